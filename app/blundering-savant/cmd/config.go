@@ -1,38 +1,197 @@
 package cmd
 
 import (
+	"fmt"
 	"log"
 	"os"
+	"strconv"
+	"strings"
 	"time"
+
+	"github.com/cchalm/blundering-savant/internal/ai"
+	"github.com/cchalm/blundering-savant/internal/task"
+	"github.com/cchalm/blundering-savant/internal/workspace"
 )
 
 var config = Config{}
 
 type Config struct {
 	// Common config
-	SystemGithubToken      string // The token used for operations with no attribution requirements
-	BotGithubToken         string // The token used for operations that should be attributed to the AI
-	AnthropicAPIKey        string
-	ValidationWorkflowName string
+	SystemGithubToken          string // The token used for operations with no attribution requirements
+	GithubAppID                int64  // If set, operations with no attribution requirements authenticate as this GitHub App instead
+	GithubAppInstallationID    int64  // The installation of the GitHub App to authenticate as
+	GithubAppPrivateKey        string // The PEM-encoded private key of the GitHub App
+	BotGithubToken             string // The token used for operations that should be attributed to the AI
+	AnthropicAPIKey            string
+	AnthropicRequestsPerMinute int // The maximum rate of requests to Anthropic's API. Zero means no limit
+	GithubWritesPerMinute      int // The maximum rate of write requests to GitHub's API, per token. Zero means no limit
+	ValidationWorkflowName     string
+	ValidationCommand          string        // If set, validation runs this command on a local checkout instead of a workflow
+	ValidationCoverageFile     string        // If set, the coverage report that ValidationCommand writes, relative to the checkout
+	ValidationEnv              []string      // Environment variables that ValidationCommand inherits beyond the defaults
+	ValidationTimeout          time.Duration // How long to wait for a validation workflow run. Zero means no extra limit
+	LogFormat                  string        // "text" or "json"
+	LogLevel                   string        // "debug", "info", "warn", or "error"
+	MaxIterations              int           // The maximum number of AI responses to handle per task. Zero means the bot's default
+	MaxRepeatedToolCalls       int           // The number of identical tool calls in a row to allow. Zero means the bot's default
+	MaxConversationTurns       int           // The number of turns after which a conversation is summarized. Zero means the bot's default
+	MaxCostUSD                 float64       // The estimated spend per task at which the bot hands off. Zero means no limit
+	SystemPromptOverride       string        // Replaces the built-in system prompt if non-empty
+	SystemPromptAppendix       string        // Appended to the system prompt if non-empty
+	UsageFooter                bool          // Whether to append AI usage to the descriptions of new pull requests
+	Concurrency                int           // The number of tasks to work on at once. Zero means the bot's default
+	ShutdownGracePeriod        time.Duration // How long in-flight tasks may continue after an interrupt. Zero means the bot's default
+	AIFailureThreshold         int           // Consecutive AI failures that pause new tasks. Zero means the bot's default
+	AIFailureCooldown          time.Duration // How long to pause after repeated AI failures. Zero means the bot's default
+	StatsFile                  string        // If set, a record of each task is appended to this file as a line of JSON
+	DryRun                     bool          // Whether to log actions that would change GitHub instead of performing them
+	FetchURLAllowedHosts       []string
+	FetchURLMaxBytes           int64
+	MaxFileBytes               int64                  // The size limit of files the AI writes. Zero selects the bot's default
+	ProtectedPaths             task.ProtectedPaths    // Paths the AI may not write to. Nil selects the bot's default
+	MaxValidationOutputLines   int                    // The lines of validation output the AI sees. Zero selects the bot's default
+	SeedTurns                  []ai.ConversationTurn  // Example turns that start every new conversation
+	EnabledTools               []string               // If non-empty, the only tools the AI may use
+	DisabledTools              []string               // Tools the AI may not use
+	BaseSyncStrategy           workspace.SyncStrategy // How to bring new commits on the base branch into the work branch
+	CommandPrefix              string                 // Starts comment commands to the bot. Empty means the default
+	IgnoreLabel                string                 // Marks issues the bot has decided to leave alone. Empty means the default
+	CommandAllowedUsers        []string               // Users whose comment commands are obeyed. Empty means trusted repository users
+	AllowedRepos               task.RepoAllowlist     // Repositories the bot acts on in polling and webhook modes. Empty means all
 
 	// One-shot options
 	QualifiedRepoName string
 	IssueNumber       int
+	AlsoFixes         []int // Other issues that the pull request for IssueNumber should also fix
 	PRNumber          int
 
 	// Polling options
 	CheckInterval             time.Duration
 	ResumableConversationsDir string
+	RedisURL                  string        // If set, conversation histories are stored in Redis rather than on disk
+	RedisConversationTTL      time.Duration // How long Redis keeps an interrupted conversation. Zero means forever
+	MetricsAddr               string        // If set, Prometheus metrics are served at /metrics on this address
+	HealthAddr                string        // If set, /healthz and /readyz are served on this address
+	PollRepos                 []string      // If not empty, only issues in these repositories ("owner/repo") are polled
+	PollLabels                []string      // If not empty, only issues with all of these labels are polled
+	PollJitter                float64       // Fraction of CheckInterval by which to randomly vary each wait. Zero means none
+
+	// Webhook options
+	WebhookSecret   string // Secret used to verify webhook delivery signatures
+	ListenAddr      string
+	WebhookDebounce time.Duration
+
+	// Replay options
+	ReplayIssue  int    // If set, the stored conversation of this issue in QualifiedRepoName is replayed instead of a history file
+	ReplayOutput string // The file to write the rendered conversation to. Empty means stdout
+	ReplayResend bool   // Whether to resend the conversation's last message for a fresh response
+	ReplayModel  string // The model to resend to
 }
 
 func loadFromEnv(dest *string, key string) {
 	parseFromEnv(dest, key, func(v string) (string, error) { return v, nil })
 }
 
+// loadOptionalFromEnv loads a value from the environment if it is set, leaving dest unchanged otherwise
+func loadOptionalFromEnv(dest *string, key string) {
+	parseOptionalFromEnv(dest, key, func(v string) (string, error) { return v, nil })
+}
+
+// parseInt64 parses a base-10 64-bit integer
+func parseInt64(v string) (int64, error) {
+	return strconv.ParseInt(v, 10, 64)
+}
+
+// readFile reads the file at the given path, for environment variables that name files
+func readFile(path string) (string, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return string(content), nil
+}
+
+// readSeedTurns reads the conversation turns in the file at the given path. See ai.ParseSeedTurns
+func readSeedTurns(path string) ([]ai.ConversationTurn, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ai.ParseSeedTurns(content)
+}
+
+// parseList parses a comma-separated list, ignoring surrounding whitespace and empty entries
+func parseList(v string) ([]string, error) {
+	var items []string
+	for item := range strings.SplitSeq(v, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items, nil
+}
+
+// parseRepoList parses a comma-separated list of qualified repository names, e.g. "owner/repo"
+func parseRepoList(v string) ([]string, error) {
+	repos, err := parseList(v)
+	if err != nil {
+		return nil, err
+	}
+	for _, repo := range repos {
+		owner, name, ok := strings.Cut(repo, "/")
+		if !ok || owner == "" || name == "" || strings.Contains(name, "/") {
+			return nil, fmt.Errorf("'%s' is not of the form owner/repo", repo)
+		}
+	}
+	return repos, nil
+}
+
+// parseRepoAllowlist parses a comma-separated list of repository patterns, e.g. "myorg/*"
+func parseRepoAllowlist(v string) (task.RepoAllowlist, error) {
+	patterns, err := parseList(v)
+	if err != nil {
+		return nil, err
+	}
+	return task.NewRepoAllowlist(patterns)
+}
+
+// parseProtectedPaths parses a comma-separated list of path patterns, e.g. "**/vendor/**"
+func parseProtectedPaths(v string) (task.ProtectedPaths, error) {
+	patterns, err := parseList(v)
+	if err != nil {
+		return nil, err
+	}
+	return task.NewProtectedPaths(patterns)
+}
+
+// maxPollJitter is the largest fraction of the check interval by which waits may be varied. Beyond it, waits could
+// shrink to almost nothing, so that a bot searches GitHub in quick bursts
+const maxPollJitter = 0.5
+
+// parsePollJitter parses a fraction of the check interval between 0 and maxPollJitter, inclusive
+func parsePollJitter(v string) (float64, error) {
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		return 0, err
+	}
+	if f < 0 || f > maxPollJitter {
+		return 0, fmt.Errorf("%v is not between 0 and %v", f, maxPollJitter)
+	}
+	return f, nil
+}
+
 func parseFromEnv[T any](dest *T, key string, parseFn func(string) (T, error)) {
+	if os.Getenv(key) == "" {
+		log.Fatalf("%s not set", key)
+	}
+	parseOptionalFromEnv(dest, key, parseFn)
+}
+
+// parseOptionalFromEnv parses a value from the environment if it is set, leaving dest unchanged otherwise
+func parseOptionalFromEnv[T any](dest *T, key string, parseFn func(string) (T, error)) {
 	str := os.Getenv(key)
 	if str == "" {
-		log.Fatalf("%s not set", key)
+		return
 	}
 	v, err := parseFn(str)
 	if err != nil {
@@ -40,3 +199,8 @@ func parseFromEnv[T any](dest *T, key string, parseFn func(string) (T, error)) {
 	}
 	*dest = v
 }
+
+// commandConfig returns the settings for comment commands
+func (c Config) commandConfig() task.CommandConfig {
+	return task.CommandConfig{Prefix: c.CommandPrefix, AllowedUsers: c.CommandAllowedUsers, IgnoreLabel: c.IgnoreLabel}
+}
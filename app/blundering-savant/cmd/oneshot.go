@@ -9,6 +9,8 @@ import (
 	"github.com/cchalm/blundering-savant/internal/ai"
 	"github.com/cchalm/blundering-savant/internal/bot"
 	"github.com/cchalm/blundering-savant/internal/task"
+	"github.com/cchalm/blundering-savant/internal/vcs"
+	"github.com/cchalm/blundering-savant/internal/workspace"
 	"github.com/spf13/cobra"
 )
 
@@ -29,11 +31,13 @@ func loadOneShotConfig(cmd *cobra.Command, args []string) {
 func init() {
 	oneShotCmd.Flags().StringVar(&config.QualifiedRepoName, "repo", "", "Repository name in the format 'owner/repo'")
 	oneShotCmd.Flags().IntVar(&config.IssueNumber, "issue-number", 0, "Issue number to process")
+	oneShotCmd.Flags().IntSliceVar(&config.AlsoFixes, "also-fixes", nil, "Other issue numbers that the pull request should also fix")
 	oneShotCmd.Flags().IntVar(&config.PRNumber, "pr-number", 0, "Pull request number to process")
 
 	_ = oneShotCmd.MarkFlagRequired("repo")
 	oneShotCmd.MarkFlagsOneRequired("issue-number", "pr-number")
 	oneShotCmd.MarkFlagsMutuallyExclusive("issue-number", "pr-number")
+	oneShotCmd.MarkFlagsMutuallyExclusive("also-fixes", "pr-number")
 
 	rootCmd.AddCommand(oneShotCmd)
 }
@@ -41,6 +45,11 @@ func init() {
 func runTaskMode(cmd *cobra.Command, args []string) error {
 	ctx := setupContext()
 
+	logger, err := createLogger()
+	if err != nil {
+		return err
+	}
+
 	log.Printf("Starting Blundering Savant in TASK mode")
 	log.Printf("Repository: %s", config.QualifiedRepoName)
 
@@ -51,17 +60,19 @@ func runTaskMode(cmd *cobra.Command, args []string) error {
 	}
 	owner, repo := parts[0], parts[1]
 
-	// Resolve issue number from either direct issue flag or PR number
+	// Resolve issue numbers from either direct issue flags or PR number
 	var issueNumber int
+	var additionalIssues []int
 	if config.IssueNumber != 0 {
 		issueNumber = config.IssueNumber
+		additionalIssues = config.AlsoFixes
 	} else if config.PRNumber != 0 {
-		// Fetch PR branch name from GitHub and parse issue number
-		var err error
-		issueNumber, err = getIssueNumberFromPR(ctx, owner, repo, config.PRNumber)
+		// Fetch PR branch name from GitHub and parse issue numbers
+		issueNumbers, err := getIssueNumbersFromPR(ctx, owner, repo, config.PRNumber)
 		if err != nil {
 			return fmt.Errorf("failed to resolve issue number from PR #%d: %w", config.PRNumber, err)
 		}
+		issueNumber, additionalIssues = issueNumbers[0], issueNumbers[1:]
 		log.Printf("Resolved PR #%d to issue #%d", config.PRNumber, issueNumber)
 	} else {
 		return fmt.Errorf("issue number and PR number are both nil")
@@ -70,9 +81,12 @@ func runTaskMode(cmd *cobra.Command, args []string) error {
 	log.Printf("Processing issue #%d", issueNumber)
 
 	// Create clients
-	systemGithubClient := createGithubClient(ctx, config.SystemGithubToken)
+	systemGithubClient, err := createSystemGithubClient(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to create system GitHub client: %w", err)
+	}
 	botGithubClient := createGithubClient(ctx, config.BotGithubToken)
-	anthropicClient := createAnthropicClient(config.AnthropicAPIKey)
+	anthropicClient := createAnthropicClient(config.AnthropicAPIKey, config.AnthropicRequestsPerMinute)
 
 	sender := ai.NewStreamingMessageSender(anthropicClient)
 
@@ -86,22 +100,49 @@ func runTaskMode(cmd *cobra.Command, args []string) error {
 	workspaceFactory := &remoteValidationWorkspaceFactory{
 		githubClient:           botGithubClient,
 		validationWorkflowName: config.ValidationWorkflowName,
+		validationCommand:      config.ValidationCommand,
+		coverageFile:           config.ValidationCoverageFile,
+		cloneToken:             config.BotGithubToken,
+		validationEnv:          config.ValidationEnv,
+		validationTimeout:      config.ValidationTimeout,
+		syncStrategy:           config.BaseSyncStrategy,
+		dryRun:                 config.DryRun,
+		validationCache:        workspace.NewValidationCache(),
 	}
 
 	// Create bot (no conversation history in task mode)
-	b := bot.New(botGithubClient, botUser, sender, nil, workspaceFactory)
+	b := bot.New(botGithubClient, botUser, sender, nil, workspaceFactory, bot.Config{
+		Logger:               logger,
+		MaxIterations:        config.MaxIterations,
+		MaxRepeatedToolCalls: config.MaxRepeatedToolCalls,
+		MaxConversationTurns: config.MaxConversationTurns,
+		MaxCostUSD:           config.MaxCostUSD,
+		SystemPromptOverride: config.SystemPromptOverride,
+		SystemPromptAppendix: config.SystemPromptAppendix,
+		SeedTurns:            config.SeedTurns,
+		Stats:                createStatsSink(),
+		UsageFooter:          config.UsageFooter,
+		DryRun:               config.DryRun,
+
+		FetchURLAllowedHosts:     config.FetchURLAllowedHosts,
+		FetchURLMaxBytes:         config.FetchURLMaxBytes,
+		MaxFileBytes:             config.MaxFileBytes,
+		ProtectedPaths:           config.ProtectedPaths,
+		MaxValidationOutputLines: config.MaxValidationOutputLines,
+		Tools:                    bot.ToolFilter{Enabled: config.EnabledTools, Disabled: config.DisabledTools},
+	})
 
 	// Build task
-	taskBuilder := task.NewBuilder(systemGithubClient, botUser)
-	tsk, err := taskBuilder.BuildTask(ctx, owner, repo, issueNumber)
+	taskBuilder := task.NewBuilder(vcs.NewGithubProvider(systemGithubClient), botUser, config.commandConfig())
+	tsk, err := taskBuilder.BuildTask(ctx, owner, repo, issueNumber, additionalIssues...)
 	if err != nil {
 		return fmt.Errorf("failed to build task for issue %d: %w", issueNumber, err)
 	}
 
 	// Process if needed
-	if taskBuilder.NeedsAttention(*tsk) {
-		log.Printf("Issue #%d requires attention, processing...", issueNumber)
-		if err := b.DoTask(ctx, *tsk); err != nil {
+	if reason, ok := taskBuilder.NeedsAttention(*tsk); ok {
+		log.Printf("Issue #%d requires attention (%s), processing...", issueNumber, reason)
+		if _, err := b.DoTask(ctx, *tsk); err != nil {
 			return fmt.Errorf("bot encountered an error: %w", err)
 		}
 		log.Printf("Successfully processed issue #%d", issueNumber)
@@ -112,23 +153,21 @@ func runTaskMode(cmd *cobra.Command, args []string) error {
 	return nil
 }
 
-func getIssueNumberFromPR(ctx context.Context, owner, repo string, prNumber int) (int, error) {
+// getIssueNumbersFromPR returns the numbers of the issues that the given pull request fixes, starting with the one that
+// it was opened for
+func getIssueNumbersFromPR(ctx context.Context, owner, repo string, prNumber int) ([]int, error) {
 	// Create a GitHub client to fetch PR details
-	githubClient := createGithubClient(ctx, config.SystemGithubToken)
-
-	// Fetch the pull request
-	pr, _, err := githubClient.PullRequests.Get(ctx, owner, repo, prNumber)
+	githubClient, err := createSystemGithubClient(ctx)
 	if err != nil {
-		return 0, fmt.Errorf("failed to fetch PR #%d: %w", prNumber, err)
+		return nil, fmt.Errorf("failed to create system GitHub client: %w", err)
 	}
 
-	// Parse issue number from branch name
-	branchName := pr.Head.GetRef()
-	var issueNumber int
-	_, err = fmt.Sscanf(branchName, "fix/issue-%d", &issueNumber)
+	// Fetch the pull request
+	pr, _, err := githubClient.PullRequests.Get(ctx, owner, repo, prNumber)
 	if err != nil {
-		return 0, fmt.Errorf("failed to parse issue number from PR branch '%s': %w", branchName, err)
+		return nil, fmt.Errorf("failed to fetch PR #%d: %w", prNumber, err)
 	}
 
-	return issueNumber, nil
+	// Parse issue numbers from branch name
+	return task.IssueNumbersFromSourceBranch(pr.Head.GetRef())
 }
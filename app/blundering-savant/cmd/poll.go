@@ -1,13 +1,20 @@
 package cmd
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log"
+	"net/http"
 	"time"
 
+	"github.com/anthropics/anthropic-sdk-go"
 	"github.com/cchalm/blundering-savant/internal/ai"
 	"github.com/cchalm/blundering-savant/internal/bot"
+	"github.com/cchalm/blundering-savant/internal/health"
+	"github.com/cchalm/blundering-savant/internal/metrics"
 	"github.com/cchalm/blundering-savant/internal/task"
+	"github.com/cchalm/blundering-savant/internal/workspace"
 	"github.com/spf13/cobra"
 )
 
@@ -24,7 +31,15 @@ func loadPollConfig(cmd *cobra.Command, args []string) {
 	cmd.Parent().PreRun(cmd.Parent(), args)
 
 	parseFromEnv(&config.CheckInterval, "CHECK_INTERVAL", time.ParseDuration)
-	loadFromEnv(&config.ResumableConversationsDir, "RESUMABLE_CONVERSATIONS_DIR")
+	loadHistoryStoreConfig()
+	if config.RedisURL == "" {
+		loadFromEnv(&config.ResumableConversationsDir, "RESUMABLE_CONVERSATIONS_DIR")
+	}
+	loadOptionalFromEnv(&config.MetricsAddr, "METRICS_ADDR")
+	loadOptionalFromEnv(&config.HealthAddr, "HEALTH_ADDR")
+	parseOptionalFromEnv(&config.PollRepos, "POLL_REPOS", parseRepoList)
+	parseOptionalFromEnv(&config.PollLabels, "POLL_LABELS", parseList)
+	parseOptionalFromEnv(&config.PollJitter, "POLL_JITTER", parsePollJitter)
 }
 
 func init() {
@@ -34,16 +49,26 @@ func init() {
 func runPollMode(cmd *cobra.Command, args []string) error {
 	ctx := setupContext()
 
+	logger, err := createLogger()
+	if err != nil {
+		return err
+	}
+
 	log.Printf("Starting Blundering Savant in POLL mode")
 	log.Printf("Check interval: %s", config.CheckInterval)
-	if config.ResumableConversationsDir != "" {
+	if config.RedisURL != "" {
+		log.Printf("Resumable conversations stored in Redis")
+	} else if config.ResumableConversationsDir != "" {
 		log.Printf("Resumable conversations directory: %s", config.ResumableConversationsDir)
 	}
 
 	// Create clients
-	systemGithubClient := createGithubClient(ctx, config.SystemGithubToken)
+	systemGithubClient, err := createSystemGithubClient(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to create system GitHub client: %w", err)
+	}
 	botGithubClient := createGithubClient(ctx, config.BotGithubToken)
-	anthropicClient := createAnthropicClient(config.AnthropicAPIKey)
+	anthropicClient := createAnthropicClient(config.AnthropicAPIKey, config.AnthropicRequestsPerMinute)
 
 	sender := ai.NewStreamingMessageSender(anthropicClient)
 
@@ -54,20 +79,95 @@ func runPollMode(cmd *cobra.Command, args []string) error {
 	}
 
 	// Setup conversation history store
-	var historyStore bot.ConversationHistoryStore
-	if config.ResumableConversationsDir != "" {
-		historyStore = ai.NewFileSystemConversationHistoryStore(config.ResumableConversationsDir)
+	historyStore, err := createHistoryStore()
+	if err != nil {
+		return err
 	}
 
 	// Create workspace factory
 	workspaceFactory := &remoteValidationWorkspaceFactory{
 		githubClient:           botGithubClient,
 		validationWorkflowName: config.ValidationWorkflowName,
+		validationCommand:      config.ValidationCommand,
+		coverageFile:           config.ValidationCoverageFile,
+		cloneToken:             config.BotGithubToken,
+		validationEnv:          config.ValidationEnv,
+		validationTimeout:      config.ValidationTimeout,
+		syncStrategy:           config.BaseSyncStrategy,
+		dryRun:                 config.DryRun,
+		validationCache:        workspace.NewValidationCache(),
+	}
+
+	// Metrics and health checks may share an address, in which case they're served by the same server
+	muxes := map[string]*http.ServeMux{}
+	muxFor := func(addr string) *http.ServeMux {
+		if muxes[addr] == nil {
+			muxes[addr] = http.NewServeMux()
+		}
+		return muxes[addr]
+	}
+
+	var m metrics.Metrics = metrics.Noop{}
+	if config.MetricsAddr != "" {
+		prom := metrics.NewPrometheus()
+		muxFor(config.MetricsAddr).Handle("/metrics", prom.Handler())
+		m = prom
+		log.Printf("Serving metrics at %s/metrics", config.MetricsAddr)
 	}
 
 	// Create task generator and bot
-	taskGen := task.NewGenerator(systemGithubClient, githubUser, config.CheckInterval)
-	b := bot.New(botGithubClient, githubUser, sender, historyStore, workspaceFactory)
+	issueFilter := task.IssueFilter{Repos: config.PollRepos, Labels: config.PollLabels}
+	taskGen := task.NewGenerator(systemGithubClient, githubUser, config.CheckInterval, issueFilter, config.commandConfig())
+	taskGen.SetJitter(config.PollJitter)
+	taskGen.SetRepoAllowlist(config.AllowedRepos)
+
+	if config.HealthAddr != "" {
+		checker := health.NewChecker(config.CheckInterval, map[string]health.Probe{
+			"GitHub": func(ctx context.Context) error {
+				_, _, err := systemGithubClient.RateLimit.Get(ctx)
+				return err
+			},
+			"Anthropic": func(ctx context.Context) error {
+				_, err := anthropicClient.Models.List(ctx, anthropic.ModelListParams{Limit: anthropic.Int(1)})
+				return err
+			},
+		})
+		taskGen.OnPoll(checker.RecordPoll)
+		handler := checker.Handler()
+		mux := muxFor(config.HealthAddr)
+		mux.Handle("/healthz", handler)
+		mux.Handle("/readyz", handler)
+		log.Printf("Serving health checks at %s/healthz and %[1]s/readyz", config.HealthAddr)
+	}
+
+	for addr, mux := range muxes {
+		serveHTTP(ctx, addr, mux)
+	}
+	b := bot.New(botGithubClient, githubUser, sender, historyStore, workspaceFactory, bot.Config{
+		Logger:               logger,
+		Metrics:              m,
+		MaxIterations:        config.MaxIterations,
+		MaxRepeatedToolCalls: config.MaxRepeatedToolCalls,
+		MaxConversationTurns: config.MaxConversationTurns,
+		MaxCostUSD:           config.MaxCostUSD,
+		SystemPromptOverride: config.SystemPromptOverride,
+		SystemPromptAppendix: config.SystemPromptAppendix,
+		SeedTurns:            config.SeedTurns,
+		Stats:                createStatsSink(),
+		UsageFooter:          config.UsageFooter,
+		Concurrency:          config.Concurrency,
+		ShutdownGracePeriod:  config.ShutdownGracePeriod,
+		AIFailureThreshold:   config.AIFailureThreshold,
+		AIFailureCooldown:    config.AIFailureCooldown,
+		DryRun:               config.DryRun,
+
+		FetchURLAllowedHosts:     config.FetchURLAllowedHosts,
+		FetchURLMaxBytes:         config.FetchURLMaxBytes,
+		MaxFileBytes:             config.MaxFileBytes,
+		ProtectedPaths:           config.ProtectedPaths,
+		MaxValidationOutputLines: config.MaxValidationOutputLines,
+		Tools:                    bot.ToolFilter{Enabled: config.EnabledTools, Disabled: config.DisabledTools},
+	})
 
 	log.Printf("Bot started. Monitoring issues for @%s every %s", *githubUser.Login, config.CheckInterval)
 
@@ -77,3 +177,24 @@ func runPollMode(cmd *cobra.Command, args []string) error {
 	// Start the bot (blocking)
 	return b.Run(ctx, tasks)
 }
+
+// serveHTTP serves the given handler on addr until ctx is cancelled
+func serveHTTP(ctx context.Context, addr string, handler http.Handler) {
+	server := &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	go func() {
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatalf("HTTP server on %s failed: %v", addr, err)
+		}
+	}()
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = server.Shutdown(shutdownCtx)
+	}()
+}
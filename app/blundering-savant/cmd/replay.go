@@ -0,0 +1,144 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/anthropics/anthropic-sdk-go"
+	"github.com/cchalm/blundering-savant/internal/ai"
+	"github.com/cchalm/blundering-savant/internal/bot"
+	"github.com/cchalm/blundering-savant/internal/task"
+	"github.com/joho/godotenv"
+	"github.com/spf13/cobra"
+)
+
+var replayCmd = &cobra.Command{
+	Use:   "replay [history-file]",
+	Short: "Render a stored conversation, optionally resending it for a fresh response",
+	Long: `Loads a stored conversation history, either from a JSON file or, with --issue, from the configured
+conversation store, in which case --repo names the issue's repository, and renders it as markdown. With --resend, the conversation's last message is first
+sent to the AI again, so that a bad response can be reproduced or compared. Nothing is read from or written
+to GitHub, and tool uses in the fresh response are not run.`,
+	Args:   cobra.MaximumNArgs(1),
+	PreRun: loadReplayConfig,
+	RunE:   runReplayMode,
+}
+
+func loadReplayConfig(cmd *cobra.Command, args []string) {
+	// Unlike the other modes, replaying doesn't need the GitHub settings, so don't call the parent
+	err := godotenv.Load()
+	if err != nil {
+		log.Println("No .env file found, using environment variables")
+	}
+
+	if config.ReplayIssue != 0 {
+		loadHistoryStoreConfig()
+		if config.RedisURL == "" {
+			loadFromEnv(&config.ResumableConversationsDir, "RESUMABLE_CONVERSATIONS_DIR")
+		}
+	}
+	if config.ReplayResend {
+		loadFromEnv(&config.AnthropicAPIKey, "ANTHROPIC_API_KEY")
+		parseOptionalFromEnv(&config.AnthropicRequestsPerMinute, "ANTHROPIC_REQUESTS_PER_MINUTE", strconv.Atoi)
+		parseOptionalFromEnv(&config.EnabledTools, "ENABLED_TOOLS", parseList)
+		parseOptionalFromEnv(&config.DisabledTools, "DISABLED_TOOLS", parseList)
+	}
+}
+
+func init() {
+	replayCmd.Flags().IntVar(&config.ReplayIssue, "issue", 0, "Replay the stored conversation of this issue number instead of a history file")
+	replayCmd.Flags().StringVar(&config.QualifiedRepoName, "repo", "", "Repository of the issue given by --issue, in the format 'owner/repo'")
+	replayCmd.Flags().StringVarP(&config.ReplayOutput, "output", "o", "", "File to write the rendered conversation to (default stdout)")
+	replayCmd.Flags().BoolVar(&config.ReplayResend, "resend", false, "Resend the conversation's last message to the AI for a fresh response")
+	replayCmd.Flags().StringVar(&config.ReplayModel, "model", string(anthropic.ModelClaudeSonnet4_5), "Model to resend to")
+
+	rootCmd.AddCommand(replayCmd)
+}
+
+func runReplayMode(cmd *cobra.Command, args []string) error {
+	ctx := setupContext()
+
+	if (len(args) == 1) == (config.ReplayIssue != 0) {
+		return fmt.Errorf("specify either a history file or --issue")
+	}
+	if (config.ReplayIssue != 0) != (config.QualifiedRepoName != "") {
+		return fmt.Errorf("specify --repo along with --issue, and only then")
+	}
+
+	history, err := loadReplayHistory(args)
+	if err != nil {
+		return err
+	}
+
+	var sender ai.MessageSender
+	var tools []anthropic.ToolParam
+	if config.ReplayResend {
+		anthropicClient := createAnthropicClient(config.AnthropicAPIKey, config.AnthropicRequestsPerMinute)
+		sender = ai.NewStreamingMessageSender(anthropicClient)
+		// The AI's response depends on the tools it is offered, so offer the same ones the bot would
+		tools = bot.NewToolRegistry(bot.ToolFilter{Enabled: config.EnabledTools, Disabled: config.DisabledTools}).GetAllToolParams()
+	}
+
+	conv, err := ai.ResumeConversation(sender, *history, anthropic.Model(config.ReplayModel), 64000, tools)
+	if err != nil {
+		return fmt.Errorf("failed to resume conversation: %w", err)
+	}
+
+	if config.ReplayResend {
+		conv.SetOutputFilter(bot.NewOutputFilter())
+		log.Printf("Resending the last of %d turns to %s", len(conv.Turns), config.ReplayModel)
+		response, err := conv.ResendLastMessage(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to resend last message: %w", err)
+		}
+		log.Printf("Received a fresh response (stop reason: %s)", response.StopReason)
+	}
+
+	md, err := conv.ToMarkdown()
+	if err != nil {
+		return fmt.Errorf("failed to render conversation: %w", err)
+	}
+
+	if config.ReplayOutput == "" {
+		_, err = fmt.Print(md)
+		return err
+	}
+	if err := os.WriteFile(config.ReplayOutput, []byte(md), 0644); err != nil {
+		return fmt.Errorf("failed to write rendered conversation: %w", err)
+	}
+	log.Printf("Wrote rendered conversation to %s", config.ReplayOutput)
+	return nil
+}
+
+// loadReplayHistory loads the history to replay from the file named by args, or from the configured store
+func loadReplayHistory(args []string) (*ai.ConversationHistory, error) {
+	if len(args) == 1 {
+		history, err := ai.LoadConversationHistory(args[0])
+		if err != nil {
+			return nil, fmt.Errorf("failed to load conversation history: %w", err)
+		}
+		return history, nil
+	}
+
+	store, err := createHistoryStore()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create history store: %w", err)
+	}
+	owner, repo, ok := strings.Cut(config.QualifiedRepoName, "/")
+	if !ok || owner == "" || repo == "" || strings.Contains(repo, "/") {
+		return nil, fmt.Errorf("invalid repository format '%s', expected owner/repo", config.QualifiedRepoName)
+	}
+	// Look the history up under the same key that the bot stores it under
+	issue := task.GithubIssue{Owner: owner, Repo: repo, Number: config.ReplayIssue}
+	history, err := store.Get(bot.HistoryKey(issue))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get conversation history: %w", err)
+	}
+	if history == nil {
+		return nil, fmt.Errorf("no conversation is stored for issue #%d in %s", config.ReplayIssue, config.QualifiedRepoName)
+	}
+	return history, nil
+}
@@ -2,7 +2,10 @@ package cmd
 
 import (
 	"log"
+	"strconv"
+	"time"
 
+	"github.com/cchalm/blundering-savant/internal/workspace"
 	"github.com/joho/godotenv"
 	"github.com/spf13/cobra"
 )
@@ -27,10 +30,52 @@ func loadRootConfig(_ *cobra.Command, _ []string) {
 		log.Println("No .env file found, using environment variables")
 	}
 
-	loadFromEnv(&config.SystemGithubToken, "SYSTEM_GITHUB_TOKEN")
+	parseOptionalFromEnv(&config.GithubAppID, "GITHUB_APP_ID", parseInt64)
+	if config.GithubAppID != 0 {
+		parseFromEnv(&config.GithubAppInstallationID, "GITHUB_APP_INSTALLATION_ID", parseInt64)
+		parseFromEnv(&config.GithubAppPrivateKey, "GITHUB_APP_PRIVATE_KEY_FILE", readFile)
+	} else {
+		loadFromEnv(&config.SystemGithubToken, "SYSTEM_GITHUB_TOKEN")
+	}
 	loadFromEnv(&config.BotGithubToken, "BOT_GITHUB_TOKEN")
 	loadFromEnv(&config.AnthropicAPIKey, "ANTHROPIC_API_KEY")
+	parseOptionalFromEnv(&config.AnthropicRequestsPerMinute, "ANTHROPIC_REQUESTS_PER_MINUTE", strconv.Atoi)
+	parseOptionalFromEnv(&config.GithubWritesPerMinute, "GITHUB_WRITES_PER_MINUTE", strconv.Atoi)
 	loadFromEnv(&config.ValidationWorkflowName, "VALIDATION_WORKFLOW_NAME")
+	loadOptionalFromEnv(&config.ValidationCommand, "VALIDATION_COMMAND")
+	loadOptionalFromEnv(&config.ValidationCoverageFile, "VALIDATION_COVERAGE_FILE")
+	parseOptionalFromEnv(&config.ValidationEnv, "VALIDATION_ENV", parseList)
+	parseOptionalFromEnv(&config.ValidationTimeout, "VALIDATION_TIMEOUT", time.ParseDuration)
+	parseOptionalFromEnv(&config.BaseSyncStrategy, "BASE_SYNC_STRATEGY", workspace.ParseSyncStrategy)
+	loadOptionalFromEnv(&config.LogFormat, "LOG_FORMAT")
+	loadOptionalFromEnv(&config.LogLevel, "LOG_LEVEL")
+	parseOptionalFromEnv(&config.MaxIterations, "MAX_ITERATIONS", strconv.Atoi)
+	parseOptionalFromEnv(&config.MaxRepeatedToolCalls, "MAX_REPEATED_TOOL_CALLS", strconv.Atoi)
+	parseOptionalFromEnv(&config.MaxConversationTurns, "MAX_CONVERSATION_TURNS", strconv.Atoi)
+	parseOptionalFromEnv(&config.MaxCostUSD, "MAX_COST_USD", func(v string) (float64, error) {
+		return strconv.ParseFloat(v, 64)
+	})
+	parseOptionalFromEnv(&config.SystemPromptOverride, "SYSTEM_PROMPT_FILE", readFile)
+	parseOptionalFromEnv(&config.SystemPromptAppendix, "SYSTEM_PROMPT_APPENDIX_FILE", readFile)
+	parseOptionalFromEnv(&config.SeedTurns, "SEED_CONVERSATION_FILE", readSeedTurns)
+	parseOptionalFromEnv(&config.UsageFooter, "USAGE_FOOTER", strconv.ParseBool)
+	parseOptionalFromEnv(&config.Concurrency, "CONCURRENCY", strconv.Atoi)
+	parseOptionalFromEnv(&config.ShutdownGracePeriod, "SHUTDOWN_GRACE_PERIOD", time.ParseDuration)
+	parseOptionalFromEnv(&config.AIFailureThreshold, "AI_FAILURE_THRESHOLD", strconv.Atoi)
+	parseOptionalFromEnv(&config.AIFailureCooldown, "AI_FAILURE_COOLDOWN", time.ParseDuration)
+	loadOptionalFromEnv(&config.StatsFile, "STATS_FILE")
+	parseOptionalFromEnv(&config.DryRun, "DRY_RUN", strconv.ParseBool)
+	parseOptionalFromEnv(&config.FetchURLAllowedHosts, "FETCH_URL_ALLOWED_HOSTS", parseList)
+	parseOptionalFromEnv(&config.FetchURLMaxBytes, "FETCH_URL_MAX_BYTES", parseInt64)
+	parseOptionalFromEnv(&config.MaxFileBytes, "MAX_FILE_BYTES", parseInt64)
+	parseOptionalFromEnv(&config.ProtectedPaths, "PROTECTED_PATHS", parseProtectedPaths)
+	parseOptionalFromEnv(&config.MaxValidationOutputLines, "MAX_VALIDATION_OUTPUT_LINES", strconv.Atoi)
+	parseOptionalFromEnv(&config.EnabledTools, "ENABLED_TOOLS", parseList)
+	parseOptionalFromEnv(&config.DisabledTools, "DISABLED_TOOLS", parseList)
+	loadOptionalFromEnv(&config.CommandPrefix, "COMMAND_PREFIX")
+	parseOptionalFromEnv(&config.CommandAllowedUsers, "COMMAND_ALLOWED_USERS", parseList)
+	parseOptionalFromEnv(&config.AllowedRepos, "ALLOWED_REPOS", parseRepoAllowlist)
+	loadOptionalFromEnv(&config.IgnoreLabel, "IGNORE_LABEL")
 }
 
 func init() {
@@ -0,0 +1,139 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/cchalm/blundering-savant/internal/ai"
+	"github.com/cchalm/blundering-savant/internal/bot"
+	"github.com/cchalm/blundering-savant/internal/task"
+	"github.com/cchalm/blundering-savant/internal/workspace"
+	"github.com/spf13/cobra"
+)
+
+var serveCmd = &cobra.Command{
+	Use:   "serve",
+	Short: "Run in webhook mode",
+	Long: `Starts the bot in long-running mode where it listens for GitHub webhook
+events (issues, issue_comment, and pull_request_review) and processes the
+affected issues as events arrive.`,
+	PreRun: loadServeConfig,
+	RunE:   runServeMode,
+}
+
+func loadServeConfig(cmd *cobra.Command, args []string) {
+	cmd.Parent().PreRun(cmd.Parent(), args)
+
+	loadFromEnv(&config.WebhookSecret, "WEBHOOK_SECRET")
+	loadHistoryStoreConfig()
+	loadOptionalFromEnv(&config.ResumableConversationsDir, "RESUMABLE_CONVERSATIONS_DIR")
+}
+
+func init() {
+	serveCmd.Flags().StringVar(&config.ListenAddr, "listen", ":8080", "Address on which to listen for webhook deliveries")
+	serveCmd.Flags().DurationVar(&config.WebhookDebounce, "debounce", 10*time.Second, "How long an issue must be quiet before it is processed")
+
+	rootCmd.AddCommand(serveCmd)
+}
+
+func runServeMode(cmd *cobra.Command, args []string) error {
+	ctx := setupContext()
+
+	logger, err := createLogger()
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Starting Blundering Savant in SERVE mode")
+	log.Printf("Listening for webhooks on %s, debounce: %s", config.ListenAddr, config.WebhookDebounce)
+
+	// Create clients
+	systemGithubClient, err := createSystemGithubClient(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to create system GitHub client: %w", err)
+	}
+	botGithubClient := createGithubClient(ctx, config.BotGithubToken)
+	anthropicClient := createAnthropicClient(config.AnthropicAPIKey, config.AnthropicRequestsPerMinute)
+
+	sender := ai.NewStreamingMessageSender(anthropicClient)
+
+	// Get bot user info
+	githubUser, _, err := botGithubClient.Users.Get(ctx, "")
+	if err != nil {
+		return fmt.Errorf("failed to get github user: %w", err)
+	}
+
+	// Setup conversation history store
+	historyStore, err := createHistoryStore()
+	if err != nil {
+		return err
+	}
+
+	// Create workspace factory
+	workspaceFactory := &remoteValidationWorkspaceFactory{
+		githubClient:           botGithubClient,
+		validationWorkflowName: config.ValidationWorkflowName,
+		validationCommand:      config.ValidationCommand,
+		coverageFile:           config.ValidationCoverageFile,
+		cloneToken:             config.BotGithubToken,
+		validationEnv:          config.ValidationEnv,
+		validationTimeout:      config.ValidationTimeout,
+		syncStrategy:           config.BaseSyncStrategy,
+		dryRun:                 config.DryRun,
+		validationCache:        workspace.NewValidationCache(),
+	}
+
+	receiver := task.NewWebhookReceiver(systemGithubClient, githubUser, config.WebhookSecret, config.WebhookDebounce, config.commandConfig())
+	receiver.SetRepoAllowlist(config.AllowedRepos)
+	b := bot.New(botGithubClient, githubUser, sender, historyStore, workspaceFactory, bot.Config{
+		Logger:               logger,
+		MaxIterations:        config.MaxIterations,
+		MaxRepeatedToolCalls: config.MaxRepeatedToolCalls,
+		MaxConversationTurns: config.MaxConversationTurns,
+		MaxCostUSD:           config.MaxCostUSD,
+		SystemPromptOverride: config.SystemPromptOverride,
+		SystemPromptAppendix: config.SystemPromptAppendix,
+		SeedTurns:            config.SeedTurns,
+		Stats:                createStatsSink(),
+		UsageFooter:          config.UsageFooter,
+		Concurrency:          config.Concurrency,
+		ShutdownGracePeriod:  config.ShutdownGracePeriod,
+		AIFailureThreshold:   config.AIFailureThreshold,
+		AIFailureCooldown:    config.AIFailureCooldown,
+		DryRun:               config.DryRun,
+
+		FetchURLAllowedHosts:     config.FetchURLAllowedHosts,
+		FetchURLMaxBytes:         config.FetchURLMaxBytes,
+		MaxFileBytes:             config.MaxFileBytes,
+		ProtectedPaths:           config.ProtectedPaths,
+		MaxValidationOutputLines: config.MaxValidationOutputLines,
+		Tools:                    bot.ToolFilter{Enabled: config.EnabledTools, Disabled: config.DisabledTools},
+	})
+
+	mux := http.NewServeMux()
+	mux.Handle("/webhook", receiver)
+	server := &http.Server{
+		Addr:              config.ListenAddr,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	go func() {
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatalf("webhook server failed: %v", err)
+		}
+	}()
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = server.Shutdown(shutdownCtx)
+	}()
+
+	// Start the bot (blocking)
+	return b.Run(ctx, receiver.Generate(ctx))
+}
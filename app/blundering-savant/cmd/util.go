@@ -2,18 +2,24 @@ package cmd
 
 import (
 	"context"
+	"fmt"
 	"log"
 	"net/http"
 	"os"
 	"os/signal"
+	"time"
 
 	"github.com/anthropics/anthropic-sdk-go"
 	"github.com/anthropics/anthropic-sdk-go/option"
+	"github.com/cchalm/blundering-savant/internal/ai"
 	"github.com/cchalm/blundering-savant/internal/bot"
+	"github.com/cchalm/blundering-savant/internal/githubapp"
+	"github.com/cchalm/blundering-savant/internal/logging"
 	"github.com/cchalm/blundering-savant/internal/task"
 	"github.com/cchalm/blundering-savant/internal/transport"
 	"github.com/cchalm/blundering-savant/internal/workspace"
 	"github.com/google/go-github/v72/github"
+	"github.com/redis/go-redis/v9"
 	"golang.org/x/oauth2"
 )
 
@@ -25,7 +31,7 @@ func setupContext() context.Context {
 	signal.Notify(interrupt, os.Interrupt)
 	go func() {
 		<-interrupt
-		log.Println("Interrupt signal detected, shutting down gracefully...")
+		log.Println("Interrupt signal detected, shutting down gracefully. Interrupt again to force shutdown...")
 		cancel()
 		<-interrupt
 		log.Fatal("Forcing shutdown")
@@ -34,22 +40,87 @@ func setupContext() context.Context {
 	return ctx
 }
 
+// createGithubClient creates a GitHub client authenticated with the given token. Its requests are rate limited
+// separately from those of clients with other tokens, since GitHub's rate limits apply per token
 func createGithubClient(ctx context.Context, token string) *github.Client {
 	tokenSource := oauth2.StaticTokenSource(
 		&oauth2.Token{AccessToken: token},
 	)
-	httpClient := oauth2.NewClient(ctx, tokenSource)
+	return createGithubClientWithTokenSource(ctx, tokenSource)
+}
+
+// createSystemGithubClient creates the GitHub client for operations with no attribution requirements. It authenticates
+// as an installation of a GitHub App if one is configured, which has higher rate limits than a personal access token,
+// and with the system token otherwise
+func createSystemGithubClient(ctx context.Context) (*github.Client, error) {
+	if config.GithubAppID == 0 {
+		return createGithubClient(ctx, config.SystemGithubToken), nil
+	}
+	tokenSource, err := githubapp.NewAppTokenSource(config.GithubAppID, config.GithubAppInstallationID,
+		[]byte(config.GithubAppPrivateKey), "https://api.github.com/", nil)
+	if err != nil {
+		return nil, err
+	}
+	return createGithubClientWithTokenSource(ctx, tokenSource), nil
+}
+
+// createGithubClientWithTokenSource creates a rate-limited GitHub client authenticated with tokens from tokenSource
+func createGithubClientWithTokenSource(ctx context.Context, tokenSource oauth2.TokenSource) *github.Client {
+	rateLimitedCtx := context.WithValue(ctx, oauth2.HTTPClient, &http.Client{
+		Transport: transport.WithGithubRateLimiting(nil, config.GithubWritesPerMinute),
+	})
+	httpClient := oauth2.NewClient(rateLimitedCtx, tokenSource)
 	return github.NewClient(httpClient)
 }
 
-func createAnthropicClient(apiKey string) anthropic.Client {
+func createLogger() (logging.Logger, error) {
+	logger, err := logging.New(os.Stderr, config.LogFormat, config.LogLevel)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create logger: %w", err)
+	}
+	return logger, nil
+}
+
+// loadHistoryStoreConfig loads the optional settings for storing conversation histories in Redis
+func loadHistoryStoreConfig() {
+	loadOptionalFromEnv(&config.RedisURL, "REDIS_URL")
+	parseOptionalFromEnv(&config.RedisConversationTTL, "REDIS_CONVERSATION_TTL", time.ParseDuration)
+}
+
+// createHistoryStore creates the store for interrupted conversations: Redis if a URL is configured, otherwise the file
+// system if a directory is configured, otherwise nil
+func createHistoryStore() (bot.ConversationHistoryStore, error) {
+	if config.RedisURL != "" {
+		opts, err := redis.ParseURL(config.RedisURL)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse redis URL: %w", err)
+		}
+		return ai.NewRedisConversationHistoryStore(redis.NewClient(opts), "blundering-savant:conversations", config.RedisConversationTTL), nil
+	}
+	if config.ResumableConversationsDir != "" {
+		return ai.NewFileSystemConversationHistoryStore(config.ResumableConversationsDir), nil
+	}
+	return nil, nil
+}
+
+// createStatsSink creates the sink for task stats: a JSONL file if one is configured, otherwise nil
+func createStatsSink() bot.StatsSink {
+	if config.StatsFile == "" {
+		return nil
+	}
+	return bot.NewJSONLStatsSink(config.StatsFile)
+}
+
+func createAnthropicClient(apiKey string, requestsPerMinute int) anthropic.Client {
 	rateLimitedHTTPClient := &http.Client{
-		Transport: transport.WithRateLimiting(nil),
+		Transport: transport.WithRateLimiting(nil, requestsPerMinute),
 	}
 	return anthropic.NewClient(
 		option.WithHTTPClient(rateLimitedHTTPClient),
 		option.WithAPIKey(apiKey),
-		option.WithMaxRetries(5),
+		// The transport already retries rate limited and overloaded requests with backoff. Retrying again on top of it
+		// would multiply the attempts made for each request
+		option.WithMaxRetries(0),
 	)
 }
 
@@ -57,8 +128,20 @@ func createAnthropicClient(apiKey string) anthropic.Client {
 type remoteValidationWorkspaceFactory struct {
 	githubClient           *github.Client
 	validationWorkflowName string
+	validationCommand      string        // If set, validates changes by running this command locally instead of a workflow
+	coverageFile           string        // If set, the coverage report that validationCommand writes
+	cloneToken             string        // Authenticates the local checkouts that validationCommand runs on
+	validationEnv          []string      // Environment variables that validationCommand inherits beyond the defaults
+	validationTimeout      time.Duration // Zero means no limit beyond the validator's own
+	dryRun                 bool          // If true, workspaces are read-only so that no branches are created
+	syncStrategy           workspace.SyncStrategy
+	validationCache        *workspace.ValidationCache // Shared by all workspaces. May be nil
 }
 
 func (rvwf *remoteValidationWorkspaceFactory) NewWorkspace(ctx context.Context, tsk task.Task) (bot.Workspace, error) {
-	return workspace.NewRemoteValidationWorkspace(ctx, rvwf.githubClient, rvwf.validationWorkflowName, tsk)
+	if rvwf.dryRun {
+		return workspace.NewReadOnlyRemoteValidationWorkspace(ctx, rvwf.githubClient, tsk)
+	}
+	return workspace.NewRemoteValidationWorkspace(ctx, rvwf.githubClient, rvwf.validationWorkflowName, rvwf.validationCommand,
+		rvwf.coverageFile, rvwf.cloneToken, rvwf.validationEnv, rvwf.validationTimeout, rvwf.validationCache, rvwf.syncStrategy, tsk)
 }
@@ -2,6 +2,7 @@ package ai
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"log"
 	"slices"
@@ -16,6 +17,11 @@ type MessageSender interface {
 
 type Conversation struct {
 	Turns []ConversationTurn
+	// seedTurns is the number of turns at the start of Turns that were seeded as examples rather than exchanged with the
+	// AI
+	seedTurns int
+	// spendStartTurn is the index of the first turn whose response counts towards Spend
+	spendStartTurn int
 
 	sender MessageSender
 
@@ -23,6 +29,9 @@ type Conversation struct {
 	systemPrompt    string
 	tools           []anthropic.ToolParam
 	maxOutputTokens int64 // Maximum number of output tokens per response
+
+	usage        *UsageTracker // May be nil
+	outputFilter OutputFilter  // May be nil
 }
 
 // ConversationTurn represents user instructions, assistant response, and resolved tool uses as a single unit
@@ -70,18 +79,114 @@ func ResumeConversation(
 		systemPrompt: history.SystemPrompt,
 		tools:        tools,
 		Turns:        history.Turns,
+		seedTurns:    history.SeedTurns,
+
+		spendStartTurn: history.SpendStartTurn,
 
 		maxOutputTokens: maxOutputTokens,
 	}
 	return c, nil
 }
 
+// Seed starts the conversation with the given turns, e.g. examples of correct tool usage for the AI to follow. The
+// turns are sent to the AI as if they had already happened. Every tool use in them must have a result. Must be called
+// before any message is sent
+func (cc *Conversation) Seed(turns []ConversationTurn) error {
+	if len(cc.Turns) > 0 {
+		return fmt.Errorf("cannot seed a conversation that has already started")
+	}
+	if err := validateSeedTurns(turns); err != nil {
+		return err
+	}
+
+	// Copy the turns deeply, since sending messages sets cache points on their blocks, and the same seed turns may be
+	// shared by concurrent conversations
+	b, err := json.Marshal(turns)
+	if err != nil {
+		return fmt.Errorf("failed to copy seed turns: %w", err)
+	}
+	var copied []ConversationTurn
+	if err := json.Unmarshal(b, &copied); err != nil {
+		return fmt.Errorf("failed to copy seed turns: %w", err)
+	}
+
+	cc.Turns = copied
+	cc.seedTurns = len(turns)
+	return nil
+}
+
+// SeedTurns returns the number of turns at the start of the conversation that were added with Seed
+func (cc *Conversation) SeedTurns() int {
+	return cc.seedTurns
+}
+
+// Spend returns the token usage of the conversation's responses, excluding those of seeded turns and those before
+// ResetSpend was last called. Unlike a UsageTracker, it covers the turns exchanged before the conversation was resumed,
+// but not conversations forked from this one
+func (cc *Conversation) Spend() *UsageTracker {
+	spend := NewUsageTracker()
+	for _, turn := range cc.Turns[max(cc.seedTurns, cc.spendStartTurn):] {
+		if turn.Response != nil {
+			spend.Add(turn.Response.Model, turn.Response.Usage)
+		}
+	}
+	return spend
+}
+
+// ResetSpend excludes the responses so far from Spend, e.g. when a task is granted a fresh budget
+func (cc *Conversation) ResetSpend() {
+	cc.spendStartTurn = len(cc.Turns)
+}
+
+// ParseSeedTurns parses turns to seed a conversation with from JSON, in the same format as the turns of a stored
+// ConversationHistory, so that turns can be copied from a real conversation
+func ParseSeedTurns(content []byte) ([]ConversationTurn, error) {
+	var turns []ConversationTurn
+	if err := json.Unmarshal(content, &turns); err != nil {
+		return nil, fmt.Errorf("failed to parse seed turns: %w", err)
+	}
+	if err := validateSeedTurns(turns); err != nil {
+		return nil, err
+	}
+	return turns, nil
+}
+
+// validateSeedTurns returns an error if the given turns can't be sent as the start of a conversation
+func validateSeedTurns(turns []ConversationTurn) error {
+	for i, turn := range turns {
+		if turn.Response == nil {
+			return fmt.Errorf("seed turn %d has no response", i)
+		}
+		uses := buildToolExchangesFromResponse(turn.Response)
+		if len(uses) != len(turn.ToolExchanges) {
+			return fmt.Errorf("seed turn %d has %d tool uses but %d tool exchanges", i, len(uses), len(turn.ToolExchanges))
+		}
+		for _, exchange := range turn.ToolExchanges {
+			if exchange.ResultBlock == nil {
+				return fmt.Errorf("seed turn %d has no result for tool use '%s' (%s)", i, exchange.UseBlock.ID, exchange.UseBlock.Name)
+			}
+		}
+	}
+	return nil
+}
+
 // SendMessage sends the last turn's tool results and optional supplemental instructions to the AI, awaits its response,
 // and adds both to the conversation as a new turn
 func (cc *Conversation) SendMessage(ctx context.Context, instructions ...anthropic.ContentBlockParamUnion) (*anthropic.Message, error) {
 	return cc.sendMessage(ctx, true, instructions...)
 }
 
+// WithCachePoint marks the given block as a prompt cache breakpoint and returns it. Pass the marked block to SendMessage
+// to cache the request prefix up to and including the block, e.g. large content at the start of the conversation that
+// every later turn repeats. Unlike the cache point that SendMessage sets automatically, the mark stays on the block for
+// the rest of the conversation. The API allows at most four cache breakpoints per request
+func WithCachePoint(block anthropic.ContentBlockParamUnion) anthropic.ContentBlockParamUnion {
+	if cacheControl := block.GetCacheControl(); cacheControl != nil {
+		*cacheControl = anthropic.NewCacheControlEphemeralParam()
+	}
+	return block
+}
+
 // ResendLastMessage erases the last turn in the conversation history and resends the user message in that turn
 func (cc *Conversation) ResendLastMessage(ctx context.Context) (*anthropic.Message, error) {
 	if len(cc.Turns) == 0 {
@@ -108,6 +213,9 @@ func (cc *Conversation) sendMessage(ctx context.Context, enableCache bool, instr
 	if err != nil {
 		return nil, fmt.Errorf("failed to convert turns to messages: %w", err)
 	}
+	if cc.outputFilter != nil {
+		messages = cc.outputFilter(messages)
+	}
 
 	// Set cache point only if caching is enabled
 	var cacheControl *anthropic.CacheControlEphemeralParam
@@ -118,6 +226,9 @@ func (cc *Conversation) sendMessage(ctx context.Context, enableCache bool, instr
 		cacheControl, err = getLastCacheControl(messages)
 		if err != nil {
 			log.Printf("Warning: failed to set cache point: %s", err)
+		} else if cacheControl.Type != "" {
+			// The block was marked with WithCachePoint. Leave it alone, so that the mark isn't removed after sending
+			cacheControl = nil
 		} else {
 			*cacheControl = anthropic.NewCacheControlEphemeralParam()
 		}
@@ -151,6 +262,10 @@ func (cc *Conversation) sendMessage(ctx context.Context, enableCache bool, instr
 		return nil, err
 	}
 
+	if cc.usage != nil {
+		cc.usage.Add(response.Model, response.Usage)
+	}
+
 	log.Printf("Token usage - Input: %d, Cache create: %d, Cache read: %d, Total: %d",
 		response.Usage.InputTokens,
 		response.Usage.CacheCreationInputTokens,
@@ -174,6 +289,12 @@ func (cc *Conversation) sendMessage(ctx context.Context, enableCache bool, instr
 	return response, nil
 }
 
+// TrackUsage records the token usage of every subsequent response in this conversation, and in conversations forked
+// from it, with the given tracker
+func (cc *Conversation) TrackUsage(tracker *UsageTracker) {
+	cc.usage = tracker
+}
+
 func (cc *Conversation) GetPendingToolUses() []anthropic.ToolUseBlock {
 	if len(cc.Turns) == 0 {
 		return nil
@@ -281,6 +402,9 @@ func getLastCacheControl(messages []anthropic.MessageParam) (*anthropic.CacheCon
 type ConversationHistory struct {
 	SystemPrompt string             `json:"systemPrompt"`
 	Turns        []ConversationTurn `json:"turns"`
+	SeedTurns    int                `json:"seedTurns,omitempty"` // See Conversation.SeedTurns
+
+	SpendStartTurn int `json:"spendStartTurn,omitempty"` // See Conversation.ResetSpend
 }
 
 // History returns a serializable conversation history
@@ -288,5 +412,8 @@ func (cc *Conversation) History() ConversationHistory {
 	return ConversationHistory{
 		SystemPrompt: cc.systemPrompt,
 		Turns:        cc.Turns,
+		SeedTurns:    cc.seedTurns,
+
+		SpendStartTurn: cc.spendStartTurn,
 	}
 }
@@ -181,6 +181,56 @@ func TestSendMessage_MultipleTurns(t *testing.T) {
 	assert.Len(t, conv.Turns, 2)
 }
 
+// cachePointSenderStub records, for each request, the text of the blocks that were marked as cache points when the
+// request was sent
+type cachePointSenderStub struct {
+	cachePoints [][]string
+}
+
+func (s *cachePointSenderStub) SendMessage(_ context.Context, params anthropic.MessageNewParams, _ ...anthropt.RequestOption) (*anthropic.Message, error) {
+	var cachePoints []string
+	for _, message := range params.Messages {
+		for _, block := range message.Content {
+			if cacheControl := block.GetCacheControl(); cacheControl != nil && cacheControl.Type != "" {
+				cachePoints = append(cachePoints, *block.GetText())
+			}
+		}
+	}
+	s.cachePoints = append(s.cachePoints, cachePoints)
+	return newAnthropicMessage(nil, anthropic.NewTextBlock("assistant response")), nil
+}
+
+func TestSendMessage_CachePoint(t *testing.T) {
+	sender := &cachePointSenderStub{}
+	conv := NewConversation(sender, anthropic.ModelClaudeSonnet4_0, 4000, nil, "system prompt")
+
+	ctx := context.Background()
+	_, err := conv.SendMessage(ctx, WithCachePoint(anthropic.NewTextBlock("repository")), anthropic.NewTextBlock("task"))
+	require.NoError(t, err)
+	_, err = conv.SendMessage(ctx, anthropic.NewTextBlock("keep going"))
+	require.NoError(t, err)
+
+	// The marked block keeps its cache point in every request, alongside the one that is set automatically
+	require.Equal(t, [][]string{{"repository", "task"}, {"repository", "task"}}, sender.cachePoints)
+
+	// Only the marked block keeps its cache point in the conversation history
+	require.NotNil(t, conv.Turns[0].Instructions[0].GetCacheControl())
+	require.Equal(t, anthropic.NewCacheControlEphemeralParam(), *conv.Turns[0].Instructions[0].GetCacheControl())
+	require.Zero(t, *conv.Turns[0].Instructions[1].GetCacheControl())
+}
+
+func TestSendMessage_CachePointOnLastBlock(t *testing.T) {
+	sender := &cachePointSenderStub{}
+	conv := NewConversation(sender, anthropic.ModelClaudeSonnet4_0, 4000, nil, "system prompt")
+
+	_, err := conv.SendMessage(context.Background(), WithCachePoint(anthropic.NewTextBlock("repository")))
+	require.NoError(t, err)
+
+	require.Equal(t, [][]string{{"repository"}}, sender.cachePoints)
+	// The automatic cache point landed on the marked block, but must not remove the mark after sending
+	require.Equal(t, anthropic.NewCacheControlEphemeralParam(), *conv.Turns[0].Instructions[0].GetCacheControl())
+}
+
 func TestSendMessage_Error(t *testing.T) {
 	expectedErr := fmt.Errorf("api error")
 	sender := &messageSenderStub{err: expectedErr}
@@ -677,3 +727,61 @@ func TestFork_IndependentCopy(t *testing.T) {
 	assert.Equal(t, "turn 1", forked.Turns[0].Instructions[0].OfText.Text)
 	assert.Equal(t, "new instruction", forked.Turns[1].Instructions[0].OfText.Text)
 }
+
+func TestSeed_SentBeforeFirstMessage(t *testing.T) {
+	sender := &messageSenderStub{response: newAnthropicMessage(t, anthropic.NewTextBlock("assistant response"))}
+	conv := NewConversation(sender, anthropic.ModelClaudeSonnet4_0, 4000, nil, "system prompt")
+
+	seed := ConversationTurn{
+		Instructions: []anthropic.ContentBlockParamUnion{anthropic.NewTextBlock("example task")},
+		Response:     newAnthropicMessage(t, anthropic.NewTextBlock("example response")),
+	}
+	require.NoError(t, conv.Seed([]ConversationTurn{seed}))
+	_, err := conv.SendMessage(context.Background(), anthropic.NewTextBlock("real task"))
+	require.NoError(t, err)
+
+	messages := sender.capturedParams.Messages
+	require.Len(t, messages, 3)
+	assert.Equal(t, "example task", messages[0].Content[0].OfText.Text)
+	assert.Equal(t, "example response", messages[1].Content[0].OfText.Text)
+	assert.Equal(t, "real task", messages[2].Content[0].OfText.Text)
+
+	assert.Equal(t, 1, conv.SeedTurns())
+	assert.Equal(t, 1, conv.History().SeedTurns)
+	resumed, err := ResumeConversation(sender, conv.History(), anthropic.ModelClaudeSonnet4_0, 4000, nil)
+	require.NoError(t, err)
+	assert.Equal(t, 1, resumed.SeedTurns())
+}
+
+func TestSeed_AfterFirstMessage(t *testing.T) {
+	sender := &messageSenderStub{response: newAnthropicMessage(t, anthropic.NewTextBlock("assistant response"))}
+	conv := NewConversation(sender, anthropic.ModelClaudeSonnet4_0, 4000, nil, "system prompt")
+	_, err := conv.SendMessage(context.Background(), anthropic.NewTextBlock("real task"))
+	require.NoError(t, err)
+
+	require.Error(t, conv.Seed([]ConversationTurn{{Response: newAnthropicMessage(t, anthropic.NewTextBlock("example"))}}))
+}
+
+func TestParseSeedTurns(t *testing.T) {
+	toolUse := anthropic.NewToolUseBlock("tool_1", map[string]any{"path": "README.md"}, "read_files")
+	turns := []ConversationTurn{{
+		Instructions: []anthropic.ContentBlockParamUnion{anthropic.NewTextBlock("example task")},
+		Response:     newAnthropicMessage(t, toolUse),
+	}}
+	turns[0].ToolExchanges = buildToolExchangesFromResponse(turns[0].Response)
+	content, err := json.Marshal(turns)
+	require.NoError(t, err)
+
+	_, err = ParseSeedTurns(content)
+	require.ErrorContains(t, err, "no result for tool use 'tool_1' (read_files)")
+
+	result := newToolResultBlockParam("tool_1", "# Example", false)
+	turns[0].ToolExchanges[0].ResultBlock = &result
+	content, err = json.Marshal(turns)
+	require.NoError(t, err)
+
+	parsed, err := ParseSeedTurns(content)
+	require.NoError(t, err)
+	require.Len(t, parsed, 1)
+	require.Equal(t, "tool_1", parsed[0].ToolExchanges[0].ResultBlock.ToolUseID)
+}
@@ -54,9 +54,15 @@ type messageTokenUsage struct {
 	CacheReadTokens     int64 `json:"cacheReadTokens"`
 }
 
-// ToMarkdown converts the ClaudeConversation to a well-organized markdown string
+// ToMarkdown renders the conversation as a markdown document: the system prompt, followed by each turn's instructions,
+// the assistant's text and thinking, and the tool uses and results, in order, and finally a token usage summary
 func (cc *Conversation) ToMarkdown() (string, error) {
-	data, err := cc.buildMarkdownData()
+	return cc.toMarkdown(time.Now())
+}
+
+// toMarkdown renders the conversation as markdown, stamped with the given generation time
+func (cc *Conversation) toMarkdown(createdAt time.Time) (string, error) {
+	data, err := cc.buildMarkdownData(createdAt)
 	if err != nil {
 		return "", fmt.Errorf("failed to build conversation data: %w", err)
 	}
@@ -65,10 +71,10 @@ func (cc *Conversation) ToMarkdown() (string, error) {
 }
 
 // buildMarkdownData converts ClaudeConversation to simplified markdown data
-func (cc *Conversation) buildMarkdownData() (*conversationMarkdownData, error) {
+func (cc *Conversation) buildMarkdownData(createdAt time.Time) (*conversationMarkdownData, error) {
 	data := &conversationMarkdownData{
 		SystemPrompt: cc.systemPrompt,
-		CreatedAt:    time.Now().Format("2006-01-02 15:04:05 MST"),
+		CreatedAt:    createdAt.Format("2006-01-02 15:04:05 MST"),
 		TokenUsage:   conversationTokenUsage{},
 	}
 
@@ -177,8 +183,75 @@ func renderConversationMarkdown(data *conversationMarkdownData) (string, error)
 				return "👍 Adding reaction"
 			case "validate_changes":
 				return "✅ Validating changes"
+			case "get_pending_responses":
+				return "📬 Checking for comments awaiting a response"
+			case "get_coverage":
+				return "🧪 Checking test coverage"
+			case "get_validation_status":
+				return "📋 Checking validation status"
+			case "run_tests":
+				var input map[string]interface{}
+				if err := json.Unmarshal([]byte(toolInput), &input); err == nil {
+					if target, ok := input["target"].(string); ok && target != "" {
+						return fmt.Sprintf("🧪 Running tests '%s'", target)
+					}
+				}
+				return "🧪 Running tests"
 			case "publish_changes_for_review":
 				return "📤 Publishing changes for review"
+			case "mark_pull_request_ready":
+				return "🚀 Marking pull request ready for review"
+			case "update_pull_request_description":
+				return "✏️ Updating pull request description"
+			case "retarget_pull_request":
+				var input map[string]interface{}
+				if err := json.Unmarshal([]byte(toolInput), &input); err == nil {
+					if base, ok := input["base"].(string); ok && base != "" {
+						return fmt.Sprintf("🎯 Retargeting pull request to '%s'", base)
+					}
+				}
+				return "🎯 Retargeting pull request"
+			case "git_log":
+				return "📜 Listing recent commits"
+			case "view_file_at_ref":
+				var input map[string]interface{}
+				if err := json.Unmarshal([]byte(toolInput), &input); err == nil {
+					path, _ := input["path"].(string)
+					ref, _ := input["ref"].(string)
+					if path != "" && ref != "" {
+						return fmt.Sprintf("👀 Reading '%s' at %s", path, ref)
+					}
+				}
+				return "👀 Reading a file at another version"
+			case "get_check_runs":
+				return "🚦 Checking CI results"
+			case "write_file":
+				var input map[string]interface{}
+				if err := json.Unmarshal([]byte(toolInput), &input); err == nil {
+					if path, ok := input["path"].(string); ok && path != "" {
+						return fmt.Sprintf("📝 Writing '%s'", path)
+					}
+				}
+				return "📝 Writing file"
+			case "apply_patch":
+				var input map[string]interface{}
+				if err := json.Unmarshal([]byte(toolInput), &input); err == nil {
+					patch, _ := input["patch"].(string)
+					for line := range strings.SplitSeq(patch, "\n") {
+						if path, ok := strings.CutPrefix(line, "+++ b/"); ok {
+							return fmt.Sprintf("🩹 Patching '%s'", strings.TrimSpace(path))
+						}
+					}
+				}
+				return "🩹 Applying patch"
+			case "find_symbol":
+				var input map[string]interface{}
+				if err := json.Unmarshal([]byte(toolInput), &input); err == nil {
+					if name, ok := input["name"].(string); ok && name != "" {
+						return fmt.Sprintf("🔎 Finding '%s'", name)
+					}
+				}
+				return "🔎 Finding symbol"
 			case "delete_file":
 				// Parse path from input for more specific summary
 				var input map[string]interface{}
@@ -188,6 +261,88 @@ func renderConversationMarkdown(data *conversationMarkdownData) (string, error)
 					}
 				}
 				return "🗑️ Deleting file"
+			case "read_multiple_files":
+				var input map[string]interface{}
+				if err := json.Unmarshal([]byte(toolInput), &input); err == nil {
+					if paths, ok := input["paths"].([]interface{}); ok {
+						return fmt.Sprintf("👀 Reading %d files", len(paths))
+					}
+				}
+				return "👀 Reading files"
+			case "list_directory_tree":
+				var input map[string]interface{}
+				if err := json.Unmarshal([]byte(toolInput), &input); err == nil {
+					if path, ok := input["path"].(string); ok && path != "" {
+						return fmt.Sprintf("🌳 Listing '%s'", path)
+					}
+				}
+				return "🌳 Listing files"
+			case "manage_labels":
+				return "🏷️ Updating labels"
+			case "list_assignees":
+				return "👥 Listing assignees"
+			case "manage_assignees":
+				return "👥 Updating assignees"
+			case "resolve_review_thread":
+				return "✅ Resolving review thread"
+			case "submit_review":
+				return "📝 Submitting review"
+			case "submit_review_with_comments":
+				return "📝 Submitting review with line comments"
+			case "request_reviewers":
+				return "👥 Requesting reviewers"
+			case "close_issue":
+				return "🔒 Closing issue"
+			case "reopen_issue":
+				return "🔓 Reopening issue"
+			case "create_issue":
+				var input map[string]interface{}
+				if err := json.Unmarshal([]byte(toolInput), &input); err == nil {
+					if title, ok := input["title"].(string); ok && title != "" {
+						return fmt.Sprintf("📝 Opening issue '%s'", title)
+					}
+				}
+				return "📝 Opening issue"
+			case "list_open_items":
+				return "📋 Listing open issues and pull requests"
+			case "get_issue_timeline":
+				return "🕰️ Viewing the issue timeline"
+			case "link_issue":
+				var input map[string]interface{}
+				if err := json.Unmarshal([]byte(toolInput), &input); err == nil {
+					if number, ok := input["number"].(float64); ok {
+						return fmt.Sprintf("🔗 Linking #%d", int(number))
+					}
+				}
+				return "🔗 Linking issue"
+			case "fetch_url":
+				var input map[string]interface{}
+				if err := json.Unmarshal([]byte(toolInput), &input); err == nil {
+					if url, ok := input["url"].(string); ok && url != "" {
+						return fmt.Sprintf("🌐 Fetching %s", url)
+					}
+				}
+				return "🌐 Fetching URL"
+			case "blame_file":
+				var input map[string]interface{}
+				if err := json.Unmarshal([]byte(toolInput), &input); err == nil {
+					if path, ok := input["path"].(string); ok && path != "" {
+						return fmt.Sprintf("🕵️ Blaming '%s'", path)
+					}
+				}
+				return "🕵️ Blaming file"
+			case "view_diff":
+				return "🔍 Viewing local changes"
+			case "view_pull_request_diff":
+				return "🔍 Viewing pull request changes"
+			case "await_human_input":
+				return "❓ Asking a human"
+			case "post_plan":
+				return "📋 Proposing a plan"
+			case "conclude":
+				return "🏁 Concluding"
+			case "ignore_issue":
+				return "🙈 Ignoring the issue"
 			case "report_limitation":
 				return "🆘 Reporting limitation"
 			default:
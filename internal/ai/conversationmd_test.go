@@ -0,0 +1,77 @@
+package ai
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/anthropics/anthropic-sdk-go"
+	"github.com/stretchr/testify/require"
+)
+
+var updateGolden = flag.Bool("update", false, "update golden files in testdata")
+
+// testToMarkdown renders the given turns and compares the result to the named golden file in testdata. Run the tests
+// with -update to rewrite the golden files after an intentional format change
+func testToMarkdown(t *testing.T, goldenName string, turns ...ConversationTurn) {
+	t.Helper()
+
+	conv := &Conversation{
+		systemPrompt: "You are a helpful bot.\nBe brief.",
+		Turns:        turns,
+	}
+	requireMarkdownGolden(t, goldenName, conv)
+}
+
+// requireMarkdownGolden renders the given conversation with a fixed generation time and compares the result to the
+// named golden file in testdata
+func requireMarkdownGolden(t *testing.T, goldenName string, conv *Conversation) {
+	t.Helper()
+
+	md, err := conv.toMarkdown(time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC))
+	require.NoError(t, err)
+
+	goldenPath := filepath.Join("testdata", goldenName)
+	if *updateGolden {
+		require.NoError(t, os.WriteFile(goldenPath, []byte(md), 0644))
+	}
+	golden, err := os.ReadFile(goldenPath)
+	require.NoError(t, err)
+	require.Equal(t, string(golden), md)
+}
+
+// toolExchangeTurn returns a turn in which the assistant views a file, as rendered in the tool_exchange.md golden file
+func toolExchangeTurn(t *testing.T) ConversationTurn {
+	response := newAnthropicMessage(t,
+		anthropic.NewTextBlock("Let me look at the file."),
+		anthropic.NewToolUseBlock("tool_1", map[string]any{"command": "view", "path": "main.go"}, "str_replace_based_edit_tool"),
+	)
+	result := newToolResultBlockParam("tool_1", "package main\n\nfunc main() {}\n", false)
+
+	return ConversationTurn{
+		Instructions: []anthropic.ContentBlockParamUnion{anthropic.NewTextBlock("Please fix the bug in main.go")},
+		Response:     response,
+		ToolExchanges: []ToolExchange{{
+			UseBlock:    response.Content[1].AsToolUse(),
+			ResultBlock: &result,
+		}},
+	}
+}
+
+func TestToMarkdown_ToolExchange(t *testing.T) {
+	testToMarkdown(t, "tool_exchange.md", toolExchangeTurn(t))
+}
+
+func TestToMarkdown_Thinking(t *testing.T) {
+	response := newAnthropicMessage(t,
+		anthropic.NewThinkingBlock("signature", "The user wants a greeting.\nA short one will do."),
+		anthropic.NewTextBlock("Hello!"),
+	)
+
+	testToMarkdown(t, "thinking.md", ConversationTurn{
+		Instructions: []anthropic.ContentBlockParamUnion{anthropic.NewTextBlock("Say hello")},
+		Response:     response,
+	})
+}
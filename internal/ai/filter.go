@@ -0,0 +1,231 @@
+package ai
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/anthropics/anthropic-sdk-go"
+)
+
+// OutputFilter transforms the messages of each request before it is sent, e.g. to shrink them. It affects only what is
+// sent, not the conversation's history. Messages share content with the history, so filters must not modify the
+// messages they are given; they must return modified copies instead
+type OutputFilter func(messages []anthropic.MessageParam) []anthropic.MessageParam
+
+// SetOutputFilter installs a filter that is applied to the messages of every subsequent request in this conversation,
+// and in conversations forked from it. A nil filter removes any installed filter
+func (cc *Conversation) SetOutputFilter(filter OutputFilter) {
+	cc.outputFilter = filter
+}
+
+// ChainOutputFilters returns a filter that applies the given filters in order
+func ChainOutputFilters(filters ...OutputFilter) OutputFilter {
+	return func(messages []anthropic.MessageParam) []anthropic.MessageParam {
+		for _, filter := range filters {
+			messages = filter(messages)
+		}
+		return messages
+	}
+}
+
+// NewToolResultTruncationFilter returns a filter that replaces the text of tool results with a short placeholder once
+// they are more than keepTurns turns old, if the text is longer than maxChars. Recent tool results, and short ones,
+// are sent in full.
+//
+// Truncating a result changes the request prefix, so the prompt cache is rewritten from that point on. Leaving short
+// results alone keeps that from happening on every turn
+func NewToolResultTruncationFilter(keepTurns int, maxChars int) OutputFilter {
+	return func(messages []anthropic.MessageParam) []anthropic.MessageParam {
+		// Each turn starts with a user message, which carries the results of the previous turn's tool uses
+		var userMessages []int
+		for i, message := range messages {
+			if message.Role == anthropic.MessageParamRoleUser {
+				userMessages = append(userMessages, i)
+			}
+		}
+		if len(userMessages) <= keepTurns {
+			return messages
+		}
+
+		filtered := make([]anthropic.MessageParam, len(messages))
+		copy(filtered, messages)
+		for _, i := range userMessages[:len(userMessages)-keepTurns] {
+			filtered[i] = truncateToolResults(messages[i], maxChars)
+		}
+		return filtered
+	}
+}
+
+// truncateToolResults returns a copy of message in which tool result text longer than maxChars is replaced with a
+// placeholder. The given message is not modified
+func truncateToolResults(message anthropic.MessageParam, maxChars int) anthropic.MessageParam {
+	content := make([]anthropic.ContentBlockParamUnion, len(message.Content))
+	for i, block := range message.Content {
+		content[i] = block
+		if block.OfToolResult == nil {
+			continue
+		}
+
+		result := *block.OfToolResult
+		result.Content = make([]anthropic.ToolResultBlockParamContentUnion, len(block.OfToolResult.Content))
+		for j, c := range block.OfToolResult.Content {
+			if c.OfText != nil && len(c.OfText.Text) > maxChars {
+				placeholder := fmt.Sprintf("[%d characters of old tool output omitted. Run the tool again if you need it]",
+					len(c.OfText.Text))
+				c = anthropic.ToolResultBlockParamContentUnion{OfText: &anthropic.TextBlockParam{Text: placeholder}}
+			}
+			result.Content[j] = c
+		}
+		content[i] = anthropic.ContentBlockParamUnion{OfToolResult: &result}
+	}
+
+	message.Content = content
+	return message
+}
+
+// textEditorToolName is the name of the text editor tool, whose view results NewSupersededViewFilter compacts
+const textEditorToolName = "str_replace_based_edit_tool"
+
+// supersededViewPlaceholder replaces the text of view results that a later view of the same path makes redundant
+const supersededViewPlaceholder = "[Superseded by a later view of this path]"
+
+// TruncatedViewNote begins the notes that the text editor tool adds to the result of a view where it left part of the
+// file out. A view of a whole path with such a note doesn't show everything that views of ranges of the path can
+const TruncatedViewNote = "[File truncated: "
+
+// viewKey identifies what a view of a file or directory shows. Views of the same path with the same range show the
+// same thing, and a view without a range shows everything any view of that path can
+type viewKey struct {
+	path      string
+	viewRange string // Empty for views of the whole path
+}
+
+// NewSupersededViewFilter returns a filter that replaces the results of text editor views with a short placeholder when
+// a later view of the same path shows at least as much, since the earlier results are stale copies of the same content.
+// Only successful views supersede earlier ones, and a view of a whole path that was truncated supersedes only other views
+// of the whole path.
+//
+// Like truncation, compacting a result changes the request prefix, so the prompt cache is rewritten from that point on
+func NewSupersededViewFilter() OutputFilter {
+	return func(messages []anthropic.MessageParam) []anthropic.MessageParam {
+		// Find what each view tool use showed, from the assistant messages that requested them
+		views := map[string]viewKey{}
+		for _, message := range messages {
+			if message.Role != anthropic.MessageParamRoleAssistant {
+				continue
+			}
+			for _, block := range message.Content {
+				if key, ok := parseViewToolUse(block.OfToolUse); ok {
+					views[block.OfToolUse.ID] = key
+				}
+			}
+		}
+		if len(views) == 0 {
+			return messages
+		}
+
+		// Walk the results from newest to oldest, so that each view is compared with the views that came after it
+		superseded := map[string]bool{}
+		laterViews := map[viewKey]bool{}
+		completeViews := map[string]bool{} // Paths with a later untruncated view of the whole path
+		for _, message := range slices.Backward(messages) {
+			if message.Role != anthropic.MessageParamRoleUser {
+				continue
+			}
+			for _, block := range slices.Backward(message.Content) {
+				result := block.OfToolResult
+				if result == nil {
+					continue
+				}
+				key, ok := views[result.ToolUseID]
+				if !ok {
+					continue
+				}
+				if laterViews[key] || completeViews[key.path] {
+					superseded[result.ToolUseID] = true
+				} else if !result.IsError.Value {
+					laterViews[key] = true
+					if key.viewRange == "" && !isTruncatedView(result) {
+						completeViews[key.path] = true
+					}
+				}
+			}
+		}
+		if len(superseded) == 0 {
+			return messages
+		}
+
+		filtered := make([]anthropic.MessageParam, len(messages))
+		for i, message := range messages {
+			filtered[i] = replaceToolResults(message, superseded, supersededViewPlaceholder)
+		}
+		return filtered
+	}
+}
+
+// isTruncatedView returns true if the given view result left part of the file out
+func isTruncatedView(result *anthropic.ToolResultBlockParam) bool {
+	for _, content := range result.Content {
+		if content.OfText != nil && strings.Contains(content.OfText.Text, TruncatedViewNote) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseViewToolUse returns what the given tool use views, if it is a text editor view
+func parseViewToolUse(block *anthropic.ToolUseBlockParam) (viewKey, bool) {
+	if block == nil || block.Name != textEditorToolName {
+		return viewKey{}, false
+	}
+
+	inputJSON, err := json.Marshal(block.Input)
+	if err != nil {
+		return viewKey{}, false
+	}
+	var input struct {
+		Command   string `json:"command"`
+		Path      string `json:"path"`
+		ViewRange []int  `json:"view_range"`
+	}
+	if err := json.Unmarshal(inputJSON, &input); err != nil || input.Command != "view" || input.Path == "" {
+		return viewKey{}, false
+	}
+
+	key := viewKey{path: path.Clean(input.Path)}
+	if len(input.ViewRange) > 0 {
+		key.viewRange = fmt.Sprint(input.ViewRange)
+	}
+	return key, true
+}
+
+// replaceToolResults returns a copy of message in which the text of the tool results for the given tool use IDs is
+// replaced with the given placeholder. The given message is not modified, and is returned as-is if it has no such
+// results
+func replaceToolResults(message anthropic.MessageParam, toolUseIDs map[string]bool, placeholder string) anthropic.MessageParam {
+	if !slices.ContainsFunc(message.Content, func(block anthropic.ContentBlockParamUnion) bool {
+		return block.OfToolResult != nil && toolUseIDs[block.OfToolResult.ToolUseID]
+	}) {
+		return message
+	}
+
+	content := make([]anthropic.ContentBlockParamUnion, len(message.Content))
+	for i, block := range message.Content {
+		content[i] = block
+		if block.OfToolResult == nil || !toolUseIDs[block.OfToolResult.ToolUseID] {
+			continue
+		}
+
+		result := *block.OfToolResult
+		result.Content = []anthropic.ToolResultBlockParamContentUnion{
+			{OfText: &anthropic.TextBlockParam{Text: placeholder}},
+		}
+		content[i] = anthropic.ContentBlockParamUnion{OfToolResult: &result}
+	}
+
+	message.Content = content
+	return message
+}
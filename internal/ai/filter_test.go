@@ -0,0 +1,143 @@
+package ai
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/anthropics/anthropic-sdk-go"
+	"github.com/stretchr/testify/require"
+)
+
+// testToolResultTruncation sends one message per tool result in the given order through a conversation with a
+// truncation filter installed, followed by a final message, and returns the tool result text of each user message in
+// the final request, in order
+func testToolResultTruncation(t *testing.T, keepTurns int, maxChars int, results []string) []string {
+	sender := &messageSenderStub{}
+	conv := NewConversation(sender, anthropic.ModelClaudeSonnet4_0, 4000, nil, "system prompt")
+	conv.SetOutputFilter(NewToolResultTruncationFilter(keepTurns, maxChars))
+
+	ctx := context.Background()
+	for i, result := range results {
+		toolID := "tool_" + string(rune('a'+i))
+		sender.response = newAnthropicMessage(t, anthropic.NewToolUseBlock(toolID, map[string]string{}, "test_tool"))
+		_, err := conv.SendMessage(ctx, anthropic.NewTextBlock("go on"))
+		require.NoError(t, err)
+		require.NoError(t, conv.AddToolResult(newToolResultBlockParam(toolID, result, false)))
+	}
+	sender.response = newAnthropicMessage(t, anthropic.NewTextBlock("done"))
+	_, err := conv.SendMessage(ctx)
+	require.NoError(t, err)
+
+	// The filter must not change the conversation's history
+	for i, result := range results {
+		require.Equal(t, result, conv.Turns[i].ToolExchanges[0].ResultBlock.Content[0].OfText.Text)
+	}
+
+	var sent []string
+	for _, message := range sender.capturedParams.Messages {
+		for _, block := range message.Content {
+			if block.OfToolResult != nil {
+				sent = append(sent, block.OfToolResult.Content[0].OfText.Text)
+			}
+		}
+	}
+	return sent
+}
+
+func TestToolResultTruncation_OldLargeResult(t *testing.T) {
+	large := strings.Repeat("x", 100)
+	sent := testToolResultTruncation(t, 1, 10, []string{large, "short", large})
+
+	require.Len(t, sent, 3)
+	require.Equal(t, "[100 characters of old tool output omitted. Run the tool again if you need it]", sent[0])
+	require.Equal(t, "short", sent[1])
+	require.Equal(t, large, sent[2])
+}
+
+func TestToolResultTruncation_AllRecent(t *testing.T) {
+	large := strings.Repeat("x", 100)
+	sent := testToolResultTruncation(t, 5, 10, []string{large, large})
+
+	require.Equal(t, []string{large, large}, sent)
+}
+
+type testView struct {
+	path      string
+	viewRange []int // May be nil
+	result    string
+}
+
+// testSupersededViews sends one message per view in the given order through a conversation with a superseded view
+// filter installed, followed by a final message, and returns the tool result text in the final request, in order
+func testSupersededViews(t *testing.T, views []testView) []string {
+	sender := &messageSenderStub{}
+	conv := NewConversation(sender, anthropic.ModelClaudeSonnet4_0, 4000, nil, "system prompt")
+	conv.SetOutputFilter(NewSupersededViewFilter())
+
+	ctx := context.Background()
+	for i, view := range views {
+		toolID := "tool_" + string(rune('a'+i))
+		input := map[string]any{"command": "view", "path": view.path}
+		if view.viewRange != nil {
+			input["view_range"] = view.viewRange
+		}
+		sender.response = newAnthropicMessage(t, anthropic.NewToolUseBlock(toolID, input, "str_replace_based_edit_tool"))
+		_, err := conv.SendMessage(ctx, anthropic.NewTextBlock("go on"))
+		require.NoError(t, err)
+		require.NoError(t, conv.AddToolResult(newToolResultBlockParam(toolID, view.result, false)))
+	}
+	sender.response = newAnthropicMessage(t, anthropic.NewTextBlock("done"))
+	_, err := conv.SendMessage(ctx)
+	require.NoError(t, err)
+
+	// The filter must not change the conversation's history
+	for i, view := range views {
+		require.Equal(t, view.result, conv.Turns[i].ToolExchanges[0].ResultBlock.Content[0].OfText.Text)
+	}
+
+	var sent []string
+	for _, message := range sender.capturedParams.Messages {
+		for _, block := range message.Content {
+			if block.OfToolResult != nil {
+				sent = append(sent, block.OfToolResult.Content[0].OfText.Text)
+			}
+		}
+	}
+	return sent
+}
+
+func TestSupersededViews_SameFile(t *testing.T) {
+	sent := testSupersededViews(t, []testView{
+		{path: "main.go", result: "v1"},
+		{path: "util.go", result: "util"},
+		{path: "main.go", result: "v2"},
+		{path: "./main.go", result: "v3"},
+	})
+
+	require.Equal(t, []string{supersededViewPlaceholder, "util", supersededViewPlaceholder, "v3"}, sent)
+}
+
+func TestSupersededViews_Ranges(t *testing.T) {
+	sent := testSupersededViews(t, []testView{
+		{path: "main.go", result: "full"},
+		{path: "main.go", viewRange: []int{1, 10}, result: "lines 1-10"},
+		{path: "main.go", viewRange: []int{20, 30}, result: "lines 20-30"},
+		{path: "main.go", viewRange: []int{1, 10}, result: "lines 1-10 again"},
+	})
+
+	// A ranged view supersedes only views of the same range, and doesn't supersede a full view
+	require.Equal(t, []string{"full", supersededViewPlaceholder, "lines 20-30", "lines 1-10 again"}, sent)
+}
+
+func TestSupersededViews_TruncatedViewSupersedesOnlyFullViews(t *testing.T) {
+	truncated := "1: a\n" + TruncatedViewNote + "showing lines 1-1 of 2]"
+	sent := testSupersededViews(t, []testView{
+		{path: "main.go", result: "earlier truncated"},
+		{path: "main.go", viewRange: []int{2, 2}, result: "line 2"},
+		{path: "main.go", result: truncated},
+	})
+
+	// The ranged view shows a line that the later view left out
+	require.Equal(t, []string{supersededViewPlaceholder, "line 2", truncated}, sent)
+}
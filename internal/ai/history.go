@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"log"
 	"os"
 	"path"
 )
@@ -19,13 +20,46 @@ func NewFileSystemConversationHistoryStore(dir string) FileSystemConversationHis
 	}
 }
 
+// tempSuffix is appended to a key's file name to get the name of the file that Set writes to before moving it into place
+const tempSuffix = ".tmp"
+
+// Get returns the history stored at the given key, or nil if there is none. If the history file is missing or
+// unreadable but an interrupted Set left a complete history in its temporary file, that history is returned instead and
+// moved into place
 func (fschv FileSystemConversationHistoryStore) Get(key string) (*ConversationHistory, error) {
 	path := path.Join(fschv.dir, key)
+	value, err := readHistoryFile(path)
+	if err == nil {
+		return value, nil
+	}
+
+	// Recover from an interrupted Set, if it got as far as writing the temporary file completely
+	tempValue, tempErr := readHistoryFile(path + tempSuffix)
+	if tempErr != nil || tempValue == nil {
+		if errors.Is(err, os.ErrNotExist) {
+			// Nothing complete is stored at this key
+			return nil, nil
+		}
+		return nil, err
+	}
+	log.Printf("Warning: recovering conversation history '%s' from an interrupted write", key)
+	if err := os.Rename(path+tempSuffix, path); err != nil {
+		return nil, fmt.Errorf("failed to move recovered history into place: %w", err)
+	}
+	return tempValue, nil
+}
+
+// LoadConversationHistory reads a conversation history from a JSON file, e.g. one written by
+// FileSystemConversationHistoryStore or copied out of another store, so that it can be inspected or replayed offline
+func LoadConversationHistory(path string) (*ConversationHistory, error) {
+	return readHistoryFile(path)
+}
+
+// readHistoryFile reads and unmarshals the history in the file at the given path. The returned error wraps
+// os.ErrNotExist if the file doesn't exist
+func readHistoryFile(path string) (*ConversationHistory, error) {
 	b, err := os.ReadFile(path)
-	if errors.Is(err, os.ErrNotExist) {
-		// The file doesn't exist so nothing is stored at this key
-		return nil, nil
-	} else if err != nil {
+	if err != nil {
 		return nil, fmt.Errorf("failed to read file: %w", err)
 	}
 	var value ConversationHistory
@@ -36,24 +70,52 @@ func (fschv FileSystemConversationHistoryStore) Get(key string) (*ConversationHi
 	return &value, nil
 }
 
+// Set stores the given history at the given key. The history is written to a temporary file that then replaces any
+// previous history in a single rename, so that a process killed mid-write leaves the previous history intact
 func (fschv FileSystemConversationHistoryStore) Set(key string, value ConversationHistory) error {
 	b, err := json.Marshal(value)
 	if err != nil {
 		return fmt.Errorf("failed to marshal conversation history: %w", err)
 	}
 	path := path.Join(fschv.dir, key)
-	err = os.WriteFile(path, b, 0666)
+	err = writeFileSynced(path+tempSuffix, b)
 	if err != nil {
 		return fmt.Errorf("failed to write file: %w", err)
 	}
+	err = os.Rename(path+tempSuffix, path)
+	if err != nil {
+		return fmt.Errorf("failed to move file into place: %w", err)
+	}
 	return nil
 }
 
+// writeFileSynced writes data to the named file, creating or truncating it, and flushes it to disk before returning, so
+// that the file is complete before it is renamed
+func writeFileSynced(name string, data []byte) error {
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0666)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
 func (fschv FileSystemConversationHistoryStore) Delete(key string) error {
 	path := path.Join(fschv.dir, key)
 	err := os.Remove(path)
 	if err != nil {
 		return fmt.Errorf("failed to delete file: %w", err)
 	}
+	// Also remove any leftovers of an interrupted Set, so that Get doesn't recover them
+	err = os.Remove(path + tempSuffix)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to delete temporary file: %w", err)
+	}
 	return nil
 }
@@ -0,0 +1,99 @@
+package ai
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileSystemConversationHistoryStore_SetGetDelete(t *testing.T) {
+	dir := t.TempDir()
+	store := NewFileSystemConversationHistoryStore(dir)
+
+	history := testHistory()
+	require.NoError(t, store.Set("42", history))
+	require.NoFileExists(t, filepath.Join(dir, "42"+tempSuffix))
+
+	got, err := store.Get("42")
+	require.NoError(t, err)
+	require.NotNil(t, got)
+	require.Equal(t, history.SystemPrompt, got.SystemPrompt)
+
+	require.NoError(t, store.Delete("42"))
+	got, err = store.Get("42")
+	require.NoError(t, err)
+	require.Nil(t, got)
+}
+
+// testInterruptedSet simulates a process killed partway through Set, leaving the given contents, if not nil, in the
+// history file and its temporary file, and returns the result of a subsequent Get
+func testInterruptedSet(t *testing.T, target []byte, temp []byte) (*ConversationHistory, error) {
+	dir := t.TempDir()
+	if target != nil {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "42"), target, 0666))
+	}
+	if temp != nil {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "42"+tempSuffix), temp, 0666))
+	}
+	return NewFileSystemConversationHistoryStore(dir).Get("42")
+}
+
+func marshalTestHistory(t *testing.T, systemPrompt string) []byte {
+	history := testHistory()
+	history.SystemPrompt = systemPrompt
+	b, err := json.Marshal(history)
+	require.NoError(t, err)
+	return b
+}
+
+func TestFileSystemConversationHistoryStore_PartialTempFile(t *testing.T) {
+	complete := marshalTestHistory(t, "previous")
+	got, err := testInterruptedSet(t, complete, complete[:len(complete)/2])
+	require.NoError(t, err)
+	require.Equal(t, "previous", got.SystemPrompt)
+}
+
+func TestFileSystemConversationHistoryStore_CompleteTempFileTargetMissing(t *testing.T) {
+	got, err := testInterruptedSet(t, nil, marshalTestHistory(t, "new"))
+	require.NoError(t, err)
+	require.Equal(t, "new", got.SystemPrompt)
+}
+
+func TestFileSystemConversationHistoryStore_CompleteTempFileTargetCorrupt(t *testing.T) {
+	complete := marshalTestHistory(t, "previous")
+	got, err := testInterruptedSet(t, complete[:len(complete)/2], marshalTestHistory(t, "new"))
+	require.NoError(t, err)
+	require.Equal(t, "new", got.SystemPrompt)
+}
+
+func TestFileSystemConversationHistoryStore_PartialTempFileTargetMissing(t *testing.T) {
+	complete := marshalTestHistory(t, "new")
+	got, err := testInterruptedSet(t, nil, complete[:len(complete)/2])
+	require.NoError(t, err)
+	require.Nil(t, got)
+}
+
+func TestLoadConversationHistory_RendersDeterministically(t *testing.T) {
+	dir := t.TempDir()
+	history := ConversationHistory{
+		SystemPrompt: "You are a helpful bot.\nBe brief.",
+		Turns:        []ConversationTurn{toolExchangeTurn(t)},
+	}
+	require.NoError(t, NewFileSystemConversationHistoryStore(dir).Set("42", history))
+
+	loaded, err := LoadConversationHistory(filepath.Join(dir, "42"))
+	require.NoError(t, err)
+	conv, err := ResumeConversation(nil, *loaded, "", 0, nil)
+	require.NoError(t, err)
+
+	// A history that went through storage renders the same as the conversation it came from
+	requireMarkdownGolden(t, "tool_exchange.md", conv)
+}
+
+func TestLoadConversationHistory_Missing(t *testing.T) {
+	_, err := LoadConversationHistory(filepath.Join(t.TempDir(), "42"))
+	require.ErrorIs(t, err, os.ErrNotExist)
+}
@@ -0,0 +1,67 @@
+package ai
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// RedisConversationHistoryStore implements ConversationHistoryStore using Redis, so that interrupted conversations can
+// be resumed by any machine with access to the same Redis server
+type RedisConversationHistoryStore struct {
+	client    redis.UniversalClient
+	namespace string        // Prepended to every key, so that the store can share a Redis database with other data
+	ttl       time.Duration // How long a conversation history is kept after it was last set. Zero means forever
+}
+
+func NewRedisConversationHistoryStore(client redis.UniversalClient, namespace string, ttl time.Duration) RedisConversationHistoryStore {
+	return RedisConversationHistoryStore{
+		client:    client,
+		namespace: namespace,
+		ttl:       ttl,
+	}
+}
+
+func (rchs RedisConversationHistoryStore) Get(key string) (*ConversationHistory, error) {
+	b, err := rchs.client.Get(context.Background(), rchs.redisKey(key)).Bytes()
+	if errors.Is(err, redis.Nil) {
+		// Nothing is stored at this key, or it expired
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to get conversation history from redis: %w", err)
+	}
+	var value ConversationHistory
+	err = json.Unmarshal(b, &value)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal conversation history: %w", err)
+	}
+	return &value, nil
+}
+
+func (rchs RedisConversationHistoryStore) Set(key string, value ConversationHistory) error {
+	b, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Errorf("failed to marshal conversation history: %w", err)
+	}
+	err = rchs.client.Set(context.Background(), rchs.redisKey(key), b, rchs.ttl).Err()
+	if err != nil {
+		return fmt.Errorf("failed to set conversation history in redis: %w", err)
+	}
+	return nil
+}
+
+func (rchs RedisConversationHistoryStore) Delete(key string) error {
+	err := rchs.client.Del(context.Background(), rchs.redisKey(key)).Err()
+	if err != nil {
+		return fmt.Errorf("failed to delete conversation history from redis: %w", err)
+	}
+	return nil
+}
+
+func (rchs RedisConversationHistoryStore) redisKey(key string) string {
+	return rchs.namespace + ":" + key
+}
@@ -0,0 +1,66 @@
+package ai
+
+import (
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/anthropics/anthropic-sdk-go"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestRedisStore(t *testing.T, ttl time.Duration) (RedisConversationHistoryStore, *miniredis.Miniredis) {
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	t.Cleanup(func() { _ = client.Close() })
+	return NewRedisConversationHistoryStore(client, "conversations", ttl), server
+}
+
+func testHistory() ConversationHistory {
+	return ConversationHistory{
+		SystemPrompt: "some system prompt",
+		Turns: []ConversationTurn{
+			{Instructions: []anthropic.ContentBlockParamUnion{anthropic.NewTextBlock("do the thing")}},
+		},
+	}
+}
+
+func TestRedisConversationHistoryStore_SetGetDelete(t *testing.T) {
+	store, server := newTestRedisStore(t, 0)
+
+	history := testHistory()
+	require.NoError(t, store.Set("42", history))
+	require.True(t, server.Exists("conversations:42"))
+
+	got, err := store.Get("42")
+	require.NoError(t, err)
+	require.NotNil(t, got)
+	require.Equal(t, history.SystemPrompt, got.SystemPrompt)
+	require.Len(t, got.Turns, 1)
+
+	require.NoError(t, store.Delete("42"))
+	got, err = store.Get("42")
+	require.NoError(t, err)
+	require.Nil(t, got)
+}
+
+func TestRedisConversationHistoryStore_GetMissing(t *testing.T) {
+	store, _ := newTestRedisStore(t, 0)
+
+	got, err := store.Get("42")
+	require.NoError(t, err)
+	require.Nil(t, got)
+}
+
+func TestRedisConversationHistoryStore_TTL(t *testing.T) {
+	store, server := newTestRedisStore(t, time.Hour)
+
+	require.NoError(t, store.Set("42", testHistory()))
+	require.Equal(t, time.Hour, server.TTL("conversations:42"))
+
+	server.FastForward(time.Hour)
+	got, err := store.Get("42")
+	require.NoError(t, err)
+	require.Nil(t, got)
+}
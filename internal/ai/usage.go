@@ -0,0 +1,126 @@
+package ai
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/anthropics/anthropic-sdk-go"
+)
+
+// Usage holds token counts accumulated over one or more messages
+type Usage struct {
+	InputTokens              int64 `json:"input_tokens"`
+	OutputTokens             int64 `json:"output_tokens"`
+	CacheReadInputTokens     int64 `json:"cache_read_input_tokens"`
+	CacheCreationInputTokens int64 `json:"cache_creation_input_tokens"`
+}
+
+func (u Usage) add(other Usage) Usage {
+	return Usage{
+		InputTokens:              u.InputTokens + other.InputTokens,
+		OutputTokens:             u.OutputTokens + other.OutputTokens,
+		CacheReadInputTokens:     u.CacheReadInputTokens + other.CacheReadInputTokens,
+		CacheCreationInputTokens: u.CacheCreationInputTokens + other.CacheCreationInputTokens,
+	}
+}
+
+// ModelPrice holds the prices of a model's tokens, in US dollars per million tokens
+type ModelPrice struct {
+	Input         float64
+	Output        float64
+	CacheRead     float64
+	CacheCreation float64
+}
+
+func (mp ModelPrice) cost(u Usage) float64 {
+	return (float64(u.InputTokens)*mp.Input +
+		float64(u.OutputTokens)*mp.Output +
+		float64(u.CacheReadInputTokens)*mp.CacheRead +
+		float64(u.CacheCreationInputTokens)*mp.CacheCreation) / 1_000_000
+}
+
+// PriceTable maps models to their prices. A model that isn't listed exactly is priced using the longest listed model
+// name that it starts with, so that e.g. "claude-sonnet-4-5" also covers dated snapshots like
+// "claude-sonnet-4-5-20250929"
+type PriceTable map[anthropic.Model]ModelPrice
+
+// DefaultPriceTable lists standard API prices, with cache creation priced for the default five-minute cache lifetime
+var DefaultPriceTable = PriceTable{
+	anthropic.ModelClaudeSonnet4_5: {Input: 3, Output: 15, CacheRead: 0.30, CacheCreation: 3.75},
+	anthropic.ModelClaudeSonnet4_0: {Input: 3, Output: 15, CacheRead: 0.30, CacheCreation: 3.75},
+	"claude-opus-4":                {Input: 15, Output: 75, CacheRead: 1.50, CacheCreation: 18.75},
+	"claude-haiku-4-5":             {Input: 1, Output: 5, CacheRead: 0.10, CacheCreation: 1.25},
+}
+
+func (pt PriceTable) lookup(model anthropic.Model) (ModelPrice, bool) {
+	if price, ok := pt[model]; ok {
+		return price, true
+	}
+
+	var (
+		best    ModelPrice
+		bestLen int
+	)
+	for name, price := range pt {
+		if strings.HasPrefix(string(model), string(name)) && len(name) > bestLen {
+			best, bestLen = price, len(name)
+		}
+	}
+	return best, bestLen > 0
+}
+
+// UsageTracker accumulates token usage across all messages sent in one or more conversations. It is safe for
+// concurrent use
+type UsageTracker struct {
+	mu      sync.Mutex
+	byModel map[anthropic.Model]Usage
+}
+
+func NewUsageTracker() *UsageTracker {
+	return &UsageTracker{
+		byModel: map[anthropic.Model]Usage{},
+	}
+}
+
+// Add records the usage of a single response from the given model
+func (ut *UsageTracker) Add(model anthropic.Model, usage anthropic.Usage) {
+	ut.mu.Lock()
+	defer ut.mu.Unlock()
+
+	ut.byModel[model] = ut.byModel[model].add(Usage{
+		InputTokens:              usage.InputTokens,
+		OutputTokens:             usage.OutputTokens,
+		CacheReadInputTokens:     usage.CacheReadInputTokens,
+		CacheCreationInputTokens: usage.CacheCreationInputTokens,
+	})
+}
+
+// Total returns the usage recorded so far, summed across all models
+func (ut *UsageTracker) Total() Usage {
+	ut.mu.Lock()
+	defer ut.mu.Unlock()
+
+	var total Usage
+	for _, usage := range ut.byModel {
+		total = total.add(usage)
+	}
+	return total
+}
+
+// Cost returns the estimated cost in US dollars of the usage recorded so far. Returns an error if any of the models
+// used are missing from the price table
+func (ut *UsageTracker) Cost(prices PriceTable) (float64, error) {
+	ut.mu.Lock()
+	defer ut.mu.Unlock()
+
+	var cost float64
+	for model, usage := range ut.byModel {
+		price, ok := prices.lookup(model)
+		if !ok {
+			return 0, fmt.Errorf("no price for model '%s'", model)
+		}
+		cost += price.cost(usage)
+	}
+	return cost, nil
+}
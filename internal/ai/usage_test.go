@@ -0,0 +1,38 @@
+package ai
+
+import (
+	"testing"
+
+	"github.com/anthropics/anthropic-sdk-go"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUsageTracker_Cost(t *testing.T) {
+	tracker := NewUsageTracker()
+	// Dated snapshots are priced using the undated model name
+	tracker.Add(anthropic.ModelClaudeSonnet4_5_20250929, anthropic.Usage{InputTokens: 1_000_000, OutputTokens: 100_000})
+	tracker.Add(anthropic.ModelClaudeSonnet4_5, anthropic.Usage{CacheReadInputTokens: 1_000_000, CacheCreationInputTokens: 1_000_000})
+
+	prices := PriceTable{
+		anthropic.ModelClaudeSonnet4_5: {Input: 3, Output: 15, CacheRead: 0.30, CacheCreation: 3.75},
+		"claude":                       {Input: 100, Output: 100, CacheRead: 100, CacheCreation: 100},
+	}
+	cost, err := tracker.Cost(prices)
+	require.NoError(t, err)
+	require.InDelta(t, 3+1.5+0.30+3.75, cost, 1e-9)
+
+	require.Equal(t, Usage{
+		InputTokens:              1_000_000,
+		OutputTokens:             100_000,
+		CacheReadInputTokens:     1_000_000,
+		CacheCreationInputTokens: 1_000_000,
+	}, tracker.Total())
+}
+
+func TestUsageTracker_CostUnknownModel(t *testing.T) {
+	tracker := NewUsageTracker()
+	tracker.Add("some-other-model", anthropic.Usage{InputTokens: 1})
+
+	_, err := tracker.Cost(DefaultPriceTable)
+	require.Error(t, err)
+}
@@ -1,22 +1,29 @@
 package bot
 
 import (
+	"bytes"
 	"context"
+	"crypto/sha256"
 	_ "embed"
+	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
-	"net/http"
 	"os"
+	"runtime/debug"
 	"slices"
-	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/anthropics/anthropic-sdk-go"
 	"github.com/google/go-github/v72/github"
 
 	"github.com/cchalm/blundering-savant/internal/ai"
+	"github.com/cchalm/blundering-savant/internal/logging"
+	"github.com/cchalm/blundering-savant/internal/metrics"
 	"github.com/cchalm/blundering-savant/internal/task"
 	"github.com/cchalm/blundering-savant/internal/validator"
+	"github.com/cchalm/blundering-savant/internal/vcs"
 	"github.com/cchalm/blundering-savant/internal/workspace"
 )
 
@@ -24,16 +31,118 @@ import (
 // comments from other users
 type Bot struct {
 	githubClient           *github.Client
+	vcs                    vcs.Provider
 	sender                 ai.MessageSender
 	toolRegistry           *ToolRegistry
 	workspaceFactory       WorkspaceFactory
 	resumableConversations ConversationHistoryStore // May be nil
+	stats                  StatsSink                // May be nil
+
+	tokenLimit           int64   // Determines when conversation summarization is triggered
+	maxTurns             int     // Also triggers summarization, however few tokens the conversation reportedly uses
+	maxIterations        int     // The maximum number of AI responses to handle per task
+	maxRepeatedToolCalls int     // The number of times in a row that an identical tool call is run before it is refused
+	maxCostUSD           float64 // The estimated spend per task at which the bot hands off to a human. Zero means no limit
+	prices               ai.PriceTable
+	usageFooter          bool
+	systemPromptOverride string // Replaces the built-in system prompt if non-empty
+	systemPromptAppendix string // Appended to the system prompt if non-empty
+	concurrency          int    // The number of tasks to work on at once
+	dryRun               bool   // If true, changes to GitHub are logged instead of made
+	maxFileBytes         int64  // The size limit of files the AI writes, unless the repository overrides it
+	// protectedPaths are the paths the AI may not write to, unless the repository overrides them
+	protectedPaths task.ProtectedPaths
+
+	// maxValidationOutputLines is the number of lines of validation output that the AI sees before it is truncated
+	maxValidationOutputLines int
+
+	// seedTurns are example turns that start every new conversation
+	seedTurns []ai.ConversationTurn
+
+	// shutdownGracePeriod is how long Run lets in-flight tasks continue after its context is cancelled
+	shutdownGracePeriod time.Duration
+
+	// breaker pauses work on new tasks after repeated AI failures
+	breaker *circuitBreaker
+
+	// issueLocks prevents concurrent work on the same issue. Locks are keyed by owner/repo#number, since issue numbers
+	// are only unique within a repository
+	issueLocks keyedMutex[string]
+
+	user    *github.User
+	logger  logging.Logger
+	metrics metrics.Metrics
+}
 
-	tokenLimit int64 // Determines when conversation summarization is triggered
-
-	user *github.User
+// Config holds optional settings for a Bot. The zero value of each field selects a default
+type Config struct {
+	// Logger receives the bot's log output. Defaults to logging.Default()
+	Logger logging.Logger
+	// Metrics records the bot's throughput, errors, and tool latency. Defaults to discarding them
+	Metrics metrics.Metrics
+	// MaxIterations caps the number of AI responses handled per task, to limit spend on tasks that the AI can't
+	// complete. Defaults to 500
+	MaxIterations int
+	// MaxConversationTurns caps the number of turns in a conversation. A longer conversation is summarized even if its
+	// reported token usage is below the limit, which it may be if the usage is stale. Defaults to 100
+	MaxConversationTurns int
+	// MaxCostUSD caps the estimated cost of each task, in US dollars. When a task exceeds it, the bot posts a summary of
+	// its progress, marks the issue as blocked, and stops. The conversation is kept so that the task can be resumed
+	// once the label is removed. Zero means no limit
+	MaxCostUSD float64
+	// Prices are used to estimate the cost of each task. Defaults to ai.DefaultPriceTable
+	Prices ai.PriceTable
+	// UsageFooter enables appending token usage and estimated cost to the descriptions of new pull requests
+	UsageFooter bool
+	// Concurrency is the number of tasks that Run works on at once. Defaults to 1
+	Concurrency int
+	// ShutdownGracePeriod is how long Run lets in-flight tasks continue after its context is cancelled, before
+	// cancelling them too. Defaults to 5 minutes
+	ShutdownGracePeriod time.Duration
+	// AIFailureThreshold is the number of consecutive failed requests to the AI after which Run stops starting new tasks
+	// for AIFailureCooldown, and tasks that fail in the meantime are left to be retried rather than marked as blocked.
+	// Defaults to 5
+	AIFailureThreshold int
+	// AIFailureCooldown is how long Run pauses after AIFailureThreshold consecutive failed requests to the AI. Defaults
+	// to 10 minutes
+	AIFailureCooldown time.Duration
+	// DryRun prevents the bot from changing anything on GitHub, e.g. posting comments, adding labels, or publishing
+	// changes. The actions it would have taken are logged instead. Conversation histories are not persisted in dry-run
+	// mode, so that a dry run can't be resumed for real
+	DryRun bool
+	// FetchURLAllowedHosts lists the hosts that the AI may fetch documents from. An entry of the form "*.example.com"
+	// allows all subdomains of example.com. The fetch_url tool is only available if this is non-empty
+	FetchURLAllowedHosts []string
+	// FetchURLMaxBytes caps the size of documents the AI may fetch. Defaults to 100KB
+	FetchURLMaxBytes int64
+	// MaxFileBytes caps the size of files the AI may write, so that it can't bloat the repository. A repository may
+	// override it in its config file. Defaults to 1MB
+	MaxFileBytes int64
+	// ProtectedPaths are patterns of paths that the AI may not write to, such as vendored or generated code. A repository
+	// may override them in its config file. Defaults to task.DefaultProtectedPaths
+	ProtectedPaths task.ProtectedPaths
+	// MaxValidationOutputLines caps the number of lines of validation and test output that the AI sees. Longer output is
+	// truncated to its first and last lines and the lines that look like errors. Defaults to 200
+	MaxValidationOutputLines int
+	// Tools selects which tools the AI may use. Defaults to all of them
+	Tools ToolFilter
+	// MaxRepeatedToolCalls is the number of times in a row that the AI may make an identical tool call. Further repeats
+	// are not run; the AI is told that it appears to be stuck in a loop instead. Defaults to 3
+	MaxRepeatedToolCalls int
+	// SystemPromptOverride replaces the built-in system prompt, if set. It is used verbatim, not as a template
+	SystemPromptOverride string
+	// SystemPromptAppendix is appended to the system prompt, e.g. to describe organization-specific conventions
+	SystemPromptAppendix string
+	// Stats receives a record of each task that the bot works on, e.g. to analyze its performance over time. Records
+	// are discarded if nil
+	Stats StatsSink
+	// SeedTurns start every new conversation, e.g. to demonstrate correct tool usage. They are kept when the
+	// conversation is summarized. See ai.ParseSeedTurns
+	SeedTurns []ai.ConversationTurn
 }
 
+// ConversationHistoryStore stores conversation histories by key. Implementations must be safe for concurrent use with
+// distinct keys
 type ConversationHistoryStore interface {
 	// Get returns the conversation history stored at the given key, or nil if there is nothing stored at that key
 	Get(key string) (*ai.ConversationHistory, error)
@@ -53,6 +162,15 @@ type Workspace interface {
 	HasLocalChanges() bool
 	// ClearChanges clears any local (unvalidated) changes in the workspace
 	ClearLocalChanges()
+	// Diff returns a unified diff of local (unvalidated) changes in the workspace. If path is non-empty, only changes to
+	// that file, or to files within that directory, are included
+	Diff(ctx context.Context, path string) (string, error)
+
+	// SyncWithBase brings new commits on the base branch into the workspace, if configured to. If they conflict with
+	// the workspace's changes, the returned result fails and describes the conflicts
+	SyncWithBase(ctx context.Context) (validator.ValidationResult, error)
+	// SetBaseBranch changes the branch that the workspace is based on, e.g. after its pull request was retargeted
+	SetBaseBranch(branch string)
 
 	// HasUnpublishedChanged returns true if there are validated changes that have not been published for review
 	HasUnpublishedChanges(ctx context.Context) (bool, error)
@@ -61,11 +179,19 @@ type Workspace interface {
 	// be provided if there are local changes in the workspace. After calling ValidateChanges, there will be no local
 	// changes in the workspace.
 	ValidateChanges(ctx context.Context, commitMessage *string) (validator.ValidationResult, error)
-	// PublishChangesForReview makes validated changes available for review. reviewRequestTitle and reviewRequestBody
-	// are only used the first time a review is published, subsequent publishes will ignore these parameters and update
-	// the existing review. PublishChangesForReview will return an error if there are unvalidated local changes in the
-	// workspace; all local changes must be validated before calling PublishChangesForReview
-	PublishChangesForReview(ctx context.Context, reviewRequestTitle string, reviewRequestBody string) error
+	// RunTests persists local changes remotely, like ValidateChanges, and runs only the tests identified by target,
+	// e.g. a package or test file. Returns an error wrapping validator.ErrScopedTestsUnsupported if the workspace can't
+	// run a subset of the tests
+	RunTests(ctx context.Context, commitMessage *string, target string) (validator.ValidationResult, error)
+	// Coverage returns the test coverage measured by the most recent validation. Returns an error wrapping
+	// validator.ErrCoverageUnavailable if there is none, e.g. because the validator doesn't measure coverage
+	Coverage(ctx context.Context) (validator.Coverage, error)
+	// PublishChangesForReview makes validated changes available for review. reviewRequestTitle, reviewRequestBody, and
+	// draft are only used the first time a review is published, subsequent publishes will ignore these parameters and
+	// update the existing review. If draft is true, the review is opened as a draft. Returns the pull request if this
+	// publish created it, or nil otherwise. PublishChangesForReview will return an error if there are unvalidated local
+	// changes in the workspace; all local changes must be validated before calling PublishChangesForReview
+	PublishChangesForReview(ctx context.Context, reviewRequestTitle string, reviewRequestBody string, draft bool) (*task.GithubPullRequest, error)
 }
 
 type WorkspaceFactory interface {
@@ -78,146 +204,642 @@ func New(
 	sender ai.MessageSender,
 	historyStore ConversationHistoryStore,
 	workspaceFactory WorkspaceFactory,
+	config Config,
 ) *Bot {
+	logger := config.Logger
+	if logger == nil {
+		logger = logging.Default()
+	}
+	m := config.Metrics
+	if m == nil {
+		m = metrics.Noop{}
+	}
+	maxIterations := config.MaxIterations
+	if maxIterations <= 0 {
+		maxIterations = 500
+	}
+	maxTurns := config.MaxConversationTurns
+	if maxTurns <= 0 {
+		maxTurns = 100
+	}
+	maxRepeatedToolCalls := config.MaxRepeatedToolCalls
+	if maxRepeatedToolCalls <= 0 {
+		maxRepeatedToolCalls = 3
+	}
+	prices := config.Prices
+	if prices == nil {
+		prices = ai.DefaultPriceTable
+	}
+	concurrency := config.Concurrency
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+	shutdownGracePeriod := config.ShutdownGracePeriod
+	if shutdownGracePeriod <= 0 {
+		shutdownGracePeriod = 5 * time.Minute
+	}
+	aiFailureThreshold := config.AIFailureThreshold
+	if aiFailureThreshold <= 0 {
+		aiFailureThreshold = 5
+	}
+	aiFailureCooldown := config.AIFailureCooldown
+	if aiFailureCooldown <= 0 {
+		aiFailureCooldown = 10 * time.Minute
+	}
+	breaker := newCircuitBreaker(aiFailureThreshold, aiFailureCooldown)
+	if config.DryRun {
+		historyStore = nil
+	}
+
+	maxFileBytes := config.MaxFileBytes
+	if maxFileBytes <= 0 {
+		maxFileBytes = 1_000_000
+	}
+
+	protectedPaths := config.ProtectedPaths
+	if protectedPaths == nil {
+		protectedPaths = task.DefaultProtectedPaths
+	}
+
+	maxValidationOutputLines := config.MaxValidationOutputLines
+	if maxValidationOutputLines <= 0 {
+		maxValidationOutputLines = 200
+	}
+
+	toolRegistry := NewToolRegistry(config.Tools)
+	if len(config.FetchURLAllowedHosts) > 0 {
+		fetchURLMaxBytes := config.FetchURLMaxBytes
+		if fetchURLMaxBytes <= 0 {
+			fetchURLMaxBytes = 100_000
+		}
+		toolRegistry.Register(NewFetchURLTool(config.FetchURLAllowedHosts, fetchURLMaxBytes))
+	}
+	if unknown := toolRegistry.UnknownFilterNames(); len(unknown) > 0 {
+		logger.Warn("Ignoring unknown tools in tool filter", "tools", unknown)
+	}
+
 	return &Bot{
-		githubClient:           githubClient,
-		sender:                 sender,
-		toolRegistry:           NewToolRegistry(),
-		workspaceFactory:       workspaceFactory,
-		resumableConversations: historyStore,
-		tokenLimit:             100000, // Use a limit of 100k tokens, half of the context limit of 200k
-		user:                   githubUser,
+		githubClient:             githubClient,
+		vcs:                      vcs.NewGithubProvider(githubClient),
+		sender:                   breakerSender{sender: sender, breaker: breaker},
+		toolRegistry:             toolRegistry,
+		workspaceFactory:         workspaceFactory,
+		resumableConversations:   historyStore,
+		stats:                    config.Stats,
+		tokenLimit:               100000, // Use a limit of 100k tokens, half of the context limit of 200k
+		maxTurns:                 maxTurns,
+		maxIterations:            maxIterations,
+		maxRepeatedToolCalls:     maxRepeatedToolCalls,
+		maxCostUSD:               config.MaxCostUSD,
+		prices:                   prices,
+		usageFooter:              config.UsageFooter,
+		systemPromptOverride:     config.SystemPromptOverride,
+		systemPromptAppendix:     config.SystemPromptAppendix,
+		seedTurns:                config.SeedTurns,
+		concurrency:              concurrency,
+		shutdownGracePeriod:      shutdownGracePeriod,
+		breaker:                  breaker,
+		dryRun:                   config.DryRun,
+		maxFileBytes:             maxFileBytes,
+		protectedPaths:           protectedPaths,
+		maxValidationOutputLines: maxValidationOutputLines,
+		user:                     githubUser,
+		logger:                   logger,
+		metrics:                  m,
 	}
 }
 
-// Run starts the main loop
+// Run starts the main loop, working on up to the configured number of tasks at once. Tasks for the same issue are
+// never worked on concurrently. Returns when the tasks channel is closed or yields an error, or when ctx is cancelled,
+// after waiting for in-progress tasks to finish
 func (b *Bot) Run(ctx context.Context, tasks <-chan task.TaskOrError) error {
-	for taskOrError := range tasks {
-		tsk, err := taskOrError.Task, taskOrError.Err
-		if err != nil {
-			return err
+	// Tasks aren't cancelled along with ctx, so that a shutdown doesn't abandon them mid-edit. They get a grace period
+	// to finish instead
+	taskCtx, cancelTasks := context.WithCancel(context.WithoutCancel(ctx))
+	defer cancelTasks()
+	go b.cancelAfterGracePeriod(ctx, taskCtx, cancelTasks)
+
+	// slots holds a token for each task in progress. A slot is taken before pulling a task rather than after, so that
+	// while every worker is busy, waiting tasks stay with the generator, which keeps them up to date, rather than going
+	// stale here
+	slots := make(chan struct{}, b.concurrency)
+	var wg sync.WaitGroup
+
+	var err error
+	for {
+		// Stop pulling tasks while the AI is failing, rather than failing each of them
+		b.waitForBreaker(ctx)
+		// Once shutting down, stop waiting for a free slot or the next task, and don't start anything new. Both may be
+		// ready along with ctx.Done, in which case select picks at random, so check ctx again afterwards
+		select {
+		case slots <- struct{}{}:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		var (
+			taskOrError task.TaskOrError
+			ok          bool
+		)
+		select {
+		case taskOrError, ok = <-tasks:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			// A task received along with the cancellation is left for the next run to pick up
+			break
+		}
+		if !ok {
+			break
+		}
+		if taskOrError.Err != nil {
+			err = taskOrError.Err
+			break
 		}
 
-		err = b.DoTask(ctx, tsk)
+		wg.Add(1)
+		go func(tsk task.Task) {
+			defer wg.Done()
+			defer func() { <-slots }()
+			b.doTaskExclusively(taskCtx, tsk)
+		}(taskOrError.Task)
+	}
 
-		if err != nil {
-			// Log the error and continue processing other tasks
-			log.Printf("failed to process task for issue %d: %v", tsk.Issue.Number, err)
+	if ctx.Err() != nil {
+		// Let the producer send whatever it has left and close the channel, rather than block on it forever
+		go func() {
+			for range tasks {
+			}
+		}()
+	}
+
+	wg.Wait()
+	return err
+}
+
+// waitForBreaker blocks until the circuit breaker is closed or ctx is cancelled
+func (b *Bot) waitForBreaker(ctx context.Context) {
+	for {
+		until := b.breaker.openUntil()
+		if until.IsZero() {
+			return
+		}
+		b.logger.Warn("Pausing work on new tasks after repeated AI failures", "until", until.Format(time.RFC3339))
+		select {
+		case <-time.After(time.Until(until)):
+		case <-ctx.Done():
+			return
 		}
 	}
+}
 
-	return nil
+// cancelAfterGracePeriod calls cancelTasks once the shutdown grace period has passed since ctx was cancelled, unless
+// taskCtx is done first
+func (b *Bot) cancelAfterGracePeriod(ctx context.Context, taskCtx context.Context, cancelTasks context.CancelFunc) {
+	select {
+	case <-ctx.Done():
+	case <-taskCtx.Done():
+		return
+	}
+
+	b.logger.Info("Waiting for in-flight tasks to finish before shutting down", "grace_period", b.shutdownGracePeriod)
+	select {
+	case <-time.After(b.shutdownGracePeriod):
+		b.logger.Warn("In-flight tasks didn't finish within the shutdown grace period, cancelling them")
+		cancelTasks()
+	case <-taskCtx.Done():
+	}
+}
+
+// doTaskExclusively does the given task once no other worker is working on the same issue, logging any error
+// HistoryKey returns the key under which the conversation history of work on the given issue is stored. Issue numbers
+// are only unique within a repository, so the key includes the repository. GitHub owner names can't contain
+// underscores, so the key is also a safe file name that no other issue's key collides with
+func HistoryKey(issue task.GithubIssue) string {
+	return fmt.Sprintf("%s_%s_%d", issue.Owner, issue.Repo, issue.Number)
 }
 
-func (b *Bot) DoTask(ctx context.Context, tsk task.Task) (err error) {
-	if err := addLabel(ctx, b.githubClient.Issues, tsk.Issue, task.LabelWorking); err != nil {
-		log.Printf("failed to add in-progress label: %v", err)
+func (b *Bot) doTaskExclusively(ctx context.Context, tsk task.Task) {
+	key := fmt.Sprintf("%s/%s#%d", tsk.Issue.Owner, tsk.Issue.Repo, tsk.Issue.Number)
+	b.issueLocks.Lock(key)
+	defer b.issueLocks.Unlock(key)
+
+	_, err := b.DoTask(ctx, tsk)
+	if err != nil {
+		// Log the error and continue processing other tasks
+		b.logger.Error("failed to process task", "issue_number", tsk.Issue.Number, "error", err)
 	}
+}
+
+// DoTask works on the given task until the AI concludes its turn. Returns the token usage of the task, which is
+// non-nil even if an error is returned
+func (b *Bot) DoTask(ctx context.Context, tsk task.Task) (usage *ai.UsageTracker, err error) {
+	// Attach the task's identity to everything logged while working on it
+	logger := b.logger.With("owner", tsk.Issue.Owner, "repo", tsk.Issue.Repo, "issue_number", tsk.Issue.Number)
+	ctx = logging.WithLogger(ctx, logger)
+
+	usage = ai.NewUsageTracker()
 	defer func() {
-		if err := removeLabel(ctx, b.githubClient.Issues, tsk.Issue, task.LabelWorking); err != nil {
-			log.Printf("failed to remove in-progress label: %v", err)
+		total := usage.Total()
+		args := []any{
+			"input_tokens", total.InputTokens,
+			"output_tokens", total.OutputTokens,
+			"cache_read_input_tokens", total.CacheReadInputTokens,
+			"cache_creation_input_tokens", total.CacheCreationInputTokens,
 		}
+		if cost, err := usage.Cost(b.prices); err == nil {
+			args = append(args, "estimated_cost_usd", fmt.Sprintf("%.2f", cost))
+		}
+		logger.Info("Task usage", args...)
+	}()
 
-		if err != nil {
+	err = b.acquireIssueLock(ctx, tsk.Issue)
+	if errors.Is(err, errIssueLocked) {
+		// Leave the label alone, it belongs to whoever is working on the issue
+		logger.Info("Skipping task", "reason", err)
+		return usage, nil
+	} else if err != nil {
+		// Without the lock, another process may be working on the issue too, so leave the task to be picked up again
+		return usage, fmt.Errorf("failed to lock issue: %w", err)
+	}
+	logger.Info("Starting task", "attention_reason", tsk.AttentionReason)
+	started := time.Now()
+	progress := newTaskProgress()
+	defer func() {
+		interrupted := err != nil && ctx.Err() != nil
+		// Clean up even if the task was cancelled
+		ctx := context.WithoutCancel(ctx)
+		if err := b.releaseIssueLock(ctx, tsk.Issue); err != nil {
+			logger.Error("failed to unlock issue", "error", err)
+		}
+
+		// Check the breaker rather than the error, so that the failure that opened the breaker counts too
+		aiUnavailable := err != nil && !interrupted && (errors.Is(err, errCircuitOpen) || b.breaker.isOpen())
+		transient := err != nil && !interrupted && isTransientError(err)
+		outcome := "success"
+		if interrupted {
+			outcome = "interrupted"
+		} else if aiUnavailable {
+			outcome = "ai_unavailable"
+		} else if transient {
+			outcome = "transient_error"
+		} else if err != nil {
+			outcome = "error"
+		}
+		b.metrics.IncCounter(metrics.TasksProcessed, metrics.Labels{"outcome": outcome})
+
+		if interrupted {
+			// Not the task's fault, so leave it to be picked up again. Its conversation history has been kept
+			logger.Warn("Task interrupted", "error", err)
+		} else if aiUnavailable {
+			// Every task would fail the same way until the AI recovers, so leave the task to be picked up again then
+			logger.Warn("Task failed while the AI is unavailable, will retry", "error", err)
+		} else if transient {
+			// Likely to succeed on a later attempt, so leave the task to be picked up again on the next poll rather than
+			// asking a human to unblock it
+			logger.Warn("Task failed with a transient error, will retry", "error", err)
+		} else if err != nil {
 			// Add blocked label if there is an error, to tell the bot not to pick up this item again
-			if err := addLabel(ctx, b.githubClient.Issues, tsk.Issue, task.LabelBlocked); err != nil {
-				log.Printf("failed to add blocked label: %v", err)
+			if err := b.addIssueLabel(ctx, tsk.Issue, task.LabelBlocked); err != nil {
+				logger.Error("failed to add blocked label", "error", err)
 			}
+			b.metrics.IncCounter(metrics.TasksBlocked, nil)
 			// Post sanitized error comment
 			msg := "❌ I encountered an error while working on this issue."
+			var ile iterationLimitError
+			if errors.As(err, &ile) {
+				msg = fmt.Sprintf("❌ I gave up on this issue after %d iterations without finishing. "+
+					"The task may be too large or ambiguous for me to complete; consider breaking it into smaller "+
+					"issues or clarifying the requirements, then remove the '%s' label to have me try again.",
+					ile.limit, *task.LabelBlocked.Name)
+			}
 			if err := b.postIssueComment(ctx, tsk.Issue, msg); err != nil {
-				log.Printf("failed to post error comment: %v", err)
+				logger.Error("failed to post error comment", "error", err)
 			}
 		}
+
+		statsOutcome := TaskCompleted
+		if interrupted || aiUnavailable || transient {
+			statsOutcome = TaskRetrying
+		} else if err != nil {
+			statsOutcome = TaskBlocked
+		} else if progress.handedOff {
+			statsOutcome = TaskHandoff
+		}
+		b.recordStats(ctx, tsk, statsOutcome, started, progress, usage)
+	}()
+	// Deferred after the cleanup above so that it runs first, turning a panic into an error that the cleanup handles
+	defer func() {
+		if r := recover(); r != nil {
+			logger.Error("panic while processing task", "panic", r, "stack", string(debug.Stack()))
+			err = fmt.Errorf("panic: %v", r)
+		}
 	}()
 
+	if len(tsk.Directives.Comments) > 0 {
+		b.acknowledgeCommands(ctx, tsk)
+	}
+	if tsk.Directives.Skip {
+		logger.Info("Skipping task", "reason", "told to ignore it")
+		return usage, nil
+	}
+	if tsk.Directives.Retry {
+		if err := b.startOver(ctx, tsk); err != nil {
+			return usage, err
+		}
+	}
+
 	workspace, err := b.workspaceFactory.NewWorkspace(ctx, tsk)
 	if err != nil {
-		return fmt.Errorf("failed to create workspace: %w", err)
+		return usage, fmt.Errorf("failed to create workspace: %w", err)
 	}
 
 	// Do some prep work to avoid unnecessary back-and-forths with the AI
 
+	syncResult, err := workspace.SyncWithBase(ctx)
+	if err != nil {
+		return usage, fmt.Errorf("failed to sync workspace with base branch: %w", err)
+	}
+
 	hasUnpublishedChanges, err := workspace.HasUnpublishedChanges(ctx)
 	if err != nil {
-		return fmt.Errorf("failed to check for unpublished changes: %w", err)
+		return usage, fmt.Errorf("failed to check for unpublished changes: %w", err)
 	}
 
 	validationResult, err := workspace.ValidateChanges(ctx, nil)
 	if err != nil {
-		return fmt.Errorf("failed to fetch validation results: %w", err)
+		return usage, fmt.Errorf("failed to fetch validation results: %w", err)
 	}
 
 	tsk.HasUnpublishedChanges = hasUnpublishedChanges
 	tsk.ValidationResult = validationResult
+	tsk.BaseSyncResult = &syncResult
+
+	if err := b.acknowledgeComments(ctx, tsk); err != nil {
+		return usage, err
+	}
+	if err := b.acknowledgePlanApproval(ctx, tsk); err != nil {
+		return usage, err
+	}
 
 	// Let the AI do its thing
-	err = b.processWithAI(ctx, tsk, workspace)
+	err = b.processWithAI(ctx, tsk, workspace, usage, progress)
 	if err != nil {
-		return fmt.Errorf("failed to process with AI: %w", err)
+		return usage, fmt.Errorf("failed to process with AI: %w", err)
+	}
+
+	return usage, nil
+}
+
+// recordStats sends a record of the given task to the stats sink, if the bot has one
+func (b *Bot) recordStats(
+	ctx context.Context,
+	tsk task.Task,
+	outcome TaskOutcome,
+	started time.Time,
+	progress *taskProgress,
+	usage *ai.UsageTracker,
+) {
+	if b.stats == nil {
+		return
 	}
 
+	stats := TaskStats{
+		Owner:           tsk.Issue.Owner,
+		Repo:            tsk.Issue.Repo,
+		IssueNumber:     tsk.Issue.Number,
+		FinishedAt:      time.Now().UTC(),
+		DurationSeconds: time.Since(started).Seconds(),
+		Outcome:         outcome,
+		Iterations:      progress.iterations,
+		ToolCalls:       progress.toolCalls,
+		Usage:           usage.Total(),
+	}
+	if cost, err := usage.Cost(b.prices); err == nil {
+		stats.EstimatedCostUSD = &cost
+	}
+	if err := b.stats.Record(stats); err != nil {
+		logging.FromContext(ctx).Warn("failed to record task stats", "error", err)
+	}
+}
+
+// acknowledgeCommands reacts to the comments that the task's directives came from, so that they aren't obeyed again
+func (b *Bot) acknowledgeCommands(ctx context.Context, tsk task.Task) {
+	logger := logging.FromContext(ctx)
+	for _, comment := range tsk.Directives.Comments {
+		logger.Info("Obeying command", "comment_id", comment.GetID(), "command", comment.GetBody())
+		if b.dryRun {
+			continue
+		}
+		err := b.vcs.AddCommentReaction(ctx, tsk.Issue.Owner, tsk.Issue.Repo, comment.GetID(), "+1")
+		if err != nil {
+			logger.Error("failed to acknowledge command", "comment_id", comment.GetID(), "error", err)
+		}
+	}
+}
+
+// acknowledgementReaction is the reaction with which the bot marks comments that it has started responding to
+const acknowledgementReaction = task.AcknowledgementReaction
+
+// acknowledgeComments reacts to the comments requiring responses before the AI starts on them, to show that the bot is
+// on it. The acknowledgement doesn't mark the comments as handled; they require responses until the AI reacts to them
+// itself, so a crash partway through the response, even before the conversation is first persisted, doesn't drop them.
+// GitHub keeps one reaction of each kind per user, so acknowledging a comment again after a restart doesn't duplicate
+// the reaction. The issue is first labeled as the bot's turn, which keeps the task, and its resumable conversation,
+// pending until the AI concludes
+func (b *Bot) acknowledgeComments(ctx context.Context, tsk task.Task) error {
+	issueComments := slices.Concat(tsk.IssueCommentsRequiringResponses, tsk.PRCommentsRequiringResponses)
+	reviewComments := tsk.PRReviewCommentsRequiringResponses
+	if len(issueComments) == 0 && len(reviewComments) == 0 {
+		return nil
+	}
+
+	if !slices.Contains(tsk.Issue.Labels, task.LabelBotTurn.GetName()) {
+		if err := b.addIssueLabel(ctx, tsk.Issue, task.LabelBotTurn); err != nil {
+			return fmt.Errorf("failed to add bot turn label before acknowledging comments: %w", err)
+		}
+	}
+	if b.dryRun {
+		logging.FromContext(ctx).Info("Dry run: skipping comment acknowledgements")
+		return nil
+	}
+
+	// A failed acknowledgement only means that the comment is picked up again later, so carry on
+	logger := logging.FromContext(ctx)
+	owner, repo := tsk.Issue.Owner, tsk.Issue.Repo
+	for _, comment := range issueComments {
+		if err := b.vcs.AddCommentReaction(ctx, owner, repo, comment.GetID(), acknowledgementReaction); err != nil {
+			logger.Warn("failed to acknowledge comment", "comment_id", comment.GetID(), "error", err)
+		}
+	}
+	for _, comment := range reviewComments {
+		if err := b.vcs.AddReviewCommentReaction(ctx, owner, repo, comment.GetID(), acknowledgementReaction); err != nil {
+			logger.Warn("failed to acknowledge review comment", "comment_id", comment.GetID(), "error", err)
+		}
+	}
+	return nil
+}
+
+// acknowledgePlanApproval reacts to the task's plan comment once its plan has been approved, so that the approval doesn't
+// prompt the bot to start work again. Like acknowledgeComments, the issue is first labeled as the bot's turn, so that
+// the work is resumed if it is interrupted
+func (b *Bot) acknowledgePlanApproval(ctx context.Context, tsk task.Task) error {
+	if !tsk.Plan.Approved || tsk.Plan.Started {
+		return nil
+	}
+
+	if !slices.Contains(tsk.Issue.Labels, task.LabelBotTurn.GetName()) {
+		if err := b.addIssueLabel(ctx, tsk.Issue, task.LabelBotTurn); err != nil {
+			return fmt.Errorf("failed to add bot turn label before acknowledging plan approval: %w", err)
+		}
+	}
+	if b.dryRun {
+		logging.FromContext(ctx).Info("Dry run: skipping plan approval acknowledgement")
+		return nil
+	}
+
+	logger := logging.FromContext(ctx)
+	logger.Info("Starting work on approved plan", "comment_id", tsk.Plan.Comment.GetID())
+	err := b.vcs.AddCommentReaction(ctx, tsk.Issue.Owner, tsk.Issue.Repo, tsk.Plan.Comment.GetID(), acknowledgementReaction)
+	if err != nil {
+		// Only means that the approval is picked up again later, so carry on
+		logger.Warn("failed to acknowledge plan approval", "comment_id", tsk.Plan.Comment.GetID(), "error", err)
+	}
+	return nil
+}
+
+// startOver discards the task's interrupted conversation, if any, and unblocks the issue, so that work on the task
+// starts from scratch
+func (b *Bot) startOver(ctx context.Context, tsk task.Task) error {
+	if b.resumableConversations != nil {
+		if err := b.resumableConversations.Delete(HistoryKey(tsk.Issue)); err != nil {
+			return fmt.Errorf("failed to delete conversation history: %w", err)
+		}
+	}
+	if slices.Contains(tsk.Issue.Labels, task.LabelBlocked.GetName()) {
+		if err := b.removeIssueLabel(ctx, tsk.Issue, task.LabelBlocked); err != nil {
+			return fmt.Errorf("failed to remove blocked label: %w", err)
+		}
+	}
+	logging.FromContext(ctx).Info("Starting the task over")
 	return nil
 }
 
 // processWithAI handles the AI interaction with text editor tool support
-func (b *Bot) processWithAI(ctx context.Context, tsk task.Task, workspace Workspace) error {
-	maxIterations := 500
+func (b *Bot) processWithAI(
+	ctx context.Context,
+	tsk task.Task,
+	workspace Workspace,
+	usage *ai.UsageTracker,
+	progress *taskProgress,
+) (err error) {
+	logger := logging.FromContext(ctx)
 
 	// Create tool context
 	toolCtx := &ToolContext{
-		Workspace:    workspace,
-		Task:         tsk,
-		GithubClient: b.githubClient,
+		Workspace:                workspace,
+		Task:                     tsk,
+		VCS:                      b.vcs,
+		GithubClient:             b.githubClient,
+		BotUser:                  b.user,
+		Usage:                    usage,
+		Prices:                   b.prices,
+		UsageFooter:              b.usageFooter,
+		DryRun:                   b.dryRun,
+		Metrics:                  b.metrics,
+		MaxFileBytes:             b.maxFileBytes,
+		ProtectedPaths:           b.protectedPaths,
+		MaxValidationOutputLines: b.maxValidationOutputLines,
+	}
+	if tsk.RepoConfig.MaxFileBytes > 0 {
+		toolCtx.MaxFileBytes = tsk.RepoConfig.MaxFileBytes
+	}
+	if tsk.RepoConfig.ProtectedPaths != nil {
+		toolCtx.ProtectedPaths = tsk.RepoConfig.ProtectedPaths
 	}
 
 	// Initialize conversation
-	conversation, response, err := b.initConversation(ctx, tsk, toolCtx)
+	conversation, response, sent, err := b.initConversation(ctx, tsk, toolCtx)
 	if err != nil {
 		return fmt.Errorf("failed to initialize conversation: %w", err)
 	}
+	if sent {
+		// The first message of this run can't reuse a cached prefix, e.g. because it starts the conversation
+		logTurnUsage(ctx, response.Usage, false)
+	}
+	// Whether the next turn extends a prompt that this run sent, and so may have cached. A conversation resumed from an
+	// incomplete turn was last sent before the bot stopped, and its cache may have expired since
+	prefixCached := sent
+
+	defer func() {
+		if err != nil && ctx.Err() != nil {
+			// Interrupted, e.g. by a shutdown. Keep any progress made since the history was last persisted, so that the
+			// task resumes from here
+			if err := b.persistHistory(tsk, conversation); err != nil {
+				logger.Error("failed to persist conversation history of interrupted task", "error", err)
+			}
+		}
+	}()
+
+	loopDetector := &toolLoopDetector{limit: b.maxRepeatedToolCalls}
 
 	i := 0
 	for response.StopReason != anthropic.StopReasonEndTurn {
-		if i > maxIterations {
-			return fmt.Errorf("exceeded maximum iterations (%d) without completion", maxIterations)
+		if i >= b.maxIterations {
+			return iterationLimitError{limit: b.maxIterations}
 		}
 
-		if b.resumableConversations != nil {
-			// Persist the conversation history up to this point
-			err = b.resumableConversations.Set(strconv.Itoa(tsk.Issue.Number), conversation.History())
-			if err != nil {
-				return fmt.Errorf("failed to persist conversation history: %w", err)
-			}
+		// Persist the conversation history up to this point
+		err = b.persistHistory(tsk, conversation)
+		if err != nil {
+			return err
+		}
+
+		if cost, exceeded := b.budgetExceeded(ctx, conversation); exceeded {
+			// Stop before running any more tools. The persisted history ends with this response, so the task resumes
+			// from here once a human has had a look
+			progress.handedOff = true
+			return b.handOffOverBudget(ctx, tsk, conversation, cost)
 		}
 
-		log.Printf("Processing AI response, iteration: %d", i+1)
+		logger.Info("Processing AI response", "iteration", i+1)
+		progress.iterations++
 		for _, contentBlock := range response.Content {
 			switch block := contentBlock.AsAny().(type) {
 			case anthropic.TextBlock:
-				log.Print("    <text> ", block.Text)
+				logger.Info("    <text> " + block.Text)
 			case anthropic.ToolUseBlock:
-				log.Print("    <tool use> ", block.Name)
+				logger.Info("    <tool use> " + block.Name)
 			case anthropic.ServerToolUseBlock:
-				log.Print("    <server tool use> ", block.Name)
+				logger.Info("    <server tool use> " + string(block.Name))
 			case anthropic.WebSearchToolResultBlock:
-				log.Print("    <web search tool result>")
+				logger.Info("    <web search tool result>")
 			case anthropic.ThinkingBlock:
-				log.Print("    <thinking>", block.Thinking)
+				logger.Info("    <thinking>" + block.Thinking)
 			case anthropic.RedactedThinkingBlock:
-				log.Print("    <redacted thinking>")
+				logger.Info("    <redacted thinking>")
 			default:
-				log.Print("    <unknown>")
+				logger.Info("    <unknown>")
 			}
 		}
 
 		switch response.StopReason {
 		case anthropic.StopReasonToolUse:
 			// Execute tool uses and add results to conversation
-			err = b.runTools(ctx, toolCtx, conversation)
+			err = b.runTools(ctx, toolCtx, conversation, loopDetector, progress)
 			if err != nil {
 				return err
 			}
+			if toolCtx.AwaitingHumanInput {
+				progress.handedOff = true
+				return b.pauseForHumanInput(ctx, tsk, conversation)
+			}
+			if toolCtx.Concluded {
+				// The tool already handed the turn back to humans
+				logger.Info("AI concluded the task")
+				return b.deleteHistory(tsk)
+			}
 		case anthropic.StopReasonMaxTokens:
 			return fmt.Errorf("exceeded max tokens")
 		case anthropic.StopReasonRefusal:
@@ -228,18 +850,22 @@ func (b *Bot) processWithAI(ctx context.Context, tsk task.Task, workspace Worksp
 			return fmt.Errorf("unexpected stop reason: %v", response.StopReason)
 		}
 
-		log.Printf("    Responding to AI")
-		response, err = sendMessage(ctx, conversation, b.tokenLimit)
+		logger.Info("    Responding to AI")
+		turnsBefore := len(conversation.Turns)
+		response, err = sendMessage(ctx, conversation, b.tokenLimit, b.maxTurns, b.metrics)
 		if err != nil {
 			return err
 		}
+		// Summarization rewrites the history, so the cached prefix is only expected to be reused if it didn't happen
+		logTurnUsage(ctx, response.Usage, prefixCached && len(conversation.Turns) == turnsBefore+1)
+		prefixCached = true
 
 		if s, err := conversation.ToMarkdown(); err != nil {
-			log.Printf("Warning: failed to serialize conversation as markdown: %v", err)
+			logger.Warn("failed to serialize conversation as markdown", "error", err)
 		} else if err := os.MkdirAll("logs", os.ModePerm); err != nil {
-			log.Printf("Warning: failed to create logs directory: %v", err)
-		} else if err := os.WriteFile(fmt.Sprintf("logs/conversation_issue_%d.md", tsk.Issue.Number), []byte(s), 0666); err != nil {
-			log.Printf("Warning: failed to write conversation to markdown file for debugging: %v", err)
+			logger.Warn("failed to create logs directory", "error", err)
+		} else if err := os.WriteFile(fmt.Sprintf("logs/conversation_%s.md", HistoryKey(tsk.Issue)), []byte(s), 0666); err != nil {
+			logger.Warn("failed to write conversation to markdown file for debugging", "error", err)
 		}
 
 		i++
@@ -247,37 +873,155 @@ func (b *Bot) processWithAI(ctx context.Context, tsk task.Task, workspace Worksp
 
 	// We're done!
 
-	if b.resumableConversations != nil {
-		// Delete the conversation history so that we don't try to resume it later
-		err = b.resumableConversations.Delete(strconv.Itoa(tsk.Issue.Number))
-		if err != nil {
-			return fmt.Errorf("failed to delete conversation history for concluded conversation: %w", err)
-		}
+	err = b.deleteHistory(tsk)
+	if err != nil {
+		return err
 	}
 
-	err = removeLabel(ctx, b.githubClient.Issues, tsk.Issue, task.LabelBotTurn)
+	err = b.removeIssueLabel(ctx, tsk.Issue, task.LabelBotTurn)
 	if err != nil {
 		return fmt.Errorf("failed to remove bot turn label: %w", err)
 	}
 
-	log.Print("AI interaction concluded")
+	logger.Info("AI interaction concluded")
+	return nil
+}
+
+// pauseForHumanInput ends work on a task whose AI has asked a human a question. The conversation, including the
+// result of the question, is kept so that the task resumes from here when the human replies
+func (b *Bot) pauseForHumanInput(ctx context.Context, tsk task.Task, conversation *ai.Conversation) error {
+	if err := b.persistHistory(tsk, conversation); err != nil {
+		return err
+	}
+
+	logging.FromContext(ctx).Info("AI is waiting for human input")
+	return nil
+}
+
+// deleteHistory deletes the conversation history of a concluded task, if the bot has a history store, so that we don't
+// try to resume it later
+func (b *Bot) deleteHistory(tsk task.Task) error {
+	if b.resumableConversations == nil {
+		return nil
+	}
+	err := b.resumableConversations.Delete(HistoryKey(tsk.Issue))
+	if err != nil {
+		return fmt.Errorf("failed to delete conversation history for concluded conversation: %w", err)
+	}
 	return nil
 }
 
+// persistHistory saves the conversation history so that the task can be resumed from this point, if the bot has a
+// history store
+func (b *Bot) persistHistory(tsk task.Task, conversation *ai.Conversation) error {
+	if b.resumableConversations == nil {
+		return nil
+	}
+	err := b.resumableConversations.Set(HistoryKey(tsk.Issue), conversation.History())
+	if err != nil {
+		return fmt.Errorf("failed to persist conversation history: %w", err)
+	}
+	return nil
+}
+
+// budgetExceeded returns the estimated cost of the task so far, and whether it has reached the per-task budget. The
+// cost is that of the whole conversation, including turns from before it was resumed, since the last budget handoff
+func (b *Bot) budgetExceeded(ctx context.Context, conversation *ai.Conversation) (float64, bool) {
+	if b.maxCostUSD <= 0 {
+		return 0, false
+	}
+	cost, err := conversation.Spend().Cost(b.prices)
+	if err != nil {
+		logging.FromContext(ctx).Warn("failed to estimate task cost, not enforcing budget", "error", err)
+		return 0, false
+	}
+	return cost, cost >= b.maxCostUSD
+}
+
+// handOffOverBudget stops work on a task that has exceeded its budget, leaving a summary of progress for a human and
+// marking the issue as blocked so that the bot doesn't pick it up again until the label is removed
+func (b *Bot) handOffOverBudget(ctx context.Context, tsk task.Task, conversation *ai.Conversation, cost float64) error {
+	logging.FromContext(ctx).Warn("Task exceeded budget, handing off", "estimated_cost_usd", fmt.Sprintf("%.2f", cost),
+		"max_cost_usd", fmt.Sprintf("%.2f", b.maxCostUSD))
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "⏸️ I've paused work on this issue because it reached its budget of $%.2f (estimated spend so far: "+
+		"$%.2f).", b.maxCostUSD, cost)
+	if progress := latestAssistantText(conversation); progress != "" {
+		sb.WriteString("\n\nHere's where I got to:\n\n")
+		for line := range strings.SplitSeq(progress, "\n") {
+			sb.WriteString("> " + line + "\n")
+		}
+	}
+	fmt.Fprintf(&sb, "\nMy progress has been saved. Remove the '%s' label to have me pick up where I left off.",
+		*task.LabelBlocked.Name)
+
+	// Removing the blocked label grants the task another budget, so spend from here on counts towards that one
+	conversation.ResetSpend()
+	if err := b.persistHistory(tsk, conversation); err != nil {
+		return err
+	}
+
+	if err := b.addIssueLabel(ctx, tsk.Issue, task.LabelBlocked); err != nil {
+		return fmt.Errorf("failed to add blocked label: %w", err)
+	}
+	b.metrics.IncCounter(metrics.TasksBlocked, nil)
+	if err := b.postIssueComment(ctx, tsk.Issue, sb.String()); err != nil {
+		return fmt.Errorf("failed to post handoff comment: %w", err)
+	}
+	return nil
+}
+
+// latestAssistantText returns the text of the most recent AI response that contains any, or an empty string if there
+// is none
+func latestAssistantText(conversation *ai.Conversation) string {
+	for i := len(conversation.Turns) - 1; i >= conversation.SeedTurns(); i-- {
+		response := conversation.Turns[i].Response
+		if response == nil {
+			continue
+		}
+		var texts []string
+		for _, block := range response.Content {
+			if text, ok := block.AsAny().(anthropic.TextBlock); ok && strings.TrimSpace(text.Text) != "" {
+				texts = append(texts, strings.TrimSpace(text.Text))
+			}
+		}
+		if len(texts) > 0 {
+			return strings.Join(texts, "\n\n")
+		}
+	}
+	return ""
+}
+
+// iterationLimitError indicates that the AI did not complete a task within the maximum number of iterations
+type iterationLimitError struct {
+	limit int
+}
+
+func (ile iterationLimitError) Error() string {
+	return fmt.Sprintf("exceeded maximum iterations (%d) without completion", ile.limit)
+}
+
 // sendMessage sends a message in the given conversation with summarization behavior to avoid token limits
 func sendMessage(
 	ctx context.Context,
 	conversation *ai.Conversation,
 	tokenLimit int64,
+	maxTurns int,
+	m metrics.Metrics,
 	instructions ...anthropic.ContentBlockParamUnion,
 ) (*anthropic.Message, error) {
 
-	if tokenUsageExceedsLimit(conversation, tokenLimit) {
-		keepFirst, keepLast := 0, 10 // Keep the last 10 messages
+	// Keep any seeded example turns, and the last 10 messages
+	keepFirst, keepLast := conversation.SeedTurns(), 10
+	// A summarized conversation has keepFirst+keepLast+2 turns, so a lower turn limit would summarize every message
+	maxTurns = max(maxTurns, keepFirst+keepLast+2)
+	if tokenUsageExceedsLimit(conversation, tokenLimit) || len(conversation.Turns) > maxTurns {
 		err := summarize(ctx, conversation, keepFirst, keepLast)
 		if err != nil {
 			return nil, err
 		}
+		m.IncCounter(metrics.Summarizations, nil)
 	}
 
 	response, err := conversation.SendMessage(ctx, instructions...)
@@ -308,112 +1052,307 @@ func tokenUsageExceedsLimit(conversation *ai.Conversation, tokenLimit int64) boo
 	return totalTokens > tokenLimit
 }
 
+const (
+	// lowCacheReadRatio is the fraction of prompt tokens read from the cache below which a turn is considered to have
+	// missed the cache
+	lowCacheReadRatio = 0.5
+	// minCacheablePromptTokens is the prompt size below which cache misses aren't worth warning about, since small
+	// prompts may not reach the minimum cacheable length
+	minCacheablePromptTokens = 4096
+)
+
+// logTurnUsage logs the token usage of a single turn at debug level. If expectCacheHit is true, i.e. the turn extends
+// the previous turn's prompt, it also warns when few of the prompt tokens were read from the cache, which suggests that
+// the cacheable prefix changed between turns
+func logTurnUsage(ctx context.Context, usage anthropic.Usage, expectCacheHit bool) {
+	logger := logging.FromContext(ctx)
+	promptTokens := usage.InputTokens + usage.CacheReadInputTokens + usage.CacheCreationInputTokens
+	logger.Debug("    Token usage",
+		"input_tokens", usage.InputTokens,
+		"output_tokens", usage.OutputTokens,
+		"cache_read_tokens", usage.CacheReadInputTokens,
+		"cache_creation_tokens", usage.CacheCreationInputTokens,
+	)
+
+	if !expectCacheHit || promptTokens < minCacheablePromptTokens {
+		return
+	}
+	ratio := float64(usage.CacheReadInputTokens) / float64(promptTokens)
+	if ratio < lowCacheReadRatio {
+		logger.Warn("    Low cache hit rate, the cacheable prompt prefix may have changed",
+			"cache_read_ratio", fmt.Sprintf("%.2f", ratio),
+			"prompt_tokens", promptTokens,
+			"cache_read_tokens", usage.CacheReadInputTokens,
+		)
+	}
+}
+
 // runTools executes pending tool calls and adds their results to the conversation
-func (b *Bot) runTools(ctx context.Context, toolCtx *ToolContext, conversation *ai.Conversation) error {
+func (b *Bot) runTools(
+	ctx context.Context,
+	toolCtx *ToolContext,
+	conversation *ai.Conversation,
+	loopDetector *toolLoopDetector,
+	progress *taskProgress,
+) error {
 	pendingToolUses := conversation.GetPendingToolUses()
 
 	if len(pendingToolUses) == 0 {
-		log.Printf("    WARNING: Stop reason was 'tool_use', but no pending tool uses found. This shouldn't happen.")
+		logging.FromContext(ctx).Warn("    Stop reason was 'tool_use', but no pending tool uses found. This shouldn't happen.")
 		// Add an error message as an instruction so the AI can self-correct
 		_, err := conversation.SendMessage(ctx, anthropic.NewTextBlock("Error: No tool uses found in message. Was there a formatting issue?"))
 		return err
 	}
 
 	for _, toolUse := range pendingToolUses {
-		log.Printf("    Executing tool: %s", toolUse.Name)
-
-		// Process the tool use with the registry
-		toolResult, err := b.toolRegistry.ProcessToolUse(ctx, toolUse, toolCtx)
-		if err != nil {
-			return fmt.Errorf("failed to process tool use: %w", err)
+		progress.toolCalls[toolUse.Name]++
+		var toolResult *anthropic.ToolResultBlockParam
+		if repeats := loopDetector.observe(toolUse); repeats > loopDetector.limit && !b.isPollingTool(toolUse.Name) {
+			logging.FromContext(ctx).Warn("    Refusing repeated tool call", "tool", toolUse.Name, "repeats", repeats)
+			result := newToolResultBlockParam(toolUse.ID, fmt.Sprintf("Error: you have made this exact %s call %d times "+
+				"in a row, and it was not run again because the result would not change. You appear to be stuck in a loop. "+
+				"Re-read the previous results and try a different approach, or report a limitation if you can't make "+
+				"progress", toolUse.Name, repeats), true)
+			toolResult = &result
+		} else {
+			logging.FromContext(ctx).Info("    Executing tool", "tool", toolUse.Name)
+
+			// Process the tool use with the registry
+			var err error
+			toolResult, err = b.toolRegistry.ProcessToolUse(ctx, toolUse, toolCtx)
+			if err != nil {
+				return fmt.Errorf("failed to process tool use: %w", err)
+			}
 		}
 
 		// Add the result to the conversation
-		err = conversation.AddToolResult(*toolResult)
+		err := conversation.AddToolResult(*toolResult)
 		if err != nil {
 			return fmt.Errorf("failed to add tool result: %w", err)
 		}
+
+		// Persist each result as soon as we have it, so that if a later tool call in this turn fails, resuming the
+		// conversation replays this call rather than running it again
+		err = b.persistHistory(toolCtx.Task, conversation)
+		if err != nil {
+			return err
+		}
 	}
 
 	return nil
 }
 
+// isPollingTool returns true if the tool with the given name is expected to be called repeatedly with the same input,
+// because its result changes on its own, so repeated calls to it aren't a loop
+func (b *Bot) isPollingTool(name string) bool {
+	tool, ok := b.toolRegistry.GetTool(name)
+	return ok && tool.IsPolling()
+}
+
+// toolLoopDetector counts how many times in a row the AI has made the same tool call, with the same input
+type toolLoopDetector struct {
+	limit int // The number of consecutive identical calls to allow
+
+	lastCall [sha256.Size]byte // A hash of the most recent call
+	repeats  int               // The number of consecutive calls identical to lastCall, including lastCall itself
+}
+
+// observe records a tool call and returns the number of consecutive times it has been made, including this one
+func (tld *toolLoopDetector) observe(toolUse anthropic.ToolUseBlock) int {
+	// Compact the input so that whitespace differences don't disguise a repeated call
+	var input bytes.Buffer
+	if err := json.Compact(&input, toolUse.Input); err != nil {
+		input.Reset()
+		input.Write(toolUse.Input)
+	}
+	call := sha256.Sum256(append([]byte(toolUse.Name+"\x00"), input.Bytes()...))
+
+	if call == tld.lastCall {
+		tld.repeats++
+	} else {
+		tld.lastCall = call
+		tld.repeats = 1
+	}
+	return tld.repeats
+}
+
 // Helper functions
 
 func (b *Bot) postIssueComment(ctx context.Context, issue task.GithubIssue, body string) error {
-	comment := &github.IssueComment{
-		Body: github.Ptr(body),
+	if b.dryRun {
+		logging.FromContext(ctx).Info("Dry run: skipping issue comment", "body", body)
+		return nil
 	}
-	_, _, err := b.githubClient.Issues.CreateComment(ctx, issue.Owner, issue.Repo, issue.Number, comment)
-	return err
+	return b.vcs.CreateComment(ctx, issue.Owner, issue.Repo, issue.Number, body)
+}
+
+// addIssueLabel adds a label to an issue, unless in dry-run mode
+func (b *Bot) addIssueLabel(ctx context.Context, issue task.GithubIssue, label github.Label) error {
+	if b.dryRun {
+		logging.FromContext(ctx).Info("Dry run: skipping label addition", "label", label.GetName())
+		return nil
+	}
+	return addLabel(ctx, b.vcs, issue, label)
+}
+
+// errIssueLocked is returned by acquireIssueLock when another process is already working on an issue
+var errIssueLocked = errors.New("another process is already working on this issue")
+
+// acquireIssueLock adds the in-progress label to an issue, using it as a lock shared with other processes working on
+// the same repository, e.g. a poller and a one-shot run triggered by GitHub Actions. Returns errIssueLocked if the label
+// is already present, or if the issue's events show that someone else added it first. A label added more than
+// task.WorkingLabelExpiry ago was left behind by a process that died while working on the issue, so it is taken over.
+//
+// GitHub has no atomic test-and-set for labels, and adding a label that is already present is a silent no-op, so two
+// processes acting as the same user can still both acquire the lock if they check within moments of each other. The
+// label is re-read after adding it to narrow that window to the time between reading and adding the label. If the label
+// can't be re-read, it is left in place in case another process holds it, and expires like any other
+func (b *Bot) acquireIssueLock(ctx context.Context, issue task.GithubIssue) error {
+	if b.dryRun {
+		// Nothing is changed in dry-run mode, so there's nothing to protect
+		return b.addIssueLabel(ctx, issue, task.LabelWorking)
+	}
+
+	events, err := b.vcs.ListIssueEvents(ctx, issue.Owner, issue.Repo, issue.Number)
+	if err != nil {
+		return fmt.Errorf("failed to list issue events: %w", err)
+	}
+	if added := task.WorkingLabelAdded(events); added != nil {
+		age := time.Since(added.GetCreatedAt().Time)
+		if age < task.WorkingLabelExpiry {
+			return errIssueLocked
+		}
+		logging.FromContext(ctx).Warn("Taking over an expired in-progress label", "added_by", added.GetActor().GetLogin(),
+			"age", age.Round(time.Minute))
+		// Remove the label rather than keep it, so that adding it again leaves an event that shows who took it over
+		if err := b.removeIssueLabel(ctx, issue, task.LabelWorking); err != nil {
+			return fmt.Errorf("failed to remove expired in-progress label: %w", err)
+		}
+	}
+	var lastSeen int64
+	for _, event := range events {
+		lastSeen = max(lastSeen, event.GetID())
+	}
+
+	if err := b.addIssueLabel(ctx, issue, task.LabelWorking); err != nil {
+		return fmt.Errorf("failed to add in-progress label: %w", err)
+	}
+
+	// Check who added the label. If another process added it between our check and our addition, our addition was a
+	// no-op and the first labeling since our check is theirs
+	events, err = b.vcs.ListIssueEvents(ctx, issue.Owner, issue.Repo, issue.Number)
+	if err != nil {
+		return fmt.Errorf("failed to re-read in-progress label: %w", err)
+	}
+	var firstLabeled *github.IssueEvent
+	for _, event := range events {
+		if event.GetID() > lastSeen && event.GetEvent() == "labeled" && event.GetLabel().GetName() == task.LabelWorking.GetName() &&
+			(firstLabeled == nil || event.GetID() < firstLabeled.GetID()) {
+			firstLabeled = event
+		}
+	}
+	if firstLabeled == nil {
+		return fmt.Errorf("failed to re-read in-progress label: no record of it being added")
+	}
+	if firstLabeled.GetActor().GetLogin() != b.user.GetLogin() {
+		return errIssueLocked
+	}
+	return nil
+}
+
+// releaseIssueLock removes the in-progress label from an issue
+func (b *Bot) releaseIssueLock(ctx context.Context, issue task.GithubIssue) error {
+	if err := b.removeIssueLabel(ctx, issue, task.LabelWorking); err != nil {
+		return fmt.Errorf("failed to remove in-progress label: %w", err)
+	}
+	return nil
+}
+
+// removeIssueLabel removes a label from an issue, unless in dry-run mode
+func (b *Bot) removeIssueLabel(ctx context.Context, issue task.GithubIssue, label github.Label) error {
+	if b.dryRun {
+		logging.FromContext(ctx).Info("Dry run: skipping label removal", "label", label.GetName())
+		return nil
+	}
+	return removeLabel(ctx, b.vcs, issue, label)
 }
 
 // Label management functions
 
-// addLabel adds a label to an issue
-func addLabel(ctx context.Context, issuesService *github.IssuesService, issue task.GithubIssue, label github.Label) error {
+// addLabel adds a label to an issue, creating the label in the repository if necessary
+func addLabel(ctx context.Context, provider vcs.Provider, issue task.GithubIssue, label github.Label) error {
 	if label.Name == nil {
 		return fmt.Errorf("cannot add label with nil name")
 	}
-	if err := ensureLabelExists(ctx, issuesService, issue.Owner, issue.Repo, label); err != nil {
-		log.Printf("Warning: Could not ensure label exists: %v", err)
+	vcsLabel := vcs.Label{Name: label.GetName(), Description: label.GetDescription(), Color: label.GetColor()}
+	if err := provider.EnsureLabel(ctx, issue.Owner, issue.Repo, vcsLabel); err != nil {
+		logging.FromContext(ctx).Warn("could not ensure label exists", "label", *label.Name, "error", err)
 	}
 
-	labels := []string{*label.Name}
-	_, _, err := issuesService.AddLabelsToIssue(ctx, issue.Owner, issue.Repo, issue.Number, labels)
-	return err
+	return provider.AddLabels(ctx, issue.Owner, issue.Repo, issue.Number, []string{*label.Name})
 }
 
 // removeLabel removes a label from an issue, if present
-func removeLabel(ctx context.Context, issuesService *github.IssuesService, issue task.GithubIssue, label github.Label) error {
+func removeLabel(ctx context.Context, provider vcs.Provider, issue task.GithubIssue, label github.Label) error {
 	if label.Name == nil {
 		return fmt.Errorf("cannot remove label with nil name")
 	}
-	resp, err := issuesService.RemoveLabelForIssue(ctx, issue.Owner, issue.Repo, issue.Number, *label.Name)
-	if err != nil && resp.StatusCode == http.StatusNotFound {
+	err := provider.RemoveLabel(ctx, issue.Owner, issue.Repo, issue.Number, *label.Name)
+	if errors.Is(err, vcs.ErrNotFound) {
 		// If the label isn't present, ignore the error
 		return nil
 	}
 	return err
 }
 
-// ensureLabelExists creates a label if it doesn't exist
-func ensureLabelExists(ctx context.Context, issuesService *github.IssuesService, owner, repo string, label github.Label) error {
-	if label.Name == nil {
-		return fmt.Errorf("nil label name")
-	}
-	_, _, err := issuesService.GetLabel(ctx, owner, repo, *label.Name)
-	if err == nil {
-		return nil
-	}
+// Utility functions
 
-	_, _, err = issuesService.CreateLabel(ctx, owner, repo, &label)
-	return err
-}
+const (
+	// toolResultKeepTurns is the number of most recent turns whose tool results are always sent to the AI in full
+	toolResultKeepTurns = 5
+	// toolResultMaxChars is the length beyond which older tool results are replaced with a placeholder
+	toolResultMaxChars = 2000
+)
 
-// Utility functions
+// NewOutputFilter returns the filter that shrinks what is sent to the AI: superseded file views are compacted first, so
+// that truncation only applies to what's left
+func NewOutputFilter() ai.OutputFilter {
+	return ai.ChainOutputFilters(
+		ai.NewSupersededViewFilter(),
+		ai.NewToolResultTruncationFilter(toolResultKeepTurns, toolResultMaxChars),
+	)
+}
 
-// initConversation either constructs a new conversation or resumes a previous conversation
-func (b *Bot) initConversation(ctx context.Context, tsk task.Task, toolCtx *ToolContext) (*ai.Conversation, *anthropic.Message, error) {
+// initConversation either constructs a new conversation or resumes a previous conversation. Returns the latest response
+// in the conversation, and whether it was sent for in this call rather than taken from the previous conversation
+func (b *Bot) initConversation(ctx context.Context, tsk task.Task, toolCtx *ToolContext) (*ai.Conversation, *anthropic.Message, bool, error) {
 	model := anthropic.ModelClaudeSonnet4_5
+	if tsk.Directives.Model != "" {
+		model = anthropic.Model(tsk.Directives.Model)
+	}
 	var maxTokens int64 = 64000
 
 	tools := b.toolRegistry.GetAllToolParams()
+	if tsk.NeedsPlanApproval() {
+		// Don't offer tools that change code until a plan is approved, except to read files with
+		tools = slices.DeleteFunc(tools, func(tool anthropic.ToolParam) bool { return !isOfferedWhilePlanning(tool.Name) })
+	}
 
 	var history *ai.ConversationHistory
 	if b.resumableConversations != nil {
 		// Check if there is a resumable conversation for this task
 		var err error
-		history, err = b.resumableConversations.Get(strconv.Itoa(tsk.Issue.Number))
+		history, err = b.resumableConversations.Get(HistoryKey(tsk.Issue))
 		if err != nil {
-			return nil, nil, fmt.Errorf("failed to look up resumable conversation by issue number: %w", err)
+			return nil, nil, false, fmt.Errorf("failed to look up resumable conversation by issue number: %w", err)
 		}
 	}
 
 	if history != nil {
 		return b.resumeConversation(ctx, *history, model, maxTokens, tools, toolCtx)
 	} else {
-		return b.newConversation(ctx, tsk, model, maxTokens, tools)
+		conv, response, err := b.newConversation(ctx, tsk, model, maxTokens, tools, toolCtx.Usage)
+		return conv, response, true, err
 	}
 }
 
@@ -424,39 +1363,75 @@ func (b *Bot) resumeConversation(
 	maxTokens int64,
 	tools []anthropic.ToolParam,
 	toolCtx *ToolContext,
-) (*ai.Conversation, *anthropic.Message, error) {
+) (*ai.Conversation, *anthropic.Message, bool, error) {
 	conv, err := ai.ResumeConversation(b.sender, history, model, maxTokens, tools)
 	if err != nil {
-		return nil, nil, fmt.Errorf("failed to resume conversation: %w", err)
+		return nil, nil, false, fmt.Errorf("failed to resume conversation: %w", err)
 	}
+	conv.TrackUsage(toolCtx.Usage)
+	conv.SetOutputFilter(NewOutputFilter())
 
 	err = b.rerunStatefulToolCalls(ctx, toolCtx, conv)
 	if err != nil {
-		return nil, nil, fmt.Errorf("failed to rerun stateful tool calls: %w", err)
+		return nil, nil, false, fmt.Errorf("failed to rerun stateful tool calls: %w", err)
 	}
 
 	// Extract the last message of the resumed conversation. If tool uses are already resolved, send the next
 	// message. Otherwise, return the response from the last message
 	var response *anthropic.Message
-	if len(conv.GetPendingToolUses()) == 0 {
-		log.Printf("Resuming previous conversation from a completed turn - sending next message")
+	if awaitingHumanInput(conv) {
+		logging.FromContext(ctx).Info("Resuming previous conversation after a question to a human - sending the task's current state")
+		if err := b.removeIssueLabel(ctx, toolCtx.Task.Issue, task.LabelNeedsHuman); err != nil {
+			return nil, nil, false, fmt.Errorf("failed to remove needs-human label: %w", err)
+		}
+
+		// Send the task again, since it includes the human's reply
+		_, taskContent, err := buildPrompt(toolCtx.Task)
+		if err != nil {
+			return nil, nil, false, fmt.Errorf("failed to build prompt: %w", err)
+		}
+		intro := "A human has responded since you asked your question."
+		if plan := toolCtx.Task.Plan; plan.Approved && !plan.Started {
+			intro = "A human has approved your plan, so you may now carry it out."
+		}
+		r, err := conv.SendMessage(ctx, anthropic.NewTextBlock(intro+" Here is the current state of the task:\n\n"+
+			taskContent))
+		if err != nil {
+			return nil, nil, false, fmt.Errorf("failed to send message: %w", err)
+		}
+		response = r
+	} else if len(conv.GetPendingToolUses()) == 0 {
+		logging.FromContext(ctx).Info("Resuming previous conversation from a completed turn - sending next message")
 		r, err := conv.SendMessage(ctx)
 		if err != nil {
-			return nil, nil, fmt.Errorf("failed to send message: %w", err)
+			return nil, nil, false, fmt.Errorf("failed to send message: %w", err)
 		}
 		response = r
 	} else {
-		log.Printf("Resuming previous conversation from an incomplete turn - returning previous response")
+		logging.FromContext(ctx).Info("Resuming previous conversation from an incomplete turn - returning previous response")
 
-		// We should be careful here. Assistant message handling is not necessarily idempotent, e.g. if the bot
-		// sends a message with two tool calls and we get through one of them before encountering an error with the
-		// second, the handling of the first tool call may have had side effects that would be damaging to repeat.
-		// Consider implementing transactions with rollback for parallel tool calls.
+		// Tool calls in this turn that already have results were replayed above, so the caller only runs the pending
+		// ones. Running a completed call again could repeat side effects, e.g. posting the same comment twice
 
 		lastTurn := conv.Turns[len(conv.Turns)-1]
-		response = lastTurn.Response
+		return conv, lastTurn.Response, false, nil
+	}
+	return conv, response, true, nil
+}
+
+// awaitingHumanInput returns true if the conversation's last turn ended with a question to a human, or a plan for them
+// to approve
+func awaitingHumanInput(conv *ai.Conversation) bool {
+	if len(conv.Turns) == 0 || len(conv.GetPendingToolUses()) > 0 {
+		return false
 	}
-	return conv, response, nil
+	for _, exchange := range conv.Turns[len(conv.Turns)-1].ToolExchanges {
+		name := exchange.UseBlock.Name
+		if (name == NewAwaitHumanInputTool().Name || name == NewPostPlanTool().Name) && !exchange.ResultBlock.IsError.Value {
+			return true
+		}
+	}
+	return false
 }
 
 func (b *Bot) newConversation(
@@ -465,44 +1440,64 @@ func (b *Bot) newConversation(
 	model anthropic.Model,
 	maxTokens int64,
 	tools []anthropic.ToolParam,
+	usage *ai.UsageTracker,
 ) (*ai.Conversation, *anthropic.Message, error) {
-	systemPrompt, err := buildSystemPrompt("Blundering Savant", *b.user.Login)
-	if err != nil {
-		return nil, nil, fmt.Errorf("failed to build system prompt: %w", err)
+	systemPrompt := b.systemPromptOverride
+	if systemPrompt == "" {
+		var err error
+		systemPrompt, err = buildSystemPrompt("Blundering Savant", *b.user.Login, b.dryRun)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to build system prompt: %w", err)
+		}
+	} else if b.dryRun {
+		// The built-in prompt warns the AI that it's in a dry run, but an operator's replacement can't be relied on to
+		systemPrompt = strings.TrimRight(systemPrompt, "\n") + "\n\n" + dryRunNotice
+	}
+	if b.systemPromptAppendix != "" {
+		// The appendix goes after the base prompt, which is the same for every conversation, so that the two can share
+		// a cached prefix
+		systemPrompt = strings.TrimRight(systemPrompt, "\n") + "\n\n" + b.systemPromptAppendix
 	}
 
 	c := ai.NewConversation(b.sender, model, maxTokens, tools, systemPrompt)
+	c.TrackUsage(usage)
+	c.SetOutputFilter(NewOutputFilter())
+	if err := c.Seed(b.seedTurns); err != nil {
+		return nil, nil, fmt.Errorf("failed to seed conversation: %w", err)
+	}
 
-	log.Printf("Sending initial message to AI")
+	logging.FromContext(ctx).Info("Sending initial message to AI")
 	repositoryContent, taskContent, err := buildPrompt(tsk)
 	if err != nil {
 		return nil, nil, fmt.Errorf("failed to build prompt: %w", err)
 	}
 
-	// Send repository content as cacheable block, followed by task-specific content
-	repositoryBlock := anthropic.NewTextBlock(repositoryContent)
+	// Send repository content as a cache point, followed by task-specific content. The repository content is large and
+	// repeated in every turn, so caching it saves most of the input cost of later turns
+	repositoryBlock := ai.WithCachePoint(anthropic.NewTextBlock(repositoryContent))
 	taskBlock := anthropic.NewTextBlock(taskContent)
+	blocks := append([]anthropic.ContentBlockParamUnion{repositoryBlock, taskBlock}, buildImageBlocks(tsk.Images)...)
 
-	response, err := c.SendMessage(ctx, repositoryBlock, taskBlock)
+	response, err := c.SendMessage(ctx, blocks...)
 	if err != nil {
 		return nil, nil, fmt.Errorf("failed to send initial message to AI: %w", err)
 	}
 	return c, response, nil
 }
 
+// rerunStatefulToolCalls replays every tool call in the conversation that has a result, restoring the local state that
+// those calls built up. Calls without a result, which can only be in the last turn, were never completed and are left
+// for the caller to run
 func (b *Bot) rerunStatefulToolCalls(ctx context.Context, toolCtx *ToolContext, conversation *ai.Conversation) error {
-	for turnNumber, turn := range conversation.Turns {
-		if turnNumber == len(conversation.Turns)-1 {
-			// Skip the last message in the conversation, since this message was not previously handled
-			break
-		}
-		for _, block := range turn.Response.Content {
-			switch toolUseBlock := block.AsAny().(type) {
-			case anthropic.ToolUseBlock:
-				err := b.toolRegistry.ReplayToolUse(ctx, toolUseBlock, toolCtx)
-				if err != nil {
-					return err
-				}
+	// Seeded turns are examples, not changes to this task's workspace
+	for _, turn := range conversation.Turns[conversation.SeedTurns():] {
+		for _, exchange := range turn.ToolExchanges {
+			if exchange.ResultBlock == nil {
+				continue
+			}
+			err := b.toolRegistry.ReplayToolUse(ctx, exchange, toolCtx)
+			if err != nil {
+				return err
 			}
 		}
 	}
@@ -575,8 +1570,8 @@ func summarize(ctx context.Context, conversation *ai.Conversation, keepFirst int
 	// Technically a summary could be constructed with keepFirst+keepLast+1 messages, but then all of the messages from the
 	// original conversation would be in the summarized conversation, rendering the summary useless
 	if minTurns := keepFirst + keepLast + 2; len(conversation.Turns) < minTurns {
-		log.Printf("Warning: Conversation has %d turns, but at least %d are required for summarization. Skipping summarization",
-			len(conversation.Turns), minTurns)
+		logging.FromContext(ctx).Warn("Conversation is too short to summarize. Skipping summarization",
+			"turns", len(conversation.Turns), "min_turns", minTurns)
 		return nil
 	}
 
@@ -589,8 +1584,8 @@ func summarize(ctx context.Context, conversation *ai.Conversation, keepFirst int
 				lastMsg.Response.Usage.CacheCreationInputTokens
 		}
 
-		log.Printf("    Conversation has %d messages and %d total input tokens, summarizing...",
-			len(conversation.Turns), totalTokens)
+		logging.FromContext(ctx).Info("    Conversation is too long, summarizing...",
+			"turns", len(conversation.Turns), "input_tokens", totalTokens)
 	}
 
 	// Generate a summary of the conversation with AI
@@ -613,8 +1608,8 @@ func summarize(ctx context.Context, conversation *ai.Conversation, keepFirst int
 	}...)
 	summarizedTurns = append(summarizedTurns, conversation.Turns[len(conversation.Turns)-keepLast:]...)
 
-	log.Printf("    Conversation summarized: %d messages -> %d messages",
-		len(conversation.Turns), len(summarizedTurns))
+	logging.FromContext(ctx).Info("    Conversation summarized",
+		"turns_before", len(conversation.Turns), "turns_after", len(summarizedTurns))
 
 	// Update the conversation
 	conversation.Turns = summarizedTurns
@@ -238,7 +238,7 @@ func TestBotReactsToCommentsUsingParallelToolCalls(t *testing.T) {
 	tsk.PRCommentsRequiringResponses = tsk.PRComments
 
 	// Use the actual tool registry
-	toolRegistry := NewToolRegistry()
+	toolRegistry := NewToolRegistry(ToolFilter{})
 
 	repoPrompt, taskPrompt, err := buildPrompt(tsk)
 	require.NoError(t, err)
@@ -351,7 +351,7 @@ func TestBotRejectsDangerousSuggestions(t *testing.T) {
 	}
 
 	// Use the actual tool registry
-	toolRegistry := NewToolRegistry()
+	toolRegistry := NewToolRegistry(ToolFilter{})
 
 	repoPrompt, taskPrompt, err := buildPrompt(tsk)
 	require.NoError(t, err)
@@ -455,7 +455,7 @@ func TestBotDoesNotRedundantlyExploreRepository(t *testing.T) {
 	}
 
 	// Use the actual tool registry
-	toolRegistry := NewToolRegistry()
+	toolRegistry := NewToolRegistry(ToolFilter{})
 
 	repoPrompt, taskPrompt, err := buildPrompt(tsk)
 	require.NoError(t, err)
@@ -494,7 +494,7 @@ func newTestConversation(t *testing.T, toolRegistry ToolRegistry, previousMessag
 	)
 	sender := ai.NewStreamingMessageSender(anthropicClient)
 
-	systemPrompt, err := buildSystemPrompt("Blundering Savant", "blunderingsavant")
+	systemPrompt, err := buildSystemPrompt("Blundering Savant", "blunderingsavant", false)
 	require.NoError(t, err)
 
 	history := ai.ConversationHistory{
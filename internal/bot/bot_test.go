@@ -1,14 +1,35 @@
 package bot
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/anthropics/anthropic-sdk-go"
 	anthropt "github.com/anthropics/anthropic-sdk-go/option"
 	"github.com/cchalm/blundering-savant/internal/ai"
+	"github.com/cchalm/blundering-savant/internal/logging"
+	"github.com/cchalm/blundering-savant/internal/metrics"
+	"github.com/cchalm/blundering-savant/internal/task"
+	"github.com/cchalm/blundering-savant/internal/validator"
+	"github.com/cchalm/blundering-savant/internal/workspace"
+	"github.com/google/go-github/v72/github"
 	"github.com/stretchr/testify/require"
 )
 
@@ -194,6 +215,87 @@ func testSummarize(
 	require.Equal(t, expectedTurns, conversation.Turns)
 }
 
+func TestSendMessage_SeedTurnsSurviveSummarization(t *testing.T) {
+	sender := senderStub{response: newAnthropicResponse(t, summary)}
+	conversation := ai.NewConversation(sender, anthropic.ModelClaudeSonnet4_5, 10000, nil, "some system prompt")
+	require.NoError(t, conversation.Seed([]ai.ConversationTurn{turn(t, 1), turn(t, 2)}))
+
+	ctx := context.Background()
+	for i := range 12 {
+		_, err := conversation.SendMessage(ctx, anthropic.NewTextBlock(fmt.Sprintf("user message %d", i+3)))
+		require.NoError(t, err)
+	}
+	require.Equal(t, "user message 1", conversation.Turns[0].Instructions[0].OfText.Text)
+
+	// A negative token limit forces summarization
+	_, err := sendMessage(ctx, conversation, -1, 0, metrics.Noop{})
+	require.NoError(t, err)
+
+	require.Equal(t, 2, conversation.SeedTurns())
+	require.Equal(t, "user message 1", conversation.Turns[0].Instructions[0].OfText.Text)
+	require.Equal(t, "user message 2", conversation.Turns[1].Instructions[0].OfText.Text)
+	require.Equal(t, []anthropic.ContentBlockParamUnion{repeatSummaryRequest}, conversation.Turns[2].Instructions)
+}
+
+// newLongConversation creates a conversation with the given number of turns, each of which reportedly used few tokens
+func newLongConversation(t *testing.T, turns int) *ai.Conversation {
+	response := newAnthropicResponse(t, summary)
+	response.Usage = anthropic.Usage{InputTokens: 10, OutputTokens: 10}
+	conversation := ai.NewConversation(senderStub{response: response}, anthropic.ModelClaudeSonnet4_5, 10000, nil, "some system prompt")
+	for i := range turns {
+		_, err := conversation.SendMessage(context.Background(), anthropic.NewTextBlock(fmt.Sprintf("user message %d", i+1)))
+		require.NoError(t, err)
+	}
+	return conversation
+}
+
+func TestSendMessage_TurnLimitForcesSummarization(t *testing.T) {
+	ctx := context.Background()
+	conversation := newLongConversation(t, 20)
+
+	// The reported token usage is far below the limit, but the conversation has too many turns
+	_, err := sendMessage(ctx, conversation, 100000, 15, metrics.Noop{})
+	require.NoError(t, err)
+	require.Equal(t, []anthropic.ContentBlockParamUnion{repeatSummaryRequest}, conversation.Turns[0].Instructions)
+	require.Len(t, conversation.Turns, 13, "the summary exchange and the last 10 turns, plus the new one")
+}
+
+func TestSendMessage_UnderTurnLimitNotSummarized(t *testing.T) {
+	ctx := context.Background()
+	conversation := newLongConversation(t, 20)
+
+	_, err := sendMessage(ctx, conversation, 100000, 100, metrics.Noop{})
+	require.NoError(t, err)
+	require.Len(t, conversation.Turns, 21)
+}
+
+// testLogTurnUsage logs the given usage with a debug-level logger and returns the log output
+func testLogTurnUsage(usage anthropic.Usage, expectCacheHit bool) string {
+	var buf bytes.Buffer
+	ctx := logging.WithLogger(context.Background(), logging.NewTextLogger(log.New(&buf, "", 0), slog.LevelDebug))
+	logTurnUsage(ctx, usage, expectCacheHit)
+	return buf.String()
+}
+
+func TestLogTurnUsage_LowCacheHitWarns(t *testing.T) {
+	out := testLogTurnUsage(anthropic.Usage{InputTokens: 50000, OutputTokens: 800, CacheReadInputTokens: 10}, true)
+	require.Contains(t, out, "input_tokens=50000")
+	require.Contains(t, out, "output_tokens=800")
+	require.Contains(t, out, "Low cache hit rate")
+}
+
+func TestLogTurnUsage_HighCacheHitDoesNotWarn(t *testing.T) {
+	out := testLogTurnUsage(anthropic.Usage{InputTokens: 500, OutputTokens: 800, CacheReadInputTokens: 48000, CacheCreationInputTokens: 1500}, true)
+	require.Contains(t, out, "cache_read_tokens=48000")
+	require.NotContains(t, out, "Low cache hit rate")
+}
+
+func TestLogTurnUsage_NoCacheHitExpected(t *testing.T) {
+	// e.g. right after summarization, which rewrites the history
+	out := testLogTurnUsage(anthropic.Usage{InputTokens: 50000, OutputTokens: 800}, false)
+	require.NotContains(t, out, "Low cache hit rate")
+}
+
 type senderStub struct {
 	response *anthropic.Message
 }
@@ -235,3 +337,1806 @@ func newAnthropicResponse(t *testing.T, content ...anthropic.ContentBlockParamUn
 
 	return &msg
 }
+
+// toolUseSenderStub responds to every message with a request to use the view_diff tool, so the conversation never ends
+type toolUseSenderStub struct {
+	t     *testing.T
+	calls *int
+	tool  string // The tool to call. Defaults to view_diff
+}
+
+func (tss toolUseSenderStub) SendMessage(_ context.Context, _ anthropic.MessageNewParams, _ ...anthropt.RequestOption) (*anthropic.Message, error) {
+	*tss.calls++
+	tool := tss.tool
+	if tool == "" {
+		tool = "view_diff"
+	}
+	msgJSON := fmt.Sprintf(`{
+		"id": "msg_%[1]d",
+		"type": "message",
+		"role": "assistant",
+		"model": "claude-sonnet-4-5",
+		"content": [{"type": "tool_use", "id": "toolu_%[1]d", "name": %[2]q, "input": {}}],
+		"stop_reason": "tool_use",
+		"usage": {"input_tokens": 10, "output_tokens": 10}
+	}`, *tss.calls, tool)
+
+	var msg anthropic.Message
+	require.NoError(tss.t, json.Unmarshal([]byte(msgJSON), &msg))
+	return &msg, nil
+}
+
+// fakeWorkspace is a Workspace backed by an in-memory map of files, with no unpublished changes. Methods that aren't
+// overridden panic
+type fakeWorkspace struct {
+	Workspace
+
+	files map[string]string
+}
+
+func (fw fakeWorkspace) Read(_ context.Context, path string) (string, error) {
+	content, ok := fw.files[path]
+	if !ok {
+		return "", workspace.ErrFileNotFound
+	}
+	return content, nil
+}
+
+func (fw fakeWorkspace) Write(_ context.Context, path string, content string) error {
+	fw.files[path] = content
+	return nil
+}
+
+func (fw fakeWorkspace) FileExists(_ context.Context, path string) (bool, error) {
+	_, ok := fw.files[path]
+	return ok, nil
+}
+
+func (fw fakeWorkspace) IsDir(_ context.Context, path string) (bool, error) {
+	prefix := strings.TrimSuffix(path, "/") + "/"
+	for p := range fw.files {
+		if strings.HasPrefix(p, prefix) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (fw fakeWorkspace) ListDir(_ context.Context, dir string) ([]string, error) {
+	if _, ok := fw.files[dir]; ok {
+		return nil, workspace.ErrIsFile
+	}
+	prefix := ""
+	if dir != "" {
+		prefix = strings.TrimSuffix(dir, "/") + "/"
+	}
+	names := []string{}
+	for p := range fw.files {
+		rest, ok := strings.CutPrefix(p, prefix)
+		if !ok {
+			continue
+		}
+		if subdir, _, nested := strings.Cut(rest, "/"); nested {
+			rest = subdir + "/"
+		}
+		if !slices.Contains(names, rest) {
+			names = append(names, rest)
+		}
+	}
+	if len(names) == 0 {
+		return nil, workspace.ErrFileNotFound
+	}
+	return names, nil
+}
+
+func (fw fakeWorkspace) Delete(_ context.Context, path string) error {
+	if _, ok := fw.files[path]; !ok {
+		return workspace.ErrFileNotFound
+	}
+	delete(fw.files, path)
+	return nil
+}
+
+func (fw fakeWorkspace) Diff(context.Context, string) (string, error) { return "", nil }
+func (fw fakeWorkspace) SyncWithBase(context.Context) (validator.ValidationResult, error) {
+	return validator.ValidationResult{Succeeded: true}, nil
+}
+func (fw fakeWorkspace) HasUnpublishedChanges(context.Context) (bool, error) {
+	return false, nil
+}
+func (fw fakeWorkspace) ValidateChanges(context.Context, *string) (validator.ValidationResult, error) {
+	return validator.ValidationResult{Succeeded: true}, nil
+}
+
+type fakeWorkspaceFactory struct{}
+
+func (fwf fakeWorkspaceFactory) NewWorkspace(context.Context, task.Task) (Workspace, error) {
+	return fakeWorkspace{}, nil
+}
+
+// fakeLockEvents serves the events in which the in-progress label was added to and removed from issues, recording an
+// event each time a request adds or removes it
+type fakeLockEvents struct {
+	mu     sync.Mutex
+	nextID int64
+	events map[string][]*github.IssueEvent // By the path of the issue
+	// racer, if not empty, is the login of another process that adds the label to an issue moments before the bot does,
+	// making the bot's addition a no-op
+	racer       string
+	failRereads bool // Fail requests to list an issue's events after the first
+	lists       int
+}
+
+// lockEvent returns an event in which the given user added the in-progress label the given time ago
+func lockEvent(id int64, login string, age time.Duration) *github.IssueEvent {
+	return &github.IssueEvent{
+		ID:        github.Ptr(id),
+		Event:     github.Ptr("labeled"),
+		Label:     &github.Label{Name: github.Ptr("bot-working")},
+		Actor:     &github.User{Login: github.Ptr(login)},
+		CreatedAt: &github.Timestamp{Time: time.Now().Add(-age)},
+	}
+}
+
+// record adds an event in which the given user added or removed the in-progress label, unless that changes nothing
+func (fle *fakeLockEvents) record(issuePath string, event string, login string) {
+	labeled := task.WorkingLabelAdded(fle.events[issuePath]) != nil
+	if labeled == (event == "labeled") {
+		return
+	}
+	fle.nextID = max(fle.nextID, 1000) + 1
+	added := lockEvent(fle.nextID, login, 0)
+	added.Event = github.Ptr(event)
+	if fle.events == nil {
+		fle.events = map[string][]*github.IssueEvent{}
+	}
+	fle.events[issuePath] = append(fle.events[issuePath], added)
+}
+
+// withLockEvents returns a handler that serves events from the given fake, records the in-progress label being added
+// and removed, and passes other requests, including those changing labels, to next
+func withLockEvents(t *testing.T, fle *fakeLockEvents, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		issuePath, rest, isLabels := strings.Cut(r.URL.Path, "/labels")
+		switch {
+		case r.Method == http.MethodGet && strings.Contains(r.URL.Path, "/issues/") && strings.HasSuffix(r.URL.Path, "/events"):
+			fle.mu.Lock()
+			defer fle.mu.Unlock()
+			fle.lists++
+			w.Header().Set("Content-Type", "application/json")
+			if fle.failRereads && fle.lists > 1 {
+				w.WriteHeader(http.StatusInternalServerError)
+				_, _ = w.Write([]byte(`{"message": "Server Error"}`))
+				return
+			}
+			events := fle.events[strings.TrimSuffix(r.URL.Path, "/events")]
+			if events == nil {
+				events = []*github.IssueEvent{}
+			}
+			require.NoError(t, json.NewEncoder(w).Encode(events))
+		case r.Method == http.MethodPost && strings.Contains(issuePath, "/issues/") && isLabels && rest == "":
+			body, err := io.ReadAll(r.Body)
+			require.NoError(t, err)
+			var labels []string
+			require.NoError(t, json.Unmarshal(body, &labels))
+			if slices.Contains(labels, "bot-working") {
+				fle.mu.Lock()
+				if fle.racer != "" {
+					fle.record(issuePath, "labeled", fle.racer)
+				}
+				fle.record(issuePath, "labeled", "bot")
+				fle.mu.Unlock()
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+			next.ServeHTTP(w, r)
+		case r.Method == http.MethodDelete && isLabels && rest == "/bot-working":
+			fle.mu.Lock()
+			fle.record(issuePath, "unlabeled", "bot")
+			fle.mu.Unlock()
+			next.ServeHTTP(w, r)
+		default:
+			next.ServeHTTP(w, r)
+		}
+	})
+}
+
+// newCommentRecordingGithubClient returns a GitHub client backed by a fake server that accepts label changes and
+// records the bodies of posted issue comments
+func newCommentRecordingGithubClient(t *testing.T, comments *[]string) *github.Client {
+	var mu sync.Mutex
+	return newTestGithubClient(t, withLockEvents(t, &fakeLockEvents{}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case r.Method == http.MethodPost && strings.HasSuffix(r.URL.Path, "/comments"):
+			var comment github.IssueComment
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&comment))
+			mu.Lock()
+			*comments = append(*comments, comment.GetBody())
+			mu.Unlock()
+			_, _ = w.Write([]byte(`{}`))
+		case strings.Contains(r.URL.Path, "/issues/") &&
+			(strings.HasSuffix(r.URL.Path, "/labels") || strings.HasSuffix(r.URL.Path, "/events")):
+			_, _ = w.Write([]byte(`[]`))
+		default:
+			_, _ = w.Write([]byte(`{}`))
+		}
+	})))
+}
+
+// githubActivity records the changes requested of a fake GitHub server created by newActivityRecordingGithubClient
+type githubActivity struct {
+	comments      []string // The bodies of posted comments
+	reactions     []string // The paths that reactions were posted to, each followed by the reaction
+	addedLabels   []string
+	removedLabels []string
+}
+
+// newActivityRecordingGithubClient returns a GitHub client backed by a fake server that accepts comments, reactions,
+// and label changes, and records them. The issue has no labels or events
+func newActivityRecordingGithubClient(t *testing.T) (*github.Client, *githubActivity) {
+	var (
+		mu       sync.Mutex
+		activity githubActivity
+	)
+	githubClient := newTestGithubClient(t, withLockEvents(t, &fakeLockEvents{}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		mu.Lock()
+		defer mu.Unlock()
+		switch {
+		case r.Method == http.MethodPost && strings.HasSuffix(r.URL.Path, "/reactions"):
+			var reaction github.Reaction
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&reaction))
+			activity.reactions = append(activity.reactions, r.URL.Path+" "+reaction.GetContent())
+			_, _ = w.Write([]byte(`{}`))
+		case r.Method == http.MethodPost && strings.HasSuffix(r.URL.Path, "/comments"):
+			var comment github.IssueComment
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&comment))
+			activity.comments = append(activity.comments, comment.GetBody())
+			_, _ = w.Write([]byte(`{}`))
+		case r.Method == http.MethodPost && strings.Contains(r.URL.Path, "/issues/") && strings.HasSuffix(r.URL.Path, "/labels"):
+			var labels []string
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&labels))
+			activity.addedLabels = append(activity.addedLabels, labels...)
+			_, _ = w.Write([]byte(`[]`))
+		case r.Method == http.MethodDelete && strings.Contains(r.URL.Path, "/issues/"):
+			activity.removedLabels = append(activity.removedLabels, r.URL.Path[strings.LastIndex(r.URL.Path, "/")+1:])
+			w.WriteHeader(http.StatusNoContent)
+		case strings.HasSuffix(r.URL.Path, "/labels") || strings.HasSuffix(r.URL.Path, "/events"):
+			_, _ = w.Write([]byte(`[]`))
+		default:
+			_, _ = w.Write([]byte(`{}`))
+		}
+	})))
+	return githubClient, &activity
+}
+
+func TestDoTask_MaxIterations(t *testing.T) {
+	// processWithAI writes conversation logs to the working directory
+	t.Chdir(t.TempDir())
+
+	var comments []string
+	calls := 0
+	b := New(
+		newCommentRecordingGithubClient(t, &comments),
+		&github.User{Login: github.Ptr("bot")},
+		toolUseSenderStub{t: t, calls: &calls},
+		nil,
+		fakeWorkspaceFactory{},
+		Config{MaxIterations: 3},
+	)
+
+	tsk := task.Task{Issue: task.GithubIssue{Owner: "owner", Repo: "repo", Number: 1}}
+	_, err := b.DoTask(context.Background(), tsk)
+	require.ErrorAs(t, err, &iterationLimitError{})
+
+	// One initial message, plus one response to each iteration's tool use
+	require.Equal(t, 4, calls)
+	require.Len(t, comments, 1)
+	require.Contains(t, comments[0], "gave up on this issue after 3 iterations")
+}
+
+// expensiveSenderStub responds to every message with a progress note and a request to use the view_diff tool. Each
+// response costs $1.50 at Claude Sonnet 4.5 prices
+type expensiveSenderStub struct {
+	t     *testing.T
+	calls *int
+}
+
+func (ess expensiveSenderStub) SendMessage(_ context.Context, _ anthropic.MessageNewParams, _ ...anthropt.RequestOption) (*anthropic.Message, error) {
+	*ess.calls++
+	msgJSON := fmt.Sprintf(`{
+		"id": "msg_%[1]d",
+		"type": "message",
+		"role": "assistant",
+		"model": "claude-sonnet-4-5",
+		"content": [
+			{"type": "text", "text": "Step %[1]d done"},
+			{"type": "tool_use", "id": "toolu_%[1]d", "name": "view_diff", "input": {}}
+		],
+		"stop_reason": "tool_use",
+		"usage": {"input_tokens": 0, "output_tokens": 100000}
+	}`, *ess.calls)
+
+	var msg anthropic.Message
+	require.NoError(ess.t, json.Unmarshal([]byte(msgJSON), &msg))
+	return &msg, nil
+}
+
+// mapHistoryStore is an in-memory ConversationHistoryStore
+type mapHistoryStore map[string]ai.ConversationHistory
+
+func (mhs mapHistoryStore) Get(key string) (*ai.ConversationHistory, error) {
+	history, ok := mhs[key]
+	if !ok {
+		return nil, nil
+	}
+	return &history, nil
+}
+
+func (mhs mapHistoryStore) Set(key string, value ai.ConversationHistory) error {
+	mhs[key] = value
+	return nil
+}
+
+func (mhs mapHistoryStore) Delete(key string) error {
+	delete(mhs, key)
+	return nil
+}
+
+func TestDoTask_BudgetExceeded(t *testing.T) {
+	// processWithAI writes conversation logs to the working directory
+	t.Chdir(t.TempDir())
+
+	var (
+		mu          sync.Mutex
+		comments    []string
+		addedLabels []string
+	)
+	githubClient := newTestGithubClient(t, withLockEvents(t, &fakeLockEvents{}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		mu.Lock()
+		defer mu.Unlock()
+		switch {
+		case r.Method == http.MethodPost && strings.HasSuffix(r.URL.Path, "/comments"):
+			var comment github.IssueComment
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&comment))
+			comments = append(comments, comment.GetBody())
+			_, _ = w.Write([]byte(`{}`))
+		case r.Method == http.MethodPost && strings.Contains(r.URL.Path, "/issues/") && strings.HasSuffix(r.URL.Path, "/labels"):
+			var labels []string
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&labels))
+			addedLabels = append(addedLabels, labels...)
+			_, _ = w.Write([]byte(`[]`))
+		case strings.HasSuffix(r.URL.Path, "/labels") || strings.HasSuffix(r.URL.Path, "/events"):
+			_, _ = w.Write([]byte(`[]`))
+		default:
+			_, _ = w.Write([]byte(`{}`))
+		}
+	})))
+
+	calls := 0
+	historyStore := mapHistoryStore{}
+	b := New(
+		githubClient,
+		&github.User{Login: github.Ptr("bot")},
+		expensiveSenderStub{t: t, calls: &calls},
+		historyStore,
+		fakeWorkspaceFactory{},
+		Config{MaxCostUSD: 4},
+	)
+
+	tsk := task.Task{Issue: task.GithubIssue{Owner: "owner", Repo: "repo", Number: 1}}
+	_, err := b.DoTask(context.Background(), tsk)
+	require.NoError(t, err)
+
+	// The third response brings the spend to $4.50, so the bot stops before running its tool
+	require.Equal(t, 3, calls)
+	require.Contains(t, addedLabels, *task.LabelBlocked.Name)
+	require.Len(t, comments, 1)
+	require.Contains(t, comments[0], "reached its budget of $4.00")
+	require.Contains(t, comments[0], "estimated spend so far: $4.50")
+	require.Contains(t, comments[0], "> Step 3 done")
+
+	// The conversation is kept so that the task can be resumed
+	history, ok := historyStore["owner_repo_1"]
+	require.True(t, ok)
+	require.Len(t, history.Turns, 3)
+}
+
+func TestDoTask_BudgetCountsSpendBeforeResume(t *testing.T) {
+	// processWithAI writes conversation logs to the working directory
+	t.Chdir(t.TempDir())
+
+	var (
+		mu       sync.Mutex
+		comments []string
+	)
+	githubClient := newTestGithubClient(t, withLockEvents(t, &fakeLockEvents{}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		mu.Lock()
+		defer mu.Unlock()
+		switch {
+		case r.Method == http.MethodPost && strings.HasSuffix(r.URL.Path, "/comments"):
+			var comment github.IssueComment
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&comment))
+			comments = append(comments, comment.GetBody())
+			_, _ = w.Write([]byte(`{}`))
+		case strings.HasSuffix(r.URL.Path, "/labels") || strings.HasSuffix(r.URL.Path, "/events"):
+			_, _ = w.Write([]byte(`[]`))
+		default:
+			_, _ = w.Write([]byte(`{}`))
+		}
+	})))
+
+	// Before a restart, the task spent $3.00 on two responses whose tool uses were resolved
+	calls := 0
+	sender := expensiveSenderStub{t: t, calls: &calls}
+	var turns []ai.ConversationTurn
+	for range 2 {
+		response, err := sender.SendMessage(context.Background(), anthropic.MessageNewParams{})
+		require.NoError(t, err)
+		toolUse := response.Content[1].AsToolUse()
+		turns = append(turns, ai.ConversationTurn{
+			Instructions: []anthropic.ContentBlockParamUnion{anthropic.NewTextBlock("go on")},
+			Response:     response,
+			ToolExchanges: []ai.ToolExchange{{
+				UseBlock:    toolUse,
+				ResultBlock: &anthropic.ToolResultBlockParam{ToolUseID: toolUse.ID},
+			}},
+		})
+	}
+	historyStore := mapHistoryStore{"owner_repo_1": ai.ConversationHistory{SystemPrompt: "system prompt", Turns: turns}}
+	calls = 0
+
+	b := New(githubClient, &github.User{Login: github.Ptr("bot")}, sender, historyStore, fakeWorkspaceFactory{},
+		Config{MaxCostUSD: 4})
+
+	tsk := task.Task{Issue: task.GithubIssue{Owner: "owner", Repo: "repo", Number: 1}}
+	_, err := b.DoTask(context.Background(), tsk)
+	require.NoError(t, err)
+
+	// The first response after resuming brings the spend to $4.50
+	require.Equal(t, 1, calls)
+	require.Len(t, comments, 1)
+	require.Contains(t, comments[0], "estimated spend so far: $4.50")
+
+	// Resuming after the handoff starts a fresh budget, which the third response after it exceeds
+	calls = 0
+	_, err = b.DoTask(context.Background(), tsk)
+	require.NoError(t, err)
+	require.Equal(t, 3, calls)
+	require.Len(t, comments, 2)
+	require.Contains(t, comments[1], "estimated spend so far: $4.50")
+}
+
+// systemPromptRecordingSenderStub ends the conversation immediately, recording the system prompt it is sent
+type systemPromptRecordingSenderStub struct {
+	slowSenderStub
+
+	systemPrompts *[]string
+}
+
+func (sprss *systemPromptRecordingSenderStub) SendMessage(ctx context.Context, params anthropic.MessageNewParams, opts ...anthropt.RequestOption) (*anthropic.Message, error) {
+	var texts []string
+	for _, block := range params.System {
+		texts = append(texts, block.Text)
+	}
+	*sprss.systemPrompts = append(*sprss.systemPrompts, strings.Join(texts, ""))
+	return sprss.slowSenderStub.SendMessage(ctx, params, opts...)
+}
+
+func testSystemPrompt(t *testing.T, config Config) string {
+	// processWithAI writes conversation logs to the working directory
+	t.Chdir(t.TempDir())
+
+	var comments, systemPrompts []string
+	b := New(
+		newCommentRecordingGithubClient(t, &comments),
+		&github.User{Login: github.Ptr("bot")},
+		&systemPromptRecordingSenderStub{slowSenderStub: slowSenderStub{t: t}, systemPrompts: &systemPrompts},
+		nil,
+		fakeWorkspaceFactory{},
+		config,
+	)
+
+	tsk := task.Task{Issue: task.GithubIssue{Owner: "owner", Repo: "repo", Number: 1}}
+	_, err := b.DoTask(context.Background(), tsk)
+	require.NoError(t, err)
+	require.Len(t, systemPrompts, 1)
+	return systemPrompts[0]
+}
+
+func TestDoTask_SystemPromptAppendix(t *testing.T) {
+	base, err := buildSystemPrompt("Blundering Savant", "bot", false)
+	require.NoError(t, err)
+
+	systemPrompt := testSystemPrompt(t, Config{SystemPromptAppendix: "Always use conventional commits."})
+	// The base prompt comes first, so that it can be cached
+	require.True(t, strings.HasPrefix(systemPrompt, strings.TrimRight(base, "\n")))
+	require.True(t, strings.HasSuffix(systemPrompt, "\n\nAlways use conventional commits."))
+}
+
+func TestDoTask_SystemPromptOverride(t *testing.T) {
+	systemPrompt := testSystemPrompt(t, Config{
+		SystemPromptOverride: "You are a careful bot.\n",
+		SystemPromptAppendix: "Always use conventional commits.",
+	})
+	require.Equal(t, "You are a careful bot.\n\nAlways use conventional commits.", systemPrompt)
+}
+
+func TestDoTask_SystemPromptOverrideDryRun(t *testing.T) {
+	systemPrompt := testSystemPrompt(t, Config{
+		SystemPromptOverride: "You are a careful bot.\n",
+		SystemPromptAppendix: "Always use conventional commits.",
+		DryRun:               true,
+	})
+	require.Equal(t, "You are a careful bot.\n\n"+dryRunNotice+"\n\nAlways use conventional commits.", systemPrompt)
+}
+
+func TestDoTask_HistoriesKeptPerRepository(t *testing.T) {
+	// processWithAI writes conversation logs to the working directory
+	t.Chdir(t.TempDir())
+
+	// An issue with the same number in another repository has a conversation to resume
+	otherIssue := task.GithubIssue{Owner: "owner", Repo: "other-repo", Number: 1}
+	historyStore := mapHistoryStore{HistoryKey(otherIssue): ai.ConversationHistory{SystemPrompt: "other system prompt"}}
+
+	var comments, systemPrompts []string
+	b := New(
+		newCommentRecordingGithubClient(t, &comments),
+		&github.User{Login: github.Ptr("bot")},
+		&systemPromptRecordingSenderStub{slowSenderStub: slowSenderStub{t: t}, systemPrompts: &systemPrompts},
+		historyStore,
+		fakeWorkspaceFactory{},
+		Config{},
+	)
+
+	tsk := task.Task{Issue: task.GithubIssue{Owner: "owner", Repo: "repo", Number: 1}}
+	_, err := b.DoTask(context.Background(), tsk)
+	require.NoError(t, err)
+
+	// The task started its own conversation, and finishing it left the other one alone
+	require.Len(t, systemPrompts, 1)
+	require.NotEqual(t, "other system prompt", systemPrompts[0])
+	require.Equal(t, mapHistoryStore{"owner_other-repo_1": ai.ConversationHistory{SystemPrompt: "other system prompt"}}, historyStore)
+}
+
+// newLockTestGithubClient returns a GitHub client backed by a fake server whose issues have the in-progress label events
+// in the given fake. Label additions and removals are recorded
+func newLockTestGithubClient(t *testing.T, events *fakeLockEvents, labelRequests *[]labelRequest) *github.Client {
+	var mu sync.Mutex
+	return newTestGithubClient(t, withLockEvents(t, events, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case strings.HasPrefix(r.URL.Path, "/repos/owner/repo/issues/1/labels"):
+			body, err := io.ReadAll(r.Body)
+			require.NoError(t, err)
+			mu.Lock()
+			*labelRequests = append(*labelRequests, labelRequest{method: r.Method, path: r.URL.Path, body: string(body)})
+			mu.Unlock()
+			_, _ = w.Write([]byte(`[]`))
+		default:
+			_, _ = w.Write([]byte(`{}`))
+		}
+	})))
+}
+
+func testIssueLock(t *testing.T, events *fakeLockEvents) (sendCalls int, labelRequests []labelRequest, err error) {
+	// processWithAI writes conversation logs to the working directory
+	t.Chdir(t.TempDir())
+
+	b := New(
+		newLockTestGithubClient(t, events, &labelRequests),
+		&github.User{Login: github.Ptr("bot")},
+		toolUseSenderStub{t: t, calls: &sendCalls},
+		nil,
+		fakeWorkspaceFactory{},
+		Config{MaxIterations: 1},
+	)
+
+	tsk := task.Task{Issue: task.GithubIssue{Owner: "owner", Repo: "repo", Number: 1}}
+	_, err = b.DoTask(context.Background(), tsk)
+	return sendCalls, labelRequests, err
+}
+
+// issue1LockEvents returns a fake whose issue 1 has the given events
+func issue1LockEvents(events ...*github.IssueEvent) *fakeLockEvents {
+	return &fakeLockEvents{events: map[string][]*github.IssueEvent{"/repos/owner/repo/issues/1": events}}
+}
+
+func TestDoTask_IssueLocked(t *testing.T) {
+	// Another process is already working on the issue
+	sendCalls, labelRequests, err := testIssueLock(t, issue1LockEvents(lockEvent(999, "bot", time.Minute)))
+	require.NoError(t, err)
+	require.Zero(t, sendCalls)
+	require.Empty(t, labelRequests, "the other process's label should be left alone")
+}
+
+func TestDoTask_IssueLockRace(t *testing.T) {
+	// The label wasn't there when checked, but another process added it first
+	sendCalls, labelRequests, err := testIssueLock(t, &fakeLockEvents{racer: "github-actions[bot]"})
+	require.NoError(t, err)
+	require.Zero(t, sendCalls)
+	require.Len(t, labelRequests, 1)
+	require.Equal(t, http.MethodPost, labelRequests[0].method, "only the addition should be requested, not a removal")
+}
+
+func TestDoTask_IssueLockAcquired(t *testing.T) {
+	// The label was added and removed before
+	removed := lockEvent(1000, "bot", time.Hour)
+	removed.Event = github.Ptr("unlabeled")
+	sendCalls, labelRequests, err := testIssueLock(t, issue1LockEvents(lockEvent(999, "bot", 2*time.Hour), removed))
+	require.ErrorAs(t, err, &iterationLimitError{})
+	require.NotZero(t, sendCalls)
+	require.Equal(t, http.MethodPost, labelRequests[0].method)
+	require.JSONEq(t, `["bot-working"]`, labelRequests[0].body)
+	// The lock is released when the task ends
+	require.Contains(t, labelRequests, labelRequest{method: http.MethodDelete, path: "/repos/owner/repo/issues/1/labels/bot-working"})
+}
+
+func TestDoTask_IssueLockExpired(t *testing.T) {
+	// A process died while working on the issue long ago, leaving the label behind
+	sendCalls, labelRequests, err := testIssueLock(t, issue1LockEvents(lockEvent(999, "bot", task.WorkingLabelExpiry+time.Minute)))
+	require.ErrorAs(t, err, &iterationLimitError{})
+	require.NotZero(t, sendCalls)
+	// The label is taken over by removing and adding it
+	require.Equal(t, labelRequest{method: http.MethodDelete, path: "/repos/owner/repo/issues/1/labels/bot-working"}, labelRequests[0])
+	require.Equal(t, http.MethodPost, labelRequests[1].method)
+}
+
+func TestDoTask_IssueLockUndecided(t *testing.T) {
+	// The label can be added, but not re-read
+	events := &fakeLockEvents{failRereads: true}
+	sendCalls, labelRequests, err := testIssueLock(t, events)
+	require.ErrorContains(t, err, "failed to lock issue")
+	require.Zero(t, sendCalls, "the issue must not be worked on without the lock")
+	require.Len(t, labelRequests, 1, "the label should be left to expire in case another process holds it")
+	require.NotNil(t, task.WorkingLabelAdded(events.events["/repos/owner/repo/issues/1"]))
+}
+
+// panickingSenderStub panics when sent a message
+type panickingSenderStub struct{}
+
+func (pss panickingSenderStub) SendMessage(context.Context, anthropic.MessageNewParams, ...anthropt.RequestOption) (*anthropic.Message, error) {
+	panic("oops")
+}
+
+func TestDoTask_PanicReleasesLock(t *testing.T) {
+	// processWithAI writes conversation logs to the working directory
+	t.Chdir(t.TempDir())
+
+	var labelRequests []labelRequest
+	b := New(
+		newLockTestGithubClient(t, &fakeLockEvents{}, &labelRequests),
+		&github.User{Login: github.Ptr("bot")},
+		panickingSenderStub{},
+		nil,
+		fakeWorkspaceFactory{},
+		Config{},
+	)
+
+	tsk := task.Task{Issue: task.GithubIssue{Owner: "owner", Repo: "repo", Number: 1}}
+	_, err := b.DoTask(context.Background(), tsk)
+	require.ErrorContains(t, err, "panic: oops")
+	require.Contains(t, labelRequests, labelRequest{method: http.MethodDelete, path: "/repos/owner/repo/issues/1/labels/bot-working"})
+}
+
+// errorSenderStub fails to send every message with the given error
+type errorSenderStub struct {
+	err error
+}
+
+func (ess errorSenderStub) SendMessage(context.Context, anthropic.MessageNewParams, ...anthropt.RequestOption) (*anthropic.Message, error) {
+	return nil, ess.err
+}
+
+// testDoTaskError runs a task whose messages all fail with the given error. Returns the label requests that were made
+func testDoTaskError(t *testing.T, sendErr error) []labelRequest {
+	// processWithAI writes conversation logs to the working directory
+	t.Chdir(t.TempDir())
+
+	var labelRequests []labelRequest
+	b := New(
+		newLockTestGithubClient(t, &fakeLockEvents{}, &labelRequests),
+		&github.User{Login: github.Ptr("bot")},
+		errorSenderStub{err: sendErr},
+		nil,
+		fakeWorkspaceFactory{},
+		Config{},
+	)
+
+	tsk := task.Task{Issue: task.GithubIssue{Owner: "owner", Repo: "repo", Number: 1}}
+	_, err := b.DoTask(context.Background(), tsk)
+	require.ErrorIs(t, err, sendErr)
+	return labelRequests
+}
+
+func newAnthropicError(statusCode int) *anthropic.Error {
+	req := httptest.NewRequest(http.MethodPost, "https://api.anthropic.com/v1/messages", nil)
+	return &anthropic.Error{StatusCode: statusCode, Request: req, Response: &http.Response{StatusCode: statusCode}}
+}
+
+func TestDoTask_TransientErrorNotBlocked(t *testing.T) {
+	labelRequests := testDoTaskError(t, newAnthropicError(529))
+	require.NotEmpty(t, labelRequests, "the in-progress label should still be managed")
+	for _, req := range labelRequests {
+		require.NotContains(t, req.body, *task.LabelBlocked.Name, "a transient error should be retried on the next poll")
+	}
+}
+
+func TestDoTask_PermanentErrorBlocked(t *testing.T) {
+	labelRequests := testDoTaskError(t, newAnthropicError(http.StatusBadRequest))
+	require.Contains(t, labelRequests, labelRequest{method: http.MethodPost, path: "/repos/owner/repo/issues/1/labels", body: `["bot-blocked"]` + "\n"})
+}
+
+// countingErrorSenderStub fails to send every message with the given error, counting the attempts
+type countingErrorSenderStub struct {
+	err   error
+	calls int
+}
+
+func (cess *countingErrorSenderStub) SendMessage(context.Context, anthropic.MessageNewParams, ...anthropt.RequestOption) (*anthropic.Message, error) {
+	cess.calls++
+	return nil, cess.err
+}
+
+func TestDoTask_NotBlockedWhileCircuitOpen(t *testing.T) {
+	// processWithAI writes conversation logs to the working directory
+	t.Chdir(t.TempDir())
+
+	var labelRequests []labelRequest
+	sender := &countingErrorSenderStub{err: newAnthropicError(529)}
+	b := New(
+		newLockTestGithubClient(t, &fakeLockEvents{}, &labelRequests),
+		&github.User{Login: github.Ptr("bot")},
+		sender,
+		nil,
+		fakeWorkspaceFactory{},
+		Config{AIFailureThreshold: 2},
+	)
+
+	tsk := task.Task{Issue: task.GithubIssue{Owner: "owner", Repo: "repo", Number: 1}}
+	for range 3 {
+		_, err := b.DoTask(context.Background(), tsk)
+		require.Error(t, err)
+	}
+
+	require.Equal(t, 2, sender.calls, "no message should be sent while the breaker is open")
+	blocked := 0
+	for _, req := range labelRequests {
+		if req.method == http.MethodPost && strings.Contains(req.body, *task.LabelBlocked.Name) {
+			blocked++
+		}
+	}
+	require.Zero(t, blocked, "neither the transient failures nor the paused task should block the issue")
+}
+
+func TestIsTransientError(t *testing.T) {
+	githubErr := func(statusCode int) error {
+		return &github.ErrorResponse{Response: &http.Response{StatusCode: statusCode}}
+	}
+	transient := []error{
+		fmt.Errorf("failed to send message: %w", newAnthropicError(529)),
+		newAnthropicError(http.StatusTooManyRequests),
+		githubErr(http.StatusBadGateway),
+		&github.RateLimitError{},
+		&github.AbuseRateLimitError{},
+		fmt.Errorf("validation timed out: %w", context.DeadlineExceeded),
+		&url.Error{Op: "Get", URL: "https://api.github.com", Err: errors.New("connection reset by peer")},
+	}
+	for _, err := range transient {
+		require.True(t, isTransientError(err), "%v", err)
+	}
+
+	permanent := []error{
+		newAnthropicError(http.StatusBadRequest),
+		githubErr(http.StatusUnprocessableEntity),
+		iterationLimitError{limit: 10},
+		errors.New("failed to parse tool input"),
+	}
+	for _, err := range permanent {
+		require.False(t, isTransientError(err), "%v", err)
+	}
+}
+
+func TestSummarize_TracksUsage(t *testing.T) {
+	response := newAnthropicResponse(t, summary)
+	response.Model = anthropic.ModelClaudeSonnet4_5_20250929
+	response.Usage = anthropic.Usage{InputTokens: 100, OutputTokens: 10, CacheReadInputTokens: 1000, CacheCreationInputTokens: 50}
+
+	history := ai.ConversationHistory{
+		SystemPrompt: "some system prompt",
+		Turns:        []ai.ConversationTurn{turn(t, 1), turn(t, 2), turn(t, 3), turn(t, 4)},
+	}
+	conversation, err := ai.ResumeConversation(senderStub{response: response}, history, anthropic.ModelClaudeSonnet4_5, 10000, nil)
+	require.NoError(t, err)
+
+	tracker := ai.NewUsageTracker()
+	conversation.TrackUsage(tracker)
+
+	ctx := context.Background()
+	_, err = conversation.SendMessage(ctx)
+	require.NoError(t, err)
+	// Summarization sends a message in a forked conversation, which should be tracked as well
+	err = summarize(ctx, conversation, 0, 2)
+	require.NoError(t, err)
+	_, err = conversation.SendMessage(ctx)
+	require.NoError(t, err)
+
+	expected := ai.Usage{InputTokens: 300, OutputTokens: 30, CacheReadInputTokens: 3000, CacheCreationInputTokens: 150}
+	require.Equal(t, expected, tracker.Total())
+}
+
+// slowSenderStub ends the conversation after a delay, recording the greatest number of messages in flight at once
+type slowSenderStub struct {
+	t     *testing.T
+	delay time.Duration
+
+	mu          sync.Mutex
+	inFlight    int
+	maxInFlight int
+	calls       int
+}
+
+func (sss *slowSenderStub) SendMessage(_ context.Context, _ anthropic.MessageNewParams, _ ...anthropt.RequestOption) (*anthropic.Message, error) {
+	sss.mu.Lock()
+	sss.inFlight++
+	sss.maxInFlight = max(sss.maxInFlight, sss.inFlight)
+	sss.calls++
+	sss.mu.Unlock()
+
+	time.Sleep(sss.delay)
+
+	sss.mu.Lock()
+	sss.inFlight--
+	sss.mu.Unlock()
+
+	var msg anthropic.Message
+	require.NoError(sss.t, json.Unmarshal([]byte(`{
+		"id": "msg_1",
+		"type": "message",
+		"role": "assistant",
+		"model": "claude-sonnet-4-5",
+		"content": [{"type": "text", "text": "Done"}],
+		"stop_reason": "end_turn",
+		"usage": {"input_tokens": 10, "output_tokens": 10}
+	}`), &msg))
+	return &msg, nil
+}
+
+func testRun(t *testing.T, concurrency int, issueNumbers []int) *slowSenderStub {
+	var issues []task.GithubIssue
+	for _, n := range issueNumbers {
+		issues = append(issues, task.GithubIssue{Owner: "owner", Repo: "repo", Number: n})
+	}
+	return testRunIssues(t, concurrency, issues)
+}
+
+// testRunIssues is like testRun, but runs tasks for the given issues, which may be in different repositories
+func testRunIssues(t *testing.T, concurrency int, issues []task.GithubIssue) *slowSenderStub {
+	// processWithAI writes conversation logs to the working directory
+	t.Chdir(t.TempDir())
+
+	var comments []string
+	sender := &slowSenderStub{t: t, delay: 50 * time.Millisecond}
+	b := New(
+		newCommentRecordingGithubClient(t, &comments),
+		&github.User{Login: github.Ptr("bot")},
+		sender,
+		nil,
+		fakeWorkspaceFactory{},
+		Config{Concurrency: concurrency},
+	)
+
+	tasks := make(chan task.TaskOrError, len(issues))
+	for _, issue := range issues {
+		tasks <- task.TaskOrError{Task: task.Task{Issue: issue}}
+	}
+	close(tasks)
+
+	require.NoError(t, b.Run(context.Background(), tasks))
+	return sender
+}
+
+func TestRun_Concurrent(t *testing.T) {
+	sender := testRun(t, 3, []int{1, 2, 3, 4, 5, 6})
+
+	require.Equal(t, 6, sender.calls)
+	require.Greater(t, sender.maxInFlight, 1)
+	require.LessOrEqual(t, sender.maxInFlight, 3)
+}
+
+func TestRun_SameIssueSerialized(t *testing.T) {
+	sender := testRun(t, 2, []int{1, 1})
+
+	require.Equal(t, 2, sender.calls)
+	require.Equal(t, 1, sender.maxInFlight)
+}
+
+func TestRun_SameNumberInDifferentReposConcurrent(t *testing.T) {
+	sender := testRunIssues(t, 2, []task.GithubIssue{
+		{Owner: "owner", Repo: "repo", Number: 1},
+		{Owner: "owner", Repo: "other-repo", Number: 1},
+	})
+
+	require.Equal(t, 2, sender.calls)
+	require.Equal(t, 2, sender.maxInFlight)
+}
+
+func TestRun_PausesWhileCircuitOpen(t *testing.T) {
+	// processWithAI writes conversation logs to the working directory
+	t.Chdir(t.TempDir())
+
+	var comments []string
+	sender := &slowSenderStub{t: t}
+	cooldown := 100 * time.Millisecond
+	b := New(
+		newCommentRecordingGithubClient(t, &comments),
+		&github.User{Login: github.Ptr("bot")},
+		sender,
+		nil,
+		fakeWorkspaceFactory{},
+		Config{AIFailureThreshold: 1, AIFailureCooldown: cooldown},
+	)
+	b.breaker.recordFailure()
+
+	tasks := make(chan task.TaskOrError, 1)
+	tasks <- task.TaskOrError{Task: task.Task{Issue: task.GithubIssue{Owner: "owner", Repo: "repo", Number: 1}}}
+	close(tasks)
+
+	start := time.Now()
+	require.NoError(t, b.Run(context.Background(), tasks))
+	require.GreaterOrEqual(t, time.Since(start), cooldown)
+	require.Equal(t, 1, sender.calls, "the task should only be started once the breaker has closed")
+}
+
+func TestRun_TaskError(t *testing.T) {
+	b := New(nil, &github.User{Login: github.Ptr("bot")}, nil, nil, fakeWorkspaceFactory{}, Config{Concurrency: 2})
+
+	tasks := make(chan task.TaskOrError, 1)
+	tasks <- task.TaskOrError{Err: errors.New("failed to list issues")}
+	close(tasks)
+
+	require.ErrorContains(t, b.Run(context.Background(), tasks), "failed to list issues")
+}
+
+func TestDoTask_IgnoreCommand(t *testing.T) {
+	var (
+		mu        sync.Mutex
+		reactions []string
+	)
+	githubClient := newTestGithubClient(t, withLockEvents(t, &fakeLockEvents{}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/reactions"):
+			mu.Lock()
+			reactions = append(reactions, r.URL.Path)
+			mu.Unlock()
+			_, _ = w.Write([]byte(`{}`))
+		case strings.HasSuffix(r.URL.Path, "/labels") || strings.HasSuffix(r.URL.Path, "/events"):
+			_, _ = w.Write([]byte(`[]`))
+		default:
+			_, _ = w.Write([]byte(`{}`))
+		}
+	})))
+
+	calls := 0
+	b := New(githubClient, &github.User{Login: github.Ptr("bot")}, toolUseSenderStub{t: t, calls: &calls}, nil, fakeWorkspaceFactory{}, Config{})
+
+	tsk := task.Task{
+		Issue: task.GithubIssue{Owner: "owner", Repo: "repo", Number: 1},
+		Directives: task.Directives{
+			Skip:     true,
+			Comments: []*github.IssueComment{{ID: github.Ptr(int64(42)), Body: github.Ptr("/bot ignore")}},
+		},
+	}
+	_, err := b.DoTask(context.Background(), tsk)
+	require.NoError(t, err)
+
+	require.Equal(t, 0, calls)
+	require.Equal(t, []string{"/repos/owner/repo/issues/comments/42/reactions"}, reactions)
+}
+
+// shutdownSenderStub responds to the first message with a request to use the view_diff tool. Later calls signal that
+// they have started, then end the conversation once released, or fail if their context is cancelled first
+type shutdownSenderStub struct {
+	t       *testing.T
+	started chan struct{}
+	release chan struct{}
+	calls   int
+}
+
+func (sss *shutdownSenderStub) SendMessage(ctx context.Context, _ anthropic.MessageNewParams, _ ...anthropt.RequestOption) (*anthropic.Message, error) {
+	sss.calls++
+	msgJSON := `{
+		"id": "msg_1",
+		"type": "message",
+		"role": "assistant",
+		"model": "claude-sonnet-4-5",
+		"content": [{"type": "tool_use", "id": "toolu_1", "name": "view_diff", "input": {}}],
+		"stop_reason": "tool_use",
+		"usage": {"input_tokens": 10, "output_tokens": 10}
+	}`
+	if sss.calls > 1 {
+		close(sss.started)
+		select {
+		case <-sss.release:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		msgJSON = `{
+			"id": "msg_2",
+			"type": "message",
+			"role": "assistant",
+			"model": "claude-sonnet-4-5",
+			"content": [{"type": "text", "text": "Done"}],
+			"stop_reason": "end_turn",
+			"usage": {"input_tokens": 10, "output_tokens": 10}
+		}`
+	}
+
+	var msg anthropic.Message
+	require.NoError(sss.t, json.Unmarshal([]byte(msgJSON), &msg))
+	return &msg, nil
+}
+
+// testShutdown cancels Run's context while the AI is working on a task, releasing the AI after the cancellation if
+// release is true. Returns the conversation histories and comments left behind
+func testShutdown(t *testing.T, gracePeriod time.Duration, release bool) (mapHistoryStore, []string) {
+	// processWithAI writes conversation logs to the working directory
+	t.Chdir(t.TempDir())
+
+	var comments []string
+	sender := &shutdownSenderStub{t: t, started: make(chan struct{}), release: make(chan struct{})}
+	historyStore := mapHistoryStore{}
+	b := New(
+		newCommentRecordingGithubClient(t, &comments),
+		&github.User{Login: github.Ptr("bot")},
+		sender,
+		historyStore,
+		fakeWorkspaceFactory{},
+		Config{ShutdownGracePeriod: gracePeriod},
+	)
+
+	tasks := make(chan task.TaskOrError, 1)
+	tasks <- task.TaskOrError{Task: task.Task{Issue: task.GithubIssue{Owner: "owner", Repo: "repo", Number: 1}}}
+	close(tasks)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-sender.started
+		cancel()
+		if release {
+			close(sender.release)
+		}
+	}()
+
+	require.NoError(t, b.Run(ctx, tasks))
+	require.Equal(t, 2, sender.calls)
+	return historyStore, comments
+}
+
+// blockingSenderStub ends the conversation in response to every message, but the first message blocks until released
+type blockingSenderStub struct {
+	t       *testing.T
+	started chan struct{}
+	release chan struct{}
+	calls   atomic.Int32
+}
+
+func (bss *blockingSenderStub) SendMessage(_ context.Context, _ anthropic.MessageNewParams, _ ...anthropt.RequestOption) (*anthropic.Message, error) {
+	if bss.calls.Add(1) == 1 {
+		close(bss.started)
+		<-bss.release
+	}
+
+	var msg anthropic.Message
+	require.NoError(bss.t, json.Unmarshal([]byte(`{
+		"id": "msg_1",
+		"type": "message",
+		"role": "assistant",
+		"model": "claude-sonnet-4-5",
+		"content": [{"type": "text", "text": "Done"}],
+		"stop_reason": "end_turn",
+		"usage": {"input_tokens": 10, "output_tokens": 10}
+	}`), &msg))
+	return &msg, nil
+}
+
+func TestRun_ShutdownStartsNoNewTasks(t *testing.T) {
+	// processWithAI writes conversation logs to the working directory
+	t.Chdir(t.TempDir())
+
+	var comments []string
+	sender := &blockingSenderStub{t: t, started: make(chan struct{}), release: make(chan struct{})}
+	b := New(
+		newCommentRecordingGithubClient(t, &comments),
+		&github.User{Login: github.Ptr("bot")},
+		sender,
+		nil,
+		fakeWorkspaceFactory{},
+		Config{Concurrency: 1, ShutdownGracePeriod: time.Minute},
+	)
+
+	// Like a running generator, the producer keeps the channel open after its tasks are taken, until it is stopped
+	tasks := make(chan task.TaskOrError)
+	stop := make(chan struct{})
+	go func() {
+		defer close(tasks)
+		for n := 1; n <= 2; n++ {
+			tasks <- task.TaskOrError{Task: task.Task{Issue: task.GithubIssue{Owner: "owner", Repo: "repo", Number: n}}}
+		}
+		<-stop
+	}()
+
+	// Shut down while the only worker is busy with the first task, and the second task is waiting to be sent
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-sender.started
+		cancel()
+		close(sender.release)
+	}()
+
+	require.NoError(t, b.Run(ctx, tasks))
+	close(stop)
+	require.EqualValues(t, 1, sender.calls.Load(), "no task should be started after shutdown")
+}
+
+func TestRun_ShutdownFinishesTask(t *testing.T) {
+	historyStore, comments := testShutdown(t, time.Minute, true)
+
+	// The task concluded, so there is nothing to resume
+	require.Empty(t, historyStore)
+	require.Empty(t, comments)
+}
+
+func TestRun_ShutdownGracePeriodExceeded(t *testing.T) {
+	historyStore, comments := testShutdown(t, 10*time.Millisecond, false)
+
+	// The interrupted task isn't reported as failed, and its conversation is kept, including the tool result that was
+	// added after the history was last persisted
+	require.Empty(t, comments)
+	history, ok := historyStore["owner_repo_1"]
+	require.True(t, ok)
+	require.Len(t, history.Turns, 1)
+	require.Len(t, history.Turns[0].ToolExchanges, 1)
+	require.NotNil(t, history.Turns[0].ToolExchanges[0].ResultBlock)
+}
+
+func TestDoTask_DryRun(t *testing.T) {
+	// processWithAI writes conversation logs to the working directory
+	t.Chdir(t.TempDir())
+
+	githubClient := newTestGithubClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("unexpected GitHub request in dry-run mode: %s %s", r.Method, r.URL.Path)
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	calls := 0
+	b := New(
+		githubClient,
+		&github.User{Login: github.Ptr("bot")},
+		toolUseSenderStub{t: t, calls: &calls},
+		nil,
+		fakeWorkspaceFactory{},
+		Config{MaxIterations: 1, DryRun: true},
+	)
+
+	// Giving up would normally add a label and post a comment
+	tsk := task.Task{Issue: task.GithubIssue{Owner: "owner", Repo: "repo", Number: 1}}
+	_, err := b.DoTask(context.Background(), tsk)
+	require.ErrorAs(t, err, &iterationLimitError{})
+}
+
+// diffCountingWorkspace is a fakeWorkspace that counts calls to Diff
+type diffCountingWorkspace struct {
+	fakeWorkspace
+
+	calls *int
+}
+
+func (dcw diffCountingWorkspace) Diff(context.Context, string) (string, error) {
+	*dcw.calls++
+	return "", nil
+}
+
+type diffCountingWorkspaceFactory struct {
+	calls *int
+	files map[string]string // May be nil
+}
+
+func (dcwf diffCountingWorkspaceFactory) NewWorkspace(context.Context, task.Task) (Workspace, error) {
+	return diffCountingWorkspace{fakeWorkspace: fakeWorkspace{files: dcwf.files}, calls: dcwf.calls}, nil
+}
+
+// toolResultRecordingSenderStub behaves like toolUseSenderStub, and also records the text of the tool results in each
+// message it is sent
+type toolResultRecordingSenderStub struct {
+	toolUseSenderStub
+
+	toolResults *[]string
+}
+
+func (trss toolResultRecordingSenderStub) SendMessage(ctx context.Context, params anthropic.MessageNewParams, opts ...anthropt.RequestOption) (*anthropic.Message, error) {
+	last := params.Messages[len(params.Messages)-1]
+	for _, block := range last.Content {
+		if block.OfToolResult == nil {
+			continue
+		}
+		for _, content := range block.OfToolResult.Content {
+			if content.OfText != nil {
+				*trss.toolResults = append(*trss.toolResults, content.OfText.Text)
+			}
+		}
+	}
+	return trss.toolUseSenderStub.SendMessage(ctx, params, opts...)
+}
+
+func TestDoTask_RepeatedToolCalls(t *testing.T) {
+	// processWithAI writes conversation logs to the working directory
+	t.Chdir(t.TempDir())
+
+	var comments, toolResults []string
+	sendCalls, diffCalls := 0, 0
+	b := New(
+		newCommentRecordingGithubClient(t, &comments),
+		&github.User{Login: github.Ptr("bot")},
+		toolResultRecordingSenderStub{toolUseSenderStub{t: t, calls: &sendCalls}, &toolResults},
+		nil,
+		diffCountingWorkspaceFactory{calls: &diffCalls},
+		Config{MaxIterations: 6, MaxRepeatedToolCalls: 2},
+	)
+
+	tsk := task.Task{Issue: task.GithubIssue{Owner: "owner", Repo: "repo", Number: 1}}
+	_, err := b.DoTask(context.Background(), tsk)
+	require.ErrorAs(t, err, &iterationLimitError{})
+
+	// The AI asked for the diff 6 times, but it was only computed for the first 2
+	require.Equal(t, 2, diffCalls)
+	require.Len(t, toolResults, 6)
+	for _, result := range toolResults[2:] {
+		require.Contains(t, result, "stuck in a loop")
+	}
+}
+
+func TestDoTask_RepeatedPollingToolCalls(t *testing.T) {
+	// processWithAI writes conversation logs to the working directory
+	t.Chdir(t.TempDir())
+
+	var comments, toolResults []string
+	sendCalls := 0
+	sender := toolUseSenderStub{t: t, calls: &sendCalls, tool: "get_validation_status"}
+	b := New(
+		newCommentRecordingGithubClient(t, &comments),
+		&github.User{Login: github.Ptr("bot")},
+		toolResultRecordingSenderStub{sender, &toolResults},
+		nil,
+		fakeWorkspaceFactory{},
+		Config{MaxIterations: 6, MaxRepeatedToolCalls: 2},
+	)
+
+	tsk := task.Task{Issue: task.GithubIssue{Owner: "owner", Repo: "repo", Number: 1}}
+	_, err := b.DoTask(context.Background(), tsk)
+	require.ErrorAs(t, err, &iterationLimitError{})
+
+	// Waiting for validation to finish means asking for its status again and again
+	require.Len(t, toolResults, 6)
+	for _, result := range toolResults {
+		require.NotContains(t, result, "stuck in a loop")
+	}
+}
+
+func TestDoTask_ResumeIncompleteTurn(t *testing.T) {
+	// processWithAI writes conversation logs to the working directory
+	t.Chdir(t.TempDir())
+
+	// The previous attempt stopped partway through a turn: the file was written, but the diff was never viewed
+	var response anthropic.Message
+	require.NoError(t, json.Unmarshal([]byte(`{
+		"id": "msg_0",
+		"type": "message",
+		"role": "assistant",
+		"model": "claude-sonnet-4-5",
+		"content": [
+			{"type": "tool_use", "id": "toolu_write", "name": "write_file", "input": {"path": "a.txt", "content": "hello"}},
+			{"type": "tool_use", "id": "toolu_diff", "name": "view_diff", "input": {}}
+		],
+		"stop_reason": "tool_use",
+		"usage": {"input_tokens": 10, "output_tokens": 10}
+	}`), &response))
+	writeResult := newToolResultBlockParam("toolu_write", "original write result", false)
+	historyStore := mapHistoryStore{"owner_repo_1": ai.ConversationHistory{
+		Turns: []ai.ConversationTurn{{
+			Instructions: []anthropic.ContentBlockParamUnion{anthropic.NewTextBlock("do the task")},
+			Response:     &response,
+			ToolExchanges: []ai.ToolExchange{
+				{UseBlock: response.Content[0].AsToolUse(), ResultBlock: &writeResult},
+				{UseBlock: response.Content[1].AsToolUse()},
+			},
+		}},
+	}}
+
+	var comments, toolResults []string
+	sendCalls, diffCalls := 0, 0
+	files := map[string]string{}
+	b := New(
+		newCommentRecordingGithubClient(t, &comments),
+		&github.User{Login: github.Ptr("bot")},
+		toolResultRecordingSenderStub{toolUseSenderStub{t: t, calls: &sendCalls}, &toolResults},
+		historyStore,
+		diffCountingWorkspaceFactory{calls: &diffCalls, files: files},
+		Config{MaxIterations: 1},
+	)
+
+	tsk := task.Task{Issue: task.GithubIssue{Owner: "owner", Repo: "repo", Number: 1}}
+	_, err := b.DoTask(context.Background(), tsk)
+	require.ErrorAs(t, err, &iterationLimitError{})
+
+	// The completed write was replayed to restore the workspace, and only the pending diff was run
+	require.Equal(t, "hello", files["a.txt"])
+	require.Equal(t, 1, diffCalls)
+	require.Equal(t, 1, sendCalls)
+	require.Len(t, toolResults, 2)
+	require.Equal(t, "original write result", toolResults[0])
+}
+
+func TestToolLoopDetector(t *testing.T) {
+	tld := &toolLoopDetector{limit: 2}
+	block := func(name string, input string) anthropic.ToolUseBlock {
+		return anthropic.ToolUseBlock{Name: name, Input: json.RawMessage(input)}
+	}
+
+	require.Equal(t, 1, tld.observe(block("view_diff", `{}`)))
+	require.Equal(t, 2, tld.observe(block("view_diff", `{ }`)), "whitespace differences should be ignored")
+	require.Equal(t, 1, tld.observe(block("view_diff", `{"path": "a.go"}`)))
+	require.Equal(t, 1, tld.observe(block("read_multiple_files", `{"path": "a.go"}`)))
+	require.Equal(t, 2, tld.observe(block("read_multiple_files", `{"path": "a.go"}`)))
+	require.Equal(t, 3, tld.observe(block("read_multiple_files", `{"path": "a.go"}`)))
+}
+
+// questionSenderStub asks a human a question in response to the first message it is sent, and ends the conversation
+// in response to any later message, recording the text of that message
+type questionSenderStub struct {
+	t     *testing.T
+	calls *int
+
+	laterMessages *[]string
+}
+
+func (qss questionSenderStub) SendMessage(_ context.Context, params anthropic.MessageNewParams, _ ...anthropt.RequestOption) (*anthropic.Message, error) {
+	*qss.calls++
+	msgJSON := `{
+		"id": "msg_1",
+		"type": "message",
+		"role": "assistant",
+		"model": "claude-sonnet-4-5",
+		"content": [{"type": "tool_use", "id": "toolu_1", "name": "await_human_input", "input": {"question": "Which one?"}}],
+		"stop_reason": "tool_use",
+		"usage": {"input_tokens": 10, "output_tokens": 10}
+	}`
+	if *qss.calls > 1 {
+		last := params.Messages[len(params.Messages)-1]
+		for _, block := range last.Content {
+			if block.OfText != nil {
+				*qss.laterMessages = append(*qss.laterMessages, block.OfText.Text)
+			}
+		}
+		msgJSON = `{
+			"id": "msg_2",
+			"type": "message",
+			"role": "assistant",
+			"model": "claude-sonnet-4-5",
+			"content": [{"type": "text", "text": "Thanks, done"}],
+			"stop_reason": "end_turn",
+			"usage": {"input_tokens": 10, "output_tokens": 10}
+		}`
+	}
+
+	var msg anthropic.Message
+	require.NoError(qss.t, json.Unmarshal([]byte(msgJSON), &msg))
+	return &msg, nil
+}
+
+func TestDoTask_AwaitHumanInput(t *testing.T) {
+	// processWithAI writes conversation logs to the working directory
+	t.Chdir(t.TempDir())
+
+	githubClient, activity := newActivityRecordingGithubClient(t)
+
+	calls := 0
+	var laterMessages []string
+	historyStore := mapHistoryStore{}
+	b := New(
+		githubClient,
+		&github.User{Login: github.Ptr("bot")},
+		questionSenderStub{t: t, calls: &calls, laterMessages: &laterMessages},
+		historyStore,
+		fakeWorkspaceFactory{},
+		Config{},
+	)
+
+	tsk := task.Task{Issue: task.GithubIssue{Owner: "owner", Repo: "repo", Number: 1}}
+	_, err := b.DoTask(context.Background(), tsk)
+	require.NoError(t, err)
+
+	// The loop stopped after the question, without blocking the issue
+	require.Equal(t, 1, calls)
+	require.Len(t, activity.comments, 1)
+	require.Contains(t, activity.comments[0], "Which one?")
+	require.Contains(t, activity.addedLabels, *task.LabelNeedsHuman.Name)
+	require.NotContains(t, activity.addedLabels, *task.LabelBlocked.Name)
+	require.Contains(t, activity.removedLabels, *task.LabelBotTurn.Name)
+
+	// The conversation was kept, including the result of the question, so that it can be resumed
+	history, ok := historyStore["owner_repo_1"]
+	require.True(t, ok)
+	require.NotNil(t, history.Turns[len(history.Turns)-1].ToolExchanges[0].ResultBlock)
+
+	// When the human replies, the conversation resumes with the task's current state
+	tsk.IssueComments = []*github.IssueComment{{Body: github.Ptr("The second one")}}
+	_, err = b.DoTask(context.Background(), tsk)
+	require.NoError(t, err)
+
+	require.Equal(t, 2, calls)
+	require.Len(t, laterMessages, 1)
+	require.Contains(t, laterMessages[0], "A human has responded")
+	require.Contains(t, laterMessages[0], "The second one")
+	require.Contains(t, activity.removedLabels, *task.LabelNeedsHuman.Name)
+	require.Empty(t, historyStore)
+}
+
+// planSenderStub proposes a plan in response to the first message, and ends the conversation in response to any later
+// message. It records the tools offered with each message, and the text of later messages
+type planSenderStub struct {
+	t     *testing.T
+	calls *int
+
+	offeredTools  *[][]string
+	laterMessages *[]string
+}
+
+func (pss planSenderStub) SendMessage(_ context.Context, params anthropic.MessageNewParams, _ ...anthropt.RequestOption) (*anthropic.Message, error) {
+	*pss.calls++
+	var tools []string
+	for _, tool := range params.Tools {
+		tools = append(tools, tool.OfTool.Name)
+	}
+	*pss.offeredTools = append(*pss.offeredTools, tools)
+
+	msgJSON := `{
+		"id": "msg_1",
+		"type": "message",
+		"role": "assistant",
+		"model": "claude-sonnet-4-5",
+		"content": [{"type": "tool_use", "id": "toolu_1", "name": "post_plan", "input": {"plan": "Add a cache to the parser"}}],
+		"stop_reason": "tool_use",
+		"usage": {"input_tokens": 10, "output_tokens": 10}
+	}`
+	if *pss.calls > 1 {
+		last := params.Messages[len(params.Messages)-1]
+		for _, block := range last.Content {
+			if block.OfText != nil {
+				*pss.laterMessages = append(*pss.laterMessages, block.OfText.Text)
+			}
+		}
+		msgJSON = `{
+			"id": "msg_2",
+			"type": "message",
+			"role": "assistant",
+			"model": "claude-sonnet-4-5",
+			"content": [{"type": "text", "text": "Done"}],
+			"stop_reason": "end_turn",
+			"usage": {"input_tokens": 10, "output_tokens": 10}
+		}`
+	}
+
+	var msg anthropic.Message
+	require.NoError(pss.t, json.Unmarshal([]byte(msgJSON), &msg))
+	return &msg, nil
+}
+
+func TestDoTask_PlanFirst(t *testing.T) {
+	// processWithAI writes conversation logs to the working directory
+	t.Chdir(t.TempDir())
+
+	githubClient, activity := newActivityRecordingGithubClient(t)
+
+	calls := 0
+	var offeredTools [][]string
+	var laterMessages []string
+	historyStore := mapHistoryStore{}
+	b := New(
+		githubClient,
+		&github.User{Login: github.Ptr("bot")},
+		planSenderStub{t: t, calls: &calls, offeredTools: &offeredTools, laterMessages: &laterMessages},
+		historyStore,
+		fakeWorkspaceFactory{},
+		Config{},
+	)
+
+	tsk := task.Task{
+		Issue:           task.GithubIssue{Owner: "owner", Repo: "repo", Number: 1},
+		Plan:            task.PlanState{Required: true},
+		AttentionReason: task.AttentionNewIssue,
+	}
+	_, err := b.DoTask(context.Background(), tsk)
+	require.NoError(t, err)
+
+	// The first run posted a plan and stopped, without being offered tools that change code
+	require.Equal(t, 1, calls)
+	require.Contains(t, offeredTools[0], "post_plan")
+	require.Contains(t, offeredTools[0], "read_multiple_files")
+	require.Contains(t, offeredTools[0], "str_replace_based_edit_tool", "planning tasks may view files")
+	require.NotContains(t, offeredTools[0], "publish_changes_for_review")
+	require.Len(t, activity.comments, 1)
+	require.True(t, strings.HasPrefix(activity.comments[0], task.PlanCommentHeading))
+	require.Contains(t, activity.comments[0], "Add a cache to the parser")
+	require.Contains(t, activity.addedLabels, *task.LabelNeedsHuman.Name)
+	require.Contains(t, historyStore, "owner_repo_1")
+
+	// Once a human approves the plan, the next run acknowledges the approval and carries on with all tools
+	planComment := &github.IssueComment{
+		ID:   github.Ptr(int64(5)),
+		User: &github.User{Login: github.Ptr("bot")},
+		Body: github.Ptr(activity.comments[0]),
+	}
+	tsk.IssueComments = []*github.IssueComment{planComment}
+	tsk.Plan = task.PlanState{Required: true, Comment: planComment, Approved: true}
+	tsk.AttentionReason = task.AttentionPlanApproved
+	_, err = b.DoTask(context.Background(), tsk)
+	require.NoError(t, err)
+
+	require.Equal(t, 2, calls)
+	require.Contains(t, offeredTools[1], "str_replace_based_edit_tool")
+	require.Contains(t, offeredTools[1], "publish_changes_for_review")
+	require.Equal(t, []string{"/repos/owner/repo/issues/comments/5/reactions eyes"}, activity.reactions)
+	require.Contains(t, activity.addedLabels, *task.LabelBotTurn.Name)
+	require.Len(t, laterMessages, 1)
+	require.Contains(t, laterMessages[0], "approved your plan")
+	require.Contains(t, laterMessages[0], "Add a cache to the parser")
+	require.Empty(t, historyStore)
+}
+
+// concludeSenderStub concludes the task, with a final comment, in response to every message
+type concludeSenderStub struct {
+	t     *testing.T
+	calls *int
+}
+
+func (css concludeSenderStub) SendMessage(_ context.Context, _ anthropic.MessageNewParams, _ ...anthropt.RequestOption) (*anthropic.Message, error) {
+	*css.calls++
+	msgJSON := `{
+		"id": "msg_1",
+		"type": "message",
+		"role": "assistant",
+		"model": "claude-sonnet-4-5",
+		"content": [{"type": "tool_use", "id": "toolu_1", "name": "conclude", "input": {"comment": "It's configured in config.go"}}],
+		"stop_reason": "tool_use",
+		"usage": {"input_tokens": 10, "output_tokens": 10}
+	}`
+
+	var msg anthropic.Message
+	require.NoError(css.t, json.Unmarshal([]byte(msgJSON), &msg))
+	return &msg, nil
+}
+
+func TestDoTask_Conclude(t *testing.T) {
+	// processWithAI writes conversation logs to the working directory
+	t.Chdir(t.TempDir())
+
+	githubClient, activity := newActivityRecordingGithubClient(t)
+
+	calls := 0
+	historyStore := mapHistoryStore{}
+	b := New(
+		githubClient,
+		&github.User{Login: github.Ptr("bot")},
+		concludeSenderStub{t: t, calls: &calls},
+		historyStore,
+		fakeWorkspaceFactory{},
+		Config{},
+	)
+
+	tsk := task.Task{Issue: task.GithubIssue{Owner: "owner", Repo: "repo", Number: 1}}
+	_, err := b.DoTask(context.Background(), tsk)
+	require.NoError(t, err)
+
+	// The loop stopped after concluding, without waiting for a human or blocking the issue
+	require.Equal(t, 1, calls)
+	require.Equal(t, []string{"It's configured in config.go"}, activity.comments)
+	require.NotContains(t, activity.addedLabels, *task.LabelNeedsHuman.Name)
+	require.NotContains(t, activity.addedLabels, *task.LabelBlocked.Name)
+	require.Contains(t, activity.removedLabels, *task.LabelBotTurn.Name)
+
+	// The conversation was deleted, so that the next comment starts a new one
+	require.Empty(t, historyStore)
+}
+
+// crashingSenderStub simulates the bot crashing while waiting for the AI, by cancelling the task's context
+type crashingSenderStub struct {
+	cancel context.CancelFunc
+}
+
+func (css crashingSenderStub) SendMessage(ctx context.Context, _ anthropic.MessageNewParams, _ ...anthropt.RequestOption) (*anthropic.Message, error) {
+	css.cancel()
+	return nil, ctx.Err()
+}
+
+func TestDoTask_RecordsStats(t *testing.T) {
+	// processWithAI writes conversation logs to the working directory
+	t.Chdir(t.TempDir())
+
+	statsPath := filepath.Join(t.TempDir(), "stats.jsonl")
+	var comments []string
+	calls := 0
+	b := New(
+		newCommentRecordingGithubClient(t, &comments),
+		&github.User{Login: github.Ptr("bot")},
+		concludeSenderStub{t: t, calls: &calls},
+		nil,
+		fakeWorkspaceFactory{},
+		Config{Stats: NewJSONLStatsSink(statsPath)},
+	)
+
+	tsk := task.Task{Issue: task.GithubIssue{Owner: "owner", Repo: "repo", Number: 1}}
+	_, err := b.DoTask(context.Background(), tsk)
+	require.NoError(t, err)
+
+	content, err := os.ReadFile(statsPath)
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSuffix(string(content), "\n"), "\n")
+	require.Len(t, lines, 1)
+
+	var stats TaskStats
+	decoder := json.NewDecoder(strings.NewReader(lines[0]))
+	decoder.DisallowUnknownFields()
+	require.NoError(t, decoder.Decode(&stats))
+	require.Equal(t, "owner", stats.Owner)
+	require.Equal(t, "repo", stats.Repo)
+	require.Equal(t, 1, stats.IssueNumber)
+	require.Equal(t, TaskCompleted, stats.Outcome)
+	require.Equal(t, 1, stats.Iterations)
+	require.Equal(t, map[string]int{"conclude": 1}, stats.ToolCalls)
+	require.Equal(t, ai.Usage{InputTokens: 10, OutputTokens: 10}, stats.Usage)
+	require.NotNil(t, stats.EstimatedCostUSD)
+	require.False(t, stats.FinishedAt.IsZero())
+	require.Contains(t, lines[0], `"usage":{"input_tokens":10,"output_tokens":10,`)
+}
+
+// endTurnSenderStub ends the conversation in response to every message. If messages isn't nil, the text of each
+// message's last turn is recorded in it
+type endTurnSenderStub struct {
+	t        *testing.T
+	messages *[]string
+}
+
+func (ets endTurnSenderStub) SendMessage(_ context.Context, params anthropic.MessageNewParams, _ ...anthropt.RequestOption) (*anthropic.Message, error) {
+	if ets.messages != nil {
+		var text strings.Builder
+		for _, block := range params.Messages[len(params.Messages)-1].Content {
+			if block.OfText != nil {
+				text.WriteString(block.OfText.Text)
+			}
+		}
+		*ets.messages = append(*ets.messages, text.String())
+	}
+	var msg anthropic.Message
+	require.NoError(ets.t, json.Unmarshal([]byte(`{
+		"id": "msg_1",
+		"type": "message",
+		"role": "assistant",
+		"model": "claude-sonnet-4-5",
+		"content": [{"type": "text", "text": "Done"}],
+		"stop_reason": "end_turn",
+		"usage": {"input_tokens": 10, "output_tokens": 10}
+	}`), &msg))
+	return &msg, nil
+}
+
+func TestDoTask_AcknowledgedCommentsRedeliveredAfterCrash(t *testing.T) {
+	// processWithAI writes conversation logs to the working directory
+	t.Chdir(t.TempDir())
+
+	githubClient, activity := newActivityRecordingGithubClient(t)
+	comment := &github.IssueComment{ID: github.Ptr(int64(5)), Body: github.Ptr("Please also handle the empty case")}
+	reviewComment := &github.PullRequestComment{ID: github.Ptr(int64(6)), Body: github.Ptr("Typo here")}
+	tsk := task.Task{
+		Issue:                              task.GithubIssue{Owner: "owner", Repo: "repo", Number: 1},
+		IssueComments:                      []*github.IssueComment{comment},
+		IssueCommentsRequiringResponses:    []*github.IssueComment{comment},
+		PRReviewCommentsRequiringResponses: []*github.PullRequestComment{reviewComment},
+	}
+
+	// The bot crashes while the AI is responding, before the conversation is first persisted
+	ctx, cancel := context.WithCancel(context.Background())
+	historyStore := mapHistoryStore{}
+	b := New(githubClient, &github.User{Login: github.Ptr("bot")}, crashingSenderStub{cancel: cancel}, historyStore,
+		fakeWorkspaceFactory{}, Config{})
+	_, err := b.DoTask(ctx, tsk)
+	require.ErrorIs(t, err, context.Canceled)
+
+	// The comments were acknowledged before the response, and the task was kept pending
+	acknowledgements := []string{
+		"/repos/owner/repo/issues/comments/5/reactions eyes",
+		"/repos/owner/repo/pulls/comments/6/reactions eyes",
+	}
+	require.Equal(t, acknowledgements, activity.reactions)
+	require.Contains(t, activity.addedLabels, *task.LabelBotTurn.Name)
+	require.Empty(t, historyStore)
+
+	// The acknowledgement doesn't mark the comments as handled, so after the restart they are delivered to the AI again
+	var messages []string
+	b = New(githubClient, &github.User{Login: github.Ptr("bot")}, endTurnSenderStub{t: t, messages: &messages},
+		historyStore, fakeWorkspaceFactory{}, Config{})
+	_, err = b.DoTask(context.Background(), tsk)
+	require.NoError(t, err)
+	require.Len(t, messages, 1)
+	require.Contains(t, messages[0], "Issue comments requiring responses: 5")
+	require.Contains(t, messages[0], "PR review comments requiring responses: 6")
+	require.Equal(t, slices.Concat(acknowledgements, acknowledgements), activity.reactions,
+		"acknowledging again is harmless, since GitHub keeps one reaction of each kind per user")
+}
+
+// uncachedSenderStub ends the conversation in response to every message, reporting a large prompt that wasn't read from
+// the cache
+type uncachedSenderStub struct {
+	t *testing.T
+}
+
+func (uss uncachedSenderStub) SendMessage(_ context.Context, _ anthropic.MessageNewParams, _ ...anthropt.RequestOption) (*anthropic.Message, error) {
+	var msg anthropic.Message
+	require.NoError(uss.t, json.Unmarshal([]byte(`{
+		"id": "msg_1",
+		"type": "message",
+		"role": "assistant",
+		"model": "claude-sonnet-4-5",
+		"content": [{"type": "text", "text": "Done"}],
+		"stop_reason": "end_turn",
+		"usage": {"input_tokens": 50000, "output_tokens": 10}
+	}`), &msg))
+	return &msg, nil
+}
+
+// testTurnUsageLogs runs a task with the given history store and returns the debug log output
+func testTurnUsageLogs(t *testing.T, historyStore ConversationHistoryStore) string {
+	// processWithAI writes conversation logs to the working directory
+	t.Chdir(t.TempDir())
+
+	var (
+		comments []string
+		buf      bytes.Buffer
+	)
+	b := New(newCommentRecordingGithubClient(t, &comments), &github.User{Login: github.Ptr("bot")},
+		uncachedSenderStub{t: t}, historyStore, fakeWorkspaceFactory{},
+		Config{Logger: logging.NewTextLogger(log.New(&buf, "", 0), slog.LevelDebug)})
+
+	tsk := task.Task{Issue: task.GithubIssue{Owner: "owner", Repo: "repo", Number: 1}}
+	_, err := b.DoTask(context.Background(), tsk)
+	require.NoError(t, err)
+	return buf.String()
+}
+
+func TestDoTask_LogsUsageOfFirstMessage(t *testing.T) {
+	out := testTurnUsageLogs(t, nil)
+
+	require.Equal(t, 1, strings.Count(out, "Token usage"))
+	require.Contains(t, out, "input_tokens=50000")
+	require.NotContains(t, out, "Low cache hit rate", "the first message can't hit the cache")
+}
+
+func TestDoTask_NoCacheWarningAfterResumingIncompleteTurn(t *testing.T) {
+	response := newAnthropicResponse(t, anthropic.NewToolUseBlock("toolu_1", map[string]any{}, "view_diff"))
+	response.StopReason = anthropic.StopReasonToolUse
+	historyStore := mapHistoryStore{"owner_repo_1": ai.ConversationHistory{
+		SystemPrompt: "system prompt",
+		Turns: []ai.ConversationTurn{{
+			Instructions:  []anthropic.ContentBlockParamUnion{anthropic.NewTextBlock("task")},
+			Response:      response,
+			ToolExchanges: []ai.ToolExchange{{UseBlock: response.Content[0].AsToolUse()}},
+		}},
+	}}
+	out := testTurnUsageLogs(t, historyStore)
+
+	// The prompt was last cached before the restart, so its cache may have expired
+	require.Equal(t, 1, strings.Count(out, "Token usage"))
+	require.NotContains(t, out, "Low cache hit rate")
+}
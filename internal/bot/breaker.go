@@ -0,0 +1,111 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/anthropics/anthropic-sdk-go"
+	anthropt "github.com/anthropics/anthropic-sdk-go/option"
+
+	"github.com/cchalm/blundering-savant/internal/ai"
+)
+
+// errCircuitOpen is returned instead of sending a message to the AI while the circuit breaker is open
+var errCircuitOpen = errors.New("not sending message: too many consecutive AI failures, pausing until the cooldown ends")
+
+// circuitBreaker pauses work after repeated AI failures, e.g. during an Anthropic outage, so that the bot doesn't fail
+// and block every task it picks up in the meantime. It opens after a number of consecutive failures and closes again
+// once a cooldown has passed. Safe for concurrent use
+type circuitBreaker struct {
+	threshold int // The number of consecutive failures that opens the breaker
+	cooldown  time.Duration
+
+	mu       sync.Mutex
+	failures int       // The number of consecutive failures so far
+	openedAt time.Time // When the breaker opened, or zero if it is closed
+
+	// Overridden in tests
+	now func() time.Time
+}
+
+func newCircuitBreaker(threshold int, cooldown time.Duration) *circuitBreaker {
+	return &circuitBreaker{
+		threshold: threshold,
+		cooldown:  cooldown,
+		now:       time.Now,
+	}
+}
+
+// recordSuccess records that a message was sent successfully, resetting the count of consecutive failures
+func (cb *circuitBreaker) recordSuccess() {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	cb.failures = 0
+}
+
+// recordFailure records that a message failed to send, opening the breaker if there have been too many in a row
+func (cb *circuitBreaker) recordFailure() {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	cb.failures++
+	if cb.failures >= cb.threshold && cb.openedAt.IsZero() {
+		cb.openedAt = cb.now()
+	}
+}
+
+// openUntil returns the time at which the breaker will close, or the zero time if it is closed
+func (cb *circuitBreaker) openUntil() time.Time {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	if cb.openedAt.IsZero() {
+		return time.Time{}
+	}
+	until := cb.openedAt.Add(cb.cooldown)
+	if !cb.now().Before(until) {
+		// Give the AI another chance, but reopen on the first failure rather than waiting for a full threshold's worth
+		cb.openedAt = time.Time{}
+		cb.failures = cb.threshold - 1
+		return time.Time{}
+	}
+	return until
+}
+
+// isOpen returns true if the breaker is open
+func (cb *circuitBreaker) isOpen() bool {
+	return !cb.openUntil().IsZero()
+}
+
+// breakerSender is an ai.MessageSender that reports the outcome of each message to a circuit breaker, and doesn't send
+// messages while the breaker is open. Only failures that suggest the AI is unavailable, e.g. server errors, rate limits,
+// and network errors, count against the breaker. Others, e.g. a request that is too large, are particular to a task
+type breakerSender struct {
+	sender  ai.MessageSender
+	breaker *circuitBreaker
+}
+
+// SendMessage sends a message unless the breaker is open, in which case it returns errCircuitOpen
+func (bs breakerSender) SendMessage(
+	ctx context.Context,
+	params anthropic.MessageNewParams,
+	opts ...anthropt.RequestOption,
+) (*anthropic.Message, error) {
+	if bs.breaker.isOpen() {
+		return nil, errCircuitOpen
+	}
+
+	msg, err := bs.sender.SendMessage(ctx, params, opts...)
+	if err != nil {
+		// Cancellation says nothing about the AI's health
+		if ctx.Err() == nil && isTransientError(err) {
+			bs.breaker.recordFailure()
+		}
+		return msg, err
+	}
+	bs.breaker.recordSuccess()
+	return msg, nil
+}
@@ -0,0 +1,74 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/anthropics/anthropic-sdk-go"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestCircuitBreaker creates a breaker with a fake clock. Returns a function that advances the clock
+func newTestCircuitBreaker(threshold int, cooldown time.Duration) (*circuitBreaker, func(d time.Duration)) {
+	cb := newCircuitBreaker(threshold, cooldown)
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	cb.now = func() time.Time { return now }
+	return cb, func(d time.Duration) { now = now.Add(d) }
+}
+
+func TestCircuitBreaker_OpensAfterConsecutiveFailures(t *testing.T) {
+	cb, _ := newTestCircuitBreaker(3, time.Minute)
+
+	cb.recordFailure()
+	cb.recordFailure()
+	cb.recordSuccess()
+	cb.recordFailure()
+	cb.recordFailure()
+	require.False(t, cb.isOpen(), "a success should reset the count of consecutive failures")
+
+	opened := cb.now()
+	cb.recordFailure()
+	require.True(t, cb.isOpen())
+	require.Equal(t, opened.Add(time.Minute), cb.openUntil())
+}
+
+func TestCircuitBreaker_ClosesAfterCooldown(t *testing.T) {
+	cb, advance := newTestCircuitBreaker(3, time.Minute)
+	for range 3 {
+		cb.recordFailure()
+	}
+
+	advance(time.Minute - time.Second)
+	require.True(t, cb.isOpen())
+	advance(time.Second)
+	require.False(t, cb.isOpen())
+
+	cb.recordFailure()
+	require.True(t, cb.isOpen(), "a failure right after the cooldown should reopen the breaker")
+}
+
+func TestBreakerSender_CountsOnlyUnavailability(t *testing.T) {
+	cb, _ := newTestCircuitBreaker(1, time.Minute)
+	sender := &countingErrorSenderStub{}
+	bs := breakerSender{sender: sender, breaker: cb}
+
+	// Failures particular to a request don't suggest that the AI is unavailable
+	for _, err := range []error{
+		newAnthropicError(http.StatusBadRequest),
+		newAnthropicError(http.StatusUnauthorized),
+		errors.New("failed to parse response"),
+	} {
+		sender.err = err
+		_, sendErr := bs.SendMessage(context.Background(), anthropic.MessageNewParams{})
+		require.ErrorIs(t, sendErr, err)
+		require.False(t, cb.isOpen(), "%v should not open the breaker", err)
+	}
+
+	sender.err = newAnthropicError(529)
+	_, err := bs.SendMessage(context.Background(), anthropic.MessageNewParams{})
+	require.Error(t, err)
+	require.True(t, cb.isOpen(), "an overloaded AI should open the breaker")
+}
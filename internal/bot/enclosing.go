@@ -0,0 +1,98 @@
+package bot
+
+import (
+	"path"
+	"regexp"
+	"strings"
+)
+
+var (
+	// goDeclRegex matches the first line of a top-level Go function, method, or type declaration
+	goDeclRegex = regexp.MustCompile(`^(func|type)\s`)
+	// goBlockEndRegex matches the last line of a top-level Go declaration
+	goBlockEndRegex = regexp.MustCompile(`^[})]`)
+	// pythonDeclRegex matches the first line of a Python function or class definition
+	pythonDeclRegex = regexp.MustCompile(`^\s*((async\s+)?def|class)\s+\w+`)
+	// jsDeclRegex matches the first line of a JavaScript or TypeScript function, class, or function-valued variable
+	// declaration
+	jsDeclRegex = regexp.MustCompile(`^\s*(export\s+)?(default\s+)?((async\s+)?function\b|(abstract\s+)?class\s+\w+|` +
+		`(const|let|var)\s+\w+\s*=\s*(async\s*)?(function\b|\([^)]*\)\s*=>|\w+\s*=>))`)
+)
+
+// enclosingDeclaration finds the function, class, or type declaration that encloses the given 1-based line of a file.
+// Returns the line number and trimmed first line of the declaration, or false if there is none or the language isn't
+// supported. Declarations are recognized by pattern rather than parsed, so this is a best-effort hint
+func enclosingDeclaration(filePath string, lines []string, line int) (int, string, bool) {
+	if line < 1 || line > len(lines) {
+		return 0, "", false
+	}
+
+	var declLine int
+	switch path.Ext(filePath) {
+	case ".go":
+		declLine = enclosingGoDeclaration(lines, line)
+	case ".py":
+		declLine = enclosingIndentedDeclaration(lines, line, pythonDeclRegex)
+	case ".js", ".jsx", ".mjs", ".cjs", ".ts", ".tsx":
+		declLine = enclosingIndentedDeclaration(lines, line, jsDeclRegex)
+	}
+	if declLine == 0 {
+		return 0, "", false
+	}
+
+	decl := strings.TrimSpace(lines[declLine-1])
+	decl = strings.TrimSpace(strings.TrimRight(decl, "{:"))
+	return declLine, decl, true
+}
+
+// enclosingGoDeclaration returns the 1-based line number of the top-level declaration enclosing the given line, or zero.
+// gofmt puts top-level declarations and their closing braces at the start of a line
+func enclosingGoDeclaration(lines []string, line int) int {
+	for i := line - 1; i >= 0; i-- {
+		if goDeclRegex.MatchString(lines[i]) {
+			return i + 1
+		}
+		if i < line-1 && goBlockEndRegex.MatchString(lines[i]) {
+			// The preceding declaration ended before the given line
+			return 0
+		}
+	}
+	return 0
+}
+
+// enclosingIndentedDeclaration returns the 1-based line number of the declaration enclosing the given line in a
+// language whose blocks are indented, or zero. Walks back through lines that are less indented than the given line,
+// i.e. the blocks that contain it, until one of them is a declaration
+func enclosingIndentedDeclaration(lines []string, line int, declRegex *regexp.Regexp) int {
+	// Blank lines don't have a meaningful indentation, so start from the nearest non-blank line
+	for line > 0 && strings.TrimSpace(lines[line-1]) == "" {
+		line--
+	}
+	if line == 0 {
+		return 0
+	}
+	if declRegex.MatchString(lines[line-1]) {
+		return line
+	}
+
+	ceiling := indentation(lines[line-1])
+	for i := line - 2; i >= 0 && ceiling > 0; i-- {
+		if strings.TrimSpace(lines[i]) == "" {
+			continue
+		}
+		indent := indentation(lines[i])
+		if indent >= ceiling {
+			continue
+		}
+		if declRegex.MatchString(lines[i]) {
+			return i + 1
+		}
+		ceiling = indent
+	}
+	return 0
+}
+
+// indentation returns the number of leading spaces and tabs of a line
+func indentation(line string) int {
+	return len(line) - len(strings.TrimLeft(line, " \t"))
+}
@@ -0,0 +1,79 @@
+package bot
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+const enclosingGoSource = `package server
+
+type Server struct {
+	addr string
+}
+
+func (s *Server) Start(ctx context.Context) error {
+	if s.addr == "" {
+		return errors.New("no address")
+	}
+	return nil
+}
+
+var defaultServer = &Server{}
+`
+
+const enclosingPythonSource = `import os
+
+
+class Server:
+    def __init__(self, addr):
+        self.addr = addr
+
+    async def start(self):
+        if not self.addr:
+            raise ValueError("no address")
+
+        return True
+
+
+def main():
+    Server(os.environ["ADDR"])
+`
+
+func testEnclosingDeclaration(t *testing.T, path string, source string, line int) string {
+	declLine, decl, ok := enclosingDeclaration(path, strings.Split(source, "\n"), line)
+	if !ok {
+		return ""
+	}
+	require.LessOrEqual(t, declLine, line)
+	return decl
+}
+
+func TestEnclosingDeclaration_Go(t *testing.T) {
+	require.Equal(t, "type Server struct", testEnclosingDeclaration(t, "server.go", enclosingGoSource, 4))
+	require.Equal(t, "func (s *Server) Start(ctx context.Context) error", testEnclosingDeclaration(t, "server.go", enclosingGoSource, 9))
+	require.Equal(t, "func (s *Server) Start(ctx context.Context) error", testEnclosingDeclaration(t, "server.go", enclosingGoSource, 12),
+		"the closing brace is part of the declaration")
+	require.Empty(t, testEnclosingDeclaration(t, "server.go", enclosingGoSource, 13), "between declarations")
+	require.Empty(t, testEnclosingDeclaration(t, "server.go", enclosingGoSource, 14))
+}
+
+func TestEnclosingDeclaration_Python(t *testing.T) {
+	require.Equal(t, "def __init__(self, addr)", testEnclosingDeclaration(t, "server.py", enclosingPythonSource, 6))
+	require.Equal(t, "async def start(self)", testEnclosingDeclaration(t, "server.py", enclosingPythonSource, 10),
+		"nested blocks should be skipped")
+	require.Equal(t, "async def start(self)", testEnclosingDeclaration(t, "server.py", enclosingPythonSource, 11),
+		"blank lines belong to the block above")
+	require.Equal(t, "def main()", testEnclosingDeclaration(t, "server.py", enclosingPythonSource, 17))
+	require.Empty(t, testEnclosingDeclaration(t, "server.py", enclosingPythonSource, 1))
+}
+
+func TestEnclosingDeclaration_JavaScript(t *testing.T) {
+	source := "export const handler = async (req) => {\n  if (req) {\n    return 1;\n  }\n};\n"
+	require.Equal(t, "export const handler = async (req) =>", testEnclosingDeclaration(t, "handler.js", source, 3))
+}
+
+func TestEnclosingDeclaration_UnknownExtension(t *testing.T) {
+	require.Empty(t, testEnclosingDeclaration(t, "server.txt", enclosingGoSource, 9))
+}
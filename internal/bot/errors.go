@@ -0,0 +1,50 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+
+	"github.com/anthropics/anthropic-sdk-go"
+	"github.com/google/go-github/v72/github"
+)
+
+// isTransientError returns true if the given error is likely to go away on its own, e.g. a rate limit, a server error,
+// or a network blip, such that trying the task again later may succeed. Anything else, e.g. a malformed response, a
+// rejected request, or a bug, is considered permanent
+func isTransientError(err error) bool {
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return true
+	}
+
+	var rateLimitErr *github.RateLimitError
+	var abuseErr *github.AbuseRateLimitError
+	if errors.As(err, &rateLimitErr) || errors.As(err, &abuseErr) {
+		return true
+	}
+	var githubErr *github.ErrorResponse
+	if errors.As(err, &githubErr) && githubErr.Response != nil {
+		return isTransientStatus(githubErr.Response.StatusCode)
+	}
+	var anthropicErr *anthropic.Error
+	if errors.As(err, &anthropicErr) {
+		return isTransientStatus(anthropicErr.StatusCode)
+	}
+
+	// Failures to send a request or read a response, e.g. connection resets, timeouts, and DNS failures
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
+
+// isTransientStatus returns true if a response with the given HTTP status code may succeed if the request is retried
+func isTransientStatus(statusCode int) bool {
+	switch statusCode {
+	case http.StatusRequestTimeout, http.StatusTooManyRequests:
+		return true
+	default:
+		// Includes 529, which the Anthropic API uses to report that it is overloaded
+		return statusCode >= 500
+	}
+}
@@ -0,0 +1,45 @@
+package bot
+
+import "sync"
+
+// keyedMutex provides mutual exclusion per key. The zero value is ready to use
+type keyedMutex[K comparable] struct {
+	mu    sync.Mutex
+	locks map[K]*refCountedMutex
+}
+
+type refCountedMutex struct {
+	sync.Mutex
+	refs int // The number of goroutines holding or waiting for the lock
+}
+
+// Lock blocks until the lock for the given key is available, then acquires it
+func (km *keyedMutex[K]) Lock(key K) {
+	km.mu.Lock()
+	if km.locks == nil {
+		km.locks = map[K]*refCountedMutex{}
+	}
+	l, ok := km.locks[key]
+	if !ok {
+		l = &refCountedMutex{}
+		km.locks[key] = l
+	}
+	l.refs++
+	km.mu.Unlock()
+
+	l.Lock()
+}
+
+// Unlock releases the lock for the given key, which must be held
+func (km *keyedMutex[K]) Unlock(key K) {
+	km.mu.Lock()
+	defer km.mu.Unlock()
+
+	l := km.locks[key]
+	l.refs--
+	if l.refs == 0 {
+		// Nobody else is waiting, so forget the lock to keep the map from growing without bound
+		delete(km.locks, key)
+	}
+	l.Unlock()
+}
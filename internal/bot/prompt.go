@@ -3,11 +3,13 @@ package bot
 import (
 	"bytes"
 	_ "embed"
+	"encoding/base64"
 	"fmt"
 	"strconv"
 	"strings"
 	"text/template"
 
+	"github.com/anthropics/anthropic-sdk-go"
 	"github.com/google/go-github/v72/github"
 
 	"github.com/cchalm/blundering-savant/internal/task"
@@ -23,7 +25,13 @@ var repositoryPromptTemplate string
 //go:embed task_prompt.tmpl
 var taskPromptTemplate string
 
-func buildSystemPrompt(botName string, botUsername string) (string, error) {
+// dryRunNotice tells the AI that its changes to GitHub aren't really made. It ends the system prompt in dry-run mode,
+// whether the prompt is built in or replaced by the operator
+const dryRunNotice = `<dry_run>
+You are running in dry-run mode, so that your operators can see what you would do. Tools that would change anything on GitHub, such as posting comments, adding reactions, changing labels, validating changes, or publishing changes, are not actually executed; their results will say so. Behave exactly as you normally would, and continue as though those tools succeeded.
+</dry_run>`
+
+func buildSystemPrompt(botName string, botUsername string, dryRun bool) (string, error) {
 	tmpl, err := template.New("system prompt").Parse(systemPromptTemplate)
 	if err != nil {
 		return "", fmt.Errorf("failed to parse system prompt template: %w", err)
@@ -31,11 +39,15 @@ func buildSystemPrompt(botName string, botUsername string) (string, error) {
 
 	var buf bytes.Buffer
 	err = tmpl.Execute(&buf, struct {
-		BotName     string
-		BotUsername string
+		BotName      string
+		BotUsername  string
+		DryRun       bool
+		DryRunNotice string
 	}{
-		BotName:     botName,
-		BotUsername: botUsername,
+		BotName:      botName,
+		BotUsername:  botUsername,
+		DryRun:       dryRun,
+		DryRunNotice: dryRunNotice,
 	})
 	if err != nil {
 		return "", fmt.Errorf("failed to execute system prompt template: %w", err)
@@ -74,6 +86,8 @@ func buildPrompt(tsk task.Task) (repositoryContent, taskContent string, err erro
 			}
 			return diff
 		},
+		"untrusted":  wrapUntrusted,
+		"neutralize": neutralizeInjection,
 		"indent": func(prefix string, text string) string {
 			prefixed := strings.Builder{}
 			for line := range strings.Lines(text) {
@@ -111,6 +125,19 @@ func buildPrompt(tsk task.Task) (repositoryContent, taskContent string, err erro
 	return repositoryBuf.String(), taskBuf.String(), nil
 }
 
+// buildImageBlocks converts the images embedded in the task's issue and comments into content blocks, each preceded by
+// a label saying where it came from so that the AI can tell which image the text refers to
+func buildImageBlocks(images []task.Image) []anthropic.ContentBlockParamUnion {
+	var blocks []anthropic.ContentBlockParamUnion
+	for _, image := range images {
+		blocks = append(blocks,
+			anthropic.NewTextBlock(fmt.Sprintf("Image embedded in the issue or its comments as %s:", image.URL)),
+			anthropic.NewImageBlockBase64(image.MediaType, base64.StdEncoding.EncodeToString(image.Data)),
+		)
+	}
+	return blocks
+}
+
 // Helper functions to convert GitHub types to template types
 
 func convertGitHubUser(user *github.User) userData {
@@ -226,7 +253,14 @@ func buildTemplateData(tsk task.Task) promptTemplateData {
 
 	data.IssueNumber = tsk.Issue.Number
 	data.IssueTitle = tsk.Issue.Title
-	data.IssueBody = tsk.Issue.Body
+	data.IssueBody = formatIssueBody(tsk.Issue)
+	for _, issue := range tsk.AdditionalIssues {
+		data.AdditionalIssues = append(data.AdditionalIssues, additionalIssueData{
+			Number: issue.Number,
+			Title:  issue.Title,
+			Body:   formatIssueBody(issue),
+		})
+	}
 
 	// Pull request information
 	if tsk.PullRequest != nil {
@@ -303,12 +337,48 @@ func buildTemplateData(tsk task.Task) promptTemplateData {
 		data.PRReviewCommentsRequiringResponses = append(data.PRReviewCommentsRequiringResponses, convertGitHubReviewComment(comment))
 	}
 
+	data.AttentionReason = attentionReasonDescriptions[tsk.AttentionReason]
+	data.MaxPRLines = tsk.RepoConfig.MaxPRLines
+	if tsk.Plan.Required {
+		data.Plan = &planData{NeedsApproval: tsk.NeedsPlanApproval()}
+		if tsk.Plan.Comment != nil {
+			data.Plan.Body = planFromComment(tsk.Plan.Comment.GetBody())
+		}
+	}
+
 	data.HasUnpublishedChanges = tsk.HasUnpublishedChanges
 	data.ValidationResult = tsk.ValidationResult
+	data.BaseSyncResult = tsk.BaseSyncResult
+	data.HasMergeConflicts = tsk.HasMergeConflicts()
 
 	return data
 }
 
+// planFromComment extracts the plan from a comment posted by the post_plan tool
+func planFromComment(body string) string {
+	body = strings.TrimPrefix(body, task.PlanCommentHeading)
+	body = strings.TrimSpace(body)
+	return strings.TrimSpace(strings.TrimSuffix(body, planApprovalInstructions))
+}
+
+// formatIssueBody returns the body of the given issue for the prompt. The fields of issues submitted with an issue form
+// are presented as labeled values rather than as the markdown headings that GitHub renders them with
+func formatIssueBody(issue task.GithubIssue) string {
+	if len(issue.FormFields) == 0 {
+		return issue.Body
+	}
+
+	var fields []string
+	for _, field := range issue.FormFields {
+		if strings.Contains(field.Value, "\n") {
+			fields = append(fields, fmt.Sprintf("**%s:**\n%s", field.Label, field.Value))
+		} else {
+			fields = append(fields, fmt.Sprintf("**%s:** %s", field.Label, field.Value))
+		}
+	}
+	return strings.Join(fields, "\n\n")
+}
+
 // truncateString truncates a string to a maximum length
 func truncateString(s string, maxLen int) string {
 	if len(s) <= maxLen {
@@ -370,13 +440,37 @@ type reviewCommentData struct {
 // reviewCommentThreadData represents a thread of PR review comments
 type reviewCommentThreadData []reviewCommentData
 
+// attentionReasonDescriptions tells the AI why it was invoked for a task, phrased to follow "because"
+var attentionReasonDescriptions = map[task.AttentionReason]string{
+	task.AttentionNewIssue:      "the issue is new and has not been worked on yet",
+	task.AttentionIssueComment:  "a comment on the issue is awaiting your response",
+	task.AttentionPRComment:     "a comment on the pull request is awaiting your response",
+	task.AttentionReviewComment: "a review comment on the pull request is awaiting your response",
+	task.AttentionCommand:       "a user asked for the task to be worked on again",
+	task.AttentionBotTurnLabel:  "someone added the \"bot-turn\" label to the issue to ask you to take another look",
+	task.AttentionPlanApproved:  "a human approved the plan you proposed",
+}
+
+// planData describes the plan for an issue in plan-first mode
+type planData struct {
+	NeedsApproval bool   // Whether the AI must have a plan approved before changing code
+	Body          string // The AI's latest plan, without the comment's heading and instructions. May be empty
+}
+
 // promptTemplateData holds the data used to render the prompt template
+type additionalIssueData struct {
+	Number int
+	Title  string
+	Body   string
+}
+
 type promptTemplateData struct {
 	Repository             string
 	MainLanguage           string
 	IssueNumber            int
 	IssueTitle             string
 	IssueBody              string
+	AdditionalIssues       []additionalIssueData // Other issues that the pull request must also fix
 	PullRequest            *pullRequestData
 	StyleGuides            map[string]string // path -> content
 	ReadmeContent          string
@@ -391,6 +485,11 @@ type promptTemplateData struct {
 	IssueCommentsRequiringResponses    []commentData
 	PRCommentsRequiringResponses       []commentData
 	PRReviewCommentsRequiringResponses []reviewCommentData
+	AttentionReason                    string    // Why the bot was invoked, phrased to follow "because". May be empty
+	MaxPRLines                         int       // The repository's limit on the size of pull requests. Zero means no limit
+	Plan                               *planData // Nil unless the issue is in plan-first mode
 	HasUnpublishedChanges              bool
 	ValidationResult                   validator.ValidationResult
+	BaseSyncResult                     *validator.ValidationResult
+	HasMergeConflicts                  bool // Whether GitHub reports that the pull request conflicts with its base branch
 }
@@ -0,0 +1,51 @@
+package bot
+
+import (
+	"regexp"
+	"strings"
+)
+
+// untrustedContentTag is the name of the tag that delimits content written by GitHub users in prompts. The system
+// prompt tells the AI to treat everything inside these tags as data rather than instructions
+const untrustedContentTag = "untrusted-content"
+
+// untrustedContentTagPattern matches opening and closing untrusted content tags, so that content can't end its own
+// block early and smuggle text outside of it
+var untrustedContentTagPattern = regexp.MustCompile(`(?i)<(\s*/?\s*)` + untrustedContentTag)
+
+// injectionPatterns match phrases that are common in attempts to hijack the AI with instructions embedded in issues and
+// comments. They are flagged rather than removed, so that the AI can still see and report them
+var injectionPatterns = []*regexp.Regexp{
+	regexp.MustCompile(`(?i)\b(?:ignore|disregard|forget|override)\s+(?:all\s+|any\s+)?(?:of\s+)?(?:the\s+|your\s+)?(?:previous|prior|above|earlier|preceding|system)\s+(?:instructions|prompts?|rules|directions)\b`),
+	regexp.MustCompile(`(?i)\byou\s+are\s+now\s+(?:a|an|in)\b`),
+	regexp.MustCompile(`(?i)\bnew\s+(?:system\s+)?instructions\s*:`),
+	regexp.MustCompile(`(?im)^[ \t]*(?:system|human|assistant)[ \t]*:`),
+	regexp.MustCompile(`(?i)</?\s*(?:system|instructions)\s*>`),
+}
+
+// neutralizeInjection escapes untrusted content tags in text, and flags phrases that look like attempts at prompt
+// injection. Everything else, including markdown, is left as is
+func neutralizeInjection(text string) string {
+	text = untrustedContentTagPattern.ReplaceAllString(text, "&lt;${1}"+untrustedContentTag)
+	for _, pattern := range injectionPatterns {
+		text = pattern.ReplaceAllStringFunc(text, func(match string) string {
+			return "[possible prompt injection: " + strings.TrimSpace(match) + "]"
+		})
+	}
+	return text
+}
+
+// wrapUntrusted neutralizes text written by a GitHub user and wraps it, quoted, in an untrusted content block
+func wrapUntrusted(text string) string {
+	var sb strings.Builder
+	sb.WriteString("<" + untrustedContentTag + ">\n")
+	for line := range strings.Lines(neutralizeInjection(text)) {
+		sb.WriteString("> ")
+		sb.WriteString(line)
+	}
+	if !strings.HasSuffix(sb.String(), "\n") {
+		sb.WriteString("\n")
+	}
+	sb.WriteString("</" + untrustedContentTag + ">")
+	return sb.String()
+}
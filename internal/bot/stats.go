@@ -0,0 +1,93 @@
+package bot
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/cchalm/blundering-savant/internal/ai"
+)
+
+// TaskOutcome describes how work on a task ended
+type TaskOutcome string
+
+const (
+	// TaskCompleted means that the AI finished its turn
+	TaskCompleted TaskOutcome = "completed"
+	// TaskBlocked means that the task failed and was labeled as blocked
+	TaskBlocked TaskOutcome = "blocked"
+	// TaskHandoff means that the bot handed the task to a human, e.g. to answer a question or because it exceeded its
+	// budget, and will resume it once they have responded
+	TaskHandoff TaskOutcome = "handoff"
+	// TaskRetrying means that the task was interrupted or failed with an error that is expected to go away, and will be
+	// picked up again later
+	TaskRetrying TaskOutcome = "retrying"
+)
+
+// TaskStats records how the bot performed on a single task
+type TaskStats struct {
+	Owner           string         `json:"owner"`
+	Repo            string         `json:"repo"`
+	IssueNumber     int            `json:"issue_number"`
+	FinishedAt      time.Time      `json:"finished_at"`
+	DurationSeconds float64        `json:"duration_seconds"`
+	Outcome         TaskOutcome    `json:"outcome"`
+	Iterations      int            `json:"iterations"` // The number of AI responses handled
+	ToolCalls       map[string]int `json:"tool_calls"` // The number of calls to each tool, by tool name
+	Usage           ai.Usage       `json:"usage"`
+	// EstimatedCostUSD is the estimated cost of Usage, or nil if some of the models used have no known price
+	EstimatedCostUSD *float64 `json:"estimated_cost_usd,omitempty"`
+}
+
+// StatsSink receives a record of each task that the bot works on. Implementations must be safe for concurrent use
+type StatsSink interface {
+	Record(stats TaskStats) error
+}
+
+// taskProgress counts what the AI did while working on a task, for its stats record
+type taskProgress struct {
+	iterations int
+	toolCalls  map[string]int
+	// handedOff is set when the bot hands the task to a human, e.g. to answer a question
+	handedOff bool
+}
+
+func newTaskProgress() *taskProgress {
+	return &taskProgress{toolCalls: map[string]int{}}
+}
+
+// JSONLStatsSink implements StatsSink by appending each record to a file as a line of JSON
+type JSONLStatsSink struct {
+	path string
+
+	mu sync.Mutex
+}
+
+func NewJSONLStatsSink(path string) *JSONLStatsSink {
+	return &JSONLStatsSink{path: path}
+}
+
+// Record appends the given stats to the file, creating it if it doesn't exist
+func (s *JSONLStatsSink) Record(stats TaskStats) error {
+	line, err := json.Marshal(stats)
+	if err != nil {
+		return fmt.Errorf("failed to marshal task stats: %w", err)
+	}
+	line = append(line, '\n')
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	f, err := os.OpenFile(s.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0666)
+	if err != nil {
+		return fmt.Errorf("failed to open stats file: %w", err)
+	}
+	// Write the line in a single call, so that a record is never interleaved with another process's
+	if _, err := f.Write(line); err != nil {
+		_ = f.Close()
+		return fmt.Errorf("failed to write stats: %w", err)
+	}
+	return f.Close()
+}
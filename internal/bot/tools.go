@@ -5,14 +5,34 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"log"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"io"
+	"maps"
+	"mime"
 	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+	"slices"
+	"strconv"
 	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
 
 	"github.com/anthropics/anthropic-sdk-go"
+	"github.com/cchalm/blundering-savant/internal/ai"
+	"github.com/cchalm/blundering-savant/internal/githubgql"
+	"github.com/cchalm/blundering-savant/internal/logging"
+	"github.com/cchalm/blundering-savant/internal/metrics"
 	"github.com/cchalm/blundering-savant/internal/task"
+	"github.com/cchalm/blundering-savant/internal/validator"
+	"github.com/cchalm/blundering-savant/internal/vcs"
 	"github.com/cchalm/blundering-savant/internal/workspace"
 	"github.com/google/go-github/v72/github"
+	"golang.org/x/net/html"
 )
 
 // AnthropicTool defines the interface for all tools
@@ -32,13 +52,44 @@ type AnthropicTool interface {
 	// Note that this function does not return a string, because a response should already have been added to the
 	// conversation from the original run of this tool.
 	Replay(ctx context.Context, block anthropic.ToolUseBlock, toolCtx *ToolContext) error
+
+	// IsMutating returns true if the tool has side effects beyond the workspace's in-memory state, e.g. posting to
+	// GitHub or pushing commits. Mutating tools are not run in dry-run mode
+	IsMutating() bool
+
+	// IsPolling returns true if the tool reports state that changes without the AI's involvement, e.g. the progress of
+	// CI, such that calling it repeatedly with the same input is expected rather than a sign of a loop
+	IsPolling() bool
 }
 
 // ToolContext provides context needed by tools during execution
 type ToolContext struct {
-	Workspace    Workspace
-	Task         task.Task
+	Workspace Workspace
+	Task      task.Task
+	VCS       vcs.Provider // Where comments, reactions, labels, and reviews are posted
+	// GithubClient is used by tools that rely on GitHub-specific APIs, such as GraphQL, that Provider doesn't cover
 	GithubClient *github.Client
+	BotUser      *github.User // The GitHub user the bot acts as
+
+	Usage       *ai.UsageTracker // Token usage of the task so far
+	Prices      ai.PriceTable    // Used to estimate the cost of Usage
+	UsageFooter bool             // Whether to append usage to the descriptions of new pull requests
+
+	DryRun bool // If true, mutating tools are logged instead of run
+
+	Metrics metrics.Metrics // Records tool calls and their latency. May be nil
+
+	MaxFileBytes   int64               // The size limit of files written by tools. Zero means no limit
+	ProtectedPaths task.ProtectedPaths // Paths that tools may not write to. Nil means none
+	// MaxValidationOutputLines caps the lines of validation and test output shown to the AI. Zero means no limit
+	MaxValidationOutputLines int
+
+	// AwaitingHumanInput is set by tools that have asked a human a question, to end the conversation until they reply
+	AwaitingHumanInput bool
+	// Concluded is set by tools that have finished the task, to end the conversation for good
+	Concluded bool
+	// IssuesCreated counts the issues that the AI has opened during the task, to cap them
+	IssuesCreated int
 }
 
 // ToolInputError represents an error that could be recovered by correcting inputs to the tool. This error will be
@@ -57,7 +108,17 @@ func (tie ToolInputError) Unwrap() error {
 
 // Base tool implementation helper
 type BaseTool struct {
-	Name string
+	Name     string
+	Mutating bool // See AnthropicTool.IsMutating
+	Polling  bool // See AnthropicTool.IsPolling
+}
+
+func (bt BaseTool) IsMutating() bool {
+	return bt.Mutating
+}
+
+func (bt BaseTool) IsPolling() bool {
+	return bt.Polling
 }
 
 // parseInputJSON is a helper to unmarshal tool input
@@ -83,6 +144,9 @@ type TextEditorInput struct {
 	FileText   string `json:"file_text,omitempty"`
 	ViewRange  []int  `json:"view_range,omitempty"`
 	InsertLine int    `json:"insert_line,omitempty"`
+	// Occurrence selects which match of old_str to replace, starting from 1, when old_str isn't unique. This isn't part
+	// of the built-in tool's schema, so the AI learns about it from the error returned for ambiguous matches
+	Occurrence int `json:"occurrence,omitempty"`
 }
 
 // NewTextEditorTool creates a new text editor tool
@@ -129,6 +193,18 @@ func (t *TextEditorTool) run(ctx context.Context, block anthropic.ToolUseBlock,
 		return nil, fmt.Errorf("error parsing input: %w", err)
 	}
 
+	maxFileBytes, protectedPaths := toolCtx.MaxFileBytes, toolCtx.ProtectedPaths
+	if replay {
+		// The original run was within the limits at the time, and the limits may have changed since
+		maxFileBytes, protectedPaths = 0, nil
+	}
+	switch input.Command {
+	case "str_replace", "create", "insert":
+		if err := checkProtectedPath(input.Path, protectedPaths); err != nil {
+			return nil, fmt.Errorf("error running command '%s': %w", input.Command, err)
+		}
+	}
+
 	var result string
 	switch input.Command {
 	case "view":
@@ -138,11 +214,11 @@ func (t *TextEditorTool) run(ctx context.Context, block anthropic.ToolUseBlock,
 		}
 		result, err = t.executeView(ctx, input, toolCtx.Workspace)
 	case "str_replace":
-		result, err = t.executeStrReplace(ctx, input, toolCtx.Workspace)
+		result, err = t.executeStrReplace(ctx, input, toolCtx.Workspace, maxFileBytes)
 	case "create":
-		result, err = t.executeCreate(ctx, input, toolCtx.Workspace)
+		result, err = t.executeCreate(ctx, input, toolCtx.Workspace, maxFileBytes)
 	case "insert":
-		result, err = t.executeInsert(ctx, input, toolCtx.Workspace)
+		result, err = t.executeInsert(ctx, input, toolCtx.Workspace, maxFileBytes)
 	case "undo_edit":
 		result = ""
 		err = ToolInputError{fmt.Errorf("undo_edit not supported")}
@@ -157,6 +233,13 @@ func (t *TextEditorTool) run(ctx context.Context, block anthropic.ToolUseBlock,
 	return &result, nil
 }
 
+// maxViewLines is the number of lines of a file that the view command shows when no range is given
+const maxViewLines = 1000
+
+// maxViewBytes caps the size of the content that the view command shows, so that files with very long lines, e.g.
+// minified ones, can't use up the token budget within maxViewLines
+const maxViewBytes = 100_000
+
 // Implementation methods for each command
 func (t *TextEditorTool) executeView(ctx context.Context, input *TextEditorInput, fs workspace.FileSystem) (string, error) {
 	if fs == nil {
@@ -188,9 +271,16 @@ func (t *TextEditorTool) executeView(ctx context.Context, input *TextEditorInput
 		return "", fmt.Errorf("error reading file: %w", err)
 	}
 
-	if len(input.ViewRange) == 2 {
-		startLine := input.ViewRange[0]
-		endLine := input.ViewRange[1]
+	return formatFileView(input.Path, content, input.ViewRange), nil
+}
+
+// formatFileView numbers the lines of a file's content for the AI to view. If viewRange holds a start and end line,
+// only those lines are shown, preceded by the declaration that the first of them is inside, if known. Otherwise the
+// content is truncated to maxViewLines. Either way, lines beyond maxViewBytes are cut off
+func formatFileView(path string, content string, viewRange []int) string {
+	if len(viewRange) == 2 {
+		startLine := viewRange[0]
+		endLine := viewRange[1]
 
 		lines := strings.Split(content, "\n")
 		if endLine == -1 {
@@ -205,21 +295,72 @@ func (t *TextEditorTool) executeView(ctx context.Context, input *TextEditorInput
 		}
 
 		var result strings.Builder
-		for i := startLine - 1; i < endLine; i++ {
-			result.WriteString(fmt.Sprintf("%d: %s\n", i+1, lines[i]))
+		if declLine, decl, ok := enclosingDeclaration(path, lines, startLine); ok && declLine < startLine {
+			result.WriteString(fmt.Sprintf("[Line %d is inside `%s`, declared at line %d]\n", startLine, decl, declLine))
+		}
+		if startLine > endLine {
+			return result.String()
+		}
+		shown := viewableLines(lines[startLine-1:endLine], endLine-startLine+1)
+		for i := startLine - 1; i < startLine-1+shown; i++ {
+			result.WriteString(fmt.Sprintf("%d: %s\n", i+1, capViewLine(lines[i])))
+		}
+		if lastShown := startLine - 1 + shown; lastShown < endLine {
+			result.WriteString(fmt.Sprintf("[Range truncated at %d bytes: showing lines %d-%d. Use view_range to view "+
+				"the rest, e.g. [%d, %d]]\n", maxViewBytes, startLine, lastShown, lastShown+1, endLine))
+		}
+		return result.String()
+	}
+
+	lines := strings.Split(content, "\n")
+	shown := viewableLines(lines, maxViewLines)
+	shownLines := make([]string, shown)
+	for i, line := range lines[:shown] {
+		shownLines[i] = capViewLine(line)
+	}
+	result := numberLines(strings.Join(shownLines, "\n"))
+	if shown < len(lines) {
+		// Viewing a huge file, e.g. a generated one, in full could use up the token budget in one go
+		result += fmt.Sprintf("\n"+ai.TruncatedViewNote+"showing lines 1-%d of %d, %d more lines not shown. Use view_range to "+
+			"view the rest, e.g. [%d, %d]]\n", shown, len(lines), len(lines)-shown, shown+1,
+			min(shown+maxViewLines, len(lines)))
+	}
+	return result
+}
+
+// viewableLines returns how many of the given lines, from the first, fit within maxLines and maxViewBytes. At least one
+// line is always viewable, so that views make progress through a file; see capViewLine
+func viewableLines(lines []string, maxLines int) int {
+	size := 0
+	for i, line := range lines {
+		size += min(len(line), maxViewBytes) + 1
+		if i > 0 && (i == maxLines || size > maxViewBytes) {
+			return i
 		}
-		return result.String(), nil
 	}
+	return len(lines)
+}
+
+// capViewLine cuts off a line that is longer than maxViewBytes on its own
+func capViewLine(line string) string {
+	if len(line) <= maxViewBytes {
+		return line
+	}
+	shown := truncateAtRuneBoundary(line, maxViewBytes)
+	return fmt.Sprintf("%s %s%d more bytes of this line not shown]", shown, ai.TruncatedViewNote, len(line)-len(shown))
+}
 
+// numberLines prefixes each line of content with its 1-based line number
+func numberLines(content string) string {
 	lines := strings.Split(content, "\n")
 	var result strings.Builder
 	for i, line := range lines {
 		result.WriteString(fmt.Sprintf("%d: %s\n", i+1, line))
 	}
-	return result.String(), nil
+	return result.String()
 }
 
-func (t *TextEditorTool) executeStrReplace(ctx context.Context, input *TextEditorInput, fs workspace.FileSystem) (string, error) {
+func (t *TextEditorTool) executeStrReplace(ctx context.Context, input *TextEditorInput, fs workspace.FileSystem, maxFileBytes int64) (string, error) {
 	content, err := fs.Read(ctx, input.Path)
 	if errors.Is(err, workspace.ErrFileNotFound) {
 		return "", ToolInputError{err}
@@ -229,13 +370,30 @@ func (t *TextEditorTool) executeStrReplace(ctx context.Context, input *TextEdito
 
 	count := strings.Count(content, input.OldStr)
 	if count == 0 {
-		return "", ToolInputError{fmt.Errorf("old_str not found in file")}
+		return "", ToolInputError{oldStrNotFoundError(content, input.OldStr)}
 	}
-	if count > 1 {
-		return "", ToolInputError{fmt.Errorf("old_str found %d times in file, must be unique", count)}
+
+	var start int
+	switch {
+	case input.Occurrence < 0 || input.Occurrence > count:
+		return "", ToolInputError{fmt.Errorf("occurrence %d is out of range, old_str found %d times in file", input.Occurrence, count)}
+	case input.Occurrence > 0:
+		start = nthIndex(content, input.OldStr, input.Occurrence)
+	case count > 1:
+		lines := []string{}
+		for i := range count {
+			lines = append(lines, strconv.Itoa(lineNumberAt(content, nthIndex(content, input.OldStr, i+1))))
+		}
+		return "", ToolInputError{fmt.Errorf("old_str found %d times in file, at lines %s. Include more context to make it "+
+			"unique, or set \"occurrence\" to the number of the match to replace, starting from 1", count, strings.Join(lines, ", "))}
+	default:
+		start = strings.Index(content, input.OldStr)
 	}
 
-	newContent := strings.Replace(content, input.OldStr, input.NewStr, 1)
+	newContent := content[:start] + input.NewStr + content[start+len(input.OldStr):]
+	if err := checkFileSize(input.Path, newContent, maxFileBytes); err != nil {
+		return "", err
+	}
 	err = fs.Write(ctx, input.Path, newContent)
 	if err != nil {
 		return "", fmt.Errorf("error writing file: %w", err)
@@ -244,7 +402,98 @@ func (t *TextEditorTool) executeStrReplace(ctx context.Context, input *TextEdito
 	return fmt.Sprintf("Successfully replaced text in %s", input.Path), nil
 }
 
-func (t *TextEditorTool) executeCreate(ctx context.Context, input *TextEditorInput, fs workspace.FileSystem) (string, error) {
+// oldStrNotFoundError builds an error for an old_str that does not appear in content. If old_str appears with different
+// whitespace, the error describes where, so that the AI can correct its input rather than guessing
+func oldStrNotFoundError(content string, oldStr string) error {
+	matches := findWhitespaceInsensitiveMatches(content, oldStr)
+	switch len(matches) {
+	case 0:
+		return fmt.Errorf("old_str not found in file")
+	case 1:
+		start, end := matches[0][0], matches[0][1]
+		return fmt.Errorf("old_str not found in file, but text differing only in whitespace was found at line %d. "+
+			"The exact text in the file, between the markers, is:\n<<<\n%s\n>>>", lineNumberAt(content, start), content[start:end])
+	default:
+		lines := []string{}
+		for _, match := range matches {
+			lines = append(lines, strconv.Itoa(lineNumberAt(content, match[0])))
+		}
+		return fmt.Errorf("old_str not found in file, but text differing only in whitespace was found at lines %s. "+
+			"View those lines to find the exact text", strings.Join(lines, ", "))
+	}
+}
+
+// findWhitespaceInsensitiveMatches returns the start and end offsets of non-overlapping substrings of content that
+// match needle when all runs of whitespace are considered equal, and leading and trailing whitespace in needle is
+// ignored
+func findWhitespaceInsensitiveMatches(content string, needle string) [][2]int {
+	normalizedNeedle := strings.Join(strings.Fields(needle), " ")
+	if normalizedNeedle == "" {
+		return nil
+	}
+
+	// Collapse whitespace in content the same way, remembering the original offset of each normalized byte
+	var normalized strings.Builder
+	offsets := []int{}
+	inWhitespace := false
+	for i := 0; i < len(content); {
+		r, size := utf8.DecodeRuneInString(content[i:])
+		if unicode.IsSpace(r) {
+			if !inWhitespace {
+				normalized.WriteByte(' ')
+				offsets = append(offsets, i)
+			}
+			inWhitespace = true
+		} else {
+			inWhitespace = false
+			normalized.WriteString(content[i : i+size])
+			for j := range size {
+				offsets = append(offsets, i+j)
+			}
+		}
+		i += size
+	}
+	normalizedContent := normalized.String()
+
+	var matches [][2]int
+	for from := 0; ; {
+		idx := strings.Index(normalizedContent[from:], normalizedNeedle)
+		if idx == -1 {
+			break
+		}
+		start := from + idx
+		end := start + len(normalizedNeedle)
+		// The needle ends with a non-whitespace byte, so the match ends right after that byte's original offset
+		matches = append(matches, [2]int{offsets[start], offsets[end-1] + 1})
+		from = end
+	}
+	return matches
+}
+
+// nthIndex returns the offset of the nth non-overlapping occurrence of substr in s, starting from 1, or -1 if there are
+// fewer than n occurrences
+func nthIndex(s string, substr string, n int) int {
+	offset := 0
+	for range n - 1 {
+		idx := strings.Index(s[offset:], substr)
+		if idx == -1 {
+			return -1
+		}
+		offset += idx + len(substr)
+	}
+	idx := strings.Index(s[offset:], substr)
+	if idx == -1 {
+		return -1
+	}
+	return offset + idx
+}
+
+// lineNumberAt returns the 1-based line number of the given byte offset in content
+func lineNumberAt(content string, offset int) int {
+	return strings.Count(content[:offset], "\n") + 1
+}
+
+func (t *TextEditorTool) executeCreate(ctx context.Context, input *TextEditorInput, fs workspace.FileSystem, maxFileBytes int64) (string, error) {
 	exists, err := fs.FileExists(ctx, input.Path)
 	if err != nil {
 		return "", fmt.Errorf("error checking file existence: %w", err)
@@ -252,6 +501,9 @@ func (t *TextEditorTool) executeCreate(ctx context.Context, input *TextEditorInp
 	if exists {
 		return "", ToolInputError{fmt.Errorf("file already exists: %s", input.Path)}
 	}
+	if err := checkFileSize(input.Path, input.FileText, maxFileBytes); err != nil {
+		return "", err
+	}
 
 	err = fs.Write(ctx, input.Path, input.FileText)
 	if err != nil {
@@ -261,7 +513,7 @@ func (t *TextEditorTool) executeCreate(ctx context.Context, input *TextEditorInp
 	return fmt.Sprintf("Successfully created file %s", input.Path), nil
 }
 
-func (t *TextEditorTool) executeInsert(ctx context.Context, input *TextEditorInput, fs workspace.FileSystem) (string, error) {
+func (t *TextEditorTool) executeInsert(ctx context.Context, input *TextEditorInput, fs workspace.FileSystem, maxFileBytes int64) (string, error) {
 	content, err := fs.Read(ctx, input.Path)
 	if errors.Is(err, workspace.ErrFileNotFound) {
 		return "", ToolInputError{err}
@@ -289,6 +541,9 @@ func (t *TextEditorTool) executeInsert(ctx context.Context, input *TextEditorInp
 	}
 
 	newContent := strings.Join(result, "\n")
+	if err := checkFileSize(input.Path, newContent, maxFileBytes); err != nil {
+		return "", err
+	}
 	err = fs.Write(ctx, input.Path, newContent)
 	if err != nil {
 		return "", fmt.Errorf("error writing file: %w", err)
@@ -297,6 +552,26 @@ func (t *TextEditorTool) executeInsert(ctx context.Context, input *TextEditorInp
 	return fmt.Sprintf("Successfully inserted text at line %d in %s", lineNum, input.Path), nil
 }
 
+// checkFileSize returns a ToolInputError if writing content to the file at path would make it larger than maxBytes.
+// Zero means no limit
+func checkFileSize(path string, content string, maxBytes int64) error {
+	if maxBytes > 0 && int64(len(content)) > maxBytes {
+		return ToolInputError{fmt.Errorf("%s would be %d bytes, more than the limit of %d bytes. Split it into smaller "+
+			"files, or leave out generated or bulk data", path, len(content), maxBytes)}
+	}
+	return nil
+}
+
+// checkProtectedPath returns a ToolInputError if the file at path matches one of the protected path patterns
+func checkProtectedPath(path string, protectedPaths task.ProtectedPaths) error {
+	if pattern, ok := protectedPaths.Match(path); ok {
+		return ToolInputError{fmt.Errorf("%s is protected by the pattern '%s', because files like it are usually vendored "+
+			"or generated, and must not be edited by hand. Change the code it comes from instead, or, if it really must "+
+			"change, ask a maintainer to change it or to allow it in the repository's config", path, pattern)}
+	}
+	return nil
+}
+
 // ValidateChangesTool implements the validate_changes tool
 type ValidateChangesTool struct {
 	BaseTool
@@ -310,7 +585,7 @@ type ValidateChangesInput struct {
 // NewValidateChangesTool creates a new create pull request tool
 func NewValidateChangesTool() *ValidateChangesTool {
 	return &ValidateChangesTool{
-		BaseTool: BaseTool{Name: "validate_changes"},
+		BaseTool: BaseTool{Name: "validate_changes", Mutating: true},
 	}
 }
 
@@ -365,12 +640,19 @@ func (t *ValidateChangesTool) Run(ctx context.Context, block anthropic.ToolUseBl
 		}
 		return nil, fmt.Errorf("failed to commit changes: %w", err)
 	}
+	// Remember the result for get_validation_status
+	toolCtx.Task.ValidationResult = result
 
 	var msg string
+	if result.NoChanges {
+		msg = "No changes were present (changes to trailing whitespace alone are discarded), so nothing was committed. " +
+			"Result for the existing work branch: "
+	}
 	if !result.Succeeded {
-		msg = fmt.Sprintf("Validation failed. Details:\n```\n%s\n```\n", result.Details)
+		msg += fmt.Sprintf("Validation failed. Details:\n```\n%s\n```\n",
+			truncateValidationDetails(result.Details, toolCtx.MaxValidationOutputLines))
 	} else {
-		msg = "validation succeeded"
+		msg += "validation succeeded"
 	}
 	return &msg, nil
 }
@@ -381,6 +663,272 @@ func (t *ValidateChangesTool) Replay(ctx context.Context, block anthropic.ToolUs
 	return nil
 }
 
+// GetValidationStatusTool implements the get_validation_status tool
+type GetValidationStatusTool struct {
+	BaseTool
+}
+
+// NewGetValidationStatusTool creates a new get validation status tool
+func NewGetValidationStatusTool() *GetValidationStatusTool {
+	return &GetValidationStatusTool{
+		BaseTool: BaseTool{Name: "get_validation_status", Polling: true},
+	}
+}
+
+// GetToolParam returns the tool parameter definition
+func (t *GetValidationStatusTool) GetToolParam() anthropic.ToolParam {
+	return anthropic.ToolParam{
+		Name: t.Name,
+		Description: anthropic.String("Get the result of the most recent validation of the work branch, without " +
+			"validating again. Does not reflect file changes made since then; use validate_changes to validate those"),
+		InputSchema: anthropic.ToolInputSchemaParam{
+			Properties: map[string]any{},
+		},
+	}
+}
+
+// Run returns the most recent validation result
+func (t *GetValidationStatusTool) Run(ctx context.Context, block anthropic.ToolUseBlock, toolCtx *ToolContext) (*string, error) {
+	if block.Name != t.Name {
+		return nil, fmt.Errorf("tool use block is for %s, not %s", block.Name, t.Name)
+	}
+
+	result := toolCtx.Task.ValidationResult
+	var msg string
+	if !result.Succeeded {
+		msg = fmt.Sprintf("Validation failed. Details:\n```\n%s\n```\n",
+			truncateValidationDetails(result.Details, toolCtx.MaxValidationOutputLines))
+	} else {
+		msg = "Validation succeeded"
+	}
+	return &msg, nil
+}
+
+func (t *GetValidationStatusTool) Replay(ctx context.Context, block anthropic.ToolUseBlock, toolCtx *ToolContext) error {
+	// No side effects to replay
+	return nil
+}
+
+// GetCoverageTool implements the get_coverage tool
+type GetCoverageTool struct {
+	BaseTool
+}
+
+// GetCoverageInput represents the input for get_coverage
+type GetCoverageInput struct {
+	Path string `json:"path"`
+}
+
+// NewGetCoverageTool creates a new get coverage tool
+func NewGetCoverageTool() *GetCoverageTool {
+	return &GetCoverageTool{
+		BaseTool: BaseTool{Name: "get_coverage"},
+	}
+}
+
+// GetToolParam returns the tool parameter definition
+func (t *GetCoverageTool) GetToolParam() anthropic.ToolParam {
+	return anthropic.ToolParam{
+		Name: t.Name,
+		Description: anthropic.String("View which lines of a file the tests ran during the most recent validation, to " +
+			"find code that no test exercises. Not every repository measures coverage. Does not reflect file " +
+			"changes made since the last validation"),
+		InputSchema: anthropic.ToolInputSchemaParam{
+			Properties: map[string]any{
+				"path": map[string]any{
+					"type":        "string",
+					"description": "The file, or directory of files, to get coverage for, relative to the repository root",
+				},
+			},
+			Required: []string{"path"},
+		},
+	}
+}
+
+// ParseToolUse parses the tool use block
+func (t *GetCoverageTool) ParseToolUse(block anthropic.ToolUseBlock) (*GetCoverageInput, error) {
+	if block.Name != t.Name {
+		return nil, fmt.Errorf("tool use block is for %s, not %s", block.Name, t.Name)
+	}
+
+	var input GetCoverageInput
+	if err := parseInputJSON(block, &input); err != nil {
+		return nil, err
+	}
+	return &input, nil
+}
+
+// Run executes the get coverage command
+func (t *GetCoverageTool) Run(ctx context.Context, block anthropic.ToolUseBlock, toolCtx *ToolContext) (*string, error) {
+	input, err := t.ParseToolUse(block)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing input: %w", err)
+	}
+
+	target := strings.Trim(path.Clean("/"+input.Path), "/")
+	if target == "" {
+		return nil, ToolInputError{fmt.Errorf("path is required")}
+	}
+
+	coverage, err := toolCtx.Workspace.Coverage(ctx)
+	if errors.Is(err, validator.ErrCoverageUnavailable) {
+		result := fmt.Sprintf("Coverage unavailable: %s. Read the tests to find out what they cover instead",
+			strings.TrimPrefix(err.Error(), validator.ErrCoverageUnavailable.Error()+": "))
+		return &result, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to get coverage: %w", err)
+	}
+
+	var paths []string
+	for p := range coverage {
+		if p == target || strings.HasPrefix(p, target+"/") {
+			paths = append(paths, p)
+		}
+	}
+	if len(paths) == 0 {
+		result := fmt.Sprintf("No coverage was recorded for %s. It may not contain code, or no test may load it", target)
+		return &result, nil
+	}
+	slices.Sort(paths)
+
+	var sb strings.Builder
+	for i, p := range paths {
+		if i > 0 {
+			sb.WriteString("\n")
+		}
+		sb.WriteString(formatFileCoverage(p, coverage[p]))
+	}
+	result := sb.String()
+	return &result, nil
+}
+
+func (t *GetCoverageTool) Replay(ctx context.Context, block anthropic.ToolUseBlock, toolCtx *ToolContext) error {
+	// No side effects to replay
+	return nil
+}
+
+// formatFileCoverage formats the coverage of a file for the AI as ranges of covered and uncovered lines
+func formatFileCoverage(filePath string, fc validator.FileCoverage) string {
+	formatRanges := func(ranges []validator.LineRange) string {
+		if len(ranges) == 0 {
+			return "none"
+		}
+		var parts []string
+		for _, r := range ranges {
+			if r.Start == r.End {
+				parts = append(parts, strconv.Itoa(r.Start))
+			} else {
+				parts = append(parts, fmt.Sprintf("%d-%d", r.Start, r.End))
+			}
+		}
+		return strings.Join(parts, ", ")
+	}
+
+	return fmt.Sprintf("%s\n  Covered lines: %s\n  Uncovered lines: %s\n", filePath, formatRanges(fc.Covered),
+		formatRanges(fc.Uncovered))
+}
+
+// RunTestsTool implements the run_tests tool
+type RunTestsTool struct {
+	BaseTool
+}
+
+// RunTestsInput represents the input for run_tests
+type RunTestsInput struct {
+	Target        string `json:"target"`
+	CommitMessage string `json:"commit_message"`
+}
+
+// NewRunTestsTool creates a new run tests tool
+func NewRunTestsTool() *RunTestsTool {
+	return &RunTestsTool{
+		BaseTool: BaseTool{Name: "run_tests", Mutating: true},
+	}
+}
+
+// GetToolParam returns the tool parameter definition
+func (t *RunTestsTool) GetToolParam() anthropic.ToolParam {
+	return anthropic.ToolParam{
+		Name: t.Name,
+		Description: anthropic.String("Run a subset of the tests against all previous file changes, e.g. a single " +
+			"test file or package. Faster than validate_changes, but does not replace it: changes must still pass " +
+			"validate_changes before they can be published"),
+		InputSchema: anthropic.ToolInputSchemaParam{
+			Properties: map[string]any{
+				"target": map[string]any{
+					"type": "string",
+					"description": "The tests to run, e.g. a test file path or package path, in whatever form the " +
+						"repository's test command accepts",
+				},
+				"commit_message": map[string]any{
+					"type": "string",
+					"description": "Commit message for file changes made since the last call to this tool or " +
+						"validate_changes. May or may not be used depending on the implementation, but a non-empty " +
+						"string must be provided",
+				},
+			},
+			Required: []string{"target"},
+		},
+	}
+}
+
+// ParseToolUse parses the tool use block
+func (t *RunTestsTool) ParseToolUse(block anthropic.ToolUseBlock) (*RunTestsInput, error) {
+	if block.Name != t.Name {
+		return nil, fmt.Errorf("tool use block is for %s, not %s", block.Name, t.Name)
+	}
+
+	var input RunTestsInput
+	if err := parseInputJSON(block, &input); err != nil {
+		return nil, err
+	}
+	return &input, nil
+}
+
+// Run executes the run tests command
+func (t *RunTestsTool) Run(ctx context.Context, block anthropic.ToolUseBlock, toolCtx *ToolContext) (*string, error) {
+	input, err := t.ParseToolUse(block)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing input: %w", err)
+	}
+
+	if input.Target == "" {
+		return nil, ToolInputError{fmt.Errorf("target is required")}
+	}
+	if input.CommitMessage == "" {
+		return nil, ToolInputError{fmt.Errorf("commit_message is required")}
+	}
+
+	result, err := toolCtx.Workspace.RunTests(ctx, &input.CommitMessage, input.Target)
+	if err != nil {
+		if errors.Is(err, validator.ErrScopedTestsUnsupported) {
+			msg := "This repository does not support running a subset of the tests. Use the validate_changes tool " +
+				"to run all of them instead"
+			return &msg, nil
+		}
+		var permErr workspace.InsufficientPermissionsError
+		if errors.As(err, &permErr) {
+			return nil, ToolInputError{cause: fmt.Errorf("unable to %s: %s", permErr.Operation, permErr.Reason)}
+		}
+		return nil, fmt.Errorf("failed to run tests: %w", err)
+	}
+
+	details := truncateValidationDetails(result.Details, toolCtx.MaxValidationOutputLines)
+	var msg string
+	if !result.Succeeded {
+		msg = fmt.Sprintf("Tests failed. Details:\n```\n%s\n```\n", details)
+	} else {
+		msg = fmt.Sprintf("Tests passed. Details:\n```\n%s\n```\n", details)
+	}
+	return &msg, nil
+}
+
+func (t *RunTestsTool) Replay(ctx context.Context, block anthropic.ToolUseBlock, toolCtx *ToolContext) error {
+	// Changes were persisted remotely when the tests were run the first time, so we can clear them locally
+	toolCtx.Workspace.ClearLocalChanges()
+	return nil
+}
+
 // PostCommentTool implements the post_comment tool
 type PostCommentTool struct {
 	BaseTool
@@ -396,7 +944,7 @@ type PostCommentInput struct {
 // NewPostCommentTool creates a new post comment tool
 func NewPostCommentTool() *PostCommentTool {
 	return &PostCommentTool{
-		BaseTool: BaseTool{Name: "post_comment"},
+		BaseTool: BaseTool{Name: "post_comment", Mutating: true},
 	}
 }
 
@@ -455,19 +1003,13 @@ func (t *PostCommentTool) Run(ctx context.Context, block anthropic.ToolUseBlock,
 
 	switch input.CommentType {
 	case "issue":
-		comment := &github.IssueComment{
-			Body: github.Ptr(input.Body),
-		}
-		_, _, err = toolCtx.GithubClient.Issues.CreateComment(ctx, toolCtx.Task.Issue.Owner, toolCtx.Task.Issue.Repo, toolCtx.Task.Issue.Number, comment)
+		err = toolCtx.VCS.CreateComment(ctx, toolCtx.Task.Issue.Owner, toolCtx.Task.Issue.Repo, toolCtx.Task.Issue.Number, input.Body)
 		if err != nil {
 			return nil, err
 		}
 	case "pr":
 		if toolCtx.Task.PullRequest != nil {
-			comment := &github.IssueComment{
-				Body: github.Ptr(input.Body),
-			}
-			_, _, err = toolCtx.GithubClient.Issues.CreateComment(ctx, toolCtx.Task.Issue.Owner, toolCtx.Task.Issue.Repo, toolCtx.Task.PullRequest.Number, comment)
+			err = toolCtx.VCS.CreateComment(ctx, toolCtx.Task.Issue.Owner, toolCtx.Task.Issue.Repo, toolCtx.Task.PullRequest.Number, input.Body)
 			if err != nil {
 				return nil, err
 			}
@@ -476,13 +1018,13 @@ func (t *PostCommentTool) Run(ctx context.Context, block anthropic.ToolUseBlock,
 		if input.InReplyTo == nil {
 			return nil, ToolInputError{fmt.Errorf("InReplyTo must be specified for review comments. The bot is currently unable to create top-level review comments")}
 		}
-		_, _, err = toolCtx.GithubClient.PullRequests.CreateCommentInReplyTo(
+		err = toolCtx.VCS.ReplyToReviewComment(
 			ctx,
 			toolCtx.Task.Issue.Owner,
 			toolCtx.Task.Issue.Repo,
 			toolCtx.Task.PullRequest.Number,
-			input.Body,
 			*input.InReplyTo,
+			input.Body,
 		)
 		if err != nil {
 			return nil, err
@@ -512,7 +1054,7 @@ type AddReactionInput struct {
 // NewAddReactionTool creates a new add reaction tool
 func NewAddReactionTool() *AddReactionTool {
 	return &AddReactionTool{
-		BaseTool: BaseTool{Name: "add_reaction"},
+		BaseTool: BaseTool{Name: "add_reaction", Mutating: true},
 	}
 }
 
@@ -572,29 +1114,17 @@ func (t *AddReactionTool) Run(ctx context.Context, block anthropic.ToolUseBlock,
 
 	switch input.CommentType {
 	case "issue", "PR":
-		_, resp, err := toolCtx.GithubClient.Reactions.CreateIssueCommentReaction(ctx, toolCtx.Task.Issue.Owner, toolCtx.Task.Issue.Repo, input.CommentID, input.Reaction)
-		if err != nil {
-			switch resp.StatusCode {
-			case http.StatusNotFound:
-				return nil, ToolInputError{fmt.Errorf("failed to create reaction (%s); check that you are providing a valid comment ID", resp.Status)}
-			case http.StatusUnprocessableEntity:
-				return nil, ToolInputError{fmt.Errorf("failed to create reaction (%s); check that you are providing a valid reaction", resp.Status)}
-			default:
-				return nil, err
-			}
-		}
+		err = toolCtx.VCS.AddCommentReaction(ctx, toolCtx.Task.Issue.Owner, toolCtx.Task.Issue.Repo, input.CommentID, input.Reaction)
 	case "PR review":
-		_, resp, err := toolCtx.GithubClient.Reactions.CreatePullRequestCommentReaction(ctx, toolCtx.Task.Issue.Owner, toolCtx.Task.Issue.Repo, input.CommentID, input.Reaction)
-		if err != nil {
-			switch resp.StatusCode {
-			case http.StatusNotFound:
-				return nil, ToolInputError{fmt.Errorf("failed to create reaction (%s); check that you are providing a valid comment ID", resp.Status)}
-			case http.StatusUnprocessableEntity:
-				return nil, ToolInputError{fmt.Errorf("failed to create reaction (%s); check that you are providing a valid reaction", resp.Status)}
-			default:
-				return nil, err
-			}
-		}
+		err = toolCtx.VCS.AddReviewCommentReaction(ctx, toolCtx.Task.Issue.Owner, toolCtx.Task.Issue.Repo, input.CommentID, input.Reaction)
+	}
+	switch {
+	case errors.Is(err, vcs.ErrNotFound):
+		return nil, ToolInputError{fmt.Errorf("failed to create reaction (not found); check that you are providing a valid comment ID")}
+	case errors.Is(err, vcs.ErrInvalid):
+		return nil, ToolInputError{fmt.Errorf("failed to create reaction (rejected); check that you are providing a valid reaction")}
+	case err != nil:
+		return nil, err
 	}
 
 	return nil, nil
@@ -605,136 +1135,4361 @@ func (t *AddReactionTool) Replay(ctx context.Context, block anthropic.ToolUseBlo
 	return nil
 }
 
-// DeleteFileTool implements the delete_file tool
-type DeleteFileTool struct {
+// GetPendingResponsesTool implements the get_pending_responses tool
+type GetPendingResponsesTool struct {
 	BaseTool
 }
 
-// DeleteFileInput represents the input for delete_file
-type DeleteFileInput struct {
-	Path string `json:"path"`
+// NewGetPendingResponsesTool creates a new get pending responses tool
+func NewGetPendingResponsesTool() *GetPendingResponsesTool {
+	return &GetPendingResponsesTool{
+		BaseTool: BaseTool{Name: "get_pending_responses"},
+	}
 }
 
-// NewDeleteFileTool creates a new delete file tool
-func NewDeleteFileTool() *DeleteFileTool {
+// GetToolParam returns the tool parameter definition
+func (t *GetPendingResponsesTool) GetToolParam() anthropic.ToolParam {
+	return anthropic.ToolParam{
+		Name: t.Name,
+		Description: anthropic.String("List the comments requiring responses that you haven't handled yet. A comment " +
+			"counts as handled once you have reacted to it"),
+		InputSchema: anthropic.ToolInputSchemaParam{
+			Properties: map[string]any{},
+		},
+	}
+}
+
+// Run lists the comments requiring responses that the bot hasn't reacted to since the task started
+func (t *GetPendingResponsesTool) Run(ctx context.Context, block anthropic.ToolUseBlock, toolCtx *ToolContext) (*string, error) {
+	if block.Name != t.Name {
+		return nil, fmt.Errorf("tool use block is for %s, not %s", block.Name, t.Name)
+	}
+
+	tsk := toolCtx.Task
+	var pending []string
+	for _, comments := range []struct {
+		commentType string
+		comments    []*github.IssueComment
+	}{
+		{"issue", tsk.IssueCommentsRequiringResponses},
+		{"PR", tsk.PRCommentsRequiringResponses},
+	} {
+		for _, comment := range comments.comments {
+			reactions, _, err := toolCtx.GithubClient.Reactions.ListIssueCommentReactions(ctx, tsk.Issue.Owner,
+				tsk.Issue.Repo, comment.GetID(), &github.ListReactionOptions{ListOptions: github.ListOptions{PerPage: 100}})
+			if err != nil {
+				return nil, fmt.Errorf("failed to list reactions to comment %d: %w", comment.GetID(), err)
+			}
+			if !hasHandledReaction(reactions, toolCtx.BotUser) {
+				pending = append(pending, fmt.Sprintf("- %s comment %d by @%s: %s", comments.commentType,
+					comment.GetID(), comment.GetUser().GetLogin(), commentExcerpt(comment.GetBody())))
+			}
+		}
+	}
+	for _, comment := range tsk.PRReviewCommentsRequiringResponses {
+		reactions, _, err := toolCtx.GithubClient.Reactions.ListPullRequestCommentReactions(ctx, tsk.Issue.Owner,
+			tsk.Issue.Repo, comment.GetID(), &github.ListReactionOptions{ListOptions: github.ListOptions{PerPage: 100}})
+		if err != nil {
+			return nil, fmt.Errorf("failed to list reactions to review comment %d: %w", comment.GetID(), err)
+		}
+		if !hasHandledReaction(reactions, toolCtx.BotUser) {
+			pending = append(pending, fmt.Sprintf("- PR review comment %d by @%s on %s: %s", comment.GetID(),
+				comment.GetUser().GetLogin(), comment.GetPath(), commentExcerpt(comment.GetBody())))
+		}
+	}
+
+	var result string
+	if len(pending) == 0 {
+		result = "No comments are waiting for a response"
+	} else {
+		result = fmt.Sprintf("%d comments are waiting for a response:\n%s\n", len(pending), strings.Join(pending, "\n"))
+	}
+	return &result, nil
+}
+
+func (t *GetPendingResponsesTool) Replay(ctx context.Context, block anthropic.ToolUseBlock, toolCtx *ToolContext) error {
+	// No side effects to replay
+	return nil
+}
+
+// hasHandledReaction returns true if the bot has reacted to a comment other than to acknowledge it, which the bot does
+// for every comment requiring a response before the AI starts on them
+func hasHandledReaction(reactions []*github.Reaction, botUser *github.User) bool {
+	for _, reaction := range reactions {
+		if reaction.GetUser().GetLogin() == botUser.GetLogin() && reaction.GetContent() != acknowledgementReaction {
+			return true
+		}
+	}
+	return false
+}
+
+// commentExcerpt returns the first line of a comment body, shortened if it is long
+func commentExcerpt(body string) string {
+	firstLine, _, _ := strings.Cut(strings.TrimSpace(body), "\n")
+	return truncateString(strings.TrimSpace(firstLine), 100)
+}
+
+// ResolveReviewThreadTool implements the resolve_review_thread tool
+type ResolveReviewThreadTool struct {
+	BaseTool
+}
+
+// ResolveReviewThreadInput represents the input for resolve_review_thread
+type ResolveReviewThreadInput struct {
+	CommentID int64 `json:"comment_id"`
+}
+
+// NewResolveReviewThreadTool creates a new resolve review thread tool
+func NewResolveReviewThreadTool() *ResolveReviewThreadTool {
+	return &ResolveReviewThreadTool{
+		BaseTool: BaseTool{Name: "resolve_review_thread", Mutating: true},
+	}
+}
+
+// GetToolParam returns the tool parameter definition
+func (t *ResolveReviewThreadTool) GetToolParam() anthropic.ToolParam {
+	return anthropic.ToolParam{
+		Name:        t.Name,
+		Description: anthropic.String("Mark a PR review comment thread as resolved, once the feedback in it has been fully addressed"),
+		InputSchema: anthropic.ToolInputSchemaParam{
+			Properties: map[string]any{
+				"comment_id": map[string]any{
+					"type":        "integer",
+					"description": "ID of the first comment in the review thread",
+				},
+			},
+			Required: []string{"comment_id"},
+		},
+	}
+}
+
+// ParseToolUse parses the tool use block
+func (t *ResolveReviewThreadTool) ParseToolUse(block anthropic.ToolUseBlock) (*ResolveReviewThreadInput, error) {
+	if block.Name != t.Name {
+		return nil, fmt.Errorf("tool use block is for %s, not %s", block.Name, t.Name)
+	}
+
+	var input ResolveReviewThreadInput
+	if err := parseInputJSON(block, &input); err != nil {
+		return nil, err
+	}
+	return &input, nil
+}
+
+// Run executes the resolve review thread command
+func (t *ResolveReviewThreadTool) Run(ctx context.Context, block anthropic.ToolUseBlock, toolCtx *ToolContext) (*string, error) {
+	input, err := t.ParseToolUse(block)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing input: %w", err)
+	}
+
+	if input.CommentID == 0 {
+		return nil, ToolInputError{fmt.Errorf("comment_id is required")}
+	}
+	pr := toolCtx.Task.PullRequest
+	if pr == nil {
+		return nil, ToolInputError{fmt.Errorf("there is no pull request, so there are no review threads to resolve")}
+	}
+
+	// Looking the thread up on the task's pull request ensures that we don't resolve threads on other pull requests
+	gql := githubgql.NewGraphQLClientFromREST(toolCtx.GithubClient)
+	thread, err := gql.FindReviewThread(ctx, pr.Owner, pr.Repo, pr.Number, input.CommentID)
+	if err != nil {
+		return nil, err
+	}
+	if thread == nil {
+		return nil, ToolInputError{fmt.Errorf("comment %d is not the first comment of a review thread on pull request #%d", input.CommentID, pr.Number)}
+	}
+
+	var result string
+	if thread.IsResolved {
+		result = "The review thread was already resolved"
+	} else {
+		if err := gql.ResolveReviewThread(ctx, thread.ID); err != nil {
+			return nil, err
+		}
+		result = "Resolved the review thread"
+	}
+	return &result, nil
+}
+
+func (t *ResolveReviewThreadTool) Replay(ctx context.Context, block anthropic.ToolUseBlock, toolCtx *ToolContext) error {
+	// No side effects to replay
+	return nil
+}
+
+// FetchURLTool implements the fetch_url tool
+type FetchURLTool struct {
+	BaseTool
+
+	allowedHosts []string // Hostnames that may be fetched. An entry of the form "*.example.com" allows subdomains
+	maxBytes     int64    // Responses larger than this are rejected
+	client       *http.Client
+}
+
+// FetchURLInput represents the input for fetch_url
+type FetchURLInput struct {
+	URL string `json:"url"`
+}
+
+// NewFetchURLTool creates a new fetch URL tool that may only fetch from the given hosts, rejecting responses larger
+// than maxBytes
+func NewFetchURLTool(allowedHosts []string, maxBytes int64) *FetchURLTool {
+	t := &FetchURLTool{
+		BaseTool:     BaseTool{Name: "fetch_url"},
+		allowedHosts: allowedHosts,
+		maxBytes:     maxBytes,
+	}
+	t.client = &http.Client{
+		Timeout: 30 * time.Second,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= 10 {
+				return errors.New("stopped after 10 redirects")
+			}
+			// Redirects must not lead off the allowlist
+			return t.checkURL(req.URL)
+		},
+	}
+	return t
+}
+
+// GetToolParam returns the tool parameter definition
+func (t *FetchURLTool) GetToolParam() anthropic.ToolParam {
+	return anthropic.ToolParam{
+		Name: t.Name,
+		Description: anthropic.String(fmt.Sprintf("Fetch a web page or document, e.g. API documentation or an RFC referenced in the issue, and return its text. "+
+			"Only these hosts may be fetched: %s", strings.Join(t.allowedHosts, ", "))),
+		InputSchema: anthropic.ToolInputSchemaParam{
+			Properties: map[string]any{
+				"url": map[string]any{
+					"type":        "string",
+					"description": "The http or https URL to fetch",
+				},
+			},
+			Required: []string{"url"},
+		},
+	}
+}
+
+// ParseToolUse parses the tool use block
+func (t *FetchURLTool) ParseToolUse(block anthropic.ToolUseBlock) (*FetchURLInput, error) {
+	if block.Name != t.Name {
+		return nil, fmt.Errorf("tool use block is for %s, not %s", block.Name, t.Name)
+	}
+
+	var input FetchURLInput
+	if err := parseInputJSON(block, &input); err != nil {
+		return nil, err
+	}
+	return &input, nil
+}
+
+// Run executes the fetch URL command
+func (t *FetchURLTool) Run(ctx context.Context, block anthropic.ToolUseBlock, toolCtx *ToolContext) (*string, error) {
+	input, err := t.ParseToolUse(block)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing input: %w", err)
+	}
+
+	u, err := url.Parse(input.URL)
+	if err != nil {
+		return nil, ToolInputError{fmt.Errorf("invalid URL: %w", err)}
+	}
+	if err := t.checkURL(u); err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, ToolInputError{fmt.Errorf("invalid URL: %w", err)}
+	}
+	resp, err := t.client.Do(req)
+	if err != nil {
+		var tie ToolInputError
+		if errors.As(err, &tie) {
+			return nil, tie
+		}
+		// Network errors are the fault of the URL or the remote host, so let the AI decide what to do about them
+		return nil, ToolInputError{fmt.Errorf("failed to fetch URL: %w", err)}
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, ToolInputError{fmt.Errorf("failed to fetch URL: %s", resp.Status)}
+	}
+	if resp.ContentLength > t.maxBytes {
+		return nil, ToolInputError{fmt.Errorf("response is %d bytes, which exceeds the limit of %d bytes", resp.ContentLength, t.maxBytes)}
+	}
+
+	// Read one byte past the limit to detect oversized responses without a Content-Length
+	body, err := io.ReadAll(io.LimitReader(resp.Body, t.maxBytes+1))
+	if err != nil {
+		return nil, ToolInputError{fmt.Errorf("failed to read response: %w", err)}
+	}
+	if int64(len(body)) > t.maxBytes {
+		return nil, ToolInputError{fmt.Errorf("response exceeds the limit of %d bytes", t.maxBytes)}
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	var text string
+	switch {
+	case mediaType == "text/html":
+		text = htmlToText(string(body))
+	case strings.HasPrefix(mediaType, "text/"), mediaType == "application/json", mediaType == "application/xml", mediaType == "":
+		text = string(body)
+	default:
+		return nil, ToolInputError{fmt.Errorf("unsupported content type '%s', only text can be fetched", mediaType)}
+	}
+	if !utf8.ValidString(text) {
+		return nil, ToolInputError{fmt.Errorf("response is not valid UTF-8 text")}
+	}
+
+	return &text, nil
+}
+
+func (t *FetchURLTool) Replay(ctx context.Context, block anthropic.ToolUseBlock, toolCtx *ToolContext) error {
+	// No side effects to replay
+	return nil
+}
+
+// checkURL returns a ToolInputError if the given URL may not be fetched
+func (t *FetchURLTool) checkURL(u *url.URL) error {
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return ToolInputError{fmt.Errorf("unsupported scheme '%s', only http and https URLs can be fetched", u.Scheme)}
+	}
+	host := strings.ToLower(u.Hostname())
+	for _, allowed := range t.allowedHosts {
+		allowed = strings.ToLower(allowed)
+		if suffix, ok := strings.CutPrefix(allowed, "*."); ok {
+			if strings.HasSuffix(host, "."+suffix) {
+				return nil
+			}
+		} else if host == allowed {
+			return nil
+		}
+	}
+	return ToolInputError{fmt.Errorf("host '%s' is not on the allowlist", u.Hostname())}
+}
+
+// htmlToText extracts the human-readable text of an HTML document, dropping markup, scripts, and styles
+func htmlToText(doc string) string {
+	var sb strings.Builder
+	tokenizer := html.NewTokenizer(strings.NewReader(doc))
+	skipDepth := 0 // Greater than zero while inside an element whose content isn't text
+	for {
+		switch tokenizer.Next() {
+		case html.ErrorToken:
+			// Collapse the blank lines left behind by markup
+			lines := strings.Split(sb.String(), "\n")
+			var kept []string
+			for _, line := range lines {
+				if line = strings.TrimSpace(line); line != "" {
+					kept = append(kept, line)
+				}
+			}
+			return strings.Join(kept, "\n")
+		case html.StartTagToken:
+			name, _ := tokenizer.TagName()
+			switch string(name) {
+			case "script", "style", "noscript", "template":
+				skipDepth++
+			case "p", "div", "br", "li", "tr", "h1", "h2", "h3", "h4", "h5", "h6", "pre", "section", "article":
+				sb.WriteString("\n")
+			}
+		case html.EndTagToken:
+			name, _ := tokenizer.TagName()
+			switch string(name) {
+			case "script", "style", "noscript", "template":
+				skipDepth = max(skipDepth-1, 0)
+			case "p", "div", "li", "tr", "h1", "h2", "h3", "h4", "h5", "h6", "pre", "section", "article":
+				sb.WriteString("\n")
+			}
+		case html.TextToken:
+			if skipDepth == 0 {
+				sb.Write(tokenizer.Text())
+			}
+		}
+	}
+}
+
+// DeleteFileTool implements the delete_file tool
+type DeleteFileTool struct {
+	BaseTool
+}
+
+// DeleteFileInput represents the input for delete_file
+type DeleteFileInput struct {
+	Path string `json:"path"`
+}
+
+// NewDeleteFileTool creates a new delete file tool
+func NewDeleteFileTool() *DeleteFileTool {
 	return &DeleteFileTool{
 		BaseTool: BaseTool{Name: "delete_file"},
 	}
 }
 
 // GetToolParam returns the tool parameter definition
-func (t *DeleteFileTool) GetToolParam() anthropic.ToolParam {
+func (t *DeleteFileTool) GetToolParam() anthropic.ToolParam {
+	return anthropic.ToolParam{
+		Name:        t.Name,
+		Description: anthropic.String("Delete a file"),
+		InputSchema: anthropic.ToolInputSchemaParam{
+			Properties: map[string]any{
+				"path": map[string]any{
+					"type":        "string",
+					"description": "Path to the file to delete.",
+				},
+			},
+			Required: []string{"path"},
+		},
+	}
+}
+
+// ParseToolUse parses the tool use block
+func (t *DeleteFileTool) ParseToolUse(block anthropic.ToolUseBlock) (*DeleteFileInput, error) {
+	if block.Name != t.Name {
+		return nil, fmt.Errorf("tool use block is for %s, not %s", block.Name, t.Name)
+	}
+
+	var input DeleteFileInput
+	if err := parseInputJSON(block, &input); err != nil {
+		return nil, err
+	}
+	return &input, nil
+}
+
+// Run executes the delete file command
+func (t *DeleteFileTool) Run(ctx context.Context, block anthropic.ToolUseBlock, toolCtx *ToolContext) (*string, error) {
+	input, err := t.ParseToolUse(block)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing input: %w", err)
+	}
+
+	if input.Path == "" {
+		return nil, ToolInputError{fmt.Errorf("path is required")}
+	}
+
+	// Validate that the path doesn't start with a leading slash
+	if strings.HasPrefix(input.Path, "/") {
+		return nil, ToolInputError{fmt.Errorf("path must be relative (no leading slash)")}
+	}
+	if err := checkProtectedPath(input.Path, toolCtx.ProtectedPaths); err != nil {
+		return nil, err
+	}
+
+	// Check if the file exists before deleting
+	exists, err := toolCtx.Workspace.FileExists(ctx, input.Path)
+	if err != nil {
+		return nil, fmt.Errorf("error checking if file exists: %w", err)
+	}
+	if !exists {
+		return nil, ToolInputError{fmt.Errorf("file does not exist: %s", input.Path)}
+	}
+
+	// Check if it's a directory
+	isDir, err := toolCtx.Workspace.IsDir(ctx, input.Path)
+	if err != nil {
+		return nil, fmt.Errorf("error checking if path is directory: %w", err)
+	}
+	if isDir {
+		return nil, ToolInputError{fmt.Errorf("cannot delete directory: %s (only files can be deleted)", input.Path)}
+	}
+
+	// Delete the file
+	err = toolCtx.Workspace.Delete(ctx, input.Path)
+	if err != nil {
+		return nil, fmt.Errorf("error deleting file: %w", err)
+	}
+
+	result := fmt.Sprintf("Successfully deleted file: %s", input.Path)
+	return &result, nil
+}
+
+func (t *DeleteFileTool) Replay(ctx context.Context, block anthropic.ToolUseBlock, toolCtx *ToolContext) error {
+	input, err := t.ParseToolUse(block)
+	if err != nil {
+		return fmt.Errorf("error parsing input: %w", err)
+	}
+
+	// Replay the deletion (same as the original run since it's an in-memory operation). If the file is already gone,
+	// e.g. because the deletion was already replayed or persisted remotely, the intended state has been reached
+	err = toolCtx.Workspace.Delete(ctx, input.Path)
+	if errors.Is(err, workspace.ErrFileNotFound) {
+		return nil
+	}
+	return err
+}
+
+// WriteFileTool implements the write_file tool
+type WriteFileTool struct {
+	BaseTool
+}
+
+// WriteFileInput represents the input for write_file
+type WriteFileInput struct {
+	Path      string `json:"path"`
+	Content   string `json:"content"`
+	Overwrite bool   `json:"overwrite,omitempty"`
+}
+
+// NewWriteFileTool creates a new write file tool
+func NewWriteFileTool() *WriteFileTool {
+	return &WriteFileTool{
+		BaseTool: BaseTool{Name: "write_file"},
+	}
+}
+
+// GetToolParam returns the tool parameter definition
+func (t *WriteFileTool) GetToolParam() anthropic.ToolParam {
+	return anthropic.ToolParam{
+		Name: t.Name,
+		Description: anthropic.String("Write the entire contents of a file, creating it if it doesn't exist. " +
+			"Fails if the file exists, unless overwrite is true. Prefer str_replace for targeted changes to existing files"),
+		InputSchema: anthropic.ToolInputSchemaParam{
+			Properties: map[string]any{
+				"path": map[string]any{
+					"type":        "string",
+					"description": "Path to the file to write",
+				},
+				"content": map[string]any{
+					"type":        "string",
+					"description": "The full contents of the file",
+				},
+				"overwrite": map[string]any{
+					"type":        "boolean",
+					"description": "Set to true to replace the contents of an existing file. Defaults to false",
+				},
+			},
+			Required: []string{"path", "content"},
+		},
+	}
+}
+
+// ParseToolUse parses the tool use block
+func (t *WriteFileTool) ParseToolUse(block anthropic.ToolUseBlock) (*WriteFileInput, error) {
+	if block.Name != t.Name {
+		return nil, fmt.Errorf("tool use block is for %s, not %s", block.Name, t.Name)
+	}
+
+	var input WriteFileInput
+	if err := parseInputJSON(block, &input); err != nil {
+		return nil, err
+	}
+	return &input, nil
+}
+
+// Run executes the write file command
+func (t *WriteFileTool) Run(ctx context.Context, block anthropic.ToolUseBlock, toolCtx *ToolContext) (*string, error) {
+	input, err := t.ParseToolUse(block)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing input: %w", err)
+	}
+
+	if input.Path == "" {
+		return nil, ToolInputError{fmt.Errorf("path is required")}
+	}
+	if strings.HasPrefix(input.Path, "/") {
+		return nil, ToolInputError{fmt.Errorf("path must be relative (no leading slash)")}
+	}
+	if err := checkProtectedPath(input.Path, toolCtx.ProtectedPaths); err != nil {
+		return nil, err
+	}
+
+	exists, err := toolCtx.Workspace.FileExists(ctx, input.Path)
+	if err != nil {
+		return nil, fmt.Errorf("error checking if file exists: %w", err)
+	}
+	if exists {
+		if !input.Overwrite {
+			return nil, ToolInputError{fmt.Errorf("file already exists: %s. Set overwrite to true to replace its contents", input.Path)}
+		}
+		isDir, err := toolCtx.Workspace.IsDir(ctx, input.Path)
+		if err != nil {
+			return nil, fmt.Errorf("error checking if path is directory: %w", err)
+		}
+		if isDir {
+			return nil, ToolInputError{fmt.Errorf("cannot overwrite directory: %s", input.Path)}
+		}
+	}
+	if err := checkFileSize(input.Path, input.Content, toolCtx.MaxFileBytes); err != nil {
+		return nil, err
+	}
+
+	err = toolCtx.Workspace.Write(ctx, input.Path, input.Content)
+	if err != nil {
+		return nil, fmt.Errorf("error writing file: %w", err)
+	}
+
+	verb := "created"
+	if exists {
+		verb = "overwrote"
+	}
+	result := fmt.Sprintf("Successfully %s file: %s", verb, input.Path)
+	return &result, nil
+}
+
+func (t *WriteFileTool) Replay(ctx context.Context, block anthropic.ToolUseBlock, toolCtx *ToolContext) error {
+	input, err := t.ParseToolUse(block)
+	if err != nil {
+		return fmt.Errorf("error parsing input: %w", err)
+	}
+
+	// Rewrite the file without checking whether it exists. The original run succeeded, so the file either didn't exist
+	// or was meant to be overwritten, and it may exist now because the write was already replayed or persisted remotely
+	return toolCtx.Workspace.Write(ctx, input.Path, input.Content)
+}
+
+// ApplyPatchTool implements the apply_patch tool
+type ApplyPatchTool struct {
+	BaseTool
+}
+
+// ApplyPatchInput represents the input for apply_patch
+type ApplyPatchInput struct {
+	Patch string `json:"patch"`
+}
+
+// NewApplyPatchTool creates a new apply patch tool
+func NewApplyPatchTool() *ApplyPatchTool {
+	return &ApplyPatchTool{
+		BaseTool: BaseTool{Name: "apply_patch"},
+	}
+}
+
+// GetToolParam returns the tool parameter definition
+func (t *ApplyPatchTool) GetToolParam() anthropic.ToolParam {
+	return anthropic.ToolParam{
+		Name: t.Name,
+		Description: anthropic.String("Apply a unified diff to a single file. Prefer this over many str_replace calls when " +
+			"making several changes to one file. Hunks are located by their context and removed lines, using the line " +
+			"numbers in hunk headers as hints. Either all hunks apply or the file is left unchanged. To create a file, " +
+			"use /dev/null as the old path"),
+		InputSchema: anthropic.ToolInputSchemaParam{
+			Properties: map[string]any{
+				"patch": map[string]any{
+					"type": "string",
+					"description": "A unified diff for one file, starting with '--- a/<path>' and '+++ b/<path>' lines " +
+						"followed by one or more hunks",
+				},
+			},
+			Required: []string{"patch"},
+		},
+	}
+}
+
+// ParseToolUse parses the tool use block
+func (t *ApplyPatchTool) ParseToolUse(block anthropic.ToolUseBlock) (*ApplyPatchInput, error) {
+	if block.Name != t.Name {
+		return nil, fmt.Errorf("tool use block is for %s, not %s", block.Name, t.Name)
+	}
+
+	var input ApplyPatchInput
+	if err := parseInputJSON(block, &input); err != nil {
+		return nil, err
+	}
+	return &input, nil
+}
+
+// Run executes the apply patch command
+func (t *ApplyPatchTool) Run(ctx context.Context, block anthropic.ToolUseBlock, toolCtx *ToolContext) (*string, error) {
+	input, err := t.ParseToolUse(block)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing input: %w", err)
+	}
+
+	patch, err := parseUnifiedDiff(input.Patch)
+	if err != nil {
+		return nil, ToolInputError{fmt.Errorf("invalid patch: %w", err)}
+	}
+	if patch.newPath == "" {
+		return nil, ToolInputError{fmt.Errorf("patch deletes %s, use the delete_file tool to delete files", patch.oldPath)}
+	}
+	if patch.oldPath != "" && patch.oldPath != patch.newPath {
+		return nil, ToolInputError{fmt.Errorf("patch renames %s to %s, which is not supported", patch.oldPath, patch.newPath)}
+	}
+	if strings.HasPrefix(patch.newPath, "/") {
+		return nil, ToolInputError{fmt.Errorf("path must be relative (no leading slash)")}
+	}
+	if err := checkProtectedPath(patch.newPath, toolCtx.ProtectedPaths); err != nil {
+		return nil, err
+	}
+
+	exists, err := toolCtx.Workspace.FileExists(ctx, patch.newPath)
+	if err != nil {
+		return nil, fmt.Errorf("error checking if file exists: %w", err)
+	}
+	creating := patch.oldPath == ""
+	if creating && exists {
+		return nil, ToolInputError{fmt.Errorf("patch creates %s, but the file already exists", patch.newPath)}
+	}
+	if !creating && !exists {
+		return nil, ToolInputError{fmt.Errorf("file does not exist: %s", patch.newPath)}
+	}
+
+	var content string
+	if exists {
+		content, err = toolCtx.Workspace.Read(ctx, patch.newPath)
+		if err != nil {
+			return nil, fmt.Errorf("error reading file: %w", err)
+		}
+	}
+
+	patched, err := patch.apply(content)
+	if err != nil {
+		return nil, ToolInputError{err}
+	}
+	if err := checkFileSize(patch.newPath, patched, toolCtx.MaxFileBytes); err != nil {
+		return nil, err
+	}
+
+	err = toolCtx.Workspace.Write(ctx, patch.newPath, patched)
+	if err != nil {
+		return nil, fmt.Errorf("error writing file: %w", err)
+	}
+
+	result := fmt.Sprintf("Successfully applied %d hunk(s) to %s", len(patch.hunks), patch.newPath)
+	return &result, nil
+}
+
+func (t *ApplyPatchTool) Replay(ctx context.Context, block anthropic.ToolUseBlock, toolCtx *ToolContext) error {
+	input, err := t.ParseToolUse(block)
+	if err != nil {
+		return fmt.Errorf("error parsing input: %w", err)
+	}
+
+	patch, err := parseUnifiedDiff(input.Patch)
+	if err != nil {
+		return fmt.Errorf("invalid patch: %w", err)
+	}
+
+	if patch.oldPath == "" {
+		// A patch that creates a file determines its entire content, so rewrite it like write_file, whether or not the
+		// creation was already replayed or persisted remotely
+		created, err := patch.apply("")
+		if err != nil {
+			return err
+		}
+		return toolCtx.Workspace.Write(ctx, patch.newPath, created)
+	}
+
+	content, err := toolCtx.Workspace.Read(ctx, patch.newPath)
+	if err != nil {
+		return fmt.Errorf("error reading file: %w", err)
+	}
+
+	// The patch may already have been applied, e.g. because it was already replayed or persisted remotely. If so, the
+	// intended state has been reached. Check this before applying the patch, since a patch that only inserts lines
+	// would apply again on top of itself
+	if _, err := patch.reversed().apply(content); err == nil {
+		return nil
+	}
+
+	patched, err := patch.apply(content)
+	if err != nil {
+		return err
+	}
+	return toolCtx.Workspace.Write(ctx, patch.newPath, patched)
+}
+
+// unifiedDiff is a parsed unified diff of a single file
+type unifiedDiff struct {
+	oldPath string // Empty if the diff creates the file
+	newPath string // Empty if the diff deletes the file
+	hunks   []diffHunk
+}
+
+// diffHunk is a single hunk of a unified diff
+type diffHunk struct {
+	header   string
+	oldStart int // 1-based. For a hunk that removes no lines, the line after which new lines are inserted
+	newStart int
+	lines    []diffLine
+
+	// Set by "\ No newline at end of file" markers
+	oldNoNewline bool
+	newNoNewline bool
+}
+
+// diffLine is a line of a hunk. op is ' ' for context, '-' for a removed line, or '+' for an added line
+type diffLine struct {
+	op   byte
+	text string
+}
+
+var hunkHeaderRegex = regexp.MustCompile(`^@@ -(\d+)(?:,(\d+))? \+(\d+)(?:,(\d+))? @@`)
+
+// parseUnifiedDiff parses a unified diff of a single file. Lines preceding the file header, such as "diff --git" and
+// "index" lines, are ignored. Hunk line counts are not checked, since they're easy to get wrong by hand; each hunk
+// extends to the next hunk header
+func parseUnifiedDiff(patch string) (*unifiedDiff, error) {
+	lines := strings.Split(strings.TrimSuffix(strings.ReplaceAll(patch, "\r\n", "\n"), "\n"), "\n")
+
+	i := 0
+	for i < len(lines) && !strings.HasPrefix(lines[i], "--- ") {
+		i++
+	}
+	if i+1 >= len(lines) || !strings.HasPrefix(lines[i+1], "+++ ") {
+		return nil, fmt.Errorf("missing '--- ' and '+++ ' file header lines")
+	}
+	diff := &unifiedDiff{
+		oldPath: parseDiffPath(strings.TrimPrefix(lines[i], "--- ")),
+		newPath: parseDiffPath(strings.TrimPrefix(lines[i+1], "+++ ")),
+	}
+	if diff.oldPath == "" && diff.newPath == "" {
+		return nil, fmt.Errorf("file header has no path")
+	}
+
+	var hunk *diffHunk
+	for j := i + 2; j < len(lines); j++ {
+		line := lines[j]
+		if strings.HasPrefix(line, "diff ") ||
+			(strings.HasPrefix(line, "--- ") && j+1 < len(lines) && strings.HasPrefix(lines[j+1], "+++ ")) {
+			return nil, fmt.Errorf("patch must modify a single file")
+		}
+		if strings.HasPrefix(line, "@@") {
+			m := hunkHeaderRegex.FindStringSubmatch(line)
+			if m == nil {
+				return nil, fmt.Errorf("malformed hunk header: %s", line)
+			}
+			oldStart, _ := strconv.Atoi(m[1])
+			newStart, _ := strconv.Atoi(m[3])
+			diff.hunks = append(diff.hunks, diffHunk{header: line, oldStart: oldStart, newStart: newStart})
+			hunk = &diff.hunks[len(diff.hunks)-1]
+			continue
+		}
+		if hunk == nil {
+			return nil, fmt.Errorf("unexpected line before first hunk: %s", line)
+		}
+
+		switch {
+		case strings.HasPrefix(line, "\\"):
+			// "\ No newline at end of file" applies to the preceding line
+			if len(hunk.lines) == 0 {
+				return nil, fmt.Errorf("unexpected '%s' at start of hunk %s", line, hunk.header)
+			}
+			switch hunk.lines[len(hunk.lines)-1].op {
+			case '-':
+				hunk.oldNoNewline = true
+			case '+':
+				hunk.newNoNewline = true
+			default:
+				hunk.oldNoNewline, hunk.newNoNewline = true, true
+			}
+		case line == "":
+			// Editors and models often strip the trailing space from blank context lines
+			hunk.lines = append(hunk.lines, diffLine{op: ' '})
+		case line[0] == ' ' || line[0] == '-' || line[0] == '+':
+			hunk.lines = append(hunk.lines, diffLine{op: line[0], text: line[1:]})
+		default:
+			return nil, fmt.Errorf("unexpected line in hunk %s: %s", hunk.header, line)
+		}
+	}
+	if len(diff.hunks) == 0 {
+		return nil, fmt.Errorf("patch has no hunks")
+	}
+	return diff, nil
+}
+
+// parseDiffPath parses the path from a "--- " or "+++ " line, with the prefix removed. Returns an empty string for
+// /dev/null
+func parseDiffPath(s string) string {
+	// Some tools append a tab and a timestamp
+	s, _, _ = strings.Cut(s, "\t")
+	s = strings.TrimSpace(s)
+	if s == "/dev/null" {
+		return ""
+	}
+	if rest, ok := strings.CutPrefix(s, "a/"); ok {
+		return rest
+	}
+	if rest, ok := strings.CutPrefix(s, "b/"); ok {
+		return rest
+	}
+	return s
+}
+
+// oldLines returns the lines that the hunk expects to find in the file
+func (h diffHunk) oldLines() []string {
+	var lines []string
+	for _, l := range h.lines {
+		if l.op != '+' {
+			lines = append(lines, l.text)
+		}
+	}
+	return lines
+}
+
+// newLines returns the lines that the hunk replaces its old lines with
+func (h diffHunk) newLines() []string {
+	var lines []string
+	for _, l := range h.lines {
+		if l.op != '-' {
+			lines = append(lines, l.text)
+		}
+	}
+	return lines
+}
+
+// reversed returns a diff that undoes this one
+func (d unifiedDiff) reversed() unifiedDiff {
+	rev := unifiedDiff{oldPath: d.newPath, newPath: d.oldPath}
+	for _, h := range d.hunks {
+		rh := diffHunk{
+			header:       h.header,
+			oldStart:     h.newStart,
+			newStart:     h.oldStart,
+			oldNoNewline: h.newNoNewline,
+			newNoNewline: h.oldNoNewline,
+		}
+		for _, l := range h.lines {
+			switch l.op {
+			case '-':
+				l.op = '+'
+			case '+':
+				l.op = '-'
+			}
+			rh.lines = append(rh.lines, l)
+		}
+		rev.hunks = append(rev.hunks, rh)
+	}
+	return rev
+}
+
+// apply applies the diff's hunks to content, in order, and returns the result. Each hunk is placed where its old lines
+// match the content, preferring the position given in its header. Returns an error describing the first hunk that
+// doesn't match
+func (d unifiedDiff) apply(content string) (string, error) {
+	var lines []string
+	trailingNewline := true
+	if content != "" {
+		trailingNewline = strings.HasSuffix(content, "\n")
+		lines = strings.Split(strings.TrimSuffix(content, "\n"), "\n")
+	}
+
+	var result []string
+	pos := 0 // Lines before pos have been consumed by previous hunks
+	for i, hunk := range d.hunks {
+		old := hunk.oldLines()
+
+		var idx int
+		if len(old) == 0 {
+			// Pure insertion after line oldStart
+			idx = hunk.oldStart
+			if idx < pos || idx > len(lines) {
+				return "", fmt.Errorf("hunk %d (%s) inserts after line %d, which is out of range", i+1, hunk.header, idx)
+			}
+		} else {
+			var ok bool
+			idx, ok = findHunk(lines, old, pos, hunk.oldStart-1)
+			if !ok {
+				return "", fmt.Errorf("hunk %d does not match the file. No changes were made. Expected to find these "+
+					"lines:\n<<<\n%s\n>>>\nView the file and regenerate the patch against its current content",
+					i+1, strings.Join(old, "\n"))
+			}
+		}
+
+		result = append(result, lines[pos:idx]...)
+		result = append(result, hunk.newLines()...)
+		pos = idx + len(old)
+		if pos == len(lines) {
+			trailingNewline = !hunk.newNoNewline
+		}
+	}
+	result = append(result, lines[pos:]...)
+
+	patched := strings.Join(result, "\n")
+	if trailingNewline && len(result) > 0 {
+		patched += "\n"
+	}
+	return patched, nil
+}
+
+// findHunk returns the index at or after start at which lines contains want, choosing the match closest to hint
+func findHunk(lines []string, want []string, start int, hint int) (int, bool) {
+	best, found := 0, false
+	for idx := start; idx+len(want) <= len(lines); idx++ {
+		if !slices.Equal(lines[idx:idx+len(want)], want) {
+			continue
+		}
+		if !found || absInt(idx-hint) < absInt(best-hint) {
+			best, found = idx, true
+		}
+	}
+	return best, found
+}
+
+func absInt(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+// maxReadFilesPaths is the maximum number of files that can be read with one read_multiple_files call
+const maxReadFilesPaths = 20
+
+// ReadFilesTool implements the read_multiple_files tool
+type ReadFilesTool struct {
+	BaseTool
+}
+
+// ReadFilesInput represents the input for read_multiple_files
+type ReadFilesInput struct {
+	Paths []string `json:"paths"`
+}
+
+// NewReadFilesTool creates a new read files tool
+func NewReadFilesTool() *ReadFilesTool {
+	return &ReadFilesTool{
+		BaseTool: BaseTool{Name: "read_multiple_files"},
+	}
+}
+
+// GetToolParam returns the tool parameter definition
+func (t *ReadFilesTool) GetToolParam() anthropic.ToolParam {
+	return anthropic.ToolParam{
+		Name: t.Name,
+		Description: anthropic.String(fmt.Sprintf("Read the contents of several files at once, with line numbers. Prefer "+
+			"this over viewing files one at a time when you already know which files you need. At most %d paths may be "+
+			"given", maxReadFilesPaths)),
+		InputSchema: anthropic.ToolInputSchemaParam{
+			Properties: map[string]any{
+				"paths": map[string]any{
+					"type":        "array",
+					"items":       map[string]any{"type": "string"},
+					"description": "Paths of the files to read",
+				},
+			},
+			Required: []string{"paths"},
+		},
+	}
+}
+
+// ParseToolUse parses the tool use block
+func (t *ReadFilesTool) ParseToolUse(block anthropic.ToolUseBlock) (*ReadFilesInput, error) {
+	if block.Name != t.Name {
+		return nil, fmt.Errorf("tool use block is for %s, not %s", block.Name, t.Name)
+	}
+
+	var input ReadFilesInput
+	if err := parseInputJSON(block, &input); err != nil {
+		return nil, err
+	}
+	return &input, nil
+}
+
+// Run executes the read files command
+func (t *ReadFilesTool) Run(ctx context.Context, block anthropic.ToolUseBlock, toolCtx *ToolContext) (*string, error) {
+	input, err := t.ParseToolUse(block)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing input: %w", err)
+	}
+
+	if len(input.Paths) == 0 {
+		return nil, ToolInputError{fmt.Errorf("at least one path is required")}
+	}
+	if len(input.Paths) > maxReadFilesPaths {
+		return nil, ToolInputError{fmt.Errorf("too many paths (%d), at most %d may be read at once", len(input.Paths), maxReadFilesPaths)}
+	}
+
+	var result strings.Builder
+	for _, path := range input.Paths {
+		fmt.Fprintf(&result, "==> %s <==\n", path)
+
+		// Report problems with individual files inline, so that one bad path doesn't waste the whole call
+		content, err := toolCtx.Workspace.Read(ctx, path)
+		if errors.Is(err, workspace.ErrFileNotFound) {
+			result.WriteString("Error: file not found\n\n")
+			continue
+		} else if errors.Is(err, workspace.ErrIsDir) {
+			result.WriteString("Error: path is a directory\n\n")
+			continue
+		} else if err != nil {
+			return nil, fmt.Errorf("error reading file '%s': %w", path, err)
+		}
+
+		result.WriteString(numberLines(content))
+		result.WriteString("\n")
+	}
+
+	s := result.String()
+	return &s, nil
+}
+
+func (t *ReadFilesTool) Replay(ctx context.Context, block anthropic.ToolUseBlock, toolCtx *ToolContext) error {
+	// No side effects to replay
+	return nil
+}
+
+const (
+	// defaultListTreeDepth is how many levels list_directory_tree descends when no max_depth is given
+	defaultListTreeDepth = 3
+	// maxListTreeEntries caps the number of entries that list_directory_tree returns at once
+	maxListTreeEntries = 300
+)
+
+// ListTreeTool implements the list_directory_tree tool
+type ListTreeTool struct {
+	BaseTool
+}
+
+// ListTreeInput represents the input for list_directory_tree
+type ListTreeInput struct {
+	Path     string `json:"path"`
+	MaxDepth *int   `json:"max_depth,omitempty"`
+}
+
+// NewListTreeTool creates a new list tree tool
+func NewListTreeTool() *ListTreeTool {
+	return &ListTreeTool{
+		BaseTool: BaseTool{Name: "list_directory_tree"},
+	}
+}
+
+// GetToolParam returns the tool parameter definition
+func (t *ListTreeTool) GetToolParam() anthropic.ToolParam {
+	return anthropic.ToolParam{
+		Name: t.Name,
+		Description: anthropic.String(fmt.Sprintf("List the files and subdirectories under a directory as an indented "+
+			"tree, reflecting your local changes. Use this to see the current structure of the repository after creating, "+
+			"moving, or deleting files. At most %d entries are listed", maxListTreeEntries)),
+		InputSchema: anthropic.ToolInputSchemaParam{
+			Properties: map[string]any{
+				"path": map[string]any{
+					"type":        "string",
+					"description": "Path of the directory to list. Use an empty string for the repository root",
+				},
+				"max_depth": map[string]any{
+					"type":        "integer",
+					"description": fmt.Sprintf("How many levels of subdirectories to descend into. Defaults to %d", defaultListTreeDepth),
+				},
+			},
+			Required: []string{"path"},
+		},
+	}
+}
+
+// ParseToolUse parses the tool use block
+func (t *ListTreeTool) ParseToolUse(block anthropic.ToolUseBlock) (*ListTreeInput, error) {
+	if block.Name != t.Name {
+		return nil, fmt.Errorf("tool use block is for %s, not %s", block.Name, t.Name)
+	}
+
+	var input ListTreeInput
+	if err := parseInputJSON(block, &input); err != nil {
+		return nil, err
+	}
+	return &input, nil
+}
+
+// Run executes the list tree command
+func (t *ListTreeTool) Run(ctx context.Context, block anthropic.ToolUseBlock, toolCtx *ToolContext) (*string, error) {
+	input, err := t.ParseToolUse(block)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing input: %w", err)
+	}
+
+	maxDepth := defaultListTreeDepth
+	if input.MaxDepth != nil {
+		if *input.MaxDepth < 1 {
+			return nil, ToolInputError{fmt.Errorf("max_depth must be at least 1")}
+		}
+		maxDepth = *input.MaxDepth
+	}
+
+	dir := strings.Trim(input.Path, "/")
+	if dir == "." {
+		dir = ""
+	}
+	if dir != "" {
+		isDir, err := toolCtx.Workspace.IsDir(ctx, dir)
+		if err != nil {
+			return nil, fmt.Errorf("error checking path '%s': %w", dir, err)
+		}
+		if !isDir {
+			return nil, ToolInputError{fmt.Errorf("'%s' is not a directory", dir)}
+		}
+	}
+
+	var result strings.Builder
+	if dir == "" {
+		result.WriteString("./\n")
+	} else {
+		fmt.Fprintf(&result, "%s/\n", dir)
+	}
+
+	entries := 0
+	truncated, err := listTree(ctx, toolCtx.Workspace, dir, 1, maxDepth, &result, &entries)
+	if err != nil {
+		return nil, err
+	}
+	if truncated {
+		fmt.Fprintf(&result, "... (stopped after %d entries; list a subdirectory or use a smaller max_depth to see the rest)\n",
+			maxListTreeEntries)
+	}
+
+	s := result.String()
+	return &s, nil
+}
+
+// listTree writes the entries of dir to sb, indented by depth, descending into subdirectories until maxDepth. entries
+// counts the entries written so far. Returns true if it stopped early because maxListTreeEntries was reached
+func listTree(
+	ctx context.Context,
+	fs workspace.ReadOnlyFileSystem,
+	dir string,
+	depth int,
+	maxDepth int,
+	sb *strings.Builder,
+	entries *int,
+) (bool, error) {
+	names, err := fs.ListDir(ctx, dir)
+	if err != nil {
+		return false, fmt.Errorf("error listing directory '%s': %w", dir, err)
+	}
+	slices.Sort(names)
+
+	for _, name := range names {
+		if *entries == maxListTreeEntries {
+			return true, nil
+		}
+		*entries++
+		fmt.Fprintf(sb, "%s%s\n", strings.Repeat("  ", depth), name)
+
+		subdir, isDir := strings.CutSuffix(name, "/")
+		if !isDir || depth == maxDepth {
+			continue
+		}
+		if dir != "" {
+			subdir = dir + "/" + subdir
+		}
+		truncated, err := listTree(ctx, fs, subdir, depth+1, maxDepth, sb, entries)
+		if err != nil || truncated {
+			return truncated, err
+		}
+	}
+	return false, nil
+}
+
+func (t *ListTreeTool) Replay(ctx context.Context, block anthropic.ToolUseBlock, toolCtx *ToolContext) error {
+	// No side effects to replay
+	return nil
+}
+
+// maxBlameLines caps the number of lines that blame_file reports on at once
+const maxBlameLines = 200
+
+// BlameTool implements the blame_file tool
+type BlameTool struct {
+	BaseTool
+}
+
+// BlameInput represents the input for blame_file
+type BlameInput struct {
+	Path      string `json:"path"`
+	StartLine int    `json:"start_line,omitempty"`
+	EndLine   int    `json:"end_line,omitempty"`
+}
+
+// NewBlameTool creates a new blame tool
+func NewBlameTool() *BlameTool {
+	return &BlameTool{
+		BaseTool: BaseTool{Name: "blame_file"},
+	}
+}
+
+// GetToolParam returns the tool parameter definition
+func (t *BlameTool) GetToolParam() anthropic.ToolParam {
+	return anthropic.ToolParam{
+		Name: t.Name,
+		Description: anthropic.String(fmt.Sprintf("Show which commit last changed each line of a file, with the commit's author and date. "+
+			"Reflects changes that have been validated, but not local changes. At most %d lines are shown per call", maxBlameLines)),
+		InputSchema: anthropic.ToolInputSchemaParam{
+			Properties: map[string]any{
+				"path": map[string]any{
+					"type":        "string",
+					"description": "Path to the file",
+				},
+				"start_line": map[string]any{
+					"type":        "integer",
+					"description": "First line to show, starting from 1. Defaults to 1",
+				},
+				"end_line": map[string]any{
+					"type":        "integer",
+					"description": "Last line to show, inclusive",
+				},
+			},
+			Required: []string{"path"},
+		},
+	}
+}
+
+// ParseToolUse parses the tool use block
+func (t *BlameTool) ParseToolUse(block anthropic.ToolUseBlock) (*BlameInput, error) {
+	if block.Name != t.Name {
+		return nil, fmt.Errorf("tool use block is for %s, not %s", block.Name, t.Name)
+	}
+
+	var input BlameInput
+	if err := parseInputJSON(block, &input); err != nil {
+		return nil, err
+	}
+	return &input, nil
+}
+
+// Run executes the blame command
+func (t *BlameTool) Run(ctx context.Context, block anthropic.ToolUseBlock, toolCtx *ToolContext) (*string, error) {
+	input, err := t.ParseToolUse(block)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing input: %w", err)
+	}
+
+	path := strings.TrimPrefix(input.Path, "/")
+	if path == "" {
+		return nil, ToolInputError{fmt.Errorf("path is required")}
+	}
+	start := max(input.StartLine, 1)
+	end := input.EndLine
+	if end == 0 {
+		end = start + maxBlameLines - 1
+	} else if end < start {
+		return nil, ToolInputError{fmt.Errorf("end_line %d is before start_line %d", end, start)}
+	}
+	clamped := false
+	if end-start+1 > maxBlameLines {
+		end = start + maxBlameLines - 1
+		clamped = input.EndLine != 0
+	}
+
+	// Blame the source branch, which has the bot's validated changes, or the target branch if there are none yet
+	issue := toolCtx.Task.Issue
+	gql := githubgql.NewGraphQLClientFromREST(toolCtx.GithubClient)
+	ref := toolCtx.Task.SourceBranch
+	ranges, err := gql.Blame(ctx, issue.Owner, issue.Repo, ref, path)
+	if errors.Is(err, githubgql.ErrRefNotFound) {
+		ref = toolCtx.Task.TargetBranch
+		ranges, err = gql.Blame(ctx, issue.Owner, issue.Repo, ref, path)
+	}
+	var gqlErr githubgql.GraphQLError
+	if errors.As(err, &gqlErr) {
+		return nil, ToolInputError{fmt.Errorf("failed to blame '%s': %s", path, strings.Join(gqlErr.Messages, "; "))}
+	} else if err != nil {
+		return nil, err
+	}
+
+	s := formatBlame(path, ref, ranges, start, end, clamped)
+	return &s, nil
+}
+
+func (t *BlameTool) Replay(ctx context.Context, block anthropic.ToolUseBlock, toolCtx *ToolContext) error {
+	// No side effects to replay
+	return nil
+}
+
+// formatBlame formats the parts of the given blame ranges that fall within lines start to end, inclusive. Consecutive
+// lines from the same commit are grouped
+func formatBlame(path string, ref string, ranges []githubgql.BlameRange, start int, end int, clamped bool) string {
+	lastLine := 0
+	for _, r := range ranges {
+		lastLine = max(lastLine, r.EndLine)
+	}
+	end = min(end, lastLine)
+	if start > end {
+		return fmt.Sprintf("'%s' has %d lines, so there is nothing to show from line %d", path, lastLine, start)
+	}
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "Blame of '%s' at '%s', lines %d-%d:\n", path, ref, start, end)
+	for _, r := range ranges {
+		from, to := max(r.StartLine, start), min(r.EndLine, end)
+		if from > to {
+			continue
+		}
+		lines := strconv.Itoa(from)
+		if to > from {
+			lines = fmt.Sprintf("%d-%d", from, to)
+		}
+		sha := r.CommitSHA
+		if len(sha) > 12 {
+			sha = sha[:12]
+		}
+		fmt.Fprintf(&sb, "%s: %s %s %s: %s\n", lines, sha, r.CommitTimestamp.UTC().Format(time.DateOnly), r.CommitAuthor, r.CommitHeadline)
+	}
+	if clamped {
+		fmt.Fprintf(&sb, "Note: only %d lines can be shown at once. Request later lines in another call\n", maxBlameLines)
+	}
+	return sb.String()
+}
+
+// maxFindSymbolFiles is the maximum number of files that find_symbol will read in one call
+const maxFindSymbolFiles = 200
+
+// FindSymbolTool implements the find_symbol tool
+type FindSymbolTool struct {
+	BaseTool
+}
+
+// FindSymbolInput represents the input for find_symbol
+type FindSymbolInput struct {
+	Name      string `json:"name"`
+	Directory string `json:"directory,omitempty"`
+}
+
+// NewFindSymbolTool creates a new find symbol tool
+func NewFindSymbolTool() *FindSymbolTool {
+	return &FindSymbolTool{
+		BaseTool: BaseTool{Name: "find_symbol"},
+	}
+}
+
+// GetToolParam returns the tool parameter definition
+func (t *FindSymbolTool) GetToolParam() anthropic.ToolParam {
+	return anthropic.ToolParam{
+		Name: t.Name,
+		Description: anthropic.String("Find where a top-level Go function, method, type, constant, or variable is " +
+			"declared. Only available in Go repositories"),
+		InputSchema: anthropic.ToolInputSchemaParam{
+			Properties: map[string]any{
+				"name": map[string]any{
+					"type": "string",
+					"description": "The identifier to find, e.g. 'NewServer'. Methods can be qualified with their " +
+						"receiver type, e.g. 'Server.Start'",
+				},
+				"directory": map[string]any{
+					"type":        "string",
+					"description": "Only search files in this directory and its subdirectories. Searches the whole repository if omitted",
+				},
+			},
+			Required: []string{"name"},
+		},
+	}
+}
+
+// ParseToolUse parses the tool use block
+func (t *FindSymbolTool) ParseToolUse(block anthropic.ToolUseBlock) (*FindSymbolInput, error) {
+	if block.Name != t.Name {
+		return nil, fmt.Errorf("tool use block is for %s, not %s", block.Name, t.Name)
+	}
+
+	var input FindSymbolInput
+	if err := parseInputJSON(block, &input); err != nil {
+		return nil, err
+	}
+	return &input, nil
+}
+
+// Run executes the find symbol command
+func (t *FindSymbolTool) Run(ctx context.Context, block anthropic.ToolUseBlock, toolCtx *ToolContext) (*string, error) {
+	input, err := t.ParseToolUse(block)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing input: %w", err)
+	}
+
+	info := toolCtx.Task.CodebaseInfo
+	if info == nil || info.MainLanguage != "Go" {
+		return nil, ToolInputError{fmt.Errorf("find_symbol is only available in Go repositories")}
+	}
+	receiver, name, qualified := strings.Cut(input.Name, ".")
+	if !qualified {
+		receiver, name = "", input.Name
+	}
+	if !token.IsIdentifier(name) || (qualified && !token.IsIdentifier(receiver)) {
+		return nil, ToolInputError{fmt.Errorf("'%s' is not a Go identifier", input.Name)}
+	}
+
+	// Candidates come from the repository's file tree. Files are read through the workspace, so that local changes to
+	// them are taken into account
+	dir := strings.Trim(input.Directory, "/")
+	var candidates []string
+	for _, path := range info.FileTree {
+		if !strings.HasSuffix(path, ".go") || (dir != "" && !strings.HasPrefix(path, dir+"/")) {
+			continue
+		}
+		if strings.HasPrefix(path, "vendor/") || strings.Contains(path, "/vendor/") || strings.Contains(path, "testdata/") {
+			continue
+		}
+		candidates = append(candidates, path)
+	}
+	if len(candidates) > maxFindSymbolFiles {
+		return nil, ToolInputError{fmt.Errorf("there are %d Go files to search, but at most %d can be searched at once. "+
+			"Specify a directory to narrow the search", len(candidates), maxFindSymbolFiles)}
+	}
+
+	var matches []string
+	for _, path := range candidates {
+		content, err := toolCtx.Workspace.Read(ctx, path)
+		if errors.Is(err, workspace.ErrFileNotFound) {
+			// Deleted locally
+			continue
+		} else if err != nil {
+			return nil, fmt.Errorf("error reading file '%s': %w", path, err)
+		}
+		// Only parse files that could declare the symbol
+		if !strings.Contains(content, name) {
+			continue
+		}
+		matches = append(matches, findGoDeclarations(path, content, receiver, name)...)
+	}
+
+	var result string
+	if len(matches) == 0 {
+		where := "the repository"
+		if dir != "" {
+			where = fmt.Sprintf("'%s'", dir)
+		}
+		result = fmt.Sprintf("No top-level declaration of '%s' found in %s", input.Name, where)
+	} else {
+		result = strings.Join(matches, "\n")
+	}
+	return &result, nil
+}
+
+func (t *FindSymbolTool) Replay(ctx context.Context, block anthropic.ToolUseBlock, toolCtx *ToolContext) error {
+	// No side effects to replay
+	return nil
+}
+
+// findGoDeclarations parses Go source and returns the top-level declarations with the given name, formatted as
+// "path:line: <declaration line>". If receiver is non-empty, only methods of that receiver type match. Files that fail
+// to parse are searched as far as the parser got
+func findGoDeclarations(path string, content string, receiver string, name string) []string {
+	fset := token.NewFileSet()
+	file, _ := parser.ParseFile(fset, path, content, parser.SkipObjectResolution)
+	if file == nil {
+		return nil
+	}
+
+	lines := strings.Split(content, "\n")
+	format := func(pos token.Pos) string {
+		line := fset.Position(pos).Line
+		return fmt.Sprintf("%s:%d: %s", path, line, strings.TrimSpace(lines[line-1]))
+	}
+
+	var matches []string
+	for _, decl := range file.Decls {
+		switch d := decl.(type) {
+		case *ast.FuncDecl:
+			if d.Name.Name != name {
+				continue
+			}
+			if receiver != "" && (d.Recv == nil || receiverTypeName(d.Recv) != receiver) {
+				continue
+			}
+			matches = append(matches, format(d.Pos()))
+		case *ast.GenDecl:
+			if receiver != "" {
+				continue
+			}
+			for _, spec := range d.Specs {
+				switch s := spec.(type) {
+				case *ast.TypeSpec:
+					if s.Name.Name == name {
+						matches = append(matches, format(s.Pos()))
+					}
+				case *ast.ValueSpec:
+					for _, ident := range s.Names {
+						if ident.Name == name {
+							matches = append(matches, format(ident.Pos()))
+						}
+					}
+				}
+			}
+		}
+	}
+	return matches
+}
+
+// receiverTypeName returns the name of a method's receiver type, without any pointer or type parameters
+func receiverTypeName(recv *ast.FieldList) string {
+	if len(recv.List) == 0 {
+		return ""
+	}
+	expr := recv.List[0].Type
+	for {
+		switch e := expr.(type) {
+		case *ast.StarExpr:
+			expr = e.X
+		case *ast.IndexExpr:
+			expr = e.X
+		case *ast.IndexListExpr:
+			expr = e.X
+		case *ast.Ident:
+			return e.Name
+		default:
+			return ""
+		}
+	}
+}
+
+// ViewDiffTool implements the view_diff tool
+type ViewDiffTool struct {
+	BaseTool
+}
+
+// ViewDiffInput represents the input for view_diff
+type ViewDiffInput struct {
+	Path string `json:"path,omitempty"`
+}
+
+// NewViewDiffTool creates a new view diff tool
+func NewViewDiffTool() *ViewDiffTool {
+	return &ViewDiffTool{
+		BaseTool: BaseTool{Name: "view_diff"},
+	}
+}
+
+// GetToolParam returns the tool parameter definition
+func (t *ViewDiffTool) GetToolParam() anthropic.ToolParam {
+	return anthropic.ToolParam{
+		Name: t.Name,
+		Description: anthropic.String("View a unified diff of your local changes that have not yet been validated. Use " +
+			"this to recall what you have changed instead of re-reading files"),
+		InputSchema: anthropic.ToolInputSchemaParam{
+			Properties: map[string]any{
+				"path": map[string]any{
+					"type":        "string",
+					"description": "Optional file or directory path to limit the diff to. Omit to see all local changes",
+				},
+			},
+		},
+	}
+}
+
+// ParseToolUse parses the tool use block
+func (t *ViewDiffTool) ParseToolUse(block anthropic.ToolUseBlock) (*ViewDiffInput, error) {
+	if block.Name != t.Name {
+		return nil, fmt.Errorf("tool use block is for %s, not %s", block.Name, t.Name)
+	}
+
+	var input ViewDiffInput
+	if err := parseInputJSON(block, &input); err != nil {
+		return nil, err
+	}
+	return &input, nil
+}
+
+// Run executes the view diff command
+func (t *ViewDiffTool) Run(ctx context.Context, block anthropic.ToolUseBlock, toolCtx *ToolContext) (*string, error) {
+	input, err := t.ParseToolUse(block)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing input: %w", err)
+	}
+
+	diff, err := toolCtx.Workspace.Diff(ctx, input.Path)
+	if err != nil {
+		return nil, fmt.Errorf("error computing diff: %w", err)
+	}
+
+	if diff == "" {
+		result := "No local changes"
+		if input.Path != "" {
+			result = fmt.Sprintf("No local changes in %s", input.Path)
+		}
+		return &result, nil
+	}
+
+	return &diff, nil
+}
+
+func (t *ViewDiffTool) Replay(ctx context.Context, block anthropic.ToolUseBlock, toolCtx *ToolContext) error {
+	// No side effects to replay
+	return nil
+}
+
+// maxPRDiffChars is the maximum length of the diff shown by view_pull_request_diff
+const maxPRDiffChars = 50000
+
+// ViewPRDiffTool implements the view_pull_request_diff tool
+type ViewPRDiffTool struct {
+	BaseTool
+}
+
+// ViewPRDiffInput represents the input for view_pull_request_diff
+type ViewPRDiffInput struct {
+	Path string `json:"path,omitempty"`
+}
+
+// NewViewPRDiffTool creates a new view PR diff tool
+func NewViewPRDiffTool() *ViewPRDiffTool {
+	return &ViewPRDiffTool{
+		BaseTool: BaseTool{Name: "view_pull_request_diff"},
+	}
+}
+
+// GetToolParam returns the tool parameter definition
+func (t *ViewPRDiffTool) GetToolParam() anthropic.ToolParam {
+	return anthropic.ToolParam{
+		Name: t.Name,
+		Description: anthropic.String(fmt.Sprintf("View the unified diff of your pull request, i.e. the changes you have "+
+			"already published for review. Use this to get an overview of the pull request instead of re-reading files. "+
+			"Local and unpublished changes are not included; use view_diff for those. At most %d characters are shown",
+			maxPRDiffChars)),
+		InputSchema: anthropic.ToolInputSchemaParam{
+			Properties: map[string]any{
+				"path": map[string]any{
+					"type":        "string",
+					"description": "Optional file or directory path to limit the diff to. Omit to see the whole diff",
+				},
+			},
+		},
+	}
+}
+
+// ParseToolUse parses the tool use block
+func (t *ViewPRDiffTool) ParseToolUse(block anthropic.ToolUseBlock) (*ViewPRDiffInput, error) {
+	if block.Name != t.Name {
+		return nil, fmt.Errorf("tool use block is for %s, not %s", block.Name, t.Name)
+	}
+
+	var input ViewPRDiffInput
+	if err := parseInputJSON(block, &input); err != nil {
+		return nil, err
+	}
+	return &input, nil
+}
+
+// Run executes the view PR diff command
+func (t *ViewPRDiffTool) Run(ctx context.Context, block anthropic.ToolUseBlock, toolCtx *ToolContext) (*string, error) {
+	input, err := t.ParseToolUse(block)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing input: %w", err)
+	}
+
+	taskPR := toolCtx.Task.PullRequest
+	if taskPR == nil {
+		return nil, ToolInputError{fmt.Errorf("there is no pull request yet. Use view_diff to see your local changes")}
+	}
+
+	diff, _, err := toolCtx.GithubClient.PullRequests.GetRaw(ctx, taskPR.Owner, taskPR.Repo, taskPR.Number,
+		github.RawOptions{Type: github.Diff})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get pull request diff: %w", err)
+	}
+
+	path := strings.Trim(input.Path, "/")
+	if path != "" {
+		diff = filterDiffByPath(diff, path)
+	}
+	if diff == "" {
+		result := fmt.Sprintf("Pull request #%d has no changes", taskPR.Number)
+		if path != "" {
+			result = fmt.Sprintf("Pull request #%d has no changes in %s", taskPR.Number, path)
+		}
+		return &result, nil
+	}
+
+	if len(diff) > maxPRDiffChars {
+		diff = fmt.Sprintf("%s\n[Diff truncated: showing %d of %d characters. Pass a path to see the rest]",
+			diff[:maxPRDiffChars], maxPRDiffChars, len(diff))
+	}
+	return &diff, nil
+}
+
+func (t *ViewPRDiffTool) Replay(ctx context.Context, block anthropic.ToolUseBlock, toolCtx *ToolContext) error {
+	// No side effects to replay
+	return nil
+}
+
+const (
+	// defaultGitLogCount is the number of commits shown by git_log if the AI doesn't say
+	defaultGitLogCount = 20
+	// maxGitLogCount is the maximum number of commits shown by git_log
+	maxGitLogCount = 100
+)
+
+// GitLogTool implements the git_log tool
+type GitLogTool struct {
+	BaseTool
+}
+
+// GitLogInput represents the input for git_log
+type GitLogInput struct {
+	Count int `json:"count,omitempty"`
+}
+
+// NewGitLogTool creates a new git log tool
+func NewGitLogTool() *GitLogTool {
+	return &GitLogTool{
+		BaseTool: BaseTool{Name: "git_log"},
+	}
+}
+
+// GetToolParam returns the tool parameter definition
+func (t *GitLogTool) GetToolParam() anthropic.ToolParam {
+	return anthropic.ToolParam{
+		Name: t.Name,
+		Description: anthropic.String("List the most recent commits on the branch that your pull request is opened from, " +
+			"newest first, with their SHAs, authors, and messages. Use this to see what has already been published, e.g. " +
+			"when resuming work, so that you don't redo it. Commits from the base branch are included, so check the authors"),
+		InputSchema: anthropic.ToolInputSchemaParam{
+			Properties: map[string]any{
+				"count": map[string]any{
+					"type":        "integer",
+					"description": fmt.Sprintf("The number of commits to list. Defaults to %d, at most %d", defaultGitLogCount, maxGitLogCount),
+				},
+			},
+		},
+	}
+}
+
+// ParseToolUse parses the tool use block
+func (t *GitLogTool) ParseToolUse(block anthropic.ToolUseBlock) (*GitLogInput, error) {
+	if block.Name != t.Name {
+		return nil, fmt.Errorf("tool use block is for %s, not %s", block.Name, t.Name)
+	}
+
+	var input GitLogInput
+	if err := parseInputJSON(block, &input); err != nil {
+		return nil, err
+	}
+	return &input, nil
+}
+
+// Run lists the commits
+func (t *GitLogTool) Run(ctx context.Context, block anthropic.ToolUseBlock, toolCtx *ToolContext) (*string, error) {
+	input, err := t.ParseToolUse(block)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing input: %w", err)
+	}
+
+	count := input.Count
+	if count == 0 {
+		count = defaultGitLogCount
+	}
+	if count < 0 || count > maxGitLogCount {
+		return nil, ToolInputError{fmt.Errorf("count must be between 1 and %d", maxGitLogCount)}
+	}
+
+	issue := toolCtx.Task.Issue
+	branch := toolCtx.Task.SourceBranch
+	commits, resp, err := toolCtx.GithubClient.Repositories.ListCommits(ctx, issue.Owner, issue.Repo, &github.CommitsListOptions{
+		SHA:         branch,
+		ListOptions: github.ListOptions{PerPage: count},
+	})
+	if err != nil {
+		// GitHub responds 404 for branches that don't exist and 409 for repositories with no commits at all
+		if resp != nil && (resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusConflict) {
+			result := fmt.Sprintf("Branch %s has no commits yet. Nothing has been published", branch)
+			return &result, nil
+		}
+		return nil, fmt.Errorf("failed to list commits: %w", err)
+	}
+	if len(commits) == 0 {
+		result := fmt.Sprintf("Branch %s has no commits yet. Nothing has been published", branch)
+		return &result, nil
+	}
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "The %d most recent commits on %s, newest first:\n", len(commits), branch)
+	for _, commit := range commits {
+		sha := commit.GetSHA()
+		if len(sha) > 12 {
+			sha = sha[:12]
+		}
+		author := commit.GetAuthor().GetLogin()
+		if author == "" {
+			author = commit.GetCommit().GetAuthor().GetName()
+		}
+		headline, _, _ := strings.Cut(commit.GetCommit().GetMessage(), "\n")
+		date := commit.GetCommit().GetAuthor().GetDate().Format(time.DateOnly)
+		fmt.Fprintf(&sb, "\n%s %s %s: %s", sha, date, author, headline)
+	}
+	result := sb.String()
+	return &result, nil
+}
+
+func (t *GitLogTool) Replay(ctx context.Context, block anthropic.ToolUseBlock, toolCtx *ToolContext) error {
+	// No side effects to replay
+	return nil
+}
+
+// ViewFileAtRefTool implements the view_file_at_ref tool
+type ViewFileAtRefTool struct {
+	BaseTool
+}
+
+// ViewFileAtRefInput represents the input for view_file_at_ref
+type ViewFileAtRefInput struct {
+	Path      string `json:"path"`
+	Ref       string `json:"ref"`
+	ViewRange []int  `json:"view_range,omitempty"`
+}
+
+// NewViewFileAtRefTool creates a new view file at ref tool
+func NewViewFileAtRefTool() *ViewFileAtRefTool {
+	return &ViewFileAtRefTool{
+		BaseTool: BaseTool{Name: "view_file_at_ref"},
+	}
+}
+
+// GetToolParam returns the tool parameter definition
+func (t *ViewFileAtRefTool) GetToolParam() anthropic.ToolParam {
+	return anthropic.ToolParam{
+		Name: t.Name,
+		Description: anthropic.String("View a file as it is on a given branch or commit, regardless of any changes you " +
+			"have made in the workspace. Use this to compare your version of a file with the base branch's, or to see " +
+			"a file as it was before a commit"),
+		InputSchema: anthropic.ToolInputSchemaParam{
+			Properties: map[string]any{
+				"path": map[string]any{
+					"type":        "string",
+					"description": "The path of the file, relative to the repository root",
+				},
+				"ref": map[string]any{
+					"type":        "string",
+					"description": "The branch name, tag, or commit SHA to view the file at",
+				},
+				"view_range": map[string]any{
+					"type":        "array",
+					"items":       map[string]any{"type": "integer"},
+					"description": "Optional start and end line numbers to view, starting from 1. An end of -1 means the end of the file",
+				},
+			},
+			Required: []string{"path", "ref"},
+		},
+	}
+}
+
+// ParseToolUse parses the tool use block
+func (t *ViewFileAtRefTool) ParseToolUse(block anthropic.ToolUseBlock) (*ViewFileAtRefInput, error) {
+	if block.Name != t.Name {
+		return nil, fmt.Errorf("tool use block is for %s, not %s", block.Name, t.Name)
+	}
+
+	var input ViewFileAtRefInput
+	if err := parseInputJSON(block, &input); err != nil {
+		return nil, err
+	}
+	return &input, nil
+}
+
+// Run fetches the file at the given ref
+func (t *ViewFileAtRefTool) Run(ctx context.Context, block anthropic.ToolUseBlock, toolCtx *ToolContext) (*string, error) {
+	input, err := t.ParseToolUse(block)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing input: %w", err)
+	}
+
+	if input.Path == "" || input.Ref == "" {
+		return nil, ToolInputError{fmt.Errorf("path and ref are required")}
+	}
+
+	issue := toolCtx.Task.Issue
+	opts := &github.RepositoryContentGetOptions{Ref: input.Ref}
+	file, _, resp, err := toolCtx.GithubClient.Repositories.GetContents(ctx, issue.Owner, issue.Repo, input.Path, opts)
+	if err != nil {
+		// GitHub responds 404 for both missing paths and missing refs
+		if resp != nil && resp.StatusCode == http.StatusNotFound {
+			return nil, ToolInputError{fmt.Errorf("%s does not exist at %s", input.Path, input.Ref)}
+		}
+		return nil, fmt.Errorf("failed to get %s at %s: %w", input.Path, input.Ref, err)
+	}
+	if file == nil {
+		return nil, ToolInputError{fmt.Errorf("%s is a directory at %s", input.Path, input.Ref)}
+	}
+
+	content, err := file.GetContent()
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode %s at %s: %w", input.Path, input.Ref, err)
+	}
+
+	result := formatFileView(input.Path, content, input.ViewRange)
+	return &result, nil
+}
+
+func (t *ViewFileAtRefTool) Replay(ctx context.Context, block anthropic.ToolUseBlock, toolCtx *ToolContext) error {
+	// No side effects to replay
+	return nil
+}
+
+// filterDiffByPath returns the sections of a git diff for files at or under the given path. A file matches if either
+// its old or new path does, so that renames into or out of the path are included
+func filterDiffByPath(diff string, path string) string {
+	matches := func(filePath string) bool {
+		return filePath == path || strings.HasPrefix(filePath, path+"/")
+	}
+
+	var sb strings.Builder
+	for _, section := range splitDiffByFile(diff) {
+		header, _, _ := strings.Cut(section, "\n")
+		oldPath, newPath, ok := parseDiffGitHeader(header)
+		if ok && (matches(oldPath) || matches(newPath)) {
+			sb.WriteString(section)
+		}
+	}
+	return sb.String()
+}
+
+// splitDiffByFile splits a git diff into one section per file, each starting with its "diff --git" line
+func splitDiffByFile(diff string) []string {
+	var sections []string
+	var section strings.Builder
+	for line := range strings.Lines(diff) {
+		if strings.HasPrefix(line, "diff --git ") && section.Len() > 0 {
+			sections = append(sections, section.String())
+			section.Reset()
+		}
+		section.WriteString(line)
+	}
+	if section.Len() > 0 {
+		sections = append(sections, section.String())
+	}
+	return sections
+}
+
+// parseDiffGitHeader parses the old and new paths from a "diff --git a/<old> b/<new>" line. Paths containing " b/" are
+// ambiguous, and are split at the middle on the assumption that the file wasn't renamed
+func parseDiffGitHeader(header string) (string, string, bool) {
+	paths, ok := strings.CutPrefix(strings.TrimSuffix(header, "\n"), "diff --git a/")
+	if !ok {
+		return "", "", false
+	}
+	if strings.Count(paths, " b/") > 1 {
+		half := (len(paths) - len(" b/")) / 2
+		return paths[:half], paths[half+len(" b/"):], true
+	}
+	oldPath, newPath, ok := strings.Cut(paths, " b/")
+	return oldPath, newPath, ok
+}
+
+// ManageLabelsTool implements the manage_labels tool
+type ManageLabelsTool struct {
+	BaseTool
+}
+
+// ManageLabelsInput represents the input for manage_labels
+type ManageLabelsInput struct {
+	Add    []string `json:"add,omitempty"`
+	Remove []string `json:"remove,omitempty"`
+}
+
+// NewManageLabelsTool creates a new manage labels tool
+func NewManageLabelsTool() *ManageLabelsTool {
+	return &ManageLabelsTool{
+		BaseTool: BaseTool{Name: "manage_labels", Mutating: true},
+	}
+}
+
+// GetToolParam returns the tool parameter definition
+func (t *ManageLabelsTool) GetToolParam() anthropic.ToolParam {
+	return anthropic.ToolParam{
+		Name:        t.Name,
+		Description: anthropic.String("Add labels to or remove labels from the issue, e.g. to triage it as a bug or mark it as needing more information"),
+		InputSchema: anthropic.ToolInputSchemaParam{
+			Properties: map[string]any{
+				"add": map[string]any{
+					"type":        "array",
+					"items":       map[string]any{"type": "string"},
+					"description": "Names of labels to add to the issue",
+				},
+				"remove": map[string]any{
+					"type":        "array",
+					"items":       map[string]any{"type": "string"},
+					"description": "Names of labels to remove from the issue",
+				},
+			},
+		},
+	}
+}
+
+// ParseToolUse parses the tool use block
+func (t *ManageLabelsTool) ParseToolUse(block anthropic.ToolUseBlock) (*ManageLabelsInput, error) {
+	if block.Name != t.Name {
+		return nil, fmt.Errorf("tool use block is for %s, not %s", block.Name, t.Name)
+	}
+
+	var input ManageLabelsInput
+	if err := parseInputJSON(block, &input); err != nil {
+		return nil, err
+	}
+	return &input, nil
+}
+
+// Run executes the manage labels command
+func (t *ManageLabelsTool) Run(ctx context.Context, block anthropic.ToolUseBlock, toolCtx *ToolContext) (*string, error) {
+	input, err := t.ParseToolUse(block)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing input: %w", err)
+	}
+
+	if len(input.Add) == 0 && len(input.Remove) == 0 {
+		return nil, ToolInputError{fmt.Errorf("at least one label to add or remove is required")}
+	}
+
+	// Check all labels before making any changes, so that rejected input has no side effects
+	for _, label := range append(slices.Clone(input.Add), input.Remove...) {
+		if strings.TrimSpace(label) == "" {
+			return nil, ToolInputError{fmt.Errorf("label names must not be empty")}
+		}
+		if isInternalLabel(toolCtx.Task, label) {
+			return nil, ToolInputError{fmt.Errorf("label '%s' is managed automatically and cannot be changed", label)}
+		}
+	}
+
+	issue := toolCtx.Task.Issue
+	var result strings.Builder
+
+	if len(input.Add) > 0 {
+		err := toolCtx.VCS.AddLabels(ctx, issue.Owner, issue.Repo, issue.Number, input.Add)
+		if err != nil {
+			return nil, fmt.Errorf("failed to add labels: %w", err)
+		}
+		fmt.Fprintf(&result, "Added labels: %s\n", strings.Join(input.Add, ", "))
+	}
+
+	for _, label := range input.Remove {
+		err := toolCtx.VCS.RemoveLabel(ctx, issue.Owner, issue.Repo, issue.Number, label)
+		if err != nil {
+			if errors.Is(err, vcs.ErrNotFound) {
+				fmt.Fprintf(&result, "Label '%s' was not on the issue\n", label)
+				continue
+			}
+			return nil, fmt.Errorf("failed to remove label '%s': %w", label, err)
+		}
+		fmt.Fprintf(&result, "Removed label: %s\n", label)
+	}
+
+	s := result.String()
+	return &s, nil
+}
+
+func (t *ManageLabelsTool) Replay(ctx context.Context, block anthropic.ToolUseBlock, toolCtx *ToolContext) error {
+	// No side effects to replay
+	return nil
+}
+
+// isInternalLabel returns true if the given label is one the bot uses to track its own state on the given task
+func isInternalLabel(tsk task.Task, name string) bool {
+	for _, label := range []github.Label{task.LabelWorking, task.LabelBlocked, task.LabelBotTurn, task.LabelNeedsHuman,
+		tsk.IgnoreLabel()} {
+		if strings.EqualFold(strings.TrimSpace(name), label.GetName()) {
+			return true
+		}
+	}
+	return false
+}
+
+// ListAssigneesTool implements the list_assignees tool
+type ListAssigneesTool struct {
+	BaseTool
+}
+
+// NewListAssigneesTool creates a new list assignees tool
+func NewListAssigneesTool() *ListAssigneesTool {
+	return &ListAssigneesTool{
+		BaseTool: BaseTool{Name: "list_assignees"},
+	}
+}
+
+// GetToolParam returns the tool parameter definition
+func (t *ListAssigneesTool) GetToolParam() anthropic.ToolParam {
+	return anthropic.ToolParam{
+		Name:        t.Name,
+		Description: anthropic.String("List who is assigned to the issue"),
+		InputSchema: anthropic.ToolInputSchemaParam{
+			Properties: map[string]any{},
+		},
+	}
+}
+
+// Run lists the issue's assignees
+func (t *ListAssigneesTool) Run(ctx context.Context, block anthropic.ToolUseBlock, toolCtx *ToolContext) (*string, error) {
+	if block.Name != t.Name {
+		return nil, fmt.Errorf("tool use block is for %s, not %s", block.Name, t.Name)
+	}
+
+	issue := toolCtx.Task.Issue
+	ghIssue, _, err := toolCtx.GithubClient.Issues.Get(ctx, issue.Owner, issue.Repo, issue.Number)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get issue: %w", err)
+	}
+
+	var logins []string
+	for _, user := range ghIssue.Assignees {
+		logins = append(logins, user.GetLogin())
+	}
+	result := formatAssignees(issue.Number, logins)
+	return &result, nil
+}
+
+func (t *ListAssigneesTool) Replay(ctx context.Context, block anthropic.ToolUseBlock, toolCtx *ToolContext) error {
+	// No side effects to replay
+	return nil
+}
+
+// ManageAssigneesTool implements the manage_assignees tool
+type ManageAssigneesTool struct {
+	BaseTool
+}
+
+// ManageAssigneesInput represents the input for manage_assignees
+type ManageAssigneesInput struct {
+	Add    []string `json:"add,omitempty"`
+	Remove []string `json:"remove,omitempty"`
+}
+
+// NewManageAssigneesTool creates a new manage assignees tool
+func NewManageAssigneesTool() *ManageAssigneesTool {
+	return &ManageAssigneesTool{
+		BaseTool: BaseTool{Name: "manage_assignees", Mutating: true},
+	}
+}
+
+// GetToolParam returns the tool parameter definition
+func (t *ManageAssigneesTool) GetToolParam() anthropic.ToolParam {
+	return anthropic.ToolParam{
+		Name: t.Name,
+		Description: anthropic.String("Change who is assigned to the issue, e.g. to assign a maintainer when handing the " +
+			"issue off to a human. Lists the assignees afterwards"),
+		InputSchema: anthropic.ToolInputSchemaParam{
+			Properties: map[string]any{
+				"add": map[string]any{
+					"type":        "array",
+					"items":       map[string]any{"type": "string"},
+					"description": "The logins of users to assign. They must be able to be assigned to issues in the repository",
+				},
+				"remove": map[string]any{
+					"type":        "array",
+					"items":       map[string]any{"type": "string"},
+					"description": "The logins of users to unassign",
+				},
+			},
+		},
+	}
+}
+
+// ParseToolUse parses the tool use block
+func (t *ManageAssigneesTool) ParseToolUse(block anthropic.ToolUseBlock) (*ManageAssigneesInput, error) {
+	if block.Name != t.Name {
+		return nil, fmt.Errorf("tool use block is for %s, not %s", block.Name, t.Name)
+	}
+
+	var input ManageAssigneesInput
+	if err := parseInputJSON(block, &input); err != nil {
+		return nil, err
+	}
+	return &input, nil
+}
+
+// Run executes the manage assignees command
+func (t *ManageAssigneesTool) Run(ctx context.Context, block anthropic.ToolUseBlock, toolCtx *ToolContext) (*string, error) {
+	input, err := t.ParseToolUse(block)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing input: %w", err)
+	}
+
+	if len(input.Add) == 0 && len(input.Remove) == 0 {
+		return nil, ToolInputError{fmt.Errorf("at least one of add or remove is required. Use list_assignees to see " +
+			"who is assigned")}
+	}
+
+	issue := toolCtx.Task.Issue
+
+	// Check all users before making any changes, so that rejected input has no side effects
+	if err := checkAssignable(ctx, toolCtx, input.Add); err != nil {
+		return nil, err
+	}
+	for _, login := range input.Remove {
+		if strings.TrimSpace(login) == "" {
+			return nil, ToolInputError{fmt.Errorf("logins must not be empty")}
+		}
+		if strings.EqualFold(login, toolCtx.BotUser.GetLogin()) {
+			// Polling finds the bot's work by its assignment, so unassigning itself would orphan the issue
+			return nil, ToolInputError{fmt.Errorf("you cannot unassign yourself")}
+		}
+	}
+
+	var assignees []string
+	if len(input.Add) > 0 {
+		assignees, err = toolCtx.VCS.AddAssignees(ctx, issue.Owner, issue.Repo, issue.Number, input.Add)
+		if err != nil {
+			return nil, fmt.Errorf("failed to add assignees: %w", err)
+		}
+	}
+	if len(input.Remove) > 0 {
+		assignees, err = toolCtx.VCS.RemoveAssignees(ctx, issue.Owner, issue.Repo, issue.Number, input.Remove)
+		if err != nil {
+			return nil, fmt.Errorf("failed to remove assignees: %w", err)
+		}
+	}
+
+	result := formatAssignees(issue.Number, assignees)
+	return &result, nil
+}
+
+func (t *ManageAssigneesTool) Replay(ctx context.Context, block anthropic.ToolUseBlock, toolCtx *ToolContext) error {
+	// No side effects to replay
+	return nil
+}
+
+// checkAssignable returns a ToolInputError if any of the given users can't be assigned to issues in the task's
+// repository
+func checkAssignable(ctx context.Context, toolCtx *ToolContext, logins []string) error {
+	issue := toolCtx.Task.Issue
+	for _, login := range logins {
+		if strings.TrimSpace(login) == "" {
+			return ToolInputError{fmt.Errorf("logins must not be empty")}
+		}
+		isAssignee, _, err := toolCtx.GithubClient.Issues.IsAssignee(ctx, issue.Owner, issue.Repo, login)
+		if err != nil {
+			return fmt.Errorf("failed to check whether %s can be assigned: %w", login, err)
+		}
+		if !isAssignee {
+			return ToolInputError{fmt.Errorf("%s cannot be assigned to issues in %s/%s", login, issue.Owner, issue.Repo)}
+		}
+	}
+	return nil
+}
+
+// formatAssignees describes who is assigned to the given issue
+func formatAssignees(number int, logins []string) string {
+	if len(logins) == 0 {
+		return fmt.Sprintf("Issue #%d has no assignees", number)
+	}
+	return fmt.Sprintf("Issue #%d is assigned to: %s", number, strings.Join(logins, ", "))
+}
+
+type SubmitReviewTool struct {
+	BaseTool
+}
+
+// SubmitReviewInput represents the input for submit_review
+type SubmitReviewInput struct {
+	Event string `json:"event"`
+	Body  string `json:"body"`
+}
+
+// Review events accepted by submit_review
+const (
+	reviewEventApprove        = "APPROVE"
+	reviewEventRequestChanges = "REQUEST_CHANGES"
+	reviewEventComment        = "COMMENT"
+)
+
+// NewSubmitReviewTool creates a new submit review tool
+func NewSubmitReviewTool() *SubmitReviewTool {
+	return &SubmitReviewTool{
+		BaseTool: BaseTool{Name: "submit_review", Mutating: true},
+	}
+}
+
+// GetToolParam returns the tool parameter definition
+func (t *SubmitReviewTool) GetToolParam() anthropic.ToolParam {
+	return anthropic.ToolParam{
+		Name:        t.Name,
+		Description: anthropic.String("Submit a review of the pull request with a verdict. Pull requests that you opened can only be reviewed with COMMENT"),
+		InputSchema: anthropic.ToolInputSchemaParam{
+			Properties: map[string]any{
+				"event": map[string]any{
+					"type":        "string",
+					"enum":        []string{reviewEventApprove, reviewEventRequestChanges, reviewEventComment},
+					"description": "The review verdict",
+				},
+				"body": map[string]any{
+					"type":        "string",
+					"description": "The body of the review, in markdown",
+				},
+			},
+			Required: []string{"event", "body"},
+		},
+	}
+}
+
+// ParseToolUse parses the tool use block
+func (t *SubmitReviewTool) ParseToolUse(block anthropic.ToolUseBlock) (*SubmitReviewInput, error) {
+	if block.Name != t.Name {
+		return nil, fmt.Errorf("tool use block is for %s, not %s", block.Name, t.Name)
+	}
+
+	var input SubmitReviewInput
+	if err := parseInputJSON(block, &input); err != nil {
+		return nil, err
+	}
+	return &input, nil
+}
+
+// Run executes the submit review command
+func (t *SubmitReviewTool) Run(ctx context.Context, block anthropic.ToolUseBlock, toolCtx *ToolContext) (*string, error) {
+	input, err := t.ParseToolUse(block)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing input: %w", err)
+	}
+
+	pr := toolCtx.Task.PullRequest
+	if pr == nil {
+		return nil, ToolInputError{fmt.Errorf("there is no pull request to review")}
+	}
+
+	if err := validateReviewEvent(input.Event, input.Body, pr, toolCtx.BotUser); err != nil {
+		return nil, err
+	}
+
+	err = toolCtx.VCS.SubmitReview(ctx, pr.Owner, pr.Repo, pr.Number, vcs.ReviewEvent(input.Event), input.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to submit review: %w", err)
+	}
+
+	result := fmt.Sprintf("Submitted %s review on pull request #%d", input.Event, pr.Number)
+	return &result, nil
+}
+
+func (t *SubmitReviewTool) Replay(ctx context.Context, block anthropic.ToolUseBlock, toolCtx *ToolContext) error {
+	// No side effects to replay
+	return nil
+}
+
+// validateReviewEvent checks that a review of the given pull request with the given event and body may be submitted by
+// the bot. Returns a ToolInputError if not. Approving or requesting changes is refused unless the pull request is known
+// not to be the bot's own
+func validateReviewEvent(event string, body string, pr *task.GithubPullRequest, botUser *github.User) error {
+	switch event {
+	case reviewEventApprove, reviewEventRequestChanges:
+		if botUser.GetLogin() == "" || pr.Author == "" {
+			return ToolInputError{fmt.Errorf("cannot tell whether pull request #%d is your own, so only %s reviews are "+
+				"allowed", pr.Number, reviewEventComment)}
+		}
+		if strings.EqualFold(pr.Author, botUser.GetLogin()) {
+			return ToolInputError{fmt.Errorf("you cannot %s your own pull request, use %s instead", strings.ToLower(strings.ReplaceAll(event, "_", " ")), reviewEventComment)}
+		}
+	case reviewEventComment:
+	default:
+		return ToolInputError{fmt.Errorf("invalid event '%s', expected %s, %s, or %s", event, reviewEventApprove, reviewEventRequestChanges, reviewEventComment)}
+	}
+	if strings.TrimSpace(body) == "" && event != reviewEventApprove {
+		return ToolInputError{fmt.Errorf("a body is required for %s reviews", event)}
+	}
+	return nil
+}
+
+// Sides of a diff that a review comment can be attached to
+const (
+	diffSideLeft  = "LEFT"
+	diffSideRight = "RIGHT"
+)
+
+// CreateReviewWithCommentsTool implements the submit_review_with_comments tool
+type CreateReviewWithCommentsTool struct {
+	BaseTool
+}
+
+// ReviewLineComment is a review comment on a single line of a pull request's diff
+type ReviewLineComment struct {
+	Path string `json:"path"`
+	Line int    `json:"line"`
+	Side string `json:"side,omitempty"`
+	Body string `json:"body"`
+}
+
+// CreateReviewWithCommentsInput represents the input for submit_review_with_comments
+type CreateReviewWithCommentsInput struct {
+	Event    string              `json:"event"`
+	Body     string              `json:"body"`
+	Comments []ReviewLineComment `json:"comments"`
+}
+
+// NewCreateReviewWithCommentsTool creates a new submit review with comments tool
+func NewCreateReviewWithCommentsTool() *CreateReviewWithCommentsTool {
+	return &CreateReviewWithCommentsTool{
+		BaseTool: BaseTool{Name: "submit_review_with_comments", Mutating: true},
+	}
+}
+
+// GetToolParam returns the tool parameter definition
+func (t *CreateReviewWithCommentsTool) GetToolParam() anthropic.ToolParam {
+	return anthropic.ToolParam{
+		Name: t.Name,
+		Description: anthropic.String("Submit a review of the pull request with comments on specific lines of its diff, " +
+			"all at once. Prefer this over posting line comments one at a time. Every line must be part of the diff. " +
+			"Pull requests that you opened can only be reviewed with COMMENT"),
+		InputSchema: anthropic.ToolInputSchemaParam{
+			Properties: map[string]any{
+				"event": map[string]any{
+					"type":        "string",
+					"enum":        []string{reviewEventApprove, reviewEventRequestChanges, reviewEventComment},
+					"description": "The review verdict",
+				},
+				"body": map[string]any{
+					"type":        "string",
+					"description": "The overall body of the review, in markdown",
+				},
+				"comments": map[string]any{
+					"type": "array",
+					"items": map[string]any{
+						"type": "object",
+						"properties": map[string]any{
+							"path": map[string]any{
+								"type":        "string",
+								"description": "The path of the file to comment on, relative to the repository root",
+							},
+							"line": map[string]any{
+								"type":        "integer",
+								"description": "The line number to comment on, in the file version given by side",
+							},
+							"side": map[string]any{
+								"type": "string",
+								"enum": []string{diffSideLeft, diffSideRight},
+								"description": "RIGHT to comment on an added or unchanged line of the new file, LEFT to " +
+									"comment on a removed line of the old file. Defaults to RIGHT",
+							},
+							"body": map[string]any{
+								"type":        "string",
+								"description": "The comment, in markdown",
+							},
+						},
+						"required": []string{"path", "line", "body"},
+					},
+					"description": "The line comments to include in the review",
+				},
+			},
+			Required: []string{"event", "body", "comments"},
+		},
+	}
+}
+
+// ParseToolUse parses the tool use block
+func (t *CreateReviewWithCommentsTool) ParseToolUse(block anthropic.ToolUseBlock) (*CreateReviewWithCommentsInput, error) {
+	if block.Name != t.Name {
+		return nil, fmt.Errorf("tool use block is for %s, not %s", block.Name, t.Name)
+	}
+
+	var input CreateReviewWithCommentsInput
+	if err := parseInputJSON(block, &input); err != nil {
+		return nil, err
+	}
+	return &input, nil
+}
+
+// Run checks that every comment is on a line of the pull request's diff and submits the comments as a single review
+func (t *CreateReviewWithCommentsTool) Run(ctx context.Context, block anthropic.ToolUseBlock, toolCtx *ToolContext) (*string, error) {
+	input, err := t.ParseToolUse(block)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing input: %w", err)
+	}
+
+	pr := toolCtx.Task.PullRequest
+	if pr == nil {
+		return nil, ToolInputError{fmt.Errorf("there is no pull request to review")}
+	}
+	if err := validateReviewEvent(input.Event, input.Body, pr, toolCtx.BotUser); err != nil {
+		return nil, err
+	}
+	if len(input.Comments) == 0 {
+		return nil, ToolInputError{fmt.Errorf("at least one comment is required, use submit_review for a review without line comments")}
+	}
+
+	diffLines, err := pullRequestDiffLines(ctx, toolCtx.GithubClient, pr)
+	if err != nil {
+		return nil, err
+	}
+
+	var problems []string
+	comments := make([]vcs.LineComment, 0, len(input.Comments))
+	for i, c := range input.Comments {
+		side := strings.ToUpper(c.Side)
+		if side == "" {
+			side = diffSideRight
+		}
+		switch {
+		case strings.TrimSpace(c.Body) == "":
+			problems = append(problems, fmt.Sprintf("comment %d has no body", i+1))
+		case side != diffSideLeft && side != diffSideRight:
+			problems = append(problems, fmt.Sprintf("comment %d has invalid side '%s', expected %s or %s", i+1, c.Side, diffSideLeft, diffSideRight))
+		case !diffLines[diffLineKey{path: c.Path, side: side, line: c.Line}]:
+			problems = append(problems, fmt.Sprintf("comment %d: line %d (%s) of %s is not part of the diff", i+1, c.Line, side, c.Path))
+		}
+		comments = append(comments, vcs.LineComment{Path: c.Path, Line: c.Line, Side: side, Body: c.Body})
+	}
+	if len(problems) > 0 {
+		return nil, ToolInputError{fmt.Errorf("no review was submitted:\n%s\nUse view_pull_request_diff to see which lines can be commented on", strings.Join(problems, "\n"))}
+	}
+
+	err = toolCtx.VCS.SubmitReviewWithComments(ctx, pr.Owner, pr.Repo, pr.Number, vcs.ReviewEvent(input.Event), input.Body, comments)
+	if err != nil {
+		return nil, fmt.Errorf("failed to submit review: %w", err)
+	}
+
+	result := fmt.Sprintf("Submitted %s review with %d line comments on pull request #%d", input.Event, len(comments), pr.Number)
+	return &result, nil
+}
+
+func (t *CreateReviewWithCommentsTool) Replay(ctx context.Context, block anthropic.ToolUseBlock, toolCtx *ToolContext) error {
+	// No side effects to replay
+	return nil
+}
+
+// diffLineKey identifies a line of a pull request's diff that can be commented on
+type diffLineKey struct {
+	path string
+	side string
+	line int
+}
+
+// pullRequestDiffLines returns the set of lines of the given pull request's diff that review comments can be attached
+// to: added and context lines on the right side, and removed and context lines on the left side
+func pullRequestDiffLines(ctx context.Context, client *github.Client, pr *task.GithubPullRequest) (map[diffLineKey]bool, error) {
+	lines := map[diffLineKey]bool{}
+	opts := &github.ListOptions{PerPage: 100}
+	for {
+		files, resp, err := client.PullRequests.ListFiles(ctx, pr.Owner, pr.Repo, pr.Number, opts)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list pull request files: %w", err)
+		}
+		for _, file := range files {
+			if file.GetPatch() == "" {
+				// Binary or too large to diff
+				continue
+			}
+			path := file.GetFilename()
+			diff, err := parseUnifiedDiff(fmt.Sprintf("--- a/%s\n+++ b/%s\n%s", path, path, file.GetPatch()))
+			if err != nil {
+				return nil, fmt.Errorf("failed to parse diff of %s: %w", path, err)
+			}
+			for _, hunk := range diff.hunks {
+				oldLine, newLine := hunk.oldStart, hunk.newStart
+				for _, l := range hunk.lines {
+					switch l.op {
+					case ' ':
+						lines[diffLineKey{path, diffSideLeft, oldLine}] = true
+						lines[diffLineKey{path, diffSideRight, newLine}] = true
+						oldLine++
+						newLine++
+					case '-':
+						lines[diffLineKey{path, diffSideLeft, oldLine}] = true
+						oldLine++
+					case '+':
+						lines[diffLineKey{path, diffSideRight, newLine}] = true
+						newLine++
+					}
+				}
+			}
+		}
+		if resp.NextPage == 0 {
+			break
+		}
+		opts.Page = resp.NextPage
+	}
+	return lines, nil
+}
+
+// RequestReviewersTool implements the request_reviewers tool
+type RequestReviewersTool struct {
+	BaseTool
+}
+
+// RequestReviewersInput represents the input for request_reviewers
+type RequestReviewersInput struct {
+	Reviewers     []string `json:"reviewers,omitempty"`
+	TeamReviewers []string `json:"team_reviewers,omitempty"`
+}
+
+// NewRequestReviewersTool creates a new request reviewers tool
+func NewRequestReviewersTool() *RequestReviewersTool {
+	return &RequestReviewersTool{
+		BaseTool: BaseTool{Name: "request_reviewers", Mutating: true},
+	}
+}
+
+// GetToolParam returns the tool parameter definition
+func (t *RequestReviewersTool) GetToolParam() anthropic.ToolParam {
+	return anthropic.ToolParam{
+		Name: t.Name,
+		Description: anthropic.String("Request reviews of your pull request from users or teams with access to the " +
+			"repository, e.g. ones named in the issue or the owners of the code you changed. Lists everyone whose review " +
+			"is requested afterwards"),
+		InputSchema: anthropic.ToolInputSchemaParam{
+			Properties: map[string]any{
+				"reviewers": map[string]any{
+					"type":        "array",
+					"items":       map[string]any{"type": "string"},
+					"description": "The logins of users to request reviews from. They must be collaborators on the repository",
+				},
+				"team_reviewers": map[string]any{
+					"type":        "array",
+					"items":       map[string]any{"type": "string"},
+					"description": "The slugs of teams in the repository's organization to request reviews from",
+				},
+			},
+		},
+	}
+}
+
+// ParseToolUse parses the tool use block
+func (t *RequestReviewersTool) ParseToolUse(block anthropic.ToolUseBlock) (*RequestReviewersInput, error) {
+	if block.Name != t.Name {
+		return nil, fmt.Errorf("tool use block is for %s, not %s", block.Name, t.Name)
+	}
+
+	var input RequestReviewersInput
+	if err := parseInputJSON(block, &input); err != nil {
+		return nil, err
+	}
+	return &input, nil
+}
+
+// Run checks that the reviewers have access to the repository and requests their reviews
+func (t *RequestReviewersTool) Run(ctx context.Context, block anthropic.ToolUseBlock, toolCtx *ToolContext) (*string, error) {
+	input, err := t.ParseToolUse(block)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing input: %w", err)
+	}
+
+	pr := toolCtx.Task.PullRequest
+	if pr == nil {
+		return nil, ToolInputError{fmt.Errorf("there is no pull request yet, publish your changes first")}
+	}
+	if len(input.Reviewers) == 0 && len(input.TeamReviewers) == 0 {
+		return nil, ToolInputError{fmt.Errorf("at least one reviewer or team reviewer is required")}
+	}
+
+	for _, login := range input.Reviewers {
+		if strings.EqualFold(login, pr.Author) {
+			return nil, ToolInputError{fmt.Errorf("%s opened the pull request and cannot review it", login)}
+		}
+		isCollaborator, _, err := toolCtx.GithubClient.Repositories.IsCollaborator(ctx, pr.Owner, pr.Repo, login)
+		if err != nil {
+			return nil, fmt.Errorf("failed to check whether %s is a collaborator: %w", login, err)
+		}
+		if !isCollaborator {
+			return nil, ToolInputError{fmt.Errorf("%s is not a collaborator on %s/%s", login, pr.Owner, pr.Repo)}
+		}
+	}
+	for _, slug := range input.TeamReviewers {
+		_, resp, err := toolCtx.GithubClient.Teams.IsTeamRepoBySlug(ctx, pr.Owner, slug, pr.Owner, pr.Repo)
+		if resp != nil && resp.StatusCode == http.StatusNotFound {
+			return nil, ToolInputError{fmt.Errorf("there is no team %s with access to %s/%s", slug, pr.Owner, pr.Repo)}
+		} else if err != nil {
+			return nil, fmt.Errorf("failed to check whether team %s has access: %w", slug, err)
+		}
+	}
+
+	updated, err := toolCtx.VCS.RequestReviewers(ctx, pr.Owner, pr.Repo, pr.Number, vcs.Reviewers{
+		Users: input.Reviewers,
+		Teams: input.TeamReviewers,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to request reviewers: %w", err)
+	}
+
+	requested := slices.Clone(updated.Users)
+	for _, slug := range updated.Teams {
+		requested = append(requested, "team "+slug)
+	}
+	result := fmt.Sprintf("Requested reviews on pull request #%d. Reviews are now requested from: %s",
+		pr.Number, strings.Join(requested, ", "))
+	return &result, nil
+}
+
+func (t *RequestReviewersTool) Replay(ctx context.Context, block anthropic.ToolUseBlock, toolCtx *ToolContext) error {
+	// No side effects to replay
+	return nil
+}
+
+// CloseIssueTool implements the close_issue tool
+type CloseIssueTool struct {
+	BaseTool
+}
+
+// CloseIssueInput represents the input for close_issue
+type CloseIssueInput struct {
+	Comment     string `json:"comment"`
+	StateReason string `json:"state_reason,omitempty"`
+}
+
+// NewCloseIssueTool creates a new close issue tool
+func NewCloseIssueTool() *CloseIssueTool {
+	return &CloseIssueTool{
+		BaseTool: BaseTool{Name: "close_issue", Mutating: true},
+	}
+}
+
+// GetToolParam returns the tool parameter definition
+func (t *CloseIssueTool) GetToolParam() anthropic.ToolParam {
+	return anthropic.ToolParam{
+		Name: t.Name,
+		Description: anthropic.String("Close the issue with a comment explaining why, e.g. because it is a duplicate, is already fixed, " +
+			"or won't be addressed. Don't close an issue that your own changes are meant to fix; merging the pull request closes it"),
+		InputSchema: anthropic.ToolInputSchemaParam{
+			Properties: map[string]any{
+				"comment": map[string]any{
+					"type":        "string",
+					"description": "A comment explaining why the issue is being closed, posted before the issue is closed",
+				},
+				"state_reason": map[string]any{
+					"type":        "string",
+					"enum":        []string{vcs.CloseReasonCompleted, vcs.CloseReasonNotPlanned},
+					"description": "completed if the issue has been resolved, not_planned if it is a duplicate or won't be addressed. Defaults to completed",
+				},
+			},
+			Required: []string{"comment"},
+		},
+	}
+}
+
+// ParseToolUse parses the tool use block
+func (t *CloseIssueTool) ParseToolUse(block anthropic.ToolUseBlock) (*CloseIssueInput, error) {
+	if block.Name != t.Name {
+		return nil, fmt.Errorf("tool use block is for %s, not %s", block.Name, t.Name)
+	}
+
+	var input CloseIssueInput
+	if err := parseInputJSON(block, &input); err != nil {
+		return nil, err
+	}
+	return &input, nil
+}
+
+// Run posts the comment and closes the issue
+func (t *CloseIssueTool) Run(ctx context.Context, block anthropic.ToolUseBlock, toolCtx *ToolContext) (*string, error) {
+	input, err := t.ParseToolUse(block)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing input: %w", err)
+	}
+
+	switch input.StateReason {
+	case "":
+		input.StateReason = vcs.CloseReasonCompleted
+	case vcs.CloseReasonCompleted, vcs.CloseReasonNotPlanned:
+	default:
+		return nil, ToolInputError{fmt.Errorf("invalid state_reason '%s', expected %s or %s", input.StateReason, vcs.CloseReasonCompleted, vcs.CloseReasonNotPlanned)}
+	}
+
+	err = setIssueState(ctx, toolCtx, input.Comment, vcs.IssueClosed, input.StateReason)
+	if err != nil {
+		return nil, err
+	}
+
+	result := fmt.Sprintf("Closed issue #%d as %s", toolCtx.Task.Issue.Number, input.StateReason)
+	return &result, nil
+}
+
+func (t *CloseIssueTool) Replay(ctx context.Context, block anthropic.ToolUseBlock, toolCtx *ToolContext) error {
+	// No side effects to replay
+	return nil
+}
+
+// ReopenIssueTool implements the reopen_issue tool
+type ReopenIssueTool struct {
+	BaseTool
+}
+
+// ReopenIssueInput represents the input for reopen_issue
+type ReopenIssueInput struct {
+	Comment string `json:"comment"`
+}
+
+// NewReopenIssueTool creates a new reopen issue tool
+func NewReopenIssueTool() *ReopenIssueTool {
+	return &ReopenIssueTool{
+		BaseTool: BaseTool{Name: "reopen_issue", Mutating: true},
+	}
+}
+
+// GetToolParam returns the tool parameter definition
+func (t *ReopenIssueTool) GetToolParam() anthropic.ToolParam {
+	return anthropic.ToolParam{
+		Name:        t.Name,
+		Description: anthropic.String("Reopen the issue, e.g. if it was closed by mistake, with a comment explaining why"),
+		InputSchema: anthropic.ToolInputSchemaParam{
+			Properties: map[string]any{
+				"comment": map[string]any{
+					"type":        "string",
+					"description": "A comment explaining why the issue is being reopened, posted before the issue is reopened",
+				},
+			},
+			Required: []string{"comment"},
+		},
+	}
+}
+
+// ParseToolUse parses the tool use block
+func (t *ReopenIssueTool) ParseToolUse(block anthropic.ToolUseBlock) (*ReopenIssueInput, error) {
+	if block.Name != t.Name {
+		return nil, fmt.Errorf("tool use block is for %s, not %s", block.Name, t.Name)
+	}
+
+	var input ReopenIssueInput
+	if err := parseInputJSON(block, &input); err != nil {
+		return nil, err
+	}
+	return &input, nil
+}
+
+// Run posts the comment and reopens the issue
+func (t *ReopenIssueTool) Run(ctx context.Context, block anthropic.ToolUseBlock, toolCtx *ToolContext) (*string, error) {
+	input, err := t.ParseToolUse(block)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing input: %w", err)
+	}
+
+	err = setIssueState(ctx, toolCtx, input.Comment, vcs.IssueOpen, "")
+	if err != nil {
+		return nil, err
+	}
+
+	result := fmt.Sprintf("Reopened issue #%d", toolCtx.Task.Issue.Number)
+	return &result, nil
+}
+
+func (t *ReopenIssueTool) Replay(ctx context.Context, block anthropic.ToolUseBlock, toolCtx *ToolContext) error {
+	// No side effects to replay
+	return nil
+}
+
+// maxOpenItems caps the number of issues and pull requests listed by list_open_items
+const maxOpenItems = 30
+
+// ListOpenItemsTool implements the list_open_items tool
+type ListOpenItemsTool struct {
+	BaseTool
+}
+
+// ListOpenItemsInput represents the input for list_open_items
+type ListOpenItemsInput struct {
+	Type  string `json:"type,omitempty"`
+	Label string `json:"label,omitempty"`
+	Text  string `json:"text,omitempty"`
+}
+
+// NewListOpenItemsTool creates a new list open items tool
+func NewListOpenItemsTool() *ListOpenItemsTool {
+	return &ListOpenItemsTool{
+		BaseTool: BaseTool{Name: "list_open_items"},
+	}
+}
+
+// GetToolParam returns the tool parameter definition
+func (t *ListOpenItemsTool) GetToolParam() anthropic.ToolParam {
+	return anthropic.ToolParam{
+		Name: t.Name,
+		Description: anthropic.String("List the open issues and pull requests in the repository, most recently updated " +
+			"first, with their numbers, titles, and URLs. Use this to check whether an issue duplicates or overlaps with " +
+			fmt.Sprintf("other open work. Lists at most %d items, so filter by label or text to narrow the results", maxOpenItems)),
+		InputSchema: anthropic.ToolInputSchemaParam{
+			Properties: map[string]any{
+				"type": map[string]any{
+					"type":        "string",
+					"enum":        []string{"issue", "pr"},
+					"description": "List only issues or only pull requests. Defaults to both",
+				},
+				"label": map[string]any{
+					"type":        "string",
+					"description": "List only items with this label",
+				},
+				"text": map[string]any{
+					"type":        "string",
+					"description": "List only items whose title or body contains these words",
+				},
+			},
+		},
+	}
+}
+
+// ParseToolUse parses the tool use block
+func (t *ListOpenItemsTool) ParseToolUse(block anthropic.ToolUseBlock) (*ListOpenItemsInput, error) {
+	if block.Name != t.Name {
+		return nil, fmt.Errorf("tool use block is for %s, not %s", block.Name, t.Name)
+	}
+
+	var input ListOpenItemsInput
+	if err := parseInputJSON(block, &input); err != nil {
+		return nil, err
+	}
+	return &input, nil
+}
+
+// Run searches for open issues and pull requests matching the filters
+func (t *ListOpenItemsTool) Run(ctx context.Context, block anthropic.ToolUseBlock, toolCtx *ToolContext) (*string, error) {
+	input, err := t.ParseToolUse(block)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing input: %w", err)
+	}
+
+	issue := toolCtx.Task.Issue
+	query := "is:open"
+	switch input.Type {
+	case "":
+		// Both issues and pull requests
+	case "issue", "pr":
+		query += " is:" + input.Type
+	default:
+		return nil, ToolInputError{fmt.Errorf("invalid type '%s', expected issue or pr", input.Type)}
+	}
+	if label := strings.TrimSpace(input.Label); label != "" {
+		if strings.Contains(label, `"`) {
+			return nil, ToolInputError{fmt.Errorf("label must not contain double quotes")}
+		}
+		query += fmt.Sprintf(` label:"%s"`, label)
+	}
+	if words := searchTerms(input.Text); len(words) > 0 {
+		query += " " + strings.Join(words, " ") + " in:title,body"
+	}
+	// The repository comes last so that nothing before it can widen the search to other repositories
+	query += fmt.Sprintf(" repo:%s/%s", issue.Owner, issue.Repo)
+
+	results, _, err := toolCtx.GithubClient.Search.Issues(ctx, query, &github.SearchOptions{
+		Sort:        "updated",
+		Order:       "desc",
+		ListOptions: github.ListOptions{PerPage: maxOpenItems},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to search issues: %w", err)
+	}
+	items := results.Issues
+	if len(items) > maxOpenItems {
+		items = items[:maxOpenItems]
+	}
+	if len(items) == 0 {
+		result := "No open issues or pull requests match"
+		return &result, nil
+	}
+
+	var sb strings.Builder
+	total := max(results.GetTotal(), len(items))
+	if total > len(items) {
+		fmt.Fprintf(&sb, "%d open items match, showing the %d most recently updated. Filter by label or text to see others:\n",
+			total, len(items))
+	} else {
+		fmt.Fprintf(&sb, "%d open items match:\n", len(items))
+	}
+	for _, item := range items {
+		kind := "issue"
+		if item.IsPullRequest() {
+			kind = "pull request"
+		}
+		if item.GetNumber() == issue.Number {
+			kind += ", this issue"
+		}
+		fmt.Fprintf(&sb, "\n#%d (%s) %s %s", item.GetNumber(), kind, item.GetTitle(), item.GetHTMLURL())
+	}
+	result := sb.String()
+	return &result, nil
+}
+
+// searchTerms splits free text into quoted words for a GitHub search query, so that words like "repo:other/repo" or "OR"
+// are matched as text rather than interpreted as qualifiers or operators
+func searchTerms(text string) []string {
+	var terms []string
+	for word := range strings.FieldsSeq(strings.ReplaceAll(text, `"`, " ")) {
+		terms = append(terms, `"`+word+`"`)
+	}
+	return terms
+}
+
+func (t *ListOpenItemsTool) Replay(ctx context.Context, block anthropic.ToolUseBlock, toolCtx *ToolContext) error {
+	// No side effects to replay
+	return nil
+}
+
+// maxTimelineEvents caps the number of events shown by get_issue_timeline. The most recent events are kept
+const maxTimelineEvents = 100
+
+// GetIssueTimelineTool implements the get_issue_timeline tool
+type GetIssueTimelineTool struct {
+	BaseTool
+}
+
+// NewGetIssueTimelineTool creates a new get issue timeline tool
+func NewGetIssueTimelineTool() *GetIssueTimelineTool {
+	return &GetIssueTimelineTool{
+		BaseTool: BaseTool{Name: "get_issue_timeline"},
+	}
+}
+
+// GetToolParam returns the tool parameter definition
+func (t *GetIssueTimelineTool) GetToolParam() anthropic.ToolParam {
+	return anthropic.ToolParam{
+		Name: t.Name,
+		Description: anthropic.String("View the timeline of events on this issue, e.g. who assigned it and when, " +
+			"labels added and removed, renames, and references from other issues, pull requests, and commits. " +
+			"Comments are not included, since you already have them"),
+		InputSchema: anthropic.ToolInputSchemaParam{
+			Properties: map[string]any{},
+		},
+	}
+}
+
+// Run executes the get issue timeline command
+func (t *GetIssueTimelineTool) Run(ctx context.Context, block anthropic.ToolUseBlock, toolCtx *ToolContext) (*string, error) {
+	if block.Name != t.Name {
+		return nil, fmt.Errorf("tool use block is for %s, not %s", block.Name, t.Name)
+	}
+
+	issue := toolCtx.Task.Issue
+	listPage := func(page int) ([]*github.Timeline, *github.Response, error) {
+		opts := &github.ListOptions{Page: page, PerPage: 100}
+		events, resp, err := toolCtx.GithubClient.Issues.ListIssueTimeline(ctx, issue.Owner, issue.Repo, issue.Number, opts)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to list timeline events: %w", err)
+		}
+		// Comments are already in the prompt
+		return slices.DeleteFunc(events, func(event *github.Timeline) bool { return event.GetEvent() == "commented" }),
+			resp, nil
+	}
+
+	// The first page tells how many pages there are. Only the most recent events are shown, so fetch pages from the last
+	// backwards until there are enough of them, rather than paging through the whole timeline of a busy issue
+	firstPage, resp, err := listPage(1)
+	if err != nil {
+		return nil, err
+	}
+	var events []*github.Timeline
+	pageNum := resp.LastPage
+	for ; pageNum > 1 && len(events) < maxTimelineEvents; pageNum-- {
+		page, _, err := listPage(pageNum)
+		if err != nil {
+			return nil, err
+		}
+		events = append(page, events...)
+	}
+	complete := pageNum <= 1
+	if complete {
+		events = append(firstPage, events...)
+	}
+
+	result := formatTimeline(issue.Number, events, complete)
+	return &result, nil
+}
+
+func (t *GetIssueTimelineTool) Replay(ctx context.Context, block anthropic.ToolUseBlock, toolCtx *ToolContext) error {
+	// No side effects to replay
+	return nil
+}
+
+// formatTimeline formats the timeline events of an issue for the AI, one per line, keeping only the most recent
+// maxTimelineEvents events. complete is false if earlier events weren't fetched at all
+func formatTimeline(issueNumber int, events []*github.Timeline, complete bool) string {
+	if len(events) == 0 {
+		return fmt.Sprintf("There are no events on the timeline of issue #%d", issueNumber)
+	}
+
+	var sb strings.Builder
+	omitted := max(len(events)-maxTimelineEvents, 0)
+	events = events[omitted:]
+	if !complete {
+		fmt.Fprintf(&sb, "Timeline of issue #%d, omitting earlier events:\n", issueNumber)
+	} else if omitted > 0 {
+		fmt.Fprintf(&sb, "Timeline of issue #%d, omitting the %d earliest events:\n", issueNumber, omitted)
+	} else {
+		fmt.Fprintf(&sb, "Timeline of issue #%d:\n", issueNumber)
+	}
+	for _, event := range events {
+		sb.WriteString("- ")
+		if event.CreatedAt != nil {
+			sb.WriteString(event.CreatedAt.Format("2006-01-02 15:04") + " ")
+		}
+		sb.WriteString(describeTimelineEvent(event))
+		sb.WriteString("\n")
+	}
+	return sb.String()
+}
+
+// describeTimelineEvent summarizes a single timeline event
+func describeTimelineEvent(event *github.Timeline) string {
+	actor := "@" + event.GetActor().GetLogin()
+	if event.GetActor().GetLogin() == "" {
+		actor = "someone"
+	}
+
+	switch event.GetEvent() {
+	case "assigned":
+		return fmt.Sprintf("%s assigned @%s", actor, event.GetAssignee().GetLogin())
+	case "unassigned":
+		return fmt.Sprintf("%s unassigned @%s", actor, event.GetAssignee().GetLogin())
+	case "labeled":
+		return fmt.Sprintf("%s added the label '%s'", actor, event.GetLabel().GetName())
+	case "unlabeled":
+		return fmt.Sprintf("%s removed the label '%s'", actor, event.GetLabel().GetName())
+	case "renamed":
+		return fmt.Sprintf("%s renamed the issue from '%s' to '%s'", actor, event.GetRename().GetFrom(), event.GetRename().GetTo())
+	case "milestoned":
+		return fmt.Sprintf("%s added the issue to milestone '%s'", actor, event.GetMilestone().GetTitle())
+	case "demilestoned":
+		return fmt.Sprintf("%s removed the issue from milestone '%s'", actor, event.GetMilestone().GetTitle())
+	case "cross-referenced":
+		source := event.GetSource().GetIssue()
+		kind := "issue"
+		if source.IsPullRequest() {
+			kind = "pull request"
+		}
+		return fmt.Sprintf("%s referenced this issue from %s %s#%d '%s' (%s)", actor, kind,
+			source.GetRepository().GetFullName(), source.GetNumber(), source.GetTitle(), source.GetState())
+	case "referenced":
+		return fmt.Sprintf("%s referenced this issue in commit %s", actor, shortSHA(event.GetCommitID()))
+	case "closed":
+		if event.GetCommitID() != "" {
+			return fmt.Sprintf("%s closed the issue with commit %s", actor, shortSHA(event.GetCommitID()))
+		}
+		return fmt.Sprintf("%s closed the issue", actor)
+	case "reopened":
+		return fmt.Sprintf("%s reopened the issue", actor)
+	default:
+		return fmt.Sprintf("%s by %s", strings.ReplaceAll(event.GetEvent(), "_", " "), actor)
+	}
+}
+
+// shortSHA abbreviates a commit SHA for display
+func shortSHA(sha string) string {
+	if len(sha) > 12 {
+		return sha[:12]
+	}
+	return sha
+}
+
+// LinkIssueTool implements the link_issue tool
+type LinkIssueTool struct {
+	BaseTool
+}
+
+// LinkIssueInput represents the input for link_issue
+type LinkIssueInput struct {
+	Number       int    `json:"number"`
+	Relationship string `json:"relationship,omitempty"`
+	Comment      string `json:"comment,omitempty"`
+}
+
+// issueRelationshipPhrases maps the relationships that link_issue supports to the phrases that introduce the
+// reference. GitHub recognizes "Duplicate of #N" and marks the issue as a duplicate
+var issueRelationshipPhrases = map[string]string{
+	"related":      "Related to",
+	"duplicate_of": "Duplicate of",
+	"blocked_by":   "Blocked by",
+	"blocks":       "Blocks",
+}
+
+// NewLinkIssueTool creates a new link issue tool
+func NewLinkIssueTool() *LinkIssueTool {
+	return &LinkIssueTool{
+		BaseTool: BaseTool{Name: "link_issue", Mutating: true},
+	}
+}
+
+// GetToolParam returns the tool parameter definition
+func (t *LinkIssueTool) GetToolParam() anthropic.ToolParam {
+	return anthropic.ToolParam{
+		Name: t.Name,
+		Description: anthropic.String("Post a comment on the issue referencing another issue or pull request in the same repository, " +
+			"which GitHub shows as a cross-reference on both. Use this when you find a related, duplicate, or blocking issue"),
+		InputSchema: anthropic.ToolInputSchemaParam{
+			Properties: map[string]any{
+				"number": map[string]any{
+					"type":        "integer",
+					"description": "The number of the issue or pull request to reference",
+				},
+				"relationship": map[string]any{
+					"type":        "string",
+					"enum":        slices.Sorted(maps.Keys(issueRelationshipPhrases)),
+					"description": "How this issue relates to the referenced one. Defaults to related",
+				},
+				"comment": map[string]any{
+					"type":        "string",
+					"description": "An optional explanation of the relationship, posted after the reference",
+				},
+			},
+			Required: []string{"number"},
+		},
+	}
+}
+
+// ParseToolUse parses the tool use block
+func (t *LinkIssueTool) ParseToolUse(block anthropic.ToolUseBlock) (*LinkIssueInput, error) {
+	if block.Name != t.Name {
+		return nil, fmt.Errorf("tool use block is for %s, not %s", block.Name, t.Name)
+	}
+
+	var input LinkIssueInput
+	if err := parseInputJSON(block, &input); err != nil {
+		return nil, err
+	}
+	return &input, nil
+}
+
+// Run checks that the referenced issue exists and posts a comment referencing it
+func (t *LinkIssueTool) Run(ctx context.Context, block anthropic.ToolUseBlock, toolCtx *ToolContext) (*string, error) {
+	input, err := t.ParseToolUse(block)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing input: %w", err)
+	}
+
+	issue := toolCtx.Task.Issue
+	if input.Number <= 0 {
+		return nil, ToolInputError{fmt.Errorf("number must be positive")}
+	}
+	if input.Number == issue.Number {
+		return nil, ToolInputError{fmt.Errorf("cannot link issue #%d to itself", issue.Number)}
+	}
+	if input.Relationship == "" {
+		input.Relationship = "related"
+	}
+	phrase, ok := issueRelationshipPhrases[input.Relationship]
+	if !ok {
+		return nil, ToolInputError{fmt.Errorf("invalid relationship '%s', expected one of %s",
+			input.Relationship, strings.Join(slices.Sorted(maps.Keys(issueRelationshipPhrases)), ", "))}
+	}
+
+	_, resp, err := toolCtx.GithubClient.Issues.Get(ctx, issue.Owner, issue.Repo, input.Number)
+	if resp != nil && resp.StatusCode == http.StatusNotFound {
+		return nil, ToolInputError{fmt.Errorf("there is no issue or pull request #%d in %s/%s", input.Number, issue.Owner, issue.Repo)}
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to get issue #%d: %w", input.Number, err)
+	}
+
+	body := fmt.Sprintf("%s #%d", phrase, input.Number)
+	if comment := strings.TrimSpace(input.Comment); comment != "" {
+		body += "\n\n" + comment
+	}
+	if err := toolCtx.VCS.CreateComment(ctx, issue.Owner, issue.Repo, issue.Number, body); err != nil {
+		return nil, fmt.Errorf("failed to post comment: %w", err)
+	}
+
+	result := fmt.Sprintf("Posted '%s #%d' on issue #%d", phrase, input.Number, issue.Number)
+	return &result, nil
+}
+
+func (t *LinkIssueTool) Replay(ctx context.Context, block anthropic.ToolUseBlock, toolCtx *ToolContext) error {
+	// No side effects to replay
+	return nil
+}
+
+// maxIssuesPerTask caps the number of issues that the AI may open while working on a single task
+const maxIssuesPerTask = 3
+
+// CreateIssueTool implements the create_issue tool
+type CreateIssueTool struct {
+	BaseTool
+}
+
+// CreateIssueInput represents the input for create_issue
+type CreateIssueInput struct {
+	Title  string   `json:"title"`
+	Body   string   `json:"body"`
+	Labels []string `json:"labels,omitempty"`
+}
+
+// NewCreateIssueTool creates a new create issue tool
+func NewCreateIssueTool() *CreateIssueTool {
+	return &CreateIssueTool{
+		BaseTool: BaseTool{Name: "create_issue", Mutating: true},
+	}
+}
+
+// GetToolParam returns the tool parameter definition
+func (t *CreateIssueTool) GetToolParam() anthropic.ToolParam {
+	return anthropic.ToolParam{
+		Name: t.Name,
+		Description: anthropic.String(fmt.Sprintf("Open a new issue in the repository, e.g. to track follow-up work or a problem "+
+			"you found that is out of scope for the current task. At most %d issues may be opened per task", maxIssuesPerTask)),
+		InputSchema: anthropic.ToolInputSchemaParam{
+			Properties: map[string]any{
+				"title": map[string]any{
+					"type":        "string",
+					"description": "The title of the new issue",
+				},
+				"body": map[string]any{
+					"type":        "string",
+					"description": "A description of the problem or work, with enough context for someone unfamiliar with the current task",
+				},
+				"labels": map[string]any{
+					"type":        "array",
+					"items":       map[string]any{"type": "string"},
+					"description": "Labels to add to the new issue",
+				},
+			},
+			Required: []string{"title", "body"},
+		},
+	}
+}
+
+// ParseToolUse parses the tool use block
+func (t *CreateIssueTool) ParseToolUse(block anthropic.ToolUseBlock) (*CreateIssueInput, error) {
+	if block.Name != t.Name {
+		return nil, fmt.Errorf("tool use block is for %s, not %s", block.Name, t.Name)
+	}
+
+	var input CreateIssueInput
+	if err := parseInputJSON(block, &input); err != nil {
+		return nil, err
+	}
+	return &input, nil
+}
+
+// Run opens the issue and returns its URL
+func (t *CreateIssueTool) Run(ctx context.Context, block anthropic.ToolUseBlock, toolCtx *ToolContext) (*string, error) {
+	input, err := t.ParseToolUse(block)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing input: %w", err)
+	}
+
+	if strings.TrimSpace(input.Title) == "" {
+		return nil, ToolInputError{fmt.Errorf("title is required")}
+	}
+	if strings.TrimSpace(input.Body) == "" {
+		return nil, ToolInputError{fmt.Errorf("body is required")}
+	}
+	if toolCtx.IssuesCreated >= maxIssuesPerTask {
+		return nil, ToolInputError{fmt.Errorf("you have already opened %d issues for this task, which is the limit. "+
+			"Mention any further problems in a comment instead", maxIssuesPerTask)}
+	}
+
+	owner, repo := toolCtx.Task.Issue.Owner, toolCtx.Task.Issue.Repo
+	number, url, err := toolCtx.VCS.CreateIssue(ctx, owner, repo, vcs.NewIssue{Title: input.Title, Body: input.Body, Labels: input.Labels})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create issue: %w", err)
+	}
+	toolCtx.IssuesCreated++
+
+	result := fmt.Sprintf("Opened issue #%d: %s", number, url)
+	return &result, nil
+}
+
+func (t *CreateIssueTool) Replay(ctx context.Context, block anthropic.ToolUseBlock, toolCtx *ToolContext) error {
+	// No side effects to replay, since the issue persists remotely. Count it toward the cap, though
+	toolCtx.IssuesCreated++
+	return nil
+}
+
+// setIssueState posts the given comment on the task's issue and then changes the issue's state. The comment is
+// required, so that there is always a rationale on the issue's timeline
+func setIssueState(ctx context.Context, toolCtx *ToolContext, comment string, state vcs.IssueState, reason string) error {
+	if strings.TrimSpace(comment) == "" {
+		return ToolInputError{fmt.Errorf("comment is required")}
+	}
+
+	issue := toolCtx.Task.Issue
+	if err := toolCtx.VCS.CreateComment(ctx, issue.Owner, issue.Repo, issue.Number, comment); err != nil {
+		return fmt.Errorf("failed to post comment: %w", err)
+	}
+	if err := toolCtx.VCS.SetIssueState(ctx, issue.Owner, issue.Repo, issue.Number, state, reason); err != nil {
+		return fmt.Errorf("failed to set issue state to %s: %w", state, err)
+	}
+	return nil
+}
+
+type PublishChangesForReviewTool struct {
+	BaseTool
+}
+
+type PublishChangesForReviewInput struct {
+	PullRequestTitle string `json:"pull_request_title"`
+	PullRequestBody  string `json:"pull_request_body"`
+	Draft            bool   `json:"draft,omitempty"`
+}
+
+func NewPublishChangesForReviewTool() *PublishChangesForReviewTool {
+	return &PublishChangesForReviewTool{
+		BaseTool: BaseTool{Name: "publish_changes_for_review", Mutating: true},
+	}
+}
+
+// GetToolParam returns the tool parameter definition
+func (t *PublishChangesForReviewTool) GetToolParam() anthropic.ToolParam {
+	return anthropic.ToolParam{
+		Name:        t.Name,
+		Description: anthropic.String("Publish changes for review by other developers"),
+		InputSchema: anthropic.ToolInputSchemaParam{
+			Properties: map[string]any{
+				"pull_request_title": map[string]any{
+					"type":        "string",
+					"description": "Title for the new pull request, if any. Ignored if a pull request already exists",
+				},
+				"pull_request_body": map[string]any{
+					"type":        "string",
+					"description": "Description of the solution and what changes were made. Ignored if a pull request already exists",
+				},
+				"draft": map[string]any{
+					"type": "boolean",
+					"description": "Whether to open the new pull request as a draft, e.g. until validation passes. Use " +
+						"mark_pull_request_ready to take it out of draft later. Defaults to false. Ignored if a pull request " +
+						"already exists",
+				},
+			},
+		},
+	}
+}
+
+// ParseToolUse parses the tool use block
+func (t *PublishChangesForReviewTool) ParseToolUse(block anthropic.ToolUseBlock) (*PublishChangesForReviewInput, error) {
+	if block.Name != t.Name {
+		return nil, fmt.Errorf("tool use block is for %s, not %s", block.Name, t.Name)
+	}
+
+	var input PublishChangesForReviewInput
+	if err := parseInputJSON(block, &input); err != nil {
+		return nil, err
+	}
+	return &input, nil
+}
+
+// Run executes the request review command
+func (t *PublishChangesForReviewTool) Run(ctx context.Context, block anthropic.ToolUseBlock, toolCtx *ToolContext) (*string, error) {
+	input, err := t.ParseToolUse(block)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing input: %w", err)
+	}
+
+	if toolCtx.Task.PullRequest == nil {
+		if input.PullRequestTitle == "" {
+			return nil, ToolInputError{fmt.Errorf("a new pull request will be created, so pull_request_title is required")}
+		}
+
+		if input.PullRequestBody == "" {
+			return nil, ToolInputError{fmt.Errorf("a new pull request will be created, so pull_request_body is required")}
+		}
+	}
+
+	if toolCtx.Workspace.HasLocalChanges() {
+		return nil, ToolInputError{fmt.Errorf("cannot publish while there are unvalidated changes in the workspace")}
+	}
+
+	body := input.PullRequestBody
+	if toolCtx.UsageFooter && toolCtx.Usage != nil {
+		body += "\n\n" + formatUsageFooter(toolCtx.Usage, toolCtx.Prices)
+	}
+
+	pr, err := toolCtx.Workspace.PublishChangesForReview(ctx, input.PullRequestTitle, body, input.Draft)
+	if err != nil {
+		if errors.Is(err, workspace.ErrNoCommits) {
+			return nil, ToolInputError{fmt.Errorf("failed to publish changes: there are no new changes")}
+		}
+		return nil, fmt.Errorf("failed to publish changes: %w", err)
+	}
+	if pr != nil {
+		// Tools that act on the pull request may be used later in the same run
+		toolCtx.Task.PullRequest = pr
+	}
+
+	return nil, nil
+}
+
+func (t *PublishChangesForReviewTool) Replay(ctx context.Context, block anthropic.ToolUseBlock, toolCtx *ToolContext) error {
+	// Changes were published from one remote location to another by the original invocation of this tool, so there is
+	// nothing to do here
+	return nil
+}
+
+// MarkPRReadyTool implements the mark_pull_request_ready tool
+type MarkPRReadyTool struct {
+	BaseTool
+}
+
+// NewMarkPRReadyTool creates a new mark PR ready tool
+func NewMarkPRReadyTool() *MarkPRReadyTool {
+	return &MarkPRReadyTool{
+		BaseTool: BaseTool{Name: "mark_pull_request_ready", Mutating: true},
+	}
+}
+
+// GetToolParam returns the tool parameter definition
+func (t *MarkPRReadyTool) GetToolParam() anthropic.ToolParam {
+	return anthropic.ToolParam{
+		Name:        t.Name,
+		Description: anthropic.String("Mark your draft pull request as ready for review, e.g. once validation passes"),
+		InputSchema: anthropic.ToolInputSchemaParam{
+			Properties: map[string]any{},
+		},
+	}
+}
+
+// Run executes the mark PR ready command
+func (t *MarkPRReadyTool) Run(ctx context.Context, block anthropic.ToolUseBlock, toolCtx *ToolContext) (*string, error) {
+	if block.Name != t.Name {
+		return nil, fmt.Errorf("tool use block is for %s, not %s", block.Name, t.Name)
+	}
+
+	taskPR := toolCtx.Task.PullRequest
+	if taskPR == nil {
+		return nil, ToolInputError{fmt.Errorf("there is no pull request to mark as ready. Publish your changes first")}
+	}
+
+	pr, _, err := toolCtx.GithubClient.PullRequests.Get(ctx, taskPR.Owner, taskPR.Repo, taskPR.Number)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get pull request: %w", err)
+	}
+
+	var result string
+	if !pr.GetDraft() {
+		result = fmt.Sprintf("Pull request #%d is already ready for review", taskPR.Number)
+	} else {
+		gql := githubgql.NewGraphQLClientFromREST(toolCtx.GithubClient)
+		if err := gql.MarkPullRequestReadyForReview(ctx, pr.GetNodeID()); err != nil {
+			return nil, err
+		}
+		result = fmt.Sprintf("Marked pull request #%d as ready for review", taskPR.Number)
+	}
+	return &result, nil
+}
+
+func (t *MarkPRReadyTool) Replay(ctx context.Context, block anthropic.ToolUseBlock, toolCtx *ToolContext) error {
+	// No side effects to replay
+	return nil
+}
+
+// UpdatePRDescriptionTool implements the update_pull_request_description tool
+type UpdatePRDescriptionTool struct {
+	BaseTool
+}
+
+// UpdatePRDescriptionInput represents the input for update_pull_request_description
+type UpdatePRDescriptionInput struct {
+	Title string `json:"title,omitempty"`
+	Body  string `json:"body,omitempty"`
+}
+
+// NewUpdatePRDescriptionTool creates a new update PR description tool
+func NewUpdatePRDescriptionTool() *UpdatePRDescriptionTool {
+	return &UpdatePRDescriptionTool{
+		BaseTool: BaseTool{Name: "update_pull_request_description", Mutating: true},
+	}
+}
+
+// GetToolParam returns the tool parameter definition
+func (t *UpdatePRDescriptionTool) GetToolParam() anthropic.ToolParam {
+	return anthropic.ToolParam{
+		Name: t.Name,
+		Description: anthropic.String("Change the title and/or description of your pull request, e.g. when its scope has " +
+			"changed since it was opened"),
+		InputSchema: anthropic.ToolInputSchemaParam{
+			Properties: map[string]any{
+				"title": map[string]any{
+					"type":        "string",
+					"description": "The new title of the pull request. Omit to keep the current title",
+				},
+				"body": map[string]any{
+					"type": "string",
+					"description": "The new description of the pull request, replacing the current one. The reference to the " +
+						"issue is added automatically. Omit to keep the current description",
+				},
+			},
+		},
+	}
+}
+
+// ParseToolUse parses the tool use block
+func (t *UpdatePRDescriptionTool) ParseToolUse(block anthropic.ToolUseBlock) (*UpdatePRDescriptionInput, error) {
+	if block.Name != t.Name {
+		return nil, fmt.Errorf("tool use block is for %s, not %s", block.Name, t.Name)
+	}
+
+	var input UpdatePRDescriptionInput
+	if err := parseInputJSON(block, &input); err != nil {
+		return nil, err
+	}
+	return &input, nil
+}
+
+// Run edits the pull request
+func (t *UpdatePRDescriptionTool) Run(ctx context.Context, block anthropic.ToolUseBlock, toolCtx *ToolContext) (*string, error) {
+	input, err := t.ParseToolUse(block)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing input: %w", err)
+	}
+
+	taskPR := toolCtx.Task.PullRequest
+	if taskPR == nil {
+		return nil, ToolInputError{fmt.Errorf("there is no pull request to update. Publish your changes first")}
+	}
+	title, body := strings.TrimSpace(input.Title), strings.TrimSpace(input.Body)
+	if title == "" && body == "" {
+		return nil, ToolInputError{fmt.Errorf("provide a new title, body, or both")}
+	}
+
+	edit := vcs.PullRequestEdit{Title: title}
+	if body != "" {
+		pr, _, err := toolCtx.GithubClient.PullRequests.Get(ctx, taskPR.Owner, taskPR.Repo, taskPR.Number)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get pull request: %w", err)
+		}
+		edit.Body = replacePRBody(pr.GetBody(), body, toolCtx.Task.IssueNumbers())
+	}
+
+	if err := toolCtx.VCS.EditPullRequest(ctx, taskPR.Owner, taskPR.Repo, taskPR.Number, edit); err != nil {
+		return nil, fmt.Errorf("failed to edit pull request: %w", err)
+	}
+	if title != "" {
+		taskPR.Title = title
+	}
+
+	result := fmt.Sprintf("Updated pull request #%d", taskPR.Number)
+	return &result, nil
+}
+
+func (t *UpdatePRDescriptionTool) Replay(ctx context.Context, block anthropic.ToolUseBlock, toolCtx *ToolContext) error {
+	// No side effects to replay
+	return nil
+}
+
+// issueReferenceRegex matches lines that reference an issue with a closing keyword, e.g. "Fixes #12"
+var issueReferenceRegex = regexp.MustCompile(`(?im)^\s*(close[sd]?|fix(e[sd])?|resolve[sd]?) #(\d+)\s*$`)
+
+// replacePRBody returns newBody followed by the footer of oldBody, which starts at the first reference to one of the
+// given issues, added when the pull request was opened. If oldBody has no such reference, e.g. because someone removed
+// it, a new footer is added. References to the issues in newBody are dropped so that the footer doesn't repeat them
+func replacePRBody(oldBody string, newBody string, issueNumbers []int) string {
+	isTaskIssue := func(numberStr string) bool {
+		number, err := strconv.Atoi(numberStr)
+		return err == nil && slices.Contains(issueNumbers, number)
+	}
+
+	footer := workspace.PullRequestFooter(issueNumbers)
+	for _, loc := range issueReferenceRegex.FindAllStringSubmatchIndex(oldBody, -1) {
+		if isTaskIssue(oldBody[loc[6]:loc[7]]) {
+			footer = strings.TrimSpace(oldBody[loc[0]:])
+			break
+		}
+	}
+
+	newBody = issueReferenceRegex.ReplaceAllStringFunc(newBody, func(line string) string {
+		if isTaskIssue(issueReferenceRegex.FindStringSubmatch(line)[3]) {
+			return ""
+		}
+		return line
+	})
+	return strings.TrimSpace(newBody) + "\n\n" + footer
+}
+
+// RetargetPRTool implements the retarget_pull_request tool
+type RetargetPRTool struct {
+	BaseTool
+}
+
+// RetargetPRInput represents the input for retarget_pull_request
+type RetargetPRInput struct {
+	Base string `json:"base"`
+}
+
+// NewRetargetPRTool creates a new retarget PR tool
+func NewRetargetPRTool() *RetargetPRTool {
+	return &RetargetPRTool{
+		BaseTool: BaseTool{Name: "retarget_pull_request", Mutating: true},
+	}
+}
+
+// GetToolParam returns the tool parameter definition
+func (t *RetargetPRTool) GetToolParam() anthropic.ToolParam {
+	return anthropic.ToolParam{
+		Name: t.Name,
+		Description: anthropic.String("Change the base branch of your pull request, e.g. if it was opened against the wrong " +
+			"branch. Your commits are not moved, so if the new base has diverged from the old one, the pull request may " +
+			"show unrelated changes or conflicts"),
+		InputSchema: anthropic.ToolInputSchemaParam{
+			Properties: map[string]any{
+				"base": map[string]any{
+					"type":        "string",
+					"description": "The branch that the pull request should be merged into",
+				},
+			},
+			Required: []string{"base"},
+		},
+	}
+}
+
+// ParseToolUse parses the tool use block
+func (t *RetargetPRTool) ParseToolUse(block anthropic.ToolUseBlock) (*RetargetPRInput, error) {
+	if block.Name != t.Name {
+		return nil, fmt.Errorf("tool use block is for %s, not %s", block.Name, t.Name)
+	}
+
+	var input RetargetPRInput
+	if err := parseInputJSON(block, &input); err != nil {
+		return nil, err
+	}
+	return &input, nil
+}
+
+// Run changes the base branch of the pull request
+func (t *RetargetPRTool) Run(ctx context.Context, block anthropic.ToolUseBlock, toolCtx *ToolContext) (*string, error) {
+	input, err := t.ParseToolUse(block)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing input: %w", err)
+	}
+
+	taskPR := toolCtx.Task.PullRequest
+	if taskPR == nil {
+		return nil, ToolInputError{fmt.Errorf("there is no pull request to retarget. Publish your changes first")}
+	}
+	base := strings.TrimSpace(input.Base)
+	if base == "" {
+		return nil, ToolInputError{fmt.Errorf("base must not be empty")}
+	}
+	if base == toolCtx.Task.SourceBranch {
+		return nil, ToolInputError{fmt.Errorf("'%s' is the pull request's head branch, which can't also be its base", base)}
+	}
+	if base == taskPR.BaseBranch {
+		result := fmt.Sprintf("Pull request #%d already targets '%s'", taskPR.Number, base)
+		return &result, nil
+	}
+
+	_, resp, err := toolCtx.GithubClient.Repositories.GetBranch(ctx, taskPR.Owner, taskPR.Repo, base, 1)
+	if resp != nil && resp.StatusCode == http.StatusNotFound {
+		return nil, ToolInputError{fmt.Errorf("there is no branch '%s' in %s/%s", base, taskPR.Owner, taskPR.Repo)}
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to get branch '%s': %w", base, err)
+	}
+
+	if err := toolCtx.VCS.EditPullRequest(ctx, taskPR.Owner, taskPR.Repo, taskPR.Number, vcs.PullRequestEdit{Base: base}); err != nil {
+		return nil, fmt.Errorf("failed to edit pull request: %w", err)
+	}
+
+	result := fmt.Sprintf("Pull request #%d now targets '%s'", taskPR.Number, base)
+	if base != toolCtx.Task.TargetBranch {
+		// The target branch is resolved from the issue each time the task is built, so it will win next time
+		result += fmt.Sprintf(". Note that the issue still asks for '%s', which later work on this task will be based "+
+			"on. To change that, ask for a `base: %s` label or line to be added to the issue", toolCtx.Task.TargetBranch, base)
+	}
+	t.retarget(toolCtx, base)
+	return &result, nil
+}
+
+// Replay bases the rest of the task on the new base branch if the pull request was retargeted. The pull request is
+// fetched afresh when a task is resumed, so its base tells whether the original call succeeded
+func (t *RetargetPRTool) Replay(ctx context.Context, block anthropic.ToolUseBlock, toolCtx *ToolContext) error {
+	input, err := t.ParseToolUse(block)
+	if err != nil {
+		return fmt.Errorf("error parsing input: %w", err)
+	}
+
+	base := strings.TrimSpace(input.Base)
+	if taskPR := toolCtx.Task.PullRequest; taskPR != nil && base != "" && taskPR.BaseBranch == base {
+		t.retarget(toolCtx, base)
+	}
+	return nil
+}
+
+// retarget bases the rest of the task on the given branch, which its pull request now targets
+func (t *RetargetPRTool) retarget(toolCtx *ToolContext, base string) {
+	toolCtx.Task.PullRequest.BaseBranch = base
+	toolCtx.Task.TargetBranch = base
+	toolCtx.Workspace.SetBaseBranch(base)
+}
+
+const (
+	// maxCheckOutputChars is the maximum length of the output shown for each failing check by get_check_runs
+	maxCheckOutputChars = 2000
+	// maxCheckAnnotations is the maximum number of annotations shown for each failing check by get_check_runs
+	maxCheckAnnotations = 20
+)
+
+// GetCheckRunsTool implements the get_check_runs tool
+type GetCheckRunsTool struct {
+	BaseTool
+}
+
+// NewGetCheckRunsTool creates a new get check runs tool
+func NewGetCheckRunsTool() *GetCheckRunsTool {
+	return &GetCheckRunsTool{
+		BaseTool: BaseTool{Name: "get_check_runs", Polling: true},
+	}
+}
+
+// GetToolParam returns the tool parameter definition
+func (t *GetCheckRunsTool) GetToolParam() anthropic.ToolParam {
+	return anthropic.ToolParam{
+		Name: t.Name,
+		Description: anthropic.String("List the CI checks and commit statuses on the head of your pull request, e.g. " +
+			"to find out whether checks that run after publishing have passed. Failing checks include a snippet of " +
+			"their output and annotations"),
+		InputSchema: anthropic.ToolInputSchemaParam{
+			Properties: map[string]any{},
+		},
+	}
+}
+
+// Run executes the get check runs command
+func (t *GetCheckRunsTool) Run(ctx context.Context, block anthropic.ToolUseBlock, toolCtx *ToolContext) (*string, error) {
+	if block.Name != t.Name {
+		return nil, fmt.Errorf("tool use block is for %s, not %s", block.Name, t.Name)
+	}
+
+	taskPR := toolCtx.Task.PullRequest
+	if taskPR == nil {
+		return nil, ToolInputError{fmt.Errorf("there is no pull request to check. Publish your changes first")}
+	}
+
+	pr, _, err := toolCtx.GithubClient.PullRequests.Get(ctx, taskPR.Owner, taskPR.Repo, taskPR.Number)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get pull request: %w", err)
+	}
+	headSHA := pr.GetHead().GetSHA()
+
+	checks := toolCtx.GithubClient.Checks
+	opts := &github.ListCheckRunsOptions{ListOptions: github.ListOptions{PerPage: 100}}
+	checkRuns, _, err := checks.ListCheckRunsForRef(ctx, taskPR.Owner, taskPR.Repo, headSHA, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list check runs: %w", err)
+	}
+
+	annotations := map[int64][]*github.CheckRunAnnotation{}
+	for _, run := range checkRuns.CheckRuns {
+		if !isFailedCheckConclusion(run.GetConclusion()) || run.GetOutput().GetAnnotationsCount() == 0 {
+			continue
+		}
+		annotationOpts := &github.ListOptions{PerPage: maxCheckAnnotations}
+		runAnnotations, _, err := checks.ListCheckRunAnnotations(ctx, taskPR.Owner, taskPR.Repo, run.GetID(), annotationOpts)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list annotations of check '%s': %w", run.GetName(), err)
+		}
+		annotations[run.GetID()] = runAnnotations
+	}
+
+	status, _, err := toolCtx.GithubClient.Repositories.GetCombinedStatus(ctx, taskPR.Owner, taskPR.Repo, headSHA, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get commit statuses: %w", err)
+	}
+
+	s := formatCheckRuns(taskPR.Number, headSHA, checkRuns.CheckRuns, annotations, status.Statuses)
+	return &s, nil
+}
+
+func (t *GetCheckRunsTool) Replay(ctx context.Context, block anthropic.ToolUseBlock, toolCtx *ToolContext) error {
+	// No side effects to replay
+	return nil
+}
+
+// truncateAtRuneBoundary returns the longest prefix of s that is at most maxBytes long and doesn't split a UTF-8
+// encoded character
+func truncateAtRuneBoundary(s string, maxBytes int) string {
+	if len(s) <= maxBytes {
+		return s
+	}
+	end := maxBytes
+	for end > 0 && !utf8.RuneStart(s[end]) {
+		end--
+	}
+	return s[:end]
+}
+
+// isFailedCheckConclusion returns true if a check run with the given conclusion needs attention
+func isFailedCheckConclusion(conclusion string) bool {
+	switch conclusion {
+	case "failure", "timed_out", "cancelled", "action_required", "startup_failure":
+		return true
+	default:
+		return false
+	}
+}
+
+// formatCheckRuns formats check runs and commit statuses for the AI. Failing check runs are followed by a snippet of
+// their output and the given annotations
+func formatCheckRuns(
+	prNumber int,
+	headSHA string,
+	runs []*github.CheckRun,
+	annotations map[int64][]*github.CheckRunAnnotation,
+	statuses []*github.RepoStatus,
+) string {
+	if len(headSHA) > 12 {
+		headSHA = headSHA[:12]
+	}
+	if len(runs) == 0 && len(statuses) == 0 {
+		return fmt.Sprintf("There are no checks or statuses on the head of pull request #%d (%s)", prNumber, headSHA)
+	}
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "Checks on the head of pull request #%d (%s):\n", prNumber, headSHA)
+	for _, run := range runs {
+		state := run.GetConclusion()
+		if run.GetStatus() != "completed" {
+			state = run.GetStatus()
+		}
+		fmt.Fprintf(&sb, "- %s: %s\n", run.GetName(), state)
+		if !isFailedCheckConclusion(run.GetConclusion()) {
+			continue
+		}
+
+		output := strings.TrimSpace(strings.Join([]string{run.GetOutput().GetSummary(), run.GetOutput().GetText()}, "\n"))
+		if len(output) > maxCheckOutputChars {
+			output = truncateAtRuneBoundary(output, maxCheckOutputChars) + "\n[output truncated]"
+		}
+		if output != "" {
+			fmt.Fprintf(&sb, "  Output:\n```\n%s\n```\n", output)
+		}
+		if runAnnotations := annotations[run.GetID()]; len(runAnnotations) > 0 {
+			sb.WriteString("  Annotations:\n")
+			for _, a := range runAnnotations {
+				fmt.Fprintf(&sb, "  - %s:%d: %s: %s\n", a.GetPath(), a.GetStartLine(), a.GetAnnotationLevel(), a.GetMessage())
+			}
+			if count := run.GetOutput().GetAnnotationsCount(); count > len(runAnnotations) {
+				fmt.Fprintf(&sb, "  (%d more annotations not shown)\n", count-len(runAnnotations))
+			}
+		}
+	}
+	if len(statuses) > 0 {
+		sb.WriteString("Commit statuses:\n")
+		for _, status := range statuses {
+			fmt.Fprintf(&sb, "- %s: %s", status.GetContext(), status.GetState())
+			if description := status.GetDescription(); description != "" {
+				fmt.Fprintf(&sb, " (%s)", description)
+			}
+			sb.WriteString("\n")
+		}
+	}
+	return sb.String()
+}
+
+// formatUsageFooter formats token usage and estimated cost as a collapsible markdown section
+func formatUsageFooter(usage *ai.UsageTracker, prices ai.PriceTable) string {
+	total := usage.Total()
+	cost := "unknown"
+	if c, err := usage.Cost(prices); err == nil {
+		cost = fmt.Sprintf("$%.2f", c)
+	}
+
+	var sb strings.Builder
+	sb.WriteString("<details>\n<summary>AI usage</summary>\n\n")
+	sb.WriteString("| Input tokens | Output tokens | Cache read tokens | Cache write tokens | Estimated cost |\n")
+	sb.WriteString("|---|---|---|---|---|\n")
+	fmt.Fprintf(&sb, "| %d | %d | %d | %d | %s |\n\n", total.InputTokens, total.OutputTokens,
+		total.CacheReadInputTokens, total.CacheCreationInputTokens, cost)
+	sb.WriteString("</details>")
+	return sb.String()
+}
+
+// ReportLimitationTool implements the report_limitation tool
+type ReportLimitationTool struct {
+	BaseTool
+}
+
+// ReportLimitationInput represents the input for report_limitation
+type ReportLimitationInput struct {
+	ToolNeeded string `json:"tool_needed"`
+	Reason     string `json:"reason"`
+}
+
+// NewReportLimitationTool creates a new report limitation tool
+func NewReportLimitationTool() *ReportLimitationTool {
+	return &ReportLimitationTool{
+		BaseTool: BaseTool{Name: "report_limitation", Mutating: true},
+	}
+}
+
+// GetToolParam returns the tool parameter definition
+func (t *ReportLimitationTool) GetToolParam() anthropic.ToolParam {
+	return anthropic.ToolParam{
+		Name:        t.Name,
+		Description: anthropic.String("Report when you cannot complete a task because you do not have a tool that you need. After calling this tool you should end the conversation"),
+		InputSchema: anthropic.ToolInputSchemaParam{
+			Properties: map[string]any{
+				"tool_needed": map[string]any{
+					"type":        "string",
+					"description": "A description of the tool you need to complete the task",
+				},
+				"reason": map[string]any{
+					"type":        "string",
+					"description": "Why this tool is needed to complete the task",
+				},
+			},
+			Required: []string{"tool_needed", "reason"},
+		},
+	}
+}
+
+// ParseToolUse parses the tool use block
+func (t *ReportLimitationTool) ParseToolUse(block anthropic.ToolUseBlock) (*ReportLimitationInput, error) {
+	if block.Name != t.Name {
+		return nil, fmt.Errorf("tool use block is for %s, not %s", block.Name, t.Name)
+	}
+
+	var input ReportLimitationInput
+	if err := parseInputJSON(block, &input); err != nil {
+		return nil, err
+	}
+	return &input, nil
+}
+
+// Run executes the report limitation command
+func (t *ReportLimitationTool) Run(ctx context.Context, block anthropic.ToolUseBlock, toolCtx *ToolContext) (*string, error) {
+	input, err := t.ParseToolUse(block)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing input: %w", err)
+	}
+
+	if input.ToolNeeded == "" {
+		return nil, ToolInputError{fmt.Errorf("tool_needed is required")}
+	}
+
+	if input.Reason == "" {
+		return nil, ToolInputError{fmt.Errorf("reason is required")}
+	}
+
+	// Create a formatted limitation report
+	var report strings.Builder
+	report.WriteString("## ⚠️ Tool Limitation Report\n\n")
+	report.WriteString("I cannot complete this task with my current tools. ")
+	report.WriteString("Human intervention or additional tool support may be required.\n\n")
+
+	report.WriteString(fmt.Sprintf("**Tool needed:** %s\n\n", input.ToolNeeded))
+	report.WriteString(fmt.Sprintf("**Reason:** %s\n\n", input.Reason))
+
+	// Post the limitation report as a comment on the issue
+	err = toolCtx.VCS.CreateComment(ctx, toolCtx.Task.Issue.Owner, toolCtx.Task.Issue.Repo, toolCtx.Task.Issue.Number, report.String())
+	if err != nil {
+		return nil, fmt.Errorf("failed to post limitation report: %w", err)
+	}
+
+	err = addLabel(ctx, toolCtx.VCS, toolCtx.Task.Issue, task.LabelBlocked)
+	if err != nil {
+		return nil, fmt.Errorf("failed to add blocked label: %w", err)
+	}
+
+	result := "Posted limitation report"
+	return &result, nil
+}
+
+func (t *ReportLimitationTool) Replay(ctx context.Context, block anthropic.ToolUseBlock, toolCtx *ToolContext) error {
+	// No side effects to replay - the comment was already posted
+	return nil
+}
+
+// AwaitHumanInputTool implements the await_human_input tool
+type AwaitHumanInputTool struct {
+	BaseTool
+}
+
+// AwaitHumanInputInput represents the input for await_human_input
+type AwaitHumanInputInput struct {
+	Question  string   `json:"question"`
+	Context   string   `json:"context,omitempty"`
+	Assignees []string `json:"assignees,omitempty"`
+}
+
+// NewAwaitHumanInputTool creates a new await human input tool
+func NewAwaitHumanInputTool() *AwaitHumanInputTool {
+	return &AwaitHumanInputTool{
+		BaseTool: BaseTool{Name: "await_human_input", Mutating: true},
+	}
+}
+
+// GetToolParam returns the tool parameter definition
+func (t *AwaitHumanInputTool) GetToolParam() anthropic.ToolParam {
 	return anthropic.ToolParam{
-		Name:        t.Name,
-		Description: anthropic.String("Delete a file"),
+		Name: t.Name,
+		Description: anthropic.String("Ask a human a question on the issue and stop working until they answer. Use this " +
+			"when you can't continue without a decision or information that only a human can provide. Your progress is " +
+			"saved, and you will pick up where you left off when they reply. Do not use any other tools after this one"),
 		InputSchema: anthropic.ToolInputSchemaParam{
 			Properties: map[string]any{
-				"path": map[string]any{
+				"question": map[string]any{
 					"type":        "string",
-					"description": "Path to the file to delete.",
+					"description": "The question to ask. Make it specific enough to answer without further back-and-forth",
+				},
+				"context": map[string]any{
+					"type":        "string",
+					"description": "Optional background that helps the human answer, e.g. the options you considered",
+				},
+				"assignees": map[string]any{
+					"type":  "array",
+					"items": map[string]any{"type": "string"},
+					"description": "Optional logins of users to assign to the issue, e.g. a maintainer who can answer " +
+						"the question. They must be able to be assigned to issues in the repository",
 				},
 			},
-			Required: []string{"path"},
+			Required: []string{"question"},
 		},
 	}
 }
 
 // ParseToolUse parses the tool use block
-func (t *DeleteFileTool) ParseToolUse(block anthropic.ToolUseBlock) (*DeleteFileInput, error) {
+func (t *AwaitHumanInputTool) ParseToolUse(block anthropic.ToolUseBlock) (*AwaitHumanInputInput, error) {
 	if block.Name != t.Name {
 		return nil, fmt.Errorf("tool use block is for %s, not %s", block.Name, t.Name)
 	}
 
-	var input DeleteFileInput
+	var input AwaitHumanInputInput
 	if err := parseInputJSON(block, &input); err != nil {
 		return nil, err
 	}
 	return &input, nil
 }
 
-// Run executes the delete file command
-func (t *DeleteFileTool) Run(ctx context.Context, block anthropic.ToolUseBlock, toolCtx *ToolContext) (*string, error) {
+// Run posts the question, marks the issue as waiting for a human, and signals the bot to stop
+func (t *AwaitHumanInputTool) Run(ctx context.Context, block anthropic.ToolUseBlock, toolCtx *ToolContext) (*string, error) {
 	input, err := t.ParseToolUse(block)
 	if err != nil {
 		return nil, fmt.Errorf("error parsing input: %w", err)
 	}
 
-	if input.Path == "" {
-		return nil, ToolInputError{fmt.Errorf("path is required")}
+	if strings.TrimSpace(input.Question) == "" {
+		return nil, ToolInputError{fmt.Errorf("question is required")}
+	}
+	if err := checkAssignable(ctx, toolCtx, input.Assignees); err != nil {
+		return nil, err
 	}
 
-	// Validate that the path doesn't start with a leading slash
-	if strings.HasPrefix(input.Path, "/") {
-		return nil, ToolInputError{fmt.Errorf("path must be relative (no leading slash)")}
+	var comment strings.Builder
+	comment.WriteString("## ❓ Question\n\n")
+	comment.WriteString(strings.TrimSpace(input.Question) + "\n\n")
+	if c := strings.TrimSpace(input.Context); c != "" {
+		comment.WriteString("**Context:** " + c + "\n\n")
 	}
+	comment.WriteString("I've paused work on this issue until someone replies.")
 
-	// Check if the file exists before deleting
-	exists, err := toolCtx.Workspace.FileExists(ctx, input.Path)
+	issue := toolCtx.Task.Issue
+	err = toolCtx.VCS.CreateComment(ctx, issue.Owner, issue.Repo, issue.Number, comment.String())
 	if err != nil {
-		return nil, fmt.Errorf("error checking if file exists: %w", err)
-	}
-	if !exists {
-		return nil, ToolInputError{fmt.Errorf("file does not exist: %s", input.Path)}
+		return nil, fmt.Errorf("failed to post question: %w", err)
 	}
 
-	// Check if it's a directory
-	isDir, err := toolCtx.Workspace.IsDir(ctx, input.Path)
+	err = addLabel(ctx, toolCtx.VCS, issue, task.LabelNeedsHuman)
 	if err != nil {
-		return nil, fmt.Errorf("error checking if path is directory: %w", err)
-	}
-	if isDir {
-		return nil, ToolInputError{fmt.Errorf("cannot delete directory: %s (only files can be deleted)", input.Path)}
+		return nil, fmt.Errorf("failed to add needs-human label: %w", err)
 	}
-
-	// Delete the file
-	err = toolCtx.Workspace.Delete(ctx, input.Path)
+	err = removeLabel(ctx, toolCtx.VCS, issue, task.LabelBotTurn)
 	if err != nil {
-		return nil, fmt.Errorf("error deleting file: %w", err)
+		return nil, fmt.Errorf("failed to remove bot turn label: %w", err)
+	}
+	if len(input.Assignees) > 0 {
+		_, err = toolCtx.VCS.AddAssignees(ctx, issue.Owner, issue.Repo, issue.Number, input.Assignees)
+		if err != nil {
+			return nil, fmt.Errorf("failed to add assignees: %w", err)
+		}
 	}
 
-	result := fmt.Sprintf("Successfully deleted file: %s", input.Path)
+	toolCtx.AwaitingHumanInput = true
+
+	result := "Posted your question. Work on this issue is paused until a human replies, at which point you will be " +
+		"given their reply"
+	if len(input.Assignees) > 0 {
+		result += fmt.Sprintf(". Assigned %s to the issue", strings.Join(input.Assignees, ", "))
+	}
 	return &result, nil
 }
 
-func (t *DeleteFileTool) Replay(ctx context.Context, block anthropic.ToolUseBlock, toolCtx *ToolContext) error {
+func (t *AwaitHumanInputTool) Replay(ctx context.Context, block anthropic.ToolUseBlock, toolCtx *ToolContext) error {
+	// No side effects to replay - the question was already posted
+	return nil
+}
+
+// planApprovalInstructions end plan comments, telling humans how to respond
+const planApprovalInstructions = "React to this comment with 👍 to approve the plan, or reply with any changes you'd " +
+	"like. I won't change any code until the plan is approved."
+
+// PostPlanTool implements the post_plan tool
+type PostPlanTool struct {
+	BaseTool
+}
+
+// PostPlanInput represents the input for post_plan
+type PostPlanInput struct {
+	Plan string `json:"plan"`
+}
+
+// NewPostPlanTool creates a new post plan tool
+func NewPostPlanTool() *PostPlanTool {
+	return &PostPlanTool{
+		BaseTool: BaseTool{Name: "post_plan", Mutating: true},
+	}
+}
+
+// GetToolParam returns the tool parameter definition
+func (t *PostPlanTool) GetToolParam() anthropic.ToolParam {
+	return anthropic.ToolParam{
+		Name: t.Name,
+		Description: anthropic.String("Propose your approach to the issue in a comment and stop working until a human " +
+			"approves it. Only for issues that need a plan approved before you change any code. Your progress is saved, " +
+			"and you will pick up where you left off when the plan is approved or someone replies. Do not use any other " +
+			"tools after this one"),
+		InputSchema: anthropic.ToolInputSchemaParam{
+			Properties: map[string]any{
+				"plan": map[string]any{
+					"type": "string",
+					"description": "The plan, in markdown: the changes you intend to make, the files they touch, and " +
+						"any open questions or risks. Concise enough for a maintainer to review at a glance",
+				},
+			},
+			Required: []string{"plan"},
+		},
+	}
+}
+
+// ParseToolUse parses the tool use block
+func (t *PostPlanTool) ParseToolUse(block anthropic.ToolUseBlock) (*PostPlanInput, error) {
+	if block.Name != t.Name {
+		return nil, fmt.Errorf("tool use block is for %s, not %s", block.Name, t.Name)
+	}
+
+	var input PostPlanInput
+	if err := parseInputJSON(block, &input); err != nil {
+		return nil, err
+	}
+	return &input, nil
+}
+
+// Run posts the plan, marks the issue as waiting for a human, and signals the bot to stop
+func (t *PostPlanTool) Run(ctx context.Context, block anthropic.ToolUseBlock, toolCtx *ToolContext) (*string, error) {
 	input, err := t.ParseToolUse(block)
 	if err != nil {
-		return fmt.Errorf("error parsing input: %w", err)
+		return nil, fmt.Errorf("error parsing input: %w", err)
+	}
+
+	plan := strings.TrimSpace(input.Plan)
+	if plan == "" {
+		return nil, ToolInputError{fmt.Errorf("plan is required")}
+	}
+	if !toolCtx.Task.NeedsPlanApproval() {
+		return nil, ToolInputError{fmt.Errorf("this issue doesn't need a plan approved, go ahead with the task")}
+	}
+
+	var comment strings.Builder
+	comment.WriteString(task.PlanCommentHeading + "\n\n")
+	comment.WriteString(plan + "\n\n")
+	comment.WriteString(planApprovalInstructions)
+
+	issue := toolCtx.Task.Issue
+	err = toolCtx.VCS.CreateComment(ctx, issue.Owner, issue.Repo, issue.Number, comment.String())
+	if err != nil {
+		return nil, fmt.Errorf("failed to post plan: %w", err)
+	}
+
+	err = addLabel(ctx, toolCtx.VCS, issue, task.LabelNeedsHuman)
+	if err != nil {
+		return nil, fmt.Errorf("failed to add needs-human label: %w", err)
+	}
+	err = removeLabel(ctx, toolCtx.VCS, issue, task.LabelBotTurn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to remove bot turn label: %w", err)
 	}
 
-	// Replay the deletion (same as the original run since it's an in-memory operation)
-	return toolCtx.Workspace.Delete(ctx, input.Path)
+	toolCtx.AwaitingHumanInput = true
+
+	result := "Posted your plan. Work on this issue is paused until a human approves the plan or replies, at which " +
+		"point you will be told"
+	return &result, nil
 }
 
-type PublishChangesForReviewTool struct {
+func (t *PostPlanTool) Replay(ctx context.Context, block anthropic.ToolUseBlock, toolCtx *ToolContext) error {
+	// No side effects to replay - the plan was already posted
+	return nil
+}
+
+// ConcludeTool implements the conclude tool
+type ConcludeTool struct {
 	BaseTool
 }
 
-type PublishChangesForReviewInput struct {
-	PullRequestTitle string `json:"pull_request_title"`
-	PullRequestBody  string `json:"pull_request_body"`
+// ConcludeInput represents the input for conclude
+type ConcludeInput struct {
+	Comment string `json:"comment,omitempty"`
 }
 
-func NewPublishChangesForReviewTool() *PublishChangesForReviewTool {
-	return &PublishChangesForReviewTool{
-		BaseTool: BaseTool{Name: "publish_changes_for_review"},
+// NewConcludeTool creates a new conclude tool
+func NewConcludeTool() *ConcludeTool {
+	return &ConcludeTool{
+		BaseTool: BaseTool{Name: "conclude", Mutating: true},
 	}
 }
 
 // GetToolParam returns the tool parameter definition
-func (t *PublishChangesForReviewTool) GetToolParam() anthropic.ToolParam {
+func (t *ConcludeTool) GetToolParam() anthropic.ToolParam {
 	return anthropic.ToolParam{
-		Name:        t.Name,
-		Description: anthropic.String("Publish changes for review by other developers"),
+		Name: t.Name,
+		Description: anthropic.String("Finish work on the issue when nothing is left for you to do and no code change is " +
+			"needed, e.g. because you answered a question. Unlike await_human_input, your progress is not saved; a " +
+			"later comment starts a new conversation. Do not use any other tools after this one"),
 		InputSchema: anthropic.ToolInputSchemaParam{
 			Properties: map[string]any{
-				"pull_request_title": map[string]any{
+				"comment": map[string]any{
 					"type":        "string",
-					"description": "Title for the new pull request, if any. Ignored if a pull request already exists",
-				},
-				"pull_request_body": map[string]any{
-					"type":        "string",
-					"description": "Description of the solution and what changes were made. Ignored if a pull request already exists",
+					"description": "Optional final comment to post on the issue, in markdown",
 				},
 			},
 		},
@@ -742,184 +5497,296 @@ func (t *PublishChangesForReviewTool) GetToolParam() anthropic.ToolParam {
 }
 
 // ParseToolUse parses the tool use block
-func (t *PublishChangesForReviewTool) ParseToolUse(block anthropic.ToolUseBlock) (*PublishChangesForReviewInput, error) {
+func (t *ConcludeTool) ParseToolUse(block anthropic.ToolUseBlock) (*ConcludeInput, error) {
 	if block.Name != t.Name {
 		return nil, fmt.Errorf("tool use block is for %s, not %s", block.Name, t.Name)
 	}
 
-	var input PublishChangesForReviewInput
+	var input ConcludeInput
 	if err := parseInputJSON(block, &input); err != nil {
 		return nil, err
 	}
 	return &input, nil
 }
 
-// Run executes the request review command
-func (t *PublishChangesForReviewTool) Run(ctx context.Context, block anthropic.ToolUseBlock, toolCtx *ToolContext) (*string, error) {
+// Run posts the final comment, if any, hands the turn back to humans, and signals the bot to stop
+func (t *ConcludeTool) Run(ctx context.Context, block anthropic.ToolUseBlock, toolCtx *ToolContext) (*string, error) {
 	input, err := t.ParseToolUse(block)
 	if err != nil {
 		return nil, fmt.Errorf("error parsing input: %w", err)
 	}
 
-	if toolCtx.Task.PullRequest == nil {
-		if input.PullRequestTitle == "" {
-			return nil, ToolInputError{fmt.Errorf("a new pull request will be created, so pull_request_title is required")}
-		}
-
-		if input.PullRequestBody == "" {
-			return nil, ToolInputError{fmt.Errorf("a new pull request will be created, so pull_request_body is required")}
+	issue := toolCtx.Task.Issue
+	if comment := strings.TrimSpace(input.Comment); comment != "" {
+		err = toolCtx.VCS.CreateComment(ctx, issue.Owner, issue.Repo, issue.Number, comment)
+		if err != nil {
+			return nil, fmt.Errorf("failed to post comment: %w", err)
 		}
 	}
 
-	if toolCtx.Workspace.HasLocalChanges() {
-		return nil, ToolInputError{fmt.Errorf("cannot publish while there are unvalidated changes in the workspace")}
-	}
-
-	err = toolCtx.Workspace.PublishChangesForReview(ctx, input.PullRequestTitle, input.PullRequestBody)
+	err = removeLabel(ctx, toolCtx.VCS, issue, task.LabelBotTurn)
 	if err != nil {
-		if errors.Is(err, workspace.ErrNoCommits) {
-			return nil, ToolInputError{fmt.Errorf("failed to publish changes: there are no new changes")}
-		}
-		return nil, fmt.Errorf("failed to publish changes: %w", err)
+		return nil, fmt.Errorf("failed to remove bot turn label: %w", err)
 	}
 
-	return nil, err
+	toolCtx.Concluded = true
+
+	result := "Concluded work on this issue"
+	return &result, nil
 }
 
-func (t *PublishChangesForReviewTool) Replay(ctx context.Context, block anthropic.ToolUseBlock, toolCtx *ToolContext) error {
-	// Changes were published from one remote location to another by the original invocation of this tool, so there is
-	// nothing to do here
+func (t *ConcludeTool) Replay(ctx context.Context, block anthropic.ToolUseBlock, toolCtx *ToolContext) error {
+	// No side effects to replay - the comment was already posted
 	return nil
 }
 
-// ReportLimitationTool implements the report_limitation tool
-type ReportLimitationTool struct {
+// IgnoreIssueTool implements the ignore_issue tool
+type IgnoreIssueTool struct {
 	BaseTool
 }
 
-// ReportLimitationInput represents the input for report_limitation
-type ReportLimitationInput struct {
-	ToolNeeded string `json:"tool_needed"`
-	Reason     string `json:"reason"`
+// IgnoreIssueInput represents the input for ignore_issue
+type IgnoreIssueInput struct {
+	Reason string `json:"reason"`
 }
 
-// NewReportLimitationTool creates a new report limitation tool
-func NewReportLimitationTool() *ReportLimitationTool {
-	return &ReportLimitationTool{
-		BaseTool: BaseTool{Name: "report_limitation"},
+// NewIgnoreIssueTool creates a new ignore issue tool
+func NewIgnoreIssueTool() *IgnoreIssueTool {
+	return &IgnoreIssueTool{
+		BaseTool: BaseTool{Name: "ignore_issue", Mutating: true},
 	}
 }
 
 // GetToolParam returns the tool parameter definition
-func (t *ReportLimitationTool) GetToolParam() anthropic.ToolParam {
+func (t *IgnoreIssueTool) GetToolParam() anthropic.ToolParam {
 	return anthropic.ToolParam{
-		Name:        t.Name,
-		Description: anthropic.String("Report when you cannot complete a task because you do not have a tool that you need. After calling this tool you should end the conversation"),
+		Name: t.Name,
+		Description: anthropic.String("Leave the issue alone for good, e.g. because it is spam or out of scope for the " +
+			"repository. Labels the issue so that you aren't asked to work on it again until a human removes the " +
+			"label, and posts your reason as a comment. Do not use this for issues that are merely unclear; ask " +
+			"questions with await_human_input instead. Do not use any other tools after this one"),
 		InputSchema: anthropic.ToolInputSchemaParam{
 			Properties: map[string]any{
-				"tool_needed": map[string]any{
-					"type":        "string",
-					"description": "A description of the tool you need to complete the task",
-				},
 				"reason": map[string]any{
 					"type":        "string",
-					"description": "Why this tool is needed to complete the task",
+					"description": "Why the issue should be ignored, in markdown. Posted on the issue for humans to read",
 				},
 			},
-			Required: []string{"tool_needed", "reason"},
+			Required: []string{"reason"},
 		},
 	}
 }
 
 // ParseToolUse parses the tool use block
-func (t *ReportLimitationTool) ParseToolUse(block anthropic.ToolUseBlock) (*ReportLimitationInput, error) {
+func (t *IgnoreIssueTool) ParseToolUse(block anthropic.ToolUseBlock) (*IgnoreIssueInput, error) {
 	if block.Name != t.Name {
 		return nil, fmt.Errorf("tool use block is for %s, not %s", block.Name, t.Name)
 	}
 
-	var input ReportLimitationInput
+	var input IgnoreIssueInput
 	if err := parseInputJSON(block, &input); err != nil {
 		return nil, err
 	}
 	return &input, nil
 }
 
-// Run executes the report limitation command
-func (t *ReportLimitationTool) Run(ctx context.Context, block anthropic.ToolUseBlock, toolCtx *ToolContext) (*string, error) {
+// Run posts the reason for ignoring the issue, labels it so that it isn't picked up again, and signals the bot to stop
+func (t *IgnoreIssueTool) Run(ctx context.Context, block anthropic.ToolUseBlock, toolCtx *ToolContext) (*string, error) {
 	input, err := t.ParseToolUse(block)
 	if err != nil {
 		return nil, fmt.Errorf("error parsing input: %w", err)
 	}
 
-	if input.ToolNeeded == "" {
-		return nil, ToolInputError{fmt.Errorf("tool_needed is required")}
-	}
-
-	if input.Reason == "" {
+	reason := strings.TrimSpace(input.Reason)
+	if reason == "" {
 		return nil, ToolInputError{fmt.Errorf("reason is required")}
 	}
 
-	// Create a formatted limitation report
-	var report strings.Builder
-	report.WriteString("## ⚠️ Tool Limitation Report\n\n")
-	report.WriteString("I cannot complete this task with my current tools. ")
-	report.WriteString("Human intervention or additional tool support may be required.\n\n")
-
-	report.WriteString(fmt.Sprintf("**Tool needed:** %s\n\n", input.ToolNeeded))
-	report.WriteString(fmt.Sprintf("**Reason:** %s\n\n", input.Reason))
-
-	// Post the limitation report as a comment on the issue
-	comment := &github.IssueComment{
-		Body: github.Ptr(report.String()),
-	}
-	_, _, err = toolCtx.GithubClient.Issues.CreateComment(ctx, toolCtx.Task.Issue.Owner, toolCtx.Task.Issue.Repo, toolCtx.Task.Issue.Number, comment)
+	issue := toolCtx.Task.Issue
+	comment := fmt.Sprintf("I'm going to leave this issue alone. %s\n\nRemove the `%s` label if you'd like me to work "+
+		"on it after all.", reason, *toolCtx.Task.IgnoreLabel().Name)
+	err = toolCtx.VCS.CreateComment(ctx, issue.Owner, issue.Repo, issue.Number, comment)
 	if err != nil {
-		return nil, fmt.Errorf("failed to post limitation report: %w", err)
+		return nil, fmt.Errorf("failed to post comment: %w", err)
 	}
 
-	err = addLabel(ctx, toolCtx.GithubClient.Issues, toolCtx.Task.Issue, task.LabelBlocked)
+	err = addLabel(ctx, toolCtx.VCS, issue, toolCtx.Task.IgnoreLabel())
 	if err != nil {
-		return nil, fmt.Errorf("failed to add blocked label: %w", err)
+		return nil, fmt.Errorf("failed to add ignore label: %w", err)
+	}
+	err = removeLabel(ctx, toolCtx.VCS, issue, task.LabelBotTurn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to remove bot turn label: %w", err)
 	}
 
-	result := "Posted limitation report"
+	toolCtx.Concluded = true
+
+	result := "Ignored this issue"
 	return &result, nil
 }
 
-func (t *ReportLimitationTool) Replay(ctx context.Context, block anthropic.ToolUseBlock, toolCtx *ToolContext) error {
-	// No side effects to replay - the comment was already posted
+func (t *IgnoreIssueTool) Replay(ctx context.Context, block anthropic.ToolUseBlock, toolCtx *ToolContext) error {
+	// No side effects to replay - the comment and label were already posted
 	return nil
 }
 
+// ToolFilter selects which tools are available to the AI. The zero value enables all tools
+type ToolFilter struct {
+	// Enabled lists the only tools to enable, if non-empty
+	Enabled []string
+	// Disabled lists tools to disable. Takes precedence over Enabled
+	Disabled []string
+}
+
+// allows returns true if the tool with the given name passes the filter
+func (tf ToolFilter) allows(name string) bool {
+	if slices.Contains(tf.Disabled, name) {
+		return false
+	}
+	return len(tf.Enabled) == 0 || slices.Contains(tf.Enabled, name)
+}
+
+// planningTools are the tools that the AI may use while a task needs a plan approved: those that investigate the issue,
+// communicate with humans, or propose the plan, but not those that change code
+var planningTools = map[string]bool{
+	"read_multiple_files": true,
+	"list_directory_tree": true,
+	"blame_file":          true,
+	"find_symbol":         true,
+	"view_diff":           true,
+	"git_log":             true,
+	"view_file_at_ref":    true,
+	"list_open_items":     true,
+	"get_issue_timeline":  true,
+	"post_comment":        true,
+	"add_reaction":        true,
+	"link_issue":          true,
+	"create_issue":        true,
+	"manage_labels":       true,
+	"manage_assignees":    true,
+	"post_plan":           true,
+	"await_human_input":   true,
+	"conclude":            true,
+	"ignore_issue":        true,
+	"report_limitation":   true,
+}
+
+// planningCommands are the commands of tools that change code which the AI may still use while a task needs a plan
+// approved, because they only read
+var planningCommands = map[string][]string{
+	"str_replace_based_edit_tool": {"view"},
+}
+
+// isOfferedWhilePlanning returns true if the tool with the given name is offered while a task needs a plan approved,
+// possibly only for some of its commands
+func isOfferedWhilePlanning(name string) bool {
+	_, ok := planningCommands[name]
+	return planningTools[name] || ok
+}
+
+// isAllowedWhilePlanning returns true if the given tool use may run while a task needs a plan approved
+func isAllowedWhilePlanning(block anthropic.ToolUseBlock) bool {
+	if planningTools[block.Name] {
+		return true
+	}
+	commands, ok := planningCommands[block.Name]
+	if !ok {
+		return false
+	}
+	var input struct {
+		Command string `json:"command"`
+	}
+	return json.Unmarshal(block.Input, &input) == nil && slices.Contains(commands, input.Command)
+}
+
 // ToolRegistry manages all available tools
 type ToolRegistry struct {
-	tools map[string]AnthropicTool
+	tools  map[string]AnthropicTool
+	filter ToolFilter
+	// disabled holds the registered tools that the filter excludes. They can't be called, but calls made while they were
+	// enabled are still replayed
+	disabled map[string]AnthropicTool
 }
 
-// NewToolRegistry creates a new tool registry with all available tools
-func NewToolRegistry() *ToolRegistry {
+// NewToolRegistry creates a new tool registry with all available tools that pass the given filter
+func NewToolRegistry(filter ToolFilter) *ToolRegistry {
 	registry := &ToolRegistry{
-		tools: make(map[string]AnthropicTool),
+		tools:    make(map[string]AnthropicTool),
+		filter:   filter,
+		disabled: make(map[string]AnthropicTool),
 	}
 
 	// Register all tools
 	registry.Register(NewTextEditorTool())
+	registry.Register(NewWriteFileTool())
+	registry.Register(NewApplyPatchTool())
 	registry.Register(NewDeleteFileTool())
+	registry.Register(NewReadFilesTool())
+	registry.Register(NewListTreeTool())
+	registry.Register(NewViewDiffTool())
+	registry.Register(NewViewPRDiffTool())
+	registry.Register(NewGitLogTool())
+	registry.Register(NewViewFileAtRefTool())
+	registry.Register(NewBlameTool())
+	registry.Register(NewFindSymbolTool())
+	registry.Register(NewManageLabelsTool())
+	registry.Register(NewListAssigneesTool())
+	registry.Register(NewManageAssigneesTool())
+	registry.Register(NewSubmitReviewTool())
+	registry.Register(NewCreateReviewWithCommentsTool())
+	registry.Register(NewRequestReviewersTool())
+	registry.Register(NewCloseIssueTool())
+	registry.Register(NewReopenIssueTool())
+	registry.Register(NewListOpenItemsTool())
+	registry.Register(NewGetIssueTimelineTool())
+	registry.Register(NewLinkIssueTool())
+	registry.Register(NewCreateIssueTool())
 	registry.Register(NewPostCommentTool())
 	registry.Register(NewAddReactionTool())
+	registry.Register(NewGetPendingResponsesTool())
+	registry.Register(NewResolveReviewThreadTool())
 	registry.Register(NewValidateChangesTool())
+	registry.Register(NewGetValidationStatusTool())
+	registry.Register(NewGetCoverageTool())
+	registry.Register(NewRunTestsTool())
 	registry.Register(NewPublishChangesForReviewTool())
+	registry.Register(NewMarkPRReadyTool())
+	registry.Register(NewUpdatePRDescriptionTool())
+	registry.Register(NewRetargetPRTool())
+	registry.Register(NewGetCheckRunsTool())
 	registry.Register(NewReportLimitationTool())
+	registry.Register(NewAwaitHumanInputTool())
+	registry.Register(NewPostPlanTool())
+	registry.Register(NewConcludeTool())
+	registry.Register(NewIgnoreIssueTool())
 
 	return registry
 }
 
-// Register adds a tool to the registry
+// Register adds a tool to the registry. Tools excluded by the registry's filter are recorded as disabled
 func (r *ToolRegistry) Register(tool AnthropicTool) {
 	param := tool.GetToolParam()
+	if !r.filter.allows(param.Name) {
+		r.disabled[param.Name] = tool
+		return
+	}
 	r.tools[param.Name] = tool
 }
 
+// UnknownFilterNames returns the names in the registry's filter that don't match any registered tool, e.g. because of
+// a typo
+func (r *ToolRegistry) UnknownFilterNames() []string {
+	var unknown []string
+	for _, name := range slices.Concat(r.filter.Enabled, r.filter.Disabled) {
+		_, enabled := r.tools[name]
+		_, disabled := r.disabled[name]
+		if !enabled && !disabled && !slices.Contains(unknown, name) {
+			unknown = append(unknown, name)
+		}
+	}
+	return unknown
+}
+
 // GetTool retrieves a tool by name
 func (r *ToolRegistry) GetTool(name string) (AnthropicTool, bool) {
 	tool, ok := r.tools[name]
@@ -937,19 +5804,46 @@ func (r *ToolRegistry) GetAllToolParams() []anthropic.ToolParam {
 
 // ProcessToolUse processes a tool use block with the appropriate tool
 func (r *ToolRegistry) ProcessToolUse(ctx context.Context, block anthropic.ToolUseBlock, toolCtx *ToolContext) (*anthropic.ToolResultBlockParam, error) {
+	if _, disabled := r.disabled[block.Name]; disabled {
+		// The AI shouldn't call tools that it wasn't offered, but tell it so rather than failing the task
+		logging.FromContext(ctx).Warn("AI called a disabled tool", "tool", block.Name)
+		result := newToolResultBlockParam(block.ID, disabledToolMessage(block.Name), true)
+		return &result, nil
+	}
 	tool, ok := r.GetTool(block.Name)
 	if !ok {
 		return nil, fmt.Errorf("unknown tool: %s", block.Name)
 	}
+	if toolCtx.Task.NeedsPlanApproval() && !isAllowedWhilePlanning(block) {
+		// Planning tasks aren't offered these tools, or are only offered their read-only commands, but the tools may
+		// have been offered earlier in a resumed conversation
+		logging.FromContext(ctx).Warn("AI called a tool that isn't allowed before its plan is approved", "tool", block.Name)
+		msg := fmt.Sprintf("The %s tool cannot be used until your plan is approved", block.Name)
+		if commands, ok := planningCommands[block.Name]; ok {
+			msg = fmt.Sprintf("Only the %s command(s) of the %s tool can be used until your plan is approved",
+				strings.Join(commands, ", "), block.Name)
+		}
+		result := newToolResultBlockParam(block.ID, msg+". Propose a plan with the post_plan tool", true)
+		return &result, nil
+	}
+
+	if toolCtx.DryRun && tool.IsMutating() {
+		logging.FromContext(ctx).Info("Dry run: skipping tool", "tool", block.Name, "input", string(block.Input))
+		result := newToolResultBlockParam(block.ID, fmt.Sprintf("Dry run: %s was not executed. Continue as if it succeeded", block.Name), false)
+		return &result, nil
+	}
 
+	start := time.Now()
 	response, err := tool.Run(ctx, block, toolCtx)
+	recordToolCall(toolCtx.Metrics, block.Name, time.Since(start), err)
 
 	var resultBlock anthropic.ToolResultBlockParam
 	var tie ToolInputError
 	if errors.As(err, &tie) {
 		// Respond to with an error result block to give the AI the opportunity to correct the inputs
 		resultBlock = newToolResultBlockParam(block.ID, tie.Error(), true)
-		log.Print("Warning: recoverable tool error, reporting to the AI to give it an opportunity to retry")
+		logging.FromContext(ctx).Warn("recoverable tool error, reporting to the AI to give it an opportunity to retry",
+			"tool", block.Name, "error", err)
 	} else if err != nil {
 		return nil, fmt.Errorf("error while running tool: %w", err)
 	} else if response != nil {
@@ -960,13 +5854,43 @@ func (r *ToolRegistry) ProcessToolUse(ctx context.Context, block anthropic.ToolU
 	return &resultBlock, nil
 }
 
-// ReplayToolUse replays a tool use block with the appropriate tool
-func (r *ToolRegistry) ReplayToolUse(ctx context.Context, toolUseBlock anthropic.ToolUseBlock, toolCtx *ToolContext) error {
+// recordToolCall records a tool call's outcome and latency, if m is non-nil
+func recordToolCall(m metrics.Metrics, toolName string, latency time.Duration, err error) {
+	if m == nil {
+		return
+	}
+	outcome := "success"
+	if errors.As(err, &ToolInputError{}) {
+		outcome = "input_error"
+	} else if err != nil {
+		outcome = "error"
+	}
+	m.IncCounter(metrics.ToolCalls, metrics.Labels{"tool": toolName, "outcome": outcome})
+	m.ObserveHistogram(metrics.ToolLatency, latency.Seconds(), metrics.Labels{"tool": toolName})
+}
+
+// ReplayToolUse replays the tool use block of a completed exchange with the appropriate tool. Tools are replayed whether
+// or not they are currently enabled, since a tool may have been disabled after it was called, unless the call was
+// refused because the tool was disabled at the time
+func (r *ToolRegistry) ReplayToolUse(ctx context.Context, exchange ai.ToolExchange, toolCtx *ToolContext) error {
+	toolUseBlock := exchange.UseBlock
+	if isDisabledToolRefusal(toolUseBlock.Name, exchange.ResultBlock) {
+		// The original call did nothing, so there is nothing to replay
+		return nil
+	}
 	tool, ok := r.GetTool(toolUseBlock.Name)
+	if !ok {
+		tool, ok = r.disabled[toolUseBlock.Name]
+	}
 	if !ok {
 		return fmt.Errorf("unknown tool: %s", toolUseBlock.Name)
 	}
 
+	if toolCtx.DryRun && tool.IsMutating() {
+		// The original call was skipped, so there is nothing to replay
+		return nil
+	}
+
 	err := tool.Replay(ctx, toolUseBlock, toolCtx)
 
 	var tie ToolInputError
@@ -983,6 +5907,20 @@ func (r *ToolRegistry) ReplayToolUse(ctx context.Context, toolUseBlock anthropic
 	return nil
 }
 
+// disabledToolMessage returns the result given to the AI when it calls the disabled tool with the given name
+func disabledToolMessage(name string) string {
+	return fmt.Sprintf("The %s tool is disabled and cannot be used", name)
+}
+
+// isDisabledToolRefusal reports whether the given result is the refusal of a call to the tool with the given name
+// because the tool was disabled
+func isDisabledToolRefusal(name string, result *anthropic.ToolResultBlockParam) bool {
+	if result == nil || !result.IsError.Value || len(result.Content) != 1 || result.Content[0].OfText == nil {
+		return false
+	}
+	return result.Content[0].OfText.Text == disabledToolMessage(name)
+}
+
 // Helper function to create a ToolResultBlockParam, in contrast to anthropic.NewToolResultBlockParam which creates a
 // ContentBlockParamUnion
 func newToolResultBlockParam(toolID string, result string, isError bool) anthropic.ToolResultBlockParam {
@@ -1,9 +1,30 @@
 package bot
 
 import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
 	"testing"
+	"unicode/utf8"
 
 	"github.com/anthropics/anthropic-sdk-go"
+	"github.com/cchalm/blundering-savant/internal/ai"
+	"github.com/cchalm/blundering-savant/internal/metrics"
+	"github.com/cchalm/blundering-savant/internal/task"
+	"github.com/cchalm/blundering-savant/internal/validator"
+	"github.com/cchalm/blundering-savant/internal/vcs"
+	"github.com/cchalm/blundering-savant/internal/workspace"
+	"github.com/google/go-github/v72/github"
+	"github.com/stretchr/testify/require"
 )
 
 func testDeleteFileToolParseInput(t *testing.T, inputJSON []byte, wantError bool) {
@@ -33,3 +54,3121 @@ func TestDeleteFileTool_ParseInput_InvalidJSON(t *testing.T) {
 	invalidJSON := []byte(`{"path": "test.txt"`) // Missing closing brace
 	testDeleteFileToolParseInput(t, invalidJSON, true)
 }
+
+// newTestGithubClient returns a GitHub client that sends all requests to the given handler
+func newTestGithubClient(t *testing.T, handler http.Handler) *github.Client {
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+
+	client := github.NewClient(nil)
+	baseURL, err := url.Parse(server.URL + "/")
+	require.NoError(t, err)
+	client.BaseURL = baseURL
+	return client
+}
+
+// labelRequest records a label-related request received by the fake GitHub server
+type labelRequest struct {
+	method string
+	path   string
+	body   string
+}
+
+func testManageLabelsTool(t *testing.T, inputJSON string) (*string, []labelRequest, error) {
+	var requests []labelRequest
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		requests = append(requests, labelRequest{method: r.Method, path: r.URL.Path, body: string(body)})
+
+		if r.Method == http.MethodDelete && strings.HasSuffix(r.URL.Path, "/missing") {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`[]`))
+	})
+
+	toolCtx := &ToolContext{
+		Task: task.Task{Issue: task.GithubIssue{Owner: "owner", Repo: "repo", Number: 7}},
+		VCS:  vcs.NewGithubProvider(newTestGithubClient(t, handler)),
+	}
+	block := anthropic.ToolUseBlock{
+		ID:    "test",
+		Name:  "manage_labels",
+		Input: json.RawMessage(inputJSON),
+	}
+
+	result, err := NewManageLabelsTool().Run(context.Background(), block, toolCtx)
+	return result, requests, err
+}
+
+func TestManageLabelsTool_Add(t *testing.T) {
+	result, requests, err := testManageLabelsTool(t, `{"add": ["bug", "needs-info"]}`)
+	require.NoError(t, err)
+	require.NotNil(t, result)
+	require.Contains(t, *result, "bug, needs-info")
+
+	require.Len(t, requests, 1)
+	require.Equal(t, http.MethodPost, requests[0].method)
+	require.Equal(t, "/repos/owner/repo/issues/7/labels", requests[0].path)
+	require.JSONEq(t, `["bug", "needs-info"]`, requests[0].body)
+}
+
+func TestManageLabelsTool_Remove(t *testing.T) {
+	result, requests, err := testManageLabelsTool(t, `{"remove": ["bug", "missing"]}`)
+	require.NoError(t, err)
+	require.NotNil(t, result)
+	require.Contains(t, *result, "Removed label: bug")
+	require.Contains(t, *result, "'missing' was not on the issue")
+
+	require.Len(t, requests, 2)
+	require.Equal(t, http.MethodDelete, requests[0].method)
+	require.Equal(t, "/repos/owner/repo/issues/7/labels/bug", requests[0].path)
+	require.Equal(t, "/repos/owner/repo/issues/7/labels/missing", requests[1].path)
+}
+
+func TestManageLabelsTool_RejectsInternalLabel(t *testing.T) {
+	_, requests, err := testManageLabelsTool(t, `{"add": ["bug"], "remove": ["Bot-Turn"]}`)
+	require.Error(t, err)
+	require.ErrorAs(t, err, &ToolInputError{})
+	require.Empty(t, requests, "no labels should be changed if any label is rejected")
+}
+
+// assigneesHandler fakes GitHub's assignee endpoints for issue 7, to which "bot" and "bob" are initially assigned.
+// Everyone but "stranger" can be assigned. Records the requests that change the assignees
+func assigneesHandler(t *testing.T, changes *[]labelRequest) http.Handler {
+	assignees := []string{"bot", "bob"}
+	writeIssue := func(w http.ResponseWriter) {
+		var users []map[string]string
+		for _, login := range assignees {
+			users = append(users, map[string]string{"login": login})
+		}
+		require.NoError(t, json.NewEncoder(w).Encode(map[string]any{"number": 7, "assignees": users}))
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/repos/owner/repo/assignees/stranger":
+			w.WriteHeader(http.StatusNotFound)
+		case r.Method == http.MethodGet && strings.HasPrefix(r.URL.Path, "/repos/owner/repo/assignees/"):
+			w.WriteHeader(http.StatusNoContent)
+		case r.URL.Path == "/repos/owner/repo/issues/7/assignees":
+			body, err := io.ReadAll(r.Body)
+			require.NoError(t, err)
+			*changes = append(*changes, labelRequest{method: r.Method, path: r.URL.Path, body: string(body)})
+
+			var request struct{ Assignees []string }
+			require.NoError(t, json.Unmarshal(body, &request))
+			for _, login := range request.Assignees {
+				assignees = slices.DeleteFunc(assignees, func(a string) bool { return a == login })
+				if r.Method == http.MethodPost {
+					assignees = append(assignees, login)
+				}
+			}
+			writeIssue(w)
+		case r.Method == http.MethodGet && r.URL.Path == "/repos/owner/repo/issues/7":
+			writeIssue(w)
+		case r.Method == http.MethodPost && strings.HasSuffix(r.URL.Path, "/labels"):
+			_, _ = w.Write([]byte(`[]`))
+		default:
+			_, _ = w.Write([]byte(`{}`))
+		}
+	})
+}
+
+func testAssigneesTool(t *testing.T, tool AnthropicTool, inputJSON string) (*string, []labelRequest, error) {
+	var changes []labelRequest
+	githubClient := newTestGithubClient(t, assigneesHandler(t, &changes))
+	toolCtx := &ToolContext{
+		Task:         task.Task{Issue: task.GithubIssue{Owner: "owner", Repo: "repo", Number: 7}},
+		GithubClient: githubClient,
+		VCS:          vcs.NewGithubProvider(githubClient),
+		BotUser:      &github.User{Login: github.Ptr("bot")},
+	}
+	block := anthropic.ToolUseBlock{
+		ID:    "test",
+		Name:  tool.GetToolParam().Name,
+		Input: json.RawMessage(inputJSON),
+	}
+
+	result, err := tool.Run(context.Background(), block, toolCtx)
+	return result, changes, err
+}
+
+func testManageAssigneesTool(t *testing.T, inputJSON string) (*string, []labelRequest, error) {
+	return testAssigneesTool(t, NewManageAssigneesTool(), inputJSON)
+}
+
+func TestManageAssigneesTool_Add(t *testing.T) {
+	result, changes, err := testManageAssigneesTool(t, `{"add": ["alice"]}`)
+	require.NoError(t, err)
+	require.Equal(t, "Issue #7 is assigned to: bot, bob, alice", *result)
+	require.Len(t, changes, 1)
+	require.Equal(t, http.MethodPost, changes[0].method)
+	require.JSONEq(t, `{"assignees": ["alice"]}`, changes[0].body)
+}
+
+func TestManageAssigneesTool_Remove(t *testing.T) {
+	result, changes, err := testManageAssigneesTool(t, `{"remove": ["bob"]}`)
+	require.NoError(t, err)
+	require.Equal(t, "Issue #7 is assigned to: bot", *result)
+	require.Len(t, changes, 1)
+	require.Equal(t, http.MethodDelete, changes[0].method)
+	require.JSONEq(t, `{"assignees": ["bob"]}`, changes[0].body)
+}
+
+func TestManageAssigneesTool_RejectsEmptyInput(t *testing.T) {
+	_, changes, err := testManageAssigneesTool(t, `{}`)
+	require.ErrorAs(t, err, &ToolInputError{})
+	require.ErrorContains(t, err, "list_assignees")
+	require.Empty(t, changes)
+}
+
+func TestListAssigneesTool(t *testing.T) {
+	result, changes, err := testAssigneesTool(t, NewListAssigneesTool(), `{}`)
+	require.NoError(t, err)
+	require.Equal(t, "Issue #7 is assigned to: bot, bob", *result)
+	require.Empty(t, changes)
+	require.False(t, NewListAssigneesTool().IsMutating(), "listing assignees should be allowed in dry-run mode")
+}
+
+func TestManageAssigneesTool_RejectsNonAssignableUser(t *testing.T) {
+	_, changes, err := testManageAssigneesTool(t, `{"add": ["alice", "stranger"], "remove": ["bob"]}`)
+	require.ErrorAs(t, err, &ToolInputError{})
+	require.ErrorContains(t, err, "stranger cannot be assigned")
+	require.Empty(t, changes, "no assignees should be changed if any user is rejected")
+}
+
+func TestManageAssigneesTool_RejectsUnassigningSelf(t *testing.T) {
+	_, changes, err := testManageAssigneesTool(t, `{"remove": ["Bot"]}`)
+	require.ErrorAs(t, err, &ToolInputError{})
+	require.Empty(t, changes)
+}
+
+func TestManageLabelsTool_RejectsEmptyInput(t *testing.T) {
+	_, requests, err := testManageLabelsTool(t, `{}`)
+	require.Error(t, err)
+	require.ErrorAs(t, err, &ToolInputError{})
+	require.Empty(t, requests)
+}
+
+func testReadFilesTool(t *testing.T, files map[string]string, paths []string) (*string, error) {
+	inputJSON, err := json.Marshal(ReadFilesInput{Paths: paths})
+	require.NoError(t, err)
+
+	toolCtx := &ToolContext{Workspace: fakeWorkspace{files: files}}
+	block := anthropic.ToolUseBlock{
+		ID:    "test",
+		Name:  "read_multiple_files",
+		Input: inputJSON,
+	}
+	return NewReadFilesTool().Run(context.Background(), block, toolCtx)
+}
+
+func TestReadFilesTool_MultipleFiles(t *testing.T) {
+	files := map[string]string{
+		"a.go": "package a",
+		"b.go": "package b\n\nfunc B() {}",
+	}
+
+	result, err := testReadFilesTool(t, files, []string{"a.go", "b.go"})
+	require.NoError(t, err)
+	expected := "==> a.go <==\n" +
+		"1: package a\n" +
+		"\n" +
+		"==> b.go <==\n" +
+		"1: package b\n" +
+		"2: \n" +
+		"3: func B() {}\n" +
+		"\n"
+	require.Equal(t, expected, *result)
+}
+
+func TestReadFilesTool_MissingFile(t *testing.T) {
+	files := map[string]string{"a.go": "package a"}
+
+	result, err := testReadFilesTool(t, files, []string{"missing.go", "a.go"})
+	require.NoError(t, err)
+	require.Contains(t, *result, "==> missing.go <==\nError: file not found\n")
+	require.Contains(t, *result, "==> a.go <==\n1: package a\n")
+}
+
+func TestReadFilesTool_TooManyPaths(t *testing.T) {
+	paths := make([]string, maxReadFilesPaths+1)
+	for i := range paths {
+		paths[i] = fmt.Sprintf("file%d.go", i)
+	}
+
+	_, err := testReadFilesTool(t, map[string]string{}, paths)
+	require.Error(t, err)
+	require.ErrorAs(t, err, &ToolInputError{})
+}
+
+func TestReadFilesTool_NoPaths(t *testing.T) {
+	_, err := testReadFilesTool(t, map[string]string{}, nil)
+	require.Error(t, err)
+	require.ErrorAs(t, err, &ToolInputError{})
+}
+
+func testView(t *testing.T, content string, viewRange []int) string {
+	inputJSON, err := json.Marshal(TextEditorInput{Command: "view", Path: "file.txt", ViewRange: viewRange})
+	require.NoError(t, err)
+
+	block := anthropic.ToolUseBlock{
+		ID:    "test",
+		Name:  "str_replace_based_edit_tool",
+		Input: inputJSON,
+	}
+	toolCtx := &ToolContext{Workspace: fakeWorkspace{files: map[string]string{"file.txt": content}}}
+	result, err := NewTextEditorTool().Run(context.Background(), block, toolCtx)
+	require.NoError(t, err)
+	return *result
+}
+
+// numberedLines returns n lines of the form "line <i>"
+func numberedLines(n int) string {
+	lines := make([]string, n)
+	for i := range lines {
+		lines[i] = fmt.Sprintf("line %d", i+1)
+	}
+	return strings.Join(lines, "\n")
+}
+
+func TestTextEditorTool_ViewSmallFile(t *testing.T) {
+	result := testView(t, "a\nb", nil)
+	require.Equal(t, "1: a\n2: b\n", result)
+}
+
+func TestTextEditorTool_ViewLargeFileTruncated(t *testing.T) {
+	result := testView(t, numberedLines(maxViewLines+50), nil)
+
+	require.Contains(t, result, fmt.Sprintf("%d: line %d\n", maxViewLines, maxViewLines))
+	require.NotContains(t, result, fmt.Sprintf("%d: line %d\n", maxViewLines+1, maxViewLines+1))
+	require.Contains(t, result, fmt.Sprintf("showing lines 1-%d of %d, 50 more lines not shown", maxViewLines, maxViewLines+50))
+}
+
+func TestTextEditorTool_ViewFileWithLongLinesTruncated(t *testing.T) {
+	line := strings.Repeat("x", maxViewBytes/4)
+	result := testView(t, strings.Repeat(line+"\n", 10), nil)
+
+	require.Less(t, len(result), maxViewBytes+1000)
+	require.Contains(t, result, "3: "+line+"\n")
+	require.NotContains(t, result, "4: ")
+	require.Contains(t, result, "showing lines 1-3 of 11, 8 more lines not shown")
+}
+
+func TestTextEditorTool_ViewSingleHugeLineCut(t *testing.T) {
+	result := testView(t, strings.Repeat("x", maxViewBytes+10), nil)
+
+	require.Equal(t, "1: "+strings.Repeat("x", maxViewBytes)+" [File truncated: 10 more bytes of this line not shown]\n", result)
+}
+
+func TestTextEditorTool_ViewRangeWithLongLinesTruncated(t *testing.T) {
+	line := strings.Repeat("x", maxViewBytes/4)
+	result := testView(t, strings.Repeat(line+"\n", 10), []int{2, 9})
+
+	require.Contains(t, result, "4: "+line+"\n")
+	require.NotContains(t, result, "5: ")
+	require.Contains(t, result, "showing lines 2-4. Use view_range to view the rest, e.g. [5, 9]")
+}
+
+func TestTextEditorTool_ViewRangeOfLargeFile(t *testing.T) {
+	result := testView(t, numberedLines(maxViewLines+50), []int{maxViewLines + 1, maxViewLines + 2})
+
+	expected := fmt.Sprintf("%[1]d: line %[1]d\n%[2]d: line %[2]d\n", maxViewLines+1, maxViewLines+2)
+	require.Equal(t, expected, result)
+}
+
+func TestTextEditorTool_ViewRangeShowsEnclosingDeclaration(t *testing.T) {
+	inputJSON, err := json.Marshal(TextEditorInput{Command: "view", Path: "main.py", ViewRange: []int{3, 3}})
+	require.NoError(t, err)
+	block := anthropic.ToolUseBlock{ID: "test", Name: "str_replace_based_edit_tool", Input: inputJSON}
+	toolCtx := &ToolContext{Workspace: fakeWorkspace{files: map[string]string{
+		"main.py": "def main():\n    x = 1\n    return x\n",
+	}}}
+
+	result, err := NewTextEditorTool().Run(context.Background(), block, toolCtx)
+	require.NoError(t, err)
+	require.Equal(t, "[Line 3 is inside `def main()`, declared at line 1]\n3:     return x\n", *result)
+}
+
+// memWorkspace tracks changes in-memory on top of a base file system, as real workspaces do
+type memWorkspace struct {
+	Workspace
+
+	fs workspace.FileSystem
+}
+
+func (mw memWorkspace) IsDir(ctx context.Context, path string) (bool, error) {
+	return mw.fs.IsDir(ctx, path)
+}
+
+func (mw memWorkspace) ListDir(ctx context.Context, dir string) ([]string, error) {
+	return mw.fs.ListDir(ctx, dir)
+}
+
+func testListTreeTool(t *testing.T, fs workspace.FileSystem, inputJSON string) (string, error) {
+	block := anthropic.ToolUseBlock{
+		ID:    "test",
+		Name:  "list_directory_tree",
+		Input: json.RawMessage(inputJSON),
+	}
+	result, err := NewListTreeTool().Run(context.Background(), block, &ToolContext{Workspace: memWorkspace{fs: fs}})
+	if err != nil {
+		return "", err
+	}
+	return *result, nil
+}
+
+var listTreeFiles = map[string]string{
+	"README.md":             "",
+	"cmd/main.go":           "",
+	"internal/a/a.go":       "",
+	"internal/a/deep/d.go":  "",
+	"internal/b/b.go":       "",
+	"internal/b/b_test.go":  "",
+	"internal/b/testdata/x": "",
+}
+
+func TestListTreeTool_MaxDepth(t *testing.T) {
+	fs := workspace.NewMemDiffFileSystem(fakeWorkspace{files: listTreeFiles})
+
+	result, err := testListTreeTool(t, &fs, `{"path": "", "max_depth": 2}`)
+	require.NoError(t, err)
+	expected := "./\n" +
+		"  README.md\n" +
+		"  cmd/\n" +
+		"    main.go\n" +
+		"  internal/\n" +
+		"    a/\n" +
+		"    b/\n"
+	require.Equal(t, expected, result)
+}
+
+func TestListTreeTool_NewFile(t *testing.T) {
+	fs := workspace.NewMemDiffFileSystem(fakeWorkspace{files: listTreeFiles})
+	require.NoError(t, fs.Write(context.Background(), "internal/a/new/new.go", "package new"))
+
+	result, err := testListTreeTool(t, &fs, `{"path": "internal/a"}`)
+	require.NoError(t, err)
+	expected := "internal/a/\n" +
+		"  a.go\n" +
+		"  deep/\n" +
+		"    d.go\n" +
+		"  new/\n" +
+		"    new.go\n"
+	require.Equal(t, expected, result)
+}
+
+func TestListTreeTool_DeletedFile(t *testing.T) {
+	fs := workspace.NewMemDiffFileSystem(fakeWorkspace{files: listTreeFiles})
+	require.NoError(t, fs.Delete(context.Background(), "internal/b/b_test.go"))
+
+	result, err := testListTreeTool(t, &fs, `{"path": "internal/b"}`)
+	require.NoError(t, err)
+	expected := "internal/b/\n" +
+		"  b.go\n" +
+		"  testdata/\n" +
+		"    x\n"
+	require.Equal(t, expected, result)
+}
+
+func TestListTreeTool_NotADirectory(t *testing.T) {
+	fs := workspace.NewMemDiffFileSystem(fakeWorkspace{files: listTreeFiles})
+
+	_, err := testListTreeTool(t, &fs, `{"path": "README.md"}`)
+	require.ErrorAs(t, err, &ToolInputError{})
+}
+
+func testStrReplace(t *testing.T, content string, oldStr string, newStr string) (string, error) {
+	return testStrReplaceOccurrence(t, content, oldStr, newStr, 0)
+}
+
+func testStrReplaceOccurrence(t *testing.T, content string, oldStr string, newStr string, occurrence int) (string, error) {
+	files := map[string]string{"file.go": content}
+	input := TextEditorInput{Command: "str_replace", Path: "file.go", OldStr: oldStr, NewStr: newStr, Occurrence: occurrence}
+	inputJSON, err := json.Marshal(input)
+	require.NoError(t, err)
+
+	block := anthropic.ToolUseBlock{
+		ID:    "test",
+		Name:  "str_replace_based_edit_tool",
+		Input: inputJSON,
+	}
+	_, err = NewTextEditorTool().Run(context.Background(), block, &ToolContext{Workspace: fakeWorkspace{files: files}})
+	return files["file.go"], err
+}
+
+func TestTextEditorTool_StrReplaceExactMatch(t *testing.T) {
+	content := "func a() {\n\treturn 1\n}\n"
+	result, err := testStrReplace(t, content, "\treturn 1", "\treturn 2")
+	require.NoError(t, err)
+	require.Equal(t, "func a() {\n\treturn 2\n}\n", result)
+}
+
+func TestTextEditorTool_StrReplaceWhitespaceNearMatch(t *testing.T) {
+	content := "func a() {\n\tif x {\n\t\treturn  1\n\t}\n}\n"
+	// Spaces instead of tabs, and a single space where the file has two
+	result, err := testStrReplace(t, content, "if x {\n    return 1\n}", "if y {}")
+	require.Error(t, err)
+	require.ErrorAs(t, err, &ToolInputError{})
+	require.Contains(t, err.Error(), "line 2")
+	require.Contains(t, err.Error(), "<<<\nif x {\n\t\treturn  1\n\t}\n>>>")
+	require.Equal(t, content, result, "file should not be modified")
+}
+
+func TestTextEditorTool_StrReplaceMultipleNearMatches(t *testing.T) {
+	content := "a  =  1\nb = 2\na = 1\n"
+	_, err := testStrReplace(t, content, "a =\t1", "a = 3")
+	require.Error(t, err)
+	require.ErrorAs(t, err, &ToolInputError{})
+	require.Contains(t, err.Error(), "lines 1, 3")
+}
+
+func TestTextEditorTool_StrReplaceNoMatch(t *testing.T) {
+	_, err := testStrReplace(t, "a = 1\n", "b = 1", "b = 2")
+	require.Error(t, err)
+	require.ErrorAs(t, err, &ToolInputError{})
+	require.NotContains(t, err.Error(), "whitespace")
+}
+
+func TestTextEditorTool_StrReplaceAmbiguous(t *testing.T) {
+	content := "x = 1\ny = 2\nx = 1\nx = 1\n"
+	result, err := testStrReplace(t, content, "x = 1", "x = 3")
+	require.ErrorAs(t, err, &ToolInputError{})
+	require.Contains(t, err.Error(), "lines 1, 3, 4")
+	require.Contains(t, err.Error(), "occurrence")
+	require.Equal(t, content, result, "file should not be modified")
+}
+
+func TestTextEditorTool_StrReplaceOccurrence(t *testing.T) {
+	result, err := testStrReplaceOccurrence(t, "x = 1\ny = 2\nx = 1\nx = 1\n", "x = 1", "x = 3", 2)
+	require.NoError(t, err)
+	require.Equal(t, "x = 1\ny = 2\nx = 3\nx = 1\n", result)
+}
+
+func TestTextEditorTool_StrReplaceOccurrenceOutOfRange(t *testing.T) {
+	content := "x = 1\ny = 2\nx = 1\nx = 1\n"
+	result, err := testStrReplaceOccurrence(t, content, "x = 1", "x = 3", 4)
+	require.ErrorAs(t, err, &ToolInputError{})
+	require.Contains(t, err.Error(), "out of range")
+	require.Equal(t, content, result, "file should not be modified")
+}
+
+// testTextEditorFileSize runs a text editor command against a file containing "hello" with a 10-byte file size limit,
+// and returns the resulting files
+func testTextEditorFileSize(t *testing.T, input TextEditorInput) (map[string]string, error) {
+	files := map[string]string{"file.txt": "hello"}
+	inputJSON, err := json.Marshal(input)
+	require.NoError(t, err)
+
+	block := anthropic.ToolUseBlock{
+		ID:    "test",
+		Name:  "str_replace_based_edit_tool",
+		Input: inputJSON,
+	}
+	toolCtx := &ToolContext{Workspace: fakeWorkspace{files: files}, MaxFileBytes: 10}
+	_, err = NewTextEditorTool().Run(context.Background(), block, toolCtx)
+	return files, err
+}
+
+func TestTextEditorTool_WritesUnderFileSizeLimit(t *testing.T) {
+	files, err := testTextEditorFileSize(t, TextEditorInput{Command: "create", Path: "new.txt", FileText: "0123456789"})
+	require.NoError(t, err)
+	require.Equal(t, "0123456789", files["new.txt"])
+
+	files, err = testTextEditorFileSize(t, TextEditorInput{Command: "str_replace", Path: "file.txt", OldStr: "hello", NewStr: "hello, you"})
+	require.NoError(t, err)
+	require.Equal(t, "hello, you", files["file.txt"])
+
+	files, err = testTextEditorFileSize(t, TextEditorInput{Command: "insert", Path: "file.txt", InsertLine: 1, NewStr: "hey"})
+	require.NoError(t, err)
+	require.Equal(t, "hello\nhey", files["file.txt"])
+}
+
+func TestTextEditorTool_CreateOverFileSizeLimit(t *testing.T) {
+	files, err := testTextEditorFileSize(t, TextEditorInput{Command: "create", Path: "new.txt", FileText: "0123456789a"})
+	require.ErrorAs(t, err, &ToolInputError{})
+	require.Contains(t, err.Error(), "new.txt would be 11 bytes, more than the limit of 10 bytes")
+	require.NotContains(t, files, "new.txt", "file should not be created")
+}
+
+func TestTextEditorTool_StrReplaceOverFileSizeLimit(t *testing.T) {
+	files, err := testTextEditorFileSize(t, TextEditorInput{Command: "str_replace", Path: "file.txt", OldStr: "hello", NewStr: "hello, world"})
+	require.ErrorAs(t, err, &ToolInputError{})
+	require.Equal(t, "hello", files["file.txt"], "file should not be modified")
+}
+
+func TestTextEditorTool_InsertOverFileSizeLimit(t *testing.T) {
+	files, err := testTextEditorFileSize(t, TextEditorInput{Command: "insert", Path: "file.txt", InsertLine: 1, NewStr: "world"})
+	require.ErrorAs(t, err, &ToolInputError{})
+	require.Equal(t, "hello", files["file.txt"], "file should not be modified")
+}
+
+// protectedPathWrites are calls to each of the tools that write files, which all try to change vendor/lib/lib.go
+var protectedPathWrites = []struct {
+	tool  AnthropicTool
+	input string
+}{
+	{NewTextEditorTool(), `{"command": "str_replace", "path": "vendor/lib/lib.go", "old_str": "old", "new_str": "new"}`},
+	{NewTextEditorTool(), `{"command": "insert", "path": "vendor/lib/lib.go", "insert_line": 1, "new_str": "new"}`},
+	{NewTextEditorTool(), `{"command": "create", "path": "vendor/lib/lib.go", "file_text": "new"}`},
+	{NewWriteFileTool(), `{"path": "vendor/lib/lib.go", "content": "new", "overwrite": true}`},
+	{NewApplyPatchTool(), `{"patch": "--- a/vendor/lib/lib.go\n+++ b/vendor/lib/lib.go\n@@ -1 +1 @@\n-old\n+new\n"}`},
+	{NewDeleteFileTool(), `{"path": "vendor/lib/lib.go"}`},
+}
+
+// testProtectedPathWrite runs the given write against a vendored file containing "old", with the given protected
+// paths, and returns the resulting files
+func testProtectedPathWrite(t *testing.T, tool AnthropicTool, input string, protectedPaths task.ProtectedPaths) (map[string]string, error) {
+	files := map[string]string{"vendor/lib/lib.go": "old"}
+	if strings.Contains(input, `"create"`) {
+		delete(files, "vendor/lib/lib.go")
+	}
+	block := anthropic.ToolUseBlock{ID: "test", Name: tool.GetToolParam().Name, Input: json.RawMessage(input)}
+	toolCtx := &ToolContext{Workspace: fakeWorkspace{files: files}, ProtectedPaths: protectedPaths}
+	_, err := tool.Run(context.Background(), block, toolCtx)
+	return files, err
+}
+
+func TestWriteTools_RejectProtectedPathsByDefault(t *testing.T) {
+	for _, write := range protectedPathWrites {
+		files, err := testProtectedPathWrite(t, write.tool, write.input, task.DefaultProtectedPaths)
+		require.ErrorAs(t, err, &ToolInputError{}, write.input)
+		require.Contains(t, err.Error(), "vendor/lib/lib.go is protected by the pattern '**/vendor/**'", write.input)
+		if strings.Contains(write.input, `"create"`) {
+			require.NotContains(t, files, "vendor/lib/lib.go", "file should not be created")
+		} else {
+			require.Equal(t, "old", files["vendor/lib/lib.go"], "file should not be modified: %s", write.input)
+		}
+	}
+}
+
+func TestWriteTools_AllowPathsThatAreNoLongerProtected(t *testing.T) {
+	for _, write := range protectedPathWrites {
+		// As when a repository's config sets protected_paths to an empty list
+		files, err := testProtectedPathWrite(t, write.tool, write.input, task.ProtectedPaths{})
+		require.NoError(t, err, write.input)
+		require.NotEqual(t, "old", files["vendor/lib/lib.go"], "file should be changed: %s", write.input)
+	}
+}
+
+func TestTextEditorTool_ReplayIgnoresProtectedPaths(t *testing.T) {
+	files := map[string]string{"vendor/lib/lib.go": "old"}
+	block := anthropic.ToolUseBlock{
+		ID:    "test",
+		Name:  "str_replace_based_edit_tool",
+		Input: json.RawMessage(`{"command": "str_replace", "path": "vendor/lib/lib.go", "old_str": "old", "new_str": "new"}`),
+	}
+	// The original run was allowed, so replaying it must succeed even if the path has been protected since
+	toolCtx := &ToolContext{Workspace: fakeWorkspace{files: files}, ProtectedPaths: task.DefaultProtectedPaths}
+	require.NoError(t, NewTextEditorTool().Replay(context.Background(), block, toolCtx))
+	require.Equal(t, "new", files["vendor/lib/lib.go"])
+}
+
+func TestDeleteFileTool_ReplayTwice(t *testing.T) {
+	files := map[string]string{"test.txt": "content"}
+	toolCtx := &ToolContext{Workspace: fakeWorkspace{files: files}}
+	block := anthropic.ToolUseBlock{
+		ID:    "test",
+		Name:  "delete_file",
+		Input: []byte(`{"path": "test.txt"}`),
+	}
+
+	tool := NewDeleteFileTool()
+	require.NoError(t, tool.Replay(context.Background(), block, toolCtx))
+	require.NotContains(t, files, "test.txt")
+	require.NoError(t, tool.Replay(context.Background(), block, toolCtx))
+}
+
+func testWriteFileTool(t *testing.T, files map[string]string, inputJSON string) error {
+	block := anthropic.ToolUseBlock{
+		ID:    "test",
+		Name:  "write_file",
+		Input: json.RawMessage(inputJSON),
+	}
+	_, err := NewWriteFileTool().Run(context.Background(), block, &ToolContext{Workspace: fakeWorkspace{files: files}})
+	return err
+}
+
+func TestWriteFileTool_CreateNew(t *testing.T) {
+	files := map[string]string{}
+	require.NoError(t, testWriteFileTool(t, files, `{"path": "manifest.json", "content": "{}"}`))
+	require.Equal(t, "{}", files["manifest.json"])
+}
+
+func TestWriteFileTool_ExistsWithoutOverwrite(t *testing.T) {
+	files := map[string]string{"manifest.json": "old"}
+	err := testWriteFileTool(t, files, `{"path": "manifest.json", "content": "new"}`)
+	require.ErrorAs(t, err, &ToolInputError{})
+	require.Equal(t, "old", files["manifest.json"])
+}
+
+func TestWriteFileTool_ExistsWithOverwrite(t *testing.T) {
+	files := map[string]string{"manifest.json": "old"}
+	require.NoError(t, testWriteFileTool(t, files, `{"path": "manifest.json", "content": "new", "overwrite": true}`))
+	require.Equal(t, "new", files["manifest.json"])
+}
+
+func TestWriteFileTool_ReplayOverwrites(t *testing.T) {
+	files := map[string]string{"manifest.json": "old"}
+	block := anthropic.ToolUseBlock{
+		ID:    "test",
+		Name:  "write_file",
+		Input: json.RawMessage(`{"path": "manifest.json", "content": "new", "overwrite": true}`),
+	}
+	require.NoError(t, NewWriteFileTool().Replay(context.Background(), block, &ToolContext{Workspace: fakeWorkspace{files: files}}))
+	require.Equal(t, "new", files["manifest.json"])
+}
+
+func TestWriteFileTool_OverFileSizeLimit(t *testing.T) {
+	files := map[string]string{"manifest.json": "old"}
+	block := anthropic.ToolUseBlock{
+		ID:    "test",
+		Name:  "write_file",
+		Input: json.RawMessage(`{"path": "manifest.json", "content": "much too new", "overwrite": true}`),
+	}
+	toolCtx := &ToolContext{Workspace: fakeWorkspace{files: files}, MaxFileBytes: 10}
+	_, err := NewWriteFileTool().Run(context.Background(), block, toolCtx)
+	require.ErrorAs(t, err, &ToolInputError{})
+	require.Equal(t, "old", files["manifest.json"])
+}
+
+func testApplyPatchTool(t *testing.T, files map[string]string, patch string) error {
+	input, err := json.Marshal(ApplyPatchInput{Patch: patch})
+	require.NoError(t, err)
+	block := anthropic.ToolUseBlock{
+		ID:    "test",
+		Name:  "apply_patch",
+		Input: input,
+	}
+	_, err = NewApplyPatchTool().Run(context.Background(), block, &ToolContext{Workspace: fakeWorkspace{files: files}})
+	return err
+}
+
+const applyPatchOriginal = "package a\n\nfunc A() int {\n\treturn 1\n}\n\nfunc B() int {\n\treturn 2\n}\n"
+
+func TestApplyPatchTool_MultiHunk(t *testing.T) {
+	files := map[string]string{"a.go": applyPatchOriginal}
+	patch := `--- a/a.go
++++ b/a.go
+@@ -3,3 +3,3 @@
+ func A() int {
+-	return 1
++	return 10
+ }
+@@ -7,3 +7,4 @@
+ func B() int {
+-	return 2
++	b := 20
++	return b
+ }
+`
+	require.NoError(t, testApplyPatchTool(t, files, patch))
+	require.Equal(t, "package a\n\nfunc A() int {\n\treturn 10\n}\n\nfunc B() int {\n\tb := 20\n\treturn b\n}\n", files["a.go"])
+}
+
+func TestApplyPatchTool_ContextMismatch(t *testing.T) {
+	files := map[string]string{"a.go": applyPatchOriginal}
+	// The first hunk applies cleanly, but the second doesn't match
+	patch := `--- a/a.go
++++ b/a.go
+@@ -3,3 +3,3 @@
+ func A() int {
+-	return 1
++	return 10
+ }
+@@ -7,3 +7,3 @@
+ func C() int {
+-	return 2
++	return 20
+ }
+`
+	err := testApplyPatchTool(t, files, patch)
+	require.ErrorAs(t, err, &ToolInputError{})
+	require.Contains(t, err.Error(), "hunk 2 does not match")
+	require.Contains(t, err.Error(), "func C() int {")
+	require.Equal(t, applyPatchOriginal, files["a.go"], "file should not be modified")
+}
+
+func TestApplyPatchTool_CreateFile(t *testing.T) {
+	files := map[string]string{}
+	patch := `--- /dev/null
++++ b/new.txt
+@@ -0,0 +1,2 @@
++hello
++world
+`
+	require.NoError(t, testApplyPatchTool(t, files, patch))
+	require.Equal(t, "hello\nworld\n", files["new.txt"])
+
+	// Creating the file again fails, because it now exists
+	err := testApplyPatchTool(t, files, patch)
+	require.ErrorAs(t, err, &ToolInputError{})
+}
+
+func TestApplyPatchTool_ReplayAlreadyApplied(t *testing.T) {
+	files := map[string]string{"a.go": applyPatchOriginal}
+	patch := `--- a/a.go
++++ b/a.go
+@@ -4 +4 @@
+-	return 1
++	return 10
+`
+	input, err := json.Marshal(ApplyPatchInput{Patch: patch})
+	require.NoError(t, err)
+	block := anthropic.ToolUseBlock{ID: "test", Name: "apply_patch", Input: input}
+	toolCtx := &ToolContext{Workspace: fakeWorkspace{files: files}}
+
+	require.NoError(t, NewApplyPatchTool().Replay(context.Background(), block, toolCtx))
+	patched := files["a.go"]
+	require.Contains(t, patched, "return 10")
+	// Replaying again leaves the file as it is
+	require.NoError(t, NewApplyPatchTool().Replay(context.Background(), block, toolCtx))
+	require.Equal(t, patched, files["a.go"])
+}
+
+func TestApplyPatchTool_ReplayInsertionTwice(t *testing.T) {
+	for name, tc := range map[string]struct {
+		files map[string]string
+		patch string
+		want  string
+	}{
+		"create": {
+			files: map[string]string{},
+			patch: "--- /dev/null\n+++ b/new.txt\n@@ -0,0 +1,2 @@\n+a\n+b\n",
+			want:  "a\nb\n",
+		},
+		"insert": {
+			files: map[string]string{"list.txt": "first\nlast\n"},
+			patch: "--- a/list.txt\n+++ b/list.txt\n@@ -1,2 +1,3 @@\n first\n+middle\n last\n",
+			want:  "first\nmiddle\nlast\n",
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			input, err := json.Marshal(ApplyPatchInput{Patch: tc.patch})
+			require.NoError(t, err)
+			block := anthropic.ToolUseBlock{ID: "test", Name: "apply_patch", Input: input}
+			toolCtx := &ToolContext{Workspace: fakeWorkspace{files: tc.files}}
+
+			require.NoError(t, NewApplyPatchTool().Replay(context.Background(), block, toolCtx))
+			require.NoError(t, NewApplyPatchTool().Replay(context.Background(), block, toolCtx))
+			for _, path := range []string{"new.txt", "list.txt"} {
+				if content, ok := tc.files[path]; ok {
+					require.Equal(t, tc.want, content)
+				}
+			}
+		})
+	}
+}
+
+func testSubmitReviewTool(t *testing.T, prAuthor string, inputJSON string) (*string, *github.PullRequestReviewRequest, error) {
+	var review *github.PullRequestReviewRequest
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, http.MethodPost, r.Method)
+		require.Equal(t, "/repos/owner/repo/pulls/12/reviews", r.URL.Path)
+		review = &github.PullRequestReviewRequest{}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(review))
+
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"id": 1}`))
+	})
+
+	toolCtx := &ToolContext{
+		Task: task.Task{
+			Issue:       task.GithubIssue{Owner: "owner", Repo: "repo", Number: 7},
+			PullRequest: &task.GithubPullRequest{Owner: "owner", Repo: "repo", Number: 12, Author: prAuthor},
+		},
+		VCS:     vcs.NewGithubProvider(newTestGithubClient(t, handler)),
+		BotUser: &github.User{Login: github.Ptr("bot")},
+	}
+	block := anthropic.ToolUseBlock{
+		ID:    "test",
+		Name:  "submit_review",
+		Input: json.RawMessage(inputJSON),
+	}
+
+	result, err := NewSubmitReviewTool().Run(context.Background(), block, toolCtx)
+	return result, review, err
+}
+
+func TestSubmitReviewTool_Approve(t *testing.T) {
+	result, review, err := testSubmitReviewTool(t, "someone", `{"event": "APPROVE", "body": "Looks good"}`)
+	require.NoError(t, err)
+	require.Contains(t, *result, "APPROVE")
+	require.Equal(t, "APPROVE", review.GetEvent())
+	require.Equal(t, "Looks good", review.GetBody())
+}
+
+func TestSubmitReviewTool_RequestChanges(t *testing.T) {
+	_, review, err := testSubmitReviewTool(t, "someone", `{"event": "REQUEST_CHANGES", "body": "Please add tests"}`)
+	require.NoError(t, err)
+	require.Equal(t, "REQUEST_CHANGES", review.GetEvent())
+	require.Equal(t, "Please add tests", review.GetBody())
+}
+
+func TestSubmitReviewTool_Comment(t *testing.T) {
+	_, review, err := testSubmitReviewTool(t, "someone", `{"event": "COMMENT", "body": "A few thoughts"}`)
+	require.NoError(t, err)
+	require.Equal(t, "COMMENT", review.GetEvent())
+}
+
+func TestSubmitReviewTool_CommentOnOwnPR(t *testing.T) {
+	_, review, err := testSubmitReviewTool(t, "bot", `{"event": "COMMENT", "body": "Note to reviewers"}`)
+	require.NoError(t, err)
+	require.Equal(t, "COMMENT", review.GetEvent())
+}
+
+func TestSubmitReviewTool_RejectsSelfApproval(t *testing.T) {
+	_, review, err := testSubmitReviewTool(t, "bot", `{"event": "APPROVE", "body": "Looks good"}`)
+	require.ErrorAs(t, err, &ToolInputError{})
+	require.Nil(t, review)
+}
+
+func TestSubmitReviewTool_RejectsSelfRequestChanges(t *testing.T) {
+	_, review, err := testSubmitReviewTool(t, "bot", `{"event": "REQUEST_CHANGES", "body": "Hmm"}`)
+	require.ErrorAs(t, err, &ToolInputError{})
+	require.Nil(t, review)
+}
+
+func TestValidateReviewEvent_UnknownAuthorship(t *testing.T) {
+	bot := &github.User{Login: github.Ptr("bot")}
+	pr := &task.GithubPullRequest{Number: 12, Author: "someone"}
+
+	require.ErrorAs(t, validateReviewEvent(reviewEventApprove, "Looks good", pr, nil), &ToolInputError{})
+	require.ErrorAs(t, validateReviewEvent(reviewEventRequestChanges, "Hmm", &task.GithubPullRequest{Number: 12}, bot), &ToolInputError{})
+	require.NoError(t, validateReviewEvent(reviewEventComment, "A few thoughts", pr, nil))
+}
+
+func TestSubmitReviewTool_RejectsInvalidEvent(t *testing.T) {
+	_, review, err := testSubmitReviewTool(t, "someone", `{"event": "MERGE", "body": "Ship it"}`)
+	require.ErrorAs(t, err, &ToolInputError{})
+	require.Nil(t, review)
+}
+
+// testCreateReviewWithCommentsTool runs submit_review_with_comments against a fake GitHub server where the pull request
+// changes lines 2-3 of main.go. Returns the review that was submitted, if any
+func testCreateReviewWithCommentsTool(t *testing.T, inputJSON string) (*string, *github.PullRequestReviewRequest, error) {
+	var review *github.PullRequestReviewRequest
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/repos/owner/repo/pulls/12/files":
+			_ = json.NewEncoder(w).Encode([]*github.CommitFile{{
+				Filename: github.Ptr("main.go"),
+				Patch:    github.Ptr("@@ -1,3 +1,4 @@\n package main\n-var x = 1\n+var x = 2\n+var y = 3\n func main() {}"),
+			}})
+		case "/repos/owner/repo/pulls/12/reviews":
+			require.Equal(t, http.MethodPost, r.Method)
+			review = &github.PullRequestReviewRequest{}
+			require.NoError(t, json.NewDecoder(r.Body).Decode(review))
+			_, _ = w.Write([]byte(`{"id": 1}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte(`{"message": "Not Found"}`))
+		}
+	})
+
+	githubClient := newTestGithubClient(t, handler)
+	toolCtx := &ToolContext{
+		Task: task.Task{
+			Issue:       task.GithubIssue{Owner: "owner", Repo: "repo", Number: 7},
+			PullRequest: &task.GithubPullRequest{Owner: "owner", Repo: "repo", Number: 12, Author: "someone"},
+		},
+		GithubClient: githubClient,
+		VCS:          vcs.NewGithubProvider(githubClient),
+		BotUser:      &github.User{Login: github.Ptr("bot")},
+	}
+	block := anthropic.ToolUseBlock{
+		ID:    "test",
+		Name:  "submit_review_with_comments",
+		Input: json.RawMessage(inputJSON),
+	}
+
+	result, err := NewCreateReviewWithCommentsTool().Run(context.Background(), block, toolCtx)
+	return result, review, err
+}
+
+func TestCreateReviewWithCommentsTool_MultipleComments(t *testing.T) {
+	result, review, err := testCreateReviewWithCommentsTool(t, `{"event": "REQUEST_CHANGES", "body": "A few things", "comments": [
+		{"path": "main.go", "line": 2, "body": "Why 2?"},
+		{"path": "main.go", "line": 3, "side": "RIGHT", "body": "Unused"},
+		{"path": "main.go", "line": 2, "side": "LEFT", "body": "This was fine"},
+		{"path": "main.go", "line": 4, "body": "Context lines are fine too"}
+	]}`)
+	require.NoError(t, err)
+	require.Contains(t, *result, "4 line comments")
+	require.Equal(t, "REQUEST_CHANGES", review.GetEvent())
+	require.Equal(t, "A few things", review.GetBody())
+	require.Equal(t, []*github.DraftReviewComment{
+		{Path: github.Ptr("main.go"), Line: github.Ptr(2), Side: github.Ptr("RIGHT"), Body: github.Ptr("Why 2?")},
+		{Path: github.Ptr("main.go"), Line: github.Ptr(3), Side: github.Ptr("RIGHT"), Body: github.Ptr("Unused")},
+		{Path: github.Ptr("main.go"), Line: github.Ptr(2), Side: github.Ptr("LEFT"), Body: github.Ptr("This was fine")},
+		{Path: github.Ptr("main.go"), Line: github.Ptr(4), Side: github.Ptr("RIGHT"), Body: github.Ptr("Context lines are fine too")},
+	}, review.Comments)
+}
+
+func TestCreateReviewWithCommentsTool_RejectsLineOutsideDiff(t *testing.T) {
+	_, review, err := testCreateReviewWithCommentsTool(t, `{"event": "COMMENT", "body": "Thoughts", "comments": [
+		{"path": "main.go", "line": 2, "body": "Fine"},
+		{"path": "main.go", "line": 40, "body": "Not in the diff"},
+		{"path": "other.go", "line": 1, "body": "Not changed"}
+	]}`)
+	require.ErrorAs(t, err, &ToolInputError{})
+	require.Contains(t, err.Error(), "line 40 (RIGHT) of main.go is not part of the diff")
+	require.Contains(t, err.Error(), "line 1 (RIGHT) of other.go is not part of the diff")
+	require.Nil(t, review, "no comments should be submitted if any is invalid")
+}
+
+// testRequestReviewersTool runs request_reviewers against a fake GitHub server where alice and bob are collaborators
+// and the backend team has access to the repository. Returns the review request that was sent, if any
+func testRequestReviewersTool(t *testing.T, inputJSON string) (*string, *github.ReviewersRequest, error) {
+	var request *github.ReviewersRequest
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/repos/owner/repo/collaborators/alice", "/repos/owner/repo/collaborators/bob",
+			"/orgs/owner/teams/backend/repos/owner/repo":
+			w.WriteHeader(http.StatusNoContent)
+		case "/repos/owner/repo/pulls/12/requested_reviewers":
+			require.Equal(t, http.MethodPost, r.Method)
+			request = &github.ReviewersRequest{}
+			require.NoError(t, json.NewDecoder(r.Body).Decode(request))
+			_, _ = w.Write([]byte(`{"number": 12, "requested_reviewers": [{"login": "alice"}, {"login": "carol"}],
+				"requested_teams": [{"slug": "backend"}]}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte(`{"message": "Not Found"}`))
+		}
+	})
+
+	githubClient := newTestGithubClient(t, handler)
+	toolCtx := &ToolContext{
+		Task: task.Task{
+			Issue:       task.GithubIssue{Owner: "owner", Repo: "repo", Number: 7},
+			PullRequest: &task.GithubPullRequest{Owner: "owner", Repo: "repo", Number: 12, Author: "bot"},
+		},
+		GithubClient: githubClient,
+		VCS:          vcs.NewGithubProvider(githubClient),
+	}
+	block := anthropic.ToolUseBlock{
+		ID:    "test",
+		Name:  "request_reviewers",
+		Input: json.RawMessage(inputJSON),
+	}
+
+	result, err := NewRequestReviewersTool().Run(context.Background(), block, toolCtx)
+	return result, request, err
+}
+
+func TestRequestReviewersTool_Valid(t *testing.T) {
+	result, request, err := testRequestReviewersTool(t, `{"reviewers": ["alice"], "team_reviewers": ["backend"]}`)
+	require.NoError(t, err)
+	require.Equal(t, &github.ReviewersRequest{Reviewers: []string{"alice"}, TeamReviewers: []string{"backend"}}, request)
+	require.Contains(t, *result, "Reviews are now requested from: alice, carol, team backend")
+}
+
+func TestRequestReviewersTool_RejectsNonCollaborator(t *testing.T) {
+	_, request, err := testRequestReviewersTool(t, `{"reviewers": ["bob", "mallory"]}`)
+	require.ErrorAs(t, err, &ToolInputError{})
+	require.Contains(t, err.Error(), "mallory is not a collaborator")
+	require.Nil(t, request, "no reviews should be requested")
+}
+
+func TestRequestReviewersTool_RejectsUnknownTeam(t *testing.T) {
+	_, request, err := testRequestReviewersTool(t, `{"team_reviewers": ["frontend"]}`)
+	require.ErrorAs(t, err, &ToolInputError{})
+	require.Nil(t, request)
+}
+
+func TestRequestReviewersTool_RejectsAuthor(t *testing.T) {
+	_, request, err := testRequestReviewersTool(t, `{"reviewers": ["bot"]}`)
+	require.ErrorAs(t, err, &ToolInputError{})
+	require.Nil(t, request)
+}
+
+func testIssueStateTool(t *testing.T, tool AnthropicTool, inputJSON string) (*string, []labelRequest, error) {
+	var requests []labelRequest
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		requests = append(requests, labelRequest{method: r.Method, path: r.URL.Path, body: string(body)})
+
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{}`))
+	})
+
+	toolCtx := &ToolContext{
+		Task: task.Task{Issue: task.GithubIssue{Owner: "owner", Repo: "repo", Number: 7}},
+		VCS:  vcs.NewGithubProvider(newTestGithubClient(t, handler)),
+	}
+	block := anthropic.ToolUseBlock{
+		ID:    "test",
+		Name:  tool.GetToolParam().Name,
+		Input: json.RawMessage(inputJSON),
+	}
+
+	result, err := tool.Run(context.Background(), block, toolCtx)
+	return result, requests, err
+}
+
+func TestCloseIssueTool_CommentsThenCloses(t *testing.T) {
+	result, requests, err := testIssueStateTool(t, NewCloseIssueTool(), `{"comment": "Duplicate of #3", "state_reason": "not_planned"}`)
+	require.NoError(t, err)
+	require.Contains(t, *result, "not_planned")
+
+	require.Len(t, requests, 2)
+	require.Equal(t, http.MethodPost, requests[0].method)
+	require.Equal(t, "/repos/owner/repo/issues/7/comments", requests[0].path)
+	require.Contains(t, requests[0].body, "Duplicate of #3")
+	require.Equal(t, http.MethodPatch, requests[1].method)
+	require.Equal(t, "/repos/owner/repo/issues/7", requests[1].path)
+	require.JSONEq(t, `{"state": "closed", "state_reason": "not_planned"}`, requests[1].body)
+}
+
+func TestCloseIssueTool_DefaultsToCompleted(t *testing.T) {
+	_, requests, err := testIssueStateTool(t, NewCloseIssueTool(), `{"comment": "Fixed by #5"}`)
+	require.NoError(t, err)
+	require.Len(t, requests, 2)
+	require.JSONEq(t, `{"state": "closed", "state_reason": "completed"}`, requests[1].body)
+}
+
+func TestCloseIssueTool_RequiresComment(t *testing.T) {
+	_, requests, err := testIssueStateTool(t, NewCloseIssueTool(), `{"comment": " ", "state_reason": "completed"}`)
+	require.ErrorAs(t, err, &ToolInputError{})
+	require.Empty(t, requests)
+}
+
+func TestCloseIssueTool_RejectsInvalidStateReason(t *testing.T) {
+	_, requests, err := testIssueStateTool(t, NewCloseIssueTool(), `{"comment": "Bye", "state_reason": "reopened"}`)
+	require.ErrorAs(t, err, &ToolInputError{})
+	require.Empty(t, requests)
+}
+
+func TestReopenIssueTool(t *testing.T) {
+	_, requests, err := testIssueStateTool(t, NewReopenIssueTool(), `{"comment": "This still happens on main"}`)
+	require.NoError(t, err)
+	require.Len(t, requests, 2)
+	require.Equal(t, "/repos/owner/repo/issues/7/comments", requests[0].path)
+	require.JSONEq(t, `{"state": "open"}`, requests[1].body)
+}
+
+func testLinkIssueTool(t *testing.T, inputJSON string) (*string, []labelRequest, error) {
+	var requests []labelRequest
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		requests = append(requests, labelRequest{method: r.Method, path: r.URL.Path, body: string(body)})
+
+		w.Header().Set("Content-Type", "application/json")
+		if r.Method == http.MethodGet && r.URL.Path != "/repos/owner/repo/issues/3" {
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte(`{"message": "Not Found"}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{}`))
+	})
+
+	githubClient := newTestGithubClient(t, handler)
+	toolCtx := &ToolContext{
+		Task:         task.Task{Issue: task.GithubIssue{Owner: "owner", Repo: "repo", Number: 7}},
+		VCS:          vcs.NewGithubProvider(githubClient),
+		GithubClient: githubClient,
+	}
+	block := anthropic.ToolUseBlock{
+		ID:    "test",
+		Name:  "link_issue",
+		Input: json.RawMessage(inputJSON),
+	}
+
+	result, err := NewLinkIssueTool().Run(context.Background(), block, toolCtx)
+	return result, requests, err
+}
+
+func TestLinkIssueTool_PostsReference(t *testing.T) {
+	result, requests, err := testLinkIssueTool(t, `{"number": 3, "relationship": "duplicate_of", "comment": "Same stack trace"}`)
+	require.NoError(t, err)
+	require.Contains(t, *result, "Duplicate of #3")
+
+	require.Len(t, requests, 2)
+	require.Equal(t, http.MethodGet, requests[0].method)
+	require.Equal(t, "/repos/owner/repo/issues/3", requests[0].path)
+	require.Equal(t, http.MethodPost, requests[1].method)
+	require.Equal(t, "/repos/owner/repo/issues/7/comments", requests[1].path)
+	require.JSONEq(t, `{"body": "Duplicate of #3\n\nSame stack trace"}`, requests[1].body)
+}
+
+func TestLinkIssueTool_DefaultsToRelated(t *testing.T) {
+	_, requests, err := testLinkIssueTool(t, `{"number": 3}`)
+	require.NoError(t, err)
+	require.Len(t, requests, 2)
+	require.JSONEq(t, `{"body": "Related to #3"}`, requests[1].body)
+}
+
+func TestLinkIssueTool_NonexistentTarget(t *testing.T) {
+	_, requests, err := testLinkIssueTool(t, `{"number": 404, "relationship": "blocked_by"}`)
+	require.ErrorAs(t, err, &ToolInputError{})
+	require.Contains(t, err.Error(), "no issue or pull request #404")
+	// Nothing is posted
+	require.Len(t, requests, 1)
+}
+
+func TestLinkIssueTool_RejectsInvalidRelationship(t *testing.T) {
+	_, requests, err := testLinkIssueTool(t, `{"number": 3, "relationship": "fixes"}`)
+	require.ErrorAs(t, err, &ToolInputError{})
+	require.Empty(t, requests)
+}
+
+func TestLinkIssueTool_RejectsSelfReference(t *testing.T) {
+	_, requests, err := testLinkIssueTool(t, `{"number": 7}`)
+	require.ErrorAs(t, err, &ToolInputError{})
+	require.Empty(t, requests)
+}
+
+// testListOpenItemsTool runs list_open_items against a fake GitHub server whose search finds the given number of
+// matching items, and returns the search query it received
+func testListOpenItemsTool(t *testing.T, total int, inputJSON string) (*string, string, error) {
+	var query string
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/search/issues", r.URL.Path)
+		query = r.URL.Query().Get("q")
+		perPage, err := strconv.Atoi(r.URL.Query().Get("per_page"))
+		require.NoError(t, err)
+
+		var items []map[string]any
+		for i := 1; i <= min(total, perPage); i++ {
+			item := map[string]any{
+				"number":   i,
+				"title":    fmt.Sprintf("Item %d", i),
+				"html_url": fmt.Sprintf("https://github.com/owner/repo/issues/%d", i),
+			}
+			if i%2 == 0 {
+				item["pull_request"] = map[string]any{"url": "https://api.github.com/repos/owner/repo/pulls/" + strconv.Itoa(i)}
+			}
+			items = append(items, item)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		require.NoError(t, json.NewEncoder(w).Encode(map[string]any{"total_count": total, "items": items}))
+	})
+
+	toolCtx := &ToolContext{
+		Task:         task.Task{Issue: task.GithubIssue{Owner: "owner", Repo: "repo", Number: 1}},
+		GithubClient: newTestGithubClient(t, handler),
+	}
+	block := anthropic.ToolUseBlock{
+		ID:    "test",
+		Name:  "list_open_items",
+		Input: json.RawMessage(inputJSON),
+	}
+
+	result, err := NewListOpenItemsTool().Run(context.Background(), block, toolCtx)
+	return result, query, err
+}
+
+func TestListOpenItemsTool_All(t *testing.T) {
+	result, query, err := testListOpenItemsTool(t, 2, `{}`)
+	require.NoError(t, err)
+	require.Equal(t, "is:open repo:owner/repo", query)
+	require.Equal(t, "2 open items match:\n"+
+		"\n#1 (issue, this issue) Item 1 https://github.com/owner/repo/issues/1"+
+		"\n#2 (pull request) Item 2 https://github.com/owner/repo/issues/2", *result)
+}
+
+func TestListOpenItemsTool_Filters(t *testing.T) {
+	_, query, err := testListOpenItemsTool(t, 1, `{"type": "issue", "label": "good first issue", "text": "crash on save"}`)
+	require.NoError(t, err)
+	require.Equal(t, `is:open is:issue label:"good first issue" "crash" "on" "save" in:title,body repo:owner/repo`, query)
+}
+
+func TestListOpenItemsTool_TextCannotAddQualifiers(t *testing.T) {
+	_, query, err := testListOpenItemsTool(t, 1, `{"text": "crash OR repo:other/secret \"is:closed"}`)
+	require.NoError(t, err)
+	require.Equal(t, `is:open "crash" "OR" "repo:other/secret" "is:closed" in:title,body repo:owner/repo`, query)
+}
+
+func TestListOpenItemsTool_Capped(t *testing.T) {
+	result, _, err := testListOpenItemsTool(t, 100, `{"type": "pr"}`)
+	require.NoError(t, err)
+	require.Contains(t, *result, fmt.Sprintf("100 open items match, showing the %d most recently updated", maxOpenItems))
+	require.Equal(t, maxOpenItems, strings.Count(*result, "\n#"))
+}
+
+func TestListOpenItemsTool_NoMatches(t *testing.T) {
+	result, _, err := testListOpenItemsTool(t, 0, `{"text": "nothing"}`)
+	require.NoError(t, err)
+	require.Equal(t, "No open issues or pull requests match", *result)
+}
+
+func TestListOpenItemsTool_RejectsInvalidType(t *testing.T) {
+	_, query, err := testListOpenItemsTool(t, 1, `{"type": "discussion"}`)
+	require.ErrorAs(t, err, &ToolInputError{})
+	require.Empty(t, query, "nothing should be searched")
+}
+
+// testGetIssueTimelineTool runs get_issue_timeline against a fake GitHub server that serves the given pages of the
+// timeline. Returns the result and the numbers of the pages that were fetched, in order
+func testGetIssueTimelineTool(t *testing.T, pages ...string) (string, []int) {
+	var fetched []int
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/repos/owner/repo/issues/7/timeline", r.URL.Path)
+		page := 1
+		if p := r.URL.Query().Get("page"); p != "" {
+			var err error
+			page, err = strconv.Atoi(p)
+			require.NoError(t, err)
+		}
+		fetched = append(fetched, page)
+		if page < len(pages) {
+			w.Header().Set("Link", fmt.Sprintf(`<https://api.github.com/repos/owner/repo/issues/7/timeline?page=%d>; rel="next", `+
+				`<https://api.github.com/repos/owner/repo/issues/7/timeline?page=%d>; rel="last"`, page+1, len(pages)))
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(pages[page-1]))
+	})
+	toolCtx := &ToolContext{
+		Task:         task.Task{Issue: task.GithubIssue{Owner: "owner", Repo: "repo", Number: 7}},
+		GithubClient: newTestGithubClient(t, handler),
+	}
+	block := anthropic.ToolUseBlock{ID: "test", Name: "get_issue_timeline", Input: json.RawMessage(`{}`)}
+
+	result, err := NewGetIssueTimelineTool().Run(context.Background(), block, toolCtx)
+	require.NoError(t, err)
+	return *result, fetched
+}
+
+func TestGetIssueTimelineTool_SummarizesEvents(t *testing.T) {
+	result, _ := testGetIssueTimelineTool(t, `[
+		{"event": "assigned", "created_at": "2025-03-01T10:00:00Z", "actor": {"login": "alice"}, "assignee": {"login": "bot"}},
+		{"event": "labeled", "created_at": "2025-03-01T10:01:00Z", "actor": {"login": "alice"}, "label": {"name": "bug"}},
+		{"event": "commented", "created_at": "2025-03-01T10:02:00Z", "actor": {"login": "bob"}, "body": "Me too"}
+	]`, `[
+		{"event": "unlabeled", "created_at": "2025-03-02T09:00:00Z", "actor": {"login": "bob"}, "label": {"name": "bug"}},
+		{"event": "renamed", "created_at": "2025-03-02T09:05:00Z", "actor": {"login": "bob"}, "rename": {"from": "Crash", "to": "Crash on save"}},
+		{"event": "cross-referenced", "created_at": "2025-03-03T12:00:00Z", "actor": {"login": "carol"}, "source": {"type": "issue", "issue": {
+			"number": 12, "title": "Refactor saving", "state": "open", "pull_request": {"url": "https://api.github.com/repos/owner/repo/pulls/12"},
+			"repository": {"full_name": "owner/repo"}}}},
+		{"event": "referenced", "created_at": "2025-03-04T08:00:00Z", "actor": {"login": "carol"}, "commit_id": "0123456789abcdef0123"}
+	]`)
+
+	require.Equal(t, "Timeline of issue #7:\n"+
+		"- 2025-03-01 10:00 @alice assigned @bot\n"+
+		"- 2025-03-01 10:01 @alice added the label 'bug'\n"+
+		"- 2025-03-02 09:00 @bob removed the label 'bug'\n"+
+		"- 2025-03-02 09:05 @bob renamed the issue from 'Crash' to 'Crash on save'\n"+
+		"- 2025-03-03 12:00 @carol referenced this issue from pull request owner/repo#12 'Refactor saving' (open)\n"+
+		"- 2025-03-04 08:00 @carol referenced this issue in commit 0123456789ab\n", result)
+}
+
+func TestGetIssueTimelineTool_Capped(t *testing.T) {
+	var events []string
+	for i := range maxTimelineEvents + 5 {
+		events = append(events, fmt.Sprintf(`{"event": "labeled", "actor": {"login": "alice"}, "label": {"name": "label-%d"}}`, i))
+	}
+	result, _ := testGetIssueTimelineTool(t, "["+strings.Join(events, ",")+"]")
+
+	require.Contains(t, result, "omitting the 5 earliest events")
+	require.Equal(t, maxTimelineEvents, strings.Count(result, "\n- "))
+	require.NotContains(t, result, "'label-4'")
+	require.Contains(t, result, fmt.Sprintf("'label-%d'", maxTimelineEvents+4))
+}
+
+func TestGetIssueTimelineTool_FetchesOnlyRecentPages(t *testing.T) {
+	var pages []string
+	for p := range 5 {
+		var events []string
+		for i := range maxTimelineEvents * 3 / 5 {
+			events = append(events, fmt.Sprintf(`{"event": "labeled", "actor": {"login": "alice"}, "label": {"name": "label-%d-%d"}}`, p+1, i))
+		}
+		pages = append(pages, "["+strings.Join(events, ",")+"]")
+	}
+	result, fetched := testGetIssueTimelineTool(t, pages...)
+
+	// The last two pages hold enough events, so the pages between the first and them are skipped
+	require.Equal(t, []int{1, 5, 4}, fetched)
+	require.Contains(t, result, "omitting earlier events")
+	require.Equal(t, maxTimelineEvents, strings.Count(result, "\n- "))
+	require.NotContains(t, result, "'label-1-0'")
+	require.Contains(t, result, fmt.Sprintf("'label-5-%d'", maxTimelineEvents*3/5-1))
+}
+
+func TestGetIssueTimelineTool_Empty(t *testing.T) {
+	result, _ := testGetIssueTimelineTool(t, `[]`)
+	require.Equal(t, "There are no events on the timeline of issue #7", result)
+}
+
+func testCreateIssueTool(t *testing.T, inputJSON string, issuesCreated int) (*string, []labelRequest, *ToolContext, error) {
+	var requests []labelRequest
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		requests = append(requests, labelRequest{method: r.Method, path: r.URL.Path, body: string(body)})
+
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"number": 8, "html_url": "https://github.com/owner/repo/issues/8"}`))
+	})
+
+	toolCtx := &ToolContext{
+		Task:          task.Task{Issue: task.GithubIssue{Owner: "owner", Repo: "repo", Number: 7}},
+		VCS:           vcs.NewGithubProvider(newTestGithubClient(t, handler)),
+		IssuesCreated: issuesCreated,
+	}
+	block := anthropic.ToolUseBlock{
+		ID:    "test",
+		Name:  "create_issue",
+		Input: json.RawMessage(inputJSON),
+	}
+
+	result, err := NewCreateIssueTool().Run(context.Background(), block, toolCtx)
+	return result, requests, toolCtx, err
+}
+
+func TestCreateIssueTool_CreatesIssue(t *testing.T) {
+	result, requests, toolCtx, err := testCreateIssueTool(t, `{"title": "Flaky test", "body": "It fails sometimes"}`, 0)
+	require.NoError(t, err)
+	require.Contains(t, *result, "https://github.com/owner/repo/issues/8")
+	require.Equal(t, 1, toolCtx.IssuesCreated)
+
+	require.Len(t, requests, 1)
+	require.Equal(t, http.MethodPost, requests[0].method)
+	require.Equal(t, "/repos/owner/repo/issues", requests[0].path)
+	require.JSONEq(t, `{"title": "Flaky test", "body": "It fails sometimes"}`, requests[0].body)
+}
+
+func TestCreateIssueTool_PassesLabels(t *testing.T) {
+	_, requests, _, err := testCreateIssueTool(t, `{"title": "Flaky test", "body": "It fails sometimes", "labels": ["bug", "tests"]}`, 0)
+	require.NoError(t, err)
+	require.Len(t, requests, 1)
+	require.JSONEq(t, `{"title": "Flaky test", "body": "It fails sometimes", "labels": ["bug", "tests"]}`, requests[0].body)
+}
+
+func TestCreateIssueTool_PerTaskCap(t *testing.T) {
+	_, requests, toolCtx, err := testCreateIssueTool(t, `{"title": "Flaky test", "body": "It fails sometimes"}`, maxIssuesPerTask)
+	require.ErrorAs(t, err, &ToolInputError{})
+	require.Empty(t, requests)
+	require.Equal(t, maxIssuesPerTask, toolCtx.IssuesCreated)
+}
+
+func TestCreateIssueTool_ReplayCountsTowardCap(t *testing.T) {
+	toolCtx := &ToolContext{}
+	block := anthropic.ToolUseBlock{ID: "test", Name: "create_issue", Input: json.RawMessage(`{"title": "t", "body": "b"}`)}
+	for range maxIssuesPerTask {
+		require.NoError(t, NewCreateIssueTool().Replay(context.Background(), block, toolCtx))
+	}
+	require.Equal(t, maxIssuesPerTask, toolCtx.IssuesCreated)
+}
+
+func TestCreateIssueTool_RequiresTitle(t *testing.T) {
+	_, requests, _, err := testCreateIssueTool(t, `{"title": " ", "body": "It fails sometimes"}`, 0)
+	require.ErrorAs(t, err, &ToolInputError{})
+	require.Empty(t, requests)
+}
+
+func testResolveReviewThreadTool(t *testing.T, threadsJSON string, commentID int64) (*string, []string, error) {
+	var mutatedThreads []string
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/graphql", r.URL.Path)
+		var req struct {
+			Query     string         `json:"query"`
+			Variables map[string]any `json:"variables"`
+		}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+
+		w.Header().Set("Content-Type", "application/json")
+		if strings.Contains(req.Query, "resolveReviewThread") {
+			mutatedThreads = append(mutatedThreads, req.Variables["threadId"].(string))
+			_, _ = w.Write([]byte(`{"data": {"resolveReviewThread": {"thread": {"id": "x"}}}}`))
+			return
+		}
+		require.EqualValues(t, 12, req.Variables["number"])
+		_, _ = fmt.Fprintf(w, `{"data": {"repository": {"pullRequest": {"reviewThreads": {
+			"nodes": %s,
+			"pageInfo": {"hasNextPage": false, "endCursor": ""}
+		}}}}}`, threadsJSON)
+	})
+
+	toolCtx := &ToolContext{
+		Task: task.Task{
+			Issue:       task.GithubIssue{Owner: "owner", Repo: "repo", Number: 7},
+			PullRequest: &task.GithubPullRequest{Owner: "owner", Repo: "repo", Number: 12},
+		},
+		GithubClient: newTestGithubClient(t, handler),
+	}
+	block := anthropic.ToolUseBlock{
+		ID:    "test",
+		Name:  "resolve_review_thread",
+		Input: json.RawMessage(fmt.Sprintf(`{"comment_id": %d}`, commentID)),
+	}
+
+	result, err := NewResolveReviewThreadTool().Run(context.Background(), block, toolCtx)
+	return result, mutatedThreads, err
+}
+
+func TestResolveReviewThreadTool_Resolves(t *testing.T) {
+	threads := `[
+		{"id": "T_1", "isResolved": false, "comments": {"nodes": [{"databaseId": 100}]}},
+		{"id": "T_2", "isResolved": false, "comments": {"nodes": [{"databaseId": 200}]}}
+	]`
+	result, mutated, err := testResolveReviewThreadTool(t, threads, 200)
+	require.NoError(t, err)
+	require.Equal(t, "Resolved the review thread", *result)
+	require.Equal(t, []string{"T_2"}, mutated)
+}
+
+func TestResolveReviewThreadTool_AlreadyResolved(t *testing.T) {
+	threads := `[{"id": "T_1", "isResolved": true, "comments": {"nodes": [{"databaseId": 100}]}}]`
+	result, mutated, err := testResolveReviewThreadTool(t, threads, 100)
+	require.NoError(t, err)
+	require.Contains(t, *result, "already resolved")
+	require.Empty(t, mutated)
+}
+
+func TestResolveReviewThreadTool_ThreadNotOnPR(t *testing.T) {
+	threads := `[{"id": "T_1", "isResolved": false, "comments": {"nodes": [{"databaseId": 100}]}}]`
+	_, mutated, err := testResolveReviewThreadTool(t, threads, 300)
+	require.ErrorAs(t, err, &ToolInputError{})
+	require.Empty(t, mutated)
+}
+
+// testBlameTool runs blame_file against a stub GraphQL endpoint that knows only the given refs, returning the tool
+// result and the refs that were queried
+func testBlameTool(t *testing.T, rangesJSONByRef map[string]string, inputJSON string) (*string, []string, error) {
+	var queriedRefs []string
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/graphql", r.URL.Path)
+		var req struct {
+			Variables map[string]any `json:"variables"`
+		}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+
+		ref := req.Variables["ref"].(string)
+		queriedRefs = append(queriedRefs, ref)
+		w.Header().Set("Content-Type", "application/json")
+		rangesJSON, ok := rangesJSONByRef[ref]
+		if !ok {
+			_, _ = w.Write([]byte(`{"data": {"repository": {"object": null}}}`))
+			return
+		}
+		_, _ = fmt.Fprintf(w, `{"data": {"repository": {"object": {"blame": {"ranges": %s}}}}}`, rangesJSON)
+	})
+
+	toolCtx := &ToolContext{
+		Task: task.Task{
+			Issue:        task.GithubIssue{Owner: "owner", Repo: "repo", Number: 7},
+			SourceBranch: "bot/issue-7-fix",
+			TargetBranch: "main",
+		},
+		GithubClient: newTestGithubClient(t, handler),
+	}
+	block := anthropic.ToolUseBlock{
+		ID:    "test",
+		Name:  "blame_file",
+		Input: json.RawMessage(inputJSON),
+	}
+
+	result, err := NewBlameTool().Run(context.Background(), block, toolCtx)
+	return result, queriedRefs, err
+}
+
+func blameRangeJSON(start int, end int, sha string, author string, headline string) string {
+	return fmt.Sprintf(`{"startingLine": %d, "endingLine": %d, "commit": {
+		"oid": "%s", "authoredDate": "2024-05-01T12:00:00Z", "messageHeadline": "%s", "author": {"name": "%s"}
+	}}`, start, end, sha, headline, author)
+}
+
+func TestBlameTool_Format(t *testing.T) {
+	ranges := "[" + blameRangeJSON(1, 3, "aaaaaaaaaaaaaaaaaaaa", "Ada", "Initial commit") + "," +
+		blameRangeJSON(4, 4, "bbbbbbbbbbbbbbbbbbbb", "Grace", "Fix typo") + "]"
+	result, refs, err := testBlameTool(t, map[string]string{"bot/issue-7-fix": ranges}, `{"path": "main.go", "start_line": 2}`)
+	require.NoError(t, err)
+	require.Equal(t, []string{"bot/issue-7-fix"}, refs)
+
+	expected := "Blame of 'main.go' at 'bot/issue-7-fix', lines 2-4:\n" +
+		"2-3: aaaaaaaaaaaa 2024-05-01 Ada: Initial commit\n" +
+		"4: bbbbbbbbbbbb 2024-05-01 Grace: Fix typo\n"
+	require.Equal(t, expected, *result)
+}
+
+func TestBlameTool_ClampsRange(t *testing.T) {
+	ranges := "[" + blameRangeJSON(1, 1000, "aaaaaaaaaaaaaaaaaaaa", "Ada", "Initial commit") + "]"
+	result, _, err := testBlameTool(t, map[string]string{"bot/issue-7-fix": ranges}, `{"path": "main.go", "start_line": 101, "end_line": 900}`)
+	require.NoError(t, err)
+	require.Contains(t, *result, fmt.Sprintf("lines 101-%d:", 100+maxBlameLines))
+	require.Contains(t, *result, fmt.Sprintf("101-%d: aaaaaaaaaaaa", 100+maxBlameLines))
+	require.Contains(t, *result, "only 200 lines can be shown")
+}
+
+func TestBlameTool_InvalidRange(t *testing.T) {
+	_, refs, err := testBlameTool(t, nil, `{"path": "main.go", "start_line": 10, "end_line": 5}`)
+	require.ErrorAs(t, err, &ToolInputError{})
+	require.Empty(t, refs)
+}
+
+func TestBlameTool_FallsBackToTargetBranch(t *testing.T) {
+	ranges := "[" + blameRangeJSON(1, 2, "cccccccccccccccccccc", "Ada", "Initial commit") + "]"
+	result, refs, err := testBlameTool(t, map[string]string{"main": ranges}, `{"path": "main.go"}`)
+	require.NoError(t, err)
+	require.Equal(t, []string{"bot/issue-7-fix", "main"}, refs)
+	require.Contains(t, *result, "Blame of 'main.go' at 'main', lines 1-2:")
+}
+
+var findSymbolFiles = map[string]string{
+	"go.mod": "module example.com/widgets\n",
+	"widget.go": `package widgets
+
+// Widget is a widget
+type Widget struct {
+	Name string
+}
+
+const DefaultName = "widget"
+
+func NewWidget(name string) *Widget {
+	return &Widget{Name: name}
+}
+`,
+	"render/render.go": `package render
+
+type Renderer interface{ Render() string }
+
+func (w *Widget) Render() string { return w.Name }
+
+func (g Gadget[T]) Render() string { return "" }
+`,
+}
+
+func testFindSymbolTool(t *testing.T, inputJSON string) (*string, error) {
+	var fileTree []string
+	for path := range findSymbolFiles {
+		fileTree = append(fileTree, path)
+	}
+	slices.Sort(fileTree)
+
+	toolCtx := &ToolContext{
+		Workspace: fakeWorkspace{files: findSymbolFiles},
+		Task: task.Task{
+			CodebaseInfo: &task.CodebaseInfo{MainLanguage: "Go", FileTree: fileTree},
+		},
+	}
+	block := anthropic.ToolUseBlock{
+		ID:    "test",
+		Name:  "find_symbol",
+		Input: json.RawMessage(inputJSON),
+	}
+	return NewFindSymbolTool().Run(context.Background(), block, toolCtx)
+}
+
+func TestFindSymbolTool_Type(t *testing.T) {
+	result, err := testFindSymbolTool(t, `{"name": "Widget"}`)
+	require.NoError(t, err)
+	require.Equal(t, "widget.go:4: type Widget struct {", *result)
+}
+
+func TestFindSymbolTool_Function(t *testing.T) {
+	result, err := testFindSymbolTool(t, `{"name": "NewWidget"}`)
+	require.NoError(t, err)
+	require.Equal(t, "widget.go:10: func NewWidget(name string) *Widget {", *result)
+}
+
+func TestFindSymbolTool_Method(t *testing.T) {
+	result, err := testFindSymbolTool(t, `{"name": "Widget.Render"}`)
+	require.NoError(t, err)
+	require.Equal(t, "render/render.go:5: func (w *Widget) Render() string { return w.Name }", *result)
+
+	// Unqualified names match methods of any receiver
+	result, err = testFindSymbolTool(t, `{"name": "Render"}`)
+	require.NoError(t, err)
+	require.Equal(t, "render/render.go:5: func (w *Widget) Render() string { return w.Name }\n"+
+		"render/render.go:7: func (g Gadget[T]) Render() string { return \"\" }", *result)
+}
+
+func TestFindSymbolTool_Const(t *testing.T) {
+	result, err := testFindSymbolTool(t, `{"name": "DefaultName"}`)
+	require.NoError(t, err)
+	require.Equal(t, "widget.go:8: const DefaultName = \"widget\"", *result)
+}
+
+func TestFindSymbolTool_NotFound(t *testing.T) {
+	result, err := testFindSymbolTool(t, `{"name": "Name", "directory": "render"}`)
+	require.NoError(t, err)
+	require.Equal(t, "No top-level declaration of 'Name' found in 'render'", *result)
+}
+
+func TestFindSymbolTool_NotGo(t *testing.T) {
+	toolCtx := &ToolContext{Task: task.Task{CodebaseInfo: &task.CodebaseInfo{MainLanguage: "Python"}}}
+	block := anthropic.ToolUseBlock{ID: "test", Name: "find_symbol", Input: json.RawMessage(`{"name": "Widget"}`)}
+	_, err := NewFindSymbolTool().Run(context.Background(), block, toolCtx)
+	require.ErrorAs(t, err, &ToolInputError{})
+}
+
+// testMarkPRReadyTool runs mark_pull_request_ready against a fake GitHub server whose pull request is a draft if
+// isDraft is true, returning the tool result and the node IDs of pull requests marked ready
+// markPRReadyHandler is a fake GitHub server for pull request 12 in owner/repo, which records the node IDs of pull
+// requests marked as ready for review in marked
+func markPRReadyHandler(t *testing.T, isDraft bool, marked *[]string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/repos/owner/repo/pulls/12":
+			_, _ = fmt.Fprintf(w, `{"number": 12, "node_id": "PR_12", "draft": %t}`, isDraft)
+		case r.Method == http.MethodPost && r.URL.Path == "/graphql":
+			var req struct {
+				Query     string         `json:"query"`
+				Variables map[string]any `json:"variables"`
+			}
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+			require.Contains(t, req.Query, "markPullRequestReadyForReview")
+			*marked = append(*marked, req.Variables["pullRequestId"].(string))
+			_, _ = w.Write([]byte(`{"data": {"markPullRequestReadyForReview": {"pullRequest": {"id": "PR_12"}}}}`))
+		default:
+			t.Errorf("unexpected request: %s %s", r.Method, r.URL.Path)
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+}
+
+func testMarkPRReadyTool(t *testing.T, isDraft bool) (*string, []string, error) {
+	var marked []string
+	toolCtx := &ToolContext{
+		Task: task.Task{
+			Issue:       task.GithubIssue{Owner: "owner", Repo: "repo", Number: 7},
+			PullRequest: &task.GithubPullRequest{Owner: "owner", Repo: "repo", Number: 12},
+		},
+		GithubClient: newTestGithubClient(t, markPRReadyHandler(t, isDraft, &marked)),
+	}
+	block := anthropic.ToolUseBlock{
+		ID:    "test",
+		Name:  "mark_pull_request_ready",
+		Input: json.RawMessage(`{}`),
+	}
+
+	result, err := NewMarkPRReadyTool().Run(context.Background(), block, toolCtx)
+	return result, marked, err
+}
+
+func TestMarkPRReadyTool_Draft(t *testing.T) {
+	result, marked, err := testMarkPRReadyTool(t, true)
+	require.NoError(t, err)
+	require.Equal(t, []string{"PR_12"}, marked)
+	require.Equal(t, "Marked pull request #12 as ready for review", *result)
+}
+
+func TestMarkPRReadyTool_AlreadyReady(t *testing.T) {
+	result, marked, err := testMarkPRReadyTool(t, false)
+	require.NoError(t, err)
+	require.Empty(t, marked)
+	require.Equal(t, "Pull request #12 is already ready for review", *result)
+}
+
+// testUpdatePRDescriptionTool runs update_pull_request_description against a fake GitHub server whose pull request has
+// the given body, and returns the JSON bodies of the edit requests
+// testGitLogTool runs git_log against a fake GitHub server that lists the given number of commits on the task's branch,
+// or responds 404 if the count is negative. Returns the result and the per_page parameter of the request
+func testGitLogTool(t *testing.T, inputJSON string, commitCount int) (*string, string, error) {
+	var perPage string
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/repos/owner/repo/commits", r.URL.Path)
+		require.Equal(t, "fix/issue-7-add-a-widget", r.URL.Query().Get("sha"))
+		perPage = r.URL.Query().Get("per_page")
+
+		w.Header().Set("Content-Type", "application/json")
+		if commitCount < 0 {
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte(`{"message": "Not Found"}`))
+			return
+		}
+		var commits []map[string]any
+		for i := range commitCount {
+			commits = append(commits, map[string]any{
+				"sha":    fmt.Sprintf("%040d", i),
+				"author": map[string]any{"login": "bot"},
+				"commit": map[string]any{
+					"message": fmt.Sprintf("Change %d\n\nDetails", i),
+					"author":  map[string]any{"name": "Bot", "date": "2025-03-01T12:00:00Z"},
+				},
+			})
+		}
+		require.NoError(t, json.NewEncoder(w).Encode(commits))
+	})
+
+	toolCtx := &ToolContext{
+		Task: task.Task{
+			Issue:        task.GithubIssue{Owner: "owner", Repo: "repo", Number: 7},
+			SourceBranch: "fix/issue-7-add-a-widget",
+		},
+		GithubClient: newTestGithubClient(t, handler),
+	}
+	block := anthropic.ToolUseBlock{
+		ID:    "test",
+		Name:  "git_log",
+		Input: json.RawMessage(inputJSON),
+	}
+
+	result, err := NewGitLogTool().Run(context.Background(), block, toolCtx)
+	return result, perPage, err
+}
+
+func TestGitLogTool_ListsCommits(t *testing.T) {
+	result, perPage, err := testGitLogTool(t, `{}`, 2)
+	require.NoError(t, err)
+	require.Equal(t, fmt.Sprint(defaultGitLogCount), perPage)
+	require.Equal(t, "The 2 most recent commits on fix/issue-7-add-a-widget, newest first:\n"+
+		"\n000000000000 2025-03-01 bot: Change 0"+
+		"\n000000000000 2025-03-01 bot: Change 1", *result)
+}
+
+func TestGitLogTool_Count(t *testing.T) {
+	_, perPage, err := testGitLogTool(t, `{"count": 5}`, 5)
+	require.NoError(t, err)
+	require.Equal(t, "5", perPage)
+}
+
+func TestGitLogTool_CountTooLarge(t *testing.T) {
+	_, perPage, err := testGitLogTool(t, fmt.Sprintf(`{"count": %d}`, maxGitLogCount+1), 0)
+	require.ErrorAs(t, err, &ToolInputError{})
+	require.Empty(t, perPage, "no request should be made")
+}
+
+func TestGitLogTool_EmptyBranch(t *testing.T) {
+	result, _, err := testGitLogTool(t, `{}`, 0)
+	require.NoError(t, err)
+	require.Contains(t, *result, "has no commits yet")
+}
+
+func TestGitLogTool_MissingBranch(t *testing.T) {
+	result, _, err := testGitLogTool(t, `{}`, -1)
+	require.NoError(t, err)
+	require.Contains(t, *result, "has no commits yet")
+}
+
+// testViewFileAtRefTool runs view_file_at_ref against a fake GitHub server that has a README.md with the given content
+// on the main branch only
+func testViewFileAtRefTool(t *testing.T, content string, inputJSON string) (*string, error) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Path != "/repos/owner/repo/contents/README.md" || r.URL.Query().Get("ref") != "main" {
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte(`{"message": "Not Found"}`))
+			return
+		}
+		require.NoError(t, json.NewEncoder(w).Encode(map[string]any{
+			"type":     "file",
+			"path":     "README.md",
+			"encoding": "base64",
+			"content":  base64.StdEncoding.EncodeToString([]byte(content)),
+		}))
+	})
+
+	toolCtx := &ToolContext{
+		Task:         task.Task{Issue: task.GithubIssue{Owner: "owner", Repo: "repo", Number: 7}},
+		GithubClient: newTestGithubClient(t, handler),
+		// The workspace's version of the file must not be read
+		Workspace: fakeWorkspace{files: map[string]string{"README.md": "changed"}},
+	}
+	block := anthropic.ToolUseBlock{
+		ID:    "test",
+		Name:  "view_file_at_ref",
+		Input: json.RawMessage(inputJSON),
+	}
+	return NewViewFileAtRefTool().Run(context.Background(), block, toolCtx)
+}
+
+func TestViewFileAtRefTool_BaseBranch(t *testing.T) {
+	result, err := testViewFileAtRefTool(t, "# Title\nOriginal", `{"path": "README.md", "ref": "main"}`)
+	require.NoError(t, err)
+	require.Equal(t, "1: # Title\n2: Original\n", *result)
+}
+
+func TestViewFileAtRefTool_ViewRange(t *testing.T) {
+	result, err := testViewFileAtRefTool(t, numberedLines(maxViewLines+50), `{"path": "README.md", "ref": "main", "view_range": [3, 4]}`)
+	require.NoError(t, err)
+	require.Equal(t, "3: line 3\n4: line 4\n", *result)
+}
+
+func TestViewFileAtRefTool_Truncated(t *testing.T) {
+	result, err := testViewFileAtRefTool(t, numberedLines(maxViewLines+50), `{"path": "README.md", "ref": "main"}`)
+	require.NoError(t, err)
+	require.Contains(t, *result, "50 more lines not shown")
+}
+
+func TestViewFileAtRefTool_NonexistentPath(t *testing.T) {
+	_, err := testViewFileAtRefTool(t, "", `{"path": "missing.md", "ref": "main"}`)
+	require.ErrorAs(t, err, &ToolInputError{})
+	require.Contains(t, err.Error(), "missing.md does not exist at main")
+}
+
+func testUpdatePRDescriptionTool(t *testing.T, currentBody string, inputJSON string) (*string, []string, error) {
+	var edits []string
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/repos/owner/repo/pulls/12":
+			require.NoError(t, json.NewEncoder(w).Encode(map[string]any{"number": 12, "body": currentBody}))
+		case r.Method == http.MethodPatch && r.URL.Path == "/repos/owner/repo/pulls/12":
+			body, err := io.ReadAll(r.Body)
+			require.NoError(t, err)
+			edits = append(edits, string(body))
+			_, _ = w.Write([]byte(`{"number": 12}`))
+		default:
+			t.Errorf("unexpected request: %s %s", r.Method, r.URL.Path)
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+
+	githubClient := newTestGithubClient(t, handler)
+	toolCtx := &ToolContext{
+		Task: task.Task{
+			Issue:       task.GithubIssue{Owner: "owner", Repo: "repo", Number: 7},
+			PullRequest: &task.GithubPullRequest{Owner: "owner", Repo: "repo", Number: 12},
+		},
+		GithubClient: githubClient,
+		VCS:          vcs.NewGithubProvider(githubClient),
+	}
+	block := anthropic.ToolUseBlock{
+		ID:    "test",
+		Name:  "update_pull_request_description",
+		Input: json.RawMessage(inputJSON),
+	}
+
+	result, err := NewUpdatePRDescriptionTool().Run(context.Background(), block, toolCtx)
+	return result, edits, err
+}
+
+func TestUpdatePRDescriptionTool_Title(t *testing.T) {
+	result, edits, err := testUpdatePRDescriptionTool(t, "", `{"title": "Fix the parser"}`)
+	require.NoError(t, err)
+	require.Equal(t, "Updated pull request #12", *result)
+	require.Len(t, edits, 1)
+	require.JSONEq(t, `{"title": "Fix the parser"}`, edits[0])
+}
+
+func TestUpdatePRDescriptionTool_PreservesFooter(t *testing.T) {
+	currentBody := "Old summary\n\n" + workspace.PullRequestFooter([]int{7})
+	_, edits, err := testUpdatePRDescriptionTool(t, currentBody, `{"body": "New summary"}`)
+	require.NoError(t, err)
+	require.Len(t, edits, 1)
+
+	var edit struct{ Body string }
+	require.NoError(t, json.Unmarshal([]byte(edits[0]), &edit))
+	require.Equal(t, "New summary\n\n"+workspace.PullRequestFooter([]int{7}), edit.Body)
+}
+
+func TestUpdatePRDescriptionTool_DoesNotDuplicateReference(t *testing.T) {
+	currentBody := "Old summary\n\n" + workspace.PullRequestFooter([]int{7})
+	_, edits, err := testUpdatePRDescriptionTool(t, currentBody, `{"body": "New summary\n\nFixes #7\nRelated to #3"}`)
+	require.NoError(t, err)
+	require.Len(t, edits, 1)
+
+	var edit struct{ Body string }
+	require.NoError(t, json.Unmarshal([]byte(edits[0]), &edit))
+	require.Equal(t, "New summary\n\nRelated to #3\n\n"+workspace.PullRequestFooter([]int{7}), edit.Body)
+	require.Equal(t, 1, strings.Count(edit.Body, "#7"))
+}
+
+func TestUpdatePRDescriptionTool_RestoresMissingFooter(t *testing.T) {
+	_, edits, err := testUpdatePRDescriptionTool(t, "Someone removed the footer", `{"body": "New summary"}`)
+	require.NoError(t, err)
+	require.Len(t, edits, 1)
+
+	var edit struct{ Body string }
+	require.NoError(t, json.Unmarshal([]byte(edits[0]), &edit))
+	require.Equal(t, "New summary\n\n"+workspace.PullRequestFooter([]int{7}), edit.Body)
+}
+
+func TestUpdatePRDescriptionTool_NothingToUpdate(t *testing.T) {
+	_, edits, err := testUpdatePRDescriptionTool(t, "", `{"title": " "}`)
+	require.ErrorAs(t, err, &ToolInputError{})
+	require.Empty(t, edits)
+}
+
+// testRetargetPRTool runs retarget_pull_request for a pull request from "fix/issue-7-bug" into "main", against a fake
+// GitHub server on which only "main" and "release" exist. Returns the bodies of the requests to edit the pull request
+func testRetargetPRTool(t *testing.T, inputJSON string) (*string, []string, *ToolContext, error) {
+	var edits []string
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case r.Method == http.MethodGet && (r.URL.Path == "/repos/owner/repo/branches/main" || r.URL.Path == "/repos/owner/repo/branches/release"):
+			_, _ = fmt.Fprintf(w, `{"name": "%s"}`, strings.TrimPrefix(r.URL.Path, "/repos/owner/repo/branches/"))
+		case r.Method == http.MethodGet && strings.HasPrefix(r.URL.Path, "/repos/owner/repo/branches/"):
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte(`{"message": "Branch not found"}`))
+		case r.Method == http.MethodPatch && r.URL.Path == "/repos/owner/repo/pulls/12":
+			body, err := io.ReadAll(r.Body)
+			require.NoError(t, err)
+			edits = append(edits, string(body))
+			_, _ = w.Write([]byte(`{"number": 12}`))
+		default:
+			t.Errorf("unexpected request: %s %s", r.Method, r.URL.Path)
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+
+	githubClient := newTestGithubClient(t, handler)
+	toolCtx := &ToolContext{
+		Task: task.Task{
+			Issue:        task.GithubIssue{Owner: "owner", Repo: "repo", Number: 7},
+			PullRequest:  &task.GithubPullRequest{Owner: "owner", Repo: "repo", Number: 12, BaseBranch: "main"},
+			TargetBranch: "main",
+			SourceBranch: "fix/issue-7-bug",
+		},
+		Workspace:    &baseBranchWorkspace{baseBranch: "main"},
+		GithubClient: githubClient,
+		VCS:          vcs.NewGithubProvider(githubClient),
+	}
+	block := anthropic.ToolUseBlock{
+		ID:    "test",
+		Name:  "retarget_pull_request",
+		Input: json.RawMessage(inputJSON),
+	}
+
+	result, err := NewRetargetPRTool().Run(context.Background(), block, toolCtx)
+	return result, edits, toolCtx, err
+}
+
+// baseBranchWorkspace records the base branch that it was given
+type baseBranchWorkspace struct {
+	fakeWorkspace
+
+	baseBranch string
+}
+
+func (bbw *baseBranchWorkspace) SetBaseBranch(branch string) {
+	bbw.baseBranch = branch
+}
+
+func TestRetargetPRTool_Valid(t *testing.T) {
+	result, edits, toolCtx, err := testRetargetPRTool(t, `{"base": "release"}`)
+	require.NoError(t, err)
+	require.Equal(t, "Pull request #12 now targets 'release'. Note that the issue still asks for 'main', which later "+
+		"work on this task will be based on. To change that, ask for a `base: release` label or line to be added to the "+
+		"issue", *result)
+	require.Len(t, edits, 1)
+	require.JSONEq(t, `{"base": "release"}`, edits[0])
+	require.Equal(t, "release", toolCtx.Task.PullRequest.BaseBranch)
+	require.Equal(t, "release", toolCtx.Task.TargetBranch)
+	require.Equal(t, "release", toolCtx.Workspace.(*baseBranchWorkspace).baseBranch)
+}
+
+func TestRetargetPRTool_BaseIsHead(t *testing.T) {
+	_, edits, _, err := testRetargetPRTool(t, `{"base": "fix/issue-7-bug"}`)
+	require.ErrorAs(t, err, &ToolInputError{})
+	require.ErrorContains(t, err, "head branch")
+	require.Empty(t, edits)
+}
+
+func TestRetargetPRTool_BaseDoesNotExist(t *testing.T) {
+	_, edits, toolCtx, err := testRetargetPRTool(t, `{"base": "relase"}`)
+	require.ErrorAs(t, err, &ToolInputError{})
+	require.ErrorContains(t, err, "there is no branch 'relase'")
+	require.Empty(t, edits)
+	require.Equal(t, "main", toolCtx.Task.PullRequest.BaseBranch)
+	require.Equal(t, "main", toolCtx.Task.TargetBranch)
+	require.Equal(t, "main", toolCtx.Workspace.(*baseBranchWorkspace).baseBranch)
+}
+
+func TestRetargetPRTool_ReplayMovesWorkspaceBase(t *testing.T) {
+	// The resumed task's pull request was fetched after the retarget, but its target branch comes from the issue
+	toolCtx := &ToolContext{
+		Task: task.Task{
+			PullRequest:  &task.GithubPullRequest{Owner: "owner", Repo: "repo", Number: 12, BaseBranch: "release"},
+			TargetBranch: "main",
+		},
+		Workspace: &baseBranchWorkspace{baseBranch: "main"},
+	}
+	block := anthropic.ToolUseBlock{ID: "test", Name: "retarget_pull_request", Input: json.RawMessage(`{"base": "release"}`)}
+
+	require.NoError(t, NewRetargetPRTool().Replay(context.Background(), block, toolCtx))
+	require.Equal(t, "release", toolCtx.Task.TargetBranch)
+	require.Equal(t, "release", toolCtx.Workspace.(*baseBranchWorkspace).baseBranch)
+}
+
+func TestRetargetPRTool_ReplayFailedRetarget(t *testing.T) {
+	toolCtx := &ToolContext{
+		Task: task.Task{
+			PullRequest:  &task.GithubPullRequest{Owner: "owner", Repo: "repo", Number: 12, BaseBranch: "main"},
+			TargetBranch: "main",
+		},
+		Workspace: &baseBranchWorkspace{baseBranch: "main"},
+	}
+	block := anthropic.ToolUseBlock{ID: "test", Name: "retarget_pull_request", Input: json.RawMessage(`{"base": "relase"}`)}
+
+	require.NoError(t, NewRetargetPRTool().Replay(context.Background(), block, toolCtx))
+	require.Equal(t, "main", toolCtx.Task.TargetBranch)
+	require.Equal(t, "main", toolCtx.Workspace.(*baseBranchWorkspace).baseBranch)
+}
+
+func testGetCheckRunsTool(t *testing.T, checkRunsJSON string, annotationsJSON string) (*string, error) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/repos/owner/repo/pulls/12":
+			_, _ = w.Write([]byte(`{"number": 12, "head": {"sha": "0123456789abcdef"}}`))
+		case r.Method == http.MethodGet && r.URL.Path == "/repos/owner/repo/commits/0123456789abcdef/check-runs":
+			_, _ = w.Write([]byte(checkRunsJSON))
+		case r.Method == http.MethodGet && r.URL.Path == "/repos/owner/repo/check-runs/2/annotations":
+			_, _ = w.Write([]byte(annotationsJSON))
+		case r.Method == http.MethodGet && r.URL.Path == "/repos/owner/repo/commits/0123456789abcdef/status":
+			_, _ = w.Write([]byte(`{"state": "success", "statuses": [{"context": "ci/deploy", "state": "success", "description": "Deployed"}]}`))
+		default:
+			t.Errorf("unexpected request: %s %s", r.Method, r.URL.Path)
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+
+	toolCtx := &ToolContext{
+		Task: task.Task{
+			Issue:       task.GithubIssue{Owner: "owner", Repo: "repo", Number: 7},
+			PullRequest: &task.GithubPullRequest{Owner: "owner", Repo: "repo", Number: 12},
+		},
+		GithubClient: newTestGithubClient(t, handler),
+	}
+	block := anthropic.ToolUseBlock{
+		ID:    "test",
+		Name:  "get_check_runs",
+		Input: json.RawMessage(`{}`),
+	}
+
+	return NewGetCheckRunsTool().Run(context.Background(), block, toolCtx)
+}
+
+func TestGetCheckRunsTool_FailingWithAnnotations(t *testing.T) {
+	result, err := testGetCheckRunsTool(t, `{"total_count": 2, "check_runs": [
+		{"id": 1, "name": "test", "status": "completed", "conclusion": "success"},
+		{"id": 2, "name": "lint", "status": "completed", "conclusion": "failure",
+			"output": {"summary": "1 issue found", "annotations_count": 1}}
+	]}`, `[{"path": "main.go", "start_line": 10, "annotation_level": "failure", "message": "unused variable x"}]`)
+	require.NoError(t, err)
+	require.Equal(t, "Checks on the head of pull request #12 (0123456789ab):\n"+
+		"- test: success\n"+
+		"- lint: failure\n"+
+		"  Output:\n```\n1 issue found\n```\n"+
+		"  Annotations:\n"+
+		"  - main.go:10: failure: unused variable x\n"+
+		"Commit statuses:\n"+
+		"- ci/deploy: success (Deployed)\n", *result)
+}
+
+func TestGetCheckRunsTool_AllPassing(t *testing.T) {
+	result, err := testGetCheckRunsTool(t, `{"total_count": 2, "check_runs": [
+		{"id": 1, "name": "test", "status": "completed", "conclusion": "success"},
+		{"id": 3, "name": "lint", "status": "completed", "conclusion": "success", "output": {"annotations_count": 2}}
+	]}`, `[]`)
+	require.NoError(t, err)
+	require.Equal(t, "Checks on the head of pull request #12 (0123456789ab):\n"+
+		"- test: success\n"+
+		"- lint: success\n"+
+		"Commit statuses:\n"+
+		"- ci/deploy: success (Deployed)\n", *result)
+}
+
+func TestGetCheckRunsTool_TruncatesOutputAtCharacterBoundary(t *testing.T) {
+	// The multi-byte character straddles the truncation point
+	summary := strings.Repeat("x", maxCheckOutputChars-1) + "é" + strings.Repeat("y", 10)
+	result, err := testGetCheckRunsTool(t, fmt.Sprintf(`{"total_count": 1, "check_runs": [
+		{"id": 2, "name": "lint", "status": "completed", "conclusion": "failure", "output": {"summary": %q}}
+	]}`, summary), `[]`)
+	require.NoError(t, err)
+	require.True(t, utf8.ValidString(*result))
+	require.Contains(t, *result, strings.Repeat("x", maxCheckOutputChars-1)+"\n[output truncated]")
+}
+
+const testPRDiff = `diff --git a/main.go b/main.go
+index 1111111..2222222 100644
+--- a/main.go
++++ b/main.go
+@@ -1 +1 @@
+-package old
++package main
+diff --git a/internal/util/util.go b/internal/util/util.go
+new file mode 100644
+--- /dev/null
++++ b/internal/util/util.go
+@@ -0,0 +1 @@
++package util
+diff --git a/docs/old.md b/internal/util/README.md
+similarity index 100%
+rename from docs/old.md
+rename to internal/util/README.md
+`
+
+func testViewPRDiffTool(t *testing.T, inputJSON string, diff string) (*string, error) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet || r.URL.Path != "/repos/owner/repo/pulls/12" {
+			t.Errorf("unexpected request: %s %s", r.Method, r.URL.Path)
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		require.Equal(t, "application/vnd.github.v3.diff", r.Header.Get("Accept"))
+		_, _ = w.Write([]byte(diff))
+	})
+
+	toolCtx := &ToolContext{
+		Task: task.Task{
+			Issue:       task.GithubIssue{Owner: "owner", Repo: "repo", Number: 7},
+			PullRequest: &task.GithubPullRequest{Owner: "owner", Repo: "repo", Number: 12},
+		},
+		GithubClient: newTestGithubClient(t, handler),
+	}
+	block := anthropic.ToolUseBlock{
+		ID:    "test",
+		Name:  "view_pull_request_diff",
+		Input: json.RawMessage(inputJSON),
+	}
+
+	return NewViewPRDiffTool().Run(context.Background(), block, toolCtx)
+}
+
+func TestViewPRDiffTool_All(t *testing.T) {
+	result, err := testViewPRDiffTool(t, `{}`, testPRDiff)
+	require.NoError(t, err)
+	require.Equal(t, testPRDiff, *result)
+}
+
+func TestViewPRDiffTool_FilterByDirectory(t *testing.T) {
+	result, err := testViewPRDiffTool(t, `{"path": "internal/util/"}`, testPRDiff)
+	require.NoError(t, err)
+	require.Equal(t, `diff --git a/internal/util/util.go b/internal/util/util.go
+new file mode 100644
+--- /dev/null
++++ b/internal/util/util.go
+@@ -0,0 +1 @@
++package util
+diff --git a/docs/old.md b/internal/util/README.md
+similarity index 100%
+rename from docs/old.md
+rename to internal/util/README.md
+`, *result)
+}
+
+func TestViewPRDiffTool_FilterByFile(t *testing.T) {
+	result, err := testViewPRDiffTool(t, `{"path": "main.go"}`, testPRDiff)
+	require.NoError(t, err)
+	require.True(t, strings.HasPrefix(*result, "diff --git a/main.go b/main.go\n"))
+	require.NotContains(t, *result, "util")
+}
+
+func TestViewPRDiffTool_NoMatch(t *testing.T) {
+	result, err := testViewPRDiffTool(t, `{"path": "cmd"}`, testPRDiff)
+	require.NoError(t, err)
+	require.Equal(t, "Pull request #12 has no changes in cmd", *result)
+}
+
+func TestViewPRDiffTool_Truncated(t *testing.T) {
+	diff := "diff --git a/big.txt b/big.txt\n" + strings.Repeat("+x\n", maxPRDiffChars)
+	result, err := testViewPRDiffTool(t, `{}`, diff)
+	require.NoError(t, err)
+	require.Equal(t, diff[:maxPRDiffChars], (*result)[:maxPRDiffChars])
+	require.Contains(t, *result, fmt.Sprintf("[Diff truncated: showing %d of %d characters", maxPRDiffChars, len(diff)))
+}
+
+// publishRecordingWorkspace records the draft flag of each publish
+type publishRecordingWorkspace struct {
+	fakeWorkspace
+
+	drafts *[]bool
+}
+
+func (prw publishRecordingWorkspace) HasLocalChanges() bool { return false }
+
+func (prw publishRecordingWorkspace) PublishChangesForReview(_ context.Context, title string, _ string, draft bool) (*task.GithubPullRequest, error) {
+	*prw.drafts = append(*prw.drafts, draft)
+	if len(*prw.drafts) > 1 {
+		return nil, nil
+	}
+	return &task.GithubPullRequest{Owner: "owner", Repo: "repo", Number: 12, Title: title}, nil
+}
+
+func TestPublishChangesForReviewTool_Draft(t *testing.T) {
+	var drafts []bool
+	toolCtx := &ToolContext{Workspace: publishRecordingWorkspace{drafts: &drafts}}
+	for _, inputJSON := range []string{
+		`{"pull_request_title": "Fix the bug", "pull_request_body": "Fixes it", "draft": true}`,
+		`{"pull_request_title": "Fix the bug", "pull_request_body": "Fixes it"}`,
+	} {
+		block := anthropic.ToolUseBlock{ID: "test", Name: "publish_changes_for_review", Input: json.RawMessage(inputJSON)}
+		_, err := NewPublishChangesForReviewTool().Run(context.Background(), block, toolCtx)
+		require.NoError(t, err)
+	}
+	require.Equal(t, []bool{true, false}, drafts)
+}
+
+func TestPublishChangesForReviewTool_PullRequestUsableInSameRun(t *testing.T) {
+	var drafts []bool
+	var marked []string
+	registry := NewToolRegistry(ToolFilter{})
+	toolCtx := &ToolContext{
+		Task:         task.Task{Issue: task.GithubIssue{Owner: "owner", Repo: "repo", Number: 7}},
+		Workspace:    publishRecordingWorkspace{drafts: &drafts},
+		GithubClient: newTestGithubClient(t, markPRReadyHandler(t, true, &marked)),
+	}
+
+	publish := anthropic.ToolUseBlock{ID: "publish", Name: "publish_changes_for_review",
+		Input: json.RawMessage(`{"pull_request_title": "Fix the bug", "pull_request_body": "Fixes it", "draft": true}`)}
+	result, err := registry.ProcessToolUse(context.Background(), publish, toolCtx)
+	require.NoError(t, err)
+	require.False(t, result.IsError.Value, result.Content[0].OfText.Text)
+	require.Equal(t, 12, toolCtx.Task.PullRequest.Number)
+
+	ready := anthropic.ToolUseBlock{ID: "ready", Name: "mark_pull_request_ready", Input: json.RawMessage(`{}`)}
+	result, err = registry.ProcessToolUse(context.Background(), ready, toolCtx)
+	require.NoError(t, err)
+	require.False(t, result.IsError.Value, result.Content[0].OfText.Text)
+	require.Equal(t, []string{"PR_12"}, marked)
+}
+
+// scopedTestWorkspace runs tests with the given result, and records the targets it is asked to run
+type scopedTestWorkspace struct {
+	fakeWorkspace
+
+	result  validator.ValidationResult
+	err     error
+	targets *[]string
+}
+
+func (stw scopedTestWorkspace) RunTests(_ context.Context, _ *string, target string) (validator.ValidationResult, error) {
+	*stw.targets = append(*stw.targets, target)
+	return stw.result, stw.err
+}
+
+func testRunTestsTool(t *testing.T, ws scopedTestWorkspace) (*string, []string, error) {
+	var targets []string
+	ws.targets = &targets
+	block := anthropic.ToolUseBlock{
+		ID:    "test",
+		Name:  "run_tests",
+		Input: json.RawMessage(`{"target": "./internal/bot", "commit_message": "Fix the bug"}`),
+	}
+	result, err := NewRunTestsTool().Run(context.Background(), block, &ToolContext{Workspace: ws})
+	return result, targets, err
+}
+
+func TestRunTestsTool_Failed(t *testing.T) {
+	result, targets, err := testRunTestsTool(t, scopedTestWorkspace{
+		result: validator.ValidationResult{Succeeded: false, Details: "FAIL TestFoo"},
+	})
+	require.NoError(t, err)
+	require.Equal(t, []string{"./internal/bot"}, targets)
+	require.Contains(t, *result, "Tests failed")
+	require.Contains(t, *result, "FAIL TestFoo")
+}
+
+func TestRunTestsTool_Unsupported(t *testing.T) {
+	result, _, err := testRunTestsTool(t, scopedTestWorkspace{
+		err: fmt.Errorf("cannot run tests: %w", validator.ErrScopedTestsUnsupported),
+	})
+	require.NoError(t, err)
+	require.Contains(t, *result, "validate_changes")
+}
+
+func testDryRunTool(t *testing.T, toolName string, inputJSON string) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("unexpected GitHub request in dry-run mode: %s %s", r.Method, r.URL.Path)
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+
+	githubClient := newTestGithubClient(t, handler)
+	toolCtx := &ToolContext{
+		// A nil workspace panics if the tool tries to validate or publish changes
+		Workspace: nil,
+		Task: task.Task{
+			Issue:       task.GithubIssue{Owner: "owner", Repo: "repo", Number: 7},
+			PullRequest: &task.GithubPullRequest{Owner: "owner", Repo: "repo", Number: 12, Author: "someone"},
+		},
+		VCS:          vcs.NewGithubProvider(githubClient),
+		GithubClient: githubClient,
+		DryRun:       true,
+	}
+	block := anthropic.ToolUseBlock{
+		ID:    "test",
+		Name:  toolName,
+		Input: json.RawMessage(inputJSON),
+	}
+
+	registry := NewToolRegistry(ToolFilter{})
+	result, err := registry.ProcessToolUse(context.Background(), block, toolCtx)
+	require.NoError(t, err)
+	require.False(t, result.IsError.Value)
+	require.Contains(t, result.Content[0].OfText.Text, "Dry run")
+
+	require.NoError(t, registry.ReplayToolUse(context.Background(), ai.ToolExchange{UseBlock: block, ResultBlock: result}, toolCtx))
+}
+
+func TestDryRun_PostComment(t *testing.T) {
+	testDryRunTool(t, "post_comment", `{"comment_type": "issue", "body": "hello"}`)
+}
+
+func TestDryRun_AddReaction(t *testing.T) {
+	testDryRunTool(t, "add_reaction", `{"comment_type": "issue", "comment_id": 1, "reaction": "+1"}`)
+}
+
+func TestDryRun_ValidateChanges(t *testing.T) {
+	testDryRunTool(t, "validate_changes", `{"commit_message": "Fix the bug"}`)
+}
+
+func TestDryRun_RunTests(t *testing.T) {
+	testDryRunTool(t, "run_tests", `{"target": "./...", "commit_message": "Fix the bug"}`)
+}
+
+func TestDryRun_PublishChangesForReview(t *testing.T) {
+	testDryRunTool(t, "publish_changes_for_review", `{"pull_request_title": "Fix the bug", "pull_request_body": "Fixes it"}`)
+}
+
+func TestDryRun_MarkPRReady(t *testing.T) {
+	testDryRunTool(t, "mark_pull_request_ready", `{}`)
+}
+
+func TestDryRun_ManageLabels(t *testing.T) {
+	testDryRunTool(t, "manage_labels", `{"add": ["bug"]}`)
+}
+
+func TestDryRun_SubmitReview(t *testing.T) {
+	testDryRunTool(t, "submit_review", `{"event": "APPROVE", "body": "Looks good"}`)
+}
+
+func TestDryRun_Conclude(t *testing.T) {
+	testDryRunTool(t, "conclude", `{"comment": "Answered"}`)
+}
+
+func TestDryRun_SubmitReviewWithComments(t *testing.T) {
+	testDryRunTool(t, "submit_review_with_comments", `{"event": "COMMENT", "body": "Thoughts", "comments": [{"path": "main.go", "line": 2, "body": "Why?"}]}`)
+}
+
+func TestDryRun_RequestReviewers(t *testing.T) {
+	testDryRunTool(t, "request_reviewers", `{"reviewers": ["alice"]}`)
+}
+
+func TestDryRun_ResolveReviewThread(t *testing.T) {
+	testDryRunTool(t, "resolve_review_thread", `{"comment_id": 1}`)
+}
+
+func TestDryRun_CloseIssue(t *testing.T) {
+	testDryRunTool(t, "close_issue", `{"comment": "Duplicate of #3"}`)
+}
+
+func TestDryRun_LinkIssue(t *testing.T) {
+	testDryRunTool(t, "link_issue", `{"number": 3, "relationship": "duplicate_of"}`)
+}
+
+func TestDryRun_CreateIssue(t *testing.T) {
+	testDryRunTool(t, "create_issue", `{"title": "Flaky test", "body": "It fails sometimes"}`)
+}
+
+func TestDryRun_UpdatePRDescription(t *testing.T) {
+	testDryRunTool(t, "update_pull_request_description", `{"title": "Fix the parser"}`)
+}
+
+func TestDryRun_ManageAssignees(t *testing.T) {
+	testDryRunTool(t, "manage_assignees", `{"add": ["alice"]}`)
+}
+
+func TestDryRun_RetargetPR(t *testing.T) {
+	testDryRunTool(t, "retarget_pull_request", `{"base": "release"}`)
+}
+
+func TestDryRun_ReportLimitation(t *testing.T) {
+	testDryRunTool(t, "report_limitation", `{"tool_needed": "a compiler", "reason": "to build the code"}`)
+}
+
+func TestDryRun_PostPlan(t *testing.T) {
+	testDryRunTool(t, "post_plan", `{"plan": "Add a cache"}`)
+}
+
+func TestDryRun_ReadOnlyToolsRun(t *testing.T) {
+	toolCtx := &ToolContext{Workspace: fakeWorkspace{files: map[string]string{"a.go": "package a"}}, DryRun: true}
+	block := anthropic.ToolUseBlock{
+		ID:    "test",
+		Name:  "read_multiple_files",
+		Input: json.RawMessage(`{"paths": ["a.go"]}`),
+	}
+
+	result, err := NewToolRegistry(ToolFilter{}).ProcessToolUse(context.Background(), block, toolCtx)
+	require.NoError(t, err)
+	require.Contains(t, result.Content[0].OfText.Text, "1: package a")
+}
+
+func testToolFilter(t *testing.T, filter ToolFilter, wantEnabled []string, wantDisabled []string) {
+	registry := NewToolRegistry(filter)
+
+	var advertised []string
+	for _, param := range registry.GetAllToolParams() {
+		advertised = append(advertised, param.Name)
+	}
+	for _, name := range wantEnabled {
+		require.Contains(t, advertised, name)
+	}
+
+	toolCtx := &ToolContext{Workspace: fakeWorkspace{files: map[string]string{"a.go": "package a"}}}
+	for _, name := range wantDisabled {
+		require.NotContains(t, advertised, name)
+
+		block := anthropic.ToolUseBlock{ID: "test", Name: name, Input: json.RawMessage(`{"paths": ["a.go"]}`)}
+		result, err := registry.ProcessToolUse(context.Background(), block, toolCtx)
+		require.NoError(t, err)
+		require.True(t, result.IsError.Value)
+		require.Contains(t, result.Content[0].OfText.Text, "disabled")
+
+		require.NoError(t, registry.ReplayToolUse(context.Background(), ai.ToolExchange{UseBlock: block, ResultBlock: result}, toolCtx))
+	}
+}
+
+func TestReplayToolUse_ToolDisabledSinceCalled(t *testing.T) {
+	block := anthropic.ToolUseBlock{
+		ID:    "test",
+		Name:  "str_replace_based_edit_tool",
+		Input: json.RawMessage(`{"command": "create", "path": "b.go", "file_text": "package b"}`),
+	}
+	toolCtx := &ToolContext{Workspace: fakeWorkspace{files: map[string]string{}}}
+	result, err := NewToolRegistry(ToolFilter{}).ProcessToolUse(context.Background(), block, toolCtx)
+	require.NoError(t, err)
+	require.False(t, result.IsError.Value)
+
+	// The conversation is resumed after the tool is disabled, and its changes must still be restored
+	disabled := NewToolRegistry(ToolFilter{Disabled: []string{"str_replace_based_edit_tool"}})
+	resumed := fakeWorkspace{files: map[string]string{}}
+	exchange := ai.ToolExchange{UseBlock: block, ResultBlock: result}
+	require.NoError(t, disabled.ReplayToolUse(context.Background(), exchange, &ToolContext{Workspace: resumed}))
+	require.Equal(t, map[string]string{"b.go": "package b"}, resumed.files)
+}
+
+func TestReplayToolUse_RefusedCallNotReplayed(t *testing.T) {
+	block := anthropic.ToolUseBlock{
+		ID:    "test",
+		Name:  "str_replace_based_edit_tool",
+		Input: json.RawMessage(`{"command": "create", "path": "b.go", "file_text": "package b"}`),
+	}
+	ws := fakeWorkspace{files: map[string]string{}}
+	disabled := NewToolRegistry(ToolFilter{Disabled: []string{"str_replace_based_edit_tool"}})
+	result, err := disabled.ProcessToolUse(context.Background(), block, &ToolContext{Workspace: ws})
+	require.NoError(t, err)
+	require.True(t, result.IsError.Value)
+
+	// Even once the tool is enabled again, the refused call did nothing, so replaying it does nothing
+	exchange := ai.ToolExchange{UseBlock: block, ResultBlock: result}
+	require.NoError(t, NewToolRegistry(ToolFilter{}).ReplayToolUse(context.Background(), exchange, &ToolContext{Workspace: ws}))
+	require.Empty(t, ws.files)
+}
+
+func TestToolFilter_AllEnabledByDefault(t *testing.T) {
+	testToolFilter(t, ToolFilter{}, []string{"read_multiple_files", "post_comment", "close_issue"}, nil)
+}
+
+func TestToolFilter_Disabled(t *testing.T) {
+	testToolFilter(t,
+		ToolFilter{Disabled: []string{"read_multiple_files"}},
+		[]string{"post_comment", "close_issue"},
+		[]string{"read_multiple_files"},
+	)
+}
+
+func TestToolFilter_Enabled(t *testing.T) {
+	testToolFilter(t,
+		ToolFilter{Enabled: []string{"post_comment"}},
+		[]string{"post_comment"},
+		[]string{"read_multiple_files", "close_issue"},
+	)
+}
+
+func TestToolFilter_DisabledTakesPrecedence(t *testing.T) {
+	testToolFilter(t,
+		ToolFilter{Enabled: []string{"post_comment", "read_multiple_files"}, Disabled: []string{"read_multiple_files"}},
+		[]string{"post_comment"},
+		[]string{"read_multiple_files"},
+	)
+}
+
+func TestToolFilter_UnknownNames(t *testing.T) {
+	registry := NewToolRegistry(ToolFilter{Enabled: []string{"post_comment", "post_coment"}, Disabled: []string{"nope"}})
+	require.Equal(t, []string{"post_coment", "nope"}, registry.UnknownFilterNames())
+}
+
+// recordingMetrics is a metrics.Metrics that records everything in memory
+type recordingMetrics struct {
+	counters   map[string][]metrics.Labels
+	histograms map[string][]float64
+}
+
+func newRecordingMetrics() *recordingMetrics {
+	return &recordingMetrics{counters: map[string][]metrics.Labels{}, histograms: map[string][]float64{}}
+}
+
+func (rm *recordingMetrics) IncCounter(name string, labels metrics.Labels) {
+	rm.counters[name] = append(rm.counters[name], labels)
+}
+
+func (rm *recordingMetrics) ObserveHistogram(name string, value float64, labels metrics.Labels) {
+	rm.histograms[name] = append(rm.histograms[name], value)
+}
+
+func TestProcessToolUse_RecordsMetrics(t *testing.T) {
+	m := newRecordingMetrics()
+	toolCtx := &ToolContext{Workspace: fakeWorkspace{files: map[string]string{"a.go": "package a"}}, Metrics: m}
+	block := anthropic.ToolUseBlock{
+		ID:    "test",
+		Name:  "read_multiple_files",
+		Input: json.RawMessage(`{"paths": ["a.go"]}`),
+	}
+
+	_, err := NewToolRegistry(ToolFilter{}).ProcessToolUse(context.Background(), block, toolCtx)
+	require.NoError(t, err)
+
+	require.Equal(t, []metrics.Labels{{"tool": "read_multiple_files", "outcome": "success"}}, m.counters[metrics.ToolCalls])
+	require.Len(t, m.histograms[metrics.ToolLatency], 1)
+	require.GreaterOrEqual(t, m.histograms[metrics.ToolLatency][0], 0.0)
+}
+
+func newTestDocumentServer(t *testing.T) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/rfc.txt":
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			_, _ = w.Write([]byte("Network Working Group"))
+		case "/docs.html":
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			_, _ = w.Write([]byte(`<html><head><style>p { color: red; }</style><script>alert("hi")</script></head>` +
+				`<body><h1>API Reference</h1><p>Call <code>Get</code> to fetch &amp; return a widget.</p></body></html>`))
+		case "/large.txt":
+			w.Header().Set("Content-Type", "text/plain")
+			_, _ = w.Write([]byte(strings.Repeat("a", 2000)))
+		case "/redirect":
+			http.Redirect(w, r, "https://evil.example.com/", http.StatusFound)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func testFetchURLTool(t *testing.T, allowedHosts []string, url string) (*string, error) {
+	inputJSON, err := json.Marshal(FetchURLInput{URL: url})
+	require.NoError(t, err)
+
+	block := anthropic.ToolUseBlock{
+		ID:    "test",
+		Name:  "fetch_url",
+		Input: inputJSON,
+	}
+	return NewFetchURLTool(allowedHosts, 1000).Run(context.Background(), block, &ToolContext{})
+}
+
+func TestFetchURLTool_Allowed(t *testing.T) {
+	server := newTestDocumentServer(t)
+
+	result, err := testFetchURLTool(t, []string{"127.0.0.1"}, server.URL+"/rfc.txt")
+	require.NoError(t, err)
+	require.Equal(t, "Network Working Group", *result)
+}
+
+func TestFetchURLTool_HTML(t *testing.T) {
+	server := newTestDocumentServer(t)
+
+	result, err := testFetchURLTool(t, []string{"127.0.0.1"}, server.URL+"/docs.html")
+	require.NoError(t, err)
+	require.Equal(t, "API Reference\nCall Get to fetch & return a widget.", *result)
+}
+
+func TestFetchURLTool_DisallowedHost(t *testing.T) {
+	server := newTestDocumentServer(t)
+
+	_, err := testFetchURLTool(t, []string{"docs.example.com"}, server.URL+"/rfc.txt")
+	require.ErrorAs(t, err, &ToolInputError{})
+	require.ErrorContains(t, err, "not on the allowlist")
+}
+
+func TestFetchURLTool_WildcardHost(t *testing.T) {
+	tool := NewFetchURLTool([]string{"*.example.com"}, 1000)
+
+	require.NoError(t, tool.checkURL(&url.URL{Scheme: "https", Host: "docs.example.com"}))
+	require.Error(t, tool.checkURL(&url.URL{Scheme: "https", Host: "example.com"}))
+	require.Error(t, tool.checkURL(&url.URL{Scheme: "https", Host: "notexample.com"}))
+}
+
+func TestFetchURLTool_DisallowedScheme(t *testing.T) {
+	_, err := testFetchURLTool(t, []string{"127.0.0.1"}, "file:///etc/passwd")
+	require.ErrorAs(t, err, &ToolInputError{})
+	require.ErrorContains(t, err, "unsupported scheme")
+}
+
+func TestFetchURLTool_Oversized(t *testing.T) {
+	server := newTestDocumentServer(t)
+
+	_, err := testFetchURLTool(t, []string{"127.0.0.1"}, server.URL+"/large.txt")
+	require.ErrorAs(t, err, &ToolInputError{})
+	require.ErrorContains(t, err, "exceeds the limit")
+}
+
+func TestFetchURLTool_RedirectToDisallowedHost(t *testing.T) {
+	server := newTestDocumentServer(t)
+
+	_, err := testFetchURLTool(t, []string{"127.0.0.1"}, server.URL+"/redirect")
+	require.ErrorAs(t, err, &ToolInputError{})
+	require.ErrorContains(t, err, "not on the allowlist")
+}
+
+func testAwaitHumanInputTool(t *testing.T, inputJSON string) (*ToolContext, []labelRequest, error) {
+	var requests []labelRequest
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		requests = append(requests, labelRequest{method: r.Method, path: r.URL.Path, body: string(body)})
+
+		w.Header().Set("Content-Type", "application/json")
+		if r.Method == http.MethodPost && strings.HasSuffix(r.URL.Path, "/labels") {
+			_, _ = w.Write([]byte(`[]`))
+			return
+		}
+		_, _ = w.Write([]byte(`{}`))
+	})
+
+	githubClient := newTestGithubClient(t, handler)
+	toolCtx := &ToolContext{
+		Task:         task.Task{Issue: task.GithubIssue{Owner: "owner", Repo: "repo", Number: 7}},
+		VCS:          vcs.NewGithubProvider(githubClient),
+		GithubClient: githubClient,
+	}
+	block := anthropic.ToolUseBlock{
+		ID:    "test",
+		Name:  "await_human_input",
+		Input: json.RawMessage(inputJSON),
+	}
+
+	_, err := NewAwaitHumanInputTool().Run(context.Background(), block, toolCtx)
+	return toolCtx, requests, err
+}
+
+func TestAwaitHumanInputTool_PostsQuestionAndPauses(t *testing.T) {
+	toolCtx, requests, err := testAwaitHumanInputTool(t, `{"question": "Should timeouts be configurable?"}`)
+	require.NoError(t, err)
+	require.True(t, toolCtx.AwaitingHumanInput)
+
+	var comments, added, removed []string
+	for _, r := range requests {
+		switch {
+		case r.method == http.MethodPost && r.path == "/repos/owner/repo/issues/7/comments":
+			comments = append(comments, r.body)
+		case r.method == http.MethodPost && r.path == "/repos/owner/repo/issues/7/labels":
+			added = append(added, r.body)
+		case r.method == http.MethodDelete:
+			removed = append(removed, r.path)
+		}
+	}
+	require.Len(t, comments, 1)
+	require.Contains(t, comments[0], "Should timeouts be configurable?")
+	require.Len(t, added, 1)
+	require.JSONEq(t, `["needs-human"]`, added[0])
+	require.Equal(t, []string{"/repos/owner/repo/issues/7/labels/bot-turn"}, removed)
+}
+
+func TestConcludeTool_WithoutComment(t *testing.T) {
+	var requests []labelRequest
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, labelRequest{method: r.Method, path: r.URL.Path})
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{}`))
+	})
+	toolCtx := &ToolContext{
+		Task: task.Task{Issue: task.GithubIssue{Owner: "owner", Repo: "repo", Number: 7}},
+		VCS:  vcs.NewGithubProvider(newTestGithubClient(t, handler)),
+	}
+	block := anthropic.ToolUseBlock{ID: "test", Name: "conclude", Input: json.RawMessage(`{}`)}
+
+	_, err := NewConcludeTool().Run(context.Background(), block, toolCtx)
+	require.NoError(t, err)
+	require.True(t, toolCtx.Concluded)
+	require.Equal(t, []labelRequest{{method: http.MethodDelete, path: "/repos/owner/repo/issues/7/labels/bot-turn"}}, requests,
+		"no comment should be posted")
+}
+
+func TestIgnoreIssueTool(t *testing.T) {
+	var comments []string
+	var labels []labelRequest
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		body, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		switch {
+		case r.Method == http.MethodPost && r.URL.Path == "/repos/owner/repo/issues/7/comments":
+			var comment github.IssueComment
+			require.NoError(t, json.Unmarshal(body, &comment))
+			comments = append(comments, comment.GetBody())
+		case strings.HasPrefix(r.URL.Path, "/repos/owner/repo/issues/7/labels"):
+			labels = append(labels, labelRequest{method: r.Method, path: r.URL.Path, body: string(body)})
+			_, _ = w.Write([]byte(`[]`))
+			return
+		}
+		_, _ = w.Write([]byte(`{}`))
+	})
+	toolCtx := &ToolContext{
+		Task: task.Task{Issue: task.GithubIssue{Owner: "owner", Repo: "repo", Number: 7}},
+		VCS:  vcs.NewGithubProvider(newTestGithubClient(t, handler)),
+	}
+	block := anthropic.ToolUseBlock{ID: "test", Name: "ignore_issue",
+		Input: json.RawMessage(`{"reason": "This looks like spam."}`)}
+
+	_, err := NewIgnoreIssueTool().Run(context.Background(), block, toolCtx)
+	require.NoError(t, err)
+	require.True(t, toolCtx.Concluded)
+	require.Len(t, comments, 1)
+	require.Contains(t, comments[0], "This looks like spam.")
+	require.Contains(t, comments[0], "Remove the `bot-ignore` label")
+	require.Len(t, labels, 2)
+	require.Equal(t, http.MethodPost, labels[0].method)
+	require.JSONEq(t, `["bot-ignore"]`, labels[0].body)
+	require.Equal(t, labelRequest{method: http.MethodDelete, path: "/repos/owner/repo/issues/7/labels/bot-turn"}, labels[1])
+
+	// The label keeps the issue from being worked on again
+	ignored := task.Task{Issue: task.GithubIssue{Labels: []string{"bot-ignore"}}}
+	_, ok := task.NewBuilder(vcs.NewGithubProvider(github.NewClient(nil)), nil, task.CommandConfig{}).NeedsAttention(ignored)
+	require.False(t, ok)
+}
+
+func TestIgnoreIssueTool_RejectsEmptyReason(t *testing.T) {
+	toolCtx := &ToolContext{Task: task.Task{Issue: task.GithubIssue{Owner: "owner", Repo: "repo", Number: 7}}}
+	block := anthropic.ToolUseBlock{ID: "test", Name: "ignore_issue", Input: json.RawMessage(`{"reason": " "}`)}
+
+	_, err := NewIgnoreIssueTool().Run(context.Background(), block, toolCtx)
+	require.ErrorAs(t, err, &ToolInputError{})
+	require.False(t, toolCtx.Concluded)
+}
+
+func TestAwaitHumanInputTool_AssignsMaintainer(t *testing.T) {
+	var changes []labelRequest
+	githubClient := newTestGithubClient(t, assigneesHandler(t, &changes))
+	toolCtx := &ToolContext{
+		Task:         task.Task{Issue: task.GithubIssue{Owner: "owner", Repo: "repo", Number: 7}},
+		VCS:          vcs.NewGithubProvider(githubClient),
+		GithubClient: githubClient,
+	}
+	block := anthropic.ToolUseBlock{
+		ID:    "test",
+		Name:  "await_human_input",
+		Input: json.RawMessage(`{"question": "Which format should we use?", "assignees": ["alice"]}`),
+	}
+
+	result, err := NewAwaitHumanInputTool().Run(context.Background(), block, toolCtx)
+	require.NoError(t, err)
+	require.Contains(t, *result, "Assigned alice to the issue")
+	require.True(t, toolCtx.AwaitingHumanInput)
+	require.Len(t, changes, 1)
+	require.JSONEq(t, `{"assignees": ["alice"]}`, changes[0].body)
+}
+
+func TestAwaitHumanInputTool_RejectsNonAssignableUser(t *testing.T) {
+	var changes []labelRequest
+	githubClient := newTestGithubClient(t, assigneesHandler(t, &changes))
+	toolCtx := &ToolContext{
+		Task:         task.Task{Issue: task.GithubIssue{Owner: "owner", Repo: "repo", Number: 7}},
+		VCS:          vcs.NewGithubProvider(githubClient),
+		GithubClient: githubClient,
+	}
+	block := anthropic.ToolUseBlock{
+		ID:    "test",
+		Name:  "await_human_input",
+		Input: json.RawMessage(`{"question": "Which format should we use?", "assignees": ["stranger"]}`),
+	}
+
+	_, err := NewAwaitHumanInputTool().Run(context.Background(), block, toolCtx)
+	require.ErrorAs(t, err, &ToolInputError{})
+	require.False(t, toolCtx.AwaitingHumanInput, "the question should not be asked if an assignee is rejected")
+	require.Empty(t, changes)
+}
+
+func TestAwaitHumanInputTool_RejectsEmptyQuestion(t *testing.T) {
+	toolCtx, requests, err := testAwaitHumanInputTool(t, `{"question": "  "}`)
+	require.ErrorAs(t, err, &ToolInputError{})
+	require.False(t, toolCtx.AwaitingHumanInput)
+	require.Empty(t, requests)
+}
+
+func testPostPlanTool(t *testing.T, plan task.PlanState, inputJSON string) (*ToolContext, []labelRequest, error) {
+	var requests []labelRequest
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		requests = append(requests, labelRequest{method: r.Method, path: r.URL.Path, body: string(body)})
+
+		w.Header().Set("Content-Type", "application/json")
+		if r.Method == http.MethodPost && strings.HasSuffix(r.URL.Path, "/labels") {
+			_, _ = w.Write([]byte(`[]`))
+			return
+		}
+		_, _ = w.Write([]byte(`{}`))
+	})
+
+	toolCtx := &ToolContext{
+		Task: task.Task{Issue: task.GithubIssue{Owner: "owner", Repo: "repo", Number: 7}, Plan: plan},
+		VCS:  vcs.NewGithubProvider(newTestGithubClient(t, handler)),
+	}
+	block := anthropic.ToolUseBlock{ID: "test", Name: "post_plan", Input: json.RawMessage(inputJSON)}
+
+	_, err := NewPostPlanTool().Run(context.Background(), block, toolCtx)
+	return toolCtx, requests, err
+}
+
+func TestPostPlanTool_PostsPlanAndPauses(t *testing.T) {
+	toolCtx, requests, err := testPostPlanTool(t, task.PlanState{Required: true}, `{"plan": "1. Add a cache\n2. Test it"}`)
+	require.NoError(t, err)
+	require.True(t, toolCtx.AwaitingHumanInput)
+
+	var comments []github.IssueComment
+	var added, removed []string
+	for _, r := range requests {
+		switch {
+		case r.method == http.MethodPost && r.path == "/repos/owner/repo/issues/7/comments":
+			var comment github.IssueComment
+			require.NoError(t, json.Unmarshal([]byte(r.body), &comment))
+			comments = append(comments, comment)
+		case r.method == http.MethodPost && r.path == "/repos/owner/repo/issues/7/labels":
+			added = append(added, r.body)
+		case r.method == http.MethodDelete:
+			removed = append(removed, r.path)
+		}
+	}
+	require.Len(t, comments, 1)
+	require.Equal(t, task.PlanCommentHeading+"\n\n1. Add a cache\n2. Test it\n\n"+planApprovalInstructions, comments[0].GetBody())
+	require.Equal(t, "1. Add a cache\n2. Test it", planFromComment(comments[0].GetBody()))
+	require.Len(t, added, 1)
+	require.JSONEq(t, `["needs-human"]`, added[0])
+	require.Equal(t, []string{"/repos/owner/repo/issues/7/labels/bot-turn"}, removed)
+}
+
+func TestPostPlanTool_RevisesUnapprovedPlan(t *testing.T) {
+	plan := task.PlanState{Required: true, Comment: &github.IssueComment{ID: github.Ptr(int64(5))}}
+	toolCtx, _, err := testPostPlanTool(t, plan, `{"plan": "Use a map instead"}`)
+	require.NoError(t, err)
+	require.True(t, toolCtx.AwaitingHumanInput)
+}
+
+func TestPostPlanTool_PlanNotNeeded(t *testing.T) {
+	for _, plan := range []task.PlanState{
+		{},
+		{Required: true, Comment: &github.IssueComment{ID: github.Ptr(int64(5))}, Approved: true},
+	} {
+		toolCtx, requests, err := testPostPlanTool(t, plan, `{"plan": "Add a cache"}`)
+		require.ErrorAs(t, err, &ToolInputError{})
+		require.False(t, toolCtx.AwaitingHumanInput)
+		require.Empty(t, requests)
+	}
+}
+
+func TestPostPlanTool_RejectsEmptyPlan(t *testing.T) {
+	toolCtx, requests, err := testPostPlanTool(t, task.PlanState{Required: true}, `{"plan": " "}`)
+	require.ErrorAs(t, err, &ToolInputError{})
+	require.False(t, toolCtx.AwaitingHumanInput)
+	require.Empty(t, requests)
+}
+
+func TestToolRegistry_RefusesCodeChangesUntilPlanApproved(t *testing.T) {
+	registry := NewToolRegistry(ToolFilter{})
+	files := map[string]string{"a.go": "package a"}
+	toolCtx := &ToolContext{
+		Workspace: fakeWorkspace{files: files},
+		Task:      task.Task{Plan: task.PlanState{Required: true}},
+	}
+
+	write := anthropic.ToolUseBlock{ID: "write", Name: "write_file",
+		Input: json.RawMessage(`{"path": "a.go", "content": "package b", "overwrite": true}`)}
+	result, err := registry.ProcessToolUse(context.Background(), write, toolCtx)
+	require.NoError(t, err)
+	require.True(t, result.IsError.Value)
+	require.Contains(t, result.Content[0].OfText.Text, "until your plan is approved")
+	require.Equal(t, "package a", files["a.go"])
+
+	read := anthropic.ToolUseBlock{ID: "read", Name: "read_multiple_files", Input: json.RawMessage(`{"paths": ["a.go"]}`)}
+	result, err = registry.ProcessToolUse(context.Background(), read, toolCtx)
+	require.NoError(t, err)
+	require.False(t, result.IsError.Value)
+
+	// The editor may view files, but not edit them
+	view := anthropic.ToolUseBlock{ID: "view", Name: "str_replace_based_edit_tool",
+		Input: json.RawMessage(`{"command": "view", "path": "a.go"}`)}
+	result, err = registry.ProcessToolUse(context.Background(), view, toolCtx)
+	require.NoError(t, err)
+	require.False(t, result.IsError.Value, result.Content[0].OfText.Text)
+
+	replace := anthropic.ToolUseBlock{ID: "replace", Name: "str_replace_based_edit_tool",
+		Input: json.RawMessage(`{"command": "str_replace", "path": "a.go", "old_str": "a", "new_str": "b"}`)}
+	result, err = registry.ProcessToolUse(context.Background(), replace, toolCtx)
+	require.NoError(t, err)
+	require.True(t, result.IsError.Value)
+	require.Contains(t, result.Content[0].OfText.Text, "Only the view command(s)")
+	require.Equal(t, "package a", files["a.go"])
+
+	// Once the plan is approved, the AI may change code
+	toolCtx.Task.Plan.Approved = true
+	result, err = registry.ProcessToolUse(context.Background(), write, toolCtx)
+	require.NoError(t, err)
+	require.False(t, result.IsError.Value, result.Content[0].OfText.Text)
+	require.Equal(t, "package b", files["a.go"])
+}
+
+func TestPlanningToolsAreRegistered(t *testing.T) {
+	registry := NewToolRegistry(ToolFilter{})
+	for name := range planningTools {
+		_, ok := registry.GetTool(name)
+		require.True(t, ok, "%s is not a registered tool", name)
+	}
+}
+
+func testGetValidationStatusTool(t *testing.T, result validator.ValidationResult) string {
+	toolCtx := &ToolContext{Task: task.Task{ValidationResult: result}}
+	block := anthropic.ToolUseBlock{ID: "test", Name: "get_validation_status", Input: json.RawMessage(`{}`)}
+
+	output, err := NewGetValidationStatusTool().Run(context.Background(), block, toolCtx)
+	require.NoError(t, err)
+	require.NotNil(t, output)
+	return *output
+}
+
+func TestGetValidationStatusTool_Passing(t *testing.T) {
+	output := testGetValidationStatusTool(t, validator.ValidationResult{Succeeded: true, Details: "All checks passed"})
+	require.Equal(t, "Validation succeeded", output)
+}
+
+func TestGetValidationStatusTool_Failing(t *testing.T) {
+	output := testGetValidationStatusTool(t, validator.ValidationResult{Succeeded: false, Details: "TestFoo failed"})
+	require.Contains(t, output, "Validation failed")
+	require.Contains(t, output, "TestFoo failed")
+}
+
+// coverageWorkspace is a Workspace that reports the given coverage, or the given error
+type coverageWorkspace struct {
+	Workspace
+
+	coverage validator.Coverage
+	err      error
+}
+
+func (cw coverageWorkspace) Coverage(context.Context) (validator.Coverage, error) {
+	return cw.coverage, cw.err
+}
+
+func testGetCoverageTool(t *testing.T, ws Workspace, path string) string {
+	toolCtx := &ToolContext{Workspace: ws}
+	input, err := json.Marshal(GetCoverageInput{Path: path})
+	require.NoError(t, err)
+	block := anthropic.ToolUseBlock{ID: "test", Name: "get_coverage", Input: input}
+
+	output, err := NewGetCoverageTool().Run(context.Background(), block, toolCtx)
+	require.NoError(t, err)
+	require.NotNil(t, output)
+	return *output
+}
+
+func TestGetCoverageTool_Available(t *testing.T) {
+	ws := coverageWorkspace{coverage: validator.Coverage{
+		"pkg/widget.go": {
+			Covered:   []validator.LineRange{{Start: 3, End: 7}, {Start: 12, End: 12}},
+			Uncovered: []validator.LineRange{{Start: 8, End: 10}},
+		},
+		"pkg/gadget.go":   {Covered: []validator.LineRange{{Start: 1, End: 4}}},
+		"pkgextra/foo.go": {Covered: []validator.LineRange{{Start: 1, End: 1}}},
+	}}
+
+	output := testGetCoverageTool(t, ws, "pkg/widget.go")
+	require.Equal(t, "pkg/widget.go\n  Covered lines: 3-7, 12\n  Uncovered lines: 8-10\n", output)
+
+	output = testGetCoverageTool(t, ws, "pkg/")
+	require.Equal(t, "pkg/gadget.go\n  Covered lines: 1-4\n  Uncovered lines: none\n\n"+
+		"pkg/widget.go\n  Covered lines: 3-7, 12\n  Uncovered lines: 8-10\n", output)
+
+	output = testGetCoverageTool(t, ws, "pkg/missing.go")
+	require.Contains(t, output, "No coverage was recorded for pkg/missing.go")
+}
+
+func TestGetCoverageTool_Unavailable(t *testing.T) {
+	ws := coverageWorkspace{err: fmt.Errorf("%w: the validator doesn't measure coverage", validator.ErrCoverageUnavailable)}
+
+	output := testGetCoverageTool(t, ws, "pkg/widget.go")
+	require.Contains(t, output, "Coverage unavailable: the validator doesn't measure coverage")
+}
+
+// newReactionsGithubClient fakes GitHub's reaction endpoints for issue and review comments, starting with the given
+// reactions, keyed by comment ID. Reactions created through the client are attributed to "bot"
+func newReactionsGithubClient(t *testing.T, reactions map[int64][]*github.Reaction) *github.Client {
+	reactionsRegex := regexp.MustCompile(`^/repos/owner/repo/(issues|pulls)/comments/(\d+)/reactions$`)
+	return newTestGithubClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		match := reactionsRegex.FindStringSubmatch(r.URL.Path)
+		require.NotNil(t, match, "unexpected request to %s", r.URL.Path)
+		id, err := strconv.ParseInt(match[2], 10, 64)
+		require.NoError(t, err)
+
+		w.Header().Set("Content-Type", "application/json")
+		switch r.Method {
+		case http.MethodGet:
+			_ = json.NewEncoder(w).Encode(reactions[id])
+		case http.MethodPost:
+			var reaction github.Reaction
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&reaction))
+			reaction.User = &github.User{Login: github.Ptr("bot")}
+			reactions[id] = append(reactions[id], &reaction)
+			w.WriteHeader(http.StatusCreated)
+			_ = json.NewEncoder(w).Encode(reaction)
+		}
+	}))
+}
+
+func TestGetPendingResponsesTool_ReactionRemovesComment(t *testing.T) {
+	reaction := func(login string, content string) *github.Reaction {
+		return &github.Reaction{User: &github.User{Login: github.Ptr(login)}, Content: github.Ptr(content)}
+	}
+	client := newReactionsGithubClient(t, map[int64][]*github.Reaction{
+		// Acknowledged by the bot before the AI started, which doesn't count as handling the comment
+		1: {reaction("bot", "eyes")},
+		// Someone else's reaction doesn't count either
+		2: {reaction("alice", "+1")},
+		3: {reaction("bot", "eyes")},
+	})
+	author := &github.User{Login: github.Ptr("alice")}
+	toolCtx := &ToolContext{
+		Task: task.Task{
+			Issue: task.GithubIssue{Owner: "owner", Repo: "repo", Number: 7},
+			IssueCommentsRequiringResponses: []*github.IssueComment{
+				{ID: github.Ptr(int64(1)), User: author, Body: github.Ptr("Can you also handle empty input?\nThanks")},
+			},
+			PRCommentsRequiringResponses: []*github.IssueComment{
+				{ID: github.Ptr(int64(2)), User: author, Body: github.Ptr("Looks good overall")},
+			},
+			PRReviewCommentsRequiringResponses: []*github.PullRequestComment{
+				{ID: github.Ptr(int64(3)), User: author, Path: github.Ptr("widget.go"), Body: github.Ptr("Rename this")},
+			},
+		},
+		VCS:          vcs.NewGithubProvider(client),
+		GithubClient: client,
+		BotUser:      &github.User{Login: github.Ptr("bot")},
+	}
+	getPending := func() string {
+		block := anthropic.ToolUseBlock{ID: "test", Name: "get_pending_responses", Input: json.RawMessage(`{}`)}
+		output, err := NewGetPendingResponsesTool().Run(context.Background(), block, toolCtx)
+		require.NoError(t, err)
+		require.NotNil(t, output)
+		return *output
+	}
+
+	output := getPending()
+	require.Equal(t, "3 comments are waiting for a response:\n"+
+		"- issue comment 1 by @alice: Can you also handle empty input?\n"+
+		"- PR comment 2 by @alice: Looks good overall\n"+
+		"- PR review comment 3 by @alice on widget.go: Rename this\n", output)
+
+	block := anthropic.ToolUseBlock{ID: "test", Name: "add_reaction",
+		Input: json.RawMessage(`{"comment_type": "issue", "comment_id": 1, "reaction": "+1"}`)}
+	_, err := NewAddReactionTool().Run(context.Background(), block, toolCtx)
+	require.NoError(t, err)
+
+	output = getPending()
+	require.NotContains(t, output, "comment 1 ")
+	require.Contains(t, output, "2 comments are waiting for a response")
+
+	for _, input := range []string{
+		`{"comment_type": "PR", "comment_id": 2, "reaction": "heart"}`,
+		`{"comment_type": "PR review", "comment_id": 3, "reaction": "+1"}`,
+	} {
+		block := anthropic.ToolUseBlock{ID: "test", Name: "add_reaction", Input: json.RawMessage(input)}
+		_, err := NewAddReactionTool().Run(context.Background(), block, toolCtx)
+		require.NoError(t, err)
+	}
+	require.Equal(t, "No comments are waiting for a response", getPending())
+}
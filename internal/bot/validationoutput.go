@@ -0,0 +1,76 @@
+package bot
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+const (
+	// validationOutputContextLines is the number of lines kept on either side of a line that looks like an error
+	validationOutputContextLines = 2
+)
+
+// validationErrorRegex matches lines of validation output that look like they report an error, e.g. a failed test or a
+// compiler error with a file position
+var validationErrorRegex = regexp.MustCompile(
+	`(?i)\b(error|errors|fail|fails|failed|failing|failure|panic|fatal|exception|traceback)\b|\S+:\d+:\d+:`)
+
+// truncateValidationDetails shortens validation output to roughly maxLines lines, so that a build that prints thousands
+// of lines doesn't flood the context. The first and last lines are kept, along with lines that look like errors and a
+// few lines around them, and each gap is replaced with a note of how many lines were omitted. Zero means no limit
+func truncateValidationDetails(details string, maxLines int) string {
+	lines := strings.Split(strings.TrimSuffix(details, "\n"), "\n")
+	if maxLines <= 0 || len(lines) <= maxLines {
+		return details
+	}
+
+	keep := make([]bool, len(lines))
+	kept := 0
+	mark := func(i int) {
+		if i >= 0 && i < len(lines) && !keep[i] {
+			keep[i] = true
+			kept++
+		}
+	}
+
+	// Spend a quarter of the budget on each end of the output, where commands typically announce what they're doing
+	// and summarize what went wrong, and the rest on the errors in between
+	edge := maxLines / 4
+	for i := range edge {
+		mark(i)
+		mark(len(lines) - 1 - i)
+	}
+	for i := edge; i < len(lines)-edge; i++ {
+		if !validationErrorRegex.MatchString(lines[i]) {
+			continue
+		}
+		if kept+2*validationOutputContextLines+1 > maxLines {
+			break
+		}
+		for j := i - validationOutputContextLines; j <= i+validationOutputContextLines; j++ {
+			mark(j)
+		}
+	}
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "[Output truncated: showing %d of %d lines, including those that look like errors]\n", kept,
+		len(lines))
+	omitted := 0
+	for i, line := range lines {
+		if !keep[i] {
+			omitted++
+			continue
+		}
+		if omitted > 0 {
+			fmt.Fprintf(&sb, "[... %d lines omitted ...]\n", omitted)
+			omitted = 0
+		}
+		sb.WriteString(line)
+		sb.WriteString("\n")
+	}
+	if omitted > 0 {
+		fmt.Fprintf(&sb, "[... %d lines omitted ...]\n", omitted)
+	}
+	return sb.String()
+}
@@ -0,0 +1,45 @@
+package bot
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTruncateValidationDetails_Short(t *testing.T) {
+	details := "go build ./...\n./widget.go:12:3: undefined: gadget\n"
+	require.Equal(t, details, truncateValidationDetails(details, 200))
+}
+
+func TestTruncateValidationDetails_Huge(t *testing.T) {
+	var sb strings.Builder
+	for i := 1; i <= 5000; i++ {
+		switch i {
+		case 2500:
+			sb.WriteString("--- FAIL: TestWidget (0.01s)\n")
+		case 3700:
+			sb.WriteString("./widget.go:12:3: undefined: gadget\n")
+		default:
+			fmt.Fprintf(&sb, "ok line %d\n", i)
+		}
+	}
+
+	output := truncateValidationDetails(sb.String(), 100)
+	lines := strings.Split(strings.TrimSuffix(output, "\n"), "\n")
+	require.Less(t, len(lines), 120)
+	require.Contains(t, output, "[Output truncated: showing 60 of 5000 lines")
+
+	// The start and end of the output are kept
+	require.Contains(t, output, "ok line 1\n")
+	require.Contains(t, output, "ok line 25\n")
+	require.NotContains(t, output, "ok line 26\n")
+	require.Contains(t, output, "ok line 4976\n")
+	require.Contains(t, output, "ok line 5000\n")
+
+	// Errors are kept along with the lines around them
+	require.Contains(t, output, "ok line 2498\nok line 2499\n--- FAIL: TestWidget (0.01s)\nok line 2501\nok line 2502\n"+
+		"[... 1195 lines omitted ...]\nok line 3698\nok line 3699\n./widget.go:12:3: undefined: gadget\n")
+	require.Contains(t, output, "ok line 25\n[... 2472 lines omitted ...]\nok line 2498\n")
+}
@@ -0,0 +1,192 @@
+package githubapp
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+const (
+	// appTokenRefreshMargin is how long before an installation token expires that it is replaced, so that a request
+	// started with it doesn't fail partway through. Installation tokens are valid for an hour
+	appTokenRefreshMargin = 5 * time.Minute
+	// appJWTLifetime is how long the JWTs that authenticate as the app are valid for. GitHub allows at most 10 minutes
+	appJWTLifetime = 9 * time.Minute
+	// appTokenRequestTimeout bounds how long fetching an installation token may take
+	appTokenRequestTimeout = 30 * time.Second
+)
+
+// AppTokenSource is an oauth2.TokenSource that authenticates as an installation of a GitHub App. It caches installation
+// tokens and fetches a new one shortly before the current one expires. The tokens it returns report an expiry that is
+// appTokenRefreshMargin earlier than GitHub's, so that callers which cache them, like the client returned by
+// oauth2.NewClient, replace them early too. Safe for concurrent use
+type AppTokenSource struct {
+	appID          int64
+	installationID int64
+	privateKey     *rsa.PrivateKey
+	baseURL        string
+	httpClient     *http.Client
+
+	mu    sync.Mutex
+	token *oauth2.Token
+
+	// Overridden in tests
+	now func() time.Time
+}
+
+// NewAppTokenSource creates a token source for the given installation of the GitHub App with the given ID, which signs
+// its requests for installation tokens with privateKeyPEM, a PEM-encoded RSA key as downloaded from the app's settings.
+// Tokens are requested from the REST API at baseURL, e.g. "https://api.github.com/", using httpClient, or
+// http.DefaultClient if nil
+func NewAppTokenSource(appID int64, installationID int64, privateKeyPEM []byte, baseURL string, httpClient *http.Client) (*AppTokenSource, error) {
+	privateKey, err := parseRSAPrivateKey(privateKeyPEM)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse GitHub App private key: %w", err)
+	}
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	if !strings.HasSuffix(baseURL, "/") {
+		baseURL += "/"
+	}
+
+	return &AppTokenSource{
+		appID:          appID,
+		installationID: installationID,
+		privateKey:     privateKey,
+		baseURL:        baseURL,
+		httpClient:     httpClient,
+		now:            time.Now,
+	}, nil
+}
+
+// Token returns an installation token, fetching a new one if the cached one is missing or about to expire
+func (s *AppTokenSource) Token() (*oauth2.Token, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.token != nil && s.now().Before(s.token.Expiry) {
+		return s.token, nil
+	}
+
+	token, err := s.fetchInstallationToken()
+	if err != nil {
+		return nil, err
+	}
+	s.token = token
+	return token, nil
+}
+
+// fetchInstallationToken exchanges a JWT signed with the app's private key for a new installation token
+func (s *AppTokenSource) fetchInstallationToken() (*oauth2.Token, error) {
+	jwt, err := s.signJWT()
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign GitHub App JWT: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), appTokenRequestTimeout)
+	defer cancel()
+
+	endpoint := fmt.Sprintf("%sapp/installations/%d/access_tokens", s.baseURL, s.installationID)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create installation token request: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+jwt)
+	req.Header.Set("Accept", "application/vnd.github+json")
+
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to request installation token: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read installation token response: %w", err)
+	}
+	if resp.StatusCode != http.StatusCreated {
+		return nil, fmt.Errorf("installation token request failed with status %d: %s", resp.StatusCode,
+			bytes.TrimSpace(body))
+	}
+
+	var result struct {
+		Token     string    `json:"token"`
+		ExpiresAt time.Time `json:"expires_at"`
+	}
+	if err := json.Unmarshal(body, &result); err != nil {
+		return nil, fmt.Errorf("failed to parse installation token response: %w", err)
+	}
+	if result.Token == "" {
+		return nil, fmt.Errorf("installation token response has no token")
+	}
+
+	expiry := result.ExpiresAt.Add(-appTokenRefreshMargin)
+	return &oauth2.Token{AccessToken: result.Token, TokenType: "token", Expiry: expiry}, nil
+}
+
+// signJWT creates a short-lived JWT that authenticates as the app itself, rather than an installation of it
+func (s *AppTokenSource) signJWT() (string, error) {
+	now := s.now()
+	header := map[string]string{"alg": "RS256", "typ": "JWT"}
+	claims := map[string]any{
+		// Backdate the token in case GitHub's clock is behind ours, as GitHub recommends
+		"iat": now.Add(-time.Minute).Unix(),
+		"exp": now.Add(appJWTLifetime).Unix(),
+		"iss": strconv.FormatInt(s.appID, 10),
+	}
+
+	var segments []string
+	for _, part := range []any{header, claims} {
+		encoded, err := json.Marshal(part)
+		if err != nil {
+			return "", err
+		}
+		segments = append(segments, base64.RawURLEncoding.EncodeToString(encoded))
+	}
+
+	signingInput := strings.Join(segments, ".")
+	digest := sha256.Sum256([]byte(signingInput))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, s.privateKey, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", err
+	}
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+// parseRSAPrivateKey parses a PEM-encoded RSA private key in either PKCS #1 form, which is how GitHub issues them, or
+// PKCS #8 form
+func parseRSAPrivateKey(keyPEM []byte) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode(keyPEM)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM data found")
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	rsaKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("expected an RSA key, got %T", key)
+	}
+	return rsaKey, nil
+}
@@ -0,0 +1,149 @@
+package githubapp
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// fakeTokenEndpoint issues installation tokens that expire an hour after the time returned by now, after checking that
+// requests are authenticated with a JWT signed by the app's key
+type fakeTokenEndpoint struct {
+	t         *testing.T
+	publicKey *rsa.PublicKey
+	now       func() time.Time
+	status    int // The status to respond with. Zero means 201
+
+	mu       sync.Mutex
+	requests int
+}
+
+func (f *fakeTokenEndpoint) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	f.requests++
+	n := f.requests
+	f.mu.Unlock()
+
+	require.Equal(f.t, http.MethodPost, r.Method)
+	require.Equal(f.t, "/app/installations/42/access_tokens", r.URL.Path)
+	jwt, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	require.True(f.t, ok)
+	claims := verifyJWT(f.t, jwt, f.publicKey)
+	require.Equal(f.t, "7", claims["iss"])
+
+	if f.status != 0 {
+		w.WriteHeader(f.status)
+		_, _ = w.Write([]byte(`{"message": "Bad credentials"}`))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	_, _ = fmt.Fprintf(w, `{"token": "ghs_%d", "expires_at": "%s"}`, n, f.now().Add(time.Hour).UTC().Format(time.RFC3339))
+}
+
+// verifyJWT checks the signature of an RS256 JWT and returns its claims
+func verifyJWT(t *testing.T, jwt string, publicKey *rsa.PublicKey) map[string]any {
+	parts := strings.Split(jwt, ".")
+	require.Len(t, parts, 3)
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	require.NoError(t, err)
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	require.NoError(t, rsa.VerifyPKCS1v15(publicKey, crypto.SHA256, digest[:], signature))
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	require.NoError(t, err)
+	var claims map[string]any
+	require.NoError(t, json.Unmarshal(payload, &claims))
+	return claims
+}
+
+// newTestAppTokenSource creates a token source backed by a fake token endpoint, with a fake clock. Returns a function
+// that advances the clock
+func newTestAppTokenSource(t *testing.T, status int) (*AppTokenSource, *fakeTokenEndpoint, func(d time.Duration)) {
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(privateKey)})
+
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	clock := func() time.Time { return now }
+	endpoint := &fakeTokenEndpoint{t: t, publicKey: &privateKey.PublicKey, now: clock, status: status}
+	server := httptest.NewServer(endpoint)
+	t.Cleanup(server.Close)
+
+	source, err := NewAppTokenSource(7, 42, keyPEM, server.URL, server.Client())
+	require.NoError(t, err)
+	source.now = clock
+	return source, endpoint, func(d time.Duration) { now = now.Add(d) }
+}
+
+func TestAppTokenSource_ReusesToken(t *testing.T) {
+	source, endpoint, advance := newTestAppTokenSource(t, 0)
+
+	token, err := source.Token()
+	require.NoError(t, err)
+	require.Equal(t, "ghs_1", token.AccessToken)
+
+	advance(50 * time.Minute)
+	token, err = source.Token()
+	require.NoError(t, err)
+	require.Equal(t, "ghs_1", token.AccessToken)
+	require.Equal(t, 1, endpoint.requests)
+}
+
+func TestAppTokenSource_RefreshesBeforeExpiry(t *testing.T) {
+	source, endpoint, advance := newTestAppTokenSource(t, 0)
+
+	_, err := source.Token()
+	require.NoError(t, err)
+
+	// Within the refresh margin of the first token's expiry
+	advance(56 * time.Minute)
+	token, err := source.Token()
+	require.NoError(t, err)
+	require.Equal(t, "ghs_2", token.AccessToken)
+	require.Equal(t, 2, endpoint.requests)
+
+	// The new token is reused in turn
+	advance(10 * time.Minute)
+	token, err = source.Token()
+	require.NoError(t, err)
+	require.Equal(t, "ghs_2", token.AccessToken)
+	require.Equal(t, 2, endpoint.requests)
+}
+
+func TestAppTokenSource_ExpiryIncludesRefreshMargin(t *testing.T) {
+	source, _, _ := newTestAppTokenSource(t, 0)
+
+	issued := source.now()
+	token, err := source.Token()
+	require.NoError(t, err)
+	// Callers that cache the token themselves, rather than calling Token for every request, see the early expiry
+	require.Equal(t, issued.Add(time.Hour-appTokenRefreshMargin), token.Expiry)
+}
+
+func TestAppTokenSource_RequestFails(t *testing.T) {
+	source, _, _ := newTestAppTokenSource(t, http.StatusUnauthorized)
+
+	_, err := source.Token()
+	require.ErrorContains(t, err, "installation token request failed with status 401: {\"message\": \"Bad credentials\"}")
+}
+
+func TestNewAppTokenSource_InvalidKey(t *testing.T) {
+	_, err := NewAppTokenSource(7, 42, []byte("not a key"), "https://api.github.com/", nil)
+	require.ErrorContains(t, err, "failed to parse GitHub App private key")
+}
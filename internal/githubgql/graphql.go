@@ -0,0 +1,296 @@
+// Package githubgql provides access to parts of the GitHub API that the REST client doesn't expose, via GitHub's GraphQL
+// API.
+package githubgql
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/google/go-github/v72/github"
+)
+
+// GraphQLClient sends queries and mutations to GitHub's GraphQL API
+type GraphQLClient struct {
+	httpClient *http.Client
+	endpoint   string
+}
+
+// NewGraphQLClient creates a GraphQL client that sends requests to the given endpoint using the given HTTP client, which
+// is responsible for authentication
+func NewGraphQLClient(httpClient *http.Client, endpoint string) *GraphQLClient {
+	return &GraphQLClient{
+		httpClient: httpClient,
+		endpoint:   endpoint,
+	}
+}
+
+// NewGraphQLClientFromREST creates a GraphQL client that shares the authentication and host of the given REST client
+func NewGraphQLClientFromREST(restClient *github.Client) *GraphQLClient {
+	return NewGraphQLClient(restClient.Client(), graphQLEndpoint(restClient.BaseURL))
+}
+
+// graphQLEndpoint returns the GraphQL endpoint corresponding to a REST API base URL. GitHub Enterprise Server serves
+// REST at /api/v3/ and GraphQL at /api/graphql, while github.com serves GraphQL at /graphql on the API host
+func graphQLEndpoint(restBaseURL *url.URL) string {
+	u := *restBaseURL
+	if prefix, ok := strings.CutSuffix(u.Path, "/api/v3/"); ok {
+		u.Path = prefix + "/api/graphql"
+		return u.String()
+	}
+	return u.ResolveReference(&url.URL{Path: "graphql"}).String()
+}
+
+type graphQLRequest struct {
+	Query     string         `json:"query"`
+	Variables map[string]any `json:"variables,omitempty"`
+}
+
+// GraphQLError is returned when GitHub responds to a request with errors, e.g. because a requested object doesn't
+// exist
+type GraphQLError struct {
+	Messages []string
+}
+
+func (ge GraphQLError) Error() string {
+	return fmt.Sprintf("graphql errors: %s", strings.Join(ge.Messages, "; "))
+}
+
+type graphQLResponse struct {
+	Data   json.RawMessage `json:"data"`
+	Errors []struct {
+		Message string `json:"message"`
+	} `json:"errors"`
+}
+
+// Do sends a query or mutation with the given variables and unmarshals the response's data into result, which may be
+// nil if the data isn't needed
+func (c *GraphQLClient) Do(ctx context.Context, query string, variables map[string]any, result any) error {
+	body, err := json.Marshal(graphQLRequest{Query: query, Variables: variables})
+	if err != nil {
+		return fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status: %s", resp.Status)
+	}
+
+	var gqlResp graphQLResponse
+	if err := json.NewDecoder(resp.Body).Decode(&gqlResp); err != nil {
+		return fmt.Errorf("failed to decode response: %w", err)
+	}
+	if len(gqlResp.Errors) > 0 {
+		messages := make([]string, len(gqlResp.Errors))
+		for i, e := range gqlResp.Errors {
+			messages[i] = e.Message
+		}
+		return GraphQLError{Messages: messages}
+	}
+
+	if result == nil {
+		return nil
+	}
+	if err := json.Unmarshal(gqlResp.Data, result); err != nil {
+		return fmt.Errorf("failed to unmarshal data: %w", err)
+	}
+	return nil
+}
+
+const reviewThreadsQuery = `query($owner: String!, $repo: String!, $number: Int!, $after: String) {
+  repository(owner: $owner, name: $repo) {
+    pullRequest(number: $number) {
+      reviewThreads(first: 100, after: $after) {
+        nodes {
+          id
+          isResolved
+          comments(first: 1) {
+            nodes { databaseId }
+          }
+        }
+        pageInfo { hasNextPage endCursor }
+      }
+    }
+  }
+}`
+
+// ReviewThread is a thread of review comments on a pull request
+type ReviewThread struct {
+	ID         string // The thread's GraphQL node ID
+	IsResolved bool
+}
+
+// FindReviewThread returns the review thread on the given pull request whose first comment has the given REST ID.
+// Returns (nil, nil) if the pull request has no such thread
+func (c *GraphQLClient) FindReviewThread(ctx context.Context, owner string, repo string, prNumber int, rootCommentID int64) (*ReviewThread, error) {
+	var after *string
+	for {
+		var data struct {
+			Repository struct {
+				PullRequest *struct {
+					ReviewThreads struct {
+						Nodes []struct {
+							ID         string `json:"id"`
+							IsResolved bool   `json:"isResolved"`
+							Comments   struct {
+								Nodes []struct {
+									DatabaseID int64 `json:"databaseId"`
+								} `json:"nodes"`
+							} `json:"comments"`
+						} `json:"nodes"`
+						PageInfo struct {
+							HasNextPage bool   `json:"hasNextPage"`
+							EndCursor   string `json:"endCursor"`
+						} `json:"pageInfo"`
+					} `json:"reviewThreads"`
+				} `json:"pullRequest"`
+			} `json:"repository"`
+		}
+		variables := map[string]any{"owner": owner, "repo": repo, "number": prNumber, "after": after}
+		if err := c.Do(ctx, reviewThreadsQuery, variables, &data); err != nil {
+			return nil, fmt.Errorf("failed to list review threads: %w", err)
+		}
+
+		pr := data.Repository.PullRequest
+		if pr == nil {
+			return nil, fmt.Errorf("pull request #%d not found", prNumber)
+		}
+		for _, thread := range pr.ReviewThreads.Nodes {
+			if len(thread.Comments.Nodes) > 0 && thread.Comments.Nodes[0].DatabaseID == rootCommentID {
+				return &ReviewThread{ID: thread.ID, IsResolved: thread.IsResolved}, nil
+			}
+		}
+
+		if !pr.ReviewThreads.PageInfo.HasNextPage {
+			return nil, nil
+		}
+		after = &pr.ReviewThreads.PageInfo.EndCursor
+	}
+}
+
+const resolveReviewThreadMutation = `mutation($threadId: ID!) {
+  resolveReviewThread(input: {threadId: $threadId}) {
+    thread { id }
+  }
+}`
+
+// ResolveReviewThread marks the review thread with the given node ID as resolved
+func (c *GraphQLClient) ResolveReviewThread(ctx context.Context, threadID string) error {
+	if err := c.Do(ctx, resolveReviewThreadMutation, map[string]any{"threadId": threadID}, nil); err != nil {
+		return fmt.Errorf("failed to resolve review thread: %w", err)
+	}
+	return nil
+}
+
+const markPullRequestReadyForReviewMutation = `mutation($pullRequestId: ID!) {
+  markPullRequestReadyForReview(input: {pullRequestId: $pullRequestId}) {
+    pullRequest { id }
+  }
+}`
+
+// MarkPullRequestReadyForReview takes the draft pull request with the given node ID out of draft. The REST API has no
+// equivalent; it ignores attempts to change a pull request's draft status
+func (c *GraphQLClient) MarkPullRequestReadyForReview(ctx context.Context, pullRequestID string) error {
+	variables := map[string]any{"pullRequestId": pullRequestID}
+	if err := c.Do(ctx, markPullRequestReadyForReviewMutation, variables, nil); err != nil {
+		return fmt.Errorf("failed to mark pull request ready for review: %w", err)
+	}
+	return nil
+}
+
+// ErrRefNotFound is returned when a git ref doesn't exist
+var ErrRefNotFound = errors.New("ref not found")
+
+const blameQuery = `query($owner: String!, $repo: String!, $ref: String!, $path: String!) {
+  repository(owner: $owner, name: $repo) {
+    object(expression: $ref) {
+      ... on Commit {
+        blame(path: $path) {
+          ranges {
+            startingLine
+            endingLine
+            commit {
+              oid
+              authoredDate
+              messageHeadline
+              author { name }
+            }
+          }
+        }
+      }
+    }
+  }
+}`
+
+// BlameRange attributes a range of lines in a file to the commit that last changed them
+type BlameRange struct {
+	StartLine int // 1-based, inclusive
+	EndLine   int // 1-based, inclusive
+
+	CommitSHA       string
+	CommitHeadline  string
+	CommitAuthor    string
+	CommitTimestamp time.Time
+}
+
+// Blame returns the blame ranges of the file at the given path, as of the given ref. Returns ErrRefNotFound if the ref
+// doesn't exist
+func (c *GraphQLClient) Blame(ctx context.Context, owner string, repo string, ref string, path string) ([]BlameRange, error) {
+	var data struct {
+		Repository struct {
+			Object *struct {
+				Blame struct {
+					Ranges []struct {
+						StartingLine int `json:"startingLine"`
+						EndingLine   int `json:"endingLine"`
+						Commit       struct {
+							OID             string    `json:"oid"`
+							AuthoredDate    time.Time `json:"authoredDate"`
+							MessageHeadline string    `json:"messageHeadline"`
+							Author          struct {
+								Name string `json:"name"`
+							} `json:"author"`
+						} `json:"commit"`
+					} `json:"ranges"`
+				} `json:"blame"`
+			} `json:"object"`
+		} `json:"repository"`
+	}
+	variables := map[string]any{"owner": owner, "repo": repo, "ref": ref, "path": path}
+	if err := c.Do(ctx, blameQuery, variables, &data); err != nil {
+		return nil, fmt.Errorf("failed to get blame: %w", err)
+	}
+	if data.Repository.Object == nil {
+		return nil, fmt.Errorf("failed to get blame at '%s': %w", ref, ErrRefNotFound)
+	}
+
+	ranges := make([]BlameRange, len(data.Repository.Object.Blame.Ranges))
+	for i, r := range data.Repository.Object.Blame.Ranges {
+		ranges[i] = BlameRange{
+			StartLine:       r.StartingLine,
+			EndLine:         r.EndingLine,
+			CommitSHA:       r.Commit.OID,
+			CommitHeadline:  r.Commit.MessageHeadline,
+			CommitAuthor:    r.Commit.Author.Name,
+			CommitTimestamp: r.Commit.AuthoredDate,
+		}
+	}
+	return ranges, nil
+}
@@ -0,0 +1,133 @@
+package githubgql
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// roundTripperStub answers GraphQL requests with canned responses, in order, recording the requests it receives
+type roundTripperStub struct {
+	t         *testing.T
+	responses []string
+	requests  []graphQLRequest
+}
+
+func (rts *roundTripperStub) RoundTrip(req *http.Request) (*http.Response, error) {
+	var gqlReq graphQLRequest
+	require.NoError(rts.t, json.NewDecoder(req.Body).Decode(&gqlReq))
+	rts.requests = append(rts.requests, gqlReq)
+
+	require.NotEmpty(rts.t, rts.responses, "unexpected request")
+	body := rts.responses[0]
+	rts.responses = rts.responses[1:]
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Content-Type": []string{"application/json"}},
+		Body:       io.NopCloser(strings.NewReader(body)),
+		Request:    req,
+	}, nil
+}
+
+func newStubbedGraphQLClient(t *testing.T, responses ...string) (*GraphQLClient, *roundTripperStub) {
+	stub := &roundTripperStub{t: t, responses: responses}
+	return NewGraphQLClient(&http.Client{Transport: stub}, "https://api.github.com/graphql"), stub
+}
+
+func TestFindReviewThread_Paginated(t *testing.T) {
+	client, stub := newStubbedGraphQLClient(t,
+		`{"data": {"repository": {"pullRequest": {"reviewThreads": {
+			"nodes": [{"id": "T_1", "isResolved": false, "comments": {"nodes": [{"databaseId": 100}]}}],
+			"pageInfo": {"hasNextPage": true, "endCursor": "cursor1"}
+		}}}}}`,
+		`{"data": {"repository": {"pullRequest": {"reviewThreads": {
+			"nodes": [{"id": "T_2", "isResolved": true, "comments": {"nodes": [{"databaseId": 200}]}}],
+			"pageInfo": {"hasNextPage": false, "endCursor": "cursor2"}
+		}}}}}`,
+	)
+
+	thread, err := client.FindReviewThread(context.Background(), "owner", "repo", 12, 200)
+	require.NoError(t, err)
+	require.Equal(t, &ReviewThread{ID: "T_2", IsResolved: true}, thread)
+
+	require.Len(t, stub.requests, 2)
+	require.Nil(t, stub.requests[0].Variables["after"])
+	require.Equal(t, "cursor1", stub.requests[1].Variables["after"])
+	require.EqualValues(t, 12, stub.requests[1].Variables["number"])
+}
+
+func TestFindReviewThread_NotFound(t *testing.T) {
+	client, _ := newStubbedGraphQLClient(t,
+		`{"data": {"repository": {"pullRequest": {"reviewThreads": {
+			"nodes": [{"id": "T_1", "isResolved": false, "comments": {"nodes": [{"databaseId": 100}]}}],
+			"pageInfo": {"hasNextPage": false, "endCursor": "cursor1"}
+		}}}}}`,
+	)
+
+	thread, err := client.FindReviewThread(context.Background(), "owner", "repo", 12, 999)
+	require.NoError(t, err)
+	require.Nil(t, thread)
+}
+
+func TestResolveReviewThread(t *testing.T) {
+	client, stub := newStubbedGraphQLClient(t, `{"data": {"resolveReviewThread": {"thread": {"id": "T_1"}}}}`)
+
+	require.NoError(t, client.ResolveReviewThread(context.Background(), "T_1"))
+	require.Len(t, stub.requests, 1)
+	require.Contains(t, stub.requests[0].Query, "resolveReviewThread")
+	require.Equal(t, "T_1", stub.requests[0].Variables["threadId"])
+}
+
+func TestDo_GraphQLErrors(t *testing.T) {
+	client, _ := newStubbedGraphQLClient(t, `{"data": null, "errors": [{"message": "Resource not accessible by integration"}]}`)
+
+	err := client.ResolveReviewThread(context.Background(), "T_1")
+	require.ErrorContains(t, err, "Resource not accessible by integration")
+}
+
+func TestGraphQLEndpoint_GitHubCom(t *testing.T) {
+	baseURL, err := url.Parse("https://api.github.com/")
+	require.NoError(t, err)
+	require.Equal(t, "https://api.github.com/graphql", graphQLEndpoint(baseURL))
+}
+
+func TestGraphQLEndpoint_Enterprise(t *testing.T) {
+	baseURL, err := url.Parse("https://github.example.com/api/v3/")
+	require.NoError(t, err)
+	require.Equal(t, "https://github.example.com/api/graphql", graphQLEndpoint(baseURL))
+}
+
+func TestBlame(t *testing.T) {
+	client, stub := newStubbedGraphQLClient(t, `{"data": {"repository": {"object": {"blame": {"ranges": [
+		{"startingLine": 1, "endingLine": 3, "commit": {
+			"oid": "0123456789abcdef", "authoredDate": "2024-05-01T12:00:00Z", "messageHeadline": "Initial commit", "author": {"name": "Ada"}
+		}}
+	]}}}}}`)
+
+	ranges, err := client.Blame(context.Background(), "owner", "repo", "main", "main.go")
+	require.NoError(t, err)
+	require.Equal(t, []BlameRange{{
+		StartLine:       1,
+		EndLine:         3,
+		CommitSHA:       "0123456789abcdef",
+		CommitHeadline:  "Initial commit",
+		CommitAuthor:    "Ada",
+		CommitTimestamp: time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC),
+	}}, ranges)
+	require.Equal(t, "main", stub.requests[0].Variables["ref"])
+	require.Equal(t, "main.go", stub.requests[0].Variables["path"])
+}
+
+func TestBlame_RefNotFound(t *testing.T) {
+	client, _ := newStubbedGraphQLClient(t, `{"data": {"repository": {"object": null}}}`)
+
+	_, err := client.Blame(context.Background(), "owner", "repo", "no-such-branch", "main.go")
+	require.ErrorIs(t, err, ErrRefNotFound)
+}
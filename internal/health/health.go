@@ -0,0 +1,130 @@
+// Package health reports whether the bot is alive and making progress, for orchestrators that restart or route around
+// unhealthy instances
+package health
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	// probeTimeout bounds how long a single dependency probe may take
+	probeTimeout = 10 * time.Second
+	// probeCacheDuration is how long the result of a dependency probe is reused, so that frequent readiness checks don't
+	// send a request to each dependency every time
+	probeCacheDuration = 30 * time.Second
+)
+
+// Probe checks that a dependency, e.g. the GitHub API, is reachable. Returns an error if it isn't
+type Probe func(ctx context.Context) error
+
+type probeResult struct {
+	err       error
+	checkedAt time.Time
+}
+
+// Checker tracks when the bot last polled successfully and whether its dependencies are reachable. It is ready if the
+// last successful poll happened within twice the poll interval and every probe succeeds. Safe for concurrent use
+type Checker struct {
+	pollInterval time.Duration
+	probes       map[string]Probe
+
+	mu       sync.Mutex
+	started  time.Time
+	lastPoll time.Time
+	results  map[string]probeResult
+
+	// Overridden in tests
+	now func() time.Time
+}
+
+// NewChecker creates a checker for a bot that polls every pollInterval and depends on the services checked by the
+// given probes, keyed by service name
+func NewChecker(pollInterval time.Duration, probes map[string]Probe) *Checker {
+	return &Checker{
+		pollInterval: pollInterval,
+		probes:       probes,
+		started:      time.Now(),
+		results:      map[string]probeResult{},
+		now:          time.Now,
+	}
+}
+
+// RecordPoll records that a poll just succeeded
+func (c *Checker) RecordPoll() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.lastPoll = c.now()
+}
+
+// Ready returns nil if the bot is ready, or an error describing why it isn't
+func (c *Checker) Ready(ctx context.Context) error {
+	if err := c.checkPoll(); err != nil {
+		return err
+	}
+	for name, probe := range c.probes {
+		if err := c.runProbe(ctx, name, probe); err != nil {
+			return fmt.Errorf("%s is unreachable: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// checkPoll returns an error if the last successful poll is too old. Before the first poll, the time since the checker
+// was created is used instead, so that a freshly started bot gets a grace period
+func (c *Checker) checkPoll() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	maxAge := 2 * c.pollInterval
+	if c.lastPoll.IsZero() {
+		if age := c.now().Sub(c.started); age > maxAge {
+			return fmt.Errorf("no successful poll since starting %s ago", age.Round(time.Second))
+		}
+		return nil
+	}
+	if age := c.now().Sub(c.lastPoll); age > maxAge {
+		return fmt.Errorf("last successful poll was %s ago, more than %s", age.Round(time.Second), maxAge)
+	}
+	return nil
+}
+
+// runProbe runs the given probe, or reuses its last result if that is recent enough
+func (c *Checker) runProbe(ctx context.Context, name string, probe Probe) error {
+	c.mu.Lock()
+	result, ok := c.results[name]
+	c.mu.Unlock()
+	if ok && c.now().Sub(result.checkedAt) < probeCacheDuration {
+		return result.err
+	}
+
+	probeCtx, cancel := context.WithTimeout(ctx, probeTimeout)
+	defer cancel()
+	err := probe(probeCtx)
+
+	c.mu.Lock()
+	c.results[name] = probeResult{err: err, checkedAt: c.now()}
+	c.mu.Unlock()
+	return err
+}
+
+// Handler returns an http.Handler that serves /healthz, which succeeds as long as the process is up, and /readyz,
+// which succeeds only if the checker is ready
+func (c *Checker) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("ok\n"))
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		if err := c.Ready(r.Context()); err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		_, _ = w.Write([]byte("ok\n"))
+	})
+	return mux
+}
@@ -0,0 +1,76 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// newTestChecker creates a checker that polls every minute, with a fake clock starting at the time it was created.
+// Returns a function that advances the clock
+func newTestChecker(probes map[string]Probe) (*Checker, func(d time.Duration)) {
+	c := NewChecker(time.Minute, probes)
+	now := c.started
+	c.now = func() time.Time { return now }
+	return c, func(d time.Duration) { now = now.Add(d) }
+}
+
+func get(t *testing.T, c *Checker, path string) int {
+	rec := httptest.NewRecorder()
+	c.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+	return rec.Code
+}
+
+func TestChecker_ReadyAfterRecentPoll(t *testing.T) {
+	c, advance := newTestChecker(nil)
+	advance(5 * time.Minute)
+	c.RecordPoll()
+	advance(90 * time.Second)
+
+	require.NoError(t, c.Ready(context.Background()))
+	require.Equal(t, http.StatusOK, get(t, c, "/readyz"))
+}
+
+func TestChecker_StalePoll(t *testing.T) {
+	c, advance := newTestChecker(nil)
+	c.RecordPoll()
+	advance(2*time.Minute + time.Second)
+
+	require.ErrorContains(t, c.Ready(context.Background()), "last successful poll was 2m1s ago")
+	require.Equal(t, http.StatusServiceUnavailable, get(t, c, "/readyz"))
+	// The process is still up
+	require.Equal(t, http.StatusOK, get(t, c, "/healthz"))
+}
+
+func TestChecker_NoPollYet(t *testing.T) {
+	c, advance := newTestChecker(nil)
+	advance(time.Minute)
+	require.NoError(t, c.Ready(context.Background()), "a freshly started bot should get a grace period")
+
+	advance(2 * time.Minute)
+	require.ErrorContains(t, c.Ready(context.Background()), "no successful poll")
+}
+
+func TestChecker_UnreachableDependency(t *testing.T) {
+	calls := 0
+	c, advance := newTestChecker(map[string]Probe{
+		"GitHub": func(ctx context.Context) error {
+			calls++
+			return errors.New("connection refused")
+		},
+	})
+	c.RecordPoll()
+
+	require.ErrorContains(t, c.Ready(context.Background()), "GitHub is unreachable: connection refused")
+	require.Equal(t, http.StatusServiceUnavailable, get(t, c, "/readyz"))
+	require.Equal(t, 1, calls, "the probe result should be reused")
+
+	advance(probeCacheDuration)
+	require.Error(t, c.Ready(context.Background()))
+	require.Equal(t, 2, calls)
+}
@@ -0,0 +1,154 @@
+// Package logging provides leveled, structured logging with key-value fields. Loggers can emit either human-readable
+// text or JSON records suitable for ingestion by a log aggregator.
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+// Logger writes leveled log records. args are alternating keys and values that are attached to the record as fields
+type Logger interface {
+	Debug(msg string, args ...any)
+	Info(msg string, args ...any)
+	Warn(msg string, args ...any)
+	Error(msg string, args ...any)
+
+	// With returns a Logger that attaches the given key-value pairs to every record it writes
+	With(args ...any) Logger
+}
+
+// Formats accepted by New
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+// New creates a logger that writes records of the given format to w, omitting records below the given level. format
+// may be "text" or "json", and level may be "debug", "info", "warn", or "error". Empty strings select text and info,
+// respectively
+func New(w io.Writer, format string, level string) (Logger, error) {
+	var lvl slog.Level
+	if level != "" {
+		if err := lvl.UnmarshalText([]byte(level)); err != nil {
+			return nil, fmt.Errorf("invalid log level '%s': %w", level, err)
+		}
+	}
+
+	switch strings.ToLower(format) {
+	case "", FormatText:
+		return NewTextLogger(log.New(w, "", log.LstdFlags), lvl), nil
+	case FormatJSON:
+		return NewJSONLogger(w, lvl), nil
+	default:
+		return nil, fmt.Errorf("invalid log format '%s', expected '%s' or '%s'", format, FormatText, FormatJSON)
+	}
+}
+
+// Default returns a text logger that writes info-level records and above through the standard library's default
+// logger
+func Default() Logger {
+	return NewTextLogger(log.Default(), slog.LevelInfo)
+}
+
+// textLogger writes records as free-form text, with any fields appended as key=value pairs
+type textLogger struct {
+	out    *log.Logger
+	level  slog.Level
+	fields []any
+}
+
+// NewTextLogger creates a logger that writes human-readable records to out
+func NewTextLogger(out *log.Logger, level slog.Level) Logger {
+	return textLogger{out: out, level: level}
+}
+
+func (tl textLogger) Debug(msg string, args ...any) { tl.log(slog.LevelDebug, msg, args) }
+func (tl textLogger) Info(msg string, args ...any)  { tl.log(slog.LevelInfo, msg, args) }
+func (tl textLogger) Warn(msg string, args ...any)  { tl.log(slog.LevelWarn, msg, args) }
+func (tl textLogger) Error(msg string, args ...any) { tl.log(slog.LevelError, msg, args) }
+
+func (tl textLogger) With(args ...any) Logger {
+	return textLogger{
+		out:    tl.out,
+		level:  tl.level,
+		fields: append(tl.fields[:len(tl.fields):len(tl.fields)], args...),
+	}
+}
+
+func (tl textLogger) log(level slog.Level, msg string, args []any) {
+	if level < tl.level {
+		return
+	}
+
+	var sb strings.Builder
+	switch {
+	case level >= slog.LevelError:
+		sb.WriteString("Error: ")
+	case level >= slog.LevelWarn:
+		sb.WriteString("Warning: ")
+	}
+	sb.WriteString(msg)
+
+	// Build the fields through a slog record so that key-value pairs are interpreted exactly as slog would
+	record := slog.NewRecord(time.Time{}, level, msg, 0)
+	record.Add(tl.fields...)
+	record.Add(args...)
+	record.Attrs(func(attr slog.Attr) bool {
+		fmt.Fprintf(&sb, " %s=%s", attr.Key, formatTextValue(attr.Value))
+		return true
+	})
+
+	tl.out.Print(sb.String())
+}
+
+// formatTextValue formats a field value, quoting it if it would otherwise be ambiguous
+func formatTextValue(v slog.Value) string {
+	s := v.Resolve().String()
+	if s == "" || strings.ContainsAny(s, " \t\n\"=") {
+		return fmt.Sprintf("%q", s)
+	}
+	return s
+}
+
+// jsonLogger writes one JSON object per record
+type jsonLogger struct {
+	l *slog.Logger
+}
+
+// NewJSONLogger creates a logger that writes newline-delimited JSON records to w
+func NewJSONLogger(w io.Writer, level slog.Level) Logger {
+	return jsonLogger{l: slog.New(slog.NewJSONHandler(w, &slog.HandlerOptions{Level: level}))}
+}
+
+func (jl jsonLogger) Debug(msg string, args ...any) { jl.l.Debug(msg, args...) }
+func (jl jsonLogger) Info(msg string, args ...any)  { jl.l.Info(msg, args...) }
+func (jl jsonLogger) Warn(msg string, args ...any)  { jl.l.Warn(msg, args...) }
+func (jl jsonLogger) Error(msg string, args ...any) { jl.l.Error(msg, args...) }
+
+func (jl jsonLogger) With(args ...any) Logger {
+	return jsonLogger{l: jl.l.With(args...)}
+}
+
+type contextKey string
+
+// loggerKey is the context key under which a Logger is stored by WithLogger
+const loggerKey contextKey = "logger"
+
+// WithLogger returns a copy of ctx that carries the given logger
+func WithLogger(ctx context.Context, logger Logger) context.Context {
+	return context.WithValue(ctx, loggerKey, logger)
+}
+
+// FromContext returns the logger carried by ctx, or the default logger if there is none
+func FromContext(ctx context.Context) Logger {
+	if logger, ok := ctx.Value(loggerKey).(Logger); ok {
+		return logger
+	}
+	return Default()
+}
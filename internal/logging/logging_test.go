@@ -0,0 +1,83 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestJSONLogger_Fields(t *testing.T) {
+	var buf bytes.Buffer
+	logger := NewJSONLogger(&buf, slog.LevelInfo).With("owner", "cchalm", "repo", "blundering-savant", "issue_number", 42)
+
+	logger.Info("Processing AI response", "iteration", 3)
+	logger.Warn("failed to write conversation")
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	require.Len(t, lines, 2)
+
+	for _, line := range lines {
+		var record map[string]any
+		require.NoError(t, json.Unmarshal([]byte(line), &record))
+		require.Equal(t, "cchalm", record["owner"])
+		require.Equal(t, "blundering-savant", record["repo"])
+		require.EqualValues(t, 42, record["issue_number"])
+	}
+
+	var first map[string]any
+	require.NoError(t, json.Unmarshal([]byte(lines[0]), &first))
+	require.Equal(t, "INFO", first["level"])
+	require.Equal(t, "Processing AI response", first["msg"])
+	require.EqualValues(t, 3, first["iteration"])
+}
+
+func TestJSONLogger_Level(t *testing.T) {
+	var buf bytes.Buffer
+	logger := NewJSONLogger(&buf, slog.LevelWarn)
+
+	logger.Debug("debug")
+	logger.Info("info")
+	logger.Warn("warn")
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	require.Len(t, lines, 1)
+	require.Contains(t, lines[0], `"msg":"warn"`)
+}
+
+func TestTextLogger_Format(t *testing.T) {
+	var buf bytes.Buffer
+	logger := NewTextLogger(log.New(&buf, "", 0), slog.LevelInfo).With("issue_number", 7)
+
+	logger.Debug("hidden")
+	logger.Info("Executing tool", "tool", "view_diff")
+	logger.Warn("failed to create logs directory", "error", "permission denied")
+
+	expected := "Executing tool issue_number=7 tool=view_diff\n" +
+		"Warning: failed to create logs directory issue_number=7 error=\"permission denied\"\n"
+	require.Equal(t, expected, buf.String())
+}
+
+func TestNew_InvalidFormat(t *testing.T) {
+	_, err := New(&bytes.Buffer{}, "xml", "")
+	require.Error(t, err)
+}
+
+func TestNew_InvalidLevel(t *testing.T) {
+	_, err := New(&bytes.Buffer{}, "json", "verbose")
+	require.Error(t, err)
+}
+
+func TestFromContext(t *testing.T) {
+	var buf bytes.Buffer
+	logger := NewJSONLogger(&buf, slog.LevelInfo)
+	ctx := WithLogger(context.Background(), logger)
+
+	FromContext(ctx).Info("hello")
+	require.Contains(t, buf.String(), `"msg":"hello"`)
+}
@@ -0,0 +1,29 @@
+// Package metrics records counters and histograms describing the bot's work, e.g. how many tasks it has processed and
+// how long its tools take to run
+package metrics
+
+// Names of the metrics that the bot records
+const (
+	TasksProcessed = "tasks_processed_total" // Labels: outcome ("success", "error", "transient_error", "ai_unavailable", or "interrupted")
+	TasksBlocked   = "tasks_blocked_total"   // Tasks that were labeled as blocked, e.g. because of an error
+	ToolCalls      = "tool_calls_total"      // Labels: tool, outcome ("success", "input_error", or "error")
+	ToolLatency    = "tool_latency_seconds"  // Labels: tool
+	Summarizations = "summarizations_total"  // Conversations summarized to stay within the token limit
+)
+
+// Labels distinguish the series of a metric, e.g. the tool that a tool call was for
+type Labels map[string]string
+
+// Metrics records counters and histograms. Implementations must be safe for concurrent use
+type Metrics interface {
+	// IncCounter adds one to the counter with the given name and labels
+	IncCounter(name string, labels Labels)
+	// ObserveHistogram records a sample in the histogram with the given name and labels
+	ObserveHistogram(name string, value float64, labels Labels)
+}
+
+// Noop is a Metrics that discards everything it records
+type Noop struct{}
+
+func (Noop) IncCounter(string, Labels)                {}
+func (Noop) ObserveHistogram(string, float64, Labels) {}
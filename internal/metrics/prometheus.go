@@ -0,0 +1,76 @@
+package metrics
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// namespace prefixes the names of all metrics exported to Prometheus
+const namespace = "blundering_savant"
+
+// Prometheus is a Metrics that exports the bot's metrics to Prometheus. Metrics with names not listed in this package
+// are dropped, as are samples whose labels don't match their metric's
+type Prometheus struct {
+	registry   *prometheus.Registry
+	counters   map[string]*prometheus.CounterVec
+	histograms map[string]*prometheus.HistogramVec
+}
+
+// NewPrometheus creates a Prometheus metrics recorder with its own registry
+func NewPrometheus() *Prometheus {
+	p := &Prometheus{
+		registry:   prometheus.NewRegistry(),
+		counters:   map[string]*prometheus.CounterVec{},
+		histograms: map[string]*prometheus.HistogramVec{},
+	}
+
+	p.addCounter(TasksProcessed, "Tasks worked on, by outcome", "outcome")
+	p.addCounter(TasksBlocked, "Tasks labeled as blocked because they need a human's attention")
+	p.addCounter(ToolCalls, "Tool calls run on behalf of the AI, by tool and outcome", "tool", "outcome")
+	p.addCounter(Summarizations, "Conversations summarized to stay within the token limit")
+	p.addHistogram(ToolLatency, "How long tool calls take to run, by tool", prometheus.DefBuckets, "tool")
+
+	return p
+}
+
+func (p *Prometheus) addCounter(name string, help string, labelNames ...string) {
+	counter := prometheus.NewCounterVec(prometheus.CounterOpts{Namespace: namespace, Name: name, Help: help}, labelNames)
+	p.registry.MustRegister(counter)
+	p.counters[name] = counter
+}
+
+func (p *Prometheus) addHistogram(name string, help string, buckets []float64, labelNames ...string) {
+	histogram := prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{Namespace: namespace, Name: name, Help: help, Buckets: buckets},
+		labelNames,
+	)
+	p.registry.MustRegister(histogram)
+	p.histograms[name] = histogram
+}
+
+func (p *Prometheus) IncCounter(name string, labels Labels) {
+	counter, ok := p.counters[name]
+	if !ok {
+		return
+	}
+	if c, err := counter.GetMetricWith(prometheus.Labels(labels)); err == nil {
+		c.Inc()
+	}
+}
+
+func (p *Prometheus) ObserveHistogram(name string, value float64, labels Labels) {
+	histogram, ok := p.histograms[name]
+	if !ok {
+		return
+	}
+	if h, err := histogram.GetMetricWith(prometheus.Labels(labels)); err == nil {
+		h.Observe(value)
+	}
+}
+
+// Handler returns an http.Handler that serves the recorded metrics in Prometheus' exposition format
+func (p *Prometheus) Handler() http.Handler {
+	return promhttp.HandlerFor(p.registry, promhttp.HandlerOpts{})
+}
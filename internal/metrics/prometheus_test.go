@@ -0,0 +1,42 @@
+package metrics
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func scrape(t *testing.T, p *Prometheus) string {
+	rec := httptest.NewRecorder()
+	p.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	body, err := io.ReadAll(rec.Body)
+	require.NoError(t, err)
+	return string(body)
+}
+
+func TestPrometheus_Records(t *testing.T) {
+	p := NewPrometheus()
+	p.IncCounter(ToolCalls, Labels{"tool": "view_diff", "outcome": "success"})
+	p.IncCounter(ToolCalls, Labels{"tool": "view_diff", "outcome": "success"})
+	p.ObserveHistogram(ToolLatency, 0.25, Labels{"tool": "view_diff"})
+
+	body := scrape(t, p)
+	require.Contains(t, body, `blundering_savant_tool_calls_total{outcome="success",tool="view_diff"} 2`)
+	require.Contains(t, body, `blundering_savant_tool_latency_seconds_count{tool="view_diff"} 1`)
+}
+
+func TestPrometheus_DropsUnknownMetricsAndLabels(t *testing.T) {
+	p := NewPrometheus()
+	p.IncCounter("unknown_total", nil)
+	p.IncCounter(ToolCalls, Labels{"wrong": "label"})
+	p.ObserveHistogram(ToolLatency, 1, nil)
+
+	body := scrape(t, p)
+	require.NotContains(t, body, "unknown_total")
+	require.NotContains(t, body, "wrong")
+	require.NotContains(t, body, "tool_latency_seconds_count")
+}
@@ -0,0 +1,42 @@
+package task
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// RepoAllowlist lists the repositories that the bot may act on, as "owner/repo" patterns in which "*" matches any part
+// of an owner or repository name, e.g. "myorg/*". Matching is case-insensitive, as GitHub names are. An empty allowlist
+// allows every repository
+type RepoAllowlist []string
+
+// NewRepoAllowlist creates an allowlist from the given patterns. Returns an error if any pattern is malformed
+func NewRepoAllowlist(patterns []string) (RepoAllowlist, error) {
+	allowlist := make(RepoAllowlist, 0, len(patterns))
+	for _, pattern := range patterns {
+		owner, repo, ok := strings.Cut(pattern, "/")
+		if !ok || owner == "" || repo == "" || strings.Contains(repo, "/") {
+			return nil, fmt.Errorf("'%s' is not of the form owner/repo", pattern)
+		}
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("'%s' is not a valid pattern: %w", pattern, err)
+		}
+		allowlist = append(allowlist, strings.ToLower(pattern))
+	}
+	return allowlist, nil
+}
+
+// Allows returns true if the given repository matches a pattern in the allowlist, or the allowlist is empty
+func (ra RepoAllowlist) Allows(owner string, repo string) bool {
+	if len(ra) == 0 {
+		return true
+	}
+	name := strings.ToLower(owner + "/" + repo)
+	for _, pattern := range ra {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
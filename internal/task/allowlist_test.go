@@ -0,0 +1,29 @@
+package task
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRepoAllowlist_Allows(t *testing.T) {
+	allowlist, err := NewRepoAllowlist([]string{"myorg/*", "partner/api"})
+	require.NoError(t, err)
+
+	require.True(t, allowlist.Allows("myorg", "web"))
+	require.True(t, allowlist.Allows("MyOrg", "Web"), "matching should be case-insensitive")
+	require.True(t, allowlist.Allows("partner", "api"))
+	require.False(t, allowlist.Allows("partner", "web"))
+	require.False(t, allowlist.Allows("myorg-fork", "web"))
+}
+
+func TestRepoAllowlist_EmptyAllowsAll(t *testing.T) {
+	require.True(t, RepoAllowlist(nil).Allows("anyone", "anything"))
+}
+
+func TestNewRepoAllowlist_Malformed(t *testing.T) {
+	for _, pattern := range []string{"myorg", "myorg/", "/repo", "a/b/c", "myorg/[web"} {
+		_, err := NewRepoAllowlist([]string{pattern})
+		require.Error(t, err, pattern)
+	}
+}
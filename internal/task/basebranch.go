@@ -0,0 +1,62 @@
+package task
+
+import (
+	"context"
+	"errors"
+	"log"
+	"regexp"
+	"strings"
+
+	"github.com/cchalm/blundering-savant/internal/vcs"
+)
+
+// baseBranchLabelPrefix starts labels that choose the branch that a task's pull request targets, e.g.
+// "base:release/2.0"
+const baseBranchLabelPrefix = "base:"
+
+// baseBranchDirectiveRegex matches lines in an issue body that choose the branch that the task's pull request targets,
+// e.g. "base: release/2.0"
+var baseBranchDirectiveRegex = regexp.MustCompile(`(?im)^[ \t]*base:[ \t]*(\S+)[ \t]*$`)
+
+// parseBaseBranchOverride returns the branch that the given issue asks its pull request to target, or "" if it doesn't
+// say. A label takes precedence over a line in the body, since only users with triage access can add labels. Anyone can
+// open an issue, so a line in the body is only obeyed if the issue's author may give the bot commands
+func parseBaseBranchOverride(issue GithubIssue, commands CommandConfig) string {
+	for _, label := range issue.Labels {
+		if branch, ok := strings.CutPrefix(label, baseBranchLabelPrefix); ok && strings.TrimSpace(branch) != "" {
+			return strings.TrimSpace(branch)
+		}
+	}
+	match := baseBranchDirectiveRegex.FindStringSubmatch(issue.Body)
+	if match == nil {
+		return ""
+	}
+	if !commands.isAllowedAuthor(issue.Author, issue.AuthorAssociation) {
+		log.Printf("[taskgen] Warning: Ignoring base branch '%s' in issue #%d in %s/%s: @%s may not give the bot commands",
+			match[1], issue.Number, issue.Owner, issue.Repo, issue.Author)
+		return ""
+	}
+	return match[1]
+}
+
+// resolveTargetBranch returns the branch that the given issue's pull request should target: the branch that the issue
+// asks for, if it exists, or the repository's default branch otherwise. A branch that doesn't exist is ignored with a
+// warning rather than failing the task, since it is most likely a typo
+func (tb builder) resolveTargetBranch(ctx context.Context, issue GithubIssue, defaultBranch string) string {
+	override := parseBaseBranchOverride(issue, tb.commands)
+	if override == "" || override == defaultBranch {
+		return defaultBranch
+	}
+
+	_, err := tb.vcs.GetBranchHead(ctx, issue.Owner, issue.Repo, override)
+	if errors.Is(err, vcs.ErrNotFound) {
+		log.Printf("[taskgen] Warning: Issue #%d asks for base branch '%s', which doesn't exist in %s/%s. Using '%s'",
+			issue.Number, override, issue.Owner, issue.Repo, defaultBranch)
+		return defaultBranch
+	} else if err != nil {
+		log.Printf("[taskgen] Warning: Could not check base branch '%s' in %s/%s, using '%s': %v",
+			override, issue.Owner, issue.Repo, defaultBranch, err)
+		return defaultBranch
+	}
+	return override
+}
@@ -0,0 +1,74 @@
+package task
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/cchalm/blundering-savant/internal/vcs"
+	"github.com/google/go-github/v72/github"
+	"github.com/stretchr/testify/require"
+)
+
+// testResolveTargetBranch resolves the target branch of the given issue in a repository whose default branch is "main"
+// and which also has a "release/2.0" branch
+func testResolveTargetBranch(t *testing.T, issue GithubIssue, expected string) {
+	testResolveTargetBranchWithCommands(t, CommandConfig{}, issue, expected)
+}
+
+// testResolveTargetBranchWithCommands is like testResolveTargetBranch, but with the given command config
+func testResolveTargetBranchWithCommands(t *testing.T, commands CommandConfig, issue GithubIssue, expected string) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Path == "/repos/owner/repo/branches/release/2.0" {
+			_, _ = w.Write([]byte(`{"name": "release/2.0", "commit": {"sha": "abc123"}}`))
+			return
+		}
+		w.WriteHeader(http.StatusNotFound)
+		_, _ = w.Write([]byte(`{"message": "Not Found"}`))
+	}))
+	t.Cleanup(server.Close)
+
+	client := github.NewClient(nil)
+	baseURL, err := url.Parse(server.URL + "/")
+	require.NoError(t, err)
+	client.BaseURL = baseURL
+
+	issue.Owner, issue.Repo, issue.Number = "owner", "repo", 1
+	tb := NewBuilder(vcs.NewGithubProvider(client), &github.User{Login: github.Ptr("bot")}, commands)
+	require.Equal(t, expected, tb.resolveTargetBranch(context.Background(), issue, "main"))
+}
+
+func TestResolveTargetBranch_BodyOverride(t *testing.T) {
+	issue := GithubIssue{Body: "The widget is broken.\n\nbase: release/2.0\n", Author: "alice", AuthorAssociation: "OWNER"}
+	testResolveTargetBranch(t, issue, "release/2.0")
+}
+
+func TestResolveTargetBranch_BodyOverrideFromUntrustedAuthor(t *testing.T) {
+	issue := GithubIssue{Body: "base: release/2.0", Author: "mallory", AuthorAssociation: "NONE"}
+	testResolveTargetBranch(t, issue, "main")
+}
+
+func TestResolveTargetBranch_BodyOverrideFromAllowedUser(t *testing.T) {
+	commands := CommandConfig{AllowedUsers: []string{"alice"}}
+	testResolveTargetBranchWithCommands(t, commands, GithubIssue{Body: "base: release/2.0", Author: "alice"}, "release/2.0")
+	// Only the allowed users may give commands, however they are associated with the repository
+	issue := GithubIssue{Body: "base: release/2.0", Author: "bob", AuthorAssociation: "OWNER"}
+	testResolveTargetBranchWithCommands(t, commands, issue, "main")
+}
+
+func TestResolveTargetBranch_LabelOverride(t *testing.T) {
+	// Only users with triage access can add labels, so a label is obeyed whoever opened the issue
+	issue := GithubIssue{Labels: []string{"bug", "base:release/2.0"}, Body: "base: release/1.0", AuthorAssociation: "NONE"}
+	testResolveTargetBranch(t, issue, "release/2.0")
+}
+
+func TestResolveTargetBranch_NonexistentBranch(t *testing.T) {
+	testResolveTargetBranch(t, GithubIssue{Body: "base: release/9.9", AuthorAssociation: "OWNER"}, "main")
+}
+
+func TestResolveTargetBranch_NoOverride(t *testing.T) {
+	testResolveTargetBranch(t, GithubIssue{Body: "Please fix the database: it is slow"}, "main")
+}
@@ -1,29 +1,90 @@
 package task
 
 import (
+	"cmp"
 	"context"
+	"errors"
 	"fmt"
 	"log"
+	"net/http"
+	"regexp"
 	"slices"
+	"strconv"
 	"strings"
+	"sync"
 
+	"github.com/cchalm/blundering-savant/internal/vcs"
 	"github.com/google/go-github/v72/github"
+	"golang.org/x/sync/errgroup"
+)
+
+const (
+	// defaultReactionLookupConcurrency bounds the number of comments whose reactions are looked up at once
+	defaultReactionLookupConcurrency = 8
+	// defaultPrefetchConcurrency bounds the number of independent pieces of information, e.g. the file tree and the
+	// README, that are fetched at once for a task. Kept low, since GitHub's secondary rate limits penalize bursts of
+	// concurrent requests
+	defaultPrefetchConcurrency = 4
 )
 
 type builder struct {
-	githubClient *github.Client
-	githubUser   *github.User
+	vcs        vcs.Provider
+	githubUser *github.User
+	commands   CommandConfig
+	repoInfo   *repoInfoCache
+
+	imageClient *http.Client // Downloads images embedded in issues and comments. See setImageHosts
+	imageHosts  []string     // The hosts that images may be downloaded from
+	images      *imageCache
+
+	reactionLookupConcurrency int
+	prefetchConcurrency       int
+	fetches                   fetchLimiter // Bounds the requests made at once for the task being built. Nil if unbounded
+}
+
+// fetchLimiter bounds the number of requests made at once, however deeply the groups of fetches making them are nested.
+// Only fetches that make requests themselves take a slot, so that a group waiting on its nested fetches doesn't starve
+// them
+type fetchLimiter chan struct{}
+
+func newFetchLimiter(limit int) fetchLimiter {
+	return make(fetchLimiter, max(limit, 1))
+}
+
+// do calls fetch once a slot is free, or returns the context's error if it's cancelled first. A nil limiter calls fetch
+// right away
+func (fl fetchLimiter) do(ctx context.Context, fetch func() error) error {
+	if fl == nil {
+		return fetch()
+	}
+	select {
+	case fl <- struct{}{}:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	defer func() { <-fl }()
+	return fetch()
 }
 
-func NewBuilder(githubClient *github.Client, user *github.User) builder {
+func NewBuilder(provider vcs.Provider, user *github.User, commands CommandConfig) builder {
 	return builder{
-		githubClient: githubClient,
-		githubUser:   user,
+		vcs:        provider,
+		githubUser: user,
+		commands:   commands,
+		repoInfo:   newRepoInfoCache(),
+
+		imageClient: newImageClient(defaultImageHosts),
+		imageHosts:  defaultImageHosts,
+		images:      newImageCache(),
+
+		reactionLookupConcurrency: defaultReactionLookupConcurrency,
+		prefetchConcurrency:       defaultPrefetchConcurrency,
 	}
 }
 
-func (tb builder) BuildTask(ctx context.Context, owner string, repo string, issueNumber int) (*Task, error) {
-	issue, _, err := tb.githubClient.Issues.Get(ctx, owner, repo, issueNumber)
+// BuildTask builds a task for the given issue. The task's pull request also fixes additionalIssues, if any
+func (tb builder) BuildTask(ctx context.Context, owner string, repo string, issueNumber int, additionalIssues ...int) (*Task, error) {
+	issue, err := tb.vcs.GetIssue(ctx, owner, repo, issueNumber)
 	if err != nil {
 		return nil, fmt.Errorf("failed to fetch issue %d from repo '%s/%s': %w", issueNumber, owner, repo, err)
 	}
@@ -33,82 +94,150 @@ func (tb builder) BuildTask(ctx context.Context, owner string, repo string, issu
 		return nil, fmt.Errorf("failed to convert issue: %w", err)
 	}
 
-	return tb.buildTaskFromIssue(ctx, converted)
+	var additional []GithubIssue
+	for _, number := range additionalIssues {
+		issue, err := tb.vcs.GetIssue(ctx, owner, repo, number)
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch additional issue %d from repo '%s/%s': %w", number, owner, repo, err)
+		}
+		converted, err := convertIssue(issue)
+		if err != nil {
+			return nil, fmt.Errorf("failed to convert additional issue %d: %w", number, err)
+		}
+		additional = append(additional, converted)
+	}
+
+	return tb.buildTaskFromIssue(ctx, converted, additional)
 }
 
-func (tb builder) buildTaskFromIssue(ctx context.Context, issue GithubIssue) (*Task, error) {
+func (tb builder) buildTaskFromIssue(ctx context.Context, issue GithubIssue, additionalIssues []GithubIssue) (*Task, error) {
+	if len(additionalIssues) == 0 {
+		// Callers that only know about one issue, like the poll loop, must still find the pull request if it was opened
+		// for several issues, rather than open a duplicate
+		var err error
+		issue, additionalIssues, err = tb.findIssuesFixedTogether(ctx, issue)
+		if err != nil {
+			return nil, fmt.Errorf("failed to find other issues fixed together with issue %d: %w", issue.Number, err)
+		}
+	}
+
+	issue.FormFields = parseIssueForm(issue.Body)
+	for i := range additionalIssues {
+		additionalIssues[i].FormFields = parseIssueForm(additionalIssues[i].Body)
+	}
+
 	tsk := Task{
-		Issue: issue,
+		Issue:            issue,
+		AdditionalIssues: additionalIssues,
+
+		ignoreLabelName: tb.commands.IgnoreLabel,
 	}
 
 	owner, repo := issue.Owner, issue.Repo
 
-	repoInfo, _, err := tb.githubClient.Repositories.Get(ctx, owner, repo)
+	// The repository is needed up front for its default branch, which other fetches depend on
+	repoInfo, err := tb.vcs.GetRepository(ctx, owner, repo)
 	if err != nil {
 		return nil, fmt.Errorf("failed to fetch repo info: %w", err)
 	}
 	if repoInfo.DefaultBranch == nil {
 		return nil, fmt.Errorf("nil default branch")
 	}
-
-	tsk.TargetBranch = *repoInfo.DefaultBranch
-	tsk.SourceBranch = getSourceBranchName(issue)
-
-	// Get the existing pull request, if any
-	pr, err := getPullRequest(ctx, tb.githubClient, owner, repo, tsk.SourceBranch, *tb.githubUser.Login)
-	if err != nil {
-		return nil, fmt.Errorf("failed to get pull request for branch: %w", err)
-	}
-	tsk.PullRequest = pr
-
-	// Get repository
-	repository, _, err := tb.githubClient.Repositories.Get(ctx, owner, repo)
-	if err != nil {
-		return nil, fmt.Errorf("failed to get repository: %w", err)
-	}
-	tsk.Repository = repository
-
-	// Get style guide
-	styleGuide, err := tb.findStyleGuides(ctx, owner, repo)
-	if err != nil {
-		log.Printf("[taskgen] Warning: Could not find style guides: %v", err)
-	}
-	tsk.StyleGuide = styleGuide
-
-	// Get codebase info
-	codebaseInfo, err := tb.analyzeCodebase(ctx, owner, repo)
-	if err != nil {
-		log.Printf("[taskgen] Warning: Could not analyze codebase: %v", err)
+	tsk.Repository = repoInfo
+	tsk.SourceBranch = getSourceBranchName(tsk.IssueNumbers(), issue.Title)
+
+	// The rest are independent, so fetch them concurrently. Optional information is logged and left empty if it can't
+	// be fetched, while a failure to fetch required information cancels the other fetches and fails the task. All of
+	// the task's fetches, including nested ones, share one limit, so the groups themselves are unlimited
+	tb.fetches = newFetchLimiter(tb.prefetchConcurrency)
+	g, gctx := errgroup.WithContext(ctx)
+	g.Go(func() error {
+		return tb.fetches.do(gctx, func() error {
+			tsk.TargetBranch = tb.resolveTargetBranch(gctx, issue, *repoInfo.DefaultBranch)
+			return nil
+		})
+	})
+	g.Go(func() error {
+		return tb.fetches.do(gctx, func() error {
+			pr, err := getPullRequest(gctx, tb.vcs, owner, repo, tsk.SourceBranch, *tb.githubUser.Login)
+			if err != nil {
+				return fmt.Errorf("failed to get pull request for branch: %w", err)
+			}
+			tsk.PullRequest = pr
+			return nil
+		})
+	})
+	g.Go(func() error {
+		return tb.fetches.do(gctx, func() error {
+			tsk.RepoConfig = tb.loadRepoConfig(gctx, owner, repo)
+			return nil
+		})
+	})
+	g.Go(func() error {
+		// Get style guides and codebase info. Makes several fetches of its own, each of which takes a slot
+		tsk.StyleGuide, tsk.CodebaseInfo = tb.getRepoInfo(gctx, owner, repo, *repoInfo.DefaultBranch)
+		return nil
+	})
+	g.Go(func() error {
+		return tb.fetches.do(gctx, func() error {
+			comments, err := tb.getAllIssueComments(gctx, owner, repo, issue.Number)
+			if err != nil {
+				log.Printf("[taskgen] Warning: Could not get issue comments: %v", err)
+			}
+			tsk.IssueComments = comments
+			return nil
+		})
+	})
+	if err := g.Wait(); err != nil {
+		return nil, err
 	}
-	tsk.CodebaseInfo = codebaseInfo
 
-	comments, err := tb.getAllIssueComments(ctx, owner, repo, issue.Number)
+	// Depends on the issue comments, the pull request, and the repository's config
+	tsk.Plan, err = tb.getPlanState(ctx, tsk)
 	if err != nil {
-		log.Printf("[taskgen] Warning: Could not get issue comments: %v", err)
+		return nil, fmt.Errorf("could not get plan state: %w", err)
 	}
-	tsk.IssueComments = comments
 
 	// If there is a PR, get PR comments, reviews, and review comments
-	if pr != nil {
-		// Get PR comments
-		comments, err := tb.getAllIssueComments(ctx, owner, repo, pr.Number)
-		if err != nil {
-			return nil, fmt.Errorf("could not get pull request comments: %w", err)
-		}
-		tsk.PRComments = comments
-
-		// Get reviews
-		reviews, err := tb.getAllPRReviews(ctx, owner, repo, pr.Number)
-		if err != nil {
-			return nil, fmt.Errorf("could not get PR reviews: %w", err)
+	if pr := tsk.PullRequest; pr != nil {
+		var reviewComments []*github.PullRequestComment
+
+		g, gctx := errgroup.WithContext(ctx)
+		g.Go(func() error {
+			return tb.fetches.do(gctx, func() error {
+				comments, err := tb.getAllIssueComments(gctx, owner, repo, pr.Number)
+				if err != nil {
+					return fmt.Errorf("could not get pull request comments: %w", err)
+				}
+				tsk.PRComments = comments
+				return nil
+			})
+		})
+		g.Go(func() error {
+			return tb.fetches.do(gctx, func() error {
+				reviews, err := tb.getAllPRReviews(gctx, owner, repo, pr.Number)
+				if err != nil {
+					return fmt.Errorf("could not get PR reviews: %w", err)
+				}
+				tsk.PRReviews = reviews
+				return nil
+			})
+		})
+		g.Go(func() error {
+			return tb.fetches.do(gctx, func() error {
+				var err error
+				reviewComments, err = tb.getAllPRReviewComments(gctx, owner, repo, pr.Number)
+				if err != nil {
+					return fmt.Errorf("could not get PR comments: %w", err)
+				}
+				return nil
+			})
+		})
+		if err := g.Wait(); err != nil {
+			return nil, err
 		}
-		tsk.PRReviews = reviews
 
 		// Get PR review comment threads
-		reviewComments, err := tb.getAllPRReviewComments(ctx, owner, repo, pr.Number)
-		if err != nil {
-			return nil, fmt.Errorf("could not get PR comments: %w", err)
-		}
 		reviewCommentThreads, err := organizePRReviewCommentsIntoThreads(reviewComments)
 		if err != nil {
 			return nil, fmt.Errorf("could not organize review comments into threads: %w", err)
@@ -130,35 +259,64 @@ func (tb builder) buildTaskFromIssue(ctx context.Context, issue GithubIssue) (*T
 	if err != nil {
 		return nil, fmt.Errorf("could not get PR review comments requiring response: %w", err)
 	}
-	tsk.IssueCommentsRequiringResponses = commentsReq
-	tsk.PRCommentsRequiringResponses = prCommentsReq
+	// Commands are for the bot, not the AI, so they don't need responses
+	tsk.IssueCommentsRequiringResponses = tb.commands.extractCommands(commentsReq, &tsk.Directives)
+	tsk.PRCommentsRequiringResponses = tb.commands.extractCommands(prCommentsReq, &tsk.Directives)
 	tsk.PRReviewCommentsRequiringResponses = prReviewCommentsReq
+	tsk.AttentionReason, _ = needsAttention(tsk)
+
+	// Only download images for tasks that will be worked on, rather than for every issue on every poll
+	if tsk.AttentionReason != AttentionNone {
+		bodies := []string{issue.Body}
+		for _, comment := range tsk.IssueComments {
+			bodies = append(bodies, comment.GetBody())
+		}
+		tsk.Images = tb.fetchImages(ctx, bodies...)
+	}
 
 	return &tsk, nil
 }
 
-func (tb builder) NeedsAttention(task Task) bool {
+// NeedsAttention returns whether the given task needs the bot's attention, and if so, why
+func (tb builder) NeedsAttention(task Task) (AttentionReason, bool) {
+	return needsAttention(task)
+}
+
+func needsAttention(task Task) (AttentionReason, bool) {
+	if slices.Contains(task.Issue.Labels, *task.IgnoreLabel().Name) {
+		// The bot decided to leave the issue alone, and nobody has removed the label since
+		return AttentionNone, false
+	}
 	if len(task.IssueComments) == 0 && task.PullRequest == nil {
 		// If there are no issue comments and no pull request, this is a brand new issue and requires our attention
-		return true
+		return AttentionNewIssue, true
+	}
+	if len(task.Directives.Comments) > 0 {
+		return AttentionCommand, true
 	}
 	// Check if there are comments needing responses
-	if len(task.IssueCommentsRequiringResponses) > 0 ||
-		len(task.PRCommentsRequiringResponses) > 0 ||
-		len(task.PRReviewCommentsRequiringResponses) > 0 {
-
-		return true
+	if len(task.IssueCommentsRequiringResponses) > 0 {
+		return AttentionIssueComment, true
+	}
+	if len(task.PRCommentsRequiringResponses) > 0 {
+		return AttentionPRComment, true
+	}
+	if len(task.PRReviewCommentsRequiringResponses) > 0 {
+		return AttentionReviewComment, true
+	}
+	if task.Plan.Approved && !task.Plan.Started {
+		return AttentionPlanApproved, true
 	}
 	// Check if there is a "bot turn" label, which is a manual prompt for the bot to take action
 	if slices.Contains(task.Issue.Labels, *LabelBotTurn.Name) {
-		return true
+		return AttentionBotTurnLabel, true
 	}
 
-	return false
+	return AttentionNone, false
 }
 
-// findStyleGuides searches for coding style documentation
-func (tb builder) findStyleGuides(ctx context.Context, owner, repo string) (*StyleGuide, error) {
+// findStyleGuides searches for coding style documentation at the given ref
+func (tb builder) findStyleGuides(ctx context.Context, owner, repo, ref string) (*StyleGuide, error) {
 	styleGuide := &StyleGuide{
 		Guides: map[string]string{},
 	}
@@ -172,13 +330,26 @@ func (tb builder) findStyleGuides(ctx context.Context, owner, repo string) (*Sty
 		"docs/CONTRIBUTING.md",
 	}
 
-	for _, path := range paths {
-		content, _, _, err := tb.githubClient.Repositories.GetContents(ctx, owner, repo, path, nil)
-		if err == nil && content != nil {
-			decodedContent, err := content.GetContent()
-			if err == nil {
-				styleGuide.Guides[path] = decodedContent
-			}
+	// Most of the paths don't exist, so look for them all at once rather than waiting on each miss in turn
+	contents := make([]string, len(paths))
+	found := make([]bool, len(paths))
+	var g errgroup.Group
+	for i, path := range paths {
+		g.Go(func() error {
+			return tb.fetches.do(ctx, func() error {
+				content, err := tb.vcs.GetFileContents(ctx, owner, repo, path, ref)
+				if err == nil {
+					// Each goroutine writes a distinct index, so no lock is needed
+					contents[i], found[i] = content, true
+				}
+				return nil
+			})
+		})
+	}
+	_ = g.Wait()
+	for i, path := range paths {
+		if found[i] {
+			styleGuide.Guides[path] = contents[i]
 		}
 	}
 
@@ -189,56 +360,75 @@ func (tb builder) findStyleGuides(ctx context.Context, owner, repo string) (*Sty
 	return styleGuide, nil
 }
 
-// analyzeCodebase examines the repository structure
-func (tb builder) analyzeCodebase(ctx context.Context, owner, repo string) (*CodebaseInfo, error) {
+// analyzeCodebase examines the repository structure at the given ref. A repository without a README is fine, but if
+// anything else can't be fetched, returns whatever could be along with the error, so that callers can use the partial
+// information without caching it
+func (tb builder) analyzeCodebase(ctx context.Context, owner, repo, ref string) (*CodebaseInfo, error) {
 	info := &CodebaseInfo{
 		PackageInfo: make(map[string]string),
 	}
 
-	// Get repository languages
-	languages, _, err := tb.githubClient.Repositories.ListLanguages(ctx, owner, repo)
-	if err != nil {
-		return nil, fmt.Errorf("failed to list languages: %w", err)
-	}
-
-	// Find main language
-	maxBytes := 0
-	for lang, bytes := range languages {
-		if bytes > maxBytes {
-			maxBytes = bytes
-			info.MainLanguage = lang
-		}
-	}
-
-	// Get file tree
-	fileTree, err := tb.getFileTree(ctx, owner, repo)
-	if err != nil {
-		log.Printf("[taskgen] Warning: Could not get file tree: %v", err)
-	} else {
-		info.FileTree = fileTree
-	}
+	// Not tied to a shared context, so that one failure doesn't cancel the other fetches
+	var g errgroup.Group
+	g.Go(func() error {
+		return tb.fetches.do(ctx, func() error {
+			// Get repository languages
+			languages, err := tb.vcs.ListLanguages(ctx, owner, repo)
+			if err != nil {
+				return fmt.Errorf("failed to list languages: %w", err)
+			}
 
-	// Get README
-	readme, _, err := tb.githubClient.Repositories.GetReadme(ctx, owner, repo, nil)
-	if err == nil {
-		content, err := readme.GetContent()
-		if err == nil {
-			info.ReadmeContent = content
-		}
+			// Find main language
+			maxBytes := 0
+			for lang, bytes := range languages {
+				if bytes > maxBytes {
+					maxBytes = bytes
+					info.MainLanguage = lang
+				}
+			}
+			return nil
+		})
+	})
+	g.Go(func() error {
+		return tb.fetches.do(ctx, func() error {
+			// Get file tree
+			fileTree, err := tb.getFileTree(ctx, owner, repo, ref)
+			if err != nil {
+				return fmt.Errorf("failed to get file tree: %w", err)
+			}
+			info.FileTree = fileTree
+			return nil
+		})
+	})
+	g.Go(func() error {
+		return tb.fetches.do(ctx, func() error {
+			// Get README
+			readme, err := tb.vcs.GetReadme(ctx, owner, repo, ref)
+			if errors.Is(err, vcs.ErrNotFound) {
+				return nil
+			} else if err != nil {
+				return fmt.Errorf("failed to get README: %w", err)
+			}
+			info.ReadmeContent = readme
+			return nil
+		})
+	})
+	if err := g.Wait(); err != nil {
+		return info, err
 	}
 
 	return info, nil
 }
 
-// getFileTree retrieves the complete file tree with safety limits
-func (tb builder) getFileTree(ctx context.Context, owner, repo string) ([]string, error) {
+// getFileTree retrieves the complete file tree at the given ref with safety limits
+func (tb builder) getFileTree(ctx context.Context, owner, repo, ref string) ([]string, error) {
 	const (
 		maxFiles      = 2000
 		maxPathLength = 500
 	)
 
 	// Get the full recursive tree
-	tree, _, err := tb.githubClient.Git.GetTree(ctx, owner, repo, "HEAD", true)
+	entries, err := tb.vcs.GetTree(ctx, owner, repo, ref)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get recursive tree: %w", err)
 	}
@@ -246,7 +436,7 @@ func (tb builder) getFileTree(ctx context.Context, owner, repo string) ([]string
 	var fileTree []string
 	fileCount := 0
 
-	for _, entry := range tree.Entries {
+	for _, entry := range entries {
 		if entry.Path == nil {
 			continue
 		}
@@ -278,37 +468,14 @@ func (tb builder) getFileTree(ctx context.Context, owner, repo string) ([]string
 
 // getAllIssueComments retrieves all comments on an issue
 func (tb builder) getAllIssueComments(ctx context.Context, owner, repo string, issueNumber int) ([]*github.IssueComment, error) {
-	var allComments []*github.IssueComment
-
-	opts := &github.IssueListCommentsOptions{
-		Sort:      github.Ptr("created"),
-		Direction: github.Ptr("asc"),
-		ListOptions: github.ListOptions{
-			PerPage: 100,
-		},
-	}
-
-	for {
-		comments, resp, err := tb.githubClient.Issues.ListComments(ctx, owner, repo, issueNumber, opts)
-		if err != nil {
-			return nil, err
-		}
-		allComments = append(allComments, comments...)
-
-		if resp.NextPage == 0 {
-			break
-		}
-		opts.Page = resp.NextPage
-	}
-
-	return allComments, nil
+	return tb.vcs.ListComments(ctx, owner, repo, issueNumber)
 }
 
 // getAllPRReviews retrieves all reviews on a PR, sorted chronologically
 func (tb builder) getAllPRReviews(ctx context.Context, owner, repo string, prNumber int) ([]*github.PullRequestReview, error) {
 	var allReviews []*github.PullRequestReview
 
-	reviews, _, err := tb.githubClient.PullRequests.ListReviews(ctx, owner, repo, prNumber, nil)
+	reviews, err := tb.vcs.ListReviews(ctx, owner, repo, prNumber)
 	if err != nil {
 		return nil, err
 	}
@@ -326,35 +493,19 @@ func (tb builder) getAllPRReviews(ctx context.Context, owner, repo string, prNum
 
 // getAllPRComments retrieves all review comments on a PR, sorted chronologically
 func (tb builder) getAllPRReviewComments(ctx context.Context, owner, repo string, prNumber int) ([]*github.PullRequestComment, error) {
-	var allComments []*github.PullRequestComment
-
-	opts := &github.PullRequestListCommentsOptions{
-		Sort:      "created",
-		Direction: "asc",
-		ListOptions: github.ListOptions{
-			PerPage: 100,
-		},
+	comments, err := tb.vcs.ListReviewComments(ctx, owner, repo, prNumber)
+	if err != nil {
+		return nil, err
 	}
 
-	for {
-		comments, resp, err := tb.githubClient.PullRequests.ListComments(ctx, owner, repo, prNumber, opts)
-		if err != nil {
-			return nil, err
-		}
-
-		for _, comment := range comments {
-			if comment == nil || comment.ID == nil {
-				log.Println("[taskgen] Warning: comment or comment.ID unexpectedly nil")
-				continue
-			}
-
-			allComments = append(allComments, comment)
+	var allComments []*github.PullRequestComment
+	for _, comment := range comments {
+		if comment == nil || comment.ID == nil {
+			log.Println("[taskgen] Warning: comment or comment.ID unexpectedly nil")
+			continue
 		}
 
-		if resp.NextPage == 0 {
-			break
-		}
-		opts.Page = resp.NextPage
+		allComments = append(allComments, comment)
 	}
 
 	return allComments, nil
@@ -364,57 +515,106 @@ func (tb builder) getAllPRReviewComments(ctx context.Context, owner, repo string
 
 // pickIssueCommentsRequiringResponse gets regular issue/PR comments that haven't been reacted to by the bot
 func (tb builder) pickIssueCommentsRequiringResponse(ctx context.Context, owner, repo string, comments []*github.IssueComment, botUser *github.User) ([]*github.IssueComment, error) {
-	var commentsRequiringResponse []*github.IssueComment
-
-	for _, comment := range comments {
+	return filterConcurrently(ctx, comments, tb.reactionLookupConcurrency, func(ctx context.Context, comment *github.IssueComment) (bool, error) {
 		// Skip if this is the bot's own comment
 		if tb.isBotComment(comment.User, botUser) {
-			continue
+			return false, nil
+		}
+		// The comment's reaction rollup saves a lookup when nobody has reacted
+		if comment.Reactions != nil && comment.Reactions.GetTotalCount() == 0 {
+			return true, nil
 		}
 
 		// Check if bot has reacted to this comment
 		hasReacted, err := tb.hasBotReactedToIssueComment(ctx, owner, repo, *comment.ID, botUser)
 		if err != nil {
-			return nil, fmt.Errorf("failed to check reactions for comment %d: %w", *comment.ID, err)
+			return false, fmt.Errorf("failed to check reactions for comment %d: %w", *comment.ID, err)
 		}
-		if hasReacted {
-			continue
-		}
-
-		commentsRequiringResponse = append(commentsRequiringResponse, comment)
-	}
-
-	return commentsRequiringResponse, nil
+		return !hasReacted, nil
+	})
 }
 
 // getReviewComments gets PR review comments that haven't been replied to or reacted to by the bot
 func (tb builder) pickPRReviewCommentsRequiringResponse(ctx context.Context, owner, repo string, commentThreads [][]*github.PullRequestComment, botUser *github.User) ([]*github.PullRequestComment, error) {
-	var commentsRequiringResponse []*github.PullRequestComment
-
-	for _, thread := range commentThreads {
-		// Look at every comment, not just the last comment in each thread. Multiple replies may have been added to a
-		// chain since the bot last looked at it, and for other contributors' peace of mind the bot should explicitly
-		// acknolwedge that it has seen every comment in the chain, even if it only replied to the last one
-		for _, comment := range thread {
-			// Skip if this is the bot's own comment
-			if tb.isBotComment(comment.User, botUser) {
-				continue
-			}
+	// Look at every comment, not just the last comment in each thread. Multiple replies may have been added to a chain
+	// since the bot last looked at it, and for other contributors' peace of mind the bot should explicitly acknolwedge
+	// that it has seen every comment in the chain, even if it only replied to the last one
+	comments := slices.Concat(commentThreads...)
+
+	return filterConcurrently(ctx, comments, tb.reactionLookupConcurrency, func(ctx context.Context, comment *github.PullRequestComment) (bool, error) {
+		// Skip if this is the bot's own comment
+		if tb.isBotComment(comment.User, botUser) {
+			return false, nil
+		}
+		// The comment's reaction rollup saves a lookup when nobody has reacted
+		if comment.Reactions != nil && comment.Reactions.GetTotalCount() == 0 {
+			return true, nil
+		}
+
+		// Check if bot has reacted to this comment
+		hasReacted, err := tb.hasBotReactedToReviewComment(ctx, owner, repo, *comment.ID, botUser)
+		if err != nil {
+			return false, fmt.Errorf("failed to check reactions for review comment %d: %w", *comment.ID, err)
+		}
+		return !hasReacted, nil
+	})
+}
+
+// filterConcurrently returns the items for which keep returns true, in their original order. keep is called for up to
+// concurrency items at once. If any call fails, the remaining calls are cancelled and the first error is returned
+func filterConcurrently[T any](ctx context.Context, items []T, concurrency int, keep func(ctx context.Context, item T) (bool, error)) ([]T, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	kept := make([]bool, len(items))
+	var (
+		wg       sync.WaitGroup
+		errOnce  sync.Once
+		firstErr error
+	)
+	semaphore := make(chan struct{}, max(concurrency, 1))
+	for i, item := range items {
+		select {
+		case semaphore <- struct{}{}:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-semaphore }()
 
-			// Check if bot has reacted to this comment
-			hasReacted, err := tb.hasBotReactedToReviewComment(ctx, owner, repo, *comment.ID, botUser)
+			ok, err := keep(ctx, item)
 			if err != nil {
-				return nil, fmt.Errorf("failed to check reactions for review comment %d: %w", *comment.ID, err)
-			}
-			if hasReacted {
-				continue
+				errOnce.Do(func() {
+					firstErr = err
+					cancel()
+				})
+				return
 			}
+			// Each goroutine writes a distinct index, so no lock is needed
+			kept[i] = ok
+		}()
+	}
+	wg.Wait()
 
-			commentsRequiringResponse = append(commentsRequiringResponse, comment)
-		}
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
 	}
 
-	return commentsRequiringResponse, nil
+	var result []T
+	for i, item := range items {
+		if kept[i] {
+			result = append(result, item)
+		}
+	}
+	return result, nil
 }
 
 // isBotComment checks if a comment was made by the bot
@@ -423,78 +623,113 @@ func (tb builder) isBotComment(commentUser, botUser *github.User) bool {
 		commentUser.Login != nil && *commentUser.Login == *botUser.Login
 }
 
-// hasBotReactedToIssueComment checks if the bot has reacted to an issue comment
+// hasBotHandledReaction returns true if the bot has reacted to a comment other than to acknowledge it. An acknowledged
+// comment still requires a response, since the bot may have been interrupted while responding to it
+func hasBotHandledReaction(reactions []*github.Reaction, botUser *github.User) bool {
+	for _, reaction := range reactions {
+		if reaction.GetUser().GetLogin() == botUser.GetLogin() && reaction.GetContent() != AcknowledgementReaction {
+			return true
+		}
+	}
+	return false
+}
+
+// hasBotReactedToIssueComment checks if the bot has reacted to an issue comment, other than to acknowledge it
 func (tb builder) hasBotReactedToIssueComment(ctx context.Context, owner, repo string, commentID int64, botUser *github.User) (bool, error) {
 	if botUser.Login == nil {
 		return false, nil
 	}
 
-	reactions, _, err := tb.githubClient.Reactions.ListIssueCommentReactions(ctx, owner, repo, commentID, nil)
+	reactions, err := tb.vcs.ListCommentReactions(ctx, owner, repo, commentID)
 	if err != nil {
 		return false, fmt.Errorf("failed to list reactions: %w", err)
 	}
 
-	for _, reaction := range reactions {
-		if reaction.User != nil && reaction.User.Login != nil &&
-			*reaction.User.Login == *botUser.Login {
-			return true, nil
-		}
-	}
-
-	return false, nil
+	return hasBotHandledReaction(reactions, botUser), nil
 }
 
-// hasBotReactedToReviewComment checks if the bot has reacted to a review comment
+// hasBotReactedToReviewComment checks if the bot has reacted to a review comment, other than to acknowledge it
 func (tb builder) hasBotReactedToReviewComment(ctx context.Context, owner, repo string, commentID int64, botUser *github.User) (bool, error) {
 	if botUser.Login == nil {
 		return false, nil
 	}
 
-	reactions, _, err := tb.githubClient.Reactions.ListPullRequestCommentReactions(ctx, owner, repo, commentID, nil)
+	reactions, err := tb.vcs.ListReviewCommentReactions(ctx, owner, repo, commentID)
 	if err != nil {
 		return false, fmt.Errorf("failed to list reactions: %w", err)
 	}
 
-	for _, reaction := range reactions {
-		if reaction.User != nil && reaction.User.Login != nil &&
-			*reaction.User.Login == *botUser.Login {
-			return true, nil
+	return hasBotHandledReaction(reactions, botUser), nil
+}
+
+// findIssuesFixedTogether looks for an open pull request by the bot whose source branch was created for the given issue
+// together with other issues, and returns the issue that the branch was created for first and the rest. If there is no
+// such pull request, returns the given issue alone
+func (tb builder) findIssuesFixedTogether(ctx context.Context, issue GithubIssue) (GithubIssue, []GithubIssue, error) {
+	prs, err := tb.vcs.ListOpenPullRequests(ctx, issue.Owner, issue.Repo)
+	if err != nil {
+		return GithubIssue{}, nil, fmt.Errorf("failed to list pull requests: %w", err)
+	}
+	for _, pr := range prs {
+		if pr.GetUser().GetLogin() != tb.githubUser.GetLogin() {
+			continue
 		}
+		issueNumbers, err := IssueNumbersFromSourceBranch(pr.GetHead().GetRef())
+		if err != nil || len(issueNumbers) < 2 || !slices.Contains(issueNumbers, issue.Number) {
+			continue
+		}
+		return tb.getIssuesFixedTogether(ctx, issue, issueNumbers)
 	}
-
-	return false, nil
+	return issue, nil, nil
 }
 
-// getPullRequest returns a pull request by source branch and owner, if exactly one such pull request exists. If no such
-// pull request exists, returns (nil, nil). If more than one such pull request exists, returns an error
-func getPullRequest(ctx context.Context, githubClient *github.Client, owner, repo, branch, author string) (*GithubPullRequest, error) {
-	query := fmt.Sprintf("type:pr repo:%s/%s head:%s author:%s", owner, repo, branch, author)
-
-	opts := &github.SearchOptions{
-		Sort:        "created",
-		Order:       "desc",
-		ListOptions: github.ListOptions{PerPage: 50},
+// getIssuesFixedTogether fetches the issues with the given numbers, reusing the given issue if it is one of them, and
+// returns the first and the rest
+func (tb builder) getIssuesFixedTogether(ctx context.Context, issue GithubIssue, issueNumbers []int) (GithubIssue, []GithubIssue, error) {
+	issues := make([]GithubIssue, len(issueNumbers))
+	for i, number := range issueNumbers {
+		if number == issue.Number {
+			issues[i] = issue
+			continue
+		}
+		fetched, err := tb.vcs.GetIssue(ctx, issue.Owner, issue.Repo, number)
+		if err != nil {
+			return GithubIssue{}, nil, fmt.Errorf("failed to fetch issue %d: %w", number, err)
+		}
+		issues[i], err = convertIssue(fetched)
+		if err != nil {
+			return GithubIssue{}, nil, fmt.Errorf("failed to convert issue %d: %w", number, err)
+		}
 	}
+	return issues[0], issues[1:], nil
+}
 
-	result, _, err := githubClient.Search.Issues(ctx, query, opts)
+// getPullRequest returns a pull request by source branch and owner. If no such pull request exists, returns (nil, nil).
+// If more than one exists, e.g. because of stale closed pull requests, see pickPullRequest
+func getPullRequest(ctx context.Context, provider vcs.Provider, owner, repo, branch, author string) (*GithubPullRequest, error) {
+	found, err := provider.FindPullRequests(ctx, owner, repo, branch, author)
 	if err != nil {
 		return nil, fmt.Errorf("failed to search issues: %w", err)
 	}
-	if len(result.Issues) > 1 {
-		return nil, fmt.Errorf("found %d pull requests, expected 0 or 1", len(result.Issues))
-	}
-
-	if len(result.Issues) == 0 {
+	if len(found) == 0 {
 		// Expected, return nil
 		return nil, nil
 	}
 
-	issue := result.Issues[0]
-	pr, _, err := githubClient.PullRequests.Get(ctx, owner, repo, *issue.Number)
+	issue, err := pickPullRequest(found)
+	if err != nil {
+		return nil, err
+	}
+	pr, err := provider.GetPullRequest(ctx, owner, repo, *issue.Number)
 	if err != nil {
 		return nil, fmt.Errorf("failed to fetch pull request: %w", err)
 	}
 
+	return ConvertPullRequest(owner, repo, pr)
+}
+
+// ConvertPullRequest converts a pull request in the given repository fetched from GitHub
+func ConvertPullRequest(owner string, repo string, pr *github.PullRequest) (*GithubPullRequest, error) {
 	if pr == nil || pr.Number == nil || pr.Title == nil || pr.URL == nil || pr.Base == nil || pr.Base.Ref == nil {
 		return nil, fmt.Errorf("unexpected nil in pull request struct")
 	}
@@ -504,15 +739,53 @@ func getPullRequest(ctx context.Context, githubClient *github.Client, owner, rep
 		Repo:   repo,
 		Number: *pr.Number,
 
-		Title: *pr.Title,
-		URL:   *pr.URL,
+		Title:  *pr.Title,
+		URL:    *pr.URL,
+		Author: pr.GetUser().GetLogin(),
 
-		BaseBranch: *pr.Base.Ref,
+		BaseBranch:   *pr.Base.Ref,
+		HasConflicts: hasMergeConflicts(pr),
+		UpdatedAt:    pr.GetUpdatedAt().Time,
 	}, nil
 }
 
+// hasMergeConflicts returns true if GitHub reports that the given pull request conflicts with its base branch, with a
+// "dirty" mergeable state, or "conflicting" in some APIs. GitHub only sets mergeable to false for conflicts, too
+func hasMergeConflicts(pr *github.PullRequest) bool {
+	state := strings.ToLower(pr.GetMergeableState())
+	return state == "dirty" || state == "conflicting" || (pr.Mergeable != nil && !*pr.Mergeable)
+}
+
+// pickPullRequest chooses between pull request search results for the same source branch. If exactly one is open, it is
+// chosen. If none are open, the most recently created is chosen. If more than one is open, returns an error, since
+// there's no telling which one the bot should work on
+func pickPullRequest(results []*github.Issue) (*github.Issue, error) {
+	var open []*github.Issue
+	for _, result := range results {
+		if result.GetState() == "open" {
+			open = append(open, result)
+		}
+	}
+	switch len(open) {
+	case 1:
+		return open[0], nil
+	case 0:
+		return slices.MaxFunc(results, func(a, b *github.Issue) int {
+			return a.GetCreatedAt().Compare(b.GetCreatedAt().Time)
+		}), nil
+	default:
+		numbers := make([]string, len(open))
+		for i, pr := range open {
+			numbers[i] = fmt.Sprintf("#%d", pr.GetNumber())
+		}
+		return nil, fmt.Errorf("found %d open pull requests (%s), expected at most 1", len(open), strings.Join(numbers, ", "))
+	}
+}
+
 // organizePRReviewCommentsIntoThreads takes a list of pull request review comments and returns a list of comment
-// threads, where each thread is a list of comments that reply to the next
+// threads, where each thread is a list of comments that reply to the next. Comments within a thread are in
+// chronological order, as are the threads themselves, by their first comments. Ties are broken by comment ID, so the
+// result doesn't depend on the order of the input
 func organizePRReviewCommentsIntoThreads(comments []*github.PullRequestComment) ([][]*github.PullRequestComment, error) {
 	// In github, it appears that all comments in a thread are replies to the top comment, rather than replies to each
 	// other in a chain. Therefore we will simply collect all replies to a comment and sort them by date to form a chain
@@ -535,33 +808,88 @@ func organizePRReviewCommentsIntoThreads(comments []*github.PullRequestComment)
 
 	threads := [][]*github.PullRequestComment{}
 	for _, thread := range threadsMap {
-		slices.SortFunc(thread, func(a, b *github.PullRequestComment) int {
-			return a.CreatedAt.Compare(b.CreatedAt.Time)
-		})
+		slices.SortFunc(thread, compareCommentsChronologically)
 		threads = append(threads, thread)
 	}
+	// Map iteration order is random, so sort the threads too, to keep prompts stable
+	slices.SortFunc(threads, func(a, b []*github.PullRequestComment) int {
+		return compareCommentsChronologically(a[0], b[0])
+	})
 
 	return threads, nil
 }
 
-func getSourceBranchName(issue GithubIssue) string {
-	branchName := fmt.Sprintf("fix/issue-%d-%s", issue.Number, sanitizeForBranchName(issue.Title))
+// compareCommentsChronologically orders review comments by creation time, then by ID
+func compareCommentsChronologically(a, b *github.PullRequestComment) int {
+	if c := a.GetCreatedAt().Compare(b.GetCreatedAt().Time); c != 0 {
+		return c
+	}
+	return cmp.Compare(a.GetID(), b.GetID())
+}
+
+// sourceBranchPrefix starts the names of the branches that the bot opens pull requests from
+const sourceBranchPrefix = "fix/issue-"
+
+// getSourceBranchName returns the name of the branch to open a pull request from for the given issues, e.g.
+// "fix/issue-12-add-a-widget" for a single issue titled "Add a widget". If the pull request fixes several issues, their
+// numbers are separated by underscores, e.g. "fix/issue-12_14_15-add-a-widget". Underscores never appear in the
+// sanitized title, so the numbers can be parsed back out unambiguously
+func getSourceBranchName(issueNumbers []int, title string) string {
+	numbers := make([]string, len(issueNumbers))
+	for i, number := range issueNumbers {
+		numbers[i] = strconv.Itoa(number)
+	}
+	branchName := fmt.Sprintf("%s%s-%s", sourceBranchPrefix, strings.Join(numbers, "_"), sanitizeForBranchName(title))
 	return normalizeBranchName(branchName)
 }
 
-func sanitizeForBranchName(s string) string {
-	// Convert to lowercase and replace invalid characters
-	s = strings.ToLower(s)
-	s = strings.ReplaceAll(s, " ", "-")
-	s = strings.ReplaceAll(s, "_", "-")
+// IssueNumberFromSourceBranch parses the number of the issue that a source branch was created for, the inverse of
+// getSourceBranchName. If the branch was created for several issues, returns the first, which is the one that the task
+// was built for. Returns an error if the branch name was not generated by getSourceBranchName
+func IssueNumberFromSourceBranch(branchName string) (int, error) {
+	issueNumbers, err := IssueNumbersFromSourceBranch(branchName)
+	if err != nil {
+		return 0, err
+	}
+	return issueNumbers[0], nil
+}
 
-	// Remove invalid characters for git branch names
-	invalidChars := []string{"~", "^", ":", "?", "*", "[", "]", "\\", "..", "@{", "/.", "//"}
-	for _, char := range invalidChars {
-		s = strings.ReplaceAll(s, char, "")
+// IssueNumbersFromSourceBranch parses the numbers of all issues that a source branch was created for, the inverse of
+// getSourceBranchName. Returns an error if the branch name was not generated by getSourceBranchName
+func IssueNumbersFromSourceBranch(branchName string) ([]int, error) {
+	rest, ok := strings.CutPrefix(branchName, sourceBranchPrefix)
+	if !ok {
+		return nil, fmt.Errorf("failed to parse issue number from branch '%s': missing prefix '%s'", branchName, sourceBranchPrefix)
 	}
+	numbersPart, _, _ := strings.Cut(rest, "-")
 
-	return s
+	var issueNumbers []int
+	for numberStr := range strings.SplitSeq(numbersPart, "_") {
+		number, err := strconv.Atoi(numberStr)
+		if err != nil || number <= 0 {
+			return nil, fmt.Errorf("failed to parse issue number from branch '%s': invalid number '%s'", branchName, numberStr)
+		}
+		issueNumbers = append(issueNumbers, number)
+	}
+	return issueNumbers, nil
+}
+
+var (
+	branchSeparatorsRegex = regexp.MustCompile(`[\s_]+`)
+	branchDisallowedRegex = regexp.MustCompile(`[^a-z0-9\-]`)
+	branchHyphenRunsRegex = regexp.MustCompile(`-{2,}`)
+)
+
+// sanitizeForBranchName converts s into a string that is safe to use as part of a git branch name. Whitespace and
+// underscores become hyphens, and anything other than ASCII letters, digits, and hyphens is dropped, including
+// non-ASCII letters, emoji, and control characters. Runs of hyphens are collapsed, and leading and trailing hyphens are
+// removed
+func sanitizeForBranchName(s string) string {
+	s = strings.ToLower(s)
+	s = branchSeparatorsRegex.ReplaceAllString(s, "-")
+	s = branchDisallowedRegex.ReplaceAllString(s, "")
+	s = branchHyphenRunsRegex.ReplaceAllString(s, "-")
+	return strings.Trim(s, "-")
 }
 
 func normalizeBranchName(s string) string {
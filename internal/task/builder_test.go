@@ -0,0 +1,585 @@
+package task
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"log"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/cchalm/blundering-savant/internal/vcs"
+	"github.com/google/go-github/v72/github"
+	"github.com/stretchr/testify/require"
+)
+
+func testGetSourceBranchName(t *testing.T, title string, expected string) {
+	testGetMultiIssueSourceBranchName(t, []int{42}, title, expected)
+}
+
+func testGetMultiIssueSourceBranchName(t *testing.T, issueNumbers []int, title string, expected string) {
+	branchName := getSourceBranchName(issueNumbers, title)
+	require.Equal(t, expected, branchName)
+	requireValidBranchName(t, branchName)
+
+	parsed, err := IssueNumbersFromSourceBranch(branchName)
+	require.NoError(t, err)
+	require.Equal(t, issueNumbers, parsed)
+
+	issueNumber, err := IssueNumberFromSourceBranch(branchName)
+	require.NoError(t, err)
+	require.Equal(t, issueNumbers[0], issueNumber)
+}
+
+// requireValidBranchName fails the test if name isn't a valid git branch name, per the rules of git check-ref-format
+func requireValidBranchName(t *testing.T, name string) {
+	t.Helper()
+
+	require.NotEmpty(t, name)
+	require.NotContains(t, name, "..")
+	require.NotContains(t, name, "@{")
+	require.NotContains(t, name, "//")
+	require.False(t, strings.HasSuffix(name, "/") || strings.HasSuffix(name, "."), "branch name %q has a bad suffix", name)
+	for _, r := range name {
+		require.False(t, r < 0x20 || r == 0x7f || strings.ContainsRune(" ~^:?*[\\", r),
+			"branch name %q contains disallowed character %q", name, r)
+	}
+	for component := range strings.SplitSeq(name, "/") {
+		require.False(t, strings.HasPrefix(component, "."), "branch name %q has a component starting with '.'", name)
+		require.False(t, strings.HasSuffix(component, ".lock"), "branch name %q has a component ending with '.lock'", name)
+	}
+}
+
+func TestGetSourceBranchName_Basic(t *testing.T) {
+	testGetSourceBranchName(t, "Add a widget", "fix/issue-42-add-a-widget")
+}
+
+func TestGetSourceBranchName_ConsecutiveSeparators(t *testing.T) {
+	testGetSourceBranchName(t, "Fix:: bug -- in  parser", "fix/issue-42-fix-bug-in-parser")
+}
+
+func TestGetSourceBranchName_NonASCII(t *testing.T) {
+	testGetSourceBranchName(t, "✨ Überarbeitung der Anmeldung", "fix/issue-42-berarbeitung-der-anmeldung")
+}
+
+func TestGetSourceBranchName_CJK(t *testing.T) {
+	testGetSourceBranchName(t, "修复 登录页面 的错误", "fix/issue-42")
+}
+
+func TestGetSourceBranchName_MixedCJKAndASCII(t *testing.T) {
+	testGetSourceBranchName(t, "修复 OAuth 登录", "fix/issue-42-oauth")
+}
+
+func TestGetSourceBranchName_OnlyPunctuation(t *testing.T) {
+	testGetSourceBranchName(t, "?!...", "fix/issue-42")
+}
+
+func TestGetSourceBranchName_NumericTitle(t *testing.T) {
+	testGetSourceBranchName(t, "404 page", "fix/issue-42-404-page")
+}
+
+func TestGetSourceBranchName_MultipleIssues(t *testing.T) {
+	testGetMultiIssueSourceBranchName(t, []int{42, 7, 1003}, "Add a widget", "fix/issue-42_7_1003-add-a-widget")
+}
+
+func TestGetSourceBranchName_MultipleIssuesNumericTitle(t *testing.T) {
+	testGetMultiIssueSourceBranchName(t, []int{42, 7, 1003}, "2024 cleanup", "fix/issue-42_7_1003-2024-cleanup")
+}
+
+func testIssueNumbersFromSourceBranchError(t *testing.T, branchName string) {
+	_, err := IssueNumbersFromSourceBranch(branchName)
+	require.Error(t, err)
+}
+
+func TestIssueNumbersFromSourceBranch_OtherBranch(t *testing.T) {
+	testIssueNumbersFromSourceBranchError(t, "feature/add-a-widget")
+}
+
+func TestIssueNumbersFromSourceBranch_MalformedNumbers(t *testing.T) {
+	testIssueNumbersFromSourceBranchError(t, "fix/issue-42__7-add-a-widget")
+}
+
+// newSlowReactionsBuilder returns a builder backed by a fake GitHub server that takes the given latency to list a
+// comment's reactions. The bot has reacted to comments with even IDs. The returned counter tracks reaction lookups
+func newSlowReactionsBuilder(t *testing.T, latency time.Duration, concurrency int) (builder, *atomic.Int32) {
+	var lookups atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lookups.Add(1)
+		time.Sleep(latency)
+
+		var commentID int64
+		_, err := fmt.Sscanf(r.URL.Path, "/repos/owner/repo/issues/comments/%d/reactions", &commentID)
+		if err != nil {
+			_, err = fmt.Sscanf(r.URL.Path, "/repos/owner/repo/pulls/comments/%d/reactions", &commentID)
+		}
+		require.NoError(t, err, "unexpected request: %s", r.URL.Path)
+
+		w.Header().Set("Content-Type", "application/json")
+		if commentID%2 == 0 {
+			_, _ = w.Write([]byte(`[{"content": "rocket", "user": {"login": "bot"}}]`))
+		} else {
+			_, _ = w.Write([]byte(`[{"content": "+1", "user": {"login": "someone"}}]`))
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	client := github.NewClient(nil)
+	baseURL, err := url.Parse(server.URL + "/")
+	require.NoError(t, err)
+	client.BaseURL = baseURL
+
+	tb := NewBuilder(vcs.NewGithubProvider(client), &github.User{Login: github.Ptr("bot")}, CommandConfig{})
+	tb.reactionLookupConcurrency = concurrency
+	return tb, &lookups
+}
+
+func newIssueComments(n int) []*github.IssueComment {
+	var comments []*github.IssueComment
+	for i := range n {
+		comments = append(comments, &github.IssueComment{
+			ID:   github.Ptr(int64(i)),
+			User: &github.User{Login: github.Ptr("someone")},
+		})
+	}
+	return comments
+}
+
+func commentIDs(comments []*github.IssueComment) []int64 {
+	var ids []int64
+	for _, comment := range comments {
+		ids = append(ids, comment.GetID())
+	}
+	return ids
+}
+
+func TestPickIssueCommentsRequiringResponse_ConcurrentMatchesSerial(t *testing.T) {
+	ctx := context.Background()
+	comments := newIssueComments(50)
+	latency := 20 * time.Millisecond
+
+	serialBuilder, _ := newSlowReactionsBuilder(t, latency, 1)
+	start := time.Now()
+	serial, err := serialBuilder.pickIssueCommentsRequiringResponse(ctx, "owner", "repo", comments, serialBuilder.githubUser)
+	require.NoError(t, err)
+	serialDuration := time.Since(start)
+
+	concurrentBuilder, _ := newSlowReactionsBuilder(t, latency, defaultReactionLookupConcurrency)
+	start = time.Now()
+	concurrent, err := concurrentBuilder.pickIssueCommentsRequiringResponse(ctx, "owner", "repo", comments, concurrentBuilder.githubUser)
+	require.NoError(t, err)
+	concurrentDuration := time.Since(start)
+
+	require.Len(t, serial, 25)
+	require.Equal(t, commentIDs(serial), commentIDs(concurrent))
+	require.Less(t, concurrentDuration, serialDuration/2)
+	t.Logf("serial: %v, concurrent: %v", serialDuration, concurrentDuration)
+}
+
+func TestPickIssueCommentsRequiringResponse_SkipsLookupWithoutReactions(t *testing.T) {
+	comments := newIssueComments(4)
+	for _, comment := range comments {
+		comment.Reactions = &github.Reactions{TotalCount: github.Ptr(0)}
+	}
+	comments = append(comments, &github.IssueComment{ID: github.Ptr(int64(10)), User: &github.User{Login: github.Ptr("bot")}})
+
+	tb, lookups := newSlowReactionsBuilder(t, 0, defaultReactionLookupConcurrency)
+	picked, err := tb.pickIssueCommentsRequiringResponse(context.Background(), "owner", "repo", comments, tb.githubUser)
+	require.NoError(t, err)
+	require.Equal(t, []int64{0, 1, 2, 3}, commentIDs(picked))
+	require.Zero(t, lookups.Load())
+}
+
+func TestPickIssueCommentsRequiringResponse_AcknowledgedCommentStillRequiresResponse(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if strings.Contains(r.URL.Path, "/comments/1/") {
+			_, _ = w.Write([]byte(`[{"content": "eyes", "user": {"login": "bot"}}]`))
+		} else {
+			_, _ = w.Write([]byte(`[{"content": "eyes", "user": {"login": "bot"}}, {"content": "+1", "user": {"login": "bot"}}]`))
+		}
+	}))
+	t.Cleanup(server.Close)
+	client := github.NewClient(nil)
+	baseURL, err := url.Parse(server.URL + "/")
+	require.NoError(t, err)
+	client.BaseURL = baseURL
+
+	tb := NewBuilder(vcs.NewGithubProvider(client), &github.User{Login: github.Ptr("bot")}, CommandConfig{})
+	picked, err := tb.pickIssueCommentsRequiringResponse(context.Background(), "owner", "repo", newIssueComments(3), tb.githubUser)
+	require.NoError(t, err)
+	require.Equal(t, []int64{1}, commentIDs(picked), "only the comment that was merely acknowledged is still pending")
+}
+
+func TestPickPRReviewCommentsRequiringResponse_PreservesThreadOrder(t *testing.T) {
+	comment := func(id int64) *github.PullRequestComment {
+		return &github.PullRequestComment{ID: github.Ptr(id), User: &github.User{Login: github.Ptr("someone")}}
+	}
+	threads := [][]*github.PullRequestComment{
+		{comment(5), comment(6), comment(7)},
+		{comment(1), comment(3)},
+	}
+
+	tb, _ := newSlowReactionsBuilder(t, time.Millisecond, defaultReactionLookupConcurrency)
+	picked, err := tb.pickPRReviewCommentsRequiringResponse(context.Background(), "owner", "repo", threads, tb.githubUser)
+	require.NoError(t, err)
+
+	var ids []int64
+	for _, c := range picked {
+		ids = append(ids, c.GetID())
+	}
+	require.Equal(t, []int64{5, 7, 1, 3}, ids)
+}
+
+// testGetPullRequest runs getPullRequest against a fake GitHub server whose search returns the given issues, as JSON
+func testGetPullRequest(t *testing.T, searchResults string) (*GithubPullRequest, error) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Path == "/search/issues" {
+			_, _ = fmt.Fprintf(w, `{"items": %s}`, searchResults)
+			return
+		}
+
+		var number int
+		_, err := fmt.Sscanf(r.URL.Path, "/repos/owner/repo/pulls/%d", &number)
+		require.NoError(t, err, "unexpected request: %s", r.URL.Path)
+		_, _ = fmt.Fprintf(w, `{"number": %[1]d, "title": "PR %[1]d", "url": "https://example.com/%[1]d", "base": {"ref": "main"}}`, number)
+	}))
+	t.Cleanup(server.Close)
+
+	client := github.NewClient(nil)
+	baseURL, err := url.Parse(server.URL + "/")
+	require.NoError(t, err)
+	client.BaseURL = baseURL
+
+	return getPullRequest(context.Background(), vcs.NewGithubProvider(client), "owner", "repo", "fix/issue-1", "bot")
+}
+
+func TestGetPullRequest_None(t *testing.T) {
+	pr, err := testGetPullRequest(t, `[]`)
+	require.NoError(t, err)
+	require.Nil(t, pr)
+}
+
+func TestGetPullRequest_OneOpenOneClosed(t *testing.T) {
+	pr, err := testGetPullRequest(t, `[
+		{"number": 3, "state": "closed", "created_at": "2024-05-02T00:00:00Z"},
+		{"number": 2, "state": "open", "created_at": "2024-05-01T00:00:00Z"}
+	]`)
+	require.NoError(t, err)
+	require.Equal(t, 2, pr.Number)
+}
+
+func TestGetPullRequest_TwoOpen(t *testing.T) {
+	_, err := testGetPullRequest(t, `[
+		{"number": 3, "state": "open", "created_at": "2024-05-02T00:00:00Z"},
+		{"number": 2, "state": "open", "created_at": "2024-05-01T00:00:00Z"}
+	]`)
+	require.ErrorContains(t, err, "found 2 open pull requests (#3, #2)")
+}
+
+func TestGetPullRequest_AllClosed(t *testing.T) {
+	pr, err := testGetPullRequest(t, `[
+		{"number": 2, "state": "closed", "created_at": "2024-05-01T00:00:00Z"},
+		{"number": 4, "state": "closed", "created_at": "2024-05-03T00:00:00Z"},
+		{"number": 3, "state": "closed", "created_at": "2024-05-02T00:00:00Z"}
+	]`)
+	require.NoError(t, err)
+	require.Equal(t, 4, pr.Number)
+}
+
+// buildTaskResponses are the responses of a fake GitHub server for everything fetched to build a task for issue 1,
+// which has pull request 5
+var buildTaskResponses = map[string]string{
+	"/repos/owner/repo/issues/1": `{"number": 1, "title": "Fix the widget", "url": "https://example.com/1", ` +
+		`"repository_url": "https://api.github.com/repos/owner/repo"}`,
+	"/repos/owner/repo":                          `{"full_name": "owner/repo", "default_branch": "main"}`,
+	"/repos/owner/repo/pulls":                    `[]`,
+	"/search/issues":                             `{"items": [{"number": 5, "state": "open"}]}`,
+	"/repos/owner/repo/pulls/5":                  `{"number": 5, "title": "PR 5", "url": "https://example.com/5", "base": {"ref": "main"}}`,
+	"/repos/owner/repo/branches/main":            `{"name": "main", "commit": {"sha": "abc123"}}`,
+	"/repos/owner/repo/contents/CONTRIBUTING.md": fmt.Sprintf(`{"type": "file", "encoding": "base64", "content": "%s"}`, base64.StdEncoding.EncodeToString([]byte("Be nice"))),
+	"/repos/owner/repo/languages":                `{"Go": 1000}`,
+	"/repos/owner/repo/git/trees/abc123":         `{"sha": "tree", "tree": [{"path": "main.go", "type": "blob"}]}`,
+	"/repos/owner/repo/readme":                   fmt.Sprintf(`{"type": "file", "encoding": "base64", "content": "%s"}`, base64.StdEncoding.EncodeToString([]byte("# Widget"))),
+	"/repos/owner/repo/issues/1/comments":        `[{"id": 10, "body": "Any news?", "user": {"login": "alice"}, "reactions": {"total_count": 0}}]`,
+	"/repos/owner/repo/issues/5/comments":        `[{"id": 11, "body": "Looks good", "user": {"login": "alice"}, "reactions": {"total_count": 0}}]`,
+	"/repos/owner/repo/pulls/5/reviews":          `[{"id": 20, "state": "COMMENTED"}]`,
+	"/repos/owner/repo/pulls/5/comments": `[{"id": 30, "body": "Rename this", "user": {"login": "bob"}, ` +
+		`"reactions": {"total_count": 0}, "created_at": "2025-01-01T00:00:00Z"}]`,
+}
+
+// testBuildTask builds a task for issue 1 against a fake GitHub server that fails requests for the given paths
+func testBuildTask(t *testing.T, failingPaths ...string) (*Task, error) {
+	return testBuildTaskWithResponses(t, buildTaskResponses, failingPaths...)
+}
+
+// testBuildTaskWithResponses is like testBuildTask, but the fake GitHub server serves the given responses
+func testBuildTaskWithResponses(t *testing.T, responses map[string]string, failingPaths ...string) (*Task, error) {
+	return testBuildTaskWithHandler(t, buildTaskHandler(responses, failingPaths...))
+}
+
+// buildTaskHandler serves the given responses, and fails requests for the given paths
+func buildTaskHandler(responses map[string]string, failingPaths ...string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if slices.Contains(failingPaths, r.URL.Path) {
+			w.WriteHeader(http.StatusInternalServerError)
+			_, _ = w.Write([]byte(`{"message": "Server Error"}`))
+			return
+		}
+		response, ok := responses[r.URL.Path]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte(`{"message": "Not Found"}`))
+			return
+		}
+		_, _ = w.Write([]byte(response))
+	})
+}
+
+// testBuildTaskWithHandler builds a task for issue 1 against a fake GitHub server with the given handler
+func testBuildTaskWithHandler(t *testing.T, handler http.Handler) (*Task, error) {
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+
+	client := github.NewClient(nil)
+	baseURL, err := url.Parse(server.URL + "/")
+	require.NoError(t, err)
+	client.BaseURL = baseURL
+
+	tb := NewBuilder(vcs.NewGithubProvider(client), &github.User{Login: github.Ptr("bot")}, CommandConfig{})
+	return tb.BuildTask(context.Background(), "owner", "repo", 1)
+}
+
+// captureLog redirects the standard logger to a buffer for the rest of the test
+func captureLog(t *testing.T) *syncBuffer {
+	var buf syncBuffer
+	log.SetOutput(&buf)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+	return &buf
+}
+
+// syncBuffer is a bytes.Buffer that is safe to write to from concurrent fetches
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (sb *syncBuffer) Write(p []byte) (int, error) {
+	sb.mu.Lock()
+	defer sb.mu.Unlock()
+	return sb.buf.Write(p)
+}
+
+func (sb *syncBuffer) String() string {
+	sb.mu.Lock()
+	defer sb.mu.Unlock()
+	return sb.buf.String()
+}
+
+func TestBuildTask_PopulatesAllFields(t *testing.T) {
+	tsk, err := testBuildTask(t)
+	require.NoError(t, err)
+
+	require.Equal(t, "owner/repo", tsk.Repository.GetFullName())
+	require.Equal(t, "main", tsk.TargetBranch)
+	require.Equal(t, "fix/issue-1-fix-the-widget", tsk.SourceBranch)
+	require.NotNil(t, tsk.PullRequest)
+	require.Equal(t, 5, tsk.PullRequest.Number)
+	require.Equal(t, map[string]string{"CONTRIBUTING.md": "Be nice"}, tsk.StyleGuide.Guides)
+	require.Equal(t, "Go", tsk.CodebaseInfo.MainLanguage)
+	require.Equal(t, []string{"main.go"}, tsk.CodebaseInfo.FileTree)
+	require.Equal(t, "# Widget", tsk.CodebaseInfo.ReadmeContent)
+	require.Len(t, tsk.IssueComments, 1)
+	require.Len(t, tsk.PRComments, 1)
+	require.Len(t, tsk.PRReviews, 1)
+	require.Len(t, tsk.PRReviewCommentThreads, 1)
+	require.Equal(t, AttentionIssueComment, tsk.AttentionReason)
+}
+
+func TestBuildTask_OptionalFetchFails(t *testing.T) {
+	logs := captureLog(t)
+
+	tsk, err := testBuildTask(t, "/repos/owner/repo/git/trees/abc123", "/repos/owner/repo/issues/1/comments")
+	require.NoError(t, err)
+
+	require.Empty(t, tsk.CodebaseInfo.FileTree)
+	require.Equal(t, "Go", tsk.CodebaseInfo.MainLanguage, "other information should still be fetched")
+	require.Empty(t, tsk.IssueComments)
+	require.Len(t, tsk.PRComments, 1)
+	require.Contains(t, logs.String(), "Warning: Could not analyze codebase: failed to get file tree")
+	require.Contains(t, logs.String(), "Warning: Could not get issue comments")
+}
+
+func TestBuildTask_RequiredFetchFails(t *testing.T) {
+	_, err := testBuildTask(t, "/repos/owner/repo/pulls/5/reviews")
+	require.ErrorContains(t, err, "could not get PR reviews")
+}
+
+func TestBuildTask_LimitsConcurrentFetches(t *testing.T) {
+	var inFlight, maxInFlight atomic.Int32
+	handler := buildTaskHandler(buildTaskResponses)
+	_, err := testBuildTaskWithHandler(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := inFlight.Add(1)
+		defer inFlight.Add(-1)
+		for m := maxInFlight.Load(); n > m && !maxInFlight.CompareAndSwap(m, n); m = maxInFlight.Load() {
+		}
+		// Give the other fetches time to pile up
+		time.Sleep(20 * time.Millisecond)
+		handler.ServeHTTP(w, r)
+	}))
+	require.NoError(t, err)
+
+	// The fetches of the style guides and the codebase are nested in the fetch of the repository info, but share its
+	// limit
+	require.LessOrEqual(t, maxInFlight.Load(), int32(defaultPrefetchConcurrency))
+	require.Equal(t, int32(defaultPrefetchConcurrency), maxInFlight.Load(), "fetches should still run concurrently")
+}
+
+func TestBuildTask_FindsPullRequestForSeveralIssues(t *testing.T) {
+	responses := maps.Clone(buildTaskResponses)
+	responses["/repos/owner/repo/pulls"] = `[
+		{"number": 6, "user": {"login": "alice"}, "head": {"ref": "fix/issue-2_1-fix-the-gadget"}},
+		{"number": 5, "user": {"login": "bot"}, "head": {"ref": "fix/issue-3_1-fix-the-gizmo"}}
+	]`
+	responses["/repos/owner/repo/issues/3"] = `{"number": 3, "title": "Fix the gizmo", "url": "https://example.com/3", ` +
+		`"repository_url": "https://api.github.com/repos/owner/repo"}`
+	responses["/repos/owner/repo/issues/3/comments"] = `[]`
+
+	tsk, err := testBuildTaskWithResponses(t, responses)
+	require.NoError(t, err)
+
+	require.Equal(t, 3, tsk.Issue.Number)
+	require.Equal(t, []int{3, 1}, tsk.IssueNumbers())
+	require.Equal(t, "fix/issue-3_1-fix-the-gizmo", tsk.SourceBranch)
+	require.Equal(t, 5, tsk.PullRequest.Number)
+}
+
+func testHasMergeConflicts(t *testing.T, mergeable *bool, mergeableState string, expected bool) {
+	pr := &github.PullRequest{Mergeable: mergeable, MergeableState: github.Ptr(mergeableState)}
+	require.Equal(t, expected, hasMergeConflicts(pr))
+
+	tsk := Task{PullRequest: &GithubPullRequest{HasConflicts: hasMergeConflicts(pr)}}
+	require.Equal(t, expected, tsk.HasMergeConflicts())
+}
+
+func TestHasMergeConflicts_Dirty(t *testing.T) {
+	testHasMergeConflicts(t, github.Ptr(false), "dirty", true)
+}
+
+func TestHasMergeConflicts_Conflicting(t *testing.T) {
+	testHasMergeConflicts(t, nil, "CONFLICTING", true)
+}
+
+func TestHasMergeConflicts_Clean(t *testing.T) {
+	testHasMergeConflicts(t, github.Ptr(true), "clean", false)
+}
+
+func TestHasMergeConflicts_NotYetComputed(t *testing.T) {
+	testHasMergeConflicts(t, nil, "unknown", false)
+}
+
+func TestHasMergeConflicts_NoPullRequest(t *testing.T) {
+	require.False(t, Task{}.HasMergeConflicts())
+}
+
+func testNeedsAttention(t *testing.T, tsk Task, expectedReason AttentionReason, expectedOK bool) {
+	reason, ok := needsAttention(tsk)
+	require.Equal(t, expectedOK, ok)
+	require.Equal(t, expectedReason, reason)
+}
+
+func TestNeedsAttention_NewIssue(t *testing.T) {
+	testNeedsAttention(t, Task{}, AttentionNewIssue, true)
+}
+
+func TestNeedsAttention_IssueComment(t *testing.T) {
+	testNeedsAttention(t, Task{
+		IssueComments:                   newIssueComments(2),
+		IssueCommentsRequiringResponses: newIssueComments(1),
+	}, AttentionIssueComment, true)
+}
+
+func TestNeedsAttention_PRComment(t *testing.T) {
+	testNeedsAttention(t, Task{
+		PullRequest:                  &GithubPullRequest{Number: 12},
+		PRCommentsRequiringResponses: newIssueComments(1),
+	}, AttentionPRComment, true)
+}
+
+func TestNeedsAttention_ReviewComment(t *testing.T) {
+	testNeedsAttention(t, Task{
+		PullRequest:                        &GithubPullRequest{Number: 12},
+		PRReviewCommentsRequiringResponses: []*github.PullRequestComment{{ID: github.Ptr(int64(1))}},
+	}, AttentionReviewComment, true)
+}
+
+func TestNeedsAttention_Command(t *testing.T) {
+	testNeedsAttention(t, Task{
+		IssueComments: newIssueComments(1),
+		Directives:    Directives{Retry: true, Comments: newIssueComments(1)},
+	}, AttentionCommand, true)
+}
+
+func TestNeedsAttention_BotTurnLabel(t *testing.T) {
+	testNeedsAttention(t, Task{
+		Issue:       GithubIssue{Labels: []string{*LabelBotTurn.Name}},
+		PullRequest: &GithubPullRequest{Number: 12},
+	}, AttentionBotTurnLabel, true)
+}
+
+func TestNeedsAttention_PlanApproved(t *testing.T) {
+	testNeedsAttention(t, Task{
+		IssueComments: newIssueComments(1),
+		Plan:          PlanState{Required: true, Comment: &github.IssueComment{}, Approved: true},
+	}, AttentionPlanApproved, true)
+}
+
+func TestNeedsAttention_PlanApprovalAcknowledged(t *testing.T) {
+	testNeedsAttention(t, Task{
+		IssueComments: newIssueComments(1),
+		Plan:          PlanState{Required: true, Comment: &github.IssueComment{}, Approved: true, Started: true},
+	}, AttentionNone, false)
+}
+
+func TestNeedsAttention_Ignored(t *testing.T) {
+	testNeedsAttention(t, Task{
+		Issue:                           GithubIssue{Labels: []string{*LabelIgnore.Name, *LabelBotTurn.Name}},
+		IssueComments:                   newIssueComments(2),
+		IssueCommentsRequiringResponses: newIssueComments(1),
+	}, AttentionNone, false)
+}
+
+func TestNeedsAttention_RenamedIgnoreLabel(t *testing.T) {
+	testNeedsAttention(t, Task{
+		Issue:                           GithubIssue{Labels: []string{"wontfix"}},
+		IssueComments:                   newIssueComments(2),
+		IssueCommentsRequiringResponses: newIssueComments(1),
+		ignoreLabelName:                 "wontfix",
+	}, AttentionNone, false)
+
+	// The default name no longer means anything to the bot
+	testNeedsAttention(t, Task{
+		Issue:                           GithubIssue{Labels: []string{*LabelIgnore.Name}},
+		IssueComments:                   newIssueComments(2),
+		IssueCommentsRequiringResponses: newIssueComments(1),
+		ignoreLabelName:                 "wontfix",
+	}, AttentionIssueComment, true)
+}
+
+func TestNeedsAttention_NothingToDo(t *testing.T) {
+	testNeedsAttention(t, Task{
+		IssueComments: newIssueComments(2),
+		PullRequest:   &GithubPullRequest{Number: 12},
+	}, AttentionNone, false)
+}
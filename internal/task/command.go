@@ -0,0 +1,116 @@
+package task
+
+import (
+	"slices"
+	"strings"
+
+	"github.com/google/go-github/v72/github"
+)
+
+// DefaultCommandPrefix is the prefix of comment commands when CommandConfig doesn't set one
+const DefaultCommandPrefix = "/bot"
+
+// CommandConfig controls how humans direct the bot: which comments are treated as commands to it, and which label tells
+// it to leave an issue alone
+type CommandConfig struct {
+	// Prefix starts a command, e.g. "/bot retry". Defaults to DefaultCommandPrefix
+	Prefix string
+	// AllowedUsers lists the logins of users whose commands are obeyed. If empty, commands are obeyed from the
+	// repository's owner, members of its organization, and its collaborators
+	AllowedUsers []string
+	// IgnoreLabel is the name of the label that marks issues for the bot to leave alone, e.g. a label that the
+	// operator's repositories already use. Defaults to the name of LabelIgnore
+	IgnoreLabel string
+}
+
+// ignoreLabel returns the label that marks issues for the bot to leave alone
+func (cc CommandConfig) ignoreLabel() github.Label {
+	label := LabelIgnore
+	if cc.IgnoreLabel != "" {
+		label.Name = github.Ptr(cc.IgnoreLabel)
+	}
+	return label
+}
+
+// Directives are instructions given to the bot through comment commands that it hasn't acknowledged yet
+type Directives struct {
+	Skip  bool   // Don't work on the task this time
+	Retry bool   // Start the task over, discarding any interrupted conversation
+	Model string // The AI model to use for the task, if non-empty
+
+	// Comments are the comments that the directives were parsed from, which the bot acknowledges once it has obeyed them
+	Comments []*github.IssueComment
+}
+
+// modelAliases maps short model names that may be used in commands to full model names
+var modelAliases = map[string]string{
+	"haiku":  "claude-haiku-4-5",
+	"sonnet": "claude-sonnet-4-5",
+	"opus":   "claude-opus-4-1-20250805",
+}
+
+// trustedAuthorAssociations are the author associations whose commands are obeyed if no allowed users are configured
+var trustedAuthorAssociations = []string{"OWNER", "MEMBER", "COLLABORATOR"}
+
+// extractCommands removes the comments that are commands from an allowed user from the given comments, and applies
+// them to directives in order, so that later commands override earlier ones. Returns the remaining comments
+func (cc CommandConfig) extractCommands(comments []*github.IssueComment, directives *Directives) []*github.IssueComment {
+	var remaining []*github.IssueComment
+	for _, comment := range comments {
+		if !cc.isAllowed(comment) || !cc.applyCommand(comment.GetBody(), directives) {
+			remaining = append(remaining, comment)
+			continue
+		}
+		directives.Comments = append(directives.Comments, comment)
+	}
+	return remaining
+}
+
+// isAllowed returns true if the author of the given comment may give the bot commands
+func (cc CommandConfig) isAllowed(comment *github.IssueComment) bool {
+	return cc.isAllowedAuthor(comment.GetUser().GetLogin(), comment.GetAuthorAssociation())
+}
+
+// isAllowedAuthor returns true if the user with the given login, associated with the repository as given, may give the
+// bot commands
+func (cc CommandConfig) isAllowedAuthor(login string, authorAssociation string) bool {
+	if len(cc.AllowedUsers) > 0 {
+		return slices.Contains(cc.AllowedUsers, login)
+	}
+	return slices.Contains(trustedAuthorAssociations, authorAssociation)
+}
+
+// applyCommand applies the command at the start of the given comment body to directives. Returns false, leaving
+// directives unchanged, if the comment doesn't start with a recognized command
+func (cc CommandConfig) applyCommand(body string, directives *Directives) bool {
+	prefix := cc.Prefix
+	if prefix == "" {
+		prefix = DefaultCommandPrefix
+	}
+
+	firstLine, _, _ := strings.Cut(strings.TrimSpace(body), "\n")
+	fields := strings.Fields(firstLine)
+	if len(fields) < 2 || fields[0] != prefix {
+		return false
+	}
+
+	switch command, args := strings.ToLower(fields[1]), fields[2:]; {
+	case command == "retry" && len(args) == 0:
+		directives.Skip = false
+		directives.Retry = true
+	case command == "ignore" && len(args) == 0:
+		directives.Skip = true
+		directives.Retry = false
+	case command == "model" && len(args) == 1:
+		model := strings.ToLower(args[0])
+		if alias, ok := modelAliases[model]; ok {
+			model = alias
+		} else if !strings.HasPrefix(model, "claude-") {
+			return false
+		}
+		directives.Model = model
+	default:
+		return false
+	}
+	return true
+}
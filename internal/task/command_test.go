@@ -0,0 +1,103 @@
+package task
+
+import (
+	"testing"
+
+	"github.com/google/go-github/v72/github"
+	"github.com/stretchr/testify/require"
+)
+
+func newCommandComment(id int64, login string, association string, body string) *github.IssueComment {
+	return &github.IssueComment{
+		ID:                github.Ptr(id),
+		User:              &github.User{Login: github.Ptr(login)},
+		AuthorAssociation: github.Ptr(association),
+		Body:              github.Ptr(body),
+	}
+}
+
+// testExtractCommands extracts commands from comments with the given bodies, all by a collaborator, and checks the
+// resulting directives and the IDs of the comments that aren't commands
+func testExtractCommands(t *testing.T, config CommandConfig, bodies []string, wantDirectives Directives, wantRemaining []int64) {
+	var comments []*github.IssueComment
+	for i, body := range bodies {
+		comments = append(comments, newCommandComment(int64(i+1), "alice", "COLLABORATOR", body))
+	}
+
+	var directives Directives
+	remaining := config.extractCommands(comments, &directives)
+
+	var remainingIDs []int64
+	for _, comment := range remaining {
+		remainingIDs = append(remainingIDs, comment.GetID())
+	}
+	require.Equal(t, wantRemaining, remainingIDs)
+
+	commandComments := directives.Comments
+	directives.Comments = nil
+	require.Equal(t, wantDirectives, directives)
+	require.Len(t, commandComments, len(bodies)-len(wantRemaining))
+}
+
+func TestExtractCommands_Retry(t *testing.T) {
+	testExtractCommands(t, CommandConfig{}, []string{"/bot retry"}, Directives{Retry: true}, nil)
+}
+
+func TestExtractCommands_Ignore(t *testing.T) {
+	testExtractCommands(t, CommandConfig{}, []string{"/bot ignore\nThis one isn't worth it"}, Directives{Skip: true}, nil)
+}
+
+func TestExtractCommands_ModelAlias(t *testing.T) {
+	testExtractCommands(t, CommandConfig{}, []string{"/bot model Haiku"}, Directives{Model: "claude-haiku-4-5"}, nil)
+}
+
+func TestExtractCommands_ModelFullName(t *testing.T) {
+	testExtractCommands(t, CommandConfig{}, []string{"/bot model claude-sonnet-4-0"}, Directives{Model: "claude-sonnet-4-0"}, nil)
+}
+
+func TestExtractCommands_UnknownModel(t *testing.T) {
+	testExtractCommands(t, CommandConfig{}, []string{"/bot model gpt-5"}, Directives{}, []int64{1})
+}
+
+func TestExtractCommands_LaterCommandsOverride(t *testing.T) {
+	testExtractCommands(t, CommandConfig{}, []string{"/bot ignore", "/bot model opus", "/bot retry"},
+		Directives{Retry: true, Model: "claude-opus-4-1-20250805"}, nil)
+}
+
+func TestExtractCommands_CustomPrefix(t *testing.T) {
+	testExtractCommands(t, CommandConfig{Prefix: "@savant"}, []string{"/bot retry", "@savant ignore"}, Directives{Skip: true}, []int64{1})
+}
+
+func TestExtractCommands_NonCommandComments(t *testing.T) {
+	testExtractCommands(t, CommandConfig{}, []string{
+		"Please retry this",
+		"Could you run /bot retry?",
+		"/bot",
+		"/bot dance",
+		"/bot retry now",
+		"/botretry",
+	}, Directives{}, []int64{1, 2, 3, 4, 5, 6})
+}
+
+func TestExtractCommands_UntrustedAssociation(t *testing.T) {
+	comments := []*github.IssueComment{newCommandComment(1, "mallory", "CONTRIBUTOR", "/bot ignore")}
+
+	var directives Directives
+	remaining := CommandConfig{}.extractCommands(comments, &directives)
+	require.Equal(t, comments, remaining)
+	require.Equal(t, Directives{}, directives)
+}
+
+func TestExtractCommands_AllowedUsers(t *testing.T) {
+	config := CommandConfig{AllowedUsers: []string{"bob"}}
+	comments := []*github.IssueComment{
+		// Allowed users replace the default of trusting the repository's owner, members, and collaborators
+		newCommandComment(1, "alice", "OWNER", "/bot ignore"),
+		newCommandComment(2, "bob", "NONE", "/bot model sonnet"),
+	}
+
+	var directives Directives
+	remaining := config.extractCommands(comments, &directives)
+	require.Equal(t, comments[:1], remaining)
+	require.Equal(t, Directives{Model: "claude-sonnet-4-5", Comments: comments[1:]}, directives)
+}
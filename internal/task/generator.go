@@ -4,42 +4,112 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"math/rand/v2"
+	"slices"
+	"strings"
 	"time"
 
+	"github.com/cchalm/blundering-savant/internal/vcs"
 	"github.com/google/go-github/v72/github"
 )
 
+// defaultTaskRefreshInterval is how long a task may wait to be handed off before the generator checks whether its issue
+// has changed since the task was built
+const defaultTaskRefreshInterval = time.Minute
+
 type TaskOrError struct {
 	Task Task
 	Err  error
 }
 
+// IssueFilter narrows the issues that the generator searches for, beyond those assigned to the bot. The zero value
+// doesn't narrow the search
+type IssueFilter struct {
+	Repos  []string // Qualified names, e.g. "owner/repo". If not empty, only issues in these repositories are found
+	Labels []string // If not empty, only issues with all of these labels are found
+}
+
+// issueTaskBuilder builds tasks for issues that have already been fetched, and decides whether they need the bot's
+// attention
+type issueTaskBuilder interface {
+	buildTaskFromIssue(ctx context.Context, issue GithubIssue, additionalIssues []GithubIssue) (*Task, error)
+	NeedsAttention(task Task) (AttentionReason, bool)
+}
+
 type generator struct {
 	checkInterval time.Duration
 	githubClient  *github.Client
+	vcs           vcs.Provider
 	githubUser    *github.User
+	filter        IssueFilter
+	ignoreLabel   string // The name of the label that marks issues to leave alone
 
-	builder builder
+	// refreshInterval is how long a built task waits for the consumer before its issue is checked for changes
+	refreshInterval time.Duration
+	// jitter is the fraction of checkInterval by which each wait between checks is randomly lengthened or shortened
+	jitter float64
+	// allowlist limits the repositories whose issues are worked on
+	allowlist RepoAllowlist
+
+	builder issueTaskBuilder
+	// onPoll, if not nil, is called after each successful search for issues, and each successful check of a task
+	// waiting for the consumer
+	onPoll func()
 }
 
-func NewGenerator(githubClient *github.Client, githubUser *github.User, checkInterval time.Duration) *generator {
+func NewGenerator(githubClient *github.Client, githubUser *github.User, checkInterval time.Duration, filter IssueFilter, commands CommandConfig) *generator {
+	provider := vcs.NewGithubProvider(githubClient)
 	return &generator{
 		checkInterval: checkInterval,
 		githubClient:  githubClient,
+		vcs:           provider,
 		githubUser:    githubUser,
+		filter:        filter,
+		ignoreLabel:   *commands.ignoreLabel().Name,
+
+		// Refresh at least as often as issues are searched for, so that a waiting task keeps reporting progress
+		refreshInterval: min(defaultTaskRefreshInterval, checkInterval),
 
-		builder: NewBuilder(githubClient, githubUser),
+		builder: NewBuilder(provider, githubUser, commands),
 	}
 }
 
+// OnPoll registers a function to call after each successful search for issues, e.g. to report that the bot is making
+// progress. It is also called each time a task waiting for busy workers is successfully checked for changes, since no
+// searches happen while it waits
+func (tg *generator) OnPoll(f func()) {
+	tg.onPoll = f
+}
+
+// SetJitter randomly varies the wait between checks by up to the given fraction of the check interval in either
+// direction, so that several bots polling on the same interval don't search GitHub at the same time. A fraction of zero,
+// the default, disables jitter
+func (tg *generator) SetJitter(fraction float64) {
+	tg.jitter = fraction
+}
+
+// SetRepoAllowlist skips issues in repositories outside of the given allowlist, as a guardrail against working on
+// issues in unexpected repositories that the bot's token can access
+func (tg *generator) SetRepoAllowlist(allowlist RepoAllowlist) {
+	tg.allowlist = allowlist
+}
+
+// Generate searches for issues that need the bot's attention on the check interval and produces a task for each. The
+// channel is unbuffered, so at most one built task waits for the consumer at a time. While it waits, it is refreshed if
+// its issue changes, or dropped if the issue no longer needs attention, so that the consumer never receives stale
+// tasks. When ctx is cancelled, a final item carrying the context error is sent and the channel is closed
 func (tg *generator) Generate(ctx context.Context) chan TaskOrError {
 	tasks := make(chan TaskOrError)
 
 	go func() {
 		defer close(tasks)
-		for {
+		for ctx.Err() == nil {
 			tg.yield(ctx, func(task Task, err error) {
-				tasks <- TaskOrError{Task: task, Err: err}
+				if err != nil {
+					tasks <- TaskOrError{Err: err}
+					return
+				}
+				tg.handOff(ctx, tasks, task)
 			})
 		}
 	}()
@@ -47,59 +117,251 @@ func (tg *generator) Generate(ctx context.Context) chan TaskOrError {
 	return tasks
 }
 
+// handOff sends the given task to the consumer, refreshing it each refresh interval until the consumer is ready. Gives
+// up without sending if the task is dropped or ctx is cancelled
+func (tg *generator) handOff(ctx context.Context, tasks chan<- TaskOrError, tsk Task) {
+	for {
+		timer := time.NewTimer(tg.refreshInterval)
+		select {
+		case tasks <- TaskOrError{Task: tsk}:
+			timer.Stop()
+			return
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		refreshed, ok := tg.refresh(ctx, tsk)
+		if !ok {
+			return
+		}
+		tsk = refreshed
+	}
+}
+
+// refresh rebuilds the given task if its issue or pull request has changed since the task was built. Returns false if
+// the task should be dropped because its issue no longer needs attention. If the issue or pull request can't be fetched
+// or the task can't be rebuilt, the task is kept as it is, to be handed off or refreshed again later
+func (tg *generator) refresh(ctx context.Context, tsk Task) (Task, bool) {
+	issue := tsk.Issue
+	latest, _, err := tg.githubClient.Issues.Get(ctx, issue.Owner, issue.Repo, issue.Number)
+	if err != nil {
+		log.Printf("[taskgen] Warning: failed to refresh issue #%d in %s/%s: %v", issue.Number, issue.Owner, issue.Repo, err)
+		return tsk, true
+	}
+	if tg.onPoll != nil {
+		tg.onPoll()
+	}
+	prChanged, err := tg.pullRequestChanged(ctx, tsk.PullRequest)
+	if err != nil {
+		log.Printf("[taskgen] Warning: failed to refresh the pull request for issue #%d in %s/%s: %v", issue.Number, issue.Owner, issue.Repo, err)
+		return tsk, true
+	}
+	if latest.GetUpdatedAt().Time.Equal(issue.UpdatedAt) && !prChanged {
+		return tsk, true
+	}
+
+	if !tg.stillMatches(latest) {
+		log.Printf("[taskgen] Dropping task for issue #%d in %s/%s: issue no longer matches the search", issue.Number, issue.Owner, issue.Repo)
+		return Task{}, false
+	}
+	converted, err := convertIssue(latest)
+	if err != nil {
+		log.Printf("[taskgen] Warning: failed to refresh issue #%d in %s/%s: %v", issue.Number, issue.Owner, issue.Repo, err)
+		return tsk, true
+	}
+	rebuilt, err := tg.builder.buildTaskFromIssue(ctx, converted, tsk.AdditionalIssues)
+	if err != nil {
+		log.Printf("[taskgen] Warning: failed to rebuild task for issue #%d in %s/%s: %v", issue.Number, issue.Owner, issue.Repo, err)
+		return tsk, true
+	}
+	if _, ok := tg.builder.NeedsAttention(*rebuilt); !ok {
+		log.Printf("[taskgen] Dropping task for issue #%d in %s/%s: no attention needed", issue.Number, issue.Owner, issue.Repo)
+		return Task{}, false
+	}
+	if isBlockedAwaitingRetry(*rebuilt) {
+		log.Printf("[taskgen] Dropping task for issue #%d in %s/%s: issue is blocked", issue.Number, issue.Owner, issue.Repo)
+		return Task{}, false
+	}
+
+	log.Printf("[taskgen] Refreshed task for issue #%d in %s/%s", issue.Number, issue.Owner, issue.Repo)
+	return *rebuilt, true
+}
+
+// pullRequestChanged reports whether the given pull request has changed since it was fetched, e.g. because someone
+// reviewed it, which doesn't change its issue. A task without a pull request has none to change
+func (tg *generator) pullRequestChanged(ctx context.Context, pr *GithubPullRequest) (bool, error) {
+	if pr == nil {
+		return false, nil
+	}
+	latest, _, err := tg.githubClient.PullRequests.Get(ctx, pr.Owner, pr.Repo, pr.Number)
+	if err != nil {
+		return false, err
+	}
+	return !latest.GetUpdatedAt().Time.Equal(pr.UpdatedAt), nil
+}
+
+// stillMatches reports whether the given issue would still be found by the generator's search, i.e. it is open,
+// assigned to the bot, not being worked on or ignored, and has all of the filter's labels
+func (tg *generator) stillMatches(issue *github.Issue) bool {
+	if issue.GetState() != "open" {
+		return false
+	}
+	if !slices.ContainsFunc(issue.Assignees, func(user *github.User) bool {
+		return user.GetLogin() == tg.githubUser.GetLogin()
+	}) {
+		return false
+	}
+
+	labels := map[string]bool{}
+	for _, label := range issue.Labels {
+		labels[label.GetName()] = true
+	}
+	if labels[LabelWorking.GetName()] || labels[tg.ignoreLabel] {
+		return false
+	}
+	for _, label := range tg.filter.Labels {
+		if !labels[label] {
+			return false
+		}
+	}
+	return true
+}
+
 func (tg *generator) yield(ctx context.Context, yield func(task Task, err error)) {
-	ticker := time.Tick(tg.checkInterval)
 	for {
+		checkStarted := time.Now()
 		issues, err := tg.searchIssues(ctx)
 		if err != nil {
 			return
 		}
+		if tg.onPoll != nil {
+			tg.onPoll()
+		}
 		if len(issues) == 0 {
 			log.Println("[taskgen] No issues found")
 		}
 
 		for _, issue := range issues {
-			tsk, err := tg.builder.buildTaskFromIssue(ctx, issue)
+			if !tg.allowlist.Allows(issue.Owner, issue.Repo) {
+				log.Printf("[taskgen] Skipping issue #%d in %s/%s: repository is not on the allowlist", issue.Number, issue.Owner, issue.Repo)
+				continue
+			}
+			if slices.Contains(issue.Labels, LabelWorking.GetName()) {
+				expired, err := tg.workingLabelExpired(ctx, issue)
+				if err != nil {
+					log.Printf("[taskgen] Warning: failed to check the in-progress label of issue #%d in %s/%s: %v", issue.Number, issue.Owner, issue.Repo, err)
+					continue
+				} else if !expired {
+					log.Printf("[taskgen] Skipping issue #%d in %s/%s: issue is being worked on", issue.Number, issue.Owner, issue.Repo)
+					continue
+				}
+				log.Printf("[taskgen] The in-progress label of issue #%d in %s/%s has expired, treating the issue as abandoned", issue.Number, issue.Owner, issue.Repo)
+			}
+
+			tsk, err := tg.builder.buildTaskFromIssue(ctx, issue, nil)
 			if err != nil {
 				yield(Task{}, fmt.Errorf("failed to build task for issue %d: %w", issue.Number, err))
+				continue
 			}
 
-			if tg.builder.NeedsAttention(*tsk) {
-				log.Printf("[taskgen] Yielding task for issue #%d in %s/%s", issue.Number, issue.Owner, issue.Repo)
+			if isBlockedAwaitingRetry(*tsk) {
+				log.Printf("[taskgen] Skipping issue #%d in %s/%s: issue is blocked", issue.Number, issue.Owner, issue.Repo)
+			} else if reason, ok := tg.builder.NeedsAttention(*tsk); ok {
+				log.Printf("[taskgen] Yielding task for issue #%d in %s/%s (%s)", issue.Number, issue.Owner, issue.Repo, reason)
 				yield(*tsk, nil)
 			} else {
 				log.Printf("[taskgen] Skipping issue #%d in %s/%s: no attention needed", issue.Number, issue.Owner, issue.Repo)
 			}
 		}
 
-		log.Printf("[taskgen] Waiting for next check (up to %v)\n", tg.checkInterval)
+		delay := tg.nextCheckDelay()
+		log.Printf("[taskgen] Waiting for next check (up to %v)\n", delay)
+		timer := time.NewTimer(time.Until(checkStarted.Add(delay)))
 		select {
-		case <-ticker:
+		case <-timer.C:
 		case <-ctx.Done():
+			timer.Stop()
 			yield(Task{}, ctx.Err())
 			return
 		}
 	}
 }
 
-func (tg *generator) searchIssues(ctx context.Context) ([]GithubIssue, error) {
-	// Search for issues assigned to the bot that are not being worked on and are not blocked
-	query := fmt.Sprintf("assignee:%s is:issue is:open -label:%s -label:%s", *tg.githubUser.Login, *LabelWorking.Name, *LabelBlocked.Name)
-	result, _, err := tg.githubClient.Search.Issues(ctx, query, nil)
+// workingLabelExpired reports whether the in-progress label on the given issue was added more than WorkingLabelExpiry
+// ago, so that whoever added it has likely died while working on the issue. A label that can't be dated hasn't expired
+func (tg *generator) workingLabelExpired(ctx context.Context, issue GithubIssue) (bool, error) {
+	events, err := tg.vcs.ListIssueEvents(ctx, issue.Owner, issue.Repo, issue.Number)
 	if err != nil {
-		return nil, fmt.Errorf("error searching issues: %w", err)
+		return false, fmt.Errorf("failed to list issue events: %w", err)
 	}
+	added := WorkingLabelAdded(events)
+	return added != nil && time.Since(added.GetCreatedAt().Time) >= WorkingLabelExpiry, nil
+}
+
+// nextCheckDelay returns the time between the start of one check and the start of the next: the check interval, varied
+// by the jitter
+func (tg *generator) nextCheckDelay() time.Duration {
+	if tg.jitter <= 0 {
+		return tg.checkInterval
+	}
+	// A uniformly random fraction in [-jitter, jitter)
+	spread := (rand.Float64()*2 - 1) * tg.jitter
+	return tg.checkInterval + time.Duration(spread*float64(tg.checkInterval))
+}
+
+func (tg *generator) searchIssues(ctx context.Context) ([]GithubIssue, error) {
+	query := buildSearchQuery(*tg.githubUser.Login, tg.filter, tg.ignoreLabel)
 
 	// Convert issue response into simpler structures
 	issues := []GithubIssue{}
-	for _, issue := range result.Issues {
-		converted, err := convertIssue(issue)
+	opts := &github.SearchOptions{ListOptions: github.ListOptions{PerPage: 100}}
+	for {
+		result, resp, err := tg.githubClient.Search.Issues(ctx, query, opts)
 		if err != nil {
-			log.Printf("[taskgen] Warning: skipping issue: %v", err)
+			return nil, fmt.Errorf("error searching issues: %w", err)
+		}
+
+		for _, issue := range result.Issues {
+			converted, err := convertIssue(issue)
+			if err != nil {
+				log.Printf("[taskgen] Warning: skipping issue: %v", err)
+				continue
+			}
+
+			issues = append(issues, converted)
 		}
 
-		issues = append(issues, converted)
+		if resp.NextPage == 0 {
+			break
+		}
+		opts.Page = resp.NextPage
 	}
 
 	return issues, nil
 }
+
+// isBlockedAwaitingRetry returns true if the given task's issue is blocked and no allowed user has told the bot to retry
+// it since
+func isBlockedAwaitingRetry(tsk Task) bool {
+	return slices.Contains(tsk.Issue.Labels, LabelBlocked.GetName()) && !tsk.Directives.Retry
+}
+
+// buildSearchQuery builds a query for issues assigned to the given user that are not labeled with ignoreLabel, narrowed
+// by the given filter. Blocked issues are found too, so that a retry command can unblock them, but they are skipped
+// unless there is one. Issues being worked on are found too, so that ones whose in-progress label has expired can be
+// picked up again, but the others are skipped
+func buildSearchQuery(login string, filter IssueFilter, ignoreLabel string) string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "assignee:%s is:issue is:open -label:%q", login, ignoreLabel)
+	// Labels are quoted in case they contain spaces. GitHub matches issues in any of the given repositories, but only
+	// issues with all of the given labels
+	for _, repo := range filter.Repos {
+		fmt.Fprintf(&sb, " repo:%s", repo)
+	}
+	for _, label := range filter.Labels {
+		fmt.Fprintf(&sb, " label:%q", label)
+	}
+	return sb.String()
+}
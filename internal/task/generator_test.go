@@ -1,6 +1,15 @@
 package task
 
 import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -81,11 +90,6 @@ func TestOrganizePRReviewCommentsIntoThreads_MultipleIndependentThreads(t *testi
 	require.NoError(t, err)
 	require.Len(t, threads, 2)
 
-	// Sort threads by first comment ID for consistent testing
-	if *threads[0][0].ID > *threads[1][0].ID {
-		threads[0], threads[1] = threads[1], threads[0]
-	}
-
 	require.Len(t, threads[0], 2)
 	require.Equal(t, int64(1), *threads[0][0].ID)
 	require.Equal(t, int64(2), *threads[0][1].ID)
@@ -95,6 +99,35 @@ func TestOrganizePRReviewCommentsIntoThreads_MultipleIndependentThreads(t *testi
 	require.Equal(t, int64(4), *threads[1][1].ID)
 }
 
+func TestOrganizePRReviewCommentsIntoThreads_StableOrder(t *testing.T) {
+	// Threads (1->4), (2->5), and (3), with 3 created at the same time as 2, listed newest first
+	tied := createComment(3, nil)
+	tied.CreatedAt = &github.Timestamp{Time: time.UnixMilli(2)}
+	comments := []*github.PullRequestComment{
+		createComment(5, int64Ptr(2)),
+		createComment(4, int64Ptr(1)),
+		tied,
+		createComment(2, nil),
+		createComment(1, nil),
+	}
+
+	// Map iteration order varies between runs, so organize the same comments repeatedly
+	for range 20 {
+		threads, err := organizePRReviewCommentsIntoThreads(comments)
+		require.NoError(t, err)
+
+		var ids [][]int64
+		for _, thread := range threads {
+			var threadIDs []int64
+			for _, comment := range thread {
+				threadIDs = append(threadIDs, comment.GetID())
+			}
+			ids = append(ids, threadIDs)
+		}
+		require.Equal(t, [][]int64{{1, 4}, {2, 5}, {3}}, ids)
+	}
+}
+
 func TestOrganizePRReviewCommentsIntoThreads_NilComment(t *testing.T) {
 	comments := []*github.PullRequestComment{
 		createComment(1, nil),
@@ -132,3 +165,481 @@ func TestOrganizePRReviewCommentsIntoThreads_EmptyInput(t *testing.T) {
 	require.NoError(t, err)
 	require.Len(t, threads, 0)
 }
+
+// issueTaskBuilderStub builds empty tasks for issues, all of which need attention
+type issueTaskBuilderStub struct{}
+
+func (itbs issueTaskBuilderStub) buildTaskFromIssue(ctx context.Context, issue GithubIssue, additionalIssues []GithubIssue) (*Task, error) {
+	return &Task{Issue: issue}, nil
+}
+
+func (itbs issueTaskBuilderStub) NeedsAttention(task Task) (AttentionReason, bool) {
+	return AttentionNewIssue, true
+}
+
+func searchResultsJSON(issueNumbers ...int) string {
+	items := ""
+	for i, number := range issueNumbers {
+		if i > 0 {
+			items += ","
+		}
+		items += fmt.Sprintf(`{"number": %d, "title": "Issue %[1]d", "url": "https://example.com/%[1]d", `+
+			`"repository_url": "https://api.github.com/repos/owner/repo"}`, number)
+	}
+	return fmt.Sprintf(`{"total_count": %d, "items": [%s]}`, len(issueNumbers), items)
+}
+
+func TestGenerator_PaginatesSearch(t *testing.T) {
+	var queries []string
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/search/issues", r.URL.Path)
+		queries = append(queries, r.URL.Query().Get("q"))
+
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Query().Get("page") == "2" {
+			_, _ = w.Write([]byte(searchResultsJSON(3)))
+			return
+		}
+		w.Header().Set("Link", fmt.Sprintf(`<%s/search/issues?page=2>; rel="next", <%[1]s/search/issues?page=2>; rel="last"`, server.URL))
+		_, _ = w.Write([]byte(searchResultsJSON(1, 2)))
+	}))
+	t.Cleanup(server.Close)
+
+	client := github.NewClient(nil)
+	baseURL, err := url.Parse(server.URL + "/")
+	require.NoError(t, err)
+	client.BaseURL = baseURL
+
+	filter := IssueFilter{Repos: []string{"owner/repo", "owner/other"}, Labels: []string{"help wanted"}}
+	tg := NewGenerator(client, &github.User{Login: github.Ptr("bot")}, time.Hour, filter, CommandConfig{})
+	tg.builder = issueTaskBuilderStub{}
+	polls := 0
+	tg.OnPoll(func() { polls++ })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var issueNumbers []int
+	tg.yield(ctx, func(task Task, err error) {
+		if err != nil {
+			require.ErrorIs(t, err, context.Canceled)
+			return
+		}
+		issueNumbers = append(issueNumbers, task.Issue.Number)
+		if len(issueNumbers) == 3 {
+			// Stop after the first search
+			cancel()
+		}
+	})
+
+	require.Equal(t, []int{1, 2, 3}, issueNumbers)
+	require.Equal(t, 1, polls)
+	expectedQuery := `assignee:bot is:issue is:open -label:"bot-ignore" ` +
+		`repo:owner/repo repo:owner/other label:"help wanted"`
+	require.Equal(t, []string{expectedQuery, expectedQuery}, queries)
+}
+
+func TestGenerator_SkipsReposOutsideAllowlist(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"total_count": 3, "items": [
+			{"number": 1, "title": "Issue 1", "url": "https://example.com/1", "repository_url": "https://api.github.com/repos/myorg/web"},
+			{"number": 2, "title": "Issue 2", "url": "https://example.com/2", "repository_url": "https://api.github.com/repos/stranger/repo"},
+			{"number": 3, "title": "Issue 3", "url": "https://example.com/3", "repository_url": "https://api.github.com/repos/myorg/api"}
+		]}`))
+	}))
+	t.Cleanup(server.Close)
+
+	client := github.NewClient(nil)
+	baseURL, err := url.Parse(server.URL + "/")
+	require.NoError(t, err)
+	client.BaseURL = baseURL
+
+	allowlist, err := NewRepoAllowlist([]string{"myorg/*"})
+	require.NoError(t, err)
+	tg := NewGenerator(client, &github.User{Login: github.Ptr("bot")}, time.Hour, IssueFilter{}, CommandConfig{})
+	tg.builder = issueTaskBuilderStub{}
+	tg.SetRepoAllowlist(allowlist)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	// Stop after the first search
+	tg.OnPoll(cancel)
+	var processed []string
+	tg.yield(ctx, func(task Task, err error) {
+		if err != nil {
+			require.ErrorIs(t, err, context.Canceled)
+			return
+		}
+		processed = append(processed, fmt.Sprintf("%s/%s#%d", task.Issue.Owner, task.Issue.Repo, task.Issue.Number))
+	})
+
+	require.Equal(t, []string{"myorg/web#1", "myorg/api#3"}, processed)
+}
+
+func TestGenerator_ExcludesIgnoredIssues(t *testing.T) {
+	// The fake search honors label exclusions in the query, like GitHub's does
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		issues := map[int]string{1: "bug", 2: "bot-ignore", 3: ""}
+		var items []string
+		for number := 1; number <= 3; number++ {
+			label := issues[number]
+			if label != "" && strings.Contains(r.URL.Query().Get("q"), fmt.Sprintf("-label:%q", label)) {
+				continue
+			}
+			items = append(items, fmt.Sprintf(`{"number": %d, "title": "Issue %[1]d", "url": "https://example.com/%[1]d", `+
+				`"repository_url": "https://api.github.com/repos/owner/repo", "labels": [{"name": "%s"}]}`, number, label))
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = fmt.Fprintf(w, `{"total_count": %d, "items": [%s]}`, len(items), strings.Join(items, ","))
+	}))
+	t.Cleanup(server.Close)
+
+	client := github.NewClient(nil)
+	baseURL, err := url.Parse(server.URL + "/")
+	require.NoError(t, err)
+	client.BaseURL = baseURL
+
+	tg := NewGenerator(client, &github.User{Login: github.Ptr("bot")}, time.Hour, IssueFilter{}, CommandConfig{})
+	tg.builder = issueTaskBuilderStub{}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	// Stop after the first search
+	tg.OnPoll(cancel)
+	var issueNumbers []int
+	tg.yield(ctx, func(task Task, err error) {
+		if err != nil {
+			require.ErrorIs(t, err, context.Canceled)
+			return
+		}
+		issueNumbers = append(issueNumbers, task.Issue.Number)
+	})
+
+	require.Equal(t, []int{1, 3}, issueNumbers)
+}
+
+func TestGenerator_SkipsIssuesBeingWorkedOn(t *testing.T) {
+	// Issues 1 and 2 have the in-progress label, but issue 2's was left behind long ago
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/search/issues":
+			labels := map[int]string{1: "bot-working", 2: "bot-working", 3: "bug"}
+			var items []string
+			for number := 1; number <= 3; number++ {
+				label := labels[number]
+				items = append(items, fmt.Sprintf(`{"number": %d, "title": "Issue %[1]d", "url": "https://example.com/%[1]d", `+
+					`"repository_url": "https://api.github.com/repos/owner/repo", "labels": [{"name": "%s"}]}`, number, label))
+			}
+			_, _ = fmt.Fprintf(w, `{"total_count": %d, "items": [%s]}`, len(items), strings.Join(items, ","))
+		case "/repos/owner/repo/issues/1/events":
+			_, _ = fmt.Fprintf(w, `[{"id": 1, "event": "labeled", "label": {"name": "bot-working"}, "created_at": %q}]`,
+				time.Now().Add(-time.Minute).Format(time.RFC3339))
+		case "/repos/owner/repo/issues/2/events":
+			_, _ = fmt.Fprintf(w, `[{"id": 2, "event": "labeled", "label": {"name": "bot-working"}, "created_at": %q}]`,
+				time.Now().Add(-WorkingLabelExpiry-time.Minute).Format(time.RFC3339))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	client := github.NewClient(nil)
+	baseURL, err := url.Parse(server.URL + "/")
+	require.NoError(t, err)
+	client.BaseURL = baseURL
+
+	tg := NewGenerator(client, &github.User{Login: github.Ptr("bot")}, time.Hour, IssueFilter{}, CommandConfig{})
+	tg.builder = issueTaskBuilderStub{}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var issueNumbers []int
+	tg.yield(ctx, func(task Task, err error) {
+		if err != nil {
+			require.ErrorIs(t, err, context.Canceled)
+			return
+		}
+		issueNumbers = append(issueNumbers, task.Issue.Number)
+		if task.Issue.Number == 3 {
+			// Stop after the first search
+			cancel()
+		}
+	})
+
+	require.Equal(t, []int{2, 3}, issueNumbers)
+}
+
+// retryTaskBuilder builds tasks for issues like issueTaskBuilderStub, with a retry command on the given issues
+type retryTaskBuilder struct {
+	issueTaskBuilderStub
+	retried []int
+}
+
+func (rtb retryTaskBuilder) buildTaskFromIssue(ctx context.Context, issue GithubIssue, additionalIssues []GithubIssue) (*Task, error) {
+	return &Task{Issue: issue, Directives: Directives{Retry: slices.Contains(rtb.retried, issue.Number)}}, nil
+}
+
+func TestGenerator_BlockedIssuesNeedRetry(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"total_count": 3, "items": [
+			{"number": 1, "title": "Issue 1", "url": "https://example.com/1", "repository_url": "https://api.github.com/repos/owner/repo"},
+			{"number": 2, "title": "Issue 2", "url": "https://example.com/2", "repository_url": "https://api.github.com/repos/owner/repo", "labels": [{"name": "bot-blocked"}]},
+			{"number": 3, "title": "Issue 3", "url": "https://example.com/3", "repository_url": "https://api.github.com/repos/owner/repo", "labels": [{"name": "bot-blocked"}]}
+		]}`))
+	}))
+	t.Cleanup(server.Close)
+
+	client := github.NewClient(nil)
+	baseURL, err := url.Parse(server.URL + "/")
+	require.NoError(t, err)
+	client.BaseURL = baseURL
+
+	tg := NewGenerator(client, &github.User{Login: github.Ptr("bot")}, time.Hour, IssueFilter{}, CommandConfig{})
+	tg.builder = retryTaskBuilder{retried: []int{3}}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	// Stop after the first search
+	tg.OnPoll(cancel)
+	var issueNumbers []int
+	tg.yield(ctx, func(task Task, err error) {
+		if err != nil {
+			require.ErrorIs(t, err, context.Canceled)
+			return
+		}
+		issueNumbers = append(issueNumbers, task.Issue.Number)
+	})
+
+	require.Equal(t, []int{1, 3}, issueNumbers, "only the blocked issue with a retry command should be worked on")
+}
+
+func TestBuildSearchQuery_RenamedIgnoreLabel(t *testing.T) {
+	tg := NewGenerator(github.NewClient(nil), &github.User{Login: github.Ptr("bot")}, time.Hour, IssueFilter{},
+		CommandConfig{IgnoreLabel: "wont fix"})
+
+	require.Equal(t, `assignee:bot is:issue is:open -label:"wont fix"`,
+		buildSearchQuery("bot", IssueFilter{}, tg.ignoreLabel))
+}
+
+func TestGenerator_NextCheckDelayNoJitter(t *testing.T) {
+	tg := &generator{checkInterval: 5 * time.Minute}
+	require.Equal(t, 5*time.Minute, tg.nextCheckDelay())
+}
+
+func TestGenerator_NextCheckDelayJitter(t *testing.T) {
+	tg := &generator{checkInterval: 10 * time.Minute}
+	tg.SetJitter(0.2)
+
+	delays := map[time.Duration]bool{}
+	for range 100 {
+		delay := tg.nextCheckDelay()
+		require.GreaterOrEqual(t, delay, 8*time.Minute)
+		require.LessOrEqual(t, delay, 12*time.Minute)
+		delays[delay] = true
+	}
+	require.Greater(t, len(delays), 1, "delays should vary")
+}
+
+// countingTaskBuilder builds tasks for issues like issueTaskBuilderStub, and counts how many times each issue is built
+type countingTaskBuilder struct {
+	mu     sync.Mutex
+	builds map[int]int
+}
+
+func (ctb *countingTaskBuilder) buildTaskFromIssue(ctx context.Context, issue GithubIssue, additionalIssues []GithubIssue) (*Task, error) {
+	ctb.mu.Lock()
+	defer ctb.mu.Unlock()
+	ctb.builds[issue.Number]++
+	return &Task{Issue: issue}, nil
+}
+
+func (ctb *countingTaskBuilder) NeedsAttention(task Task) (AttentionReason, bool) {
+	return AttentionNewIssue, true
+}
+
+func (ctb *countingTaskBuilder) buildCount(issueNumber int) int {
+	ctb.mu.Lock()
+	defer ctb.mu.Unlock()
+	return ctb.builds[issueNumber]
+}
+
+// pullRequestTaskBuilder builds tasks like countingTaskBuilder, but attaches pull request #5 to issue 2. The pull request
+// is as it was last updated at the given times in successive builds, the last of which is reused for any later builds
+type pullRequestTaskBuilder struct {
+	countingTaskBuilder
+	updates []time.Time
+}
+
+func (ptb *pullRequestTaskBuilder) buildTaskFromIssue(ctx context.Context, issue GithubIssue, additionalIssues []GithubIssue) (*Task, error) {
+	tsk, err := ptb.countingTaskBuilder.buildTaskFromIssue(ctx, issue, additionalIssues)
+	if err != nil || issue.Number != 2 {
+		return tsk, err
+	}
+	updatedAt := ptb.updates[min(ptb.buildCount(2), len(ptb.updates))-1]
+	tsk.PullRequest = &GithubPullRequest{Owner: "owner", Repo: "repo", Number: 5, UpdatedAt: updatedAt}
+	return tsk, nil
+}
+
+// issueJSON returns an issue in owner/repo assigned to the bot, as returned by the search and issues APIs
+func issueJSON(number int, state string, body string, updatedAt time.Time) string {
+	return fmt.Sprintf(`{"number": %d, "title": "Issue %[1]d", "url": "https://example.com/%[1]d", `+
+		`"repository_url": "https://api.github.com/repos/owner/repo", "state": %q, "body": %q, `+
+		`"assignees": [{"login": "bot"}], "updated_at": %q}`, number, state, body, updatedAt.Format(time.RFC3339))
+}
+
+// testRefreshingGenerator creates a generator whose search finds issues 1 and 2, and whose issues API returns the given
+// JSON for issue 2, as it is by the time the generator refreshes it. Returns the generator, its builder, and counters
+// of searches and fetches of issue 2
+func testRefreshingGenerator(t *testing.T, issue2JSON string) (*generator, *countingTaskBuilder, *atomic.Int32, *atomic.Int32) {
+	var searches, fetches atomic.Int32
+	found := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/search/issues":
+			searches.Add(1)
+			_, _ = fmt.Fprintf(w, `{"total_count": 2, "items": [%s, %s]}`,
+				issueJSON(1, "open", "original", found), issueJSON(2, "open", "original", found))
+		case "/repos/owner/repo/issues/2":
+			fetches.Add(1)
+			_, _ = w.Write([]byte(issue2JSON))
+		case "/repos/owner/repo/pulls/5":
+			_, _ = fmt.Fprintf(w, `{"number": 5, "updated_at": %q}`, found.Add(30*time.Minute).Format(time.RFC3339))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	client := github.NewClient(nil)
+	baseURL, err := url.Parse(server.URL + "/")
+	require.NoError(t, err)
+	client.BaseURL = baseURL
+
+	builder := &countingTaskBuilder{builds: map[int]int{}}
+	tg := NewGenerator(client, &github.User{Login: github.Ptr("bot")}, time.Hour, IssueFilter{}, CommandConfig{})
+	tg.builder = builder
+	tg.refreshInterval = 10 * time.Millisecond
+	return tg, builder, &searches, &fetches
+}
+
+// drainTasks cancels generation and checks that the generator's final item is the context error
+func drainTasks(t *testing.T, tasks chan TaskOrError, cancel context.CancelFunc) {
+	cancel()
+	var last TaskOrError
+	for item := range tasks {
+		last = item
+	}
+	require.ErrorIs(t, last.Err, context.Canceled)
+}
+
+func TestGenerator_SlowConsumerGetsRefreshedTask(t *testing.T) {
+	edited := time.Date(2025, 1, 1, 12, 30, 0, 0, time.UTC)
+	tg, builder, searches, fetches := testRefreshingGenerator(t, issueJSON(2, "open", "edited", edited))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	tasks := tg.Generate(ctx)
+
+	first := <-tasks
+	require.NoError(t, first.Err)
+	require.Equal(t, 1, first.Task.Issue.Number)
+
+	// Be slow to take the next task, so that the generator has to hold onto it
+	time.Sleep(100 * time.Millisecond)
+
+	second := <-tasks
+	require.NoError(t, second.Err)
+	require.Equal(t, 2, second.Task.Issue.Number)
+	require.Equal(t, "edited", second.Task.Issue.Body)
+	require.True(t, edited.Equal(second.Task.Issue.UpdatedAt))
+
+	require.Greater(t, fetches.Load(), int32(1), "the waiting task should be checked on each refresh interval")
+	require.Equal(t, 2, builder.buildCount(2), "the task should be rebuilt once, when the issue changed, and not again")
+	require.Equal(t, 1, builder.buildCount(1))
+	require.Equal(t, int32(1), searches.Load(), "waiting tasks should be refreshed rather than searched for again")
+
+	drainTasks(t, tasks, cancel)
+}
+
+func TestGenerator_WaitingTaskReportsPolls(t *testing.T) {
+	found := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	tg, _, searches, _ := testRefreshingGenerator(t, issueJSON(2, "open", "original", found))
+	var polls atomic.Int32
+	tg.OnPoll(func() { polls.Add(1) })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	tasks := tg.Generate(ctx)
+
+	first := <-tasks
+	require.NoError(t, first.Err)
+
+	// Be slow to take the next task, as if every worker were busy, so that the generator stops searching
+	time.Sleep(100 * time.Millisecond)
+
+	require.Equal(t, int32(1), searches.Load())
+	require.Greater(t, polls.Load(), int32(1), "checks of the waiting task should count as polls")
+
+	drainTasks(t, tasks, cancel)
+}
+
+func TestGenerator_SlowConsumerGetsTaskRefreshedForPullRequest(t *testing.T) {
+	found := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	reviewed := found.Add(30 * time.Minute)
+	// The issue is unchanged, but its pull request is reviewed while the task waits
+	tg, _, _, _ := testRefreshingGenerator(t, issueJSON(2, "open", "original", found))
+	builder := &pullRequestTaskBuilder{
+		countingTaskBuilder: countingTaskBuilder{builds: map[int]int{}},
+		updates:             []time.Time{found, reviewed},
+	}
+	tg.builder = builder
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	tasks := tg.Generate(ctx)
+
+	first := <-tasks
+	require.NoError(t, first.Err)
+	require.Equal(t, 1, first.Task.Issue.Number)
+
+	// Be slow to take the next task, so that the generator has to hold onto it
+	time.Sleep(100 * time.Millisecond)
+
+	second := <-tasks
+	require.NoError(t, second.Err)
+	require.Equal(t, 2, second.Task.Issue.Number)
+	require.True(t, reviewed.Equal(second.Task.PullRequest.UpdatedAt))
+	require.Equal(t, 2, builder.buildCount(2), "the task should be rebuilt once, when the pull request changed, and not again")
+
+	drainTasks(t, tasks, cancel)
+}
+
+func TestGenerator_SlowConsumerDoesNotGetDroppedTask(t *testing.T) {
+	closed := time.Date(2025, 1, 1, 12, 30, 0, 0, time.UTC)
+	tg, builder, _, fetches := testRefreshingGenerator(t, issueJSON(2, "closed", "original", closed))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	tasks := tg.Generate(ctx)
+
+	first := <-tasks
+	require.NoError(t, first.Err)
+	require.Equal(t, 1, first.Task.Issue.Number)
+
+	// Be slow to take the next task, so that the generator has to hold onto it
+	time.Sleep(100 * time.Millisecond)
+
+	select {
+	case item := <-tasks:
+		require.Fail(t, "the task for the closed issue should have been dropped", "got %+v", item)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	require.Equal(t, int32(1), fetches.Load(), "a dropped task should not be refreshed again")
+	require.Equal(t, 1, builder.buildCount(2))
+	drainTasks(t, tasks, cancel)
+}
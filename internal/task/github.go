@@ -3,6 +3,7 @@ package task
 import (
 	"fmt"
 	"strings"
+	"time"
 
 	"github.com/google/go-github/v72/github"
 )
@@ -15,8 +16,16 @@ type GithubIssue struct {
 	Title string
 	Body  string
 	URL   string
+	// FormFields holds the fields of the body if the issue was submitted with a GitHub issue form, or nil otherwise
+	FormFields []IssueFormField
 
 	Labels []string
+	// UpdatedAt is when the issue last changed, e.g. by being edited, commented on, or labeled
+	UpdatedAt time.Time
+
+	Author string // The login of the user who opened the issue
+	// AuthorAssociation is how the author is associated with the repository, e.g. "OWNER" or "CONTRIBUTOR"
+	AuthorAssociation string
 }
 
 type GithubPullRequest struct {
@@ -24,10 +33,16 @@ type GithubPullRequest struct {
 	Repo   string
 	Number int
 
-	Title string
-	URL   string
+	Title  string
+	URL    string
+	Author string // The login of the user who opened the pull request
 
 	BaseBranch string
+	// HasConflicts is true if GitHub reports that the pull request conflicts with its base branch. GitHub computes this
+	// in the background, so it is false until GitHub has done so
+	HasConflicts bool
+	// UpdatedAt is when the pull request last changed, e.g. by being pushed to, reviewed, or commented on
+	UpdatedAt time.Time
 }
 
 var (
@@ -46,8 +61,45 @@ var (
 		Description: github.Ptr("it is the bot's turn to take action on this issue"),
 		Color:       github.Ptr("2020f0"),
 	}
+	LabelNeedsHuman = github.Label{
+		Name:        github.Ptr("needs-human"),
+		Description: github.Ptr("the bot has asked a question and is waiting for a human to answer it"),
+		Color:       github.Ptr("d876e3"),
+	}
+	// LabelIgnore marks an issue that the bot has decided to leave alone, e.g. because it is spam or out of scope. Its
+	// name can be changed with CommandConfig.IgnoreLabel, so use Task.IgnoreLabel rather than this directly
+	LabelIgnore = github.Label{
+		Name:        github.Ptr("bot-ignore"),
+		Description: github.Ptr("the bot will not work on this issue until the label is removed"),
+		Color:       github.Ptr("c5def5"),
+	}
 )
 
+// AcknowledgementReaction is the reaction with which the bot marks comments that it has started responding to. Unlike
+// its other reactions, it doesn't mean that a comment has been handled, since the response may yet be interrupted
+const AcknowledgementReaction = "eyes"
+
+// WorkingLabelExpiry is how long the in-progress label may stay on an issue before it is considered to have been left
+// behind, e.g. by a process that crashed while working on the issue, so that the issue is worked on again. It is far
+// longer than tasks take, so that the lock of a live process isn't taken over
+const WorkingLabelExpiry = 6 * time.Hour
+
+// WorkingLabelAdded returns the event in which the in-progress label was added to an issue, given the issue's events, or
+// nil if the label isn't present
+func WorkingLabelAdded(events []*github.IssueEvent) *github.IssueEvent {
+	var last *github.IssueEvent
+	for _, event := range events {
+		isLabelChange := event.GetEvent() == "labeled" || event.GetEvent() == "unlabeled"
+		if isLabelChange && event.GetLabel().GetName() == LabelWorking.GetName() && event.GetID() > last.GetID() {
+			last = event
+		}
+	}
+	if last.GetEvent() != "labeled" {
+		return nil
+	}
+	return last
+}
+
 func convertIssue(issue *github.Issue) (GithubIssue, error) {
 	if issue == nil || issue.RepositoryURL == nil || issue.Number == nil || issue.Title == nil || issue.URL == nil {
 		return GithubIssue{}, fmt.Errorf("unexpected nil")
@@ -76,6 +128,10 @@ func convertIssue(issue *github.Issue) (GithubIssue, error) {
 		Body:  issue.GetBody(),
 		URL:   *issue.URL,
 
-		Labels: labels,
+		Labels:    labels,
+		UpdatedAt: issue.GetUpdatedAt().Time,
+
+		Author:            issue.GetUser().GetLogin(),
+		AuthorAssociation: issue.GetAuthorAssociation(),
 	}, nil
 }
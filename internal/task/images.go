@@ -0,0 +1,215 @@
+package task
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// maxImageBytes caps the size of each downloaded image. Anthropic's API rejects images larger than 5MB once they are
+	// base64-encoded
+	maxImageBytes = 3_750_000
+	// maxImages caps the number of images attached to a task, since each one adds to the cost of the conversation
+	maxImages = 5
+	// maxCachedImageBytes caps the total size of the images kept by an imageCache
+	maxCachedImageBytes = 50_000_000
+)
+
+// defaultImageHosts are the hosts that images are downloaded from: the ones that GitHub stores attachments on. Images
+// hosted elsewhere are skipped, so that an issue can't make the bot send requests to arbitrary hosts. An entry of the
+// form "*.example.com" allows subdomains
+var defaultImageHosts = []string{"github.com", "*.githubusercontent.com"}
+
+// supportedImageTypes are the media types of images that the AI can see
+var supportedImageTypes = []string{"image/png", "image/jpeg", "image/gif", "image/webp"}
+
+// imageRegex matches images embedded in markdown, either as ![alt](url) or as an HTML <img> tag, which GitHub uses for
+// pasted screenshots. The URL is captured by the first or second group, respectively
+var imageRegex = regexp.MustCompile(`!\[[^\]]*\]\(\s*<?(https?://[^\s)>]+)|(?i:<img\s[^>]*?\bsrc\s*=\s*["']?)(https?://[^"'\s>]+)`)
+
+// Image is an image embedded in an issue or comment, e.g. a screenshot
+type Image struct {
+	URL       string
+	MediaType string // One of supportedImageTypes
+	Data      []byte
+}
+
+// newImageClient returns a client for downloading images that refuses to follow redirects off the given hosts
+func newImageClient(hosts []string) *http.Client {
+	return &http.Client{
+		Timeout: 30 * time.Second,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= 10 {
+				return errors.New("stopped after 10 redirects")
+			}
+			if !hostAllowed(req.URL.Hostname(), hosts) {
+				return fmt.Errorf("redirected to host '%s', which is not an image host", req.URL.Hostname())
+			}
+			return nil
+		},
+	}
+}
+
+// setImageHosts changes the hosts that images may be downloaded from, including by redirects
+func (tb *builder) setImageHosts(hosts []string) {
+	tb.imageHosts = hosts
+	tb.imageClient = newImageClient(hosts)
+}
+
+// imageCache remembers downloaded images by URL, so that refreshing a task doesn't download the images embedded in its
+// issue again. Attachments on GitHub's image hosts never change, so images don't need to be invalidated. Safe for
+// concurrent use
+type imageCache struct {
+	mu     sync.Mutex
+	images map[string]Image
+	order  []string // URLs in the order they were added, for eviction
+	bytes  int      // The total size of the cached images
+}
+
+func newImageCache() *imageCache {
+	return &imageCache{images: map[string]Image{}}
+}
+
+// get returns the image cached for the given URL, if any
+func (ic *imageCache) get(url string) (Image, bool) {
+	ic.mu.Lock()
+	defer ic.mu.Unlock()
+
+	image, ok := ic.images[url]
+	return image, ok
+}
+
+// put caches the given image, evicting the oldest images until the cache is within maxCachedImageBytes
+func (ic *imageCache) put(image Image) {
+	ic.mu.Lock()
+	defer ic.mu.Unlock()
+
+	if _, ok := ic.images[image.URL]; ok {
+		return
+	}
+	ic.images[image.URL] = image
+	ic.order = append(ic.order, image.URL)
+	ic.bytes += len(image.Data)
+	for ic.bytes > maxCachedImageBytes {
+		ic.bytes -= len(ic.images[ic.order[0]].Data)
+		delete(ic.images, ic.order[0])
+		ic.order = ic.order[1:]
+	}
+}
+
+// findImageURLs returns the URLs of the images embedded in the given markdown bodies, in order of appearance and
+// without duplicates
+func findImageURLs(bodies ...string) []string {
+	var urls []string
+	for _, body := range bodies {
+		for _, match := range imageRegex.FindAllStringSubmatch(body, -1) {
+			u := match[1]
+			if u == "" {
+				u = match[2]
+			}
+			if !slices.Contains(urls, u) {
+				urls = append(urls, u)
+			}
+		}
+	}
+	return urls
+}
+
+// fetchImages downloads the images embedded in the given markdown bodies, up to maxImages of them. Images that are
+// hosted elsewhere than on the image hosts, can't be downloaded, are too large, or turn out not to be images are skipped
+func (tb builder) fetchImages(ctx context.Context, bodies ...string) []Image {
+	var images []Image
+	for _, u := range findImageURLs(bodies...) {
+		if len(images) == maxImages {
+			log.Printf("[taskgen] Warning: Skipping images after the first %d", maxImages)
+			break
+		}
+		if image, ok := tb.images.get(u); ok {
+			images = append(images, image)
+			continue
+		}
+		image, err := tb.fetchImage(ctx, u)
+		if err != nil {
+			log.Printf("[taskgen] Warning: Skipping image %s: %v", u, err)
+			continue
+		}
+		tb.images.put(image)
+		images = append(images, image)
+	}
+	return images
+}
+
+// fetchImage downloads the image at the given URL. Redirects are followed only within the image hosts
+func (tb builder) fetchImage(ctx context.Context, rawURL string) (Image, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return Image{}, fmt.Errorf("invalid URL: %w", err)
+	}
+	if !hostAllowed(u.Hostname(), tb.imageHosts) {
+		return Image{}, fmt.Errorf("host '%s' is not an image host", u.Hostname())
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return Image{}, fmt.Errorf("invalid URL: %w", err)
+	}
+	resp, err := tb.imageClient.Do(req)
+	if err != nil {
+		return Image{}, fmt.Errorf("failed to download: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return Image{}, fmt.Errorf("failed to download: %s", resp.Status)
+	}
+	if resp.ContentLength > maxImageBytes {
+		return Image{}, fmt.Errorf("image is %d bytes, which exceeds the limit of %d bytes", resp.ContentLength, maxImageBytes)
+	}
+	// Read one byte past the limit to detect oversized responses without a Content-Length
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxImageBytes+1))
+	if err != nil {
+		return Image{}, fmt.Errorf("failed to download: %w", err)
+	}
+	if len(data) > maxImageBytes {
+		return Image{}, fmt.Errorf("image exceeds the limit of %d bytes", maxImageBytes)
+	}
+
+	// Attachment hosts don't always report a useful content type, so fall back to sniffing it
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if !slices.Contains(supportedImageTypes, mediaType) {
+		mediaType, _, _ = mime.ParseMediaType(http.DetectContentType(data))
+	}
+	if !slices.Contains(supportedImageTypes, mediaType) {
+		return Image{}, fmt.Errorf("unsupported content type '%s'", mediaType)
+	}
+
+	return Image{URL: rawURL, MediaType: mediaType, Data: data}, nil
+}
+
+// hostAllowed returns true if the given host matches one of the given patterns. A pattern of the form "*.example.com"
+// matches subdomains of example.com
+func hostAllowed(host string, patterns []string) bool {
+	host = strings.ToLower(host)
+	for _, pattern := range patterns {
+		pattern = strings.ToLower(pattern)
+		if suffix, ok := strings.CutPrefix(pattern, "*."); ok {
+			if strings.HasSuffix(host, "."+suffix) {
+				return true
+			}
+		} else if host == pattern {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,152 @@
+package task
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/cchalm/blundering-savant/internal/vcs"
+	"github.com/google/go-github/v72/github"
+	"github.com/stretchr/testify/require"
+)
+
+// pngData is the start of a PNG file, enough for its content type to be detected
+var pngData = []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR")
+
+func TestFindImageURLs(t *testing.T) {
+	issueBody := "It crashes:\n\n![screenshot](https://github.com/user-attachments/assets/1 \"Crash\")\n\n" +
+		`<img width="600" alt="dialog" src="https://github.com/user-attachments/assets/2" />` + "\n\n" +
+		"See [the docs](https://example.com/docs.png) for details"
+	commentBody := "Same here ![again](https://github.com/user-attachments/assets/1) and ![other](<https://example.com/3.png>)"
+
+	require.Equal(t, []string{
+		"https://github.com/user-attachments/assets/1",
+		"https://github.com/user-attachments/assets/2",
+		"https://example.com/3.png",
+	}, findImageURLs(issueBody, commentBody))
+}
+
+// newImageBuilder returns a builder whose images are served by a fake server, which it may download images from.
+// Returns the base URL of the server
+func newImageBuilder(t *testing.T) (builder, string) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/screenshot":
+			// Served without a useful content type, like some attachment hosts do
+			w.Header().Set("Content-Type", "application/octet-stream")
+			_, _ = w.Write(pngData)
+		case "/photo.jpg":
+			w.Header().Set("Content-Type", "image/jpeg")
+			_, _ = w.Write([]byte("\xff\xd8\xff\xe0"))
+		case "/huge.png":
+			w.Header().Set("Content-Type", "image/png")
+			_, _ = w.Write(append(pngData, bytes.Repeat([]byte{0}, maxImageBytes)...))
+		case "/doc.pdf":
+			w.Header().Set("Content-Type", "application/pdf")
+			_, _ = w.Write([]byte("%PDF-1.7"))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	serverURL, err := url.Parse(server.URL)
+	require.NoError(t, err)
+
+	tb := NewBuilder(vcs.NewGithubProvider(github.NewClient(nil)), &github.User{Login: github.Ptr("bot")}, CommandConfig{})
+	tb.setImageHosts([]string{serverURL.Hostname()})
+	return tb, server.URL
+}
+
+func TestFetchImages_ImagesDownloaded(t *testing.T) {
+	tb, base := newImageBuilder(t)
+
+	images := tb.fetchImages(context.Background(),
+		"![screenshot]("+base+"/screenshot)", `<img src="`+base+`/photo.jpg">`)
+	require.Equal(t, []Image{
+		{URL: base + "/screenshot", MediaType: "image/png", Data: pngData},
+		{URL: base + "/photo.jpg", MediaType: "image/jpeg", Data: []byte("\xff\xd8\xff\xe0")},
+	}, images)
+}
+
+func TestFetchImages_UnsuitableImagesSkipped(t *testing.T) {
+	tb, base := newImageBuilder(t)
+
+	images := tb.fetchImages(context.Background(), "![big]("+base+"/huge.png)\n"+
+		"![pdf]("+base+"/doc.pdf)\n"+
+		"![gone]("+base+"/missing.png)\n"+
+		"![elsewhere](https://example.com/screenshot.png)\n"+
+		"![screenshot]("+base+"/screenshot)")
+	require.Len(t, images, 1, "only the screenshot is a small enough image on an image host")
+	require.Equal(t, base+"/screenshot", images[0].URL)
+}
+
+func TestFetchImages_Capped(t *testing.T) {
+	tb, base := newImageBuilder(t)
+
+	var body string
+	for i := range maxImages + 2 {
+		body += "![screenshot](" + base + "/screenshot?n=" + string(rune('a'+i)) + ")\n"
+	}
+	require.Len(t, tb.fetchImages(context.Background(), body), maxImages)
+}
+
+func TestFetchImages_RedirectsStayOnImageHosts(t *testing.T) {
+	tb, base := newImageBuilder(t)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/within":
+			http.Redirect(w, r, base+"/screenshot", http.StatusFound)
+		case "/away":
+			// Same server, but by a name that isn't an image host
+			http.Redirect(w, r, strings.Replace(base, "127.0.0.1", "localhost", 1)+"/screenshot", http.StatusFound)
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	images := tb.fetchImages(context.Background(), "![a]("+server.URL+"/within)\n![b]("+server.URL+"/away)")
+	require.Len(t, images, 1)
+	require.Equal(t, server.URL+"/within", images[0].URL)
+}
+
+func TestFetchImages_Cached(t *testing.T) {
+	var fetches atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches.Add(1)
+		w.Header().Set("Content-Type", "image/png")
+		_, _ = w.Write(pngData)
+	}))
+	t.Cleanup(server.Close)
+	serverURL, err := url.Parse(server.URL)
+	require.NoError(t, err)
+
+	tb := NewBuilder(vcs.NewGithubProvider(github.NewClient(nil)), &github.User{Login: github.Ptr("bot")}, CommandConfig{})
+	tb.setImageHosts([]string{serverURL.Hostname()})
+
+	body := "![screenshot](" + server.URL + "/screenshot)"
+	first := tb.fetchImages(context.Background(), body)
+	second := tb.fetchImages(context.Background(), body)
+	require.Len(t, first, 1)
+	require.Equal(t, first, second)
+	require.Equal(t, int32(1), fetches.Load(), "the image should be downloaded only once")
+}
+
+func TestImageCache_EvictsOldestImages(t *testing.T) {
+	cache := newImageCache()
+	large := bytes.Repeat([]byte{0}, maxCachedImageBytes/2)
+	cache.put(Image{URL: "a", Data: large})
+	cache.put(Image{URL: "b", Data: large})
+	cache.put(Image{URL: "c", Data: pngData})
+
+	_, ok := cache.get("a")
+	require.False(t, ok)
+	_, ok = cache.get("b")
+	require.True(t, ok)
+	_, ok = cache.get("c")
+	require.True(t, ok)
+}
@@ -0,0 +1,69 @@
+package task
+
+import (
+	"regexp"
+	"strings"
+)
+
+// issueFormNoResponse is what GitHub renders for optional issue form fields that were left empty
+const issueFormNoResponse = "_No response_"
+
+var (
+	// issueFormHeadingRegex matches the heading that GitHub renders for each field of an issue form
+	issueFormHeadingRegex = regexp.MustCompile(`^###\s+(.+?)\s*$`)
+	// codeFenceRegex matches the start or end of a fenced code block, which GitHub renders for issue form fields that
+	// have a render attribute
+	codeFenceRegex = regexp.MustCompile("^\\s*(```|~~~)")
+)
+
+// IssueFormField is a field of an issue that was submitted with a GitHub issue form
+type IssueFormField struct {
+	Label string
+	Value string
+}
+
+// parseIssueForm extracts the fields of an issue body that was rendered from a GitHub issue form, which puts each field
+// under a level-3 heading with its label. Fields that were left empty are omitted. Returns nil if the body doesn't look
+// like an issue form, e.g. because it has text before the first heading
+func parseIssueForm(body string) []IssueFormField {
+	lines := strings.Split(strings.ReplaceAll(body, "\r\n", "\n"), "\n")
+
+	var (
+		fields  []IssueFormField
+		current *IssueFormField
+		value   []string
+		inFence bool
+	)
+	flush := func() {
+		if current == nil {
+			return
+		}
+		current.Value = strings.TrimSpace(strings.Join(value, "\n"))
+		if current.Value != "" && current.Value != issueFormNoResponse {
+			fields = append(fields, *current)
+		}
+		value = nil
+	}
+
+	for _, line := range lines {
+		if codeFenceRegex.MatchString(line) {
+			inFence = !inFence
+		}
+		if match := issueFormHeadingRegex.FindStringSubmatch(line); match != nil && !inFence {
+			flush()
+			current = &IssueFormField{Label: match[1]}
+			continue
+		}
+		if current == nil {
+			if strings.TrimSpace(line) != "" {
+				// Free-form text before the first heading
+				return nil
+			}
+			continue
+		}
+		value = append(value, line)
+	}
+	flush()
+
+	return fields
+}
@@ -0,0 +1,27 @@
+package task
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseIssueForm_FormBody(t *testing.T) {
+	body := "### Version\r\n\r\nv1.2.3\r\n\r\n### What happened?\r\n\r\nIt crashed.\r\n\r\nTwice.\r\n\r\n" +
+		"### Logs\r\n\r\n```shell\r\n### not a heading\r\npanic: oops\r\n```\r\n\r\n### Anything else?\r\n\r\n_No response_"
+
+	require.Equal(t, []IssueFormField{
+		{Label: "Version", Value: "v1.2.3"},
+		{Label: "What happened?", Value: "It crashed.\n\nTwice."},
+		{Label: "Logs", Value: "```shell\n### not a heading\npanic: oops\n```"},
+	}, parseIssueForm(body))
+}
+
+func TestParseIssueForm_PlainBody(t *testing.T) {
+	body := "The parser crashes on empty input.\n\n### Steps to reproduce\n\nRun `parse \"\"`"
+	require.Nil(t, parseIssueForm(body))
+}
+
+func TestParseIssueForm_EmptyBody(t *testing.T) {
+	require.Nil(t, parseIssueForm(""))
+}
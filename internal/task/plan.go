@@ -0,0 +1,97 @@
+package task
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/google/go-github/v72/github"
+)
+
+const (
+	// PlanCommentHeading starts the comments in which the bot proposes a plan, which is how they are told apart from
+	// its other comments
+	PlanCommentHeading = "## 📋 Plan"
+	// PlanApprovalReaction is the reaction with which a human approves a plan
+	PlanApprovalReaction = "+1"
+)
+
+// PlanState is the state of the plan that the bot must have approved before changing code on an issue in plan-first
+// mode. See RepoConfig.PlanFirst
+type PlanState struct {
+	// Required is true if the issue is in plan-first mode and doesn't have a pull request yet. Once a pull request is
+	// open, the work is underway and plans are no longer required
+	Required bool
+	// Comment is the bot's latest plan for the issue, or nil if it hasn't proposed one yet
+	Comment *github.IssueComment
+	// Approved is true if a human who may give the bot commands has reacted to Comment with PlanApprovalReaction
+	Approved bool
+	// Started is true if the bot has reacted to Comment, which it does when it starts work on the approved plan
+	Started bool
+}
+
+// getPlanState looks up the state of the plan for the given task, whose issue comments must already be populated.
+// Returns the zero value if the issue isn't in plan-first mode
+func (tb builder) getPlanState(ctx context.Context, tsk Task) (PlanState, error) {
+	if tsk.PullRequest != nil || !tsk.RepoConfig.PlanFirst.Applies(tsk.Issue) {
+		return PlanState{}, nil
+	}
+
+	state := PlanState{Required: true}
+	for _, comment := range slices.Backward(tsk.IssueComments) {
+		if tb.isBotComment(comment.User, tb.githubUser) && strings.HasPrefix(comment.GetBody(), PlanCommentHeading) {
+			state.Comment = comment
+			break
+		}
+	}
+	if state.Comment == nil {
+		return state, nil
+	}
+	// The comment's reaction rollup saves a lookup when nobody has reacted
+	if state.Comment.Reactions != nil && state.Comment.Reactions.GetTotalCount() == 0 {
+		return state, nil
+	}
+
+	owner, repo := tsk.Issue.Owner, tsk.Issue.Repo
+	reactions, err := tb.vcs.ListCommentReactions(ctx, owner, repo, state.Comment.GetID())
+	if err != nil {
+		return PlanState{}, fmt.Errorf("failed to list reactions to plan comment %d: %w", state.Comment.GetID(), err)
+	}
+	for _, reaction := range reactions {
+		login := reaction.GetUser().GetLogin()
+		if login == tb.githubUser.GetLogin() {
+			state.Started = true
+		} else if reaction.GetContent() == PlanApprovalReaction && !state.Approved {
+			state.Approved, err = tb.mayApprovePlans(ctx, owner, repo, login)
+			if err != nil {
+				return PlanState{}, fmt.Errorf("failed to check whether @%s may approve plans: %w", login, err)
+			}
+		}
+	}
+	return state, nil
+}
+
+// mayApprovePlans returns true if the user with the given login may approve the bot's plans in the given repository:
+// the same users whose comment commands are obeyed. Reactions don't say how the user is associated with the repository,
+// so unless AllowedUsers limits who may command the bot, this is looked up. The repository's owner and collaborators
+// have write access to it, while members of its owning organization may only be able to read it
+func (tb builder) mayApprovePlans(ctx context.Context, owner string, repo string, login string) (bool, error) {
+	if len(tb.commands.AllowedUsers) > 0 {
+		return slices.Contains(tb.commands.AllowedUsers, login), nil
+	}
+
+	permission, err := tb.vcs.GetPermissionLevel(ctx, owner, repo, login)
+	if err != nil {
+		return false, fmt.Errorf("failed to get permission level: %w", err)
+	}
+	if slices.Contains([]string{"admin", "maintain", "write"}, permission) {
+		return true, nil
+	}
+	// Organization members may only have read access to the repository. Repositories owned by users have no members
+	member, err := tb.vcs.IsOrganizationMember(ctx, owner, login)
+	if err != nil {
+		return false, fmt.Errorf("failed to check organization membership: %w", err)
+	}
+	return member, nil
+}
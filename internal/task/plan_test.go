@@ -0,0 +1,119 @@
+package task
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/cchalm/blundering-savant/internal/vcs"
+	"github.com/google/go-github/v72/github"
+	"github.com/stretchr/testify/require"
+)
+
+// planComments are the comments on an issue in plan-first mode: an outdated plan, a human's reply, and the bot's
+// latest plan, in that order
+func planComments() []*github.IssueComment {
+	return []*github.IssueComment{
+		{ID: github.Ptr(int64(1)), User: &github.User{Login: github.Ptr("bot")}, Body: github.Ptr(PlanCommentHeading + "\n\nFirst try")},
+		{ID: github.Ptr(int64(2)), User: &github.User{Login: github.Ptr("alice")}, Body: github.Ptr("Use a map instead")},
+		{ID: github.Ptr(int64(3)), User: &github.User{Login: github.Ptr("bot")}, Body: github.Ptr(PlanCommentHeading + "\n\nUse a map")},
+		{ID: github.Ptr(int64(4)), User: &github.User{Login: github.Ptr("bot")}, Body: github.Ptr("Some other comment")},
+	}
+}
+
+// testGetPlanState looks up the plan state of a task with the given comments and pull request, in a repository that
+// puts issues labeled "large" in plan-first mode. The fake server responds to reaction lookups on the latest plan
+// comment with the given reactions JSON. alice is a collaborator with write access, carol is a member of the owning
+// organization, and everyone else can only read the repository
+func testGetPlanState(t *testing.T, commands CommandConfig, reactionsJSON string, comments []*github.IssueComment, pr *GithubPullRequest) PlanState {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case r.URL.Path == "/repos/owner/repo/issues/comments/3/reactions":
+			_, _ = w.Write([]byte(reactionsJSON))
+		case r.URL.Path == "/repos/owner/repo/collaborators/alice/permission":
+			_, _ = w.Write([]byte(`{"permission": "write"}`))
+		case strings.HasPrefix(r.URL.Path, "/repos/owner/repo/collaborators/"):
+			_, _ = w.Write([]byte(`{"permission": "read"}`))
+		case r.URL.Path == "/orgs/owner/members/carol":
+			w.WriteHeader(http.StatusNoContent)
+		case strings.HasPrefix(r.URL.Path, "/orgs/owner/members/"):
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte(`{"message": "Not Found"}`))
+		default:
+			t.Errorf("unexpected request: %s", r.URL.Path)
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	client := github.NewClient(nil)
+	baseURL, err := url.Parse(server.URL + "/")
+	require.NoError(t, err)
+	client.BaseURL = baseURL
+
+	tb := NewBuilder(vcs.NewGithubProvider(client), &github.User{Login: github.Ptr("bot")}, commands)
+	state, err := tb.getPlanState(context.Background(), Task{
+		Issue:         GithubIssue{Owner: "owner", Repo: "repo", Number: 7, Labels: []string{"large"}},
+		PullRequest:   pr,
+		RepoConfig:    RepoConfig{PlanFirst: PlanFirstConfig{Labels: []string{"large"}}},
+		IssueComments: comments,
+	})
+	require.NoError(t, err)
+	return state
+}
+
+func TestGetPlanState_NoPlanYet(t *testing.T) {
+	state := testGetPlanState(t, CommandConfig{}, `[]`, nil, nil)
+	require.Equal(t, PlanState{Required: true}, state)
+}
+
+func TestGetPlanState_PullRequestOpen(t *testing.T) {
+	state := testGetPlanState(t, CommandConfig{}, `[]`, planComments(), &GithubPullRequest{Number: 12})
+	require.Equal(t, PlanState{}, state)
+}
+
+func TestGetPlanState_NotApproved(t *testing.T) {
+	state := testGetPlanState(t, CommandConfig{}, `[{"content": "eyes", "user": {"login": "alice"}}]`, planComments(), nil)
+	require.True(t, state.Required)
+	require.Equal(t, int64(3), state.Comment.GetID(), "the latest plan should be found")
+	require.False(t, state.Approved)
+	require.False(t, state.Started)
+}
+
+func TestGetPlanState_Approved(t *testing.T) {
+	state := testGetPlanState(t, CommandConfig{}, `[{"content": "+1", "user": {"login": "alice"}}]`, planComments(), nil)
+	require.True(t, state.Approved)
+	require.False(t, state.Started)
+}
+
+func TestGetPlanState_ApprovalAcknowledged(t *testing.T) {
+	state := testGetPlanState(t, CommandConfig{},
+		`[{"content": "+1", "user": {"login": "alice"}}, {"content": "eyes", "user": {"login": "bot"}}]`, planComments(), nil)
+	require.True(t, state.Approved)
+	require.True(t, state.Started)
+}
+
+func TestGetPlanState_BotCannotApprove(t *testing.T) {
+	state := testGetPlanState(t, CommandConfig{}, `[{"content": "+1", "user": {"login": "bot"}}]`, planComments(), nil)
+	require.False(t, state.Approved)
+}
+
+func TestGetPlanState_UntrustedUserCannotApprove(t *testing.T) {
+	state := testGetPlanState(t, CommandConfig{}, `[{"content": "+1", "user": {"login": "mallory"}}]`, planComments(), nil)
+	require.False(t, state.Approved)
+
+	state = testGetPlanState(t, CommandConfig{}, `[{"content": "+1", "user": {"login": "carol"}}]`, planComments(), nil)
+	require.True(t, state.Approved, "organization members may approve plans")
+}
+
+func TestGetPlanState_ApproverNotAllowed(t *testing.T) {
+	commands := CommandConfig{AllowedUsers: []string{"bob"}}
+	state := testGetPlanState(t, commands, `[{"content": "+1", "user": {"login": "alice"}}]`, planComments(), nil)
+	require.False(t, state.Approved)
+
+	state = testGetPlanState(t, commands, `[{"content": "+1", "user": {"login": "bob"}}]`, planComments(), nil)
+	require.True(t, state.Approved)
+}
@@ -0,0 +1,66 @@
+package task
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// DefaultProtectedPaths are the paths that the bot may not write to unless the operator or repository says otherwise:
+// vendored dependencies and generated code, which are almost never meant to be edited by hand
+var DefaultProtectedPaths = ProtectedPaths{"**/vendor/**", "**/node_modules/**", "**/*.pb.go"}
+
+// ProtectedPaths lists patterns of paths, relative to the repository root, that the bot may not write to. Each element
+// of a pattern is matched against the corresponding element of a path as in path.Match, except that a "**" element
+// matches any number of path elements, including none. E.g. "**/vendor/**" matches both "vendor/a.go" and
+// "lib/vendor/b/c.go"
+type ProtectedPaths []string
+
+// NewProtectedPaths creates a list of protected paths from the given patterns. Returns an error if any pattern is
+// malformed
+func NewProtectedPaths(patterns []string) (ProtectedPaths, error) {
+	protected := make(ProtectedPaths, 0, len(patterns))
+	for _, pattern := range patterns {
+		if pattern == "" || strings.HasPrefix(pattern, "/") {
+			return nil, fmt.Errorf("'%s' is not a relative path pattern", pattern)
+		}
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("'%s' is not a valid pattern: %w", pattern, err)
+		}
+		protected = append(protected, pattern)
+	}
+	return protected, nil
+}
+
+// Match returns the first pattern that the given path matches, and true, or false if the path isn't protected
+func (pp ProtectedPaths) Match(p string) (string, bool) {
+	elems := strings.Split(strings.TrimPrefix(path.Clean(p), "/"), "/")
+	for _, pattern := range pp {
+		if matchPathElems(strings.Split(pattern, "/"), elems) {
+			return pattern, true
+		}
+	}
+	return "", false
+}
+
+// matchPathElems returns true if the given path elements match the given pattern elements. See ProtectedPaths
+func matchPathElems(pattern []string, elems []string) bool {
+	if len(pattern) == 0 {
+		return len(elems) == 0
+	}
+	if pattern[0] == "**" {
+		for i := range len(elems) + 1 {
+			if matchPathElems(pattern[1:], elems[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+	if len(elems) == 0 {
+		return false
+	}
+	if ok, _ := path.Match(pattern[0], elems[0]); !ok {
+		return false
+	}
+	return matchPathElems(pattern[1:], elems[1:])
+}
@@ -0,0 +1,48 @@
+package task
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestProtectedPaths_Match(t *testing.T) {
+	protected, err := NewProtectedPaths([]string{"**/vendor/**", "docs/*.gen.md"})
+	require.NoError(t, err)
+
+	pattern, ok := protected.Match("vendor/github.com/a/b.go")
+	require.True(t, ok)
+	require.Equal(t, "**/vendor/**", pattern)
+
+	_, ok = protected.Match("lib/vendor/c.go")
+	require.True(t, ok)
+	_, ok = protected.Match("./vendor/../vendor/c.go")
+	require.True(t, ok, "paths should be cleaned before matching")
+	_, ok = protected.Match("docs/api.gen.md")
+	require.True(t, ok)
+
+	_, ok = protected.Match("vendorized/c.go")
+	require.False(t, ok)
+	_, ok = protected.Match("docs/guide/api.gen.md")
+	require.False(t, ok, "'*' should not match across directories")
+	_, ok = protected.Match("main.go")
+	require.False(t, ok)
+}
+
+func TestDefaultProtectedPaths(t *testing.T) {
+	for _, p := range []string{"vendor/golang.org/x/sync/errgroup/errgroup.go", "web/node_modules/react/index.js", "api/v1/service.pb.go"} {
+		_, ok := DefaultProtectedPaths.Match(p)
+		require.True(t, ok, p)
+	}
+	for _, p := range []string{"main.go", "internal/vendors/vendors.go", "api/v1/service.proto"} {
+		_, ok := DefaultProtectedPaths.Match(p)
+		require.False(t, ok, p)
+	}
+}
+
+func TestNewProtectedPaths_Malformed(t *testing.T) {
+	for _, pattern := range []string{"", "/vendor/**", "vendor/[a"} {
+		_, err := NewProtectedPaths([]string{pattern})
+		require.Error(t, err, pattern)
+	}
+}
@@ -0,0 +1,100 @@
+package task
+
+import (
+	"context"
+	"log"
+	"sync"
+
+	"golang.org/x/sync/errgroup"
+)
+
+// repoInfoCache remembers the repository-level information fetched for each repository, e.g. its style guides and file
+// tree, so that tasks for the same repository reuse it until the repository's default branch moves. Only the
+// information for the latest commit of each repository is kept. Safe for concurrent use
+type repoInfoCache struct {
+	mu      sync.Mutex
+	entries map[string]repoInfo // Keyed by owner/repo
+}
+
+// repoInfo is the repository-level information fetched at a single commit
+type repoInfo struct {
+	sha          string
+	styleGuide   *StyleGuide
+	codebaseInfo *CodebaseInfo
+}
+
+func newRepoInfoCache() *repoInfoCache {
+	return &repoInfoCache{entries: map[string]repoInfo{}}
+}
+
+// get returns the information cached for the given repository, if it was fetched at the given commit
+func (c *repoInfoCache) get(owner, repo, sha string) (repoInfo, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	info, ok := c.entries[owner+"/"+repo]
+	if !ok || info.sha != sha {
+		return repoInfo{}, false
+	}
+	return info, true
+}
+
+// put caches the given information for the given repository, replacing whatever was cached for an earlier commit
+func (c *repoInfoCache) put(owner, repo string, info repoInfo) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.entries[owner+"/"+repo] = info
+}
+
+// getRepoInfo returns the style guides and codebase info of the given repository as of the head of its default branch.
+// Reuses the information fetched for an earlier task if the branch hasn't moved since. The style guides may be nil if
+// none could be fetched, and the codebase info may be partial. Incomplete information is not cached
+func (tb builder) getRepoInfo(ctx context.Context, owner, repo, defaultBranch string) (*StyleGuide, *CodebaseInfo) {
+	// Fall back to fetching whatever the repository's HEAD is, without caching, if the branch's head is unknown
+	ref := "HEAD"
+	var head string
+	err := tb.fetches.do(ctx, func() error {
+		var err error
+		head, err = tb.vcs.GetBranchHead(ctx, owner, repo, defaultBranch)
+		return err
+	})
+	if err != nil {
+		log.Printf("[taskgen] Warning: Could not get the head of branch %s: %v", defaultBranch, err)
+	} else {
+		ref = head
+		if info, ok := tb.repoInfo.get(owner, repo, ref); ok {
+			return info.styleGuide, info.codebaseInfo
+		}
+	}
+
+	var (
+		styleGuide   *StyleGuide
+		codebaseInfo *CodebaseInfo
+		codebaseErr  error
+		g            errgroup.Group
+	)
+	g.Go(func() error {
+		var err error
+		styleGuide, err = tb.findStyleGuides(ctx, owner, repo, ref)
+		if err != nil {
+			log.Printf("[taskgen] Warning: Could not find style guides: %v", err)
+		}
+		return nil
+	})
+	g.Go(func() error {
+		codebaseInfo, codebaseErr = tb.analyzeCodebase(ctx, owner, repo, ref)
+		if codebaseErr != nil {
+			log.Printf("[taskgen] Warning: Could not analyze codebase: %v", codebaseErr)
+		}
+		return nil
+	})
+	_ = g.Wait()
+
+	if codebaseErr == nil && ref != "HEAD" {
+		// Don't cache failures, so that the next task tries again
+		tb.repoInfo.put(owner, repo, repoInfo{sha: ref, styleGuide: styleGuide, codebaseInfo: codebaseInfo})
+	}
+
+	return styleGuide, codebaseInfo
+}
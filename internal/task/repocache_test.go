@@ -0,0 +1,120 @@
+package task
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/cchalm/blundering-savant/internal/vcs"
+	"github.com/google/go-github/v72/github"
+	"github.com/stretchr/testify/require"
+)
+
+// newRepoInfoBuilder returns a builder backed by a fake GitHub server for a Go repository whose default branch, "main",
+// is at the commit that *sha points to. The returned counter tracks the requests that fetch repository-level
+// information, i.e. everything but the branch lookup
+func newRepoInfoBuilder(t *testing.T, sha *string) (builder, *atomic.Int32) {
+	var fetches atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Path == "/repos/owner/repo/branches/main" {
+			_, _ = fmt.Fprintf(w, `{"name": "main", "commit": {"sha": "%s"}}`, *sha)
+			return
+		}
+
+		fetches.Add(1)
+		switch {
+		case r.URL.Path == "/repos/owner/repo/languages":
+			_, _ = w.Write([]byte(`{"Go": 1000, "Shell": 10}`))
+		case strings.HasPrefix(r.URL.Path, "/repos/owner/repo/git/trees/"):
+			require.Equal(t, "/repos/owner/repo/git/trees/"+*sha, r.URL.Path, "the tree should be fetched at the cached commit")
+			_, _ = w.Write([]byte(`{"sha": "tree", "tree": [{"path": "main.go", "type": "blob"}]}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte(`{"message": "Not Found"}`))
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	client := github.NewClient(nil)
+	baseURL, err := url.Parse(server.URL + "/")
+	require.NoError(t, err)
+	client.BaseURL = baseURL
+
+	return NewBuilder(vcs.NewGithubProvider(client), &github.User{Login: github.Ptr("bot")}, CommandConfig{}), &fetches
+}
+
+func TestGetRepoInfo_SameCommitFetchedOnce(t *testing.T) {
+	sha := "abc123"
+	tb, fetches := newRepoInfoBuilder(t, &sha)
+
+	_, first := tb.getRepoInfo(context.Background(), "owner", "repo", "main")
+	require.NotNil(t, first)
+	require.Equal(t, "Go", first.MainLanguage)
+	require.Equal(t, []string{"main.go"}, first.FileTree)
+	fetched := fetches.Load()
+
+	// Another task for the same repository
+	_, second := tb.getRepoInfo(context.Background(), "owner", "repo", "main")
+	require.Equal(t, first, second)
+	require.Equal(t, fetched, fetches.Load(), "nothing should be fetched again")
+}
+
+func TestGetRepoInfo_FailureNotCached(t *testing.T) {
+	var treeRequests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case r.URL.Path == "/repos/owner/repo/branches/main":
+			_, _ = w.Write([]byte(`{"name": "main", "commit": {"sha": "abc123"}}`))
+		case r.URL.Path == "/repos/owner/repo/languages":
+			_, _ = w.Write([]byte(`{"Go": 1000}`))
+		case strings.HasPrefix(r.URL.Path, "/repos/owner/repo/git/trees/"):
+			// The first attempt fails, later ones succeed
+			if treeRequests.Add(1) == 1 {
+				w.WriteHeader(http.StatusInternalServerError)
+				_, _ = w.Write([]byte(`{"message": "Server Error"}`))
+				return
+			}
+			_, _ = w.Write([]byte(`{"sha": "tree", "tree": [{"path": "main.go", "type": "blob"}]}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte(`{"message": "Not Found"}`))
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	client := github.NewClient(nil)
+	baseURL, err := url.Parse(server.URL + "/")
+	require.NoError(t, err)
+	client.BaseURL = baseURL
+	tb := NewBuilder(vcs.NewGithubProvider(client), &github.User{Login: github.Ptr("bot")}, CommandConfig{})
+
+	_, info := tb.getRepoInfo(context.Background(), "owner", "repo", "main")
+	require.Empty(t, info.FileTree)
+	require.Equal(t, "Go", info.MainLanguage, "the rest of the information should still be used")
+
+	// The next task fetches the tree again, rather than reusing an empty one
+	_, info = tb.getRepoInfo(context.Background(), "owner", "repo", "main")
+	require.NotNil(t, info)
+	require.Equal(t, []string{"main.go"}, info.FileTree)
+	require.Equal(t, int32(2), treeRequests.Load())
+}
+
+func TestGetRepoInfo_NewCommitFetchedAgain(t *testing.T) {
+	sha := "abc123"
+	tb, fetches := newRepoInfoBuilder(t, &sha)
+
+	tb.getRepoInfo(context.Background(), "owner", "repo", "main")
+	fetched := fetches.Load()
+
+	sha = "def456"
+	_, info := tb.getRepoInfo(context.Background(), "owner", "repo", "main")
+	require.NotNil(t, info)
+	require.Equal(t, 2*fetched, fetches.Load(), "everything should be fetched again")
+}
@@ -0,0 +1,95 @@
+package task
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"unicode/utf8"
+
+	"github.com/cchalm/blundering-savant/internal/vcs"
+	"gopkg.in/yaml.v3"
+)
+
+// RepoConfigPath is the path, relative to the repository root, of the file in which a repository configures the bot
+const RepoConfigPath = ".blundering-savant.yml"
+
+// RepoConfig holds settings that a repository chooses for itself, read from RepoConfigPath on its default branch. The
+// zero value of each field selects the operator's default
+type RepoConfig struct {
+	// ValidationWorkflow is the name of the workflow that validates the bot's changes, e.g. "validate.yml"
+	ValidationWorkflow string `yaml:"validation_workflow"`
+	// Reviewers are the GitHub users whose review is requested on pull requests that the bot opens
+	Reviewers []string `yaml:"reviewers"`
+	// MaxPRLines is the number of changed lines that the bot should keep each pull request under. Zero means no limit
+	MaxPRLines int `yaml:"max_pr_lines"`
+	// MaxFileBytes overrides the operator's limit on the size of files that the bot may write
+	MaxFileBytes int64 `yaml:"max_file_bytes"`
+	// PlanFirst selects issues on which the bot must have a plan approved before it changes any code
+	PlanFirst PlanFirstConfig `yaml:"plan_first"`
+	// ProtectedPaths overrides the operator's patterns of paths that the bot may not write to. Unlike the other fields,
+	// an empty list doesn't select the default, but protects nothing
+	ProtectedPaths ProtectedPaths `yaml:"protected_paths"`
+}
+
+// PlanFirstConfig selects the issues that are in plan-first mode, in which the bot proposes an approach in a comment and
+// waits for a human to approve it before changing any code. An issue that matches any of the criteria is in plan-first
+// mode. The zero value selects no issues
+type PlanFirstConfig struct {
+	// Labels put issues with any of these labels in plan-first mode
+	Labels []string `yaml:"labels"`
+	// MinIssueLength puts issues whose descriptions are at least this many characters long in plan-first mode. Zero
+	// means no limit
+	MinIssueLength int `yaml:"min_issue_length"`
+}
+
+// Applies returns true if the given issue is in plan-first mode
+func (pfc PlanFirstConfig) Applies(issue GithubIssue) bool {
+	if slices.ContainsFunc(issue.Labels, func(label string) bool { return slices.Contains(pfc.Labels, label) }) {
+		return true
+	}
+	return pfc.MinIssueLength > 0 && utf8.RuneCountInString(issue.Body) >= pfc.MinIssueLength
+}
+
+// ParseRepoConfig parses the contents of a repository's config file
+func ParseRepoConfig(content []byte) (RepoConfig, error) {
+	var config RepoConfig
+	if err := yaml.Unmarshal(content, &config); err != nil {
+		return RepoConfig{}, fmt.Errorf("failed to parse %s: %w", RepoConfigPath, err)
+	}
+	if config.MaxPRLines < 0 {
+		return RepoConfig{}, fmt.Errorf("failed to parse %s: max_pr_lines must not be negative", RepoConfigPath)
+	}
+	if config.MaxFileBytes < 0 {
+		return RepoConfig{}, fmt.Errorf("failed to parse %s: max_file_bytes must not be negative", RepoConfigPath)
+	}
+	if config.PlanFirst.MinIssueLength < 0 {
+		return RepoConfig{}, fmt.Errorf("failed to parse %s: plan_first.min_issue_length must not be negative", RepoConfigPath)
+	}
+	if config.ProtectedPaths != nil {
+		if _, err := NewProtectedPaths(config.ProtectedPaths); err != nil {
+			return RepoConfig{}, fmt.Errorf("failed to parse %s: protected_paths: %w", RepoConfigPath, err)
+		}
+	}
+	return config, nil
+}
+
+// loadRepoConfig reads the repository's config file. A missing file selects the defaults, as does a malformed one,
+// with a warning, so that a bad edit to the file doesn't stop the bot from working on the repository
+func (tb builder) loadRepoConfig(ctx context.Context, owner string, repo string) RepoConfig {
+	content, err := tb.vcs.GetFileContents(ctx, owner, repo, RepoConfigPath, "")
+	if errors.Is(err, vcs.ErrNotFound) {
+		return RepoConfig{}
+	} else if err != nil {
+		log.Printf("[taskgen] Warning: Could not fetch %s from %s/%s, using defaults: %v", RepoConfigPath, owner, repo, err)
+		return RepoConfig{}
+	}
+
+	config, err := ParseRepoConfig([]byte(content))
+	if err != nil {
+		log.Printf("[taskgen] Warning: Invalid config in %s/%s, using defaults: %v", owner, repo, err)
+		return RepoConfig{}
+	}
+	return config
+}
@@ -0,0 +1,132 @@
+package task
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/cchalm/blundering-savant/internal/vcs"
+	"github.com/google/go-github/v72/github"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseRepoConfig_AllFields(t *testing.T) {
+	config, err := ParseRepoConfig([]byte(`
+validation_workflow: validate.yml
+reviewers:
+  - alice
+  - bob
+max_pr_lines: 400
+max_file_bytes: 200000
+plan_first:
+  labels: [large]
+  min_issue_length: 2000
+protected_paths: ["**/*_gen.go"]
+`))
+	require.NoError(t, err)
+	require.Equal(t, RepoConfig{
+		ValidationWorkflow: "validate.yml",
+		Reviewers:          []string{"alice", "bob"},
+		MaxPRLines:         400,
+		MaxFileBytes:       200000,
+		PlanFirst:          PlanFirstConfig{Labels: []string{"large"}, MinIssueLength: 2000},
+		ProtectedPaths:     ProtectedPaths{"**/*_gen.go"},
+	}, config)
+}
+
+func TestParseRepoConfig_Empty(t *testing.T) {
+	config, err := ParseRepoConfig(nil)
+	require.NoError(t, err)
+	require.Equal(t, RepoConfig{}, config)
+}
+
+func TestParseRepoConfig_InvalidYAML(t *testing.T) {
+	_, err := ParseRepoConfig([]byte("reviewers: [alice\nmax_pr_lines: 400"))
+	require.Error(t, err)
+}
+
+func TestParseRepoConfig_WrongType(t *testing.T) {
+	_, err := ParseRepoConfig([]byte("max_pr_lines: lots"))
+	require.Error(t, err)
+}
+
+func TestParseRepoConfig_NegativeMaxPRLines(t *testing.T) {
+	_, err := ParseRepoConfig([]byte("max_pr_lines: -1"))
+	require.Error(t, err)
+}
+
+func TestParseRepoConfig_NegativeMaxFileBytes(t *testing.T) {
+	_, err := ParseRepoConfig([]byte("max_file_bytes: -1"))
+	require.Error(t, err)
+}
+
+func TestParseRepoConfig_NegativePlanFirstMinIssueLength(t *testing.T) {
+	_, err := ParseRepoConfig([]byte("plan_first:\n  min_issue_length: -1"))
+	require.Error(t, err)
+}
+
+func TestParseRepoConfig_EmptyProtectedPaths(t *testing.T) {
+	config, err := ParseRepoConfig([]byte("protected_paths: []"))
+	require.NoError(t, err)
+	require.NotNil(t, config.ProtectedPaths, "an empty list should be told apart from a missing one")
+	require.Empty(t, config.ProtectedPaths)
+}
+
+func TestParseRepoConfig_MalformedProtectedPath(t *testing.T) {
+	_, err := ParseRepoConfig([]byte(`protected_paths: ["vendor/[a"]`))
+	require.Error(t, err)
+}
+
+func TestPlanFirstConfigApplies(t *testing.T) {
+	config := PlanFirstConfig{Labels: []string{"large", "risky"}, MinIssueLength: 10}
+
+	require.True(t, config.Applies(GithubIssue{Labels: []string{"bug", "risky"}}))
+	require.True(t, config.Applies(GithubIssue{Body: "0123456789"}))
+	// Length is counted in characters, not bytes
+	require.False(t, config.Applies(GithubIssue{Body: "ééééé"}))
+	require.False(t, config.Applies(GithubIssue{Body: "short", Labels: []string{"bug"}}))
+	require.False(t, PlanFirstConfig{}.Applies(GithubIssue{Body: "0123456789", Labels: []string{"large"}}))
+}
+
+// testLoadRepoConfig runs loadRepoConfig against a fake GitHub server that serves the given config file content, or
+// responds 404 if content is nil
+func testLoadRepoConfig(t *testing.T, content *string) RepoConfig {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/repos/owner/repo/contents/"+RepoConfigPath, r.URL.Path)
+		w.Header().Set("Content-Type", "application/json")
+		if content == nil {
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte(`{"message": "Not Found"}`))
+			return
+		}
+		_, _ = fmt.Fprintf(w, `{"type": "file", "encoding": "base64", "content": "%s"}`,
+			base64.StdEncoding.EncodeToString([]byte(*content)))
+	}))
+	t.Cleanup(server.Close)
+
+	client := github.NewClient(nil)
+	baseURL, err := url.Parse(server.URL + "/")
+	require.NoError(t, err)
+	client.BaseURL = baseURL
+
+	return NewBuilder(vcs.NewGithubProvider(client), &github.User{Login: github.Ptr("bot")}, CommandConfig{}).loadRepoConfig(context.Background(), "owner", "repo")
+}
+
+func TestLoadRepoConfig_Valid(t *testing.T) {
+	config := testLoadRepoConfig(t, github.Ptr("reviewers: [alice]"))
+	require.Equal(t, RepoConfig{Reviewers: []string{"alice"}}, config)
+}
+
+func TestLoadRepoConfig_Missing(t *testing.T) {
+	config := testLoadRepoConfig(t, nil)
+	require.Equal(t, RepoConfig{}, config)
+}
+
+func TestLoadRepoConfig_InvalidYAMLUsesDefaults(t *testing.T) {
+	config := testLoadRepoConfig(t, github.Ptr("reviewers: [alice\n"))
+	require.Equal(t, RepoConfig{}, config)
+}
@@ -13,7 +13,11 @@ type Task struct {
 	Repository  *github.Repository
 	PullRequest *GithubPullRequest // May be nil if no pull request has yet been created
 
-	// The branch that changes should be merged into to resolve the task
+	// Other issues in the same repository that the task's pull request also fixes. Usually empty
+	AdditionalIssues []GithubIssue
+
+	// The branch that changes should be merged into to resolve the task: the repository's default branch, unless the issue
+	// asks for another
 	TargetBranch string
 	// The branch name used for the pull request, generated from issue details
 	SourceBranch string
@@ -21,23 +25,79 @@ type Task struct {
 	// Code context
 	StyleGuide   *StyleGuide
 	CodebaseInfo *CodebaseInfo
+	RepoConfig   RepoConfig // Settings from the repository's own config file, or defaults if it has none
 
 	// Conversation context
 	IssueComments          []*github.IssueComment         // Issue comments are sorted by timestamp
 	PRComments             []*github.IssueComment         // PRs are issues under the hood, so PR comments are issue comments. These are also sorted by timestamp
-	PRReviewCommentThreads [][]*github.PullRequestComment // List of comment threads
+	PRReviewCommentThreads [][]*github.PullRequestComment // List of comment threads, sorted by their first comments' timestamps
 	PRReviews              []*github.PullRequestReview    // PR reviews are sorted by timestamp
+	Images                 []Image                        // Images embedded in the issue and its comments, e.g. screenshots
 
 	// Current work state
 	IssueCommentsRequiringResponses    []*github.IssueComment
 	PRCommentsRequiringResponses       []*github.IssueComment
 	PRReviewCommentsRequiringResponses []*github.PullRequestComment
+	AttentionReason                    AttentionReason // Why the task needs the bot's attention, if it does
+	Directives                         Directives      // Commands given to the bot in comments that it hasn't acknowledged yet
+	Plan                               PlanState       // The state of the plan that the issue needs approved, if it is in plan-first mode
 
 	// State computed from the workspace after initial task generation (unpopulated until then)
 	HasUnpublishedChanges bool
 	ValidationResult      validator.ValidationResult
+	BaseSyncResult        *validator.ValidationResult // The result of bringing base branch commits into the work branch, if attempted
+
+	ignoreLabelName string // See IgnoreLabel. Empty for the default
+}
+
+// IgnoreLabel returns the label that marks the task's issue for the bot to leave alone, as configured by
+// CommandConfig.IgnoreLabel
+func (t Task) IgnoreLabel() github.Label {
+	return CommandConfig{IgnoreLabel: t.ignoreLabelName}.ignoreLabel()
 }
 
+// IssueNumbers returns the numbers of all issues that the task's pull request fixes, starting with the task's own issue
+func (t Task) IssueNumbers() []int {
+	numbers := []int{t.Issue.Number}
+	for _, issue := range t.AdditionalIssues {
+		numbers = append(numbers, issue.Number)
+	}
+	return numbers
+}
+
+// NeedsPlanApproval returns true if the bot must not change any code for the task until a plan is approved, because the
+// issue is in plan-first mode and the bot's latest plan, if any, hasn't been approved
+func (t Task) NeedsPlanApproval() bool {
+	return t.Plan.Required && !t.Plan.Approved
+}
+
+// HasMergeConflicts returns true if the task's pull request can't be merged because it conflicts with its base branch
+func (t Task) HasMergeConflicts() bool {
+	return t.PullRequest != nil && t.PullRequest.HasConflicts
+}
+
+// AttentionReason is why a task needs the bot's attention
+type AttentionReason string
+
+const (
+	// AttentionNone means that the task doesn't need the bot's attention
+	AttentionNone AttentionReason = ""
+	// AttentionNewIssue means that the issue is new, with no comments and no pull request
+	AttentionNewIssue AttentionReason = "new issue"
+	// AttentionIssueComment means that a comment on the issue is awaiting a response
+	AttentionIssueComment AttentionReason = "issue comment"
+	// AttentionPRComment means that a comment on the pull request is awaiting a response
+	AttentionPRComment AttentionReason = "pull request comment"
+	// AttentionReviewComment means that a review comment on the pull request's diff is awaiting a response
+	AttentionReviewComment AttentionReason = "review comment"
+	// AttentionCommand means that someone gave the bot a command in a comment
+	AttentionCommand AttentionReason = "command"
+	// AttentionBotTurnLabel means that someone added the bot-turn label to the issue to prompt the bot to act
+	AttentionBotTurnLabel AttentionReason = "bot-turn label"
+	// AttentionPlanApproved means that someone approved the plan that the bot proposed, so it can start work
+	AttentionPlanApproved AttentionReason = "plan approved"
+)
+
 // CodebaseInfo holds information about the repository structure
 type CodebaseInfo struct {
 	MainLanguage  string
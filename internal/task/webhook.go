@@ -0,0 +1,310 @@
+package task
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/cchalm/blundering-savant/internal/vcs"
+	"github.com/google/go-github/v72/github"
+)
+
+// maxWebhookPayloadBytes caps the size of webhook request bodies. GitHub caps payloads at 25MB
+const maxWebhookPayloadBytes = 25 << 20
+
+// taskBuilder builds tasks for issues and decides whether they need the bot's attention
+type taskBuilder interface {
+	BuildTask(ctx context.Context, owner string, repo string, issueNumber int, additionalIssues ...int) (*Task, error)
+	NeedsAttention(task Task) (AttentionReason, bool)
+}
+
+// pullRequestGetter fetches pull requests. Implemented by github.PullRequestsService
+type pullRequestGetter interface {
+	Get(ctx context.Context, owner string, repo string, number int) (*github.PullRequest, *github.Response, error)
+}
+
+// webhookTarget identifies the issue or pull request that a webhook event refers to
+type webhookTarget struct {
+	owner string
+	repo  string
+	// number is an issue number, unless isPR is true, in which case it is a pull request number
+	number int
+	isPR   bool
+}
+
+// webhookReceiver is an http.Handler that receives GitHub webhook deliveries and turns them into tasks. Bursts of events
+// for the same issue are debounced into a single task
+type webhookReceiver struct {
+	secret     []byte
+	debounce   time.Duration
+	githubUser *github.User
+
+	builder      taskBuilder
+	pullRequests pullRequestGetter
+	// allowlist limits the repositories whose events are acted on
+	allowlist RepoAllowlist
+
+	targets chan webhookTarget
+}
+
+// NewWebhookReceiver creates a webhook receiver that verifies deliveries using the given secret and waits for the given
+// debounce duration of quiet on an issue before producing a task for it
+func NewWebhookReceiver(
+	githubClient *github.Client,
+	githubUser *github.User,
+	secret string,
+	debounce time.Duration,
+	commands CommandConfig,
+) *webhookReceiver {
+	return newWebhookReceiver(NewBuilder(vcs.NewGithubProvider(githubClient), githubUser, commands), githubClient.PullRequests, githubUser, secret, debounce)
+}
+
+func newWebhookReceiver(
+	builder taskBuilder,
+	pullRequests pullRequestGetter,
+	githubUser *github.User,
+	secret string,
+	debounce time.Duration,
+) *webhookReceiver {
+	return &webhookReceiver{
+		secret:       []byte(secret),
+		debounce:     debounce,
+		githubUser:   githubUser,
+		builder:      builder,
+		pullRequests: pullRequests,
+		targets:      make(chan webhookTarget, 100),
+	}
+}
+
+// SetRepoAllowlist ignores events from repositories outside of the given allowlist, as a guardrail against working on
+// issues in unexpected repositories that the bot's token can access
+func (wr *webhookReceiver) SetRepoAllowlist(allowlist RepoAllowlist) {
+	wr.allowlist = allowlist
+}
+
+// ServeHTTP handles a single webhook delivery
+func (wr *webhookReceiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	payload, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxWebhookPayloadBytes))
+	if err != nil {
+		http.Error(w, "failed to read body", http.StatusBadRequest)
+		return
+	}
+
+	if err := verifySignature(wr.secret, payload, r.Header.Get("X-Hub-Signature-256")); err != nil {
+		log.Printf("[webhook] Rejecting delivery %s: %v", r.Header.Get("X-GitHub-Delivery"), err)
+		http.Error(w, "invalid signature", http.StatusUnauthorized)
+		return
+	}
+
+	target, ok, err := parseWebhookEvent(r.Header.Get("X-GitHub-Event"), payload, wr.githubUser)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to parse event: %v", err), http.StatusBadRequest)
+		return
+	}
+	if !ok {
+		// Not an event we act on, but acknowledge it so that GitHub doesn't report a failed delivery
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if !wr.allowlist.Allows(target.owner, target.repo) {
+		log.Printf("[webhook] Ignoring event for %s/%s#%d: repository is not on the allowlist", target.owner, target.repo, target.number)
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	select {
+	case wr.targets <- target:
+		w.WriteHeader(http.StatusAccepted)
+	default:
+		log.Printf("[webhook] Dropping event for %s/%s#%d: queue is full", target.owner, target.repo, target.number)
+		http.Error(w, "too many pending events", http.StatusServiceUnavailable)
+	}
+}
+
+// Generate produces a task for each issue that received webhook events, once the issue has been quiet for the debounce
+// duration. Tasks that don't need attention, or that fail to build, are skipped. When ctx is cancelled, a final item
+// carrying the context error is sent and the channel is closed
+func (wr *webhookReceiver) Generate(ctx context.Context) chan TaskOrError {
+	tasks := make(chan TaskOrError)
+
+	go func() {
+		defer close(tasks)
+		wr.run(ctx, tasks)
+	}()
+
+	return tasks
+}
+
+// builtTask is a task waiting to be sent, along with the target it was built for
+type builtTask struct {
+	target webhookTarget
+	task   Task
+}
+
+func (wr *webhookReceiver) run(ctx context.Context, tasks chan<- TaskOrError) {
+	// deadlines holds the time at which each pending target's quiet period ends
+	deadlines := map[webhookTarget]time.Time{}
+	// ready holds built tasks in the order they are to be sent. Targets keep being received while the consumer is busy,
+	// so that deliveries aren't dropped because the queue is full
+	var ready []builtTask
+
+	for {
+		var timer <-chan time.Time
+		if len(deadlines) > 0 {
+			var next time.Time
+			for _, deadline := range deadlines {
+				if next.IsZero() || deadline.Before(next) {
+					next = deadline
+				}
+			}
+			timer = time.After(time.Until(next))
+		}
+		// Sending on a nil channel blocks forever, so the send is only enabled while a task is ready
+		var send chan<- TaskOrError
+		var next TaskOrError
+		if len(ready) > 0 {
+			send = tasks
+			next = TaskOrError{Task: ready[0].task}
+		}
+
+		select {
+		case target := <-wr.targets:
+			// Restart the quiet period, so that a burst of events results in a single task. A task already built for
+			// the target is out of date, so it is dropped and built again once the target is quiet
+			deadlines[target] = time.Now().Add(wr.debounce)
+			ready = slices.DeleteFunc(ready, func(bt builtTask) bool { return bt.target == target })
+		case now := <-timer:
+			for target, deadline := range deadlines {
+				if deadline.After(now) {
+					continue
+				}
+				delete(deadlines, target)
+				if tsk, ok := wr.buildTarget(ctx, target); ok {
+					ready = append(ready, builtTask{target: target, task: *tsk})
+				}
+			}
+		case send <- next:
+			ready = ready[1:]
+		case <-ctx.Done():
+			tasks <- TaskOrError{Err: ctx.Err()}
+			return
+		}
+	}
+}
+
+// buildTarget builds a task for the given target. Returns false if the target isn't one of the bot's issues or pull
+// requests, the task doesn't need attention, or the task fails to build
+func (wr *webhookReceiver) buildTarget(ctx context.Context, target webhookTarget) (*Task, bool) {
+	issueNumbers := []int{target.number}
+	if target.isPR {
+		pr, _, err := wr.pullRequests.Get(ctx, target.owner, target.repo, target.number)
+		if err != nil {
+			log.Printf("[webhook] Warning: skipping PR #%d in %s/%s: %v", target.number, target.owner, target.repo, err)
+			return nil, false
+		}
+		issueNumbers, err = IssueNumbersFromSourceBranch(pr.GetHead().GetRef())
+		if err != nil {
+			// Not one of the bot's pull requests
+			return nil, false
+		}
+	}
+	issueNumber := issueNumbers[0]
+
+	tsk, err := wr.builder.BuildTask(ctx, target.owner, target.repo, issueNumber, issueNumbers[1:]...)
+	if err != nil {
+		// Likely a transient GitHub error, which mustn't stop the bot. The next event on the issue will try again
+		log.Printf("[webhook] Warning: skipping issue #%d in %s/%s: failed to build task: %v", issueNumber, target.owner, target.repo, err)
+		return nil, false
+	}
+
+	reason, ok := wr.builder.NeedsAttention(*tsk)
+	if !ok {
+		log.Printf("[webhook] Skipping issue #%d in %s/%s: no attention needed", issueNumber, target.owner, target.repo)
+		return nil, false
+	}
+	log.Printf("[webhook] Yielding task for issue #%d in %s/%s (%s)", issueNumber, target.owner, target.repo, reason)
+	return tsk, true
+}
+
+// verifySignature checks that signatureHeader, the value of an X-Hub-Signature-256 header, is the HMAC-SHA256 of
+// payload keyed with secret
+func verifySignature(secret []byte, payload []byte, signatureHeader string) error {
+	hexSignature, ok := strings.CutPrefix(signatureHeader, "sha256=")
+	if !ok {
+		return fmt.Errorf("missing or malformed signature")
+	}
+	signature, err := hex.DecodeString(hexSignature)
+	if err != nil {
+		return fmt.Errorf("malformed signature: %w", err)
+	}
+
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(payload)
+	if !hmac.Equal(signature, mac.Sum(nil)) {
+		return fmt.Errorf("signature mismatch")
+	}
+	return nil
+}
+
+// parseWebhookEvent extracts the issue or pull request that a webhook event refers to. Returns false if the event is
+// not one that should trigger a task, including events caused by the bot itself
+func parseWebhookEvent(eventType string, payload []byte, githubUser *github.User) (webhookTarget, bool, error) {
+	event, err := github.ParseWebHook(eventType, payload)
+	if err != nil {
+		// ParseWebHook errors on unknown event types, which we don't care about anyway
+		if eventType != "issues" && eventType != "issue_comment" && eventType != "pull_request_review" {
+			return webhookTarget{}, false, nil
+		}
+		return webhookTarget{}, false, err
+	}
+
+	var (
+		repo   *github.Repository
+		sender *github.User
+		target webhookTarget
+	)
+	switch e := event.(type) {
+	case *github.IssuesEvent:
+		repo, sender = e.GetRepo(), e.GetSender()
+		target = webhookTarget{number: e.GetIssue().GetNumber(), isPR: e.GetIssue().IsPullRequest()}
+	case *github.IssueCommentEvent:
+		repo, sender = e.GetRepo(), e.GetSender()
+		target = webhookTarget{number: e.GetIssue().GetNumber(), isPR: e.GetIssue().IsPullRequest()}
+	case *github.PullRequestReviewEvent:
+		repo, sender = e.GetRepo(), e.GetSender()
+		// Ignore reviews of pull requests that the bot didn't open, as told by the branch name in the payload
+		if _, err := IssueNumbersFromSourceBranch(e.GetPullRequest().GetHead().GetRef()); err != nil {
+			return webhookTarget{}, false, nil
+		}
+		// Target the pull request rather than its issue, so that reviews and comments on the same pull request are
+		// debounced together
+		target = webhookTarget{number: e.GetPullRequest().GetNumber(), isPR: true}
+	default:
+		return webhookTarget{}, false, nil
+	}
+
+	if githubUser != nil && sender.GetLogin() == githubUser.GetLogin() {
+		// Ignore the bot's own activity
+		return webhookTarget{}, false, nil
+	}
+	if target.number == 0 {
+		return webhookTarget{}, false, fmt.Errorf("event has no issue number")
+	}
+
+	target.owner = repo.GetOwner().GetLogin()
+	target.repo = repo.GetName()
+	return target, true, nil
+}
@@ -0,0 +1,278 @@
+package task
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-github/v72/github"
+	"github.com/stretchr/testify/require"
+)
+
+const testWebhookSecret = "It's a Secret to Everybody"
+
+func sign(secret string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(payload)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func testVerifySignature(t *testing.T, payload []byte, signatureHeader string, wantErr bool) {
+	err := verifySignature([]byte(testWebhookSecret), payload, signatureHeader)
+	if (err != nil) != wantErr {
+		t.Errorf("verifySignature() error = %v, wantErr %v", err, wantErr)
+	}
+}
+
+func TestVerifySignature_Valid(t *testing.T) {
+	// Example from GitHub's webhook documentation
+	testVerifySignature(t,
+		[]byte("Hello, World!"),
+		"sha256=757107ea0eb2509fc211221cce984b8a37570b6d7586c22c46f4379c8b043e17",
+		false, // wantErr
+	)
+}
+
+func TestVerifySignature_WrongSecret(t *testing.T) {
+	payload := []byte("Hello, World!")
+	testVerifySignature(t, payload, sign("some other secret", payload), true)
+}
+
+func TestVerifySignature_TamperedPayload(t *testing.T) {
+	testVerifySignature(t, []byte("Hello, World?"), sign(testWebhookSecret, []byte("Hello, World!")), true)
+}
+
+func TestVerifySignature_Missing(t *testing.T) {
+	testVerifySignature(t, []byte("Hello, World!"), "", true)
+}
+
+func TestVerifySignature_Malformed(t *testing.T) {
+	testVerifySignature(t, []byte("Hello, World!"), "sha256=not-hex", true)
+}
+
+// builderStub records the issues that tasks are built for
+type builderStub struct {
+	mu    sync.Mutex
+	built []int
+	fail  map[int]bool // Issues whose tasks fail to build
+}
+
+func (bs *builderStub) BuildTask(ctx context.Context, owner string, repo string, issueNumber int, additionalIssues ...int) (*Task, error) {
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+	bs.built = append(bs.built, issueNumber)
+	if bs.fail[issueNumber] {
+		return nil, fmt.Errorf("failed to build task for issue %d", issueNumber)
+	}
+	return &Task{Issue: GithubIssue{Owner: owner, Repo: repo, Number: issueNumber}}, nil
+}
+
+func (bs *builderStub) NeedsAttention(task Task) (AttentionReason, bool) {
+	return AttentionIssueComment, true
+}
+
+func (bs *builderStub) builtIssues() []int {
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+	return append([]int{}, bs.built...)
+}
+
+// pullRequestGetterStub serves pull requests from memory
+type pullRequestGetterStub struct {
+	headRefs map[int]string
+}
+
+func (pgs pullRequestGetterStub) Get(ctx context.Context, owner string, repo string, number int) (*github.PullRequest, *github.Response, error) {
+	ref, ok := pgs.headRefs[number]
+	if !ok {
+		return nil, nil, fmt.Errorf("pull request %d not found", number)
+	}
+	return &github.PullRequest{Number: &number, Head: &github.PullRequestBranch{Ref: &ref}}, nil, nil
+}
+
+func issueCommentPayload(issueNumber int, isPR bool, senderLogin string) []byte {
+	pullRequest := ""
+	if isPR {
+		pullRequest = `, "pull_request": {"url": "https://api.github.com/repos/owner/repo/pulls/1"}`
+	}
+	return fmt.Appendf(nil, `{
+		"action": "created",
+		"issue": {"number": %d%s},
+		"comment": {"id": 1, "body": "hello"},
+		"repository": {"name": "repo", "owner": {"login": "owner"}},
+		"sender": {"login": "%s"}
+	}`, issueNumber, pullRequest, senderLogin)
+}
+
+func deliver(t *testing.T, receiver *webhookReceiver, eventType string, payload []byte, signature string) int {
+	req := httptest.NewRequest(http.MethodPost, "/webhook", bytes.NewReader(payload))
+	req.Header.Set("X-GitHub-Event", eventType)
+	req.Header.Set("X-Hub-Signature-256", signature)
+	rec := httptest.NewRecorder()
+	receiver.ServeHTTP(rec, req)
+	return rec.Code
+}
+
+func newTestWebhookReceiver(builder *builderStub, debounce time.Duration) *webhookReceiver {
+	pullRequests := pullRequestGetterStub{headRefs: map[int]string{20: "fix/issue-3-some-title"}}
+	return newWebhookReceiver(builder, pullRequests, &github.User{Login: github.Ptr("bot")}, testWebhookSecret, debounce)
+}
+
+func TestWebhookReceiver_InvalidSignature(t *testing.T) {
+	builder := &builderStub{}
+	receiver := newTestWebhookReceiver(builder, time.Millisecond)
+
+	payload := issueCommentPayload(1, false, "user")
+	code := deliver(t, receiver, "issue_comment", payload, sign("wrong secret", payload))
+	require.Equal(t, http.StatusUnauthorized, code)
+	require.Empty(t, receiver.targets)
+}
+
+func TestWebhookReceiver_IgnoresOwnEvents(t *testing.T) {
+	builder := &builderStub{}
+	receiver := newTestWebhookReceiver(builder, time.Millisecond)
+
+	payload := issueCommentPayload(1, false, "bot")
+	code := deliver(t, receiver, "issue_comment", payload, sign(testWebhookSecret, payload))
+	require.Equal(t, http.StatusNoContent, code)
+	require.Empty(t, receiver.targets)
+}
+
+func TestWebhookReceiver_IgnoresReposOutsideAllowlist(t *testing.T) {
+	builder := &builderStub{}
+	receiver := newTestWebhookReceiver(builder, time.Millisecond)
+	allowlist, err := NewRepoAllowlist([]string{"myorg/*"})
+	require.NoError(t, err)
+	receiver.SetRepoAllowlist(allowlist)
+
+	// The payload is for owner/repo
+	payload := issueCommentPayload(1, false, "user")
+	code := deliver(t, receiver, "issue_comment", payload, sign(testWebhookSecret, payload))
+	require.Equal(t, http.StatusNoContent, code)
+	require.Empty(t, receiver.targets)
+
+	receiver.SetRepoAllowlist(RepoAllowlist{"owner/*"})
+	code = deliver(t, receiver, "issue_comment", payload, sign(testWebhookSecret, payload))
+	require.Equal(t, http.StatusAccepted, code)
+	require.Len(t, receiver.targets, 1)
+}
+
+func TestWebhookReceiver_Debounce(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	builder := &builderStub{}
+	receiver := newTestWebhookReceiver(builder, 100*time.Millisecond)
+	tasks := receiver.Generate(ctx)
+
+	// A burst of comments on issue 1, and a comment on PR 20, which was created for issue 3
+	for range 3 {
+		payload := issueCommentPayload(1, false, "user")
+		require.Equal(t, http.StatusAccepted, deliver(t, receiver, "issue_comment", payload, sign(testWebhookSecret, payload)))
+	}
+	payload := issueCommentPayload(20, true, "user")
+	require.Equal(t, http.StatusAccepted, deliver(t, receiver, "issue_comment", payload, sign(testWebhookSecret, payload)))
+
+	var issueNumbers []int
+	for range 2 {
+		select {
+		case taskOrError := <-tasks:
+			require.NoError(t, taskOrError.Err)
+			issueNumbers = append(issueNumbers, taskOrError.Task.Issue.Number)
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for task")
+		}
+	}
+	require.ElementsMatch(t, []int{1, 3}, issueNumbers)
+
+	// Make sure that no additional tasks are produced for the burst
+	select {
+	case taskOrError := <-tasks:
+		t.Fatalf("unexpected task for issue %d", taskOrError.Task.Issue.Number)
+	case <-time.After(300 * time.Millisecond):
+	}
+	require.ElementsMatch(t, []int{1, 3}, builder.builtIssues())
+}
+
+// receiveTask waits for the next item from tasks
+func receiveTask(t *testing.T, tasks <-chan TaskOrError) TaskOrError {
+	select {
+	case taskOrError, ok := <-tasks:
+		require.True(t, ok, "the channel should not be closed")
+		return taskOrError
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for task")
+		return TaskOrError{}
+	}
+}
+
+// deliverComment delivers a comment on the given issue, requiring it to be accepted
+func deliverComment(t *testing.T, receiver *webhookReceiver, issueNumber int) {
+	payload := issueCommentPayload(issueNumber, false, "user")
+	require.Equal(t, http.StatusAccepted, deliver(t, receiver, "issue_comment", payload, sign(testWebhookSecret, payload)))
+}
+
+func TestWebhookReceiver_SkipsTasksThatFailToBuild(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	builder := &builderStub{fail: map[int]bool{1: true}}
+	receiver := newTestWebhookReceiver(builder, 10*time.Millisecond)
+	tasks := receiver.Generate(ctx)
+
+	deliverComment(t, receiver, 1)
+	require.Eventually(t, func() bool { return len(builder.builtIssues()) == 1 }, 5*time.Second, time.Millisecond)
+	deliverComment(t, receiver, 2)
+
+	taskOrError := receiveTask(t, tasks)
+	require.NoError(t, taskOrError.Err)
+	require.Equal(t, 2, taskOrError.Task.Issue.Number)
+}
+
+func TestWebhookReceiver_ReceivesWhileConsumerBusy(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	builder := &builderStub{}
+	receiver := newTestWebhookReceiver(builder, 10*time.Millisecond)
+	tasks := receiver.Generate(ctx)
+
+	// Nothing takes the task for issue 1, like when every worker is busy
+	deliverComment(t, receiver, 1)
+	require.Eventually(t, func() bool { return len(builder.builtIssues()) == 1 }, 5*time.Second, time.Millisecond)
+
+	// Events keep being taken off the queue meanwhile, so that it doesn't fill up
+	for range 3 {
+		deliverComment(t, receiver, 2)
+		require.Eventually(t, func() bool { return len(receiver.targets) == 0 }, 5*time.Second, time.Millisecond)
+	}
+
+	require.Equal(t, 1, receiveTask(t, tasks).Task.Issue.Number)
+	require.Equal(t, 2, receiveTask(t, tasks).Task.Issue.Number)
+}
+
+func TestWebhookReceiver_CancelWhileConsumerBusy(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	builder := &builderStub{}
+	receiver := newTestWebhookReceiver(builder, 10*time.Millisecond)
+	tasks := receiver.Generate(ctx)
+
+	deliverComment(t, receiver, 1)
+	require.Eventually(t, func() bool { return len(builder.builtIssues()) == 1 }, 5*time.Second, time.Millisecond)
+	cancel()
+
+	// The task nobody took is dropped rather than sent after the cancellation
+	require.ErrorIs(t, receiveTask(t, tasks).Err, context.Canceled)
+	_, ok := <-tasks
+	require.False(t, ok)
+}
@@ -0,0 +1,182 @@
+package transport
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// secondaryBaseBackoff is how long to wait after hitting a secondary rate limit that doesn't say how long to wait.
+	// GitHub asks for at least a minute
+	secondaryBaseBackoff = time.Minute
+	secondaryMaxBackoff  = 15 * time.Minute
+)
+
+// GithubTransport spaces out write requests to GitHub's API to stay within a writes-per-minute budget, and retries
+// requests that hit one of GitHub's secondary rate limits. Secondary limits apply to the token as a whole rather than to
+// individual requests, so a limited response pauses every request through the transport until the wait is over. Reads
+// aren't spaced out, since they're covered by the primary rate limit, which the go-github client reports as an error
+type GithubTransport struct {
+	base http.RoundTripper
+
+	// writeInterval is the minimum time between the starts of consecutive write requests. Zero means no limit
+	writeInterval time.Duration
+	mu            sync.Mutex
+	nextWrite     time.Time // The earliest time at which the next write request may start
+	pausedUntil   time.Time // The time until which no request may start, after hitting a secondary rate limit
+
+	// Overridden in tests
+	sleep  func(ctx context.Context, d time.Duration) error
+	jitter func(d time.Duration) time.Duration
+	now    func() time.Time
+}
+
+// WithGithubRateLimiting wraps base, or http.DefaultTransport if base is nil, in a GithubTransport that sends at most
+// writesPerMinute write requests per minute. Zero means no limit. Share the returned transport between all clients that
+// use the same token
+func WithGithubRateLimiting(base http.RoundTripper, writesPerMinute int) *GithubTransport {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	var writeInterval time.Duration
+	if writesPerMinute > 0 {
+		writeInterval = time.Minute / time.Duration(writesPerMinute)
+	}
+	return &GithubTransport{
+		base:          base,
+		writeInterval: writeInterval,
+		sleep:         sleepContext,
+		jitter:        randomJitter,
+		now:           time.Now,
+	}
+}
+
+func (t *GithubTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// Preserve the original request body for retries
+	var bodyBytes []byte
+	if req.Body != nil {
+		var err error
+		bodyBytes, err = io.ReadAll(req.Body)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read request body: %w", err)
+		}
+		err = req.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to close request body: %w", err)
+		}
+	}
+
+	for attempt := 0; ; attempt++ {
+		if err := t.sleep(req.Context(), t.reserveSlot(isWrite(req))); err != nil {
+			return nil, err
+		}
+
+		// Restore the request body for each attempt
+		if bodyBytes != nil {
+			req.Body = io.NopCloser(bytes.NewReader(bodyBytes))
+		}
+
+		resp, err := t.base.RoundTrip(req)
+		if err != nil {
+			return resp, err
+		}
+
+		limited, err := isSecondaryRateLimit(resp)
+		if err != nil {
+			return nil, err
+		}
+		if !limited {
+			return resp, nil
+		}
+		if attempt == maxRetries {
+			// Let the caller decide what to do
+			return resp, nil
+		}
+
+		wait, ok := retryDelay(resp.Header, t.now())
+		if !ok {
+			wait = min(secondaryBaseBackoff<<attempt, secondaryMaxBackoff)
+		}
+		wait += t.jitter(wait)
+
+		// Close the response body to free resources
+		err = resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to close response body: %w", err)
+		}
+
+		log.Printf("Hit GitHub secondary rate limit (%s %s), pausing GitHub requests for %s",
+			req.Method, req.URL.Path, wait.Round(time.Millisecond))
+		t.pause(wait)
+	}
+}
+
+// pause holds back all requests through the transport for the given duration, unless they're already held back longer
+func (t *GithubTransport) pause(d time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	until := t.now().Add(d)
+	if until.After(t.pausedUntil) {
+		t.pausedUntil = until
+	}
+}
+
+// reserveSlot returns how long to wait before starting a request, honoring any pause and, for writes, claiming the next
+// start time allowed by the writes-per-minute budget
+func (t *GithubTransport) reserveSlot(write bool) time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := t.now()
+	slot := now
+	if t.pausedUntil.After(slot) {
+		slot = t.pausedUntil
+	}
+	if write && t.writeInterval > 0 {
+		if t.nextWrite.After(slot) {
+			slot = t.nextWrite
+		}
+		t.nextWrite = slot.Add(t.writeInterval)
+	}
+	return slot.Sub(now)
+}
+
+// isWrite returns true if the given request may change something on GitHub. GitHub's secondary rate limits are
+// stricter for such requests
+func isWrite(req *http.Request) bool {
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return false
+	default:
+		return true
+	}
+}
+
+// isSecondaryRateLimit returns true if the given response reports that a secondary rate limit was hit. GitHub reports
+// these with a 403 or 429 status and a message in the body, which distinguishes them from permission errors. The body
+// is restored so that the caller can still read it
+func isSecondaryRateLimit(resp *http.Response) (bool, error) {
+	if resp.StatusCode != http.StatusForbidden && resp.StatusCode != http.StatusTooManyRequests {
+		return false, nil
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return false, fmt.Errorf("failed to read response body: %w", err)
+	}
+	if err := resp.Body.Close(); err != nil {
+		return false, fmt.Errorf("failed to close response body: %w", err)
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+
+	message := strings.ToLower(string(body))
+	return strings.Contains(message, "secondary rate limit") || strings.Contains(message, "abuse detection"), nil
+}
@@ -0,0 +1,129 @@
+package transport
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+const secondaryRateLimitBody = `{"message": "You have exceeded a secondary rate limit. Please wait a few minutes before you try again."}`
+
+func responseWithBody(status int, header map[string]string, body string) *http.Response {
+	resp := response(status, header)
+	resp.Body = io.NopCloser(strings.NewReader(body))
+	return resp
+}
+
+// newTestGithubTransport creates a GithubTransport backed by the given responses, with a fake clock that advances only
+// when the transport sleeps and a fixed jitter of one millisecond. Returns the durations the transport slept for
+func newTestGithubTransport(writesPerMinute int, responses ...*http.Response) (*GithubTransport, *roundTripperStub, *[]time.Duration) {
+	stub := &roundTripperStub{responses: responses}
+	transport := WithGithubRateLimiting(stub, writesPerMinute)
+
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	var sleeps []time.Duration
+	transport.now = func() time.Time { return now }
+	transport.sleep = func(ctx context.Context, d time.Duration) error {
+		if d > 0 {
+			sleeps = append(sleeps, d)
+			now = now.Add(d)
+		}
+		return nil
+	}
+	transport.jitter = func(d time.Duration) time.Duration { return time.Millisecond }
+	return transport, stub, &sleeps
+}
+
+func sendGithubRequest(t *testing.T, transport *GithubTransport, method string) *http.Response {
+	var body io.Reader
+	if method != http.MethodGet {
+		body = strings.NewReader(`{"body": "hello"}`)
+	}
+	req, err := http.NewRequest(method, "https://api.github.com/repos/owner/repo/issues/1/comments", body)
+	require.NoError(t, err)
+	resp, err := transport.RoundTrip(req)
+	require.NoError(t, err)
+	return resp
+}
+
+func TestGithubTransport_SecondaryRateLimitRetryAfter(t *testing.T) {
+	transport, stub, sleeps := newTestGithubTransport(0,
+		responseWithBody(http.StatusForbidden, map[string]string{"retry-after": "30"}, secondaryRateLimitBody),
+		response(http.StatusCreated, nil),
+	)
+
+	resp := sendGithubRequest(t, transport, http.MethodPost)
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+	require.Equal(t, []time.Duration{30*time.Second + time.Millisecond}, *sleeps)
+	require.Equal(t, []string{`{"body": "hello"}`, `{"body": "hello"}`}, stub.bodies, "the body should be resent on retry")
+}
+
+func TestGithubTransport_AbuseDetectionBackoff(t *testing.T) {
+	abuse := `{"message": "You have triggered an abuse detection mechanism. Please wait a few minutes before you try again."}`
+	transport, _, sleeps := newTestGithubTransport(0,
+		responseWithBody(http.StatusForbidden, nil, abuse),
+		responseWithBody(http.StatusTooManyRequests, nil, abuse),
+		response(http.StatusCreated, nil),
+	)
+
+	resp := sendGithubRequest(t, transport, http.MethodPost)
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+	require.Equal(t, []time.Duration{time.Minute + time.Millisecond, 2*time.Minute + time.Millisecond}, *sleeps)
+}
+
+func TestGithubTransport_PermissionErrorNotRetried(t *testing.T) {
+	transport, _, sleeps := newTestGithubTransport(0,
+		responseWithBody(http.StatusForbidden, nil, `{"message": "Resource not accessible by integration"}`),
+	)
+
+	resp := sendGithubRequest(t, transport, http.MethodPost)
+	require.Equal(t, http.StatusForbidden, resp.StatusCode)
+	require.Empty(t, *sleeps)
+
+	// The caller can still read the error
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.Contains(t, string(body), "Resource not accessible")
+}
+
+func TestGithubTransport_GivesUpAfterMaxRetries(t *testing.T) {
+	var responses []*http.Response
+	for range maxRetries + 1 {
+		responses = append(responses, responseWithBody(http.StatusForbidden, map[string]string{"retry-after": "1"}, secondaryRateLimitBody))
+	}
+	transport, _, sleeps := newTestGithubTransport(0, responses...)
+
+	resp := sendGithubRequest(t, transport, http.MethodPost)
+	require.Equal(t, http.StatusForbidden, resp.StatusCode)
+	require.Len(t, *sleeps, maxRetries)
+}
+
+func TestGithubTransport_SpacesOutWrites(t *testing.T) {
+	transport, _, sleeps := newTestGithubTransport(30,
+		response(http.StatusCreated, nil),
+		response(http.StatusOK, nil),
+		response(http.StatusCreated, nil),
+	)
+
+	sendGithubRequest(t, transport, http.MethodPost)
+	// Reads aren't held back by the write budget
+	sendGithubRequest(t, transport, http.MethodGet)
+	sendGithubRequest(t, transport, http.MethodPost)
+
+	require.Equal(t, []time.Duration{2 * time.Second}, *sleeps)
+}
+
+func TestGithubTransport_PauseAppliesToOtherRequests(t *testing.T) {
+	transport, _, sleeps := newTestGithubTransport(0, response(http.StatusOK, nil))
+
+	// As if another request hit a secondary rate limit
+	transport.pause(time.Minute)
+	sendGithubRequest(t, transport, http.MethodGet)
+
+	require.Equal(t, []time.Duration{time.Minute}, *sleeps)
+}
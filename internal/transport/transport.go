@@ -2,23 +2,67 @@ package transport
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"io"
 	"log"
+	"math/rand/v2"
 	"net/http"
 	"strconv"
+	"sync"
 	"time"
 )
 
+// statusOverloaded is the status Anthropic's API responds with when it is temporarily overloaded
+const statusOverloaded = 529
+
+const (
+	maxRetries  = 5
+	baseBackoff = time.Second
+	maxBackoff  = time.Minute
+)
+
+// rateLimitResetHeaders are the headers in which Anthropic's API reports when each of its rate limits resets. Each is
+// paired with a header reporting how much of that limit remains
+var rateLimitResetHeaders = map[string]string{
+	"anthropic-ratelimit-requests-reset":      "anthropic-ratelimit-requests-remaining",
+	"anthropic-ratelimit-tokens-reset":        "anthropic-ratelimit-tokens-remaining",
+	"anthropic-ratelimit-input-tokens-reset":  "anthropic-ratelimit-input-tokens-remaining",
+	"anthropic-ratelimit-output-tokens-reset": "anthropic-ratelimit-output-tokens-remaining",
+}
+
+// RateLimitedTransport spaces out requests to stay within a requests-per-minute budget, and retries requests that are
+// rejected with 429 (rate limited) or 529 (overloaded). Retries wait for as long as the response asks, or back off
+// exponentially if it doesn't say, plus random jitter so that concurrent clients don't retry in lockstep
 type RateLimitedTransport struct {
 	base http.RoundTripper
+
+	// minInterval is the minimum time between the starts of consecutive requests. Zero means no limit
+	minInterval time.Duration
+	mu          sync.Mutex
+	nextSlot    time.Time // The earliest time at which the next request may start
+
+	// Overridden in tests
+	sleep  func(ctx context.Context, d time.Duration) error
+	jitter func(d time.Duration) time.Duration
 }
 
-func WithRateLimiting(base http.RoundTripper) *RateLimitedTransport {
+// WithRateLimiting wraps base, or http.DefaultTransport if base is nil, in a RateLimitedTransport that sends at most
+// requestsPerMinute requests per minute. Zero means no limit
+func WithRateLimiting(base http.RoundTripper, requestsPerMinute int) *RateLimitedTransport {
 	if base == nil {
 		base = http.DefaultTransport
 	}
-	return &RateLimitedTransport{base: base}
+	var minInterval time.Duration
+	if requestsPerMinute > 0 {
+		minInterval = time.Minute / time.Duration(requestsPerMinute)
+	}
+	return &RateLimitedTransport{
+		base:        base,
+		minInterval: minInterval,
+		sleep:       sleepContext,
+		jitter:      randomJitter,
+	}
 }
 
 func (t *RateLimitedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
@@ -36,7 +80,11 @@ func (t *RateLimitedTransport) RoundTrip(req *http.Request) (*http.Response, err
 		}
 	}
 
-	for {
+	for attempt := 0; ; attempt++ {
+		if err := t.sleep(req.Context(), t.reserveSlot()); err != nil {
+			return nil, err
+		}
+
 		// Restore the request body for each attempt
 		if bodyBytes != nil {
 			req.Body = io.NopCloser(bytes.NewReader(bodyBytes))
@@ -47,41 +95,95 @@ func (t *RateLimitedTransport) RoundTrip(req *http.Request) (*http.Response, err
 			return resp, err
 		}
 
-		// Check for 429 status
-		if resp.StatusCode == http.StatusTooManyRequests {
-			retryAfterStr := resp.Header.Get("retry-after")
-			if retryAfterStr != "" {
-				// Parse retry-after header
-				var waitDuration time.Duration
-
-				// Try parsing as seconds
-				if seconds, err := strconv.Atoi(retryAfterStr); err == nil {
-					waitDuration = time.Duration(seconds) * time.Second
-				} else if retryTime, err := time.Parse(time.RFC1123, retryAfterStr); err == nil {
-					waitDuration = time.Until(retryTime)
-				}
-
-				if waitDuration > 0 {
-					// Close the response body to free resources
-					err = resp.Body.Close()
-					if err != nil {
-						return nil, fmt.Errorf("failed to close request body: %w", err)
-					}
-
-					// Wait for the specified duration
-					log.Printf("Rate limited, waiting %s", waitDuration)
-					select {
-					case <-req.Context().Done():
-						return nil, req.Context().Err()
-					case <-time.After(waitDuration):
-						// Continue the loop to retry
-						continue
-					}
-				}
-			}
+		if resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode != statusOverloaded {
+			return resp, nil
+		}
+		if attempt == maxRetries {
+			// Let the caller decide what to do
+			return resp, nil
+		}
+
+		wait, ok := retryDelay(resp.Header, time.Now())
+		if !ok {
+			wait = min(baseBackoff<<attempt, maxBackoff)
+		}
+		wait += t.jitter(wait)
+
+		// Close the response body to free resources
+		err = resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to close response body: %w", err)
+		}
+
+		log.Printf("Rate limited (%s), waiting %s", resp.Status, wait.Round(time.Millisecond))
+		if err := t.sleep(req.Context(), wait); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// reserveSlot claims the next start time allowed by the requests-per-minute budget and returns how long to wait for it
+func (t *RateLimitedTransport) reserveSlot() time.Duration {
+	if t.minInterval == 0 {
+		return 0
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now()
+	slot := t.nextSlot
+	if slot.Before(now) {
+		slot = now
+	}
+	t.nextSlot = slot.Add(t.minInterval)
+	return slot.Sub(now)
+}
+
+// retryDelay returns how long a rate-limited response asks the client to wait before retrying, based on its
+// retry-after header or, failing that, the reset times of any exhausted Anthropic rate limits. Returns false if the
+// response doesn't say
+func retryDelay(header http.Header, now time.Time) (time.Duration, bool) {
+	if ms, err := strconv.Atoi(header.Get("retry-after-ms")); err == nil && ms > 0 {
+		return time.Duration(ms) * time.Millisecond, true
+	}
+	if retryAfter := header.Get("retry-after"); retryAfter != "" {
+		if seconds, err := strconv.Atoi(retryAfter); err == nil && seconds > 0 {
+			return time.Duration(seconds) * time.Second, true
+		} else if retryTime, err := http.ParseTime(retryAfter); err == nil && retryTime.After(now) {
+			return retryTime.Sub(now), true
+		}
+	}
+
+	var latestReset time.Time
+	for resetHeader, remainingHeader := range rateLimitResetHeaders {
+		if header.Get(remainingHeader) != "0" {
+			continue
 		}
+		reset, err := time.Parse(time.RFC3339, header.Get(resetHeader))
+		if err == nil && reset.After(latestReset) {
+			latestReset = reset
+		}
+	}
+	if latestReset.After(now) {
+		return latestReset.Sub(now), true
+	}
+	return 0, false
+}
 
-		// Return response for all other cases
-		return resp, err
+// randomJitter returns a random duration of up to a quarter of d
+func randomJitter(d time.Duration) time.Duration {
+	return rand.N(d/4 + 1)
+}
+
+func sleepContext(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(d):
+		return nil
 	}
 }
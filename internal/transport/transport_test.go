@@ -0,0 +1,159 @@
+package transport
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// roundTripperStub responds to each request with the next of its canned responses, recording the request bodies
+type roundTripperStub struct {
+	responses []*http.Response
+	bodies    []string
+}
+
+func (rts *roundTripperStub) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Body != nil {
+		body, err := io.ReadAll(req.Body)
+		if err != nil {
+			return nil, err
+		}
+		rts.bodies = append(rts.bodies, string(body))
+	}
+
+	resp := rts.responses[0]
+	rts.responses = rts.responses[1:]
+	return resp, nil
+}
+
+func response(status int, header map[string]string) *http.Response {
+	resp := &http.Response{
+		StatusCode: status,
+		Status:     http.StatusText(status),
+		Header:     http.Header{},
+		Body:       io.NopCloser(strings.NewReader("")),
+	}
+	for k, v := range header {
+		resp.Header.Set(k, v)
+	}
+	return resp
+}
+
+// testRoundTrip sends a request through a transport backed by the given responses, with a fixed jitter of one
+// millisecond, and returns the final response and the durations the transport slept for
+func testRoundTrip(t *testing.T, requestsPerMinute int, responses ...*http.Response) (*http.Response, []time.Duration, *roundTripperStub) {
+	stub := &roundTripperStub{responses: responses}
+	transport := WithRateLimiting(stub, requestsPerMinute)
+	var sleeps []time.Duration
+	transport.sleep = func(ctx context.Context, d time.Duration) error {
+		if d > 0 {
+			sleeps = append(sleeps, d)
+		}
+		return nil
+	}
+	transport.jitter = func(d time.Duration) time.Duration { return time.Millisecond }
+
+	req, err := http.NewRequest(http.MethodPost, "https://api.example.com/v1/messages", strings.NewReader("hello"))
+	require.NoError(t, err)
+	resp, err := transport.RoundTrip(req)
+	require.NoError(t, err)
+	return resp, sleeps, stub
+}
+
+func TestRoundTrip_RetryAfterSeconds(t *testing.T) {
+	resp, sleeps, stub := testRoundTrip(t, 0,
+		response(http.StatusTooManyRequests, map[string]string{"retry-after": "7"}),
+		response(http.StatusOK, nil),
+	)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, []time.Duration{7*time.Second + time.Millisecond}, sleeps)
+	require.Equal(t, []string{"hello", "hello"}, stub.bodies, "the body should be resent on retry")
+}
+
+func TestRoundTrip_RetryAfterMilliseconds(t *testing.T) {
+	_, sleeps, _ := testRoundTrip(t, 0,
+		response(statusOverloaded, map[string]string{"retry-after-ms": "1500", "retry-after": "2"}),
+		response(http.StatusOK, nil),
+	)
+	require.Equal(t, []time.Duration{1500*time.Millisecond + time.Millisecond}, sleeps)
+}
+
+func TestRoundTrip_RateLimitReset(t *testing.T) {
+	reset := time.Now().Add(30 * time.Second).UTC().Format(time.RFC3339)
+	_, sleeps, _ := testRoundTrip(t, 0,
+		response(http.StatusTooManyRequests, map[string]string{
+			"anthropic-ratelimit-tokens-remaining": "0",
+			"anthropic-ratelimit-tokens-reset":     reset,
+			// Not exhausted, so its reset time doesn't matter
+			"anthropic-ratelimit-requests-remaining": "10",
+			"anthropic-ratelimit-requests-reset":     time.Now().Add(time.Hour).UTC().Format(time.RFC3339),
+		}),
+		response(http.StatusOK, nil),
+	)
+	require.Len(t, sleeps, 1)
+	require.InDelta(t, 30*time.Second, sleeps[0], float64(2*time.Second))
+}
+
+func TestRoundTrip_ExponentialBackoff(t *testing.T) {
+	resp, sleeps, _ := testRoundTrip(t, 0,
+		response(statusOverloaded, nil),
+		response(statusOverloaded, nil),
+		response(statusOverloaded, nil),
+		response(http.StatusOK, nil),
+	)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, []time.Duration{
+		time.Second + time.Millisecond,
+		2*time.Second + time.Millisecond,
+		4*time.Second + time.Millisecond,
+	}, sleeps)
+}
+
+func TestRoundTrip_GivesUpAfterMaxRetries(t *testing.T) {
+	var responses []*http.Response
+	for range maxRetries + 1 {
+		responses = append(responses, response(http.StatusTooManyRequests, map[string]string{"retry-after": "1"}))
+	}
+	resp, sleeps, _ := testRoundTrip(t, 0, responses...)
+	require.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+	require.Len(t, sleeps, maxRetries)
+}
+
+func TestRoundTrip_RequestsPerMinute(t *testing.T) {
+	stub := &roundTripperStub{responses: []*http.Response{response(http.StatusOK, nil), response(http.StatusOK, nil)}}
+	transport := WithRateLimiting(stub, 60)
+	var sleeps []time.Duration
+	transport.sleep = func(ctx context.Context, d time.Duration) error {
+		sleeps = append(sleeps, d)
+		return nil
+	}
+
+	for range 2 {
+		req, err := http.NewRequest(http.MethodGet, "https://api.example.com/", nil)
+		require.NoError(t, err)
+		_, err = transport.RoundTrip(req)
+		require.NoError(t, err)
+	}
+
+	require.Len(t, sleeps, 2)
+	require.Zero(t, sleeps[0])
+	require.InDelta(t, time.Second, sleeps[1], float64(100*time.Millisecond))
+}
+
+func TestRoundTrip_CanceledWhileWaiting(t *testing.T) {
+	stub := &roundTripperStub{responses: []*http.Response{response(http.StatusTooManyRequests, map[string]string{"retry-after": "60"})}}
+	transport := WithRateLimiting(stub, 0)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "https://api.example.com/", nil)
+	require.NoError(t, err)
+
+	_, err = transport.RoundTrip(req)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+}
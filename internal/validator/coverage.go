@@ -0,0 +1,170 @@
+package validator
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// ErrCoverageUnavailable is returned when asked for test coverage that wasn't measured
+var ErrCoverageUnavailable = errors.New("coverage is unavailable")
+
+// LineRange is a range of 1-based line numbers, inclusive of both ends
+type LineRange struct {
+	Start int
+	End   int
+}
+
+// FileCoverage holds the lines of a file that tests did and didn't run, as sorted ranges. Lines in neither, e.g. blank
+// lines and comments, aren't executable
+type FileCoverage struct {
+	Covered   []LineRange
+	Uncovered []LineRange
+}
+
+// Coverage maps the paths of files, relative to the root of the repository, to their coverage
+type Coverage map[string]FileCoverage
+
+// ParseCoverage parses a coverage report in LCOV format, or in the format written by "go test -coverprofile". Absolute
+// file paths in LCOV reports are made relative to repoDir, the directory the tests ran in. Paths in Go profiles are
+// import paths, so goModulePath, the path of the module at the root of repoDir, is stripped from them instead
+func ParseCoverage(report []byte, repoDir string, goModulePath string) (Coverage, error) {
+	var (
+		hits map[string]map[int]bool
+		err  error
+	)
+	if bytes.HasPrefix(report, []byte("mode:")) {
+		hits, err = parseGoCoverProfile(report, goModulePath)
+	} else {
+		hits, err = parseLCOV(report, repoDir)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	coverage := Coverage{}
+	for path, lines := range hits {
+		coverage[path] = toFileCoverage(lines)
+	}
+	return coverage, nil
+}
+
+// parseGoCoverProfile parses a Go cover profile into whether each executable line of each file was run
+func parseGoCoverProfile(report []byte, modulePath string) (map[string]map[int]bool, error) {
+	hits := map[string]map[int]bool{}
+	scanner := bufio.NewScanner(bytes.NewReader(report))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "mode:") {
+			continue
+		}
+		// Lines look like "example.com/mod/pkg/file.go:10.2,12.16 3 1"
+		file, block, ok := strings.Cut(line, ":")
+		fields := strings.Fields(block)
+		if !ok || len(fields) != 3 {
+			return nil, fmt.Errorf("malformed cover profile line '%s'", line)
+		}
+		start, end, ok := strings.Cut(fields[0], ",")
+		if !ok {
+			return nil, fmt.Errorf("malformed cover profile line '%s'", line)
+		}
+		startLine, err1 := strconv.Atoi(strings.Split(start, ".")[0])
+		endLine, err2 := strconv.Atoi(strings.Split(end, ".")[0])
+		count, err3 := strconv.Atoi(fields[2])
+		if err := errors.Join(err1, err2, err3); err != nil {
+			return nil, fmt.Errorf("malformed cover profile line '%s': %w", line, err)
+		}
+
+		if modulePath != "" {
+			file = strings.TrimPrefix(file, modulePath+"/")
+		}
+		if hits[file] == nil {
+			hits[file] = map[int]bool{}
+		}
+		for l := startLine; l <= endLine; l++ {
+			// Blocks can share a line, which counts as run if any of them ran
+			hits[file][l] = hits[file][l] || count > 0
+		}
+	}
+	return hits, scanner.Err()
+}
+
+// parseLCOV parses an LCOV report into whether each executable line of each file was run
+func parseLCOV(report []byte, repoDir string) (map[string]map[int]bool, error) {
+	hits := map[string]map[int]bool{}
+	var file string
+	scanner := bufio.NewScanner(bytes.NewReader(report))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case strings.HasPrefix(line, "SF:"):
+			file = strings.TrimPrefix(line, "SF:")
+			if filepath.IsAbs(file) {
+				if rel, err := filepath.Rel(repoDir, file); err == nil {
+					file = rel
+				}
+			}
+			file = filepath.ToSlash(filepath.Clean(file))
+			if hits[file] == nil {
+				hits[file] = map[int]bool{}
+			}
+		case strings.HasPrefix(line, "DA:"):
+			if file == "" {
+				return nil, fmt.Errorf("LCOV line data outside of a file record: '%s'", line)
+			}
+			// Lines look like "DA:<line>,<count>[,<checksum>]"
+			fields := strings.Split(strings.TrimPrefix(line, "DA:"), ",")
+			if len(fields) < 2 {
+				return nil, fmt.Errorf("malformed LCOV line '%s'", line)
+			}
+			lineNumber, err1 := strconv.Atoi(fields[0])
+			count, err2 := strconv.Atoi(fields[1])
+			if err := errors.Join(err1, err2); err != nil {
+				return nil, fmt.Errorf("malformed LCOV line '%s': %w", line, err)
+			}
+			hits[file][lineNumber] = hits[file][lineNumber] || count > 0
+		case line == "end_of_record":
+			file = ""
+		}
+	}
+	return hits, scanner.Err()
+}
+
+// toFileCoverage groups the executable lines of a file into ranges of consecutive covered and uncovered lines
+func toFileCoverage(lines map[int]bool) FileCoverage {
+	numbers := make([]int, 0, len(lines))
+	for n := range lines {
+		numbers = append(numbers, n)
+	}
+	slices.Sort(numbers)
+
+	var fc FileCoverage
+	for _, n := range numbers {
+		ranges := &fc.Uncovered
+		if lines[n] {
+			ranges = &fc.Covered
+		}
+		// Extend the previous range if it ends on the previous line, or if only non-executable lines lie between them
+		if last := len(*ranges) - 1; last >= 0 && !hasLineBetween(lines, (*ranges)[last].End, n) {
+			(*ranges)[last].End = n
+			continue
+		}
+		*ranges = append(*ranges, LineRange{Start: n, End: n})
+	}
+	return fc
+}
+
+// hasLineBetween returns true if any executable line lies strictly between the given lines
+func hasLineBetween(lines map[int]bool, after int, before int) bool {
+	for n := after + 1; n < before; n++ {
+		if _, ok := lines[n]; ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,49 @@
+package validator
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseCoverage_LCOV(t *testing.T) {
+	report := "TN:\n" +
+		"SF:/work/repo/src/widget.js\n" +
+		"DA:1,1\nDA:2,1\nDA:4,0\nDA:5,0\nDA:7,3\n" +
+		"end_of_record\n" +
+		"SF:lib/util.js\n" +
+		"DA:10,0\n" +
+		"end_of_record\n"
+
+	coverage, err := ParseCoverage([]byte(report), "/work/repo", "")
+	require.NoError(t, err)
+	require.Equal(t, Coverage{
+		"src/widget.js": {
+			Covered:   []LineRange{{Start: 1, End: 2}, {Start: 7, End: 7}},
+			Uncovered: []LineRange{{Start: 4, End: 5}},
+		},
+		"lib/util.js": {Uncovered: []LineRange{{Start: 10, End: 10}}},
+	}, coverage)
+}
+
+func TestParseCoverage_GoProfile(t *testing.T) {
+	report := "mode: set\n" +
+		"example.com/mod/pkg/widget.go:3.20,5.2 2 1\n" +
+		"example.com/mod/pkg/widget.go:7.30,9.16 2 0\n" +
+		// Shares line 9 with the previous block, and ran
+		"example.com/mod/pkg/widget.go:9.16,11.3 1 1\n"
+
+	coverage, err := ParseCoverage([]byte(report), "/work/repo", "example.com/mod")
+	require.NoError(t, err)
+	require.Equal(t, Coverage{
+		"pkg/widget.go": {
+			Covered:   []LineRange{{Start: 3, End: 5}, {Start: 9, End: 11}},
+			Uncovered: []LineRange{{Start: 7, End: 8}},
+		},
+	}, coverage)
+}
+
+func TestParseCoverage_Malformed(t *testing.T) {
+	_, err := ParseCoverage([]byte("mode: set\nwidget.go:garbage\n"), "/work/repo", "")
+	require.ErrorContains(t, err, "malformed cover profile line")
+}
@@ -0,0 +1,241 @@
+package validator
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// goModuleRegex matches the module directive of a go.mod file
+var goModuleRegex = regexp.MustCompile(`(?m)^module\s+"?([^\s"]+)"?`)
+
+const (
+	// defaultLocalValidationTimeout bounds a local validation run if no timeout is given
+	defaultLocalValidationTimeout = 15 * time.Minute
+	// maxLocalValidationOutput caps the output of the validation command kept in a result. The end of the output is
+	// kept, since that's where test runners and compilers summarize failures
+	maxLocalValidationOutput = 64 * 1024
+)
+
+// passedThroughEnv lists the environment variables that the validation command inherits from the bot's environment.
+// Everything else is withheld, since the command runs code written by the AI, which issue authors can steer, and the
+// bot's environment holds its credentials
+var passedThroughEnv = []string{
+	"PATH", "LANG", "LC_ALL", "TZ",
+	"GOROOT", "GOPATH", "GOCACHE", "GOMODCACHE", "GOPROXY", "GOFLAGS", "GOTOOLCHAIN",
+}
+
+// withheldEnv lists the environment variables that hold the bot's credentials. They are never passed to the validation
+// command, even if the operator asks for them to be
+var withheldEnv = map[string]bool{
+	"BOT_GITHUB_TOKEN":            true,
+	"SYSTEM_GITHUB_TOKEN":         true,
+	"ANTHROPIC_API_KEY":           true,
+	"GITHUB_APP_PRIVATE_KEY_FILE": true,
+	"WEBHOOK_SECRET":              true,
+	"REDIS_URL":                   true,
+}
+
+// LocalValidator validates commits by fetching them into a temporary directory and running a command there, e.g.
+// "go test ./...", as an alternative to a GitHub Actions workflow. The commit succeeds validation if the command exits
+// with status zero
+type LocalValidator struct {
+	repoURL string // The URL that commits are fetched from
+	token   string // Authenticates fetches from GitHub. May be empty
+	command string // Run with sh -c at the root of the fetched commit
+	timeout time.Duration
+
+	// coverageFile is the path, relative to the root of the fetched commit, of the coverage report that command writes.
+	// Coverage isn't collected if it is empty
+	coverageFile string
+
+	// extraEnv names environment variables passed to the command in addition to passedThroughEnv
+	extraEnv []string
+}
+
+// NewLocalValidator creates a validator that fetches commits from repoURL, authenticating with the given GitHub token if
+// it isn't empty, and runs the given shell command on them. The command is stopped, failing validation, if it runs
+// longer than timeout. Zero selects a default of 15 minutes
+func NewLocalValidator(repoURL string, token string, command string, timeout time.Duration) LocalValidator {
+	if timeout <= 0 {
+		timeout = defaultLocalValidationTimeout
+	}
+	return LocalValidator{
+		repoURL: repoURL,
+		token:   token,
+		command: command,
+		timeout: timeout,
+	}
+}
+
+// WithCoverageFile returns a copy of the validator that collects test coverage from the report that its command writes
+// to the given path, relative to the root of the repository, into the Coverage of its results. See ParseCoverage for
+// the supported formats
+func (lv LocalValidator) WithCoverageFile(path string) LocalValidator {
+	lv.coverageFile = path
+	return lv
+}
+
+// WithEnv returns a copy of the validator whose command also inherits the given environment variables from the bot's
+// environment, e.g. to point at a package registry. The bot's own credentials are withheld regardless
+func (lv LocalValidator) WithEnv(names []string) LocalValidator {
+	lv.extraEnv = names
+	return lv
+}
+
+func (lv LocalValidator) ValidateBranch(ctx context.Context, branch string, commitSHA string) (ValidationResult, error) {
+	log.Printf("Validating branch '%s' with command '%s'", branch, lv.command)
+
+	tempDir, err := os.MkdirTemp("", "blundering-savant-validation-*")
+	if err != nil {
+		return ValidationResult{}, fmt.Errorf("failed to create temporary directory: %w", err)
+	}
+	defer func() {
+		if err := os.RemoveAll(tempDir); err != nil {
+			log.Printf("Warning: failed to remove temporary directory '%s': %v", tempDir, err)
+		}
+	}()
+	// The commit is checked out next to a scratch home directory, so that the command can't find the bot's dotfiles
+	dir := filepath.Join(tempDir, "repo")
+	home := filepath.Join(tempDir, "home")
+	for _, d := range []string{dir, home} {
+		if err := os.Mkdir(d, 0o700); err != nil {
+			return ValidationResult{}, fmt.Errorf("failed to create temporary directory: %w", err)
+		}
+	}
+
+	if err := lv.checkout(ctx, dir, commitSHA); err != nil {
+		return ValidationResult{}, err
+	}
+
+	runCtx, cancel := context.WithTimeout(ctx, lv.timeout)
+	defer cancel()
+
+	var output bytes.Buffer
+	cmd := exec.CommandContext(runCtx, "sh", "-c", lv.command)
+	cmd.Dir = dir
+	cmd.Env = lv.commandEnv(home)
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+	killProcessGroupOnCancel(cmd)
+	// Don't wait forever for processes that escaped the process group and hold on to the command's output
+	cmd.WaitDelay = 10 * time.Second
+	err = cmd.Run()
+
+	var coverage Coverage
+	if lv.coverageFile != "" && ctx.Err() == nil {
+		coverage = lv.collectCoverage(dir)
+	}
+
+	details := tail(output.String(), maxLocalValidationOutput)
+	var exitErr *exec.ExitError
+	switch {
+	case ctx.Err() != nil:
+		return ValidationResult{}, ctx.Err()
+	case errors.Is(runCtx.Err(), context.DeadlineExceeded):
+		return ValidationResult{
+			Succeeded: false,
+			Details:   fmt.Sprintf("Validation command timed out after %v. Output:\n%s", lv.timeout, details),
+			Coverage:  coverage,
+		}, nil
+	case errors.As(err, &exitErr):
+		return ValidationResult{
+			Succeeded: false,
+			Details:   fmt.Sprintf("Validation command exited with status %d. Output:\n%s", exitErr.ExitCode(), details),
+			Coverage:  coverage,
+		}, nil
+	case err != nil:
+		return ValidationResult{}, fmt.Errorf("failed to run validation command: %w", err)
+	}
+	return ValidationResult{Succeeded: true, Details: details, Coverage: coverage}, nil
+}
+
+// commandEnv builds the environment of the validation command from the allowlisted variables of the bot's environment,
+// with HOME and TMPDIR pointing into the given scratch directory
+func (lv LocalValidator) commandEnv(home string) []string {
+	env := []string{"HOME=" + home, "TMPDIR=" + home}
+	for _, name := range append(passedThroughEnv, lv.extraEnv...) {
+		if withheldEnv[name] || name == "HOME" || name == "TMPDIR" {
+			continue
+		}
+		value, ok := os.LookupEnv(name)
+		if !ok || (lv.token != "" && value == lv.token) {
+			continue
+		}
+		env = append(env, name+"="+value)
+	}
+	return env
+}
+
+// collectCoverage reads the coverage report that the validation command wrote in dir. Returns nil if there is none.
+// Failures are logged rather than failing validation, since coverage is only a nice-to-have
+func (lv LocalValidator) collectCoverage(dir string) Coverage {
+	report, err := os.ReadFile(filepath.Join(dir, lv.coverageFile))
+	if err != nil {
+		log.Printf("Warning: failed to read coverage report: %v", err)
+		return nil
+	}
+	coverage, err := ParseCoverage(report, dir, goModulePath(dir))
+	if err != nil {
+		log.Printf("Warning: failed to parse coverage report '%s': %v", lv.coverageFile, err)
+		return nil
+	}
+	return coverage
+}
+
+// goModulePath returns the path of the Go module at the root of dir, or an empty string if there isn't one
+func goModulePath(dir string) string {
+	goMod, err := os.ReadFile(filepath.Join(dir, "go.mod"))
+	if err != nil {
+		return ""
+	}
+	if match := goModuleRegex.FindSubmatch(goMod); match != nil {
+		return string(match[1])
+	}
+	return ""
+}
+
+// checkout fetches the given commit, and only that commit, into the given directory
+func (lv LocalValidator) checkout(ctx context.Context, dir string, commitSHA string) error {
+	for _, args := range [][]string{
+		{"init", "--quiet"},
+		{"fetch", "--quiet", "--depth=1", lv.repoURL, commitSHA},
+		{"checkout", "--quiet", "FETCH_HEAD"},
+	} {
+		cmd := exec.CommandContext(ctx, "git", args...)
+		cmd.Dir = dir
+		cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0")
+		if lv.token != "" {
+			// Pass the token through the environment rather than the command line or the URL, where it could leak into
+			// process listings and error messages
+			credentials := base64.StdEncoding.EncodeToString([]byte("x-access-token:" + lv.token))
+			cmd.Env = append(cmd.Env,
+				"GIT_CONFIG_COUNT=1",
+				"GIT_CONFIG_KEY_0=http.extraHeader",
+				"GIT_CONFIG_VALUE_0=Authorization: Basic "+credentials,
+			)
+		}
+		if output, err := cmd.CombinedOutput(); err != nil {
+			return fmt.Errorf("failed to check out commit %s: git %s: %w: %s", commitSHA, args[0], err, output)
+		}
+	}
+	return nil
+}
+
+// tail returns the last maxBytes bytes of s, noting that the rest was cut off if it was
+func tail(s string, maxBytes int) string {
+	if len(s) <= maxBytes {
+		return s
+	}
+	// The cut may split a multibyte character
+	return "[... earlier output truncated ...]\n" + strings.ToValidUTF8(s[len(s)-maxBytes:], "")
+}
@@ -0,0 +1,9 @@
+//go:build !unix
+
+package validator
+
+import "os/exec"
+
+// killProcessGroupOnCancel does nothing on platforms without process groups. Cancelling the command kills only the
+// command itself
+func killProcessGroupOnCancel(cmd *exec.Cmd) {}
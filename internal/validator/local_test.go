@@ -0,0 +1,140 @@
+package validator
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// newTestRepo creates a git repository with a single commit containing the given files. Returns the repository's path
+// and the commit's SHA
+func newTestRepo(t *testing.T, files map[string]string) (string, string) {
+	dir := t.TempDir()
+	git := func(args ...string) string {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = dir
+		cmd.Env = append(os.Environ(),
+			"GIT_AUTHOR_NAME=test", "GIT_AUTHOR_EMAIL=test@example.com",
+			"GIT_COMMITTER_NAME=test", "GIT_COMMITTER_EMAIL=test@example.com",
+		)
+		output, err := cmd.CombinedOutput()
+		require.NoError(t, err, string(output))
+		return strings.TrimSpace(string(output))
+	}
+
+	git("init", "--quiet")
+	for path, content := range files {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, path), []byte(content), 0o755))
+	}
+	git("add", ".")
+	git("commit", "--quiet", "-m", "Initial commit")
+	return dir, git("rev-parse", "HEAD")
+}
+
+// requireNoValidationDirs checks that no temporary validation directories were left behind
+func requireNoValidationDirs(t *testing.T) {
+	leftovers, err := filepath.Glob(filepath.Join(os.TempDir(), "blundering-savant-validation-*"))
+	require.NoError(t, err)
+	require.Empty(t, leftovers)
+}
+
+func TestLocalValidator_Passes(t *testing.T) {
+	t.Setenv("TMPDIR", t.TempDir())
+	repo, sha := newTestRepo(t, map[string]string{"check.sh": "echo checking \"$(cat message.txt)\"\n", "message.txt": "hello"})
+
+	result, err := NewLocalValidator(repo, "", "sh check.sh", time.Minute).ValidateBranch(context.Background(), "work", sha)
+	require.NoError(t, err)
+	require.Equal(t, ValidationResult{Succeeded: true, Details: "checking hello\n"}, result)
+	requireNoValidationDirs(t)
+}
+
+func TestLocalValidator_Fails(t *testing.T) {
+	t.Setenv("TMPDIR", t.TempDir())
+	repo, sha := newTestRepo(t, map[string]string{"check.sh": "echo 'FAIL: TestWidget' >&2\nexit 3\n"})
+
+	result, err := NewLocalValidator(repo, "", "sh check.sh", time.Minute).ValidateBranch(context.Background(), "work", sha)
+	require.NoError(t, err)
+	require.False(t, result.Succeeded)
+	require.Equal(t, "Validation command exited with status 3. Output:\nFAIL: TestWidget\n", result.Details)
+	requireNoValidationDirs(t)
+}
+
+func TestLocalValidator_TimesOut(t *testing.T) {
+	t.Setenv("TMPDIR", t.TempDir())
+	repo, sha := newTestRepo(t, map[string]string{"check.sh": "echo starting\nsleep 10\n"})
+
+	result, err := NewLocalValidator(repo, "", "sh check.sh", 500*time.Millisecond).ValidateBranch(context.Background(), "work", sha)
+	require.NoError(t, err)
+	require.False(t, result.Succeeded)
+	require.Contains(t, result.Details, "timed out after 500ms")
+	require.Contains(t, result.Details, "starting")
+	requireNoValidationDirs(t)
+}
+
+func TestLocalValidator_WithholdsCredentials(t *testing.T) {
+	t.Setenv("TMPDIR", t.TempDir())
+	t.Setenv("BOT_GITHUB_TOKEN", "bot-secret")
+	t.Setenv("ANTHROPIC_API_KEY", "anthropic-secret")
+	t.Setenv("UNLISTED_VARIABLE", "unlisted")
+	t.Setenv("REGISTRY_URL", "https://registry.example.com")
+	repo, sha := newTestRepo(t, map[string]string{"check.sh": "env\n"})
+
+	lv := NewLocalValidator(repo, "", "sh check.sh", time.Minute).WithEnv([]string{"REGISTRY_URL", "BOT_GITHUB_TOKEN"})
+	result, err := lv.ValidateBranch(context.Background(), "work", sha)
+	require.NoError(t, err)
+	require.True(t, result.Succeeded, result.Details)
+	require.Contains(t, result.Details, "REGISTRY_URL=https://registry.example.com")
+	require.Contains(t, result.Details, "PATH=")
+	require.NotContains(t, result.Details, "secret")
+	require.NotContains(t, result.Details, "UNLISTED_VARIABLE")
+	require.NotContains(t, result.Details, "HOME="+os.Getenv("HOME")+"\n")
+	requireNoValidationDirs(t)
+}
+
+func TestLocalValidator_UnknownCommit(t *testing.T) {
+	t.Setenv("TMPDIR", t.TempDir())
+	repo, _ := newTestRepo(t, map[string]string{"check.sh": "exit 0\n"})
+
+	_, err := NewLocalValidator(repo, "", "sh check.sh", time.Minute).ValidateBranch(context.Background(), "work",
+		"0123456789abcdef0123456789abcdef01234567")
+	require.ErrorContains(t, err, "failed to check out commit")
+	requireNoValidationDirs(t)
+}
+
+func TestLocalValidator_CollectsCoverage(t *testing.T) {
+	t.Setenv("TMPDIR", t.TempDir())
+	repo, sha := newTestRepo(t, map[string]string{
+		"check.sh": "printf 'SF:%s/widget.js\\nDA:1,1\\nDA:2,0\\nend_of_record\\n' \"$PWD\" > lcov.info\n",
+	})
+
+	lv := NewLocalValidator(repo, "", "sh check.sh", time.Minute).WithCoverageFile("lcov.info")
+	result, err := lv.ValidateBranch(context.Background(), "work", sha)
+	require.NoError(t, err)
+	require.True(t, result.Succeeded, result.Details)
+	require.Equal(t, Coverage{"widget.js": {
+		Covered:   []LineRange{{Start: 1, End: 1}},
+		Uncovered: []LineRange{{Start: 2, End: 2}},
+	}}, result.Coverage)
+}
+
+func TestLocalValidator_NoCoverageFile(t *testing.T) {
+	t.Setenv("TMPDIR", t.TempDir())
+	repo, sha := newTestRepo(t, map[string]string{
+		"check.sh": "printf 'SF:%s/widget.js\nDA:1,1\nend_of_record\n' \"$PWD\" > lcov.info\n",
+	})
+
+	result, err := NewLocalValidator(repo, "", "sh check.sh", time.Minute).ValidateBranch(context.Background(), "work", sha)
+	require.NoError(t, err)
+	require.Nil(t, result.Coverage, "coverage should only be collected if a report is configured")
+}
+
+func TestTail(t *testing.T) {
+	require.Equal(t, "short", tail("short", 10))
+	require.Equal(t, "[... earlier output truncated ...]\nend", tail("the end", 3))
+}
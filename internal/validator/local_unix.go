@@ -0,0 +1,17 @@
+//go:build unix
+
+package validator
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// killProcessGroupOnCancel runs the given command in its own process group and makes cancelling it kill the whole
+// group, so that processes started by the command, e.g. test binaries, don't outlive it
+func killProcessGroupOnCancel(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+}
@@ -3,11 +3,13 @@ package validator
 import (
 	"bufio"
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
 	"net/url"
+	"slices"
 	"strings"
 	"time"
 
@@ -17,8 +19,26 @@ import (
 type ValidationResult struct {
 	Succeeded bool
 	Details   string
+	// NoChanges is set by workspaces when there were no meaningful local changes to commit, so the result is that of
+	// the existing head of the work branch
+	NoChanges bool
+	// Coverage is the test coverage measured by the validation run, or nil if none was, e.g. because the validator
+	// doesn't measure coverage
+	Coverage Coverage
 }
 
+// ErrScopedTestsUnsupported is returned when asked to run a subset of tests by a validator that can't
+var ErrScopedTestsUnsupported = errors.New("running a subset of tests is not supported")
+
+// testTargetInput is the name of the workflow_dispatch input through which RunTests tells the validation workflow which
+// tests to run. Workflows that support scoped test runs must declare it
+const testTargetInput = "test_target"
+
+// scopedRunTitlePrefix starts the titles of workflow runs that only ran some of the tests. Workflows that support scoped
+// test runs must set their run-name from the test_target input with this prefix, so that the runs are never mistaken
+// for validation of the commits they ran on, even by another validator or after a restart
+const scopedRunTitlePrefix = "[scoped]"
+
 type GithubActionCommitValidator struct {
 	githubClient     *github.Client
 	owner            string
@@ -39,7 +59,7 @@ func (gacv GithubActionCommitValidator) ValidateBranch(ctx context.Context, bran
 	log.Printf("Validating branch '%s' with workflow '%s'", branch, gacv.workflowFileName)
 
 	// Find existing run for this commit, if any
-	run, err := gacv.findWorkflowRun(ctx, commitSHA)
+	run, err := gacv.findWorkflowRun(ctx, commitSHA, isPartialRun)
 	if err != nil {
 		return ValidationResult{}, fmt.Errorf("failed to find workflow run: %w", err)
 	}
@@ -60,14 +80,82 @@ func (gacv GithubActionCommitValidator) ValidateBranch(ctx context.Context, bran
 		return ValidationResult{}, fmt.Errorf("unexpected nil in workflow run")
 	}
 
-	run, err = gacv.waitForWorkflowCompletion(ctx, *run.ID)
+	return gacv.awaitResult(ctx, *run.ID, false)
+}
+
+// RunTests runs the subset of the tests identified by target, e.g. a package path or a test name pattern, against the
+// given commit, which is expected to be the head of the given branch. The target is passed to the validation workflow
+// as its test_target input. Returns ErrScopedTestsUnsupported if the workflow doesn't declare that input, or doesn't
+// mark the run as scoped in its run-name
+func (gacv GithubActionCommitValidator) RunTests(ctx context.Context, branch string, commitSHA string, target string) (ValidationResult, error) {
+	log.Printf("Running tests '%s' on branch '%s' with workflow '%s'", target, branch, gacv.workflowFileName)
+
+	// Note the runs that already exist for this commit, so that we can tell which one we start
+	existing := map[int64]bool{}
+	runs, err := gacv.listWorkflowRuns(ctx, commitSHA)
+	if err != nil {
+		return ValidationResult{}, err
+	}
+	for _, run := range runs {
+		existing[run.GetID()] = true
+	}
+
+	req := github.CreateWorkflowDispatchEventRequest{
+		Ref:    branch,
+		Inputs: map[string]any{testTargetInput: target},
+	}
+	_, err = gacv.githubClient.Actions.CreateWorkflowDispatchEventByFileName(ctx, gacv.owner, gacv.repo, gacv.workflowFileName, req)
+	if err != nil {
+		var errResp *github.ErrorResponse
+		if errors.As(err, &errResp) && errResp.Response != nil &&
+			errResp.Response.StatusCode == http.StatusUnprocessableEntity &&
+			strings.Contains(errResp.Message, "Unexpected inputs") {
+			return ValidationResult{}, fmt.Errorf("workflow '%s' has no '%s' input: %w", gacv.workflowFileName,
+				testTargetInput, ErrScopedTestsUnsupported)
+		}
+		return ValidationResult{}, fmt.Errorf("failed to trigger workflow run: %w", err)
+	}
+
+	run, err := gacv.waitForWorkflowStart(ctx, commitSHA, func(run *github.WorkflowRun) bool { return existing[run.GetID()] })
+	if err != nil {
+		return ValidationResult{}, err
+	}
+	if !isScopedRun(run) {
+		// Nothing durable would tell this run apart from full validation, so don't let it finish
+		_, err := gacv.githubClient.Actions.CancelWorkflowRunByID(ctx, gacv.owner, gacv.repo, run.GetID())
+		var acceptedErr *github.AcceptedError
+		if err != nil && !errors.As(err, &acceptedErr) {
+			return ValidationResult{}, fmt.Errorf("failed to cancel unmarked scoped workflow run %d: %w", run.GetID(), err)
+		}
+		return ValidationResult{}, fmt.Errorf("workflow '%s' doesn't start its run-name with '%s' when '%s' is set: %w",
+			gacv.workflowFileName, scopedRunTitlePrefix, testTargetInput, ErrScopedTestsUnsupported)
+	}
+
+	return gacv.awaitResult(ctx, run.GetID(), true)
+}
+
+// isScopedRun returns whether the given workflow run was started by RunTests, according to its title
+func isScopedRun(run *github.WorkflowRun) bool {
+	return strings.HasPrefix(run.GetDisplayTitle(), scopedRunTitlePrefix)
+}
+
+// isPartialRun returns whether the given workflow run can't count as validation of its commit: it only ran some of the
+// tests, or it was cancelled
+func isPartialRun(run *github.WorkflowRun) bool {
+	return isScopedRun(run) || run.GetConclusion() == string(workflowConclusionCancelled)
+}
+
+// awaitResult waits for the given workflow run to complete and returns its result. The run's logs are included in the
+// result if it failed, or always if alwaysIncludeLogs is true
+func (gacv GithubActionCommitValidator) awaitResult(ctx context.Context, runID int64, alwaysIncludeLogs bool) (ValidationResult, error) {
+	run, err := gacv.waitForWorkflowCompletion(ctx, runID)
 	if err != nil {
 		return ValidationResult{}, err
 	}
 
 	succeeded := run.GetConclusion() == string(workflowConclusionSuccess)
 	var logs string
-	if !succeeded {
+	if !succeeded || alwaysIncludeLogs {
 		logs, err = gacv.getWorkflowRunLogs(ctx, run)
 		if err != nil {
 			return ValidationResult{}, fmt.Errorf("failed to get workflow run logs: %w", err)
@@ -80,8 +168,8 @@ func (gacv GithubActionCommitValidator) ValidateBranch(ctx context.Context, bran
 	}, nil
 }
 
-// findWorkflowRun returns one workflow run for the given commit. If no workflow run exists, returns (nil, nil)
-func (gacv GithubActionCommitValidator) findWorkflowRun(ctx context.Context, commitSHA string) (*github.WorkflowRun, error) {
+// listWorkflowRuns returns recent runs of the workflow for the given commit, most recent first
+func (gacv GithubActionCommitValidator) listWorkflowRuns(ctx context.Context, commitSHA string) ([]*github.WorkflowRun, error) {
 	opts := &github.ListWorkflowRunsOptions{
 		HeadSHA:     commitSHA,
 		ListOptions: github.ListOptions{PerPage: 10},
@@ -93,14 +181,25 @@ func (gacv GithubActionCommitValidator) findWorkflowRun(ctx context.Context, com
 	if runs == nil || runs.TotalCount == nil {
 		return nil, fmt.Errorf("unexpected nil")
 	}
-	if *runs.TotalCount == 0 {
+	return runs.WorkflowRuns, nil
+}
+
+// findWorkflowRun returns one workflow run for the given commit, ignoring runs for which exclude returns true. If no
+// such workflow run exists, returns (nil, nil)
+func (gacv GithubActionCommitValidator) findWorkflowRun(ctx context.Context, commitSHA string, exclude func(*github.WorkflowRun) bool) (*github.WorkflowRun, error) {
+	runs, err := gacv.listWorkflowRuns(ctx, commitSHA)
+	if err != nil {
+		return nil, err
+	}
+	runs = slices.DeleteFunc(runs, exclude)
+	if len(runs) == 0 {
 		return nil, nil
-	} else if *runs.TotalCount > 1 {
+	} else if len(runs) > 1 {
 		log.Printf("Warning: multiple workflow runs found, picking one")
 	}
 
 	// Pick the least recent run, since it's the most likely to be done already
-	return runs.WorkflowRuns[len(runs.WorkflowRuns)-1], nil
+	return runs[len(runs)-1], nil
 }
 
 // triggerWorkflowRun triggers a workflow run for the given branch. A run will start on the head of the branch, which is
@@ -115,7 +214,7 @@ func (gacv GithubActionCommitValidator) triggerWorkflowRun(ctx context.Context,
 		return nil, fmt.Errorf("failed to trigger workflow run: %w", err)
 	}
 
-	run, err := gacv.waitForWorkflowStart(ctx, headSHA)
+	run, err := gacv.waitForWorkflowStart(ctx, headSHA, isPartialRun)
 	if err != nil {
 		return nil, err
 	}
@@ -123,7 +222,9 @@ func (gacv GithubActionCommitValidator) triggerWorkflowRun(ctx context.Context,
 	return run, nil
 }
 
-func (gacv GithubActionCommitValidator) waitForWorkflowStart(ctx context.Context, headSHA string) (*github.WorkflowRun, error) {
+// waitForWorkflowStart waits for a workflow run to be created for the given commit, ignoring runs for which exclude
+// returns true
+func (gacv GithubActionCommitValidator) waitForWorkflowStart(ctx context.Context, headSHA string, exclude func(*github.WorkflowRun) bool) (*github.WorkflowRun, error) {
 	pollInterval := 2 * time.Second
 	timeout := 200 * time.Second
 
@@ -136,7 +237,7 @@ func (gacv GithubActionCommitValidator) waitForWorkflowStart(ctx context.Context
 	defer cancel()
 
 	for {
-		run, err := gacv.findWorkflowRun(timeoutCtx, headSHA)
+		run, err := gacv.findWorkflowRun(timeoutCtx, headSHA, exclude)
 		if err != nil {
 			return nil, fmt.Errorf("error while searching for started workflow run: %w", err)
 		}
@@ -294,6 +395,7 @@ const (
 type workflowConclusion string
 
 const (
-	workflowConclusionSuccess workflowConclusion = "success"
-	workflowConclusionFailure workflowConclusion = "failure"
+	workflowConclusionSuccess   workflowConclusion = "success"
+	workflowConclusionFailure   workflowConclusion = "failure"
+	workflowConclusionCancelled workflowConclusion = "cancelled"
 )
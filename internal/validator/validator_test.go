@@ -0,0 +1,115 @@
+package validator
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/google/go-github/v72/github"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeWorkflow is a fake GitHub Actions API for a single workflow. Each dispatch starts a run with the given title and
+// conclusion, which completes immediately
+type fakeWorkflow struct {
+	mu         sync.Mutex
+	runs       []*github.WorkflowRun
+	dispatches []map[string]any
+	cancelled  []int64
+
+	// dispatchedRun returns the title and conclusion of the run started by a dispatch with the given inputs
+	dispatchedRun func(inputs map[string]any) (title string, conclusion string)
+}
+
+func (fw *fakeWorkflow) addRun(title string, conclusion string) {
+	fw.runs = append([]*github.WorkflowRun{{
+		ID:           github.Ptr(int64(len(fw.runs) + 1)),
+		DisplayTitle: github.Ptr(title),
+		Status:       github.Ptr("completed"),
+		Conclusion:   github.Ptr(conclusion),
+	}}, fw.runs...)
+}
+
+func (fw *fakeWorkflow) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	fw.mu.Lock()
+	defer fw.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	path := strings.TrimPrefix(r.URL.Path, "/repos/owner/repo/actions/")
+	switch {
+	case r.Method == http.MethodGet && path == "workflows/validate.yml/runs":
+		_ = json.NewEncoder(w).Encode(github.WorkflowRuns{TotalCount: github.Ptr(len(fw.runs)), WorkflowRuns: fw.runs})
+	case r.Method == http.MethodPost && path == "workflows/validate.yml/dispatches":
+		var req github.CreateWorkflowDispatchEventRequest
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		fw.dispatches = append(fw.dispatches, req.Inputs)
+		fw.addRun(fw.dispatchedRun(req.Inputs))
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == http.MethodPost && strings.HasSuffix(path, "/cancel"):
+		var id int64
+		_, _ = fmt.Sscanf(path, "runs/%d/cancel", &id)
+		fw.cancelled = append(fw.cancelled, id)
+		w.WriteHeader(http.StatusAccepted)
+		_, _ = w.Write([]byte(`{}`))
+	case r.Method == http.MethodGet && strings.HasSuffix(path, "/jobs"):
+		_, _ = w.Write([]byte(`{"total_count": 0, "jobs": []}`))
+	case r.Method == http.MethodGet && strings.HasPrefix(path, "runs/"):
+		for _, run := range fw.runs {
+			if path == fmt.Sprintf("runs/%d", run.GetID()) {
+				_ = json.NewEncoder(w).Encode(run)
+				return
+			}
+		}
+		w.WriteHeader(http.StatusNotFound)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+// newTestGithubActionCommitValidator returns a validator backed by the given fake workflow
+func newTestGithubActionCommitValidator(t *testing.T, fw *fakeWorkflow) GithubActionCommitValidator {
+	server := httptest.NewServer(fw)
+	t.Cleanup(server.Close)
+
+	client := github.NewClient(nil)
+	baseURL, err := url.Parse(server.URL + "/")
+	require.NoError(t, err)
+	client.BaseURL = baseURL
+	return NewGithubActionCommitValidator(client, "owner", "repo", "validate.yml")
+}
+
+func TestGithubActionCommitValidator_ScopedRunIsNotValidationForFreshValidator(t *testing.T) {
+	fw := &fakeWorkflow{dispatchedRun: func(inputs map[string]any) (string, string) {
+		if target, ok := inputs[testTargetInput]; ok {
+			return fmt.Sprintf("[scoped] %v", target), "success"
+		}
+		return "Validate", "failure"
+	}}
+
+	result, err := newTestGithubActionCommitValidator(t, fw).RunTests(context.Background(), "work", "abc123", "./internal/bot")
+	require.NoError(t, err)
+	require.True(t, result.Succeeded)
+
+	// A validator that didn't start the scoped run, e.g. after a restart, must not mistake it for full validation
+	result, err = newTestGithubActionCommitValidator(t, fw).ValidateBranch(context.Background(), "work", "abc123")
+	require.NoError(t, err)
+	require.False(t, result.Succeeded, "the result should be that of a new full run")
+	require.Len(t, fw.dispatches, 2)
+	require.Nil(t, fw.dispatches[1])
+}
+
+func TestGithubActionCommitValidator_UnmarkedScopedRunIsCancelled(t *testing.T) {
+	fw := &fakeWorkflow{dispatchedRun: func(inputs map[string]any) (string, string) {
+		return "Validate", "success"
+	}}
+
+	_, err := newTestGithubActionCommitValidator(t, fw).RunTests(context.Background(), "work", "abc123", "./internal/bot")
+	require.ErrorIs(t, err, ErrScopedTestsUnsupported)
+	require.Equal(t, []int64{1}, fw.cancelled)
+}
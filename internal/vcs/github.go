@@ -0,0 +1,423 @@
+package vcs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/google/go-github/v72/github"
+)
+
+// GithubProvider is a Provider backed by the GitHub REST API
+type GithubProvider struct {
+	client *github.Client
+}
+
+var _ Provider = (*GithubProvider)(nil)
+
+// NewGithubProvider creates a Provider that reads and makes changes using the given GitHub client
+func NewGithubProvider(client *github.Client) *GithubProvider {
+	return &GithubProvider{client: client}
+}
+
+func (gp *GithubProvider) GetRepository(ctx context.Context, owner string, repo string) (*github.Repository, error) {
+	repository, resp, err := gp.client.Repositories.Get(ctx, owner, repo)
+	return repository, classifyError(resp, err)
+}
+
+func (gp *GithubProvider) GetBranchHead(ctx context.Context, owner string, repo string, branch string) (string, error) {
+	b, resp, err := gp.client.Repositories.GetBranch(ctx, owner, repo, branch, 1)
+	if err != nil {
+		return "", classifyError(resp, err)
+	}
+	return b.GetCommit().GetSHA(), nil
+}
+
+func (gp *GithubProvider) GetFileContents(ctx context.Context, owner string, repo string, path string, ref string) (string, error) {
+	var opts *github.RepositoryContentGetOptions
+	if ref != "" {
+		opts = &github.RepositoryContentGetOptions{Ref: ref}
+	}
+	content, _, resp, err := gp.client.Repositories.GetContents(ctx, owner, repo, path, opts)
+	if err != nil {
+		return "", classifyError(resp, err)
+	}
+	if content == nil {
+		return "", fmt.Errorf("%s is not a file", path)
+	}
+	return content.GetContent()
+}
+
+func (gp *GithubProvider) GetReadme(ctx context.Context, owner string, repo string, ref string) (string, error) {
+	readme, resp, err := gp.client.Repositories.GetReadme(ctx, owner, repo, &github.RepositoryContentGetOptions{Ref: ref})
+	if err != nil {
+		return "", classifyError(resp, err)
+	}
+	return readme.GetContent()
+}
+
+func (gp *GithubProvider) GetTree(ctx context.Context, owner string, repo string, ref string) ([]*github.TreeEntry, error) {
+	tree, resp, err := gp.client.Git.GetTree(ctx, owner, repo, ref, true)
+	if err != nil {
+		return nil, classifyError(resp, err)
+	}
+	return tree.Entries, nil
+}
+
+func (gp *GithubProvider) ListLanguages(ctx context.Context, owner string, repo string) (map[string]int, error) {
+	languages, resp, err := gp.client.Repositories.ListLanguages(ctx, owner, repo)
+	return languages, classifyError(resp, err)
+}
+
+func (gp *GithubProvider) GetPermissionLevel(ctx context.Context, owner string, repo string, login string) (string, error) {
+	permission, resp, err := gp.client.Repositories.GetPermissionLevel(ctx, owner, repo, login)
+	if err != nil {
+		return "", classifyError(resp, err)
+	}
+	return permission.GetPermission(), nil
+}
+
+func (gp *GithubProvider) IsOrganizationMember(ctx context.Context, org string, login string) (bool, error) {
+	member, resp, err := gp.client.Organizations.IsMember(ctx, org, login)
+	return member, classifyError(resp, err)
+}
+
+func (gp *GithubProvider) GetIssue(ctx context.Context, owner string, repo string, number int) (*github.Issue, error) {
+	issue, resp, err := gp.client.Issues.Get(ctx, owner, repo, number)
+	return issue, classifyError(resp, err)
+}
+
+func (gp *GithubProvider) ListComments(ctx context.Context, owner string, repo string, number int) ([]*github.IssueComment, error) {
+	opts := &github.IssueListCommentsOptions{
+		Sort:        github.Ptr("created"),
+		Direction:   github.Ptr("asc"),
+		ListOptions: github.ListOptions{PerPage: 100},
+	}
+	var all []*github.IssueComment
+	for {
+		comments, resp, err := gp.client.Issues.ListComments(ctx, owner, repo, number, opts)
+		if err != nil {
+			return nil, classifyError(resp, err)
+		}
+		all = append(all, comments...)
+		if resp.NextPage == 0 {
+			return all, nil
+		}
+		opts.Page = resp.NextPage
+	}
+}
+
+func (gp *GithubProvider) ListCommentReactions(ctx context.Context, owner string, repo string, commentID int64) ([]*github.Reaction, error) {
+	opts := &github.ListReactionOptions{ListOptions: github.ListOptions{PerPage: 100}}
+	var all []*github.Reaction
+	for {
+		reactions, resp, err := gp.client.Reactions.ListIssueCommentReactions(ctx, owner, repo, commentID, opts)
+		if err != nil {
+			return nil, classifyError(resp, err)
+		}
+		all = append(all, reactions...)
+		if resp.NextPage == 0 {
+			return all, nil
+		}
+		opts.Page = resp.NextPage
+	}
+}
+
+func (gp *GithubProvider) ListIssueEvents(ctx context.Context, owner string, repo string, number int) ([]*github.IssueEvent, error) {
+	opts := &github.ListOptions{PerPage: 100}
+	var all []*github.IssueEvent
+	for {
+		events, resp, err := gp.client.Issues.ListIssueEvents(ctx, owner, repo, number, opts)
+		if err != nil {
+			return nil, classifyError(resp, err)
+		}
+		all = append(all, events...)
+		if resp.NextPage == 0 {
+			return all, nil
+		}
+		opts.Page = resp.NextPage
+	}
+}
+
+func (gp *GithubProvider) GetPullRequest(ctx context.Context, owner string, repo string, prNumber int) (*github.PullRequest, error) {
+	pr, resp, err := gp.client.PullRequests.Get(ctx, owner, repo, prNumber)
+	return pr, classifyError(resp, err)
+}
+
+func (gp *GithubProvider) ListOpenPullRequests(ctx context.Context, owner string, repo string) ([]*github.PullRequest, error) {
+	opts := &github.PullRequestListOptions{State: "open", ListOptions: github.ListOptions{PerPage: 100}}
+	var all []*github.PullRequest
+	for {
+		prs, resp, err := gp.client.PullRequests.List(ctx, owner, repo, opts)
+		if err != nil {
+			return nil, classifyError(resp, err)
+		}
+		all = append(all, prs...)
+		if resp.NextPage == 0 {
+			return all, nil
+		}
+		opts.Page = resp.NextPage
+	}
+}
+
+func (gp *GithubProvider) FindPullRequests(ctx context.Context, owner string, repo string, branch string, author string) ([]*github.Issue, error) {
+	query := fmt.Sprintf("type:pr repo:%s/%s head:%s author:%s", owner, repo, branch, author)
+	opts := &github.SearchOptions{
+		Sort:        "created",
+		Order:       "desc",
+		ListOptions: github.ListOptions{PerPage: 50},
+	}
+	result, resp, err := gp.client.Search.Issues(ctx, query, opts)
+	if err != nil {
+		return nil, classifyError(resp, err)
+	}
+	return result.Issues, nil
+}
+
+func (gp *GithubProvider) ListReviews(ctx context.Context, owner string, repo string, prNumber int) ([]*github.PullRequestReview, error) {
+	opts := &github.ListOptions{PerPage: 100}
+	var all []*github.PullRequestReview
+	for {
+		reviews, resp, err := gp.client.PullRequests.ListReviews(ctx, owner, repo, prNumber, opts)
+		if err != nil {
+			return nil, classifyError(resp, err)
+		}
+		all = append(all, reviews...)
+		if resp.NextPage == 0 {
+			return all, nil
+		}
+		opts.Page = resp.NextPage
+	}
+}
+
+func (gp *GithubProvider) ListReviewComments(ctx context.Context, owner string, repo string, prNumber int) ([]*github.PullRequestComment, error) {
+	opts := &github.PullRequestListCommentsOptions{
+		Sort:        "created",
+		Direction:   "asc",
+		ListOptions: github.ListOptions{PerPage: 100},
+	}
+	var all []*github.PullRequestComment
+	for {
+		comments, resp, err := gp.client.PullRequests.ListComments(ctx, owner, repo, prNumber, opts)
+		if err != nil {
+			return nil, classifyError(resp, err)
+		}
+		all = append(all, comments...)
+		if resp.NextPage == 0 {
+			return all, nil
+		}
+		opts.Page = resp.NextPage
+	}
+}
+
+func (gp *GithubProvider) ListReviewCommentReactions(ctx context.Context, owner string, repo string, commentID int64) ([]*github.Reaction, error) {
+	opts := &github.ListReactionOptions{ListOptions: github.ListOptions{PerPage: 100}}
+	var all []*github.Reaction
+	for {
+		reactions, resp, err := gp.client.Reactions.ListPullRequestCommentReactions(ctx, owner, repo, commentID, opts)
+		if err != nil {
+			return nil, classifyError(resp, err)
+		}
+		all = append(all, reactions...)
+		if resp.NextPage == 0 {
+			return all, nil
+		}
+		opts.Page = resp.NextPage
+	}
+}
+
+func (gp *GithubProvider) CreateComment(ctx context.Context, owner string, repo string, number int, body string) error {
+	comment := &github.IssueComment{
+		Body: github.Ptr(body),
+	}
+	_, resp, err := gp.client.Issues.CreateComment(ctx, owner, repo, number, comment)
+	return classifyError(resp, err)
+}
+
+func (gp *GithubProvider) ReplyToReviewComment(ctx context.Context, owner string, repo string, prNumber int, commentID int64, body string) error {
+	_, resp, err := gp.client.PullRequests.CreateCommentInReplyTo(ctx, owner, repo, prNumber, body, commentID)
+	return classifyError(resp, err)
+}
+
+func (gp *GithubProvider) AddCommentReaction(ctx context.Context, owner string, repo string, commentID int64, reaction string) error {
+	_, resp, err := gp.client.Reactions.CreateIssueCommentReaction(ctx, owner, repo, commentID, reaction)
+	return classifyError(resp, err)
+}
+
+func (gp *GithubProvider) AddReviewCommentReaction(ctx context.Context, owner string, repo string, commentID int64, reaction string) error {
+	_, resp, err := gp.client.Reactions.CreatePullRequestCommentReaction(ctx, owner, repo, commentID, reaction)
+	return classifyError(resp, err)
+}
+
+func (gp *GithubProvider) EnsureLabel(ctx context.Context, owner string, repo string, label Label) error {
+	_, _, err := gp.client.Issues.GetLabel(ctx, owner, repo, label.Name)
+	if err == nil {
+		return nil
+	}
+
+	githubLabel := &github.Label{
+		Name:        github.Ptr(label.Name),
+		Description: github.Ptr(label.Description),
+		Color:       github.Ptr(label.Color),
+	}
+	_, resp, err := gp.client.Issues.CreateLabel(ctx, owner, repo, githubLabel)
+	if err != nil && resp != nil && resp.StatusCode == http.StatusUnprocessableEntity && isAlreadyExistsError(err) {
+		// Another worker created the label between our check and our attempt to create it
+		return nil
+	}
+	return classifyError(resp, err)
+}
+
+func (gp *GithubProvider) AddLabels(ctx context.Context, owner string, repo string, number int, labels []string) error {
+	_, resp, err := gp.client.Issues.AddLabelsToIssue(ctx, owner, repo, number, labels)
+	return classifyError(resp, err)
+}
+
+func (gp *GithubProvider) RemoveLabel(ctx context.Context, owner string, repo string, number int, label string) error {
+	resp, err := gp.client.Issues.RemoveLabelForIssue(ctx, owner, repo, number, label)
+	return classifyError(resp, err)
+}
+
+func (gp *GithubProvider) AddAssignees(ctx context.Context, owner string, repo string, number int, logins []string) ([]string, error) {
+	issue, resp, err := gp.client.Issues.AddAssignees(ctx, owner, repo, number, logins)
+	if err != nil {
+		return nil, classifyError(resp, err)
+	}
+	return assigneeLogins(issue), nil
+}
+
+func (gp *GithubProvider) RemoveAssignees(ctx context.Context, owner string, repo string, number int, logins []string) ([]string, error) {
+	issue, resp, err := gp.client.Issues.RemoveAssignees(ctx, owner, repo, number, logins)
+	if err != nil {
+		return nil, classifyError(resp, err)
+	}
+	return assigneeLogins(issue), nil
+}
+
+// assigneeLogins returns the logins of the users assigned to the given issue
+func assigneeLogins(issue *github.Issue) []string {
+	var logins []string
+	for _, user := range issue.Assignees {
+		logins = append(logins, user.GetLogin())
+	}
+	return logins
+}
+
+func (gp *GithubProvider) CreateIssue(ctx context.Context, owner string, repo string, issue NewIssue) (int, string, error) {
+	req := &github.IssueRequest{
+		Title: github.Ptr(issue.Title),
+		Body:  github.Ptr(issue.Body),
+	}
+	if len(issue.Labels) > 0 {
+		req.Labels = &issue.Labels
+	}
+	created, resp, err := gp.client.Issues.Create(ctx, owner, repo, req)
+	if err != nil {
+		return 0, "", classifyError(resp, err)
+	}
+	return created.GetNumber(), created.GetHTMLURL(), nil
+}
+
+func (gp *GithubProvider) SetIssueState(ctx context.Context, owner string, repo string, number int, state IssueState, reason string) error {
+	req := &github.IssueRequest{
+		State: github.Ptr(string(state)),
+	}
+	if reason != "" {
+		req.StateReason = github.Ptr(reason)
+	}
+	_, resp, err := gp.client.Issues.Edit(ctx, owner, repo, number, req)
+	return classifyError(resp, err)
+}
+
+func (gp *GithubProvider) EditPullRequest(ctx context.Context, owner string, repo string, prNumber int, edit PullRequestEdit) error {
+	pr := &github.PullRequest{}
+	if edit.Title != "" {
+		pr.Title = github.Ptr(edit.Title)
+	}
+	if edit.Body != "" {
+		pr.Body = github.Ptr(edit.Body)
+	}
+	if edit.Base != "" {
+		pr.Base = &github.PullRequestBranch{Ref: github.Ptr(edit.Base)}
+	}
+	_, resp, err := gp.client.PullRequests.Edit(ctx, owner, repo, prNumber, pr)
+	return classifyError(resp, err)
+}
+
+func (gp *GithubProvider) RequestReviewers(ctx context.Context, owner string, repo string, prNumber int, reviewers Reviewers) (Reviewers, error) {
+	req := github.ReviewersRequest{
+		Reviewers:     reviewers.Users,
+		TeamReviewers: reviewers.Teams,
+	}
+	pr, resp, err := gp.client.PullRequests.RequestReviewers(ctx, owner, repo, prNumber, req)
+	if err != nil {
+		return Reviewers{}, classifyError(resp, err)
+	}
+
+	var requested Reviewers
+	for _, user := range pr.RequestedReviewers {
+		requested.Users = append(requested.Users, user.GetLogin())
+	}
+	for _, team := range pr.RequestedTeams {
+		requested.Teams = append(requested.Teams, team.GetSlug())
+	}
+	return requested, nil
+}
+
+func (gp *GithubProvider) SubmitReview(ctx context.Context, owner string, repo string, prNumber int, event ReviewEvent, body string) error {
+	review := &github.PullRequestReviewRequest{
+		Event: github.Ptr(string(event)),
+		Body:  github.Ptr(body),
+	}
+	_, resp, err := gp.client.PullRequests.CreateReview(ctx, owner, repo, prNumber, review)
+	return classifyError(resp, err)
+}
+
+func (gp *GithubProvider) SubmitReviewWithComments(ctx context.Context, owner string, repo string, prNumber int, event ReviewEvent, body string, comments []LineComment) error {
+	draftComments := make([]*github.DraftReviewComment, 0, len(comments))
+	for _, c := range comments {
+		draftComments = append(draftComments, &github.DraftReviewComment{
+			Path: github.Ptr(c.Path),
+			Line: github.Ptr(c.Line),
+			Side: github.Ptr(c.Side),
+			Body: github.Ptr(c.Body),
+		})
+	}
+	review := &github.PullRequestReviewRequest{
+		Event:    github.Ptr(string(event)),
+		Body:     github.Ptr(body),
+		Comments: draftComments,
+	}
+	_, resp, err := gp.client.PullRequests.CreateReview(ctx, owner, repo, prNumber, review)
+	return classifyError(resp, err)
+}
+
+// classifyError wraps errors from the GitHub API in ErrNotFound or ErrInvalid according to the response status
+func classifyError(resp *github.Response, err error) error {
+	if err == nil || resp == nil {
+		return err
+	}
+	switch resp.StatusCode {
+	case http.StatusNotFound:
+		return fmt.Errorf("%w: %w", ErrNotFound, err)
+	case http.StatusUnprocessableEntity:
+		return fmt.Errorf("%w: %w", ErrInvalid, err)
+	default:
+		return err
+	}
+}
+
+// isAlreadyExistsError returns true if err is a GitHub validation error reporting that a resource already exists
+func isAlreadyExistsError(err error) bool {
+	var errResp *github.ErrorResponse
+	if !errors.As(err, &errResp) {
+		return false
+	}
+	for _, e := range errResp.Errors {
+		if e.Code == "already_exists" {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,341 @@
+package vcs
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/google/go-github/v72/github"
+	"github.com/stretchr/testify/require"
+)
+
+// recordedRequest is a request received by the fake GitHub server
+type recordedRequest struct {
+	method string
+	path   string
+	body   any
+}
+
+// newTestGithubProvider returns a provider backed by a fake GitHub server that records the requests it receives and
+// responds to each with the given status
+func newTestGithubProvider(t *testing.T, status int) (*GithubProvider, *[]recordedRequest) {
+	var requests []recordedRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body any
+		if r.ContentLength > 0 {
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+		}
+		requests = append(requests, recordedRequest{method: r.Method, path: r.URL.Path, body: body})
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		if status >= 400 {
+			_, _ = w.Write([]byte(`{"message": "error"}`))
+		} else if strings.HasSuffix(r.URL.Path, "/labels") {
+			_, _ = w.Write([]byte(`[]`))
+		} else {
+			_, _ = w.Write([]byte(`{}`))
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	client := github.NewClient(nil)
+	baseURL, err := url.Parse(server.URL + "/")
+	require.NoError(t, err)
+	client.BaseURL = baseURL
+	return NewGithubProvider(client), &requests
+}
+
+// newTestGithubReadProvider returns a provider backed by a fake GitHub server that serves the given responses by path,
+// and responds to requests for other paths with 404 Not Found. A response for a path with "?page=2" appended is served
+// as the path's second page
+func newTestGithubReadProvider(t *testing.T, responses map[string]string) *GithubProvider {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		path := r.URL.Path
+		if page := r.URL.Query().Get("page"); page != "" && page != "1" {
+			path += "?page=" + page
+		} else if _, ok := responses[r.URL.Path+"?page=2"]; ok {
+			w.Header().Set("Link", fmt.Sprintf(`<http://%s%s?page=2>; rel="next"`, r.Host, r.URL.Path))
+		}
+		response, ok := responses[path]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte(`{"message": "Not Found"}`))
+			return
+		}
+		_, _ = w.Write([]byte(response))
+	}))
+	t.Cleanup(server.Close)
+
+	client := github.NewClient(nil)
+	baseURL, err := url.Parse(server.URL + "/")
+	require.NoError(t, err)
+	client.BaseURL = baseURL
+	return NewGithubProvider(client)
+}
+
+func TestGithubProvider_GetFileContents(t *testing.T) {
+	encoded := base64.StdEncoding.EncodeToString([]byte("Be nice"))
+	provider := newTestGithubReadProvider(t, map[string]string{
+		"/repos/owner/repo/contents/CONTRIBUTING.md": fmt.Sprintf(`{"type": "file", "encoding": "base64", "content": "%s"}`, encoded),
+		"/repos/owner/repo/contents/docs":            `[{"type": "file", "name": "index.md"}]`,
+	})
+
+	content, err := provider.GetFileContents(context.Background(), "owner", "repo", "CONTRIBUTING.md", "abc123")
+	require.NoError(t, err)
+	require.Equal(t, "Be nice", content)
+
+	_, err = provider.GetFileContents(context.Background(), "owner", "repo", "STYLE.md", "")
+	require.ErrorIs(t, err, ErrNotFound)
+
+	_, err = provider.GetFileContents(context.Background(), "owner", "repo", "docs", "")
+	require.ErrorContains(t, err, "not a file")
+}
+
+func TestGithubProvider_GetBranchHead(t *testing.T) {
+	provider := newTestGithubReadProvider(t, map[string]string{
+		"/repos/owner/repo/branches/main": `{"name": "main", "commit": {"sha": "abc123"}}`,
+	})
+
+	head, err := provider.GetBranchHead(context.Background(), "owner", "repo", "main")
+	require.NoError(t, err)
+	require.Equal(t, "abc123", head)
+
+	_, err = provider.GetBranchHead(context.Background(), "owner", "repo", "release/9.9")
+	require.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestGithubProvider_ListComments_AllPages(t *testing.T) {
+	provider := newTestGithubReadProvider(t, map[string]string{
+		"/repos/owner/repo/issues/7/comments":        `[{"id": 1}, {"id": 2}]`,
+		"/repos/owner/repo/issues/7/comments?page=2": `[{"id": 3}]`,
+	})
+
+	comments, err := provider.ListComments(context.Background(), "owner", "repo", 7)
+	require.NoError(t, err)
+	var ids []int64
+	for _, comment := range comments {
+		ids = append(ids, comment.GetID())
+	}
+	require.Equal(t, []int64{1, 2, 3}, ids)
+}
+
+func TestGithubProvider_ListIssueEvents_AllPages(t *testing.T) {
+	provider := newTestGithubReadProvider(t, map[string]string{
+		"/repos/owner/repo/issues/7/events":        `[{"id": 1, "event": "labeled"}, {"id": 2, "event": "unlabeled"}]`,
+		"/repos/owner/repo/issues/7/events?page=2": `[{"id": 3, "event": "labeled"}]`,
+	})
+
+	events, err := provider.ListIssueEvents(context.Background(), "owner", "repo", 7)
+	require.NoError(t, err)
+	var ids []int64
+	for _, event := range events {
+		ids = append(ids, event.GetID())
+	}
+	require.Equal(t, []int64{1, 2, 3}, ids)
+}
+
+func TestGithubProvider_FindPullRequests(t *testing.T) {
+	var query string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query = r.URL.Query().Get("q")
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"items": [{"number": 5}, {"number": 3}]}`))
+	}))
+	t.Cleanup(server.Close)
+	client := github.NewClient(nil)
+	baseURL, err := url.Parse(server.URL + "/")
+	require.NoError(t, err)
+	client.BaseURL = baseURL
+
+	found, err := NewGithubProvider(client).FindPullRequests(context.Background(), "owner", "repo", "fix/issue-1", "bot")
+	require.NoError(t, err)
+	require.Equal(t, "type:pr repo:owner/repo head:fix/issue-1 author:bot", query)
+	require.Len(t, found, 2)
+	require.Equal(t, 5, found[0].GetNumber())
+}
+
+func TestGithubProvider_CreateComment(t *testing.T) {
+	provider, requests := newTestGithubProvider(t, http.StatusCreated)
+
+	require.NoError(t, provider.CreateComment(context.Background(), "owner", "repo", 7, "hello"))
+	require.Equal(t, []recordedRequest{
+		{method: http.MethodPost, path: "/repos/owner/repo/issues/7/comments", body: map[string]any{"body": "hello"}},
+	}, *requests)
+}
+
+func TestGithubProvider_ReplyToReviewComment(t *testing.T) {
+	provider, requests := newTestGithubProvider(t, http.StatusCreated)
+
+	require.NoError(t, provider.ReplyToReviewComment(context.Background(), "owner", "repo", 12, 100, "done"))
+	require.Equal(t, []recordedRequest{
+		{method: http.MethodPost, path: "/repos/owner/repo/pulls/12/comments", body: map[string]any{"body": "done", "in_reply_to": float64(100)}},
+	}, *requests)
+}
+
+func TestGithubProvider_AddReactions(t *testing.T) {
+	provider, requests := newTestGithubProvider(t, http.StatusCreated)
+
+	require.NoError(t, provider.AddCommentReaction(context.Background(), "owner", "repo", 100, "+1"))
+	require.NoError(t, provider.AddReviewCommentReaction(context.Background(), "owner", "repo", 200, "eyes"))
+	require.Equal(t, []recordedRequest{
+		{method: http.MethodPost, path: "/repos/owner/repo/issues/comments/100/reactions", body: map[string]any{"content": "+1"}},
+		{method: http.MethodPost, path: "/repos/owner/repo/pulls/comments/200/reactions", body: map[string]any{"content": "eyes"}},
+	}, *requests)
+}
+
+func TestGithubProvider_AddReaction_NotFound(t *testing.T) {
+	provider, _ := newTestGithubProvider(t, http.StatusNotFound)
+
+	err := provider.AddCommentReaction(context.Background(), "owner", "repo", 100, "+1")
+	require.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestGithubProvider_AddReaction_Invalid(t *testing.T) {
+	provider, _ := newTestGithubProvider(t, http.StatusUnprocessableEntity)
+
+	err := provider.AddReviewCommentReaction(context.Background(), "owner", "repo", 100, "shrug")
+	require.ErrorIs(t, err, ErrInvalid)
+}
+
+func TestGithubProvider_Labels(t *testing.T) {
+	provider, requests := newTestGithubProvider(t, http.StatusOK)
+
+	require.NoError(t, provider.AddLabels(context.Background(), "owner", "repo", 7, []string{"bug"}))
+	require.NoError(t, provider.RemoveLabel(context.Background(), "owner", "repo", 7, "wontfix"))
+	require.Equal(t, []recordedRequest{
+		{method: http.MethodPost, path: "/repos/owner/repo/issues/7/labels", body: []any{"bug"}},
+		{method: http.MethodDelete, path: "/repos/owner/repo/issues/7/labels/wontfix"},
+	}, *requests)
+}
+
+func TestGithubProvider_RemoveLabel_NotFound(t *testing.T) {
+	provider, _ := newTestGithubProvider(t, http.StatusNotFound)
+
+	err := provider.RemoveLabel(context.Background(), "owner", "repo", 7, "wontfix")
+	require.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestGithubProvider_EnsureLabel_Exists(t *testing.T) {
+	provider, requests := newTestGithubProvider(t, http.StatusOK)
+
+	require.NoError(t, provider.EnsureLabel(context.Background(), "owner", "repo", Label{Name: "bot-working"}))
+	require.Equal(t, []recordedRequest{{method: http.MethodGet, path: "/repos/owner/repo/labels/bot-working"}}, *requests)
+}
+
+func TestGithubProvider_EnsureLabel_CreatedConcurrently(t *testing.T) {
+	// Simulate another worker creating the label after our existence check
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.Method == http.MethodGet {
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte(`{"message": "Not Found"}`))
+			return
+		}
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		_, _ = w.Write([]byte(`{"message": "Validation Failed", "errors": [{"resource": "Label", "code": "already_exists", "field": "name"}]}`))
+	}))
+	t.Cleanup(server.Close)
+	client := github.NewClient(nil)
+	baseURL, err := url.Parse(server.URL + "/")
+	require.NoError(t, err)
+	client.BaseURL = baseURL
+
+	err = NewGithubProvider(client).EnsureLabel(context.Background(), "owner", "repo", Label{Name: "bot-working"})
+	require.NoError(t, err)
+}
+
+func TestGithubProvider_Assignees(t *testing.T) {
+	provider, requests := newTestGithubProvider(t, http.StatusCreated)
+
+	_, err := provider.AddAssignees(context.Background(), "owner", "repo", 7, []string{"alice"})
+	require.NoError(t, err)
+	_, err = provider.RemoveAssignees(context.Background(), "owner", "repo", 7, []string{"bob"})
+	require.NoError(t, err)
+	require.Equal(t, []recordedRequest{
+		{method: http.MethodPost, path: "/repos/owner/repo/issues/7/assignees", body: map[string]any{"assignees": []any{"alice"}}},
+		{method: http.MethodDelete, path: "/repos/owner/repo/issues/7/assignees", body: map[string]any{"assignees": []any{"bob"}}},
+	}, *requests)
+}
+
+func TestGithubProvider_CreateIssue(t *testing.T) {
+	provider, requests := newTestGithubProvider(t, http.StatusCreated)
+
+	_, _, err := provider.CreateIssue(context.Background(), "owner", "repo", NewIssue{Title: "Flaky test", Body: "It fails", Labels: []string{"bug"}})
+	require.NoError(t, err)
+	_, _, err = provider.CreateIssue(context.Background(), "owner", "repo", NewIssue{Title: "Slow build", Body: "It's slow"})
+	require.NoError(t, err)
+	require.Equal(t, []recordedRequest{
+		{method: http.MethodPost, path: "/repos/owner/repo/issues", body: map[string]any{"title": "Flaky test", "body": "It fails", "labels": []any{"bug"}}},
+		{method: http.MethodPost, path: "/repos/owner/repo/issues", body: map[string]any{"title": "Slow build", "body": "It's slow"}},
+	}, *requests)
+}
+
+func TestGithubProvider_SetIssueState(t *testing.T) {
+	provider, requests := newTestGithubProvider(t, http.StatusOK)
+
+	require.NoError(t, provider.SetIssueState(context.Background(), "owner", "repo", 7, IssueClosed, CloseReasonNotPlanned))
+	require.NoError(t, provider.SetIssueState(context.Background(), "owner", "repo", 7, IssueOpen, ""))
+	require.Equal(t, []recordedRequest{
+		{method: http.MethodPatch, path: "/repos/owner/repo/issues/7", body: map[string]any{"state": "closed", "state_reason": "not_planned"}},
+		{method: http.MethodPatch, path: "/repos/owner/repo/issues/7", body: map[string]any{"state": "open"}},
+	}, *requests)
+}
+
+func TestGithubProvider_EditPullRequest(t *testing.T) {
+	provider, requests := newTestGithubProvider(t, http.StatusOK)
+
+	require.NoError(t, provider.EditPullRequest(context.Background(), "owner", "repo", 12, PullRequestEdit{Title: "Fix it"}))
+	require.NoError(t, provider.EditPullRequest(context.Background(), "owner", "repo", 12, PullRequestEdit{Title: "Fix it", Body: "Details"}))
+	require.NoError(t, provider.EditPullRequest(context.Background(), "owner", "repo", 12, PullRequestEdit{Base: "release"}))
+	require.Equal(t, []recordedRequest{
+		{method: http.MethodPatch, path: "/repos/owner/repo/pulls/12", body: map[string]any{"title": "Fix it"}},
+		{method: http.MethodPatch, path: "/repos/owner/repo/pulls/12", body: map[string]any{"title": "Fix it", "body": "Details"}},
+		{method: http.MethodPatch, path: "/repos/owner/repo/pulls/12", body: map[string]any{"base": "release"}},
+	}, *requests)
+}
+
+func TestGithubProvider_RequestReviewers(t *testing.T) {
+	provider, requests := newTestGithubProvider(t, http.StatusCreated)
+
+	_, err := provider.RequestReviewers(context.Background(), "owner", "repo", 12, Reviewers{Users: []string{"alice"}, Teams: []string{"backend"}})
+	require.NoError(t, err)
+	require.Equal(t, []recordedRequest{
+		{method: http.MethodPost, path: "/repos/owner/repo/pulls/12/requested_reviewers", body: map[string]any{
+			"reviewers":      []any{"alice"},
+			"team_reviewers": []any{"backend"},
+		}},
+	}, *requests)
+}
+
+func TestGithubProvider_SubmitReview(t *testing.T) {
+	provider, requests := newTestGithubProvider(t, http.StatusOK)
+
+	require.NoError(t, provider.SubmitReview(context.Background(), "owner", "repo", 12, ReviewRequestChanges, "Please add tests"))
+	require.Equal(t, []recordedRequest{
+		{method: http.MethodPost, path: "/repos/owner/repo/pulls/12/reviews", body: map[string]any{"event": "REQUEST_CHANGES", "body": "Please add tests"}},
+	}, *requests)
+}
+
+func TestGithubProvider_SubmitReviewWithComments(t *testing.T) {
+	provider, requests := newTestGithubProvider(t, http.StatusOK)
+
+	comments := []LineComment{{Path: "main.go", Line: 3, Side: "RIGHT", Body: "Unused"}}
+	require.NoError(t, provider.SubmitReviewWithComments(context.Background(), "owner", "repo", 12, ReviewComment, "Thoughts", comments))
+	require.Equal(t, []recordedRequest{
+		{method: http.MethodPost, path: "/repos/owner/repo/pulls/12/reviews", body: map[string]any{
+			"event": "COMMENT",
+			"body":  "Thoughts",
+			"comments": []any{
+				map[string]any{"path": "main.go", "line": float64(3), "side": "RIGHT", "body": "Unused"},
+			},
+		}},
+	}, *requests)
+}
@@ -0,0 +1,164 @@
+// Package vcs abstracts the code host that the bot works with, so that the bot can support hosts other than GitHub.
+// Provider covers what tasks are built from and the changes the bot makes to issues and pull requests; the bot's tools
+// still read through the GitHub client directly, and changes are committed through the workspace. Tasks are modeled on
+// GitHub's API, so reads return go-github's types, which other hosts' providers convert to
+package vcs
+
+import (
+	"context"
+	"errors"
+
+	"github.com/google/go-github/v72/github"
+)
+
+// ErrNotFound is returned when the target of an operation, e.g. a comment or label, doesn't exist
+var ErrNotFound = errors.New("not found")
+
+// ErrInvalid is returned when the host rejects an operation's input, e.g. an unknown reaction
+var ErrInvalid = errors.New("invalid input")
+
+// Label describes an issue label
+type Label struct {
+	Name        string
+	Description string
+	Color       string // Hex color code without a leading '#'
+}
+
+// ReviewEvent is the verdict of a pull request review
+type ReviewEvent string
+
+const (
+	ReviewApprove        ReviewEvent = "APPROVE"
+	ReviewRequestChanges ReviewEvent = "REQUEST_CHANGES"
+	ReviewComment        ReviewEvent = "COMMENT"
+)
+
+// LineComment is a review comment on a single line of a pull request's diff
+type LineComment struct {
+	Path string // Relative to the repository root
+	Line int    // In the version of the file given by Side
+	Side string // "LEFT" for the old version of the file, e.g. a removed line, or "RIGHT" for the new version
+	Body string
+}
+
+// PullRequestEdit describes changes to a pull request. Empty fields are left unchanged
+type PullRequestEdit struct {
+	Title string
+	Body  string
+	Base  string // The branch that the pull request should be merged into
+}
+
+// Reviewers are the users and teams whose reviews of a pull request are requested
+type Reviewers struct {
+	Users []string // Logins
+	Teams []string // Slugs of teams in the repository owner's organization
+}
+
+// IssueState is the state of an issue
+type IssueState string
+
+const (
+	IssueOpen   IssueState = "open"
+	IssueClosed IssueState = "closed"
+)
+
+// NewIssue describes an issue to open
+type NewIssue struct {
+	Title  string
+	Body   string
+	Labels []string // May be empty
+}
+
+// Reasons for closing an issue
+const (
+	CloseReasonCompleted  = "completed"
+	CloseReasonNotPlanned = "not_planned"
+)
+
+// Provider reads from and performs changes on a code host. Pull requests are identified by their number, which on hosts
+// such as GitHub shares a sequence with issue numbers
+type Provider interface {
+	// GetRepository fetches a repository
+	GetRepository(ctx context.Context, owner string, repo string) (*github.Repository, error)
+	// GetBranchHead returns the SHA of the commit at the head of the given branch. Returns ErrNotFound if the branch
+	// doesn't exist
+	GetBranchHead(ctx context.Context, owner string, repo string, branch string) (string, error)
+	// GetFileContents returns the contents of the file at the given path as of the given ref, or as of the default
+	// branch if ref is empty. Returns ErrNotFound if the file doesn't exist
+	GetFileContents(ctx context.Context, owner string, repo string, path string, ref string) (string, error)
+	// GetReadme returns the contents of the repository's README as of the given ref. Returns ErrNotFound if there is none
+	GetReadme(ctx context.Context, owner string, repo string, ref string) (string, error)
+	// GetTree returns every entry of the tree at the given ref, including those in subtrees
+	GetTree(ctx context.Context, owner string, repo string, ref string) ([]*github.TreeEntry, error)
+	// ListLanguages returns the number of bytes of code in the repository in each language
+	ListLanguages(ctx context.Context, owner string, repo string) (map[string]int, error)
+	// GetPermissionLevel returns the user's permission on the repository, e.g. "admin", "write", or "read"
+	GetPermissionLevel(ctx context.Context, owner string, repo string, login string) (string, error)
+	// IsOrganizationMember returns whether the user is a member of the given organization. Returns false if org is a user
+	IsOrganizationMember(ctx context.Context, org string, login string) (bool, error)
+
+	// GetIssue fetches an issue
+	GetIssue(ctx context.Context, owner string, repo string, number int) (*github.Issue, error)
+	// ListComments fetches every comment on the conversation of an issue or pull request, oldest first
+	ListComments(ctx context.Context, owner string, repo string, number int) ([]*github.IssueComment, error)
+	// ListCommentReactions fetches every reaction to a comment on the conversation of an issue or pull request
+	ListCommentReactions(ctx context.Context, owner string, repo string, commentID int64) ([]*github.Reaction, error)
+	// ListIssueEvents fetches every event of an issue or pull request, e.g. a label being added, oldest first
+	ListIssueEvents(ctx context.Context, owner string, repo string, number int) ([]*github.IssueEvent, error)
+
+	// GetPullRequest fetches a pull request
+	GetPullRequest(ctx context.Context, owner string, repo string, prNumber int) (*github.PullRequest, error)
+	// ListOpenPullRequests fetches every open pull request in the repository
+	ListOpenPullRequests(ctx context.Context, owner string, repo string) ([]*github.PullRequest, error)
+	// FindPullRequests returns the pull requests, open or closed, that the given author opened from the given branch,
+	// newest first. Pull requests are returned as issues, which lack their branches
+	FindPullRequests(ctx context.Context, owner string, repo string, branch string, author string) ([]*github.Issue, error)
+	// ListReviews fetches the reviews of a pull request, oldest first
+	ListReviews(ctx context.Context, owner string, repo string, prNumber int) ([]*github.PullRequestReview, error)
+	// ListReviewComments fetches every review comment on a pull request, oldest first
+	ListReviewComments(ctx context.Context, owner string, repo string, prNumber int) ([]*github.PullRequestComment, error)
+	// ListReviewCommentReactions fetches every reaction to a review comment
+	ListReviewCommentReactions(ctx context.Context, owner string, repo string, commentID int64) ([]*github.Reaction, error)
+
+	// CreateComment posts a comment on the conversation of the given issue or pull request
+	CreateComment(ctx context.Context, owner string, repo string, number int, body string) error
+	// ReplyToReviewComment posts a reply in the review thread containing the given review comment
+	ReplyToReviewComment(ctx context.Context, owner string, repo string, prNumber int, commentID int64, body string) error
+
+	// AddCommentReaction reacts to a comment on the conversation of an issue or pull request. Returns ErrNotFound if the
+	// comment doesn't exist and ErrInvalid if the reaction isn't supported
+	AddCommentReaction(ctx context.Context, owner string, repo string, commentID int64, reaction string) error
+	// AddReviewCommentReaction reacts to a review comment. Returns ErrNotFound if the comment doesn't exist and
+	// ErrInvalid if the reaction isn't supported
+	AddReviewCommentReaction(ctx context.Context, owner string, repo string, commentID int64, reaction string) error
+
+	// EnsureLabel creates the given label in the repository if it doesn't exist yet
+	EnsureLabel(ctx context.Context, owner string, repo string, label Label) error
+	// AddLabels adds labels to an issue or pull request
+	AddLabels(ctx context.Context, owner string, repo string, number int, labels []string) error
+	// RemoveLabel removes a label from an issue or pull request. Returns ErrNotFound if the label isn't present
+	RemoveLabel(ctx context.Context, owner string, repo string, number int, label string) error
+
+	// AddAssignees assigns users to an issue or pull request. Returns the logins of everyone now assigned
+	AddAssignees(ctx context.Context, owner string, repo string, number int, logins []string) ([]string, error)
+	// RemoveAssignees unassigns users from an issue or pull request. Returns the logins of everyone still assigned
+	RemoveAssignees(ctx context.Context, owner string, repo string, number int, logins []string) ([]string, error)
+
+	// CreateIssue opens a new issue. Returns the new issue's number and the URL at which people can view it
+	CreateIssue(ctx context.Context, owner string, repo string, issue NewIssue) (int, string, error)
+	// SetIssueState opens or closes an issue. reason explains why an issue is being closed, e.g. CloseReasonCompleted,
+	// and may be empty
+	SetIssueState(ctx context.Context, owner string, repo string, number int, state IssueState, reason string) error
+
+	// EditPullRequest changes the given pull request as described by edit
+	EditPullRequest(ctx context.Context, owner string, repo string, prNumber int, edit PullRequestEdit) error
+
+	// RequestReviewers requests reviews of a pull request from the given reviewers. Returns everyone whose review is now
+	// requested, including those requested earlier
+	RequestReviewers(ctx context.Context, owner string, repo string, prNumber int, reviewers Reviewers) (Reviewers, error)
+
+	// SubmitReview submits a review of a pull request
+	SubmitReview(ctx context.Context, owner string, repo string, prNumber int, event ReviewEvent, body string) error
+	// SubmitReviewWithComments submits a review of a pull request along with comments on lines of its diff, all at once
+	SubmitReviewWithComments(ctx context.Context, owner string, repo string, prNumber int, event ReviewEvent, body string, comments []LineComment) error
+}
@@ -0,0 +1,218 @@
+package workspace
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	// diffContextLines is the number of unchanged lines shown around each change in a unified diff
+	diffContextLines = 3
+	// maxDiffCells caps the size of the table used to compute the longest common subsequence of two files. Beyond this,
+	// the differing region is reported as a wholesale replacement rather than a minimal edit
+	maxDiffCells = 4_000_000
+)
+
+type diffOpKind byte
+
+const (
+	diffOpEqual  diffOpKind = ' '
+	diffOpDelete diffOpKind = '-'
+	diffOpInsert diffOpKind = '+'
+)
+
+type diffOp struct {
+	kind diffOpKind
+	line string
+}
+
+// unifiedDiff returns a unified diff transforming oldContent into newContent. oldExists and newExists indicate whether
+// the file exists before and after the change, respectively, so that creations and deletions are labeled as such.
+// Returns an empty string if there are no differences
+func unifiedDiff(path string, oldContent string, newContent string, oldExists bool, newExists bool) string {
+	ops := diffLines(splitLines(oldContent), splitLines(newContent))
+
+	hunks := buildHunks(ops)
+	if len(hunks) == 0 && oldExists == newExists {
+		return ""
+	}
+
+	oldName, newName := "a/"+path, "b/"+path
+	if !oldExists {
+		oldName = "/dev/null"
+	}
+	if !newExists {
+		newName = "/dev/null"
+	}
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "--- %s\n+++ %s\n", oldName, newName)
+	for _, hunk := range hunks {
+		sb.WriteString(hunk)
+	}
+	return sb.String()
+}
+
+// splitLines splits content into lines, without line terminators. A trailing newline does not produce an empty final
+// line
+func splitLines(content string) []string {
+	if content == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(content, "\n"), "\n")
+}
+
+// diffLines computes an edit script transforming a into b
+func diffLines(a, b []string) []diffOp {
+	// Strip the common prefix and suffix, which is usually most of the file, to keep the LCS table small
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+
+	ops := []diffOp{}
+	for _, line := range a[:prefix] {
+		ops = append(ops, diffOp{kind: diffOpEqual, line: line})
+	}
+	ops = append(ops, diffMiddle(a[prefix:len(a)-suffix], b[prefix:len(b)-suffix])...)
+	for _, line := range a[len(a)-suffix:] {
+		ops = append(ops, diffOp{kind: diffOpEqual, line: line})
+	}
+	return ops
+}
+
+// diffMiddle computes a minimal edit script using a longest common subsequence table, falling back to a wholesale
+// replacement if the inputs are too large
+func diffMiddle(a, b []string) []diffOp {
+	ops := []diffOp{}
+	if len(a)*len(b) > maxDiffCells {
+		for _, line := range a {
+			ops = append(ops, diffOp{kind: diffOpDelete, line: line})
+		}
+		for _, line := range b {
+			ops = append(ops, diffOp{kind: diffOpInsert, line: line})
+		}
+		return ops
+	}
+
+	// lcs[i][j] is the length of the longest common subsequence of a[i:] and b[j:]
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] == b[j]:
+			ops = append(ops, diffOp{kind: diffOpEqual, line: a[i]})
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			ops = append(ops, diffOp{kind: diffOpDelete, line: a[i]})
+			i++
+		default:
+			ops = append(ops, diffOp{kind: diffOpInsert, line: b[j]})
+			j++
+		}
+	}
+	for ; i < len(a); i++ {
+		ops = append(ops, diffOp{kind: diffOpDelete, line: a[i]})
+	}
+	for ; j < len(b); j++ {
+		ops = append(ops, diffOp{kind: diffOpInsert, line: b[j]})
+	}
+	return ops
+}
+
+// buildHunks groups an edit script into formatted unified diff hunks, each including surrounding context lines
+func buildHunks(ops []diffOp) []string {
+	var hunks []string
+
+	i := 0
+	for i < len(ops) {
+		// Find the next change
+		for i < len(ops) && ops[i].kind == diffOpEqual {
+			i++
+		}
+		if i == len(ops) {
+			break
+		}
+
+		start := max(i-diffContextLines, 0)
+
+		// Extend the hunk until there is a run of unchanged lines long enough to separate it from the next change
+		end := i
+		for end < len(ops) {
+			if ops[end].kind != diffOpEqual {
+				end++
+				continue
+			}
+			run := end
+			for run < len(ops) && ops[run].kind == diffOpEqual {
+				run++
+			}
+			if run == len(ops) || run-end > 2*diffContextLines {
+				end = min(end+diffContextLines, len(ops))
+				break
+			}
+			end = run
+		}
+
+		hunks = append(hunks, formatHunk(ops, start, end))
+		i = end
+	}
+
+	return hunks
+}
+
+// formatHunk formats ops[start:end] as a unified diff hunk
+func formatHunk(ops []diffOp, start int, end int) string {
+	// Count the lines preceding the hunk in each version of the file to determine starting line numbers
+	oldLine, newLine := 0, 0
+	for _, op := range ops[:start] {
+		if op.kind != diffOpInsert {
+			oldLine++
+		}
+		if op.kind != diffOpDelete {
+			newLine++
+		}
+	}
+
+	oldCount, newCount := 0, 0
+	var body strings.Builder
+	for _, op := range ops[start:end] {
+		if op.kind != diffOpInsert {
+			oldCount++
+		}
+		if op.kind != diffOpDelete {
+			newCount++
+		}
+		body.WriteByte(byte(op.kind))
+		body.WriteString(op.line)
+		body.WriteByte('\n')
+	}
+
+	// By convention, an empty range starts at the line before the hunk rather than the line after it
+	if oldCount > 0 {
+		oldLine++
+	}
+	if newCount > 0 {
+		newLine++
+	}
+
+	return fmt.Sprintf("@@ -%d,%d +%d,%d @@\n%s", oldLine, oldCount, newLine, newCount, body.String())
+}
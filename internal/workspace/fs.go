@@ -5,6 +5,7 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	"slices"
 	"strings"
 
 	"github.com/google/go-github/v72/github"
@@ -27,8 +28,8 @@ type ReadOnlyFileSystem interface {
 
 	// IsDir returns true if the given path is a directory, false otherwise. Returns false if the given path is a file
 	IsDir(ctx context.Context, dir string) (bool, error)
-	// List lists the fully-qualified paths of all files in the given directory. Returns ErrIsFile if the given path is
-	// a file
+	// ListDir lists the names of the entries in the given directory. Subdirectories have a trailing slash. Returns
+	// ErrIsFile if the given path is a file
 	ListDir(ctx context.Context, dir string) ([]string, error)
 }
 
@@ -116,56 +117,70 @@ func (dfs memDiffFileSystem) FileExists(ctx context.Context, path string) (bool,
 	return dfs.baseFileSystem.FileExists(ctx, path)
 }
 
-// IsDir checks if a path is a directory
+// IsDir checks if a path is a directory, including directories that only exist because files have been written to
+// them in-memory
 func (dfs memDiffFileSystem) IsDir(ctx context.Context, path string) (bool, error) {
+	prefix := strings.TrimSuffix(path, "/") + "/"
+	for p := range dfs.workingTree {
+		if strings.HasPrefix(p, prefix) {
+			return true, nil
+		}
+	}
 	return dfs.baseFileSystem.IsDir(ctx, path)
 }
 
-// ListDir lists the contents of a directory
+// ListDir lists the names of the entries in a directory with in-memory changes applied. Subdirectories have a trailing
+// slash. A subdirectory is still listed after all of its files have been deleted in-memory
 func (dfs memDiffFileSystem) ListDir(ctx context.Context, dir string) ([]string, error) {
 	// Check working tree for a file with this path
 	if _, exists := dfs.workingTree[dir]; exists {
 		return nil, ErrIsFile
 	}
 
-	basePaths, err := dfs.baseFileSystem.ListDir(ctx, dir)
-	if err != nil {
-		return nil, err
+	prefix := ""
+	if dir != "" {
+		prefix = strings.TrimSuffix(dir, "/") + "/"
+	}
+
+	baseNames, baseErr := dfs.baseFileSystem.ListDir(ctx, dir)
+	if baseErr != nil && !errors.Is(baseErr, ErrFileNotFound) {
+		return nil, baseErr
 	}
 
-	// Move paths into a map for uniqueness
-	pathsMap := map[string]struct{}{}
+	// Move names into a map for uniqueness
+	names := map[string]struct{}{}
 
-	for _, path := range basePaths {
+	for _, name := range baseNames {
 		// Only keep non-deleted files
-		if _, ok := dfs.deletedFiles[path]; !ok {
-			pathsMap[path] = struct{}{}
+		if _, ok := dfs.deletedFiles[prefix+name]; !ok {
+			names[name] = struct{}{}
 		}
 	}
 
 	for path := range dfs.workingTree {
-		if _, ok := pathsMap[path]; ok {
-			// path is already in the result (file exists in the base file system and is modified)
+		rest, ok := strings.CutPrefix(path, prefix)
+		if !ok {
 			continue
 		}
-
-		idx := strings.LastIndex(path, "/")
-		dirPart := ""
-		if idx != -1 {
-			dirPart = path[:idx]
+		if subdir, _, nested := strings.Cut(rest, "/"); nested {
+			// The file is in a subdirectory, which may only exist in-memory
+			names[subdir+"/"] = struct{}{}
+		} else {
+			names[rest] = struct{}{}
 		}
+	}
 
-		if dirPart == dir {
-			// This path is new in the working tree and is in the requested directory, so add it to the result
-			pathsMap[path] = struct{}{}
-		}
+	if baseErr != nil && len(names) == 0 {
+		// The directory exists neither in the base file system nor in-memory
+		return nil, baseErr
 	}
 
-	allPaths := []string{}
-	for path := range pathsMap {
-		allPaths = append(allPaths, path)
+	allNames := []string{}
+	for name := range names {
+		allNames = append(allNames, name)
 	}
-	return allPaths, nil
+	slices.Sort(allNames)
+	return allNames, nil
 }
 
 // HasChanges checks if the diffFileSystem has any changes on top of the base file system
@@ -173,6 +188,75 @@ func (dfs memDiffFileSystem) HasChanges() bool {
 	return len(dfs.workingTree) > 0 || len(dfs.deletedFiles) > 0
 }
 
+// HasOnlyTrailingWhitespaceChanges returns true if every in-memory change leaves its file's content the same as in the
+// base file system, apart from whitespace at the ends of lines and blank lines at the end of the file. Other whitespace,
+// such as indentation, can be significant. Creating or deleting a file is not a whitespace-only change
+func (dfs memDiffFileSystem) HasOnlyTrailingWhitespaceChanges(ctx context.Context) (bool, error) {
+	if len(dfs.deletedFiles) > 0 {
+		return false, nil
+	}
+	for p, newContent := range dfs.workingTree {
+		baseContent, err := dfs.baseFileSystem.Read(ctx, p)
+		if errors.Is(err, ErrFileNotFound) {
+			return false, nil
+		} else if err != nil {
+			return false, fmt.Errorf("failed to read base content of '%s': %w", p, err)
+		}
+		if !slices.Equal(trimTrailingWhitespace(baseContent), trimTrailingWhitespace(newContent)) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// trimTrailingWhitespace returns the lines of content without their trailing whitespace, and without the blank lines
+// at the end of the content
+func trimTrailingWhitespace(content string) []string {
+	lines := strings.Split(content, "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimRight(line, " \t\r")
+	}
+	for len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// Diff returns a unified diff of in-memory changes against the base file system. If path is non-empty, only changes to
+// that file, or to files within that directory, are included
+func (dfs memDiffFileSystem) Diff(ctx context.Context, path string) (string, error) {
+	changedPaths := []string{}
+	for p := range dfs.workingTree {
+		changedPaths = append(changedPaths, p)
+	}
+	for p := range dfs.deletedFiles {
+		changedPaths = append(changedPaths, p)
+	}
+	slices.Sort(changedPaths)
+
+	prefix := strings.TrimSuffix(path, "/") + "/"
+
+	var sb strings.Builder
+	for _, p := range changedPaths {
+		if path != "" && p != path && !strings.HasPrefix(p, prefix) {
+			continue
+		}
+
+		baseContent, err := dfs.baseFileSystem.Read(ctx, p)
+		baseExists := true
+		if errors.Is(err, ErrFileNotFound) {
+			baseExists = false
+		} else if err != nil {
+			return "", fmt.Errorf("failed to read base content of '%s': %w", p, err)
+		}
+
+		newContent, modified := dfs.workingTree[p]
+		sb.WriteString(unifiedDiff(p, baseContent, newContent, baseExists, modified))
+	}
+
+	return sb.String(), nil
+}
+
 func (dfs *memDiffFileSystem) Reset() {
 	dfs.workingTree = map[string]string{}
 	dfs.deletedFiles = map[string]struct{}{}
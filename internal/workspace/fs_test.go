@@ -81,10 +81,35 @@ func TestMemDiffFileSystem_ListDir(t *testing.T) {
 		require.NoError(t, err)
 		contents, err := fs.ListDir(ctx, "dir1")
 		require.NoError(t, err)
-		require.ElementsMatch(t, []string{"file1", "file2", "dir1/file3"}, contents)
+		require.ElementsMatch(t, []string{"file1", "file2", "file3"}, contents)
 	}
 }
 
+func TestMemDiffFileSystem_ListDirChanges(t *testing.T) {
+	ctx := context.Background()
+	baseFS := newFakeFS()
+	baseFS.createDir("dir1", []string{"file1", "file2"})
+	err := baseFS.Write(ctx, "dir1/file1", "file1 content")
+	require.NoError(t, err)
+	fs := NewMemDiffFileSystem(baseFS)
+
+	require.NoError(t, fs.Delete(ctx, "dir1/file1"))
+	require.NoError(t, fs.Write(ctx, "dir1/file2", "modified"))
+	require.NoError(t, fs.Write(ctx, "dir1/sub/file3", "file3 content"))
+
+	contents, err := fs.ListDir(ctx, "dir1")
+	require.NoError(t, err)
+	require.Equal(t, []string{"file2", "sub/"}, contents)
+
+	// The new subdirectory only exists in-memory
+	isDir, err := fs.IsDir(ctx, "dir1/sub")
+	require.NoError(t, err)
+	require.True(t, isDir)
+	contents, err = fs.ListDir(ctx, "dir1/sub")
+	require.NoError(t, err)
+	require.Equal(t, []string{"file3"}, contents)
+}
+
 func TestMemDiffFileSystem_ListDirNotExist(t *testing.T) {
 	ctx := context.Background()
 	baseFS := newFakeFS()
@@ -137,6 +162,95 @@ func TestMemDiffFileSystem_IsDir(t *testing.T) {
 	}
 }
 
+func TestMemDiffFileSystem_DiffNoChanges(t *testing.T) {
+	ctx := context.Background()
+	baseFS := newFakeFS()
+	err := baseFS.Write(ctx, "file1.txt", "line1\n")
+	require.NoError(t, err)
+	fs := NewMemDiffFileSystem(baseFS)
+
+	diff, err := fs.Diff(ctx, "")
+	require.NoError(t, err)
+	require.Empty(t, diff)
+}
+
+func TestMemDiffFileSystem_DiffModifiedFile(t *testing.T) {
+	ctx := context.Background()
+	baseFS := newFakeFS()
+	err := baseFS.Write(ctx, "file1.txt", "a\nb\nc\nd\ne\nf\ng\nh\ni\n")
+	require.NoError(t, err)
+	fs := NewMemDiffFileSystem(baseFS)
+
+	err = fs.Write(ctx, "file1.txt", "a\nb\nc\nd\nE\nf\ng\nh\ni\n")
+	require.NoError(t, err)
+
+	diff, err := fs.Diff(ctx, "")
+	require.NoError(t, err)
+	expected := "--- a/file1.txt\n" +
+		"+++ b/file1.txt\n" +
+		"@@ -2,7 +2,7 @@\n" +
+		" b\n" +
+		" c\n" +
+		" d\n" +
+		"-e\n" +
+		"+E\n" +
+		" f\n" +
+		" g\n" +
+		" h\n"
+	require.Equal(t, expected, diff)
+}
+
+func TestMemDiffFileSystem_DiffCreatedFile(t *testing.T) {
+	ctx := context.Background()
+	fs := NewMemDiffFileSystem(newFakeFS())
+
+	err := fs.Write(ctx, "new.txt", "hello\nworld\n")
+	require.NoError(t, err)
+
+	diff, err := fs.Diff(ctx, "")
+	require.NoError(t, err)
+	expected := "--- /dev/null\n" +
+		"+++ b/new.txt\n" +
+		"@@ -0,0 +1,2 @@\n" +
+		"+hello\n" +
+		"+world\n"
+	require.Equal(t, expected, diff)
+}
+
+func TestMemDiffFileSystem_DiffDeletedFile(t *testing.T) {
+	ctx := context.Background()
+	baseFS := newFakeFS()
+	err := baseFS.Write(ctx, "old.txt", "goodbye\n")
+	require.NoError(t, err)
+	fs := NewMemDiffFileSystem(baseFS)
+
+	err = fs.Delete(ctx, "old.txt")
+	require.NoError(t, err)
+
+	diff, err := fs.Diff(ctx, "")
+	require.NoError(t, err)
+	expected := "--- a/old.txt\n" +
+		"+++ /dev/null\n" +
+		"@@ -1,1 +0,0 @@\n" +
+		"-goodbye\n"
+	require.Equal(t, expected, diff)
+}
+
+func TestMemDiffFileSystem_DiffPathFilter(t *testing.T) {
+	ctx := context.Background()
+	fs := NewMemDiffFileSystem(newFakeFS())
+
+	err := fs.Write(ctx, "dir1/file1.txt", "one\n")
+	require.NoError(t, err)
+	err = fs.Write(ctx, "dir10/file2.txt", "two\n")
+	require.NoError(t, err)
+
+	diff, err := fs.Diff(ctx, "dir1")
+	require.NoError(t, err)
+	require.Contains(t, diff, "dir1/file1.txt")
+	require.NotContains(t, diff, "dir10/file2.txt")
+}
+
 // fakeFS is an in-memory file system implementation with fake directory behavior for testing
 type fakeFS struct {
 	files map[string]string
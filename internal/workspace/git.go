@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"net/http"
+	"slices"
 	"strings"
 
 	"github.com/google/go-github/v72/github"
@@ -19,6 +20,18 @@ func (ipe InsufficientPermissionsError) Error() string {
 	return fmt.Sprintf("insufficient permissions to %s: %s", ipe.Operation, ipe.Reason)
 }
 
+// MergeConflictError indicates that two branches couldn't be combined because both changed the same files
+type MergeConflictError struct {
+	Paths []string // The files changed on both branches, if known
+}
+
+func (mce MergeConflictError) Error() string {
+	if len(mce.Paths) == 0 {
+		return "merge conflict"
+	}
+	return fmt.Sprintf("merge conflict in %s", strings.Join(mce.Paths, ", "))
+}
+
 type Changelist interface {
 	ForEachModified(fn func(path string, content string) error) error
 	ForEachDeleted(fn func(path string) error) error
@@ -245,6 +258,213 @@ func (ggr *githubGitRepo) Merge(ctx context.Context, sourceBranch string, target
 	return nil, fmt.Errorf("three-way merge required but not yet implemented")
 }
 
+// MergeCommit merges sourceBranch into targetBranch using GitHub's merge API, which creates a merge commit unless there
+// is nothing to merge
+func (ggr *githubGitRepo) MergeCommit(ctx context.Context, sourceBranch string, targetBranch string, commitMessage string) (*github.Commit, error) {
+	request := &github.RepositoryMergeRequest{
+		Base:          github.Ptr(targetBranch),
+		Head:          github.Ptr(sourceBranch),
+		CommitMessage: github.Ptr(commitMessage),
+	}
+	_, resp, err := ggr.reposService.Merge(ctx, ggr.owner, ggr.repo, request)
+	if err != nil {
+		if resp != nil && resp.StatusCode == http.StatusConflict {
+			paths, err := ggr.conflictingPaths(ctx, sourceBranch, targetBranch)
+			if err != nil {
+				return nil, fmt.Errorf("failed to find conflicting files: %w", err)
+			}
+			return nil, MergeConflictError{Paths: paths}
+		}
+		return nil, fmt.Errorf("failed to merge '%s' into '%s': %w", sourceBranch, targetBranch, err)
+	}
+
+	return ggr.GetBranchHead(ctx, targetBranch)
+}
+
+// Rebase replays the commits on branch that aren't on onto on top of onto, and force-updates branch to the result. The
+// GitHub API can't merge file contents, so if any file was changed on both branches, Rebase returns a
+// MergeConflictError without changing anything. Merge commits can't be replayed
+func (ggr *githubGitRepo) Rebase(ctx context.Context, branch string, onto string) (*github.Commit, error) {
+	comparison, err := ggr.CompareCommits(ctx, onto, branch)
+	if err != nil {
+		return nil, err
+	}
+	if comparison.GetBehindBy() == 0 {
+		// Already up to date
+		return ggr.GetBranchHead(ctx, branch)
+	}
+	if comparison.GetAheadBy() > len(comparison.Commits) {
+		return nil, fmt.Errorf("too many commits to rebase (%d)", comparison.GetAheadBy())
+	}
+
+	paths, err := ggr.conflictingPaths(ctx, branch, onto)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find conflicting files: %w", err)
+	}
+	if len(paths) > 0 {
+		return nil, MergeConflictError{Paths: paths}
+	}
+
+	parent, err := ggr.GetBranchHead(ctx, onto)
+	if err != nil {
+		return nil, err
+	}
+	for _, c := range comparison.Commits {
+		// The comparison doesn't list the files changed by each commit, so fetch them individually
+		repoCommit, files, err := ggr.getCommitWithFiles(ctx, c.GetSHA())
+		if err != nil {
+			return nil, err
+		}
+		if len(repoCommit.Parents) > 1 {
+			return nil, fmt.Errorf("cannot rebase merge commit %s", c.GetSHA())
+		}
+		// The files don't say what kind of entry each is, e.g. an executable or a symlink, so look that up in the commit's
+		// tree
+		entries, err := ggr.getTreeEntries(ctx, repoCommit.GetCommit().GetTree().GetSHA())
+		if err != nil {
+			return nil, fmt.Errorf("failed to get tree of commit %s: %w", c.GetSHA(), err)
+		}
+
+		var treeEntries []*github.TreeEntry
+		for _, file := range files {
+			if file.GetStatus() == "renamed" {
+				treeEntries = append(treeEntries, &github.TreeEntry{
+					Path: file.PreviousFilename,
+					Mode: github.Ptr("100644"),
+					Type: github.Ptr("blob"),
+				})
+			}
+			entry := &github.TreeEntry{
+				Path: file.Filename,
+				Mode: github.Ptr("100644"),
+				Type: github.Ptr("blob"),
+			}
+			if file.GetStatus() != "removed" {
+				original, ok := entries[file.GetFilename()]
+				if !ok {
+					return nil, fmt.Errorf("file '%s' changed by commit %s is missing from its tree", file.GetFilename(), c.GetSHA())
+				}
+				entry.Mode, entry.Type = original.Mode, original.Type
+				entry.SHA = file.SHA // Nil SHA indicates delete
+			}
+			treeEntries = append(treeEntries, entry)
+		}
+
+		tree, _, err := ggr.git.CreateTree(ctx, ggr.owner, ggr.repo, parent.GetTree().GetSHA(), treeEntries)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create tree for commit %s: %w", c.GetSHA(), err)
+		}
+		commit := &github.Commit{
+			Message: repoCommit.GetCommit().Message,
+			Author:  repoCommit.GetCommit().Author,
+			Tree:    tree,
+			Parents: []*github.Commit{parent},
+		}
+		parent, _, err = ggr.git.CreateCommit(ctx, ggr.owner, ggr.repo, commit, nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to replay commit %s: %w", c.GetSHA(), err)
+		}
+	}
+
+	ref, _, err := ggr.git.GetRef(ctx, ggr.owner, ggr.repo, fmt.Sprintf("refs/heads/%s", branch))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get branch ref: %w", err)
+	}
+	ref.Object.SHA = parent.SHA
+	_, _, err = ggr.git.UpdateRef(ctx, ggr.owner, ggr.repo, ref, true)
+	if err != nil {
+		return nil, fmt.Errorf("failed to update branch ref: %w", err)
+	}
+
+	return parent, nil
+}
+
+// conflictingPaths returns the paths of the files that were changed on both of the given branches since they diverged
+func (ggr *githubGitRepo) conflictingPaths(ctx context.Context, branchA string, branchB string) ([]string, error) {
+	comparison, err := ggr.CompareCommits(ctx, branchA, branchB)
+	if err != nil {
+		return nil, err
+	}
+	mergeBase := comparison.GetMergeBaseCommit().GetSHA()
+
+	changedOnA, err := ggr.CompareCommits(ctx, mergeBase, branchA)
+	if err != nil {
+		return nil, err
+	}
+	changedOnB, err := ggr.CompareCommits(ctx, mergeBase, branchB)
+	if err != nil {
+		return nil, err
+	}
+	if len(changedOnA.Files) >= maxComparedFiles || len(changedOnB.Files) >= maxComparedFiles {
+		// A conflict could hide among the files that weren't listed
+		return nil, fmt.Errorf("too many changed files to check for conflicts")
+	}
+
+	pathsOnA := map[string]struct{}{}
+	for _, file := range changedOnA.Files {
+		pathsOnA[file.GetFilename()] = struct{}{}
+		if file.PreviousFilename != nil {
+			pathsOnA[file.GetPreviousFilename()] = struct{}{}
+		}
+	}
+
+	var paths []string
+	for _, file := range changedOnB.Files {
+		for _, path := range []string{file.GetFilename(), file.GetPreviousFilename()} {
+			if _, ok := pathsOnA[path]; ok && path != "" && !slices.Contains(paths, path) {
+				paths = append(paths, path)
+			}
+		}
+	}
+	slices.Sort(paths)
+	return paths, nil
+}
+
+// getCommitWithFiles fetches the commit with the given SHA and all of the files that it changes, which GitHub lists a
+// page at a time
+func (ggr *githubGitRepo) getCommitWithFiles(ctx context.Context, sha string) (*github.RepositoryCommit, []*github.CommitFile, error) {
+	var (
+		commit *github.RepositoryCommit
+		files  []*github.CommitFile
+	)
+	opts := &github.ListOptions{PerPage: 100}
+	for {
+		page, resp, err := ggr.reposService.GetCommit(ctx, ggr.owner, ggr.repo, sha, opts)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to get commit %s: %w", sha, err)
+		}
+		if commit == nil {
+			commit = page
+		}
+		files = append(files, page.Files...)
+		if resp.NextPage == 0 {
+			return commit, files, nil
+		}
+		opts.Page = resp.NextPage
+	}
+}
+
+// getTreeEntries returns the entries of the tree with the given SHA and all of its subtrees, by path. Returns an error
+// if GitHub truncates the listing
+func (ggr *githubGitRepo) getTreeEntries(ctx context.Context, sha string) (map[string]*github.TreeEntry, error) {
+	tree, _, err := ggr.git.GetTree(ctx, ggr.owner, ggr.repo, sha, true)
+	if err != nil {
+		return nil, err
+	}
+	if tree.GetTruncated() {
+		return nil, fmt.Errorf("tree %s has too many entries to list", sha)
+	}
+	entries := map[string]*github.TreeEntry{}
+	for _, entry := range tree.Entries {
+		entries[entry.GetPath()] = entry
+	}
+	return entries, nil
+}
+
+// maxComparedFiles is the most files that GitHub lists in a comparison of two commits. A comparison listing this many
+// may have left some out
+const maxComparedFiles = 300
+
 func (ggr *githubGitRepo) CompareCommits(ctx context.Context, base string, head string) (*github.CommitsComparison, error) {
 	comparison, _, err := ggr.reposService.CompareCommits(ctx, ggr.owner, ggr.repo, base, head, &github.ListOptions{})
 	if err != nil {
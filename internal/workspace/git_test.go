@@ -0,0 +1,153 @@
+package workspace
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/google/go-github/v72/github"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeRebaseServer is a fake GitHub server for rebasing the "work" branch, which has one commit changing the given
+// files, onto "main", which has moved on without touching them. Trees created by the rebase are recorded
+type fakeRebaseServer struct {
+	t *testing.T
+
+	workFiles  string // The files changed on the work branch, as JSON
+	workTree   string // The entries of the work branch's tree, as JSON
+	mainFiles  string // The files changed on main, as JSON
+	pagedFiles string // If not empty, the files on the second page of the work branch's commit, as JSON
+
+	createdTrees [][]*github.TreeEntry
+}
+
+func (f *fakeRebaseServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	path := strings.TrimPrefix(r.URL.Path, "/repos/owner/repo/")
+	switch {
+	case path == "compare/work...main" || path == "compare/main...work":
+		_, _ = w.Write([]byte(`{"ahead_by": 1, "behind_by": 1, "commits": [{"sha": "work1"}], "merge_base_commit": {"sha": "base"}}`))
+	case path == "compare/base...work":
+		_, _ = fmt.Fprintf(w, `{"files": %s}`, f.workFiles)
+	case path == "compare/base...main":
+		_, _ = fmt.Fprintf(w, `{"files": %s}`, f.mainFiles)
+	case path == "git/ref/heads/main":
+		_, _ = w.Write([]byte(`{"ref": "refs/heads/main", "object": {"sha": "main1"}}`))
+	case path == "git/ref/heads/work":
+		_, _ = w.Write([]byte(`{"ref": "refs/heads/work", "object": {"sha": "work1"}}`))
+	case path == "git/commits/main1":
+		_, _ = w.Write([]byte(`{"sha": "main1", "tree": {"sha": "maintree"}}`))
+	case path == "commits/work1":
+		files := f.workFiles
+		if r.URL.Query().Get("page") == "2" {
+			files = f.pagedFiles
+		} else if f.pagedFiles != "" {
+			w.Header().Set("Link", fmt.Sprintf(`<http://%s/repos/owner/repo/commits/work1?page=2>; rel="next"`, r.Host))
+		}
+		_, _ = fmt.Fprintf(w, `{"sha": "work1", "parents": [{"sha": "base"}], `+
+			`"commit": {"message": "Add a script", "tree": {"sha": "worktree"}}, "files": %s}`, files)
+	case path == "git/trees/worktree":
+		require.Equal(f.t, "1", r.URL.Query().Get("recursive"))
+		_, _ = fmt.Fprintf(w, `{"sha": "worktree", "tree": %s}`, f.workTree)
+	case r.Method == http.MethodPost && path == "git/trees":
+		var req struct {
+			Tree []*github.TreeEntry `json:"tree"`
+		}
+		require.NoError(f.t, json.NewDecoder(r.Body).Decode(&req))
+		f.createdTrees = append(f.createdTrees, req.Tree)
+		_, _ = w.Write([]byte(`{"sha": "newtree"}`))
+	case r.Method == http.MethodPost && path == "git/commits":
+		_, _ = w.Write([]byte(`{"sha": "rebased1"}`))
+	case r.Method == http.MethodPatch && path == "git/refs/heads/work":
+		_, _ = w.Write([]byte(`{"ref": "refs/heads/work", "object": {"sha": "rebased1"}}`))
+	default:
+		f.t.Errorf("unexpected request: %s %s", r.Method, r.URL.Path)
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func newTestGithubGitRepo(t *testing.T, handler http.Handler) githubGitRepo {
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+
+	client := github.NewClient(nil)
+	baseURL, err := url.Parse(server.URL + "/")
+	require.NoError(t, err)
+	client.BaseURL = baseURL
+	return NewGithubGitRepo(client.Git, client.Repositories, "owner", "repo")
+}
+
+func TestGithubGitRepo_RebaseKeepsFileModes(t *testing.T) {
+	fake := &fakeRebaseServer{
+		t: t,
+		workFiles: `[
+			{"filename": "run.sh", "status": "added", "sha": "script"},
+			{"filename": "latest", "status": "added", "sha": "link"}
+		]`,
+		workTree: `[
+			{"path": "run.sh", "mode": "100755", "type": "blob", "sha": "script"},
+			{"path": "latest", "mode": "120000", "type": "blob", "sha": "link"},
+			{"path": "README.md", "mode": "100644", "type": "blob", "sha": "readme"}
+		]`,
+		mainFiles: `[{"filename": "main.go", "status": "modified", "sha": "main"}]`,
+	}
+	ggr := newTestGithubGitRepo(t, fake)
+
+	commit, err := ggr.Rebase(context.Background(), "work", "main")
+	require.NoError(t, err)
+	require.Equal(t, "rebased1", commit.GetSHA())
+
+	require.Len(t, fake.createdTrees, 1)
+	modes := map[string]string{}
+	for _, entry := range fake.createdTrees[0] {
+		modes[entry.GetPath()] = entry.GetMode()
+	}
+	require.Equal(t, map[string]string{"run.sh": "100755", "latest": "120000"}, modes)
+}
+
+func TestGithubGitRepo_RebaseReplaysAllPagesOfFiles(t *testing.T) {
+	fake := &fakeRebaseServer{
+		t:          t,
+		workFiles:  `[{"filename": "a.go", "status": "added", "sha": "a"}]`,
+		pagedFiles: `[{"filename": "b.go", "status": "added", "sha": "b"}]`,
+		workTree: `[
+			{"path": "a.go", "mode": "100644", "type": "blob", "sha": "a"},
+			{"path": "b.go", "mode": "100644", "type": "blob", "sha": "b"}
+		]`,
+		mainFiles: `[]`,
+	}
+	ggr := newTestGithubGitRepo(t, fake)
+
+	_, err := ggr.Rebase(context.Background(), "work", "main")
+	require.NoError(t, err)
+
+	require.Len(t, fake.createdTrees, 1)
+	var paths []string
+	for _, entry := range fake.createdTrees[0] {
+		paths = append(paths, entry.GetPath())
+	}
+	require.Equal(t, []string{"a.go", "b.go"}, paths)
+}
+
+func TestGithubGitRepo_RebaseTooManyChangedFiles(t *testing.T) {
+	files := make([]string, maxComparedFiles)
+	for i := range files {
+		files[i] = fmt.Sprintf(`{"filename": "file%d.go", "status": "modified"}`, i)
+	}
+	fake := &fakeRebaseServer{
+		t:         t,
+		workFiles: `[{"filename": "a.go", "status": "added", "sha": "a"}]`,
+		mainFiles: "[" + strings.Join(files, ",") + "]",
+	}
+	ggr := newTestGithubGitRepo(t, fake)
+
+	_, err := ggr.Rebase(context.Background(), "work", "main")
+	require.ErrorContains(t, err, "too many changed files")
+	require.Empty(t, fake.createdTrees)
+}
@@ -7,17 +7,22 @@ import (
 	"strings"
 
 	"github.com/google/go-github/v72/github"
+
+	"github.com/cchalm/blundering-savant/internal/logging"
+	"github.com/cchalm/blundering-savant/internal/task"
 )
 
 var ErrNoCommits = fmt.Errorf("no commits")
 
-// githubPullRequestService is a wrapper around github.PullRequestsService.Create
+// githubPullRequestService is a wrapper around github.PullRequestsService that opens or updates the pull request for a
+// branch
 type githubPullRequestService struct {
 	prService    *github.PullRequestsService
 	owner        string
 	repo         string
 	sourceBranch string
 	targetBranch string
+	reviewers    []string // Users whose review is requested on new pull requests
 }
 
 func NewGithubPullRequestService(
@@ -26,6 +31,7 @@ func NewGithubPullRequestService(
 	repo string,
 	sourceBranch string,
 	targetBranch string,
+	reviewers []string,
 ) githubPullRequestService {
 	return githubPullRequestService{
 		prService:    prService,
@@ -33,28 +39,78 @@ func NewGithubPullRequestService(
 		repo:         repo,
 		sourceBranch: sourceBranch,
 		targetBranch: targetBranch,
+		reviewers:    reviewers,
 	}
 }
 
-func (gprs *githubPullRequestService) Create(ctx context.Context, title string, body string) error {
+// Create opens a pull request from the source branch into the target branch, as a draft if draft is true. If the source
+// branch already has an open pull request, e.g. because an earlier publish succeeded but the process died before
+// recording it, that pull request's title and body are updated instead of opening a duplicate. Returns the pull request
+func (gprs *githubPullRequestService) Create(ctx context.Context, title string, body string, draft bool) (*task.GithubPullRequest, error) {
+	existing, err := gprs.findOpenPullRequest(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if existing != nil {
+		logging.FromContext(ctx).Info("Updating existing pull request instead of creating a new one", "pr", existing.GetNumber())
+		edit := &github.PullRequest{Title: github.Ptr(title), Body: github.Ptr(body)}
+		updated, _, err := gprs.prService.Edit(ctx, gprs.owner, gprs.repo, existing.GetNumber(), edit)
+		if err != nil {
+			return nil, fmt.Errorf("failed to update pull request #%d: %w", existing.GetNumber(), err)
+		}
+		return task.ConvertPullRequest(gprs.owner, gprs.repo, updated)
+	}
+
 	pr := &github.NewPullRequest{
 		Title: github.Ptr(title),
 		Body:  github.Ptr(body),
 		Head:  &gprs.sourceBranch,
 		Base:  &gprs.targetBranch,
+		Draft: github.Ptr(draft),
 	}
 
-	_, _, err := gprs.prService.Create(ctx, gprs.owner, gprs.repo, pr)
+	created, _, err := gprs.prService.Create(ctx, gprs.owner, gprs.repo, pr)
 	if err != nil {
 		var ghErr *github.ErrorResponse
 		if errors.As(err, &ghErr) {
 			for _, e := range ghErr.Errors {
 				if e.Code == "custom" && strings.Contains(e.Message, "No commits between") {
-					return fmt.Errorf("failed to create pull request: %w", ErrNoCommits)
+					return nil, fmt.Errorf("failed to create pull request: %w", ErrNoCommits)
 				}
 			}
 		}
-		return fmt.Errorf("failed to create pull request: %w", err)
+		return nil, fmt.Errorf("failed to create pull request: %w", err)
+	}
+
+	if len(gprs.reviewers) > 0 {
+		reviewers := github.ReviewersRequest{Reviewers: gprs.reviewers}
+		_, _, err := gprs.prService.RequestReviewers(ctx, gprs.owner, gprs.repo, created.GetNumber(), reviewers)
+		if err != nil {
+			// Don't fail, since the pull request exists now and creating it again would fail
+			logging.FromContext(ctx).Warn("failed to request reviewers", "reviewers", gprs.reviewers, "error", err)
+		}
+	}
+	return task.ConvertPullRequest(gprs.owner, gprs.repo, created)
+}
+
+// findOpenPullRequest returns the open pull request from the source branch, or nil if there is none. Unlike a search,
+// listing pull requests sees ones that were opened moments ago
+func (gprs *githubPullRequestService) findOpenPullRequest(ctx context.Context) (*github.PullRequest, error) {
+	opts := &github.PullRequestListOptions{
+		State: "open",
+		Head:  gprs.owner + ":" + gprs.sourceBranch,
+	}
+	prs, _, err := gprs.prService.List(ctx, gprs.owner, gprs.repo, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list pull requests for branch '%s': %w", gprs.sourceBranch, err)
+	}
+	switch len(prs) {
+	case 0:
+		return nil, nil
+	case 1:
+		return prs[0], nil
+	default:
+		// There's no telling which one to update
+		return nil, fmt.Errorf("found %d open pull requests for branch '%s', expected at most 1", len(prs), gprs.sourceBranch)
 	}
-	return nil
 }
@@ -0,0 +1,99 @@
+package workspace
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/google/go-github/v72/github"
+	"github.com/stretchr/testify/require"
+)
+
+// fakePullRequestServer is a fake GitHub server that tracks the pull requests opened from a single branch
+type fakePullRequestServer struct {
+	open    []*github.PullRequest
+	creates int
+	edits   []*github.PullRequest
+}
+
+func (f *fakePullRequestServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	switch {
+	case r.Method == http.MethodGet && r.URL.Path == "/repos/owner/repo/pulls":
+		if r.URL.Query().Get("state") != "open" || r.URL.Query().Get("head") != "owner:work-branch" {
+			_ = json.NewEncoder(w).Encode([]*github.PullRequest{})
+			return
+		}
+		_ = json.NewEncoder(w).Encode(f.open)
+	case r.Method == http.MethodPost && r.URL.Path == "/repos/owner/repo/pulls":
+		var pr github.NewPullRequest
+		_ = json.NewDecoder(r.Body).Decode(&pr)
+		f.creates++
+		created := &github.PullRequest{Number: github.Ptr(f.creates), Title: pr.Title, Body: pr.Body,
+			URL: github.Ptr("https://example.com/pulls/1"), Base: &github.PullRequestBranch{Ref: pr.Base}}
+		f.open = append(f.open, created)
+		w.WriteHeader(http.StatusCreated)
+		_ = json.NewEncoder(w).Encode(created)
+	case r.Method == http.MethodPatch && r.URL.Path == "/repos/owner/repo/pulls/1":
+		var pr github.PullRequest
+		_ = json.NewDecoder(r.Body).Decode(&pr)
+		f.edits = append(f.edits, &pr)
+		_ = json.NewEncoder(w).Encode(f.open[0])
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		_, _ = w.Write([]byte(`{"message": "Not Found"}`))
+	}
+}
+
+func newTestPullRequestService(t *testing.T, fake *fakePullRequestServer) githubPullRequestService {
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+
+	client := github.NewClient(nil)
+	baseURL, err := url.Parse(server.URL + "/")
+	require.NoError(t, err)
+	client.BaseURL = baseURL
+
+	return NewGithubPullRequestService(client.PullRequests, "owner", "repo", "work-branch", "main", nil)
+}
+
+func TestPullRequestServiceCreate_NoExistingPullRequest(t *testing.T) {
+	fake := &fakePullRequestServer{}
+	prService := newTestPullRequestService(t, fake)
+
+	pr, err := prService.Create(context.Background(), "Fix bug", "body", false)
+	require.NoError(t, err)
+	require.Equal(t, 1, pr.Number)
+	require.Equal(t, "main", pr.BaseBranch)
+	require.Equal(t, 1, fake.creates)
+	require.Empty(t, fake.edits)
+}
+
+func TestPullRequestServiceCreate_SecondPublishUpdates(t *testing.T) {
+	fake := &fakePullRequestServer{}
+	prService := newTestPullRequestService(t, fake)
+
+	_, err := prService.Create(context.Background(), "Fix bug", "body", false)
+	require.NoError(t, err)
+	// As if the bot restarted before recording the pull request and published again
+	pr, err := prService.Create(context.Background(), "Fix bug properly", "new body", false)
+	require.NoError(t, err)
+	require.Equal(t, 1, pr.Number)
+
+	require.Equal(t, 1, fake.creates, "publishing again should not open a duplicate pull request")
+	require.Len(t, fake.edits, 1)
+	require.Equal(t, "Fix bug properly", fake.edits[0].GetTitle())
+	require.Equal(t, "new body", fake.edits[0].GetBody())
+}
+
+func TestPullRequestServiceCreate_MultipleOpenPullRequests(t *testing.T) {
+	fake := &fakePullRequestServer{open: []*github.PullRequest{{Number: github.Ptr(1)}, {Number: github.Ptr(2)}}}
+	prService := newTestPullRequestService(t, fake)
+
+	_, err := prService.Create(context.Background(), "Fix bug", "body", false)
+	require.ErrorContains(t, err, "found 2 open pull requests")
+	require.Zero(t, fake.creates)
+}
@@ -0,0 +1,67 @@
+package workspace
+
+import (
+	"sync"
+
+	"github.com/cchalm/blundering-savant/internal/validator"
+)
+
+// maxValidationCacheEntries caps the number of results a ValidationCache holds. The oldest results are evicted first
+const maxValidationCacheEntries = 1000
+
+// ValidationCache remembers validation results by the git tree that was validated, so that validating content that
+// hasn't changed since it was last validated, e.g. at the start of every task, returns the earlier result instead of
+// waiting on CI again. A tree's hash changes whenever any file in it is written or deleted, so results never need to be
+// invalidated explicitly. Only successful results are cached, since a failure may be flaky, and a fresh run of the
+// same tree may pass. It is safe for concurrent use, and meant to be shared by all workspaces
+type ValidationCache struct {
+	mu      sync.Mutex
+	results map[validationCacheKey]validator.ValidationResult
+	order   []validationCacheKey // Keys in the order they were added, for eviction
+}
+
+// validationCacheKey identifies a validation run. The same tree may be validated differently in different repositories,
+// or by different workflows
+type validationCacheKey struct {
+	scope   string // The repository and validation workflow
+	treeSHA string
+}
+
+func NewValidationCache() *ValidationCache {
+	return &ValidationCache{
+		results: map[validationCacheKey]validator.ValidationResult{},
+	}
+}
+
+// get returns the cached result for the given key, if any. A nil cache holds nothing
+func (vc *ValidationCache) get(key validationCacheKey) (validator.ValidationResult, bool) {
+	if vc == nil {
+		return validator.ValidationResult{}, false
+	}
+
+	vc.mu.Lock()
+	defer vc.mu.Unlock()
+
+	result, ok := vc.results[key]
+	return result, ok
+}
+
+// put caches the result for the given key, evicting the oldest result if the cache is full. Putting into a nil cache
+// does nothing
+func (vc *ValidationCache) put(key validationCacheKey, result validator.ValidationResult) {
+	if vc == nil {
+		return
+	}
+
+	vc.mu.Lock()
+	defer vc.mu.Unlock()
+
+	if _, ok := vc.results[key]; !ok {
+		if len(vc.order) == maxValidationCacheEntries {
+			delete(vc.results, vc.order[0])
+			vc.order = vc.order[1:]
+		}
+		vc.order = append(vc.order, key)
+	}
+	vc.results[key] = result
+}
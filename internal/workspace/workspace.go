@@ -2,14 +2,17 @@ package workspace
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net/http"
 	"regexp"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/google/go-github/v72/github"
 
+	"github.com/cchalm/blundering-savant/internal/logging"
 	"github.com/cchalm/blundering-savant/internal/task"
 	"github.com/cchalm/blundering-savant/internal/validator"
 )
@@ -24,52 +27,121 @@ type RemoteValidationWorkspace struct {
 	fs        *memDiffFileSystem
 	prService PullRequestService
 
-	issueNumber      int
+	issueNumbers     []int // The issues that the pull request fixes
 	needsPullRequest bool
 
 	baseBranch   string
 	workBranch   string
 	reviewBranch string
 
+	// syncStrategy is how SyncWithBase brings new commits on the base branch into the work branch
+	syncStrategy SyncStrategy
+
 	validator BranchValidator
+	// validationTimeout limits how long ValidateChanges waits for validation. Zero means no limit beyond the validator's
+	// own
+	validationTimeout time.Duration
+	// validationCache holds the results of earlier validations, possibly by other workspaces. May be nil
+	validationCache *ValidationCache
+	// validationScope identifies the repository and validation workflow or command in validationCache keys
+	validationScope string
+	// lastValidation is the result of the most recent completed validation, if any, so that it can be returned again
+	// when there is nothing new to validate
+	lastValidation *commitValidation
+
+	// readOnly is set for workspaces that must not change anything on GitHub. Read-only workspaces can be read from and
+	// changed in-memory, but not validated or published
+	readOnly bool
+	// branchesExist records whether the work and review branches existed when a read-only workspace was created, since
+	// a read-only workspace doesn't create them
+	branchesExist bool
+}
+
+// commitValidation is the result of validating a commit
+type commitValidation struct {
+	commitSHA string
+	result    validator.ValidationResult
 }
 
+// errReadOnly is returned by operations that a read-only workspace doesn't support
+var errReadOnly = errors.New("workspace is read-only")
+
 type GitRepo interface {
 	GetBranchHead(ctx context.Context, branch string) (*github.Commit, error)
 	CreateBranch(ctx context.Context, baseBranch string, newBranch string) error
 	CommitChanges(ctx context.Context, branch string, changelist Changelist, commitMessage string) (*github.Commit, error)
 	Merge(ctx context.Context, baseBranch string, targetBranch string) (*github.Commit, error)
+	// MergeCommit merges sourceBranch into targetBranch, creating a merge commit if the branches have diverged. Returns
+	// a MergeConflictError if the branches can't be merged cleanly
+	MergeCommit(ctx context.Context, sourceBranch string, targetBranch string, commitMessage string) (*github.Commit, error)
+	// Rebase replays the commits on branch that aren't on onto on top of onto, and moves branch to the result. Returns
+	// a MergeConflictError if the commits can't be replayed cleanly
+	Rebase(ctx context.Context, branch string, onto string) (*github.Commit, error)
 	CompareCommits(ctx context.Context, base string, head string) (*github.CommitsComparison, error)
 }
 
+// SyncStrategy is how a workspace brings new commits on the base branch into its work branch
+type SyncStrategy string
+
+const (
+	// SyncNone leaves the work branch alone
+	SyncNone SyncStrategy = "none"
+	// SyncMerge merges the base branch into the work branch
+	SyncMerge SyncStrategy = "merge"
+	// SyncRebase rebases the work branch onto the base branch, unless changes have already been published for review,
+	// in which case it merges to avoid rewriting the history of the pull request
+	SyncRebase SyncStrategy = "rebase"
+)
+
+// ParseSyncStrategy parses the name of a sync strategy
+func ParseSyncStrategy(s string) (SyncStrategy, error) {
+	switch strategy := SyncStrategy(s); strategy {
+	case SyncNone, SyncMerge, SyncRebase:
+		return strategy, nil
+	default:
+		return "", fmt.Errorf("unknown sync strategy '%s', expected one of 'none', 'merge', or 'rebase'", s)
+	}
+}
+
 type BranchValidator interface {
 	// ValidateBranch validates the given commit SHA, which is expected to be the head of the given branch
 	ValidateBranch(ctx context.Context, branch string, commitSHA string) (validator.ValidationResult, error)
 }
 
+// ScopedTestRunner is implemented by branch validators that can run a subset of the tests
+type ScopedTestRunner interface {
+	// RunTests runs the tests identified by target against the given commit SHA, which is expected to be the head of the
+	// given branch. Returns validator.ErrScopedTestsUnsupported if the validator can't run a subset of tests after all
+	RunTests(ctx context.Context, branch string, commitSHA string, target string) (validator.ValidationResult, error)
+}
+
 type PullRequestService interface {
-	Create(ctx context.Context, title string, body string) error
+	// Create opens a pull request, as a draft if draft is true. If one is already open from the same branch, it is
+	// updated instead. Returns the pull request
+	Create(ctx context.Context, title string, body string, draft bool) (*task.GithubPullRequest, error)
 }
 
 func NewRemoteValidationWorkspace(
 	ctx context.Context,
 	githubClient *github.Client,
 	validationWorkflowName string,
+	validationCommand string,
+	coverageFile string,
+	cloneToken string,
+	validationEnv []string,
+	validationTimeout time.Duration,
+	validationCache *ValidationCache,
+	syncStrategy SyncStrategy,
 	tsk task.Task,
 ) (*RemoteValidationWorkspace, error) {
 	owner, repo := tsk.Issue.Owner, tsk.Issue.Repo
 
-	// Get default branch
-	repoInfo, _, err := githubClient.Repositories.Get(ctx, owner, repo)
+	baseBranch, err := getBaseBranch(ctx, githubClient, tsk)
 	if err != nil {
-		return nil, fmt.Errorf("failed to fetch repo info: %w", err)
+		return nil, err
 	}
-	if repoInfo.DefaultBranch == nil {
-		return nil, fmt.Errorf("nil default branch")
-	}
-	baseBranch := *repoInfo.DefaultBranch
 
-	workBranch := getWorkBranchName(tsk.Issue)
+	workBranch := getWorkBranchName(tsk.IssueNumbers(), tsk.Issue.Title)
 	reviewBranch := tsk.SourceBranch
 
 	gitRepo := NewGithubGitRepo(githubClient.Git, githubClient.Repositories, owner, repo)
@@ -96,26 +168,136 @@ func NewRemoteValidationWorkspace(
 		return nil, fmt.Errorf("failed to await creation of work branch '%s': %w", reviewBranch, err)
 	}
 
-	prService := NewGithubPullRequestService(githubClient.PullRequests, owner, repo, reviewBranch, baseBranch)
+	prService := NewGithubPullRequestService(githubClient.PullRequests, owner, repo, reviewBranch, baseBranch, tsk.RepoConfig.Reviewers)
+
+	var (
+		branchValidator BranchValidator
+		validationScope string
+	)
+	if validationCommand != "" {
+		// The operator chose to validate locally rather than in each repository's CI
+		cloneURL := tsk.Repository.GetCloneURL()
+		if cloneURL == "" {
+			cloneURL = fmt.Sprintf("https://github.com/%s/%s.git", owner, repo)
+		}
+		localValidator := validator.NewLocalValidator(cloneURL, cloneToken, validationCommand, validationTimeout).
+			WithEnv(validationEnv)
+		if coverageFile != "" {
+			localValidator = localValidator.WithCoverageFile(coverageFile)
+		}
+		branchValidator = localValidator
+		validationScope = fmt.Sprintf("%s/%s$ %s", owner, repo, validationCommand)
+	} else {
+		// The repository's own config takes precedence over the operator's choice of validation workflow
+		if tsk.RepoConfig.ValidationWorkflow != "" {
+			validationWorkflowName = tsk.RepoConfig.ValidationWorkflow
+		}
+		branchValidator = validator.NewGithubActionCommitValidator(githubClient, owner, repo, validationWorkflowName)
+		validationScope = fmt.Sprintf("%s/%s:%s", owner, repo, validationWorkflowName)
+	}
 
-	validator := validator.NewGithubActionCommitValidator(githubClient, owner, repo, validationWorkflowName)
+	// If the pull request conflicts with the base branch, sync with it even if the operator hasn't asked for syncing, so
+	// that the conflicting files are identified, or the base branch is merged in if the conflicts have been resolved
+	if tsk.HasMergeConflicts() && (syncStrategy == "" || syncStrategy == SyncNone) {
+		syncStrategy = SyncMerge
+	}
 
 	return &RemoteValidationWorkspace{
 		git:       &gitRepo,
 		fs:        &diffFS,
 		prService: &prService,
 
-		issueNumber:      tsk.Issue.Number,
+		issueNumbers:     tsk.IssueNumbers(),
 		needsPullRequest: tsk.PullRequest == nil,
 
 		baseBranch:   baseBranch,
 		workBranch:   workBranch,
 		reviewBranch: reviewBranch,
 
-		validator: validator,
+		syncStrategy: syncStrategy,
+
+		validator:         branchValidator,
+		validationTimeout: validationTimeout,
+		validationCache:   validationCache,
+		validationScope:   validationScope,
 	}, nil
 }
 
+// getBaseBranch returns the branch that the task's branches are created from and its pull request targets: the task's
+// target branch, or the repository's default branch if the task doesn't have one
+func getBaseBranch(ctx context.Context, githubClient *github.Client, tsk task.Task) (string, error) {
+	if tsk.TargetBranch != "" {
+		return tsk.TargetBranch, nil
+	}
+
+	repoInfo, _, err := githubClient.Repositories.Get(ctx, tsk.Issue.Owner, tsk.Issue.Repo)
+	if err != nil {
+		return "", fmt.Errorf("failed to fetch repo info: %w", err)
+	}
+	if repoInfo.DefaultBranch == nil {
+		return "", fmt.Errorf("nil default branch")
+	}
+	return *repoInfo.DefaultBranch, nil
+}
+
+// NewReadOnlyRemoteValidationWorkspace creates a workspace like NewRemoteValidationWorkspace that never changes anything
+// on GitHub, for use in dry runs. Files are read from the work branch if it exists, and from the base branch otherwise
+func NewReadOnlyRemoteValidationWorkspace(ctx context.Context, githubClient *github.Client, tsk task.Task) (*RemoteValidationWorkspace, error) {
+	owner, repo := tsk.Issue.Owner, tsk.Issue.Repo
+
+	baseBranch, err := getBaseBranch(ctx, githubClient, tsk)
+	if err != nil {
+		return nil, err
+	}
+
+	workBranch := getWorkBranchName(tsk.IssueNumbers(), tsk.Issue.Title)
+	reviewBranch := tsk.SourceBranch
+
+	workBranchExists, err := branchExists(ctx, githubClient, owner, repo, workBranch)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check for work branch '%s': %w", workBranch, err)
+	}
+	reviewBranchExists, err := branchExists(ctx, githubClient, owner, repo, reviewBranch)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check for review branch '%s': %w", reviewBranch, err)
+	}
+
+	readBranch := baseBranch
+	if workBranchExists {
+		readBranch = workBranch
+	}
+
+	gitRepo := NewGithubGitRepo(githubClient.Git, githubClient.Repositories, owner, repo)
+	githubFS := NewGithubFileSystem(githubClient.Repositories, owner, repo, readBranch)
+	diffFS := NewMemDiffFileSystem(githubFS)
+
+	return &RemoteValidationWorkspace{
+		git: &gitRepo,
+		fs:  &diffFS,
+
+		issueNumbers:     tsk.IssueNumbers(),
+		needsPullRequest: tsk.PullRequest == nil,
+
+		baseBranch:   baseBranch,
+		workBranch:   workBranch,
+		reviewBranch: reviewBranch,
+
+		readOnly:      true,
+		branchesExist: workBranchExists && reviewBranchExists,
+	}, nil
+}
+
+func branchExists(ctx context.Context, githubClient *github.Client, owner, repo, branch string) (bool, error) {
+	maxRedirects := 10
+	_, resp, err := githubClient.Repositories.GetBranch(ctx, owner, repo, branch, maxRedirects)
+	if err == nil {
+		return true, nil
+	} else if resp != nil && resp.StatusCode == http.StatusNotFound {
+		return false, nil
+	}
+	return false, err
+}
+
 func awaitBranchCreation(ctx context.Context, githubClient *github.Client, owner, repo, branch string) error {
 	timeout := 10 * time.Second
 	checkInterval := 2 * time.Second
@@ -187,6 +369,13 @@ func (rvw RemoteValidationWorkspace) ListDir(ctx context.Context, path string) (
 	return rvw.fs.ListDir(ctx, path)
 }
 
+// Diff returns a unified diff of local changes. If path is non-empty, only changes to that file or directory are
+// included
+func (rvw RemoteValidationWorkspace) Diff(ctx context.Context, path string) (string, error) {
+	path = normalizePath(path)
+	return rvw.fs.Diff(ctx, path)
+}
+
 func (rvw RemoteValidationWorkspace) HasLocalChanges() bool {
 	return rvw.fs.HasChanges()
 }
@@ -194,6 +383,10 @@ func (rvw RemoteValidationWorkspace) HasLocalChanges() bool {
 // HasUnpublishedChanges returns true if there are changes in the working branch that have not been merged to the review
 // branch
 func (rvw RemoteValidationWorkspace) HasUnpublishedChanges(ctx context.Context) (bool, error) {
+	if rvw.readOnly && !rvw.branchesExist {
+		return false, nil
+	}
+
 	// Compare the working branch against the review branch
 	comparison, err := rvw.git.CompareCommits(ctx, rvw.reviewBranch, rvw.workBranch)
 	if err != nil {
@@ -204,35 +397,146 @@ func (rvw RemoteValidationWorkspace) HasUnpublishedChanges(ctx context.Context)
 	return *comparison.AheadBy > 0, nil
 }
 
+// SyncWithBase brings any new commits on the base branch into the work branch, using the workspace's sync strategy. It
+// must be called before making local changes. If the branches conflict, the work branch is left as it was and the
+// returned result describes the conflict, so that the AI can decide what to do about it. Otherwise the result succeeds,
+// and its details say what was done, if anything
+func (rvw *RemoteValidationWorkspace) SyncWithBase(ctx context.Context) (validator.ValidationResult, error) {
+	if rvw.readOnly || rvw.syncStrategy == "" || rvw.syncStrategy == SyncNone {
+		return validator.ValidationResult{Succeeded: true}, nil
+	}
+	if rvw.HasLocalChanges() {
+		return validator.ValidationResult{}, fmt.Errorf("cannot sync with the base branch while there are uncommitted changes in-memory")
+	}
+
+	// Count the commits on the base branch that the work branch doesn't have
+	comparison, err := rvw.git.CompareCommits(ctx, rvw.workBranch, rvw.baseBranch)
+	if err != nil {
+		return validator.ValidationResult{}, fmt.Errorf("failed to compare branches %s..%s: %w", rvw.workBranch, rvw.baseBranch, err)
+	}
+	newCommits := comparison.GetAheadBy()
+	if newCommits == 0 {
+		return validator.ValidationResult{Succeeded: true}, nil
+	}
+
+	rebase := rvw.syncStrategy == SyncRebase
+	if rebase {
+		// Rebasing a published branch would rewrite the pull request's history, so merge instead
+		published, err := rvw.git.CompareCommits(ctx, rvw.baseBranch, rvw.reviewBranch)
+		if err != nil {
+			return validator.ValidationResult{}, fmt.Errorf("failed to compare branches %s..%s: %w", rvw.baseBranch, rvw.reviewBranch, err)
+		}
+		rebase = published.GetAheadBy() == 0
+	}
+
+	var action string
+	if rebase {
+		_, err = rvw.git.Rebase(ctx, rvw.workBranch, rvw.baseBranch)
+		action = fmt.Sprintf("Rebased the work branch onto %d new commit(s) on '%s'", newCommits, rvw.baseBranch)
+	} else {
+		message := fmt.Sprintf("Merge branch '%s' into %s", rvw.baseBranch, rvw.workBranch)
+		_, err = rvw.git.MergeCommit(ctx, rvw.baseBranch, rvw.workBranch, message)
+		action = fmt.Sprintf("Merged %d new commit(s) from '%s' into the work branch", newCommits, rvw.baseBranch)
+	}
+
+	var conflictErr MergeConflictError
+	if errors.As(err, &conflictErr) {
+		details := fmt.Sprintf("The base branch '%s' has %d commit(s) that the work branch doesn't, and they conflict "+
+			"with the work branch", rvw.baseBranch, newCommits)
+		if len(conflictErr.Paths) > 0 {
+			details += fmt.Sprintf(" in these files: %s", strings.Join(conflictErr.Paths, ", "))
+		}
+		details += ". The work branch has not been updated, so your changes are based on an outdated version of the code"
+		return validator.ValidationResult{Succeeded: false, Details: details}, nil
+	} else if err != nil {
+		return validator.ValidationResult{}, fmt.Errorf("failed to sync work branch with '%s': %w", rvw.baseBranch, err)
+	}
+
+	return validator.ValidationResult{Succeeded: true, Details: action}, nil
+}
+
+// SetBaseBranch changes the branch that SyncWithBase syncs the work branch with, e.g. after the pull request was
+// retargeted. Commits already on the work branch are left as they are
+func (rvw *RemoteValidationWorkspace) SetBaseBranch(branch string) {
+	rvw.baseBranch = branch
+}
+
 // ClearLocalChanges deletes changes staged in-memory
 func (rvw *RemoteValidationWorkspace) ClearLocalChanges() {
 	rvw.fs.Reset()
 }
 
+// ValidateChanges commits any local changes to the work branch and validates the result. Local changes that only
+// affect trailing whitespace are discarded rather than committed. If there is nothing new to commit and the head of the work
+// branch has already been validated, the earlier result is returned without validating again
 func (rvw *RemoteValidationWorkspace) ValidateChanges(ctx context.Context, commitMessage *string) (validator.ValidationResult, error) {
-	var commitSHA string
-	if rvw.HasLocalChanges() {
-		if commitMessage == nil {
-			return validator.ValidationResult{}, fmt.Errorf("no commit message provided for validating local changes")
+	if rvw.readOnly {
+		if rvw.HasLocalChanges() {
+			return validator.ValidationResult{}, fmt.Errorf("cannot validate local changes: %w", errReadOnly)
 		}
-		commit, err := rvw.commitToWorkBranch(ctx, *commitMessage)
+		// Validation may trigger a workflow run, so don't. Report success so that there's nothing for the AI to fix
+		return validator.ValidationResult{Succeeded: true, Details: "Validation skipped in dry-run mode"}, nil
+	}
+
+	if rvw.HasLocalChanges() {
+		whitespaceOnly, err := rvw.fs.HasOnlyTrailingWhitespaceChanges(ctx)
 		if err != nil {
-			return validator.ValidationResult{}, fmt.Errorf("failed to commit changes to work branch: %w", err)
+			return validator.ValidationResult{}, fmt.Errorf("failed to inspect local changes: %w", err)
 		}
-		commitSHA = *commit.SHA
-	} else {
-		headCommit, err := rvw.git.GetBranchHead(ctx, rvw.workBranch)
-		if err != nil {
-			return validator.ValidationResult{}, fmt.Errorf("failed to get work branch info: %w", err)
+		if whitespaceOnly {
+			// Committing these would push a commit that changes nothing of substance and run CI for nothing
+			logging.FromContext(ctx).Info("Discarding trailing-whitespace-only local changes instead of validating them")
+			rvw.ClearLocalChanges()
 		}
-		commitSHA = *headCommit.SHA
 	}
+	noChanges := !rvw.HasLocalChanges()
 
+	commit, err := rvw.commitLocalChanges(ctx, commitMessage)
+	if err != nil {
+		return validator.ValidationResult{}, err
+	}
+
+	if noChanges && rvw.lastValidation != nil && rvw.lastValidation.commitSHA == commit.GetSHA() {
+		result := rvw.lastValidation.result
+		result.NoChanges = true
+		return result, nil
+	}
+
+	result, err := rvw.validateCommit(ctx, commit)
+	if err != nil {
+		return validator.ValidationResult{}, err
+	}
+	result.NoChanges = noChanges
+	return result, nil
+}
+
+// validateCommit validates the given commit on the work branch, using a cached result if there is one
+func (rvw *RemoteValidationWorkspace) validateCommit(ctx context.Context, commit *github.Commit) (validator.ValidationResult, error) {
 	if rvw.validator == nil {
 		return validator.ValidationResult{}, fmt.Errorf("failed to validate commit, no validator provided")
 	}
 
-	result, err := rvw.validator.ValidateBranch(ctx, rvw.workBranch, commitSHA)
+	cacheKey := validationCacheKey{scope: rvw.validationScope, treeSHA: commit.GetTree().GetSHA()}
+	if cacheKey.treeSHA != "" {
+		if result, ok := rvw.validationCache.get(cacheKey); ok {
+			logging.FromContext(ctx).Info("Using cached validation result", "tree_sha", cacheKey.treeSHA)
+			rvw.lastValidation = &commitValidation{commitSHA: commit.GetSHA(), result: result}
+			return result, nil
+		}
+	}
+
+	result, err := rvw.withValidationTimeout(ctx, func(ctx context.Context) (validator.ValidationResult, error) {
+		result, err := rvw.validator.ValidateBranch(ctx, rvw.workBranch, commit.GetSHA())
+		if err == nil {
+			// Only results of completed validation runs are remembered, not timeouts
+			rvw.lastValidation = &commitValidation{commitSHA: commit.GetSHA(), result: result}
+			// Failures aren't shared with other workspaces, in case they are flaky
+			if cacheKey.treeSHA != "" && result.Succeeded {
+				rvw.validationCache.put(cacheKey, result)
+			}
+		}
+		return result, err
+	})
 	if err != nil {
 		return validator.ValidationResult{}, fmt.Errorf("failed to validate commit: %w", err)
 	}
@@ -240,6 +544,93 @@ func (rvw *RemoteValidationWorkspace) ValidateChanges(ctx context.Context, commi
 	return result, nil
 }
 
+// Coverage returns the test coverage measured by the most recent validation of the work branch, which may be a cached
+// result. Returns an error wrapping validator.ErrCoverageUnavailable if nothing has been validated yet, or if the
+// validation didn't measure coverage
+func (rvw *RemoteValidationWorkspace) Coverage(ctx context.Context) (validator.Coverage, error) {
+	if rvw.lastValidation == nil {
+		return nil, fmt.Errorf("%w: the work branch hasn't been validated yet", validator.ErrCoverageUnavailable)
+	}
+	if rvw.lastValidation.result.Coverage == nil {
+		return nil, fmt.Errorf("%w: the validator didn't measure coverage", validator.ErrCoverageUnavailable)
+	}
+	return rvw.lastValidation.result.Coverage, nil
+}
+
+// RunTests commits any local changes to the work branch and runs the subset of tests identified by target against
+// them. Returns an error wrapping validator.ErrScopedTestsUnsupported if the validator can't run a subset of tests
+func (rvw *RemoteValidationWorkspace) RunTests(ctx context.Context, commitMessage *string, target string) (validator.ValidationResult, error) {
+	if rvw.readOnly {
+		if rvw.HasLocalChanges() {
+			return validator.ValidationResult{}, fmt.Errorf("cannot test local changes: %w", errReadOnly)
+		}
+		return validator.ValidationResult{Succeeded: true, Details: "Tests skipped in dry-run mode"}, nil
+	}
+
+	testRunner, ok := rvw.validator.(ScopedTestRunner)
+	if !ok {
+		return validator.ValidationResult{}, fmt.Errorf("cannot run tests '%s': %w", target, validator.ErrScopedTestsUnsupported)
+	}
+
+	commit, err := rvw.commitLocalChanges(ctx, commitMessage)
+	if err != nil {
+		return validator.ValidationResult{}, err
+	}
+
+	result, err := rvw.withValidationTimeout(ctx, func(ctx context.Context) (validator.ValidationResult, error) {
+		return testRunner.RunTests(ctx, rvw.workBranch, commit.GetSHA(), target)
+	})
+	if err != nil {
+		return validator.ValidationResult{}, fmt.Errorf("failed to run tests '%s': %w", target, err)
+	}
+
+	return result, nil
+}
+
+// commitLocalChanges commits local changes, if any, to the work branch with the given commit message, and returns the
+// head of the work branch
+func (rvw *RemoteValidationWorkspace) commitLocalChanges(ctx context.Context, commitMessage *string) (*github.Commit, error) {
+	if !rvw.HasLocalChanges() {
+		headCommit, err := rvw.git.GetBranchHead(ctx, rvw.workBranch)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get work branch info: %w", err)
+		}
+		return headCommit, nil
+	}
+
+	if commitMessage == nil {
+		return nil, fmt.Errorf("no commit message provided for validating local changes")
+	}
+	commit, err := rvw.commitToWorkBranch(ctx, *commitMessage)
+	if err != nil {
+		return nil, fmt.Errorf("failed to commit changes to work branch: %w", err)
+	}
+	return commit, nil
+}
+
+// withValidationTimeout calls validate, applying the workspace's validation timeout. A validation run that times out is
+// reported as a failure rather than an error, so that the AI can decide what to do
+func (rvw *RemoteValidationWorkspace) withValidationTimeout(
+	ctx context.Context,
+	validate func(ctx context.Context) (validator.ValidationResult, error),
+) (validator.ValidationResult, error) {
+	validateCtx := ctx
+	if rvw.validationTimeout > 0 {
+		var cancel context.CancelFunc
+		validateCtx, cancel = context.WithTimeout(ctx, rvw.validationTimeout)
+		defer cancel()
+	}
+
+	result, err := validate(validateCtx)
+	if err != nil && ctx.Err() == nil && errors.Is(validateCtx.Err(), context.DeadlineExceeded) {
+		return validator.ValidationResult{
+			Succeeded: false,
+			Details:   fmt.Sprintf("Validation timed out after %v without completing", rvw.validationTimeout),
+		}, nil
+	}
+	return result, err
+}
+
 func (rvw *RemoteValidationWorkspace) commitToWorkBranch(ctx context.Context, commitMessage string) (*github.Commit, error) {
 	if !rvw.fs.HasChanges() {
 		return nil, fmt.Errorf("no changes to commit")
@@ -257,40 +648,59 @@ func (rvw *RemoteValidationWorkspace) commitToWorkBranch(ctx context.Context, co
 }
 
 // PublishChangesForReview merges changes in the working branch into the review branch and creates a pull request, if
-// one doesn't already exist. Returns an error if there are in-memory changes that have not been committed to the work
-// branch via a ValidateChanges call
-func (rvw *RemoteValidationWorkspace) PublishChangesForReview(ctx context.Context, reviewRequestTitle string, reviewRequestBody string) error {
+// one doesn't already exist. The pull request is created as a draft if draft is true. Returns the created pull request,
+// or nil if the workspace already had one. Returns an error if there are in-memory changes that have not been committed
+// to the work branch via a ValidateChanges call
+func (rvw *RemoteValidationWorkspace) PublishChangesForReview(
+	ctx context.Context,
+	reviewRequestTitle string,
+	reviewRequestBody string,
+	draft bool,
+) (*task.GithubPullRequest, error) {
+	if rvw.readOnly {
+		return nil, fmt.Errorf("cannot publish changes: %w", errReadOnly)
+	}
+
 	_, err := rvw.mergeWorkBranchToReviewBranch(ctx)
 	if err != nil {
-		return fmt.Errorf("failed to merge work branch into review branch: %w", err)
+		return nil, fmt.Errorf("failed to merge work branch into review branch: %w", err)
 	}
 
-	if rvw.needsPullRequest {
-		err := rvw.createPullRequest(ctx, reviewRequestTitle, reviewRequestBody)
-		if err != nil {
-			return err
-		}
-		rvw.needsPullRequest = false
+	if !rvw.needsPullRequest {
+		return nil, nil
 	}
-
-	return err
+	pr, err := rvw.createPullRequest(ctx, reviewRequestTitle, reviewRequestBody, draft)
+	if err != nil {
+		return nil, err
+	}
+	rvw.needsPullRequest = false
+	return pr, nil
 }
 
-func (rvw *RemoteValidationWorkspace) createPullRequest(ctx context.Context, title string, body string) error {
-	// Add issue reference and disclaimer to PR body
-	body = fmt.Sprintf(`%s
-
-Fixes #%d
+// PullRequestFooter returns the footer that is appended to the body of each pull request the bot opens, which references
+// the issues that the pull request fixes. Each issue gets its own closing keyword, since GitHub only closes the issue
+// that directly follows one
+func PullRequestFooter(issueNumbers []int) string {
+	references := make([]string, len(issueNumbers))
+	for i, number := range issueNumbers {
+		references[i] = fmt.Sprintf("Fixes #%d", number)
+	}
+	return strings.Join(references, "\n") + `
 
 ---
-*This PR was created by the Blundering Savant bot.*`, body, rvw.issueNumber)
+*This PR was created by the Blundering Savant bot.*`
+}
 
-	err := rvw.prService.Create(ctx, title, body)
+func (rvw *RemoteValidationWorkspace) createPullRequest(ctx context.Context, title string, body string, draft bool) (*task.GithubPullRequest, error) {
+	// Add issue reference and disclaimer to PR body
+	body = body + "\n\n" + PullRequestFooter(rvw.issueNumbers)
+
+	pr, err := rvw.prService.Create(ctx, title, body, draft)
 	if err != nil {
-		return fmt.Errorf("failed to create pull request: %w", err)
+		return nil, fmt.Errorf("failed to create pull request: %w", err)
 	}
 
-	return nil
+	return pr, nil
 }
 
 func (rvw *RemoteValidationWorkspace) mergeWorkBranchToReviewBranch(ctx context.Context) (*github.Commit, error) {
@@ -306,22 +716,33 @@ func (rvw *RemoteValidationWorkspace) mergeWorkBranchToReviewBranch(ctx context.
 	return commit, nil
 }
 
-func getWorkBranchName(issue task.GithubIssue) string {
-	branchName := fmt.Sprintf("wip/issue-%d-%s", issue.Number, sanitizeForBranchName(issue.Title))
+// getWorkBranchName returns the name of the branch in which changes for the given issues are validated. Like the review
+// branch's name, it includes the numbers of all of the issues, separated by underscores
+func getWorkBranchName(issueNumbers []int, title string) string {
+	numbers := make([]string, len(issueNumbers))
+	for i, number := range issueNumbers {
+		numbers[i] = strconv.Itoa(number)
+	}
+	branchName := fmt.Sprintf("wip/issue-%s-%s", strings.Join(numbers, "_"), sanitizeForBranchName(title))
 	return normalizeBranchName(branchName)
 }
 
+var (
+	branchSeparatorsRegex = regexp.MustCompile(`[\s_]+`)
+	branchDisallowedRegex = regexp.MustCompile(`[^a-z0-9\-]`)
+	branchHyphenRunsRegex = regexp.MustCompile(`-{2,}`)
+)
+
+// sanitizeForBranchName converts s into a string that is safe to use as part of a git branch name. Whitespace and
+// underscores become hyphens, and anything other than ASCII letters, digits, and hyphens is dropped, including
+// non-ASCII letters, emoji, and control characters. Runs of hyphens are collapsed, and leading and trailing hyphens are
+// removed
 func sanitizeForBranchName(s string) string {
-	// Convert to lowercase
 	s = strings.ToLower(s)
-
-	// Replace spaces and underscores with hyphens first
-	s = strings.ReplaceAll(s, " ", "-")
-	s = strings.ReplaceAll(s, "_", "-")
-
-	// Keep only alphanumeric characters and hyphens using regex allowlist
-	allowedCharsRegex := regexp.MustCompile(`[^a-z0-9\-]`)
-	return allowedCharsRegex.ReplaceAllString(s, "")
+	s = branchSeparatorsRegex.ReplaceAllString(s, "-")
+	s = branchDisallowedRegex.ReplaceAllString(s, "")
+	s = branchHyphenRunsRegex.ReplaceAllString(s, "-")
+	return strings.Trim(s, "-")
 }
 
 func normalizeBranchName(s string) string {
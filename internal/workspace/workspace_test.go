@@ -1,7 +1,16 @@
 package workspace
 
 import (
+	"context"
+	"fmt"
+	"strings"
 	"testing"
+	"time"
+	"unicode"
+
+	"github.com/cchalm/blundering-savant/internal/task"
+	"github.com/cchalm/blundering-savant/internal/validator"
+	"github.com/google/go-github/v72/github"
 
 	"github.com/stretchr/testify/require"
 )
@@ -9,6 +18,27 @@ import (
 func testSanitizeForBranchName(t *testing.T, input string, expected string) {
 	result := sanitizeForBranchName(input)
 	require.Equal(t, expected, result)
+
+	branchName := getWorkBranchName([]int{1}, input)
+	for component := range strings.SplitSeq(branchName, "/") {
+		requireValidRefComponent(t, component)
+	}
+}
+
+// requireValidRefComponent checks a path component of a git ref name against the rules of git check-ref-format
+func requireValidRefComponent(t *testing.T, component string) {
+	require.NotEmpty(t, component)
+	require.False(t, strings.HasPrefix(component, "."), "component %q starts with a dot", component)
+	require.False(t, strings.HasPrefix(component, "-"), "component %q starts with a hyphen", component)
+	require.False(t, strings.HasSuffix(component, ".lock"), "component %q ends with .lock", component)
+	require.False(t, strings.HasSuffix(component, "."), "component %q ends with a dot", component)
+	require.NotContains(t, component, "..")
+	require.NotContains(t, component, "@{")
+	require.NotEqual(t, "@", component)
+	for _, r := range component {
+		require.False(t, unicode.IsControl(r) || r == ' ' || r > unicode.MaxASCII, "component %q contains %q", component, r)
+		require.NotContains(t, "~^:?*[\\", string(r), "component %q contains %q", component, r)
+	}
 }
 
 func TestSanitizeForBranchName_Basic(t *testing.T) {
@@ -36,7 +66,7 @@ func TestSanitizeForBranchName_MixedCase(t *testing.T) {
 }
 
 func TestSanitizeForBranchName_MultipleSpaces(t *testing.T) {
-	testSanitizeForBranchName(t, "multiple   spaces  here", "multiple---spaces--here")
+	testSanitizeForBranchName(t, "multiple   spaces  here", "multiple-spaces-here")
 }
 
 func TestSanitizeForBranchName_GitInvalidCharacters(t *testing.T) {
@@ -54,3 +84,496 @@ func TestSanitizeForBranchName_EmptyString(t *testing.T) {
 func TestSanitizeForBranchName_OnlyInvalidCharacters(t *testing.T) {
 	testSanitizeForBranchName(t, "~^:?*[]", "")
 }
+
+func TestSanitizeForBranchName_Emoji(t *testing.T) {
+	testSanitizeForBranchName(t, "🐛 Fix crash on startup 🚀", "fix-crash-on-startup")
+}
+
+func TestSanitizeForBranchName_CJK(t *testing.T) {
+	testSanitizeForBranchName(t, "修复 login bug 问题", "login-bug")
+}
+
+func TestSanitizeForBranchName_DoubleColons(t *testing.T) {
+	testSanitizeForBranchName(t, "Fix:: bug", "fix-bug")
+}
+
+func TestSanitizeForBranchName_LeadingAndTrailingPunctuation(t *testing.T) {
+	testSanitizeForBranchName(t, "--- [WIP] Fix bug! ...", "wip-fix-bug")
+}
+
+func TestSanitizeForBranchName_ControlCharacters(t *testing.T) {
+	testSanitizeForBranchName(t, "fix\x00bug\twith\ncontrol\x7fchars", "fixbug-with-controlchars")
+}
+
+func newReadOnlyTestWorkspace() *RemoteValidationWorkspace {
+	diffFS := NewMemDiffFileSystem(newFakeFS())
+	return &RemoteValidationWorkspace{fs: &diffFS, readOnly: true}
+}
+
+func TestReadOnlyWorkspace_ValidateWithoutChanges(t *testing.T) {
+	ws := newReadOnlyTestWorkspace()
+
+	result, err := ws.ValidateChanges(context.Background(), nil)
+	require.NoError(t, err)
+	require.True(t, result.Succeeded)
+
+	hasUnpublishedChanges, err := ws.HasUnpublishedChanges(context.Background())
+	require.NoError(t, err)
+	require.False(t, hasUnpublishedChanges)
+}
+
+func TestReadOnlyWorkspace_RejectsValidatingChanges(t *testing.T) {
+	ctx := context.Background()
+	ws := newReadOnlyTestWorkspace()
+	require.NoError(t, ws.Write(ctx, "file.txt", "content"))
+
+	_, err := ws.ValidateChanges(ctx, github.Ptr("Add file"))
+	require.ErrorIs(t, err, errReadOnly)
+}
+
+func TestReadOnlyWorkspace_RejectsPublishing(t *testing.T) {
+	_, err := newReadOnlyTestWorkspace().PublishChangesForReview(context.Background(), "title", "body", false)
+	require.ErrorIs(t, err, errReadOnly)
+}
+
+// gitRepoStub is a GitRepo whose branches all point at the same commit
+type gitRepoStub struct {
+	GitRepo
+	headSHA string
+}
+
+func (grs gitRepoStub) GetBranchHead(ctx context.Context, branch string) (*github.Commit, error) {
+	return &github.Commit{SHA: github.Ptr(grs.headSHA)}, nil
+}
+
+func (grs gitRepoStub) Merge(ctx context.Context, sourceBranch string, targetBranch string) (*github.Commit, error) {
+	return &github.Commit{SHA: github.Ptr(grs.headSHA)}, nil
+}
+
+// prServiceStub records the pull requests it is asked to create
+type prServiceStub struct {
+	created []prServiceStubCall
+}
+
+type prServiceStubCall struct {
+	title string
+	draft bool
+}
+
+func (pss *prServiceStub) Create(ctx context.Context, title string, body string, draft bool) (*task.GithubPullRequest, error) {
+	pss.created = append(pss.created, prServiceStubCall{title: title, draft: draft})
+	return &task.GithubPullRequest{Number: len(pss.created), Title: title}, nil
+}
+
+func testPublishChangesForReview(t *testing.T, draft bool) []prServiceStubCall {
+	diffFS := NewMemDiffFileSystem(newFakeFS())
+	prService := &prServiceStub{}
+	ws := &RemoteValidationWorkspace{
+		git:              gitRepoStub{headSHA: "abc123"},
+		fs:               &diffFS,
+		prService:        prService,
+		needsPullRequest: true,
+	}
+
+	ctx := context.Background()
+	pr, err := ws.PublishChangesForReview(ctx, "Fix the bug", "Fixes it", draft)
+	require.NoError(t, err)
+	require.Equal(t, 1, pr.Number)
+	// Later publishes update the existing pull request rather than creating another
+	pr, err = ws.PublishChangesForReview(ctx, "Fix the bug", "Fixes it", draft)
+	require.NoError(t, err)
+	require.Nil(t, pr)
+	return prService.created
+}
+
+func TestPublishChangesForReview_Draft(t *testing.T) {
+	created := testPublishChangesForReview(t, true)
+	require.Equal(t, []prServiceStubCall{{title: "Fix the bug", draft: true}}, created)
+}
+
+func TestPublishChangesForReview_NotDraft(t *testing.T) {
+	created := testPublishChangesForReview(t, false)
+	require.Equal(t, []prServiceStubCall{{title: "Fix the bug", draft: false}}, created)
+}
+
+func testPullRequestFooter(t *testing.T, issueNumbers []int, wantReferences string) {
+	footer := PullRequestFooter(issueNumbers)
+	require.Equal(t, wantReferences+"\n\n---\n*This PR was created by the Blundering Savant bot.*", footer)
+}
+
+func TestPullRequestFooter_SingleIssue(t *testing.T) {
+	testPullRequestFooter(t, []int{42}, "Fixes #42")
+}
+
+func TestPullRequestFooter_MultipleIssues(t *testing.T) {
+	testPullRequestFooter(t, []int{42, 7, 1003}, "Fixes #42\nFixes #7\nFixes #1003")
+}
+
+func TestGetWorkBranchName_MultipleIssues(t *testing.T) {
+	require.Equal(t, "wip/issue-42_7_1003-add-a-widget", getWorkBranchName([]int{42, 7, 1003}, "Add a widget"))
+}
+
+// hungValidatorStub is a BranchValidator whose workflow runs never complete
+type hungValidatorStub struct{}
+
+func (hvs hungValidatorStub) ValidateBranch(ctx context.Context, branch string, commitSHA string) (validator.ValidationResult, error) {
+	<-ctx.Done()
+	return validator.ValidationResult{}, fmt.Errorf("workflow completion check canceled: %w", ctx.Err())
+}
+
+func newHungValidationTestWorkspace(timeout time.Duration) *RemoteValidationWorkspace {
+	diffFS := NewMemDiffFileSystem(newFakeFS())
+	return &RemoteValidationWorkspace{
+		git:               gitRepoStub{headSHA: "abc123"},
+		fs:                &diffFS,
+		workBranch:        "bot/issue-1-work",
+		validator:         hungValidatorStub{},
+		validationTimeout: timeout,
+	}
+}
+
+func TestValidateChanges_TimesOut(t *testing.T) {
+	ws := newHungValidationTestWorkspace(10 * time.Millisecond)
+
+	result, err := ws.ValidateChanges(context.Background(), nil)
+	require.NoError(t, err)
+	require.False(t, result.Succeeded)
+	require.Contains(t, result.Details, "Validation timed out")
+}
+
+func TestValidateChanges_Canceled(t *testing.T) {
+	ws := newHungValidationTestWorkspace(time.Hour)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	_, err := ws.ValidateChanges(ctx, nil)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+// scopedTestRunnerStub is a BranchValidator that can run a subset of the tests, and records the tests it is asked to run
+type scopedTestRunnerStub struct {
+	hungValidatorStub
+
+	runs []scopedTestRun
+}
+
+type scopedTestRun struct {
+	branch    string
+	commitSHA string
+	target    string
+}
+
+func (strs *scopedTestRunnerStub) RunTests(ctx context.Context, branch string, commitSHA string, target string) (validator.ValidationResult, error) {
+	strs.runs = append(strs.runs, scopedTestRun{branch: branch, commitSHA: commitSHA, target: target})
+	return validator.ValidationResult{Succeeded: true, Details: "ok " + target}, nil
+}
+
+func TestRunTests_Scoped(t *testing.T) {
+	testRunner := &scopedTestRunnerStub{}
+	ws := newHungValidationTestWorkspace(time.Hour)
+	ws.validator = testRunner
+
+	result, err := ws.RunTests(context.Background(), nil, "./internal/bot")
+	require.NoError(t, err)
+	require.Equal(t, validator.ValidationResult{Succeeded: true, Details: "ok ./internal/bot"}, result)
+	require.Equal(t, []scopedTestRun{{branch: "bot/issue-1-work", commitSHA: "abc123", target: "./internal/bot"}}, testRunner.runs)
+}
+
+func TestRunTests_Unsupported(t *testing.T) {
+	ws := newHungValidationTestWorkspace(time.Hour)
+
+	_, err := ws.RunTests(context.Background(), nil, "./internal/bot")
+	require.ErrorIs(t, err, validator.ErrScopedTestsUnsupported)
+}
+
+// treeGitRepoStub is a GitRepo whose work branch head has the given tree, which each commit replaces with a new one
+type treeGitRepoStub struct {
+	GitRepo
+
+	treeSHA string
+	commits int
+}
+
+func (tgrs *treeGitRepoStub) GetBranchHead(ctx context.Context, branch string) (*github.Commit, error) {
+	return &github.Commit{SHA: github.Ptr("commit-" + tgrs.treeSHA), Tree: &github.Tree{SHA: github.Ptr(tgrs.treeSHA)}}, nil
+}
+
+func (tgrs *treeGitRepoStub) CommitChanges(ctx context.Context, branch string, changelist Changelist, commitMessage string) (*github.Commit, error) {
+	tgrs.commits++
+	tgrs.treeSHA = fmt.Sprintf("tree-%d", tgrs.commits)
+	return tgrs.GetBranchHead(ctx, branch)
+}
+
+// countingValidatorStub is a BranchValidator that records the commits it validates
+type countingValidatorStub struct {
+	validated []string
+	failing   bool               // If true, validation fails
+	coverage  validator.Coverage // Reported as measured by each validation
+}
+
+func (cvs *countingValidatorStub) ValidateBranch(ctx context.Context, branch string, commitSHA string) (validator.ValidationResult, error) {
+	cvs.validated = append(cvs.validated, commitSHA)
+	return validator.ValidationResult{Succeeded: !cvs.failing, Details: "validated " + commitSHA, Coverage: cvs.coverage}, nil
+}
+
+func newCachingTestWorkspace(cache *ValidationCache) (*RemoteValidationWorkspace, *countingValidatorStub) {
+	diffFS := NewMemDiffFileSystem(newFakeFS())
+	v := &countingValidatorStub{}
+	return &RemoteValidationWorkspace{
+		git:             &treeGitRepoStub{treeSHA: "tree-0"},
+		fs:              &diffFS,
+		workBranch:      "bot/issue-1-work",
+		validator:       v,
+		validationCache: cache,
+		validationScope: "owner/repo:validate.yml",
+	}, v
+}
+
+func TestValidateChanges_CachedForUnchangedTree(t *testing.T) {
+	cache := NewValidationCache()
+	ws, v := newCachingTestWorkspace(cache)
+
+	first, err := ws.ValidateChanges(context.Background(), nil)
+	require.NoError(t, err)
+	second, err := ws.ValidateChanges(context.Background(), nil)
+	require.NoError(t, err)
+	require.Equal(t, first, second)
+	require.Equal(t, []string{"commit-tree-0"}, v.validated)
+
+	// The cache is shared with other workspaces for the same repository and workflow
+	other, otherValidator := newCachingTestWorkspace(cache)
+	_, err = other.ValidateChanges(context.Background(), nil)
+	require.NoError(t, err)
+	require.Empty(t, otherValidator.validated)
+}
+
+func TestCoverage_FromCachedValidation(t *testing.T) {
+	cache := NewValidationCache()
+	ws, v := newCachingTestWorkspace(cache)
+	v.coverage = validator.Coverage{"a.go": {Covered: []validator.LineRange{{Start: 1, End: 3}}}}
+
+	_, err := ws.Coverage(context.Background())
+	require.ErrorIs(t, err, validator.ErrCoverageUnavailable, "nothing has been validated yet")
+	_, err = ws.ValidateChanges(context.Background(), nil)
+	require.NoError(t, err)
+
+	// E.g. a resumed task, whose workspace reuses the earlier result rather than validating again
+	other, otherValidator := newCachingTestWorkspace(cache)
+	_, err = other.ValidateChanges(context.Background(), nil)
+	require.NoError(t, err)
+	require.Empty(t, otherValidator.validated)
+
+	coverage, err := other.Coverage(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, v.coverage, coverage)
+}
+
+func TestValidateChanges_FailureNotCached(t *testing.T) {
+	cache := NewValidationCache()
+	ws, v := newCachingTestWorkspace(cache)
+	v.failing = true
+
+	result, err := ws.ValidateChanges(context.Background(), nil)
+	require.NoError(t, err)
+	require.False(t, result.Succeeded)
+
+	// The failure may have been flaky, so other workspaces validate the same tree again
+	other, otherValidator := newCachingTestWorkspace(cache)
+	result, err = other.ValidateChanges(context.Background(), nil)
+	require.NoError(t, err)
+	require.True(t, result.Succeeded)
+	require.Equal(t, []string{"commit-tree-0"}, otherValidator.validated)
+}
+
+func TestValidateChanges_ChangeBustsCache(t *testing.T) {
+	ws, v := newCachingTestWorkspace(NewValidationCache())
+
+	_, err := ws.ValidateChanges(context.Background(), nil)
+	require.NoError(t, err)
+
+	require.NoError(t, ws.Write(context.Background(), "main.go", "package main"))
+	result, err := ws.ValidateChanges(context.Background(), github.Ptr("Add main"))
+	require.NoError(t, err)
+	require.Equal(t, "validated commit-tree-1", result.Details)
+	require.Equal(t, []string{"commit-tree-0", "commit-tree-1"}, v.validated)
+}
+
+// testValidateChangesWithoutCommit validates the workspace, writes the given content over a file, and validates again,
+// expecting the write not to be committed or validated
+func testValidateChangesWithoutCommit(t *testing.T, content string) {
+	ctx := context.Background()
+	base := newFakeFS()
+	base.files["main.go"] = "package main\n\nfunc main() {}\n"
+	diffFS := NewMemDiffFileSystem(base)
+	gitRepo := &treeGitRepoStub{treeSHA: "tree-0"}
+	v := &countingValidatorStub{}
+	ws := &RemoteValidationWorkspace{git: gitRepo, fs: &diffFS, workBranch: "bot/issue-1-work", validator: v}
+
+	first, err := ws.ValidateChanges(ctx, nil)
+	require.NoError(t, err)
+
+	require.NoError(t, ws.Write(ctx, "main.go", content))
+	second, err := ws.ValidateChanges(ctx, github.Ptr("Change nothing"))
+	require.NoError(t, err)
+	require.True(t, second.NoChanges)
+	require.Equal(t, first.Details, second.Details)
+	require.False(t, ws.HasLocalChanges())
+	require.Equal(t, 0, gitRepo.commits)
+	require.Equal(t, []string{"commit-tree-0"}, v.validated)
+}
+
+func TestValidateChanges_RevertedChange(t *testing.T) {
+	testValidateChangesWithoutCommit(t, "package main\n\nfunc main() {}\n")
+}
+
+func TestValidateChanges_TrailingWhitespaceOnlyChange(t *testing.T) {
+	testValidateChangesWithoutCommit(t, "package main \n\t\nfunc main() {}  \n\n")
+}
+
+func TestValidateChanges_IndentationChangeIsCommitted(t *testing.T) {
+	ctx := context.Background()
+	for name, content := range map[string]string{
+		"indentation":    "package main\n\n\tfunc main() {}\n",
+		"inner spaces":   "package main\n\nfunc main()  {}\n",
+		"new blank line": "package main\n\n\nfunc main() {}\n",
+	} {
+		t.Run(name, func(t *testing.T) {
+			base := newFakeFS()
+			base.files["main.go"] = "package main\n\nfunc main() {}\n"
+			diffFS := NewMemDiffFileSystem(base)
+			gitRepo := &treeGitRepoStub{treeSHA: "tree-0"}
+			ws := &RemoteValidationWorkspace{git: gitRepo, fs: &diffFS, workBranch: "bot/issue-1-work", validator: &countingValidatorStub{}}
+
+			require.NoError(t, ws.Write(ctx, "main.go", content))
+			result, err := ws.ValidateChanges(ctx, github.Ptr("Reformat"))
+			require.NoError(t, err)
+			require.False(t, result.NoChanges)
+			require.Equal(t, 1, gitRepo.commits)
+		})
+	}
+}
+
+func TestValidateChanges_NewWhitespaceFileIsCommitted(t *testing.T) {
+	ctx := context.Background()
+	ws, v := newCachingTestWorkspace(nil)
+
+	require.NoError(t, ws.Write(ctx, ".gitkeep", ""))
+	result, err := ws.ValidateChanges(ctx, github.Ptr("Add .gitkeep"))
+	require.NoError(t, err)
+	require.False(t, result.NoChanges)
+	require.Equal(t, []string{"commit-tree-1"}, v.validated)
+}
+
+// syncGitRepoStub is a GitRepo whose base branch is a given number of commits ahead of the work branch, and which
+// records the merges and rebases it is asked to do
+type syncGitRepoStub struct {
+	GitRepo
+
+	baseAheadBy int
+	published   bool
+	conflict    *MergeConflictError
+
+	merged  []string
+	rebased []string
+}
+
+func (sgrs *syncGitRepoStub) CompareCommits(ctx context.Context, base string, head string) (*github.CommitsComparison, error) {
+	switch head {
+	case "main":
+		return &github.CommitsComparison{AheadBy: github.Ptr(sgrs.baseAheadBy)}, nil
+	case "review":
+		aheadBy := 0
+		if sgrs.published {
+			aheadBy = 1
+		}
+		return &github.CommitsComparison{AheadBy: github.Ptr(aheadBy)}, nil
+	default:
+		return nil, fmt.Errorf("unexpected comparison %s..%s", base, head)
+	}
+}
+
+func (sgrs *syncGitRepoStub) MergeCommit(ctx context.Context, sourceBranch string, targetBranch string, commitMessage string) (*github.Commit, error) {
+	if sgrs.conflict != nil {
+		return nil, *sgrs.conflict
+	}
+	sgrs.merged = append(sgrs.merged, sourceBranch+"->"+targetBranch)
+	return &github.Commit{SHA: github.Ptr("merged")}, nil
+}
+
+func (sgrs *syncGitRepoStub) Rebase(ctx context.Context, branch string, onto string) (*github.Commit, error) {
+	if sgrs.conflict != nil {
+		return nil, *sgrs.conflict
+	}
+	sgrs.rebased = append(sgrs.rebased, branch+"->"+onto)
+	return &github.Commit{SHA: github.Ptr("rebased")}, nil
+}
+
+func testSyncWithBase(t *testing.T, strategy SyncStrategy, git *syncGitRepoStub) validator.ValidationResult {
+	diffFS := NewMemDiffFileSystem(newFakeFS())
+	ws := &RemoteValidationWorkspace{
+		git:          git,
+		fs:           &diffFS,
+		baseBranch:   "main",
+		workBranch:   "work",
+		reviewBranch: "review",
+		syncStrategy: strategy,
+	}
+
+	result, err := ws.SyncWithBase(context.Background())
+	require.NoError(t, err)
+	return result
+}
+
+func TestSyncWithBase_NoDivergence(t *testing.T) {
+	git := &syncGitRepoStub{baseAheadBy: 0}
+	result := testSyncWithBase(t, SyncMerge, git)
+
+	require.True(t, result.Succeeded)
+	require.Empty(t, result.Details)
+	require.Empty(t, git.merged)
+}
+
+func TestSyncWithBase_CleanMerge(t *testing.T) {
+	git := &syncGitRepoStub{baseAheadBy: 2}
+	result := testSyncWithBase(t, SyncMerge, git)
+
+	require.True(t, result.Succeeded)
+	require.Contains(t, result.Details, "Merged 2 new commit(s) from 'main'")
+	require.Equal(t, []string{"main->work"}, git.merged)
+}
+
+func TestSyncWithBase_Conflict(t *testing.T) {
+	git := &syncGitRepoStub{baseAheadBy: 2, conflict: &MergeConflictError{Paths: []string{"a.go", "b.go"}}}
+	result := testSyncWithBase(t, SyncMerge, git)
+
+	require.False(t, result.Succeeded)
+	require.Contains(t, result.Details, "a.go, b.go")
+}
+
+func TestSyncWithBase_Rebase(t *testing.T) {
+	git := &syncGitRepoStub{baseAheadBy: 2}
+	result := testSyncWithBase(t, SyncRebase, git)
+
+	require.True(t, result.Succeeded)
+	require.Equal(t, []string{"work->main"}, git.rebased)
+	require.Empty(t, git.merged)
+}
+
+func TestSyncWithBase_RebasePublishedMerges(t *testing.T) {
+	// Rebasing would rewrite the history of the open pull request
+	git := &syncGitRepoStub{baseAheadBy: 2, published: true}
+	result := testSyncWithBase(t, SyncRebase, git)
+
+	require.True(t, result.Succeeded)
+	require.Empty(t, git.rebased)
+	require.Equal(t, []string{"main->work"}, git.merged)
+}
+
+func TestSyncWithBase_None(t *testing.T) {
+	git := &syncGitRepoStub{baseAheadBy: 2}
+	result := testSyncWithBase(t, SyncNone, git)
+
+	require.True(t, result.Succeeded)
+	require.Empty(t, git.merged)
+	require.Empty(t, git.rebased)
+}